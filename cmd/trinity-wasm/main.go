@@ -0,0 +1,76 @@
+//go:build js && wasm
+
+// Command trinity-wasm exposes the demo and asset parsers to JavaScript, so a
+// browser can read a demo before uploading it. Build with
+//
+//	GOOS=js GOARCH=wasm go build -o trinity-tools.wasm ./cmd/trinity-wasm
+//
+// and load it with Go's wasm_exec.js. It registers a global trinityTools
+// object whose functions take file contents and return a Promise of the
+// parsed result:
+//
+//	trinityTools.parseDemo(bytes)         → DemoInfo
+//	trinityTools.parseBSP(bytes)          → BSPAssets
+//	trinityTools.parseShaderScript(text)  → [ShaderDef]
+//
+// bytes is a Uint8Array. The Promise rejects with an Error if parsing fails.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"syscall/js"
+
+	"github.com/ernie/trinity-tools/internal/assets"
+)
+
+func main() {
+	api := js.Global().Get("Object").New()
+	api.Set("parseDemo", jsFunc(func(arg js.Value) (any, error) {
+		return assets.ParseDemoBytes(jsBytes(arg))
+	}))
+	api.Set("parseBSP", jsFunc(func(arg js.Value) (any, error) {
+		data := jsBytes(arg)
+		return assets.ParseBSP(bytes.NewReader(data), int64(len(data)))
+	}))
+	api.Set("parseShaderScript", jsFunc(func(arg js.Value) (any, error) {
+		return assets.ParseShaderScript(strings.NewReader(arg.String()))
+	}))
+	js.Global().Set("trinityTools", api)
+
+	select {} // keep the functions callable
+}
+
+// jsFunc wraps a parser as a JavaScript function of one argument returning
+// a Promise. Results cross as JSON, so they arrive as plain objects.
+func jsFunc(parse func(arg js.Value) (any, error)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) any {
+		executor := js.FuncOf(func(this js.Value, callbacks []js.Value) any {
+			resolve, reject := callbacks[0], callbacks[1]
+			if len(args) != 1 {
+				reject.Invoke(js.Global().Get("Error").New("expected one argument"))
+				return nil
+			}
+			result, err := parse(args[0])
+			if err == nil {
+				var data []byte
+				if data, err = json.Marshal(result); err == nil {
+					resolve.Invoke(js.Global().Get("JSON").Call("parse", string(data)))
+					return nil
+				}
+			}
+			reject.Invoke(js.Global().Get("Error").New(err.Error()))
+			return nil
+		})
+		defer executor.Release()
+		return js.Global().Get("Promise").New(executor)
+	})
+}
+
+// jsBytes copies a Uint8Array into Go memory.
+func jsBytes(v js.Value) []byte {
+	data := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(data, v)
+	return data
+}
@@ -5,6 +5,7 @@ import (
 	"archive/zip"
 	"bufio"
 	"context"
+	"crypto/ed25519"
 	"embed"
 	"encoding/json"
 	"fmt"
@@ -31,6 +32,7 @@ import (
 	"github.com/ernie/trinity-tools/internal/auth"
 	"github.com/ernie/trinity-tools/internal/collector"
 	"github.com/ernie/trinity-tools/internal/config"
+	"github.com/ernie/trinity-tools/internal/metrics"
 	"github.com/ernie/trinity-tools/internal/storage"
 	"github.com/ftrvxmtrx/tga"
 	flag "github.com/spf13/pflag"
@@ -56,6 +58,8 @@ func main() {
 		cmdInit(os.Args[2:])
 	case "serve":
 		cmdServe(os.Args[2:])
+	case "serve-assets":
+		cmdServeAssets(os.Args[2:])
 	case "server":
 		cmdServer(os.Args[2:])
 	case "status":
@@ -80,6 +84,36 @@ func main() {
 		cmdAssets(os.Args[2:])
 	case "demobake":
 		cmdDemobake(os.Args[2:])
+	case "watch":
+		cmdWatch(os.Args[2:])
+	case "sync":
+		cmdSync(os.Args[2:])
+	case "repack":
+		cmdRepack(os.Args[2:])
+	case "verifymap":
+		cmdVerifyMap(os.Args[2:])
+	case "demotrailer":
+		loadConfiguredDemoDictionary()
+		cmdDemoTrailer(os.Args[2:])
+	case "demosidecar":
+		loadConfiguredDemoDictionary()
+		cmdDemoSidecar(os.Args[2:])
+	case "redactdemo":
+		loadConfiguredDemoDictionary()
+		cmdRedactDemo(os.Args[2:])
+	case "baseline":
+		runGroup("baseline", os.Args[2:], baselineCommands)
+	case "mappak":
+		runGroup("mappak", os.Args[2:], mapPakCommands)
+	case "demo":
+		loadConfiguredDemoDictionary()
+		runGroup("demo", os.Args[2:], demoCommands)
+	case "manifest":
+		runGroup("manifest", os.Args[2:], manifestCommands)
+	case "pk3":
+		runGroup("pk3", os.Args[2:], pk3Commands)
+	case "vfs":
+		runGroup("vfs", os.Args[2:], vfsCommands)
 	case "version":
 		fmt.Printf("trinity %s\n", version)
 	case "help", "-h", "--help":
@@ -97,6 +131,7 @@ func printUsage() {
 	fmt.Println("Commands:")
 	fmt.Println("  init [--no-systemd] [--user quake]  Bootstrap system (create user, dirs, config)")
 	fmt.Println("  serve                               Start the stats server")
+	fmt.Println("  serve-assets [--listen addr]        Start the demo/asset service (upload, resolve, build on demand)")
 	fmt.Println("  server list                         Show configured game servers")
 	fmt.Println("  server add <name> [--port N] [flags]")
 	fmt.Println("                                      Add a game server instance")
@@ -117,9 +152,30 @@ func printUsage() {
 	fmt.Println("  skills [path]                       Extract skill icons from pk3 file(s)")
 	fmt.Println("  assets [path]                       Extract all assets (portraits, medals, skills, levelshots)")
 	fmt.Println("  demobake [path]                     Build baseline pk3, map pk3s, and manifest for web demo playback")
+	fmt.Println("  watch [path]                        Keep demobake output and demo sidecars up to date as files appear")
+	fmt.Println("  sync [--prune] <manifest>           Download/build missing demo pk3s listed in a manifest path or URL")
+	fmt.Println("  repack [flags] <in.pk3> <out.pk3>   Rewrite a pk3 with normalized paths and junk removed")
+	fmt.Println("  verifymap <map.pk3> <baseline.pk3>...")
+	fmt.Println("                                      Report map references that would fail to resolve at runtime")
+	fmt.Println("  demotrailer [--recorded-by N] <demo.tvd>...")
+	fmt.Println("                                      Rebuild the frame index/metadata trailer of demo files")
+	fmt.Println("  demosidecar [--manifest F] <demo.tvd>...")
+	fmt.Println("                                      Write a .json summary next to each demo for web listings")
+	fmt.Println("  redactdemo <in.tvd> <out.tvd>       Write a copy of a demo with names, hostnames, and IPs removed")
 	fmt.Println("  version                             Show version")
 	fmt.Println("  help                                Show this help")
 	fmt.Println()
+	fmt.Println("Asset and demo commands are also grouped; run a group for its subcommands:")
+	fmt.Println("  baseline build                      Same as demobake")
+	fmt.Println("  mappak build|verify                 Build a single map pk3, or verify one")
+	fmt.Println("  demo info|trailer|sidecar|redact|vms|rename|recompress|train-dict|pack|archive")
+	fmt.Println("                                      Inspect and rewrite demos")
+	fmt.Println("  manifest inspect                    Summarize a demobake manifest")
+	fmt.Println("  pk3 ls|repack|extract|pack          List, repack, unpack, or build pk3s")
+	fmt.Println("  vfs ls|cat|extract                  Browse a game's merged filesystem and which pk3 supplies each file")
+	fmt.Println("Asset commands read assets.output_dir, assets.demo_dir, assets.policy, and")
+	fmt.Println("assets.substitute from the config file as defaults.")
+	fmt.Println()
 	fmt.Println("Global Options:")
 	fmt.Println("  --config <path>    Path to configuration file (default /etc/trinity/config.yml)")
 	fmt.Println("  --url <url>        Base URL of the trinity server (default: derived from config)")
@@ -137,6 +193,7 @@ func printUsage() {
 func cmdServe(args []string) {
 	fs := flag.NewFlagSet("serve", flag.ExitOnError)
 	configPath := fs.String("config", "", "path to config file")
+	metricsAddr := fs.String("metrics", "", "address to serve Prometheus metrics on at /metrics, apart from the public web listener (e.g. 127.0.0.1:9108; default: off)")
 	fs.Parse(args)
 
 	// Determine config path
@@ -186,14 +243,29 @@ func cmdServe(args []string) {
 
 	// Create HTTP router
 	router := api.NewRouter(store, manager, authService, cfg.Server.StaticDir, cfg.Server.Quake3Dir)
+	if cfg.Server.RedistributableOnly {
+		router.SetRedistributableOnly(true)
+		log.Printf("Distribution mode: pk3s with official id content will not be served")
+	}
+	if cfg.Assets.VerifyKey != "" {
+		pub, err := assets.ParsePublicKey(cfg.Assets.VerifyKey)
+		if err != nil {
+			log.Fatalf("Invalid assets.verify_key: %v", err)
+		}
+		router.SetManifestKey(pub)
+		log.Printf("Demo pk3s will only be served if they match the signed manifest")
+	}
 	router.StartWebSocketHub()
 	log.Printf("Serving static files from %s", cfg.Server.StaticDir)
 
 	// Start HTTP server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.ListenAddr, cfg.Server.HTTPPort)
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
 	server := &http.Server{
 		Addr:         addr,
-		Handler:      router,
+		Handler:      metrics.CountBytes(bytesServed.With("web"), router),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -237,6 +309,139 @@ func cmdServe(args []string) {
 	log.Println("Shutdown complete")
 }
 
+// bytesServed counts response bytes by server (web or assets)
+var bytesServed = metrics.NewCounterVec("trinity_http_response_bytes_total",
+	"Bytes of HTTP response bodies served, by server (web or assets).", "server")
+
+// serveMetrics serves /metrics on addr in the background, on a listener of
+// its own so metrics aren't exposed wherever the command's own server is
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	go func() {
+		log.Printf("Serving metrics on http://%s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Warning: metrics server: %v", err)
+		}
+	}()
+}
+
+// cmdServeAssets runs the asset service as a long-running backend
+func cmdServeAssets(args []string) {
+	fs := flag.NewFlagSet("serve-assets", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	listen := fs.String("listen", "127.0.0.1:8081", "address to listen on")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	demoDir := fs.String("demos", "", "directory for uploaded demos, which must not be publicly served (default: uploads/ beside the database)")
+	quake3Dir := fs.String("quake3-dir", "", "Quake 3 install to build map pk3s from (default: from config)")
+	token := fs.String("token", os.Getenv("TRINITY_ASSET_TOKEN"), "bearer token required on requests (default: $TRINITY_ASSET_TOKEN)")
+	intakeDir := fs.String("intake", "", "accept demos game servers send to /intake, storing them in this directory, which must not be publicly served (requires --token)")
+	intakeTemplate := fs.String("intake-template", "", "name template for received demos (default: assets.intake_template or "+assets.DefaultIntakeTemplate+")")
+	fs.Parse(args)
+
+	cfg := loadCLIConfigFromFlags(*configPath, "")
+	outputDir := resolveAssetOutputDir(cfg, *output)
+	if *quake3Dir == "" && cfg != nil {
+		*quake3Dir = cfg.Server.Quake3Dir
+	}
+	if *demoDir == "" {
+		dbPath := "/var/lib/trinity/trinity.db"
+		if cfg != nil {
+			dbPath = cfg.Database.Path
+		}
+		*demoDir = filepath.Join(filepath.Dir(dbPath), "uploads")
+	}
+	public := []string{outputDir}
+	if cfg != nil && cfg.Server.StaticDir != "" {
+		public = append(public, cfg.Server.StaticDir)
+	}
+	for _, dir := range public {
+		if isWithinDir(*demoDir, dir) {
+			fmt.Fprintf(os.Stderr, "Error: upload directory %s is inside %s, which is served publicly\n", *demoDir, dir)
+			os.Exit(1)
+		}
+	}
+	if *token == "" {
+		log.Printf("Warning: no --token set; the asset service accepts unauthenticated requests")
+	}
+
+	service := api.NewAssetService(outputDir, *demoDir, *quake3Dir, *token)
+	if cfg != nil {
+		mapPakOpts, err := assetMapPakOptions(cfg, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		service.SetMapPakOptions(mapPakOpts)
+		if *intakeTemplate == "" {
+			*intakeTemplate = cfg.Assets.IntakeTemplate
+		}
+	}
+	if *intakeDir != "" {
+		for _, dir := range public {
+			if isWithinDir(*intakeDir, dir) {
+				fmt.Fprintf(os.Stderr, "Error: intake directory %s is inside %s, which is served publicly\n", *intakeDir, dir)
+				os.Exit(1)
+			}
+		}
+		if err := service.EnableIntake(*intakeDir, *intakeTemplate); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("Demo intake enabled (storing in %s)", *intakeDir)
+	}
+	stop := make(chan struct{})
+	go service.Run(stop)
+
+	server := &http.Server{
+		Addr:        *listen,
+		Handler:     metrics.CountBytes(bytesServed.With("assets"), service),
+		ReadTimeout: 5 * time.Minute, // demo uploads
+		IdleTimeout: 60 * time.Second,
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Asset service listening on %s (output %s)", *listen, outputDir)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	select {
+	case sig := <-sigCh:
+		log.Printf("Received signal %v, shutting down...", sig)
+	case err := <-serverErr:
+		log.Fatalf("HTTP server error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server shutdown error: %v", err)
+	}
+	close(stop)
+	log.Println("Shutdown complete")
+}
+
+// isWithinDir reports whether path is dir or lies under it
+func isWithinDir(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 // CLI helper variables
 var (
 	baseURL = "http://localhost:8080"
@@ -258,6 +463,7 @@ func loadCLIConfigFromFlags(configPath, url string) *config.Config {
 	}
 
 	dbPath = cfg.Database.Path
+	loadDemoDictionary(cfg.Assets.DemoDictionary)
 	// Derive URL from config, but allow --url flag to override
 	if url != "" {
 		baseURL = url
@@ -267,6 +473,109 @@ func loadCLIConfigFromFlags(configPath, url string) *config.Config {
 	return cfg
 }
 
+// resolveAssetOutputDir returns the --output flag value, or the configured
+// demobake output directory. Exits if neither is set.
+func resolveAssetOutputDir(cfg *config.Config, flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if cfg != nil {
+		if dir := cfg.AssetOutputDir(); dir != "" {
+			return dir
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Error: neither assets.output_dir nor static_dir configured, and --output not specified\n")
+	os.Exit(1)
+	return ""
+}
+
+// assetBuildProfile loads the build profile named by a flag, falling back to
+// the config's assets section. It returns nil if neither names one.
+func assetBuildProfile(cfg *config.Config, name string) (*assets.BuildProfile, error) {
+	var custom map[string]*assets.BuildProfile
+	if cfg != nil {
+		if name == "" {
+			name = cfg.Assets.Profile
+		}
+		if cfg.Assets.Profiles != "" {
+			var err error
+			if custom, err = assets.LoadBuildProfiles(cfg.Assets.Profiles); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return assets.LookupBuildProfile(name, custom)
+}
+
+// assetMapPakOptions returns the map pk3 options of the build profile named
+// by a flag or the config, with the config's other map pk3 settings
+func assetMapPakOptions(cfg *config.Config, profileName string) (assets.MapPakOptions, error) {
+	var opts assets.MapPakOptions
+	profile, err := assetBuildProfile(cfg, profileName)
+	if err != nil {
+		return opts, err
+	}
+	if profile != nil {
+		profile.ApplyMapPak(&opts)
+	}
+	if cfg != nil && cfg.Assets.Placeholders {
+		opts.Placeholders = true
+	}
+	return opts, nil
+}
+
+// assetBuildOptions loads the build profile, baseline policy, and
+// substitution table named by flags, falling back to the config's assets
+// section
+func assetBuildOptions(cfg *config.Config, profileName, policyPath, substitutePath string) (assets.BuildOptions, error) {
+	var opts assets.BuildOptions
+	profile, err := assetBuildProfile(cfg, profileName)
+	if err != nil {
+		return opts, err
+	}
+	if profile != nil {
+		profile.Apply(&opts)
+	}
+	if cfg != nil {
+		opts.LooseFiles = cfg.Assets.LooseFiles
+		opts.Roots = cfg.Assets.Roots
+		opts.Atomic = cfg.Assets.AtomicBuilds
+		if cfg.Assets.Placeholders {
+			opts.MapPak.Placeholders = true
+		}
+		opts.GameBases = cfg.Assets.GameBases
+		if policyPath == "" {
+			policyPath = cfg.Assets.Policy
+		}
+		if substitutePath == "" {
+			substitutePath = cfg.Assets.Substitute
+		}
+	}
+	if policyPath != "" {
+		policy, err := assets.LoadBaselinePolicy(policyPath)
+		if err != nil {
+			return opts, err
+		}
+		opts.Policy = policy
+	}
+	if substitutePath != "" {
+		subst, err := assets.LoadSubstitution(substitutePath)
+		if err != nil {
+			return opts, err
+		}
+		opts.Substitute = subst
+	}
+	if cfg != nil && cfg.Assets.TextureOverrides != "" {
+		if opts.TextureOverrides, err = assets.LoadTextureOverrides(cfg.Assets.TextureOverrides); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
 func loadCLIConfig(args []string) (*config.Config, []string) {
 	fs := flag.NewFlagSet("cli", flag.ContinueOnError)
 	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
@@ -1238,8 +1547,30 @@ func cmdAssets(args []string) {
 func cmdDemobake(args []string) {
 	fs := flag.NewFlagSet("demobake", flag.ExitOnError)
 	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
-	output := fs.String("output", "", "output directory (default: {static_dir}/pk3s/)")
+	output := fs.String("output", "", "output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	publish := fs.String("publish", "", "also upload results to s3://bucket/prefix, gs://bucket/prefix, or a directory")
+	policyPath := fs.String("policy", "", "baseline policy file, YAML or JSON (default: assets.policy)")
+	substitutePath := fs.String("substitute", "", "substitution table replacing official id files, e.g. with OpenArena data (default: assets.substitute)")
+	loose := fs.Bool("loose", false, "also index loose files in game directories, as dev installs have (default: assets.loose_files)")
+	roots := fs.StringArray("root", nil, "another install to merge under the Quake 3 directory, repeatable, highest priority first (default: assets.roots)")
+	profile := fs.String("profile", "", "build profile: web, lan, archive, or one from assets.profiles (default: assets.profile)")
+	placeholders := fs.Bool("placeholders", false, "put placeholder images for missing textures in map pk3s (default: assets.placeholders)")
+	music := fs.String("music", "", "map music policy: keep, exclude, or ogg to re-encode with ffmpeg (default: from the profile, else keep)")
+	musicBitrate := fs.Int("music-bitrate", 0, "Ogg Vorbis bitrate in kbit/s for --music ogg (default: from the profile, else 96)")
+	maxErrors := fs.Int("max-errors", -1, "fail if the build reports more than this many errors (negative = no limit)")
+	diagPath := fs.String("diagnostics", "", "write the build's diagnostics to this file as JSON")
+	dryRun := fs.Bool("dry-run", false, "resolve everything and report the pk3s that would be written, writing nothing")
+	listFiles := fs.Bool("files", false, "with --dry-run, list every file of each pk3")
+	signKey := fs.String("sign-key", "", "sign the manifest and artifact list with this private key (default: assets.signing_key)")
+	atomic := fs.Bool("atomic", false, "build into a staging directory and swap it in only if the build succeeds (default: assets.atomic_builds)")
+	compressedTextures := fs.String("compressed-textures", "", "use .dds and .ktx texture containers: ignore, include (beside the originals), or prefer (default: from the profile, else ignore)")
+	companions := fs.Bool("companion-textures", false, "also put each texture's _n, _s, and _glow maps in map pk3s, for engines that use them (default: from the profile)")
+	textureOverrides := fs.String("texture-overrides", "", "file mapping texture references to the files they must resolve to (default: assets.texture_overrides)")
 	fs.Parse(args)
+	if *dryRun && *publish != "" {
+		fmt.Fprintf(os.Stderr, "Error: --dry-run and --publish can't be combined\n")
+		os.Exit(1)
+	}
 
 	cfg := loadCLIConfigFromFlags(*configPath, "")
 	if cfg == nil {
@@ -1254,120 +1585,476 @@ func cmdDemobake(args []string) {
 		quake3Dir = remaining[0]
 	}
 
-	outputDir := *output
-	if outputDir == "" {
-		if cfg.Server.StaticDir == "" {
-			fmt.Fprintf(os.Stderr, "Error: static_dir not configured and --output not specified\n")
+	outputDir := resolveAssetOutputDir(cfg, *output)
+	opts, err := assetBuildOptions(cfg, *profile, *policyPath, *substitutePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *loose {
+		opts.LooseFiles = true
+	}
+	if len(*roots) > 0 {
+		opts.Roots = *roots
+	}
+	if *atomic {
+		opts.Atomic = true
+	}
+	if *compressedTextures != "" {
+		if opts.Textures.Compressed, err = assets.ParseCompressedTextures(*compressedTextures); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *companions {
+		opts.Textures.Companions = true
+	}
+	if *textureOverrides != "" {
+		if opts.TextureOverrides, err = assets.LoadTextureOverrides(*textureOverrides); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *placeholders {
+		opts.MapPak.Placeholders = true
+	}
+	if *music != "" {
+		if opts.MapPak.Music, err = assets.ParseMusicPolicy(*music); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		outputDir = filepath.Join(cfg.Server.StaticDir, "demopk3s")
+	}
+	if *musicBitrate > 0 {
+		opts.MapPak.MusicBitrate = *musicBitrate
+	}
+	if *dryRun {
+		opts.DryRun = &assets.BuildPlan{}
 	}
 
-	if err := assets.BuildBaseline(quake3Dir, outputDir); err != nil {
+	diags, err := assets.BuildBaseline(quake3Dir, outputDir, opts)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-
-	fmt.Println("Demobake complete")
-}
-
-
-// dropPrivileges switches to the given service user. No-op if not root.
-func dropPrivileges(username string) error {
-	if os.Getuid() != 0 {
-		return nil
+	if opts.DryRun != nil {
+		opts.DryRun.WriteSummary(os.Stdout, *listFiles)
 	}
-	u, err := user.Lookup(username)
-	if err != nil {
-		return fmt.Errorf("looking up user %s: %w", username, err)
+	if *diagPath != "" {
+		if diags == nil {
+			diags = assets.Diagnostics{}
+		}
+		data, _ := json.MarshalIndent(diags, "", "  ")
+		if err := os.WriteFile(*diagPath, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
-	gid, _ := strconv.Atoi(u.Gid)
-	uid, _ := strconv.Atoi(u.Uid)
-	if err := syscall.Setgid(gid); err != nil {
-		return fmt.Errorf("setgid: %w", err)
+	if *maxErrors >= 0 && diags.Errors() > *maxErrors {
+		for _, d := range diags {
+			if d.Severity == assets.SeverityError {
+				fmt.Fprintf(os.Stderr, "  %s\n", d)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Error: %d build errors, more than --max-errors %d\n", diags.Errors(), *maxErrors)
+		os.Exit(1)
 	}
-	if err := syscall.Setuid(uid); err != nil {
-		return fmt.Errorf("setuid: %w", err)
+
+	if *signKey == "" {
+		*signKey = cfg.Assets.SigningKey
+	}
+	if *signKey != "" && !*dryRun {
+		key, err := assets.LoadSigningKey(*signKey)
+		if err == nil {
+			err = assets.SignBuild(outputDir, key)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
-	return nil
-}
 
-// serviceUser returns the service user from config, defaulting to "quake"
-func serviceUser(cfg *config.Config) string {
-	if cfg != nil && cfg.Server.ServiceUser != "" {
-		return cfg.Server.ServiceUser
+	if *publish != "" {
+		backend, err := assets.ParseOutputBackend(*publish)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := assets.PublishBuild(outputDir, backend); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
-	return "quake"
-}
 
-// useSystemd returns whether systemd integration is enabled
-func useSystemd(cfg *config.Config) bool {
-	if cfg != nil && cfg.Server.UseSystemd != nil {
-		return *cfg.Server.UseSystemd
+	if *dryRun {
+		fmt.Println("Demobake dry run complete; nothing written")
+		return
 	}
-	return detectSystemd()
+	fmt.Println("Demobake complete")
 }
 
-// detectSystemd checks if the system is running systemd
-func detectSystemd() bool {
-	_, err := os.Stat("/run/systemd/system")
-	return err == nil
-}
+// cmdWatch keeps demobake output and demo indexes current on a live server host
+func cmdWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	demoDir := fs.String("demos", "", "demo directory to index (default: assets.demo_dir or {static_dir}/demos/)")
+	interval := fs.Duration("interval", 10*time.Second, "poll interval")
+	metricsAddr := fs.String("metrics", "", "address to serve Prometheus metrics on at /metrics (e.g. 127.0.0.1:9108; default: off)")
+	fs.Parse(args)
 
-// systemctlRun executes a systemctl command, printing stderr on failure
-func systemctlRun(args ...string) error {
-	cmd := exec.Command("systemctl", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
+	cfg := loadCLIConfigFromFlags(*configPath, "")
+	if cfg == nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to load config\n")
+		os.Exit(1)
+	}
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
 
-// systemctlIsActive returns the active state of a systemd unit
-func systemctlIsActive(unit string) string {
-	out, err := exec.Command("systemctl", "is-active", unit).Output()
+	opts := assets.WatchOptions{
+		Quake3Dir: cfg.Server.Quake3Dir,
+		OutputDir: *output,
+		DemoDir:   *demoDir,
+		Interval:  *interval,
+	}
+	if fs.NArg() > 0 {
+		opts.Quake3Dir = fs.Arg(0)
+	}
+	opts.OutputDir = resolveAssetOutputDir(cfg, opts.OutputDir)
+	if opts.DemoDir == "" {
+		opts.DemoDir = cfg.AssetDemoDir()
+	}
+	build, err := assetBuildOptions(cfg, "", "", "")
 	if err != nil {
-		return "not-found"
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-	return strings.TrimSpace(string(out))
-}
+	opts.Build = build
+	opts.MapRepositories = cfg.Assets.MapRepositories
 
-// writeEnvFile creates a server environment file
-func writeEnvFile(path string, port int, game string) error {
-	opts := fmt.Sprintf("+set net_port %d", port)
-	if game != "" && game != "baseq3" {
-		opts += fmt.Sprintf(" +set fs_game %s", game)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Watching %s and %s every %v", opts.Quake3Dir, opts.DemoDir, opts.Interval)
+	if err := assets.Watch(ctx, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-	content := fmt.Sprintf("SERVER_OPTS=%s\n", opts)
-	return os.WriteFile(path, []byte(content), 0644)
 }
 
-// readEnvFile parses a server environment file for port and game
-func readEnvFile(path string) (port int, game string, err error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, "", err
+// cmdSync reconciles a local demo pk3 directory against a manifest
+func cmdSync(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	quake3Dir := fs.String("quake3-dir", "", "local Quake 3 install used to build pk3s that can't be downloaded")
+	prune := fs.Bool("prune", false, "delete generated pk3s not listed in the manifest")
+	distributable := fs.Bool("distributable", false, "skip pk3s containing official id content")
+	rateLimit := fs.Int64("rate-limit", 0, "download bandwidth limit in KB/s, shared by parallel downloads (default: unlimited)")
+	parallel := fs.Int("parallel", 4, "downloads at once")
+	retries := fs.Int("retries", 0, "times to resume a download after an error (default: 5, -1 for none)")
+	verifyKey := fs.String("verify-key", "", "require the manifest to be signed by this public key, or a file holding it (default: assets.verify_key)")
+	fs.Parse(args)
+
+	remaining := fs.Args()
+	if len(remaining) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity sync [--output dir] [--quake3-dir dir] [--prune] <manifest path or URL>\n")
+		os.Exit(1)
 	}
-	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "SERVER_OPTS=") {
-			continue
+	outputDir := *output
+	if outputDir == "" || *verifyKey == "" {
+		cfg := loadCLIConfigFromFlags(*configPath, "")
+		if outputDir == "" {
+			outputDir = resolveAssetOutputDir(cfg, "")
 		}
-		opts := strings.TrimPrefix(line, "SERVER_OPTS=")
-		parts := strings.Fields(opts)
-		for i := 0; i < len(parts)-1; i++ {
-			if parts[i] == "+set" && i+2 < len(parts) {
-				switch parts[i+1] {
-				case "net_port":
-					port, _ = strconv.Atoi(parts[i+2])
-				case "fs_game":
-					game = parts[i+2]
-				}
-			}
+		if *verifyKey == "" && cfg != nil {
+			*verifyKey = cfg.Assets.VerifyKey
 		}
-		break
+	}
+	var pub ed25519.PublicKey
+	if *verifyKey != "" {
+		var err error
+		if pub, err = assets.ParsePublicKey(*verifyKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	result, err := assets.Sync(assets.SyncOptions{
+		Manifest:      remaining[0],
+		OutputDir:     outputDir,
+		Quake3Dir:     *quake3Dir,
+		Prune:         *prune,
+		Distributable: *distributable,
+		PublicKey:     pub,
+		Download:      assets.DownloadOptions{RateLimit: *rateLimit * 1024, Parallel: *parallel, Retries: *retries},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, name := range result.Downloaded {
+		fmt.Printf("  downloaded %s\n", name)
+	}
+	for _, name := range result.Built {
+		fmt.Printf("  built %s\n", name)
+	}
+	for _, name := range result.Pruned {
+		fmt.Printf("  pruned %s\n", name)
+	}
+	for name, err := range result.Failed {
+		fmt.Fprintf(os.Stderr, "  Warning: %s: %v\n", name, err)
+	}
+
+	fmt.Printf("Sync: %d up to date, %d downloaded, %d built, %d pruned, %d skipped, %d failed\n",
+		len(result.UpToDate), len(result.Downloaded), len(result.Built), len(result.Pruned), len(result.Skipped), len(result.Failed))
+	if len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+
+// cmdRepack rewrites a pk3 with normalized, recompressed entries
+func cmdRepack(args []string) {
+	fs := flag.NewFlagSet("repack", flag.ExitOnError)
+	keepCase := fs.Bool("keep-case", false, "keep entry paths' case instead of lowercasing them")
+	stripSources := fs.Bool("strip-sources", false, "remove editor source files (.map, .xcf, .psd, ...)")
+	compression := fs.String("compression", assets.CompressionBest, "compression level: default, best, fast, or store")
+	fs.Parse(args)
+
+	remaining := fs.Args()
+	if len(remaining) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity repack [--keep-case] [--strip-sources] [--compression LEVEL] <in.pk3> <out.pk3>\n")
+		os.Exit(1)
+	}
+
+	result, err := assets.RepackPk3(remaining[0], remaining[1], assets.RepackOptions{
+		KeepCase:     *keepCase,
+		StripSources: *stripSources,
+		Compression:  *compression,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, name := range result.Removed {
+		fmt.Printf("  removed %s\n", name)
+	}
+	fmt.Printf("Repacked %d entries: %.1f MB -> %.1f MB\n", result.Entries,
+		float64(result.InSize)/(1024*1024), float64(result.OutSize)/(1024*1024))
+}
+
+// cmdVerifyMap checks that a built map pk3 resolves fully against its baseline pk3s
+func cmdVerifyMap(args []string) {
+	fs := flag.NewFlagSet("verifymap", flag.ExitOnError)
+	fs.Parse(args)
+
+	remaining := fs.Args()
+	if len(remaining) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity verifymap <map.pk3> <baseline.pk3>...\n")
+		os.Exit(1)
+	}
+
+	unresolved, diags, err := assets.VerifyMapPak(remaining[0], remaining[1:])
+	for _, d := range diags {
+		fmt.Fprintf(os.Stderr, "  %s\n", d)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(unresolved) == 0 {
+		fmt.Printf("%s: all references resolve\n", remaining[0])
+		return
+	}
+	for _, ref := range unresolved {
+		fmt.Printf("  missing %s\n", ref)
+	}
+	fmt.Printf("%s: %d unresolved references\n", remaining[0], len(unresolved))
+	os.Exit(1)
+}
+
+// cmdDemoTrailer appends or repairs the trailer of each given demo
+func cmdDemoTrailer(args []string) {
+	fs := flag.NewFlagSet("demotrailer", flag.ExitOnError)
+	recordedBy := fs.String("recorded-by", "", "recorder name to store (default: keep existing)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demotrailer [--recorded-by N] <demo.tvd>...\n")
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, path := range fs.Args() {
+		trailer, err := assets.RepairDemoTrailer(path, *recordedBy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: %d frames, %s, %d index entries\n", path, trailer.FrameCount, trailer.Duration(), len(trailer.Index))
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// cmdDemoSidecar writes a summary .json next to each given demo
+func cmdDemoSidecar(args []string) {
+	fs := flag.NewFlagSet("demosidecar", flag.ExitOnError)
+	manifestPath := fs.String("manifest", "", "manifest.json used to find levelshots")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demosidecar [--manifest F] <demo.tvd>...\n")
+		os.Exit(1)
+	}
+
+	var manifest *assets.Manifest
+	if *manifestPath != "" {
+		m, err := assets.LoadManifest(*manifestPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		manifest = m
+	}
+
+	failed := 0
+	for _, path := range fs.Args() {
+		sidecar, err := assets.GenerateDemoSidecar(path, manifest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: %s, %d players, %dms\n", assets.SidecarPath(path), sidecar.Map, len(sidecar.Players), sidecar.DurationMs)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// cmdRedactDemo writes a sanitized copy of a demo for public sharing
+func cmdRedactDemo(args []string) {
+	fs := flag.NewFlagSet("redactdemo", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity redactdemo <in.tvd> <out.tvd>\n")
+		os.Exit(1)
+	}
+
+	result, err := assets.RedactDemo(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Redacted %d frames: %d players renamed, %d configstrings and %d commands rewritten\n",
+		result.Frames, result.Players, result.Configstrings, result.Commands)
+}
+
+// dropPrivileges switches to the given service user. No-op if not root.
+func dropPrivileges(username string) error {
+	if os.Getuid() != 0 {
+		return nil
+	}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("looking up user %s: %w", username, err)
+	}
+	gid, _ := strconv.Atoi(u.Gid)
+	uid, _ := strconv.Atoi(u.Uid)
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	return nil
+}
+
+// serviceUser returns the service user from config, defaulting to "quake"
+func serviceUser(cfg *config.Config) string {
+	if cfg != nil && cfg.Server.ServiceUser != "" {
+		return cfg.Server.ServiceUser
+	}
+	return "quake"
+}
+
+// useSystemd returns whether systemd integration is enabled
+func useSystemd(cfg *config.Config) bool {
+	if cfg != nil && cfg.Server.UseSystemd != nil {
+		return *cfg.Server.UseSystemd
+	}
+	return detectSystemd()
+}
+
+// detectSystemd checks if the system is running systemd
+func detectSystemd() bool {
+	_, err := os.Stat("/run/systemd/system")
+	return err == nil
+}
+
+// systemctlRun executes a systemctl command, printing stderr on failure
+func systemctlRun(args ...string) error {
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// systemctlIsActive returns the active state of a systemd unit
+func systemctlIsActive(unit string) string {
+	out, err := exec.Command("systemctl", "is-active", unit).Output()
+	if err != nil {
+		return "not-found"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeEnvFile creates a server environment file
+func writeEnvFile(path string, port int, game string) error {
+	opts := fmt.Sprintf("+set net_port %d", port)
+	if game != "" && game != "baseq3" {
+		opts += fmt.Sprintf(" +set fs_game %s", game)
+	}
+	content := fmt.Sprintf("SERVER_OPTS=%s\n", opts)
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// readEnvFile parses a server environment file for port and game
+func readEnvFile(path string) (port int, game string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "SERVER_OPTS=") {
+			continue
+		}
+		opts := strings.TrimPrefix(line, "SERVER_OPTS=")
+		parts := strings.Fields(opts)
+		for i := 0; i < len(parts)-1; i++ {
+			if parts[i] == "+set" && i+2 < len(parts) {
+				switch parts[i+1] {
+				case "net_port":
+					port, _ = strconv.Atoi(parts[i+2])
+				case "fs_game":
+					game = parts[i+2]
+				}
+			}
+		}
+		break
 	}
 	if game == "" {
 		game = "baseq3"
@@ -1920,3 +2607,1935 @@ func extractTgaToPng(f *zip.File, outputPath string, targetSize int) error {
 
 	return png.Encode(out, img)
 }
+
+// subcommand is one command of a command group such as "trinity demo info"
+type subcommand struct {
+	name string
+	args string
+	desc string
+	run  func(args []string)
+}
+
+var (
+	baselineCommands = []subcommand{
+		{"build", "[flags] [path]", "Build baseline pk3s, map pk3s, and manifest", cmdDemobake},
+	}
+	mapPakCommands = []subcommand{
+		{"build", "[--game G] [--dry-run] [--profile P] <map>...", "Build map pk3s against the existing manifest", cmdMapPakBuild},
+		{"verify", "<map.pk3> <baseline.pk3>...", "Report unresolved references", cmdVerifyMap},
+		{"explain", "[--game G] [--json] <map>", "Show why each file is included", cmdMapPakExplain},
+		{"textures", "[--game G] [--all] [--json] <map>", "Show how the map's texture references resolve", cmdMapPakTextures},
+		{"importance", "[--game G] [--json] <map>", "Rank the map's textures by how much of its surface they cover", cmdMapPakImportance},
+		{"sizes", "[--top N] [--json]", "Show map pk3 sizes by category and the largest files", cmdMapPakSizes},
+		{"fetch", "[--game G] [--repo URL] [--rate-limit KB] <map>...", "Download maps from map repositories into the install and build their pk3s", cmdMapPakFetch},
+		{"pure", "[--game G] [--json] <map>", "Print a server.cfg snippet with only the paks a map needs", cmdMapPakPure},
+		{"servers", "[--build] [--master M] [--interval D] [address...]", "Check game servers' current maps have map pk3s", cmdMapPakServers},
+	}
+	demoCommands = []subcommand{
+		{"info", "[--json] [--detailed] <demo.tvd>", "Show a demo's map, game, assets, and length", cmdDemoInfo},
+		{"trailer", "[--recorded-by N] <demo.tvd>...", "Rebuild frame index trailers", cmdDemoTrailer},
+		{"sidecar", "[--manifest F] <demo.tvd>...", "Write .json summaries", cmdDemoSidecar},
+		{"redact", "<in.tvd> <out.tvd>", "Write a sanitized copy", cmdRedactDemo},
+		{"vms", "[--manifest F] <demo.tvd>", "Show the QVMs a demo plays back with", cmdDemoVMs},
+		{"pk3s", "[--base-url U] [--demo-pk3 P] [--json] <demo.tvd>", "List the pk3s to fetch before playing a demo", cmdDemoPk3s},
+		{"bundle", "<demo.tvd> <dir|file.zip>", "Export a demo with every pk3 it needs for offline playback", cmdDemoBundle},
+		{"rename", "[--template T] [--dry-run] <demo.tvd|dir>...", "Rename demos from their content", cmdDemoRename},
+		{"recompress", "[--level N] [--dict F --output DIR] <demo.tvd|dir>...", "Re-encode frame streams smaller", cmdDemoRecompress},
+		{"train-dict", "[--size N] --output F <demo.tvd|dir>...", "Train a zstd dictionary for recompress", cmdDemoTrainDict},
+		{"pack", "--output F <dir>", "Bundle a directory's demos into an indexed .tvda archive", cmdDemoPack},
+		{"archive", "[--json] <archive.tvda> [id]", "List an archive's demos, or extract one", cmdDemoArchive},
+	}
+	manifestCommands = []subcommand{
+		{"inspect", "[manifest.json]", "Summarize games, files, and artifacts", cmdManifestInspect},
+		{"maps", "[--game G] [--gametype T] [--heavy] [--json] [manifest.json]", "List maps with titles, authors, gametypes, and complexity warnings", cmdManifestMaps},
+		{"shaders", "[flags] [manifest.json]", "Report shader and texture usage", cmdManifestShaders},
+		{"orphans", "[flags] [manifest.json]", "List textures and sounds nothing references", cmdManifestOrphans},
+		{"case", "[flags] [manifest.json]", "Report references whose case differs from the file", cmdManifestCase},
+		{"graph", "[flags] (--map M | --model P) [manifest.json]", "Export a dependency graph as DOT or JSON", cmdManifestGraph},
+		{"player", "[flags] <model>...", "Check player models for missing files", cmdManifestPlayer},
+		{"levelshots", "[flags] [manifest.json]", "Export levelshots as web images with a JSON index", cmdManifestLevelshots},
+		{"downloads", "[--layout DIR] [--json] [manifest.json]", "Check pk3s against the engine's in-game download limits", cmdManifestDownloads},
+		{"compare", "[--limit N] [--json] <before.json> <after.json>", "Show what changes for maps between two builds", cmdManifestCompare},
+		{"keygen", "<key file>", "Create a signing key and print its public key", cmdManifestKeygen},
+		{"sign", "[--key F] [output dir]", "Sign a build's manifest and artifact list", cmdManifestSign},
+		{"verify", "[--key K] [output dir]", "Check a signed build's signatures and pk3s", cmdManifestVerify},
+		{"gc", "[--dry-run] [--archive DEST] [output dir]", "Delete generated pk3s the manifest no longer lists", cmdManifestGC},
+	}
+	pk3Commands = []subcommand{
+		{"ls", "<file.pk3>...", "List entries with sizes", cmdPk3List},
+		{"repack", "[flags] <in.pk3> <out.pk3>", "Normalize paths and drop junk", cmdRepack},
+		{"extract", "[flags] <file.pk3> <dir>", "Unpack a pk3 into a directory", cmdPk3Extract},
+		{"pack", "[flags] <dir> <out.pk3>", "Build a deterministic pk3 from a directory", cmdPk3Pack},
+	}
+	vfsCommands = []subcommand{
+		{"ls", "[flags] [path]", "List files at or under path with the pk3 supplying each", cmdVFSList},
+		{"cat", "[flags] <path>", "Write a file's winning copy to stdout", cmdVFSCat},
+		{"extract", "[flags] <path> <dir>", "Extract files at or under path into dir", cmdVFSExtract},
+	}
+)
+
+// runGroup dispatches to a group's subcommand, or prints the group's help
+func runGroup(group string, args []string, cmds []subcommand) {
+	if len(args) > 0 {
+		for _, c := range cmds {
+			if c.name == args[0] {
+				c.run(args[1:])
+				return
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Unknown %s command: %s\n", group, args[0])
+	}
+	fmt.Fprintf(os.Stderr, "Usage: trinity %s <command> [options] [args]\n\nCommands:\n", group)
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, c := range cmds {
+		fmt.Fprintf(w, "  %s %s\t%s\n", c.name, c.args, c.desc)
+	}
+	w.Flush()
+	os.Exit(1)
+}
+
+// cmdMapPakBuild builds individual map pk3s against an existing manifest
+func cmdMapPakBuild(args []string) {
+	fs := flag.NewFlagSet("mappak build", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	quake3Dir := fs.String("quake3-dir", "", "Quake 3 install (default: from config)")
+	game := fs.String("game", "baseq3", "game whose manifest the maps resolve against")
+	dryRun := fs.Bool("dry-run", false, "list the files each pk3 would hold, writing nothing")
+	profile := fs.String("profile", "", "build profile: web, lan, archive, or one from assets.profiles (default: assets.profile)")
+	placeholders := fs.Bool("placeholders", false, "add placeholder images for missing textures (default: assets.placeholders)")
+	music := fs.String("music", "", "music policy: keep, exclude, or ogg to re-encode with ffmpeg (default: from the profile, else keep)")
+	musicBitrate := fs.Int("music-bitrate", 0, "Ogg Vorbis bitrate in kbit/s for --music ogg (default: from the profile, else 96)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity mappak build [--game G] [--dry-run] [--profile P] [--placeholders] [--music keep|exclude|ogg] <map>...\n")
+		os.Exit(1)
+	}
+
+	cfg := loadCLIConfigFromFlags(*configPath, "")
+	outputDir := resolveAssetOutputDir(cfg, *output)
+	if *quake3Dir == "" && cfg != nil {
+		*quake3Dir = cfg.Server.Quake3Dir
+	}
+	mapPakOpts, err := assetMapPakOptions(cfg, *profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *placeholders {
+		mapPakOpts.Placeholders = true
+	}
+	if *music != "" {
+		if mapPakOpts.Music, err = assets.ParseMusicPolicy(*music); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *musicBitrate > 0 {
+		mapPakOpts.MusicBitrate = *musicBitrate
+	}
+
+	manifest, err := assets.LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		plan := &assets.BuildPlan{}
+		failed := 0
+		for _, mapName := range fs.Args() {
+			mapName = strings.ToLower(mapName)
+			outputPath := filepath.Join(outputDir, "maps", mapName+".pk3")
+			pk3, diags, err := assets.PlanMapPak(mapName, *game, manifest, outputPath, mapPakOpts)
+			for _, d := range diags {
+				fmt.Fprintf(os.Stderr, "  %s\n", d)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", mapName, err)
+				failed++
+				continue
+			}
+			if pk3 != nil {
+				plan.Pk3s = append(plan.Pk3s, pk3)
+			}
+		}
+		plan.WriteSummary(os.Stdout, true)
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(outputDir, "maps"), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	unlock, err := assets.LockOutput(outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer unlock()
+
+	failed := 0
+	for _, mapName := range fs.Args() {
+		mapName = strings.ToLower(mapName)
+		outputPath := filepath.Join(outputDir, "maps", mapName+".pk3")
+		diags, err := assets.BuildMapPak(mapName, *game, manifest, *quake3Dir, outputPath, mapPakOpts)
+		for _, d := range diags {
+			fmt.Fprintf(os.Stderr, "  %s\n", d)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", mapName, err)
+			failed++
+			continue
+		}
+		// Record the pk3 so sync verifies it and gc keeps it
+		recorded, err := manifest.RecordMapPak(*game, mapName, outputDir, &assets.Provenance{Trigger: "mappak"})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", mapName, err)
+			failed++
+			continue
+		}
+		if recorded {
+			fmt.Printf("  built %s\n", outputPath)
+		}
+	}
+	manifestPath := filepath.Join(outputDir, "manifest.json")
+	if err := manifest.Save(manifestPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(manifestPath + assets.SignatureExt); err == nil {
+		fmt.Fprintf(os.Stderr, "Warning: the manifest's signature is now stale; re-sign it with trinity manifest sign\n")
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// cmdMapPakFetch downloads maps the install lacks from map repositories,
+// verifies them, and adds them to the install and the build
+func cmdMapPakFetch(args []string) {
+	fs := flag.NewFlagSet("mappak fetch", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	quake3Dir := fs.String("quake3-dir", "", "Quake 3 install to add the maps to (default: from config)")
+	game := fs.String("game", "baseq3", "game directory to add the maps to")
+	repos := fs.StringSlice("repo", nil, "map repository URL template, {map} for the map name (repeatable; default: assets.map_repositories)")
+	rateLimit := fs.Int64("rate-limit", 0, "download bandwidth limit in KB/s (default: unlimited)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity mappak fetch [--game G] [--repo URL] <map>...\n")
+		os.Exit(1)
+	}
+
+	cfg := loadCLIConfigFromFlags(*configPath, "")
+	opts := assets.WatchOptions{OutputDir: resolveAssetOutputDir(cfg, *output), Quake3Dir: *quake3Dir}
+	if opts.Quake3Dir == "" && cfg != nil {
+		opts.Quake3Dir = cfg.Server.Quake3Dir
+	}
+	if len(*repos) == 0 && cfg != nil {
+		*repos = cfg.Assets.MapRepositories
+	}
+	build, err := assetBuildOptions(cfg, "", "", "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Build = build
+
+	failed := 0
+	for _, mapName := range fs.Args() {
+		mapName = strings.ToLower(mapName)
+		pk3Path, source, err := assets.FetchMap(mapName, *repos, filepath.Join(opts.Quake3Dir, *game), assets.DownloadOptions{RateLimit: *rateLimit * 1024})
+		if err == nil {
+			err = assets.AddFetchedMap(opts, *game, mapName, pk3Path, source)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", mapName, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  %s: %s from %s\n", mapName, filepath.Base(pk3Path), source)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// cmdMapPakPure prints which of the install's pk3s a pure server needs for a
+// map, as a server.cfg snippet
+func cmdMapPakPure(args []string) {
+	fs := flag.NewFlagSet("mappak pure", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	game := fs.String("game", "baseq3", "game the server runs")
+	asJSON := fs.Bool("json", false, "print the paks as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity mappak pure [--game G] [--json] <map>\n")
+		os.Exit(1)
+	}
+
+	outputDir := resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), *output)
+	manifest, err := assets.LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	lists, err := assets.LoadPureLists(filepath.Join(outputDir, assets.PureListName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	list, ok := lists[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in pure lists\n", *game)
+		os.Exit(1)
+	}
+
+	set, err := assets.PlanMapPureSet(fs.Arg(0), *game, manifest, list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(set)
+		return
+	}
+	set.WriteServerConfig(os.Stdout)
+}
+
+// cmdMapPakServers polls game servers for their current map and fs_game and
+// warns about, or builds, map pk3s players will need when they connect
+func cmdMapPakServers(args []string) {
+	fs := flag.NewFlagSet("mappak servers", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	quake3Dir := fs.String("quake3-dir", "", "Quake 3 install (default: from config)")
+	masters := fs.StringSlice("master", nil, "also check the servers a master server lists (host:port, repeatable)")
+	protocol := fs.Int("protocol", 68, "protocol to ask master servers for")
+	build := fs.Bool("build", false, "build missing map pk3s")
+	profile := fs.String("profile", "", "build profile for --build (default: assets.profile)")
+	interval := fs.Duration("interval", 0, "keep polling at this interval (default: check once)")
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	fs.Parse(args)
+
+	cfg := loadCLIConfigFromFlags(*configPath, "")
+	outputDir := resolveAssetOutputDir(cfg, *output)
+	if *quake3Dir == "" && cfg != nil {
+		*quake3Dir = cfg.Server.Quake3Dir
+	}
+	mapPakOpts, err := assetMapPakOptions(cfg, *profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Servers from the config are named; ones on the command line or from
+	// master servers go by address
+	names := make(map[string]string)
+	var addresses []string
+	if cfg != nil {
+		for _, srv := range cfg.Q3Servers {
+			names[srv.Address] = srv.Name
+			addresses = append(addresses, srv.Address)
+		}
+	}
+	addresses = append(addresses, fs.Args()...)
+
+	client := collector.NewQ3Client()
+	for _, master := range *masters {
+		listed, err := client.QueryMaster(master, *protocol)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: master %s: %v\n", master, err)
+			continue
+		}
+		addresses = append(addresses, listed...)
+	}
+	if len(addresses) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity mappak servers [--build] [--master M] [--interval D] [address...]\n")
+		fmt.Fprintf(os.Stderr, "No servers: add q3_servers to the config, name addresses, or use --master\n")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	built := make(map[string]bool) // map pk3s built this run, so a failing build isn't retried every poll
+	for {
+		manifest, err := assets.LoadManifest(filepath.Join(outputDir, "manifest.json"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		var results []assets.ServerMap
+		missing := 0
+		for _, addr := range addresses {
+			info, err := client.QueryInfo(addr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", addr, err)
+				continue
+			}
+			sm, err := assets.CheckServerMap(manifest, outputDir, info["game"], info["mapname"])
+			sm.Address, sm.Name = addr, names[addr]
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", addr, err)
+				continue
+			}
+			if sm.Status == assets.ServerMapMissingPk3 && *build && !built[sm.Pk3] {
+				built[sm.Pk3] = true
+				outputPath := filepath.Join(outputDir, filepath.FromSlash(sm.Pk3))
+				log.Printf("Building map pk3: %s (%s)", sm.Map, sm.Game)
+				unlock, err := assets.LockOutput(outputDir)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				diags, err := assets.BuildMapPak(sm.Map, sm.Game, manifest, *quake3Dir, outputPath, mapPakOpts)
+				if err == nil {
+					// Record the pk3 so sync verifies it and gc keeps it
+					_, err = manifest.RecordMapPak(sm.Game, sm.Map, outputDir, &assets.Provenance{Trigger: "server"})
+				}
+				if err == nil {
+					err = manifest.Save(filepath.Join(outputDir, "manifest.json"))
+				}
+				unlock()
+				for _, d := range diags {
+					fmt.Fprintf(os.Stderr, "  %s\n", d)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %s: %v\n", sm.Map, err)
+				} else {
+					sm.Status = assets.ServerMapReady
+				}
+			}
+			if sm.Status != assets.ServerMapReady {
+				missing++
+			}
+			results = append(results, sm)
+		}
+
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(results)
+		} else {
+			for _, sm := range results {
+				fmt.Println(sm)
+			}
+		}
+
+		if *interval <= 0 {
+			if missing > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// cmdMapPakExplain prints the tree of references that pull each file into a
+// map pk3
+func cmdMapPakExplain(args []string) {
+	fs := flag.NewFlagSet("mappak explain", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	game := fs.String("game", "baseq3", "game whose manifest the map resolves against")
+	asJSON := fs.Bool("json", false, "print the tree as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity mappak explain [--game G] [--json] <map>\n")
+		os.Exit(1)
+	}
+
+	outputDir := resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), *output)
+	manifest, err := assets.LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+
+	tree, err := assets.ExplainMapPak(strings.ToLower(fs.Arg(0)), gm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(tree)
+		return
+	}
+	printDepTree(tree, 0)
+}
+
+// cmdMapPakTextures traces how each texture reference of a map resolves:
+// every file tried, the one used, and its pk3. Only references with more
+// than one candidate, an override, or no file at all are shown unless --all.
+func cmdMapPakTextures(args []string) {
+	fs := flag.NewFlagSet("mappak textures", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	game := fs.String("game", "baseq3", "game whose manifest the map resolves against")
+	all := fs.Bool("all", false, "show every reference, not only ambiguous, overridden, and missing ones")
+	asJSON := fs.Bool("json", false, "print the trace as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity mappak textures [--game G] [--all] [--json] <map>\n")
+		os.Exit(1)
+	}
+
+	outputDir := resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), *output)
+	manifest, err := assets.LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+
+	traces, err := assets.TraceMapTextures(strings.ToLower(fs.Arg(0)), gm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !*all {
+		shown := traces[:0]
+		for _, r := range traces {
+			if r.Ambiguous() || r.Override || r.Winner == "" {
+				shown = append(shown, r)
+			}
+		}
+		traces = shown
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(traces)
+		return
+	}
+	for _, r := range traces {
+		switch {
+		case r.Winner == "":
+			fmt.Printf("%s: not found (tried %s)\n", r.Ref, strings.Join(r.Candidates, ", "))
+			continue
+		case r.Override:
+			fmt.Printf("%s → %s (%s), by override over %s\n", r.Ref, r.Winner, filepath.Base(r.Source), r.Overridden)
+		default:
+			fmt.Printf("%s → %s (%s)\n", r.Ref, r.Winner, filepath.Base(r.Source))
+		}
+		for _, file := range r.Found {
+			if file != r.Winner {
+				fmt.Printf("  also %s (%s)\n", file, filepath.Base(gm.FileIndex[file]))
+			}
+		}
+	}
+}
+
+// cmdMapPakImportance lists a map's textures, least seen first
+func cmdMapPakImportance(args []string) {
+	fs := flag.NewFlagSet("mappak importance", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	game := fs.String("game", "baseq3", "game whose manifest the map resolves against")
+	asJSON := fs.Bool("json", false, "print the ranking as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity mappak importance [--game G] [--json] <map>\n")
+		os.Exit(1)
+	}
+
+	outputDir := resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), *output)
+	manifest, err := assets.LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+
+	importance, err := assets.MapTextureImportance(fs.Arg(0), gm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(importance)
+		return
+	}
+	var total float64
+	for _, t := range importance {
+		total += t.Area
+	}
+	for _, t := range importance {
+		share := 0.0
+		if total > 0 {
+			share = 100 * t.Area / total
+		}
+		baseline := ""
+		if gm.BaselineFiles[t.Texture] {
+			baseline = " (baseline)"
+		}
+		fmt.Printf("%6.2f%%  %5d surfaces  %s%s\n", share, t.Surfaces, t.Texture, baseline)
+	}
+}
+
+// cmdMapPakSizes reports where the space in the built map pk3s goes
+func cmdMapPakSizes(args []string) {
+	fs := flag.NewFlagSet("mappak sizes", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	top := fs.Int("top", 20, "number of largest files to list (0 for all)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity mappak sizes [--top N] [--json]\n")
+		os.Exit(1)
+	}
+
+	outputDir := resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), *output)
+	report, err := assets.MapPakSizes(outputDir, *top)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	report.WriteText(os.Stdout)
+}
+
+// printDepTree prints a dependency tree indented by depth
+func printDepTree(n *assets.DepNode, depth int) {
+	line := strings.Repeat("  ", depth) + n.Name
+	if n.Source != "" {
+		line += "  (" + n.Source
+		if n.Baseline {
+			line += ", baseline"
+		}
+		line += ")"
+	}
+	fmt.Println(line)
+	for _, c := range n.Children {
+		printDepTree(c, depth+1)
+	}
+}
+
+// cmdDemoInfo prints what a demo references and how long it runs
+func cmdDemoInfo(args []string) {
+	fs := flag.NewFlagSet("demo info", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the parsed demo as JSON")
+	detailed := fs.Bool("detailed", false, "list each asset reference with its configstring index and when it appeared")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo info [--json] [--detailed] <demo.tvd>\n")
+		os.Exit(1)
+	}
+
+	if *detailed {
+		printDemoAssets(fs.Arg(0), *asJSON)
+		return
+	}
+	info, err := assets.ParseDemo(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Map:\t%s\n", info.MapName)
+	if info.FSGame != "" {
+		fmt.Fprintf(w, "fs_game:\t%s\n", info.FSGame)
+	}
+	fmt.Fprintf(w, "Gametype:\t%d\n", info.GameType)
+	fmt.Fprintf(w, "Models:\t%d\n", len(info.Models))
+	fmt.Fprintf(w, "Sounds:\t%d\n", len(info.Sounds))
+	for _, pi := range info.PlayerInfos {
+		if pi.HModel != "" && pi.HModel != pi.Model {
+			fmt.Fprintf(w, "Player model:\t%s (head %s)\n", pi.Model, pi.HModel)
+		} else {
+			fmt.Fprintf(w, "Player model:\t%s\n", pi.Model)
+		}
+	}
+	if t := info.Trailer; t != nil {
+		fmt.Fprintf(w, "Frames:\t%d\n", t.FrameCount)
+		fmt.Fprintf(w, "Duration:\t%s\n", t.Duration())
+		if t.RecordedBy != "" {
+			fmt.Fprintf(w, "Recorded by:\t%s\n", t.RecordedBy)
+		}
+	}
+	w.Flush()
+}
+
+// printDemoAssets lists a demo's asset references by configstring slot
+func printDemoAssets(path string, asJSON bool) {
+	da, err := assets.ParseDemoAssets(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, w := range da.Warnings {
+		log.Printf("Warning: %s", w)
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(da)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CS\tTIME\tCATEGORY\tNAME\n")
+	for _, ref := range da.Refs {
+		when := strconv.Itoa(ref.ServerTime)
+		if ref.Initial {
+			when = "initial"
+		}
+		name := ref.Name
+		if ref.HModel != "" && ref.HModel != ref.Name {
+			name += " (head " + ref.HModel + ")"
+		}
+		if ref.Bot != "" {
+			name += " [bot " + ref.Bot + "]"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", ref.Index, when, ref.Category, name)
+	}
+	w.Flush()
+}
+
+// cmdDemoVMs reports the cgame and ui QVMs a demo plays back with, where
+// each comes from, and its checksum
+func cmdDemoVMs(args []string) {
+	fs := flag.NewFlagSet("demo vms", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	manifestPath := fs.String("manifest", "", "manifest.json (default: from config)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo vms [--manifest F] <demo.tvd>\n")
+		os.Exit(1)
+	}
+
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(*manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	info, err := assets.ParseDemo(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	game, vms, err := assets.DemoVMs(info, manifest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Game: %s\n", game)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tSOURCE\tSHA256\tBASELINE")
+	for _, vm := range vms {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\n", vm.Path, vm.Source, vm.SHA256, vm.Baseline)
+	}
+	w.Flush()
+}
+
+// cmdDemoPk3s lists the generated pk3s a client needs to play a demo
+func cmdDemoPk3s(args []string) {
+	fs := flag.NewFlagSet("demo pk3s", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	baseURL := fs.String("base-url", "", "URL the output directory is served at")
+	demoPk3 := fs.String("demo-pk3", "", "output-relative path of the demo's own pk3, if it has one")
+	asJSON := fs.Bool("json", false, "print the pk3s as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo pk3s [--base-url U] [--demo-pk3 P] [--json] <demo.tvd>\n")
+		os.Exit(1)
+	}
+
+	outputDir := resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), *output)
+	manifest, err := assets.LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	info, err := assets.ParseDemo(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	game, pk3s, err := assets.RequiredDemoPk3s(info, manifest, assets.DemoPk3Options{
+		OutputDir: outputDir,
+		BaseURL:   *baseURL,
+		DemoPk3:   *demoPk3,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{"game": game, "map": info.MapName, "pk3s": pk3s})
+		return
+	}
+
+	fmt.Printf("Game: %s\n", game)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tROLE\tSIZE\tSHA256")
+	for _, p := range pk3s {
+		size, sum := fmt.Sprintf("%d", p.Size), p.SHA256
+		if p.Missing {
+			size, sum = "-", "(not built)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Path, p.Role, size, sum)
+	}
+	w.Flush()
+}
+
+// cmdDemoBundle writes a self-contained bundle of a demo and its pk3s
+func cmdDemoBundle(args []string) {
+	fs := flag.NewFlagSet("demo bundle", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "demobake output directory (default: assets.output_dir or {static_dir}/demopk3s/)")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo bundle <demo.tvd> <dir|file.zip>\n")
+		os.Exit(1)
+	}
+
+	outputDir := resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), *output)
+	manifest, err := assets.LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	bundle, err := assets.ExportDemoBundle(fs.Arg(0), fs.Arg(1), manifest, outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	var size int64
+	for _, p := range bundle.Pk3s {
+		size += p.Size
+	}
+	fmt.Printf("Wrote %s: %s (%s), %d pk3s, %.1f MB\n", fs.Arg(1), bundle.Demo, bundle.Map, len(bundle.Pk3s), float64(size)/(1024*1024))
+}
+
+// cmdDemoRename renames demos, and their sidecars, by a template filled from
+// each demo's content. Directories are renamed as a batch.
+func cmdDemoRename(args []string) {
+	fs := flag.NewFlagSet("demo rename", flag.ExitOnError)
+	template := fs.String("template", assets.DefaultRenameTemplate, "name template; fields: date time map game gametype winner loser duration players server match")
+	dryRun := fs.Bool("dry-run", false, "show new names without renaming")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo rename [--template T] [--dry-run] <demo.tvd|dir>...\n")
+		os.Exit(1)
+	}
+
+	failed := false
+	report := func(r assets.DemoRename) {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.From, r.Err)
+			failed = true
+		case r.To == r.From:
+			fmt.Printf("%s: unchanged\n", r.From)
+		default:
+			fmt.Printf("%s -> %s\n", r.From, r.To)
+		}
+	}
+	for _, path := range fs.Args() {
+		if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+			results, err := assets.RenameDemos(path, *template, *dryRun)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				failed = true
+			}
+			for _, r := range results {
+				report(r)
+			}
+			continue
+		}
+		r := assets.DemoRename{From: path}
+		if *dryRun {
+			r.To, r.Err = assets.DemoRenameTarget(path, *template)
+		} else {
+			r.To, r.Err = assets.RenameDemo(path, *template)
+		}
+		report(r)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// demoPaths expands directory arguments to the .tvd demos directly in them.
+func demoPaths(args []string) []string {
+	var paths []string
+	for _, arg := range args {
+		stat, err := os.Stat(arg)
+		if err != nil || !stat.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(arg, "*.tvd"))
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// loadDemoDictionary registers the zstd dictionary demos were recompressed
+// with, if there is one, so they can be read. Commands that load the config
+// call it; one that can't be loaded only matters for the demos that need it,
+// which then fail to read.
+func loadDemoDictionary(path string) {
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = assets.RegisterDemoDictionary(data)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: demo dictionary: %v\n", err)
+	}
+}
+
+// loadConfiguredDemoDictionary registers the default config's demo
+// dictionary for the demo commands, which don't otherwise read the config
+func loadConfiguredDemoDictionary() {
+	if cfg, err := config.Load(defaultConfigPath); err == nil {
+		loadDemoDictionary(cfg.Assets.DemoDictionary)
+	}
+}
+
+// cmdDemoRecompress re-encodes demos' frame streams at a higher zstd level,
+// optionally with a trained dictionary, for archive storage
+func cmdDemoRecompress(args []string) {
+	fs := flag.NewFlagSet("demo recompress", flag.ExitOnError)
+	level := fs.Int("level", 19, "zstd compression level (1-22)")
+	dictPath := fs.String("dict", "", "zstd dictionary from train-dict; the game can't play demos compressed with one, so --output is required")
+	outputDir := fs.StringP("output", "o", "", "directory to write recompressed demos to (default: replace each demo)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 || *dictPath != "" && *outputDir == "" {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo recompress [--level N] [--dict F --output DIR] <demo.tvd|dir>...\n")
+		os.Exit(1)
+	}
+	if *outputDir != "" {
+		if err := os.MkdirAll(*outputDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var dictionary []byte
+	if *dictPath != "" {
+		var err error
+		if dictionary, err = os.ReadFile(*dictPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	failed := false
+	var before, after int64
+	for _, path := range demoPaths(fs.Args()) {
+		outPath := path
+		if *outputDir != "" {
+			outPath = filepath.Join(*outputDir, filepath.Base(path))
+		}
+		result, err := assets.RecompressDemo(path, outPath, *level, dictionary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		before += result.Before
+		after += result.After
+		fmt.Printf("%s: %d frames, %.1f KB -> %.1f KB\n", outPath, result.Frames, float64(result.Before)/1024, float64(result.After)/1024)
+	}
+	if before > 0 {
+		fmt.Printf("Total: %.1f MB -> %.1f MB (%.1f%%)\n", float64(before)/(1024*1024), float64(after)/(1024*1024), 100*float64(after)/float64(before))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// cmdDemoTrainDict trains a zstd dictionary on sample demos' frames
+func cmdDemoTrainDict(args []string) {
+	fs := flag.NewFlagSet("demo train-dict", flag.ExitOnError)
+	size := fs.Int("size", assets.DefaultDemoDictSize, "dictionary size in bytes")
+	output := fs.StringP("output", "o", "", "dictionary file to write")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 || *output == "" {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo train-dict [--size N] --output F <demo.tvd|dir>...\n")
+		os.Exit(1)
+	}
+
+	paths := demoPaths(fs.Args())
+	dictionary, err := assets.TrainDemoDictionary(paths, *size)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*output, dictionary, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s (%.1f KB, from %d demos)\n", *output, float64(len(dictionary))/1024, len(paths))
+}
+
+// cmdDemoPack bundles every demo under a directory into a demo archive
+func cmdDemoPack(args []string) {
+	fs := flag.NewFlagSet("demo pack", flag.ExitOnError)
+	output := fs.StringP("output", "o", "", "archive to write (.tvda)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *output == "" {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo pack --output F <dir>\n")
+		os.Exit(1)
+	}
+	index, err := assets.PackDemoArchive(*output, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	var size int64
+	for _, demo := range index.Demos {
+		size += demo.Size
+	}
+	fmt.Printf("Packed %d demos (%.1f MB) into %s\n", len(index.Demos), float64(size)/(1024*1024), *output)
+}
+
+// cmdDemoArchive lists a demo archive's index, or writes one demo from it to
+// stdout
+func cmdDemoArchive(args []string) {
+	fs := flag.NewFlagSet("demo archive", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the index as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity demo archive [--json] <archive.tvda> [id]\n")
+		os.Exit(1)
+	}
+	archive, err := assets.OpenDemoArchive(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer archive.Close()
+
+	if fs.NArg() == 2 {
+		section, err := archive.Section(fs.Arg(1))
+		if err == nil {
+			_, err = io.Copy(os.Stdout, section)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(archive.Index)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMAP\tGAMETYPE\tDURATION\tPLAYERS\tSIZE")
+	for _, demo := range archive.Index.Demos {
+		sc := demo.Sidecar
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%.1f KB\n", demo.ID, sc.Map, sc.GameType,
+			time.Duration(sc.DurationMs)*time.Millisecond, len(sc.Players), float64(demo.Size)/1024)
+	}
+	w.Flush()
+}
+
+// cmdManifestInspect summarizes a demobake manifest
+func cmdManifestInspect(args []string) {
+	fs := flag.NewFlagSet("manifest inspect", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	fs.Parse(args)
+
+	manifestPath := fs.Arg(0)
+	if manifestPath == "" {
+		manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GAME\tFILES\tBASELINE\tSHADERS\tOFFICIAL\tSUBSTITUTED\tQUARANTINED")
+	for _, game := range manifest.GameNames() {
+		gm := manifest.Games[game]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", game, len(gm.FileIndex), len(gm.BaselineFiles),
+			len(gm.Shaders), len(gm.OfficialFiles), len(gm.Substituted), len(gm.Quarantined))
+	}
+	w.Flush()
+
+	restricted := 0
+	var size int64
+	for _, a := range manifest.Artifacts {
+		size += a.Size
+		if a.Restricted {
+			restricted++
+		}
+	}
+	fmt.Printf("\n%d artifacts, %.1f MB, %d restricted\n", len(manifest.Artifacts), float64(size)/(1024*1024), restricted)
+	if len(manifest.Roots) > 0 {
+		winning := make(map[string]int)
+		for _, gm := range manifest.Games {
+			for _, source := range gm.FileIndex {
+				winning[manifest.Root(source)]++
+			}
+		}
+		for i, root := range manifest.Roots {
+			fmt.Printf("  root %d: %s (%d files)\n", i+1, root, winning[root])
+		}
+	}
+	for _, game := range manifest.GameNames() {
+		shadowed := manifest.Games[game].Shadowed
+		skipped := make([]string, 0, len(shadowed))
+		for pk3Path := range shadowed {
+			skipped = append(skipped, pk3Path)
+		}
+		sort.Strings(skipped)
+		for _, pk3Path := range skipped {
+			fmt.Printf("  shadowed (%s): %s by %s\n", game, pk3Path, shadowed[pk3Path])
+		}
+	}
+	for game, quarantined := range manifest.Quarantined() {
+		for _, q := range quarantined {
+			fmt.Printf("  quarantined (%s): %s: %s\n", game, q.Path, q.Error)
+		}
+	}
+	missingMaps := make([]string, 0, len(manifest.MissingMaps))
+	for key := range manifest.MissingMaps {
+		missingMaps = append(missingMaps, key)
+	}
+	sort.Strings(missingMaps)
+	for _, key := range missingMaps {
+		missing := manifest.MissingMaps[key]
+		fmt.Printf("  missing map (%s): %s, needed by %d demos\n", missing.Game, missing.Map, len(missing.Demos))
+	}
+}
+
+// cmdManifestMaps lists a game's maps with the metadata recorded for them
+func cmdManifestMaps(args []string) {
+	fs := flag.NewFlagSet("manifest maps", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	game := fs.String("game", "baseq3", "game to list")
+	gametype := fs.String("gametype", "", "only maps supporting this gametype (ffa, tourney, team, ctf, oneflag, overload, harvester, dom)")
+	heavy := fs.Bool("heavy", false, "only maps complex enough to perform badly in the web player")
+	asJSON := fs.Bool("json", false, "print the maps as JSON")
+	fs.Parse(args)
+
+	manifestPath := fs.Arg(0)
+	if manifestPath == "" {
+		manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+
+	maps := make(map[string]*assets.MapInfo)
+	for _, name := range gm.MapNames() {
+		info := gm.Maps[name]
+		if info == nil {
+			info = &assets.MapInfo{}
+		}
+		if *gametype != "" && !info.Supports(strings.ToLower(*gametype)) {
+			continue
+		}
+		if *heavy && (info.Stats == nil || len(info.Stats.Heavy()) == 0) {
+			continue
+		}
+		maps[name] = info
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(maps)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MAP\tTITLE\tAUTHOR\tGAMETYPES\tHEAVY")
+	for _, name := range gm.MapNames() {
+		if info, ok := maps[name]; ok {
+			var heavy []string
+			if info.Stats != nil {
+				heavy = info.Stats.Heavy()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, info.Title, info.Author, strings.Join(info.Gametypes, " "), strings.Join(heavy, ", "))
+		}
+	}
+	w.Flush()
+}
+
+// cmdManifestShaders reports which maps and models use shaders and textures
+func cmdManifestShaders(args []string) {
+	fs := flag.NewFlagSet("manifest shaders", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	game := fs.String("game", "baseq3", "game to report on")
+	unused := fs.Bool("unused", false, "list shaders no map or model references")
+	texture := fs.String("texture", "", "list the shaders and maps/models affected by replacing this texture")
+	top := fs.Int("top", 20, "number of most-shared textures to list")
+	fs.Parse(args)
+
+	manifestPath := fs.Arg(0)
+	if manifestPath == "" {
+		manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+	usage := gm.ShaderUsage()
+
+	switch {
+	case *unused:
+		for _, name := range usage.Unreferenced() {
+			fmt.Println(name)
+		}
+	case *texture != "":
+		path := strings.ToLower(*texture)
+		if resolved, ok := gm.ResolveTexture(path); ok {
+			path = resolved
+		}
+		fmt.Printf("%s\n", path)
+		for _, shader := range usage.UsedBy[path] {
+			fmt.Printf("  shader %s\n", shader)
+		}
+		for _, dep := range usage.Dependents(path) {
+			fmt.Printf("  used by %s\n", dep)
+		}
+	default:
+		textures := make([]string, 0, len(usage.UsedBy))
+		for tex := range usage.UsedBy {
+			textures = append(textures, tex)
+		}
+		sort.Slice(textures, func(i, j int) bool {
+			a, b := len(usage.UsedBy[textures[i]]), len(usage.UsedBy[textures[j]])
+			if a != b {
+				return a > b
+			}
+			return textures[i] < textures[j]
+		})
+		if len(textures) > *top {
+			textures = textures[:*top]
+		}
+		fmt.Printf("%d shaders, %d referenced by maps or models, %d unreferenced\n",
+			len(usage.ReferencedBy), len(usage.ReferencedBy)-len(usage.Unreferenced()), len(usage.Unreferenced()))
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TEXTURE\tSHADERS\tMAPS/MODELS")
+		for _, tex := range textures {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", tex, len(usage.UsedBy[tex]), len(usage.Dependents(tex)))
+		}
+		w.Flush()
+	}
+}
+
+// cmdManifestLevelshots exports every levelshot in the manifest as web images
+// for the map browser
+func cmdManifestLevelshots(args []string) {
+	fs := flag.NewFlagSet("manifest levelshots", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	output := fs.String("output", "", "directory to write levelshots/ under (default: the manifest's directory)")
+	sizes := fs.IntSlice("size", assets.LevelshotSizes, "widths to export (repeatable)")
+	format := fs.String("format", "jpg", "image format: jpg or png")
+	quality := fs.Int("quality", 85, "JPEG quality")
+	minimap := fs.Int("minimap", 0, "also render top-down minimaps this many pixels across (0 for none)")
+	fs.Parse(args)
+
+	manifestPath := fs.Arg(0)
+	if manifestPath == "" {
+		manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	outputDir := *output
+	if outputDir == "" {
+		outputDir = filepath.Dir(manifestPath)
+	}
+
+	index, err := assets.ExportLevelshots(manifest, outputDir, assets.LevelshotExportOptions{
+		Sizes:   *sizes,
+		Format:  *format,
+		Quality: *quality,
+		Minimap: *minimap,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, game := range manifest.GameNames() {
+		fmt.Printf("%s: %d levelshots\n", game, len(index[game]))
+	}
+	fmt.Printf("Wrote %s\n", filepath.Join(outputDir, "levelshots", "levelshots.json"))
+}
+
+// cmdManifestDownloads checks a build's pk3s against the engine's in-game
+// (UDP) download limits, and optionally lays them out as game directories
+func cmdManifestDownloads(args []string) {
+	fs := flag.NewFlagSet("manifest downloads", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	layoutDir := fs.String("layout", "", "link the pk3s into DIR as <game>/<name>.pk3, for serving or an sv_dlURL")
+	warnSize := fs.Int64("warn-size", assets.DefaultUDPDownloadWarnSize, "warn about pk3s larger than this many bytes")
+	asJSON := fs.Bool("json", false, "print the layout and diagnostics as JSON")
+	fs.Parse(args)
+
+	manifestPath := fs.Arg(0)
+	if manifestPath == "" {
+		manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	layout := assets.PlanDownloadLayout(manifest, *warnSize)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(layout)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, p := range layout.Paks {
+			fmt.Fprintf(w, "%s\t%.1f KB\t%d\t%s\n", p.Path, float64(p.Size)/1024, p.Checksum, p.Artifact)
+		}
+		w.Flush()
+		for _, d := range layout.Diagnostics {
+			fmt.Fprintf(os.Stderr, "  %s\n", d)
+		}
+	}
+
+	if *layoutDir != "" {
+		if err := assets.WriteDownloadLayout(layout, filepath.Dir(manifestPath), *layoutDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !*asJSON {
+			fmt.Printf("Wrote %d pk3s under %s\n", len(layout.Paks), *layoutDir)
+		}
+	}
+	if layout.Diagnostics.Errors() > 0 {
+		os.Exit(1)
+	}
+}
+
+// cmdManifestCompare reports the differences between two manifests, such as
+// the builds before and after adding a mod pk3
+func cmdManifestCompare(args []string) {
+	fs := flag.NewFlagSet("manifest compare", flag.ExitOnError)
+	limit := fs.Int("limit", 20, "entries to list of each kind (0 for all)")
+	asJSON := fs.Bool("json", false, "print the comparison as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity manifest compare [--limit N] [--json] <before.json> <after.json>\n")
+		os.Exit(1)
+	}
+	before, err := assets.LoadManifest(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	after, err := assets.LoadManifest(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	comparison := assets.CompareManifests(before, after)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(comparison)
+		return
+	}
+	comparison.WriteText(os.Stdout, *limit)
+}
+
+// cmdManifestKeygen creates an Ed25519 key for signing builds
+func cmdManifestKeygen(args []string) {
+	fs := flag.NewFlagSet("manifest keygen", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity manifest keygen <key file>\n")
+		os.Exit(1)
+	}
+	pub, err := assets.GenerateSigningKey(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s; keep it private. Public key (assets.verify_key):\n%s\n", fs.Arg(0), assets.EncodePublicKey(pub))
+}
+
+// cmdManifestSign signs a build's manifest and artifact list
+func cmdManifestSign(args []string) {
+	fs := flag.NewFlagSet("manifest sign", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	keyPath := fs.String("key", "", "private key file (default: assets.signing_key)")
+	fs.Parse(args)
+
+	cfg := loadCLIConfigFromFlags(*configPath, "")
+	if *keyPath == "" && cfg != nil {
+		*keyPath = cfg.Assets.SigningKey
+	}
+	if *keyPath == "" {
+		fmt.Fprintf(os.Stderr, "Error: no signing key; pass --key or set assets.signing_key\n")
+		os.Exit(1)
+	}
+	outputDir := fs.Arg(0)
+	if outputDir == "" {
+		outputDir = resolveAssetOutputDir(cfg, "")
+	}
+
+	key, err := assets.LoadSigningKey(*keyPath)
+	if err == nil {
+		err = assets.SignBuild(outputDir, key)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Signed %s\n", filepath.Join(outputDir, "manifest.json"))
+}
+
+// cmdManifestVerify checks a signed build against a public key
+func cmdManifestVerify(args []string) {
+	fs := flag.NewFlagSet("manifest verify", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	verifyKey := fs.String("key", "", "public key, or a file holding it (default: assets.verify_key)")
+	fs.Parse(args)
+
+	cfg := loadCLIConfigFromFlags(*configPath, "")
+	if *verifyKey == "" && cfg != nil {
+		*verifyKey = cfg.Assets.VerifyKey
+	}
+	if *verifyKey == "" {
+		fmt.Fprintf(os.Stderr, "Error: no public key; pass --key or set assets.verify_key\n")
+		os.Exit(1)
+	}
+	pub, err := assets.ParsePublicKey(*verifyKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	outputDir := fs.Arg(0)
+	if outputDir == "" {
+		outputDir = resolveAssetOutputDir(cfg, "")
+	}
+
+	bad, err := assets.VerifyBuild(outputDir, pub)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, name := range bad {
+		fmt.Printf("  missing or altered: %s\n", name)
+	}
+	if len(bad) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d pk3s don't match the signed manifest\n", len(bad))
+		os.Exit(1)
+	}
+	fmt.Println("Signatures and pk3s verified")
+}
+
+// cmdManifestGC deletes or archives generated pk3s the manifest no longer lists
+func cmdManifestGC(args []string) {
+	fs := flag.NewFlagSet("manifest gc", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	dryRun := fs.Bool("dry-run", false, "list what would be deleted, deleting nothing")
+	archive := fs.String("archive", "", "move collected pk3s to s3://bucket/prefix, gs://bucket/prefix, or a directory instead of just deleting them")
+	fs.Parse(args)
+
+	outputDir := fs.Arg(0)
+	if outputDir == "" {
+		outputDir = resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), "")
+	}
+	opts := assets.GCOptions{DryRun: *dryRun}
+	if *archive != "" {
+		backend, err := assets.ParseOutputBackend(*archive)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Archive = backend
+	}
+
+	result, err := assets.CollectGarbage(outputDir, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	artifactVerb, leftoverVerb := "removed", "removed"
+	if *dryRun {
+		artifactVerb, leftoverVerb = "would remove", "would remove"
+	} else if opts.Archive != nil {
+		artifactVerb = "archived"
+	}
+	for _, name := range result.Artifacts {
+		fmt.Printf("  %s %s\n", artifactVerb, name)
+	}
+	for _, name := range result.Leftovers {
+		fmt.Printf("  %s leftover %s\n", leftoverVerb, name)
+	}
+	fmt.Printf("GC: %d pk3s, %d leftovers, %.1f MB\n", len(result.Artifacts), len(result.Leftovers), float64(result.Bytes)/(1024*1024))
+}
+
+// cmdManifestOrphans lists unreferenced textures and sounds with size totals
+func cmdManifestOrphans(args []string) {
+	fs := flag.NewFlagSet("manifest orphans", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	game := fs.String("game", "baseq3", "game to report on")
+	strict := fs.Bool("strict", false, "ignore shaders no map, model, or skin references")
+	summary := fs.Bool("summary", false, "only print per-pk3 totals")
+	fs.Parse(args)
+
+	manifestPath := fs.Arg(0)
+	if manifestPath == "" {
+		manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+
+	report, err := assets.FindOrphans(gm, assets.OrphanOptions{Strict: *strict})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !*summary {
+		for _, f := range report.Files {
+			fmt.Fprintf(w, "%s\t%.1f KB\t%s\n", f.Path, float64(f.CompressedSize)/1024, filepath.Base(f.Pk3))
+		}
+		w.Flush()
+		fmt.Println()
+	}
+	pk3s := make([]string, 0, len(report.ByPk3))
+	for pk3 := range report.ByPk3 {
+		pk3s = append(pk3s, pk3)
+	}
+	sort.Slice(pk3s, func(i, j int) bool { return report.ByPk3[pk3s[i]] > report.ByPk3[pk3s[j]] })
+	for _, pk3 := range pk3s {
+		fmt.Fprintf(w, "%s\t%.1f MB\n", pk3, float64(report.ByPk3[pk3])/(1024*1024))
+	}
+	w.Flush()
+	fmt.Printf("%d orphaned files, %.1f MB in pk3s (%.1f MB uncompressed)\n", len(report.Files),
+		float64(report.CompressedSize)/(1024*1024), float64(report.Size)/(1024*1024))
+}
+
+// cmdManifestCase reports case mismatches between references and files,
+// optionally writing renamed copies of the affected pk3s
+func cmdManifestCase(args []string) {
+	fs := flag.NewFlagSet("manifest case", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	game := fs.String("game", "baseq3", "game to report on")
+	fixDir := fs.String("fix", "", "write pk3s with entries renamed to match their references into this directory")
+	fs.Parse(args)
+
+	manifestPath := fs.Arg(0)
+	if manifestPath == "" {
+		manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+
+	mismatches := assets.AuditCase(gm)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, m := range mismatches {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Reference, m.File, filepath.Base(m.Pk3), m.Referrer)
+	}
+	w.Flush()
+	fmt.Printf("%d case mismatches\n", len(mismatches))
+
+	if *fixDir != "" && len(mismatches) > 0 {
+		written, err := assets.FixCaseMismatches(mismatches, *fixDir)
+		for _, path := range written {
+			fmt.Printf("Wrote %s\n", path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// cmdManifestGraph exports a map's or model's dependency graph
+func cmdManifestGraph(args []string) {
+	fs := flag.NewFlagSet("manifest graph", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	game := fs.String("game", "baseq3", "game to resolve against")
+	mapName := fs.String("map", "", "map to graph")
+	model := fs.String("model", "", "md3 path or player model (name/skin) to graph")
+	format := fs.String("format", "dot", "output format: dot or json")
+	gametype := fs.Int("gametype", 0, "g_gametype whose default player model supplies missing sounds")
+	fs.Parse(args)
+
+	if (*mapName == "") == (*model == "") || (*format != "dot" && *format != "json") {
+		fmt.Fprintf(os.Stderr, "Usage: trinity manifest graph [--game G] [--gametype N] [--format dot|json] (--map M | --model P) [manifest.json]\n")
+		os.Exit(1)
+	}
+
+	manifestPath := fs.Arg(0)
+	if manifestPath == "" {
+		manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+
+	var graph *assets.DepGraph
+	if *mapName != "" {
+		graph, err = assets.MapDepGraph(strings.ToLower(*mapName), gm)
+	} else {
+		graph, err = assets.ModelDepGraph(*model, gm, manifest.DefaultPlayerModel(*game, *gametype))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(graph)
+	} else {
+		err = graph.WriteDOT(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// cmdManifestPlayer reports what player models are missing
+func cmdManifestPlayer(args []string) {
+	fs := flag.NewFlagSet("manifest player", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	game := fs.String("game", "baseq3", "game to check against")
+	manifestPath := fs.String("manifest", "", "manifest.json (default: from config)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity manifest player [--game G] [--manifest F] <model>...\n")
+		os.Exit(1)
+	}
+
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(resolveAssetOutputDir(loadCLIConfigFromFlags(*configPath, ""), ""), "manifest.json")
+	}
+	manifest, err := assets.LoadManifest(*manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	gm, ok := manifest.Games[*game]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+		os.Exit(1)
+	}
+
+	incomplete := 0
+	for _, name := range fs.Args() {
+		report, err := assets.ValidatePlayerModel(name, gm)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			incomplete++
+			continue
+		}
+		fmt.Printf("%s: skins %s\n", report.Model, strings.Join(report.Skins, ", "))
+		if report.Complete() {
+			fmt.Println("  complete")
+			continue
+		}
+		incomplete++
+		for _, m := range report.Missing {
+			line := fmt.Sprintf("  missing %s %s", m.Kind, m.Path)
+			if m.From != "" {
+				line += " (from " + m.From + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+	if incomplete > 0 {
+		os.Exit(1)
+	}
+}
+
+// cmdPk3List lists the entries of pk3 files
+func cmdPk3List(args []string) {
+	fs := flag.NewFlagSet("pk3 ls", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity pk3 ls <file.pk3>...\n")
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, path := range fs.Args() {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		if fs.NArg() > 1 {
+			fmt.Printf("%s:\n", path)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		var total uint64
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			total += f.UncompressedSize64
+			fmt.Fprintf(w, "%d\t %s\n", f.UncompressedSize64, f.Name)
+		}
+		w.Flush()
+		fmt.Printf("%d entries, %.1f MB uncompressed\n", len(r.File), float64(total)/(1024*1024))
+		r.Close()
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// pk3DirFlags registers the path policy flags shared by pk3 extract and pack
+func pk3DirFlags(fs *flag.FlagSet) *assets.Pk3DirOptions {
+	opts := &assets.Pk3DirOptions{}
+	fs.BoolVar(&opts.Lowercase, "lowercase", false, "lowercase all paths")
+	fs.BoolVar(&opts.StripSources, "strip-sources", false, "skip editor source files (.map, .xcf, .psd, ...)")
+	return opts
+}
+
+// cmdPk3Extract unpacks a pk3 into a directory
+func cmdPk3Extract(args []string) {
+	fs := flag.NewFlagSet("pk3 extract", flag.ExitOnError)
+	opts := pk3DirFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity pk3 extract [--lowercase] [--strip-sources] <file.pk3> <dir>\n")
+		os.Exit(1)
+	}
+	n, err := assets.ExtractPk3ToDir(fs.Arg(0), fs.Arg(1), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Extracted %d files to %s\n", n, fs.Arg(1))
+}
+
+// cmdPk3Pack builds a pk3 from a directory
+func cmdPk3Pack(args []string) {
+	fs := flag.NewFlagSet("pk3 pack", flag.ExitOnError)
+	opts := pk3DirFlags(fs)
+	fs.BoolVar(&opts.Split, "split", false, "continue in <out>_2.pk3, <out>_3.pk3, ... rather than exceed what the engine can read")
+	fs.BoolVar(&opts.Zip64, "zip64", false, "write one zip64 pk3 however large; the engine can't load it")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 || opts.Split && opts.Zip64 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity pk3 pack [--lowercase] [--strip-sources] [--split | --zip64] <dir> <out.pk3>\n")
+		os.Exit(1)
+	}
+	n, err := assets.BuildPk3FromDir(fs.Arg(0), fs.Arg(1), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Packed %d files into %s\n", n, fs.Arg(1))
+}
+
+// vfsFlags registers the flags shared by the vfs commands and returns a
+// function that opens the selected game's filesystem
+func vfsFlags(fs *flag.FlagSet) func() *assets.VFS {
+	configPath := fs.String("config", defaultConfigPath, "path to configuration file")
+	quake3Dir := fs.String("quake3-dir", "", "Quake 3 install (default: from config)")
+	game := fs.String("game", "baseq3", "game directory; mods are layered over baseq3")
+	manifestPath := fs.String("manifest", "", "read the file index from a demobake manifest instead of scanning pk3s")
+
+	return func() *assets.VFS {
+		if *manifestPath != "" {
+			manifest, err := assets.LoadManifest(*manifestPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			gm, ok := manifest.Games[*game]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Error: game %q not in manifest\n", *game)
+				os.Exit(1)
+			}
+			return assets.NewVFS(gm.FileIndex)
+		}
+
+		dir := *quake3Dir
+		if dir == "" {
+			if cfg := loadCLIConfigFromFlags(*configPath, ""); cfg != nil {
+				dir = cfg.Server.Quake3Dir
+			}
+		}
+		vfs, err := assets.OpenVFS(dir, *game)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return vfs
+	}
+}
+
+// cmdVFSList lists virtual files and the pk3 that supplies each
+func cmdVFSList(args []string) {
+	fs := flag.NewFlagSet("vfs ls", flag.ExitOnError)
+	open := vfsFlags(fs)
+	fs.Parse(args)
+
+	entries := open().List(fs.Arg(0))
+	if len(entries) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: %s: no such file or directory\n", fs.Arg(0))
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\n", e.Path, e.Pk3)
+	}
+	w.Flush()
+}
+
+// cmdVFSCat writes a virtual file to stdout
+func cmdVFSCat(args []string) {
+	fs := flag.NewFlagSet("vfs cat", flag.ExitOnError)
+	open := vfsFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity vfs cat [flags] <path>\n")
+		os.Exit(1)
+	}
+	data, err := open().ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(data)
+}
+
+// cmdVFSExtract extracts a virtual subtree to disk
+func cmdVFSExtract(args []string) {
+	fs := flag.NewFlagSet("vfs extract", flag.ExitOnError)
+	open := vfsFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: trinity vfs extract [flags] <path> <dir>\n")
+		os.Exit(1)
+	}
+	n, err := open().Extract(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Extracted %d files to %s\n", n, fs.Arg(1))
+}
@@ -0,0 +1,617 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ernie/trinity-tools/internal/assets"
+	"github.com/ernie/trinity-tools/internal/metrics"
+)
+
+const (
+	maxDemoUpload = 256 << 20
+	maxSyncDiff   = 4 << 20 // a client's pk3 list
+	maxDemoFrames = 1 << 20 // about seven hours at sv_fps 40
+	jobQueueSize  = 64
+	jobRetention  = time.Hour // how long finished jobs stay queryable
+)
+
+var (
+	demoIDPattern  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	mapNamePattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+)
+
+// AssetService exposes demo parsing and pk3 builds over HTTP, for running the
+// asset tools as a backend instead of a CLI:
+//
+//	POST /demos              upload a demo, returns its id and parsed info
+//	GET  /demos/{id}/assets  files and pk3s the demo needs, resolved against the manifest
+//	POST /mappak/{map}       queue a map pk3 build, returns a job
+//	GET  /mappak/{map}/explain  why each file is in the map pk3, as a tree
+//	GET  /resolve            the copy of ?path= a game (?game=) loads: its pk3, hash, and size
+//	GET  /jobs/{id}          job status
+//	GET  /manifest           the demobake manifest
+//	GET  /manifest.sig       its signature, if the build was signed
+//	GET  /maps               a game's maps with their titles, authors, and gametypes
+//	GET  /pure/{game}        the game's sv_pure pak list
+//	POST /sync/diff          pk3s a client must download or delete to match the manifest
+//	POST /intake             receive a finished recording (see EnableIntake)
+//
+// Builds run one at a time on a background worker. The manifest is reloaded
+// when its file changes (see ManifestHolder), and responses resolved against
+// it carry its generation in an X-Manifest-Generation header.
+type AssetService struct {
+	mux       *http.ServeMux
+	outputDir string // demobake output: manifest.json and maps/
+	demoDir   string
+	quake3Dir string
+	token     string
+	manifest  *ManifestHolder
+	mapPak    assets.MapPakOptions
+
+	intakeDir      string // where intake stores received demos
+	intakeTemplate string
+
+	mu     sync.Mutex
+	jobs   map[string]*AssetJob
+	active map[string]*AssetJob // queued or running build key → job
+	nextID int
+	queue  chan *AssetJob
+}
+
+// AssetJob is a queued build: a map pk3, or a demo pk3 when Demo is set.
+type AssetJob struct {
+	ID       string     `json:"id"`
+	Map      string     `json:"map"`
+	Game     string     `json:"game"`
+	Demo     string     `json:"demo,omitempty"`   // intake-relative demo path, or upload id, for demo pk3 builds
+	Upload   bool       `json:"upload,omitempty"` // Demo is an uploaded demo's id
+	Status   string     `json:"status"`           // queued, running, done, failed
+	Error    string     `json:"error,omitempty"`
+	Output   string     `json:"output,omitempty"` // output-relative path of the built pk3
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Diagnostics assets.Diagnostics `json:"diagnostics,omitempty"` // unresolved references in a map build
+
+	ManifestGeneration uint64 `json:"manifestGeneration,omitempty"` // manifest generation the build resolved against
+}
+
+// NewAssetService creates the service. Uploaded demos are stored in demoDir.
+// A non-empty token is required as a Bearer token on every request.
+func NewAssetService(outputDir, demoDir, quake3Dir, token string) *AssetService {
+	s := &AssetService{
+		mux:       http.NewServeMux(),
+		outputDir: outputDir,
+		demoDir:   demoDir,
+		quake3Dir: quake3Dir,
+		token:     token,
+		manifest:  NewManifestHolder(filepath.Join(outputDir, "manifest.json"), nil),
+		jobs:      make(map[string]*AssetJob),
+		active:    make(map[string]*AssetJob),
+		queue:     make(chan *AssetJob, jobQueueSize),
+	}
+
+	s.mux.HandleFunc("POST /demos", s.handleUploadDemo)
+	s.mux.HandleFunc("GET /demos/{id}/assets", s.handleDemoAssets)
+	s.mux.HandleFunc("POST /mappak/{map}", s.handleBuildMapPak)
+	s.mux.HandleFunc("GET /mappak/{map}/explain", s.handleExplainMapPak)
+	s.mux.HandleFunc("GET /resolve", s.handleResolve)
+	s.mux.HandleFunc("GET /jobs/{id}", s.handleGetJob)
+	s.mux.HandleFunc("GET /manifest", s.handleGetManifest)
+	s.mux.HandleFunc("GET /manifest.sig", s.handleGetManifestSignature)
+	s.mux.HandleFunc("GET /maps", s.handleListMaps)
+	s.mux.HandleFunc("GET /pure/{game}", s.handleGetPureList)
+	s.mux.HandleFunc("POST /sync/diff", s.handleSyncDiff)
+	s.mux.Handle("GET /metrics", metrics.Handler())
+	s.mux.HandleFunc("GET /health", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	return s
+}
+
+// SetMapPakOptions sets how the service builds map pk3s.
+func (s *AssetService) SetMapPakOptions(opts assets.MapPakOptions) {
+	s.mapPak = opts
+}
+
+// Run processes queued builds until stop is closed.
+func (s *AssetService) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case job := <-s.queue:
+			s.runJob(job)
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (s *AssetService) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.token != "" {
+		got := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, req)
+}
+
+// handleUploadDemo stores an uploaded demo under its content hash, parses it,
+// and queues its demo pk3 build
+func (s *AssetService) handleUploadDemo(w http.ResponseWriter, req *http.Request) {
+	if err := os.MkdirAll(s.demoDir, 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tmp, err := os.CreateTemp(s.demoDir, "upload-*.tmp")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	// Parse the demo as it streams in, rejecting oversized uploads before
+	// they're fully written
+	hash := sha256.New()
+	body := io.TeeReader(req.Body, io.MultiWriter(tmp, hash))
+	info, err := assets.ParseDemoReader(body, assets.DemoParseOptions{MaxSize: maxDemoUpload, MaxFrames: maxDemoFrames})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, assets.ErrDemoTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if trailer, err := assets.ReadDemoTrailer(tmp.Name()); err == nil {
+		info.Trailer = trailer
+	}
+	for _, warning := range info.Warnings {
+		log.Printf("Demo upload: %s", warning)
+	}
+
+	id := hex.EncodeToString(hash.Sum(nil))[:16]
+	if err := os.Rename(tmp.Name(), s.demoPath(id)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	job, err := s.queueJob(&AssetJob{Map: strings.ToLower(info.MapName), Game: info.FSGame, Demo: id, Upload: true})
+	if err != nil {
+		log.Printf("Warning: demo upload %s: demo pk3 not queued: %v", id, err)
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "demo": info, "job": job})
+}
+
+// handleDemoAssets resolves the files an uploaded demo needs
+func (s *AssetService) handleDemoAssets(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	if !demoIDPattern.MatchString(id) {
+		writeError(w, http.StatusBadRequest, "invalid demo id")
+		return
+	}
+	info, err := assets.ParseDemo(s.demoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "demo not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	manifest := s.manifest.getFor(w)
+	if manifest == nil {
+		writeError(w, http.StatusServiceUnavailable, "manifest not available")
+		return
+	}
+	game, needed, err := assets.ResolveDemoAssets(info, manifest)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	files := make([]string, 0, len(needed))
+	for path := range needed {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	resp := map[string]interface{}{
+		"map":   info.MapName,
+		"game":  game,
+		"files": files,
+	}
+	if _, pk3s, err := assets.RequiredDemoPk3s(info, manifest, assets.DemoPk3Options{OutputDir: s.outputDir, DemoPk3: "demos/" + id + ".pk3"}); err == nil {
+		resp["pk3s"] = pk3s
+	}
+	if _, vms, err := assets.DemoVMs(info, manifest); err == nil {
+		resp["vms"] = vms
+	} else {
+		resp["vmError"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleBuildMapPak queues a map pk3 build, or returns the pending job for
+// the same map
+func (s *AssetService) handleBuildMapPak(w http.ResponseWriter, req *http.Request) {
+	mapName := strings.ToLower(req.PathValue("map"))
+	if !mapNamePattern.MatchString(mapName) {
+		writeError(w, http.StatusBadRequest, "invalid map name")
+		return
+	}
+	game := req.URL.Query().Get("game")
+	if game == "" {
+		game = "baseq3"
+	}
+	if !mapNamePattern.MatchString(game) {
+		writeError(w, http.StatusBadRequest, "invalid game")
+		return
+	}
+	if s.manifest.getFor(w) == nil {
+		writeError(w, http.StatusServiceUnavailable, "manifest not available")
+		return
+	}
+
+	job, err := s.queueJob(&AssetJob{Map: mapName, Game: game})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// queueJob queues a build, or returns the pending job for the same output
+func (s *AssetService) queueJob(job *AssetJob) (*AssetJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if active, ok := s.active[job.key()]; ok {
+		return active, nil
+	}
+	s.pruneJobs()
+	s.nextID++
+	job.ID = strconv.Itoa(s.nextID)
+	job.Status = "queued"
+	job.Created = time.Now()
+	select {
+	case s.queue <- job:
+	default:
+		return nil, errors.New("build queue full")
+	}
+	s.jobs[job.ID] = job
+	s.active[job.key()] = job
+	return job, nil
+}
+
+// pruneJobs forgets jobs finished more than jobRetention ago; the caller
+// holds s.mu.
+func (s *AssetService) pruneJobs() {
+	cutoff := time.Now().Add(-jobRetention)
+	for id, job := range s.jobs {
+		if job.Finished != nil && job.Finished.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// key identifies what a job builds, so duplicate requests share a job
+func (j *AssetJob) key() string {
+	if j.Upload {
+		return "upload:" + j.Demo
+	}
+	if j.Demo != "" {
+		return "demo:" + j.Demo
+	}
+	return j.Game + "/" + j.Map
+}
+
+// handleExplainMapPak returns the tree of references that pull each file
+// into a map pk3
+func (s *AssetService) handleExplainMapPak(w http.ResponseWriter, req *http.Request) {
+	mapName := strings.ToLower(req.PathValue("map"))
+	if !mapNamePattern.MatchString(mapName) {
+		writeError(w, http.StatusBadRequest, "invalid map name")
+		return
+	}
+	game := req.URL.Query().Get("game")
+	if game == "" {
+		game = "baseq3"
+	}
+	manifest := s.manifest.getFor(w)
+	if manifest == nil {
+		writeError(w, http.StatusServiceUnavailable, "manifest not available")
+		return
+	}
+	gm, ok := manifest.Games[game]
+	if !ok {
+		writeError(w, http.StatusNotFound, "game not found")
+		return
+	}
+	tree, err := assets.ExplainMapPak(mapName, gm)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, tree)
+}
+
+// handleResolve reports the copy of a file a game loads, for debugging assets
+// clients report missing
+func (s *AssetService) handleResolve(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path required")
+		return
+	}
+	game := req.URL.Query().Get("game")
+	if game == "" {
+		game = "baseq3"
+	}
+	manifest := s.manifest.getFor(w)
+	if manifest == nil {
+		writeError(w, http.StatusServiceUnavailable, "manifest not available")
+		return
+	}
+	gm, ok := manifest.Games[game]
+	if !ok {
+		writeError(w, http.StatusNotFound, "game not found")
+		return
+	}
+	r, err := gm.ResolveFile(path)
+	var notFound *assets.ErrFileNotInIndex
+	if errors.As(err, &notFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Name source pk3s without the server's directory layout
+	if r.Pk3 != "" {
+		r.Pk3 = filepath.Base(r.Pk3)
+	}
+	if r.Texture != nil && r.Texture.Source != "" {
+		r.Texture.Source = filepath.Base(r.Texture.Source)
+	}
+	writeJSON(w, http.StatusOK, r)
+}
+
+// handleGetJob returns a build job's status
+func (s *AssetService) handleGetJob(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[req.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleGetManifest serves the demobake manifest
+func (s *AssetService) handleGetManifest(w http.ResponseWriter, req *http.Request) {
+	path := filepath.Join(s.outputDir, "manifest.json")
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, "manifest not found")
+		return
+	}
+	s.manifest.getFor(w)
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, req, path)
+}
+
+// handleGetManifestSignature serves the manifest's signature, for signed builds
+func (s *AssetService) handleGetManifestSignature(w http.ResponseWriter, req *http.Request) {
+	path := filepath.Join(s.outputDir, "manifest.json"+assets.SignatureExt)
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, "manifest is not signed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeFile(w, req, path)
+}
+
+// mapListing is one map in the GET /maps listing
+type mapListing struct {
+	Name string `json:"name"`
+	assets.MapInfo
+	Pk3  string `json:"pk3,omitempty"` // output-relative path of the built map pk3
+	Size int64  `json:"size,omitempty"`
+}
+
+// handleListMaps lists a game's maps (?game=, default baseq3) with the
+// titles, authors, and gametypes recorded in the manifest. ?gametype= keeps
+// only maps that support it.
+func (s *AssetService) handleListMaps(w http.ResponseWriter, req *http.Request) {
+	gametype := strings.ToLower(req.URL.Query().Get("gametype"))
+	game := req.URL.Query().Get("game")
+	if game == "" {
+		game = "baseq3"
+	}
+	manifest := s.manifest.getFor(w)
+	if manifest == nil {
+		writeError(w, http.StatusServiceUnavailable, "manifest not available")
+		return
+	}
+	gm, ok := manifest.Games[game]
+	if !ok {
+		writeError(w, http.StatusNotFound, "game not found")
+		return
+	}
+
+	names := gm.MapNames()
+	maps := make([]mapListing, 0, len(names))
+	for _, name := range names {
+		m := mapListing{Name: name}
+		if info := gm.Maps[name]; info != nil {
+			m.MapInfo = *info
+		}
+		if gametype != "" && !m.Supports(gametype) {
+			continue
+		}
+		rel := "maps/" + name + ".pk3"
+		if a, ok := manifest.Artifacts[rel]; ok {
+			m.Pk3 = rel
+			m.Size = a.Size
+		}
+		maps = append(maps, m)
+	}
+	writeJSON(w, http.StatusOK, maps)
+}
+
+// handleGetPureList serves a game's sv_pure pak list from the last baseline build
+func (s *AssetService) handleGetPureList(w http.ResponseWriter, req *http.Request) {
+	game := strings.ToLower(req.PathValue("game"))
+	if !mapNamePattern.MatchString(game) {
+		writeError(w, http.StatusBadRequest, "invalid game")
+		return
+	}
+	lists, err := assets.LoadPureLists(filepath.Join(s.outputDir, assets.PureListName))
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "pure lists not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	list, ok := lists[game]
+	if !ok {
+		writeError(w, http.StatusNotFound, "game not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleSyncDiff compares the pk3s a client holds, sent as
+// {"pk3s": [{"name", "sha256"}]} with the engine "checksum" in place of a
+// SHA-256 if need be, against the manifest's artifacts. A client that only
+// wants redistributable pk3s also sends "distributable": true.
+func (s *AssetService) handleSyncDiff(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Pk3s          []assets.SyncFile `json:"pk3s"`
+		Distributable bool              `json:"distributable"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxSyncDiff)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	manifest := s.manifest.getFor(w)
+	if manifest == nil {
+		writeError(w, http.StatusServiceUnavailable, "manifest not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, assets.DiffSync(manifest, body.Pk3s, body.Distributable))
+}
+
+// runJob builds a queued map or demo pk3 and records the outcome
+func (s *AssetService) runJob(job *AssetJob) {
+	s.setJobStatus(job, "running", "")
+
+	var output string
+	unlock, err := assets.LockOutput(s.outputDir)
+	if err == nil {
+		err = fmt.Errorf("manifest not available")
+		if manifest, generation := s.manifest.Get(); manifest != nil {
+			s.mu.Lock()
+			job.ManifestGeneration = generation
+			s.mu.Unlock()
+			if job.Demo != "" {
+				output, err = s.runDemoJob(job, manifest)
+			} else {
+				output, err = s.runMapJob(job, manifest)
+			}
+		}
+		unlock()
+	}
+	if err != nil {
+		log.Printf("Asset service: build %s failed: %v", job.key(), err)
+		s.setJobStatus(job, "failed", err.Error())
+		return
+	}
+	s.mu.Lock()
+	job.Output = output
+	s.mu.Unlock()
+	s.setJobStatus(job, "done", "")
+}
+
+// runMapJob builds a map pk3 and records it in the manifest, returning its
+// output-relative path. The caller holds the output lock.
+func (s *AssetService) runMapJob(job *AssetJob, manifest *assets.Manifest) (string, error) {
+	output := "maps/" + job.Map + ".pk3"
+	outputPath := filepath.Join(s.outputDir, filepath.FromSlash(output))
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return "", err
+	}
+	diags, err := assets.BuildMapPak(job.Map, job.Game, manifest, s.quake3Dir, outputPath, s.mapPak)
+	s.mu.Lock()
+	job.Diagnostics = diags
+	s.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	recorded, err := s.recordArtifact(func(saved *assets.Manifest) (bool, error) {
+		return saved.RecordMapPak(job.Game, job.Map, s.outputDir, &assets.Provenance{Trigger: "service"})
+	})
+	if err != nil || !recorded {
+		return "", err // nothing beyond the baseline, if no error
+	}
+	return output, nil
+}
+
+// recordArtifact records a built pk3 in a copy of the manifest read from
+// disk, not the one handlers share, and saves it if record reports it did;
+// the holder swaps the saved file in. The caller holds the output lock.
+func (s *AssetService) recordArtifact(record func(saved *assets.Manifest) (bool, error)) (bool, error) {
+	manifestPath := filepath.Join(s.outputDir, "manifest.json")
+	saved, err := assets.LoadManifest(manifestPath)
+	if err != nil {
+		return false, err
+	}
+	recorded, err := record(saved)
+	if err != nil || !recorded {
+		return false, err
+	}
+	return true, saved.Save(manifestPath)
+}
+
+func (s *AssetService) setJobStatus(job *AssetJob, status, errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.Status = status
+	job.Error = errMsg
+	if status == "done" || status == "failed" {
+		now := time.Now()
+		job.Finished = &now
+		delete(s.active, job.key())
+	}
+}
+
+func (s *AssetService) demoPath(id string) string {
+	return filepath.Join(s.demoDir, id+".tvd")
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ernie/trinity-tools/internal/assets"
+)
+
+// demoPk3Dir is the static subdirectory demobake writes to
+const demoPk3Dir = "demopk3s"
+
+// SetRedistributableOnly enables distribution mode: demo pk3s the manifest marks
+// as containing official id content are refused instead of served
+func (r *Router) SetRedistributableOnly(enabled bool) {
+	r.redistributableOnly = enabled
+	if enabled && r.demoManifest == nil {
+		r.demoManifest = NewManifestHolder(filepath.Join(r.staticDir, demoPk3Dir, "manifest.json"), nil)
+	}
+}
+
+// SetManifestKey makes the router check demo pk3s against a signed manifest:
+// the manifest must verify with pub, and each pk3 must match its signed hash,
+// or it isn't served
+func (r *Router) SetManifestKey(pub ed25519.PublicKey) {
+	r.demoManifest = NewManifestHolder(filepath.Join(r.staticDir, demoPk3Dir, "manifest.json"), pub)
+	r.verifiedPk3s = &verifiedCache{files: make(map[string]verifiedFile)}
+}
+
+// verifiedCache remembers demo pk3s that matched their signed hash, so each
+// is hashed once per change rather than per request
+type verifiedCache struct {
+	mu    sync.Mutex
+	files map[string]verifiedFile // output-relative path → file as verified
+}
+
+type verifiedFile struct {
+	modTime time.Time
+	size    int64
+	sha256  string
+}
+
+// isUnverifiedDemoPk3 reports whether a cleaned URL path names a demo pk3
+// that doesn't match its hash in the signed manifest. Fails closed if the
+// manifest is unavailable or its signature doesn't verify.
+func (r *Router) isUnverifiedDemoPk3(urlPath string) bool {
+	rel, ok := strings.CutPrefix(urlPath, "/"+demoPk3Dir+"/")
+	if !ok || !strings.HasSuffix(strings.ToLower(rel), ".pk3") {
+		return false
+	}
+	m, _ := r.demoManifest.Get()
+	if m == nil {
+		return true
+	}
+	want, ok := m.Artifacts[rel]
+	if !ok {
+		return true
+	}
+	fullPath := filepath.Join(r.staticDir, demoPk3Dir, filepath.FromSlash(rel))
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return false // nothing to serve; let the static handler answer
+	}
+
+	c := r.verifiedPk3s
+	c.mu.Lock()
+	v, ok := c.files[rel]
+	c.mu.Unlock()
+	if ok && v.modTime.Equal(info.ModTime()) && v.size == info.Size() && v.sha256 == want.SHA256 {
+		return false
+	}
+	if err := assets.VerifyArtifact(fullPath, want); err != nil {
+		log.Printf("Warning: refusing %s: %v", rel, err)
+		return true
+	}
+	c.mu.Lock()
+	c.files[rel] = verifiedFile{modTime: info.ModTime(), size: info.Size(), sha256: want.SHA256}
+	c.mu.Unlock()
+	return false
+}
+
+// isRestrictedDemoPk3 reports whether a cleaned URL path names a demo pk3 that
+// may not be served in distribution mode. Fails closed if the manifest is unavailable.
+func (r *Router) isRestrictedDemoPk3(urlPath string) bool {
+	rel, ok := strings.CutPrefix(urlPath, "/"+demoPk3Dir+"/")
+	if !ok || !strings.HasSuffix(strings.ToLower(rel), ".pk3") {
+		return false
+	}
+	m, _ := r.demoManifest.Get()
+	if m == nil {
+		return true
+	}
+	return m.IsRestricted(rel)
+}
+
+// refuseUnverified writes a 403 for a pk3 that doesn't match the signed manifest
+func refuseUnverified(w http.ResponseWriter) {
+	http.Error(w, "does not match the signed manifest", http.StatusForbidden)
+}
+
+// refuseRestricted writes a 403 for content that can't be redistributed
+func refuseRestricted(w http.ResponseWriter) {
+	http.Error(w, "not available for redistribution", http.StatusForbidden)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ernie/trinity-tools/internal/assets"
+)
+
+var intakeFieldPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,64}$`)
+
+// EnableIntake accepts finished recordings from game servers at POST /intake,
+// storing them under dir named by template: the assets.DemoNameFields of the
+// demo at receipt, plus {hash}, the start of its SHA-256. The service must
+// have a token, so that only game servers holding it can send demos; dir
+// should be somewhere that isn't served publicly.
+func (s *AssetService) EnableIntake(dir, template string) error {
+	if s.token == "" {
+		return errors.New("demo intake requires a token")
+	}
+	if dir == "" {
+		return errors.New("demo intake requires a directory")
+	}
+	if template == "" {
+		template = assets.DefaultIntakeTemplate
+	}
+	s.intakeDir = dir
+	s.intakeTemplate = template
+	s.mux.HandleFunc("POST /intake", s.handleIntake)
+	return nil
+}
+
+// handleIntake receives a finished demo from a game server, with its server
+// name and match id as query parameters. The demo is parsed in full to
+// validate it, stored under its templated name with a sidecar, and a demo pk3
+// build is queued for it.
+func (s *AssetService) handleIntake(w http.ResponseWriter, req *http.Request) {
+	server := req.URL.Query().Get("server")
+	match := req.URL.Query().Get("match")
+	if !intakeFieldPattern.MatchString(server) {
+		writeError(w, http.StatusBadRequest, "invalid server")
+		return
+	}
+	if !intakeFieldPattern.MatchString(match) {
+		writeError(w, http.StatusBadRequest, "invalid match")
+		return
+	}
+
+	if err := os.MkdirAll(s.intakeDir, 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tmp, err := os.CreateTemp(s.intakeDir, "intake-*.tmp")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), http.MaxBytesReader(w, req.Body, maxDemoUpload))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("upload failed: %v", err))
+		return
+	}
+
+	manifest := s.manifest.getFor(w)
+	sidecar, err := assets.BuildDemoSidecar(tmp.Name(), manifest)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid demo: %v", err))
+		return
+	}
+	if sidecar.Map == "" {
+		writeError(w, http.StatusBadRequest, "invalid demo: no map")
+		return
+	}
+	sidecar.Server = server
+	sidecar.MatchID = match
+
+	fields := assets.DemoNameFields(sidecar, time.Now())
+	fields["hash"] = hex.EncodeToString(hash.Sum(nil))[:16]
+	name, err := assets.ExpandDemoName(s.intakeTemplate, fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	dest := filepath.Join(s.intakeDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Linking claims the name atomically: of two uploads with the same name,
+	// the second gets a conflict rather than replacing the first
+	if err := os.Link(tmp.Name(), dest); errors.Is(err, fs.ErrExist) {
+		writeError(w, http.StatusConflict, "demo already exists: "+name)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := assets.WriteDemoSidecar(dest, sidecar); err != nil {
+		log.Printf("Warning: intake %s: %v", name, err)
+	}
+	log.Printf("Intake: stored %s from %s (match %s)", name, server, match)
+
+	job, err := s.queueJob(&AssetJob{Map: sidecar.Map, Game: fields["game"], Demo: name})
+	if err != nil {
+		log.Printf("Warning: intake %s: demo pk3 not queued: %v", name, err)
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"path": name, "demo": sidecar, "job": job})
+}
+
+// runDemoJob builds the demo pk3 for a demo received by intake or uploaded,
+// and records it in the manifest, so distribution mode and signed manifests
+// serve it. The demo's map pk3, when built, supplies the map's files. The
+// caller holds the output lock.
+func (s *AssetService) runDemoJob(job *AssetJob, manifest *assets.Manifest) (string, error) {
+	demoPath, trigger := filepath.Join(s.intakeDir, filepath.FromSlash(job.Demo)), "intake"
+	if job.Upload {
+		demoPath, trigger = s.demoPath(job.Demo), "service"
+	}
+	info, err := assets.ParseDemo(demoPath)
+	if err != nil {
+		return "", err
+	}
+	mapPk3Path := filepath.Join(s.outputDir, "maps", strings.ToLower(info.MapName)+".pk3")
+	if _, err := os.Stat(mapPk3Path); err != nil {
+		mapPk3Path = ""
+	}
+	output := "demos/" + strings.TrimSuffix(job.Demo, filepath.Ext(job.Demo)) + ".pk3"
+	outputPath := filepath.Join(s.outputDir, filepath.FromSlash(output))
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return "", err
+	}
+	if err := assets.BuildDemoPak(info, manifest, mapPk3Path, outputPath); err != nil {
+		return "", err
+	}
+	game, _, _ := manifest.GameFor(info.FSGame)
+	recorded, err := s.recordArtifact(func(saved *assets.Manifest) (bool, error) {
+		return saved.RecordDemoPak(game, output, s.outputDir, &assets.Provenance{Trigger: trigger, Demo: job.Demo})
+	})
+	if err != nil || !recorded {
+		return "", err // nothing beyond the baseline and map pk3, if no error
+	}
+	return output, nil
+}
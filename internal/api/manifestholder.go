@@ -0,0 +1,147 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ernie/trinity-tools/internal/assets"
+)
+
+// ManifestGenerationHeader names the response header carrying the generation
+// of the manifest a response was resolved against
+const ManifestGenerationHeader = "X-Manifest-Generation"
+
+// manifestRetryInterval is how often a manifest that failed to load is read
+// again while neither it nor its signature changes
+const manifestRetryInterval = 5 * time.Second
+
+// ManifestHolder holds a demobake manifest for request handlers, and swaps
+// in the new one when watch mode or a build rewrites the file. The swap is
+// an atomic pointer store: requests already holding the old manifest finish
+// with it, later ones get the new one, and none wait on the reload. Each
+// manifest loaded gets the next generation number, so clients can tell
+// which build answered them. Generations count from 1 each time the process
+// starts, so they only order the manifests one process has served.
+//
+// A manifest that fails to load, such as one whose signature hasn't been
+// written yet, doesn't replace the last good one; it's retried when the
+// manifest or its signature changes, and every manifestRetryInterval.
+type ManifestHolder struct {
+	path string
+	key  ed25519.PublicKey // if set, the manifest must be signed by it
+
+	current    atomic.Pointer[heldManifest]
+	reloading  sync.Mutex // held while reading the file
+	generation uint64     // last generation handed out; guarded by reloading
+}
+
+// heldManifest is a loaded manifest and the files it was loaded from
+type heldManifest struct {
+	manifest   *assets.Manifest // nil if the file is missing or never loaded
+	generation uint64           // 0 with no manifest
+	stamp      manifestStamp
+	failed     time.Time // when the file last failed to load, if it did
+}
+
+// manifestStamp identifies the versions of a manifest and its signature
+type manifestStamp struct {
+	modTime, sigModTime time.Time
+	size, sigSize       int64
+}
+
+// NewManifestHolder creates a holder for the manifest at path, loaded on
+// first use. If key is set, the manifest must verify with it.
+func NewManifestHolder(path string, key ed25519.PublicKey) *ManifestHolder {
+	return &ManifestHolder{path: path, key: key}
+}
+
+// Get returns the current manifest and its generation, first swapping in
+// the file's if it changed. While another request is reloading, Get returns
+// the manifest being replaced rather than waiting. The manifest is nil if
+// the file is missing or no version of it has loaded.
+func (h *ManifestHolder) Get() (*assets.Manifest, uint64) {
+	held := h.current.Load()
+	if held != nil && !h.stale(held) {
+		return held.manifest, held.generation
+	}
+	if held != nil && !h.reloading.TryLock() {
+		return held.manifest, held.generation
+	}
+	if held == nil {
+		h.reloading.Lock()
+	}
+	defer h.reloading.Unlock()
+
+	// Another request may have reloaded while this one waited
+	if held = h.current.Load(); held == nil || h.stale(held) {
+		held = h.load(held)
+		h.current.Store(held)
+	}
+	return held.manifest, held.generation
+}
+
+// stale reports whether held should be reloaded: the manifest or its
+// signature changed, or it failed to load a while ago.
+func (h *ManifestHolder) stale(held *heldManifest) bool {
+	if !held.failed.IsZero() && time.Since(held.failed) >= manifestRetryInterval {
+		return true
+	}
+	return h.stamp() != held.stamp
+}
+
+// stamp returns the current stamp of the manifest and its signature; a
+// missing file has a zero stamp.
+func (h *ManifestHolder) stamp() manifestStamp {
+	var s manifestStamp
+	if info, err := os.Stat(h.path); err == nil {
+		s.modTime, s.size = info.ModTime(), info.Size()
+	}
+	if info, err := os.Stat(h.path + assets.SignatureExt); err == nil {
+		s.sigModTime, s.sigSize = info.ModTime(), info.Size()
+	}
+	return s
+}
+
+// load reads the manifest file, keeping prev's manifest if it fails; the
+// caller holds reloading.
+func (h *ManifestHolder) load(prev *heldManifest) *heldManifest {
+	held := &heldManifest{stamp: h.stamp()}
+	if held.stamp.modTime.IsZero() {
+		return held
+	}
+	var m *assets.Manifest
+	var err error
+	if h.key != nil {
+		m, err = assets.VerifyManifestFile(h.path, h.key)
+	} else {
+		m, err = assets.LoadManifest(h.path)
+	}
+	if err != nil {
+		log.Printf("Failed to load manifest %s: %v", h.path, err)
+		held.failed = time.Now()
+		if prev != nil {
+			held.manifest, held.generation = prev.manifest, prev.generation
+		}
+		return held
+	}
+	h.generation++
+	held.manifest, held.generation = m, h.generation
+	log.Printf("Loaded manifest %s (generation %d)", h.path, held.generation)
+	return held
+}
+
+// getFor is Get for a request handler: it also sets the response's
+// ManifestGenerationHeader when there is a manifest.
+func (h *ManifestHolder) getFor(w http.ResponseWriter) *assets.Manifest {
+	m, generation := h.Get()
+	if m != nil {
+		w.Header().Set(ManifestGenerationHeader, strconv.FormatUint(generation, 10))
+	}
+	return m
+}
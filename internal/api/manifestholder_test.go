@@ -0,0 +1,147 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ernie/trinity-tools/internal/assets"
+)
+
+// writeTestManifest saves a manifest listing one artifact of size n, and
+// stamps it (and its signature, if key is set) with a modification time of
+// its own, so the holder sees every write however fast they come.
+func writeTestManifest(t *testing.T, dir string, n int64, key ed25519.PrivateKey) {
+	t.Helper()
+	path := filepath.Join(dir, "manifest.json")
+	m := &assets.Manifest{Artifacts: map[string]assets.Artifact{"maps/a.pk3": {Size: n}}}
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Unix(1700000000+n, 0)
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		signTestManifest(t, dir, key, when)
+	}
+}
+
+func signTestManifest(t *testing.T, dir string, key ed25519.PrivateKey, when time.Time) {
+	t.Helper()
+	if err := assets.SignBuild(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "manifest.json"+assets.SignatureExt), when, when); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func artifactSize(m *assets.Manifest) int64 {
+	if m == nil {
+		return -1
+	}
+	return m.Artifacts["maps/a.pk3"].Size
+}
+
+func TestManifestHolderKeepsLastGood(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+	h := NewManifestHolder(path, nil)
+	if m, gen := h.Get(); m != nil || gen != 0 {
+		t.Fatalf("Get with no file = %v, %d", m, gen)
+	}
+
+	writeTestManifest(t, dir, 1, nil)
+	if m, gen := h.Get(); artifactSize(m) != 1 || gen != 1 {
+		t.Fatalf("Get = size %d, generation %d; want 1, 1", artifactSize(m), gen)
+	}
+
+	// A manifest that doesn't parse leaves the last good one in place
+	if err := os.WriteFile(path, []byte(`{"artifacts":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, gen := h.Get(); artifactSize(m) != 1 || gen != 1 {
+		t.Errorf("Get after a bad write = size %d, generation %d; want 1, 1", artifactSize(m), gen)
+	}
+
+	writeTestManifest(t, dir, 2, nil)
+	if m, gen := h.Get(); artifactSize(m) != 2 || gen != 2 {
+		t.Errorf("Get after a rewrite = size %d, generation %d; want 2, 2", artifactSize(m), gen)
+	}
+}
+
+func TestManifestHolderWaitsForSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	h := NewManifestHolder(filepath.Join(dir, "manifest.json"), pub)
+
+	// A build writes the manifest, then its signature; a request between
+	// the two mustn't leave the holder refusing the signed manifest
+	writeTestManifest(t, dir, 1, nil)
+	if m, _ := h.Get(); m != nil {
+		t.Fatal("unsigned manifest loaded")
+	}
+	signTestManifest(t, dir, priv, time.Unix(1700000001, 0))
+	if m, gen := h.Get(); artifactSize(m) != 1 || gen != 1 {
+		t.Fatalf("Get once signed = size %d, generation %d; want 1, 1", artifactSize(m), gen)
+	}
+
+	// The next build's manifest, before its signature, keeps the old one
+	writeTestManifest(t, dir, 2, nil)
+	if m, gen := h.Get(); artifactSize(m) != 1 || gen != 1 {
+		t.Errorf("Get before re-signing = size %d, generation %d; want 1, 1", artifactSize(m), gen)
+	}
+	signTestManifest(t, dir, priv, time.Unix(1700000002, 0))
+	if m, gen := h.Get(); artifactSize(m) != 2 || gen != 2 {
+		t.Errorf("Get once re-signed = size %d, generation %d; want 2, 2", artifactSize(m), gen)
+	}
+}
+
+func TestManifestHolderConcurrentReload(t *testing.T) {
+	dir := t.TempDir()
+	writeTestManifest(t, dir, 1, nil)
+	h := NewManifestHolder(filepath.Join(dir, "manifest.json"), nil)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last uint64
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				m, gen := h.Get()
+				if m == nil {
+					t.Error("Get returned no manifest during reloads")
+					return
+				}
+				if gen < last {
+					t.Errorf("generation went back from %d to %d", last, gen)
+					return
+				}
+				last = gen
+			}
+		}()
+	}
+	for n := int64(2); n <= 50; n++ {
+		writeTestManifest(t, dir, n, nil)
+	}
+	close(stop)
+	wg.Wait()
+
+	if m, _ := h.Get(); artifactSize(m) != 50 {
+		t.Errorf("final manifest has size %d, want 50", artifactSize(m))
+	}
+}
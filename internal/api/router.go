@@ -23,6 +23,10 @@ type Router struct {
 	auth      *auth.Service
 	staticDir string
 	quake3Dir string
+
+	redistributableOnly bool
+	demoManifest        *ManifestHolder
+	verifiedPk3s        *verifiedCache // demo pk3s checked against a signed manifest, if one is required
 }
 
 // NewRouter creates a new HTTP router
@@ -146,6 +150,15 @@ func (r *Router) handleStatic(w http.ResponseWriter, req *http.Request) {
 		path = "/index.html"
 	}
 
+	if r.redistributableOnly && r.isRestrictedDemoPk3(filepath.ToSlash(path)) {
+		refuseRestricted(w)
+		return
+	}
+	if r.verifiedPk3s != nil && r.isUnverifiedDemoPk3(filepath.ToSlash(path)) {
+		refuseUnverified(w)
+		return
+	}
+
 	// Construct full file path
 	fullPath := filepath.Join(r.staticDir, path)
 
@@ -0,0 +1,212 @@
+package assets
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// buildBaselineAtomic is BuildBaseline for BuildOptions.Atomic: it builds
+// into a staging directory beside outputDir, carries over what the build
+// doesn't produce (demo map pk3s, signatures, anything else kept there),
+// syncs it all to disk, and only then swaps it in. A failed build leaves
+// outputDir as it was.
+//
+// If outputDir is a symlink, the swap replaces the link in one rename and
+// readers never see a gap. Otherwise outputDir is renamed aside and the
+// staging directory renamed into its place, so for a moment it doesn't
+// exist; point servers at a symlink to avoid even that.
+func buildBaselineAtomic(quake3Dir, outputDir string, opts BuildOptions) (Diagnostics, error) {
+	outputDir = filepath.Clean(outputDir)
+	linkTarget := ""
+	if info, err := os.Lstat(outputDir); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if linkTarget, err = filepath.EvalSymlinks(outputDir); err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", outputDir, err)
+		}
+	}
+
+	// Stage on the same filesystem as the live directory, so renames and
+	// hard links work
+	stageParent := filepath.Dir(outputDir)
+	if linkTarget != "" {
+		stageParent = filepath.Dir(linkTarget)
+	}
+	if err := os.MkdirAll(stageParent, 0755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", stageParent, err)
+	}
+	staging, err := os.MkdirTemp(stageParent, "."+filepath.Base(outputDir)+".staging-")
+	if err != nil {
+		return nil, fmt.Errorf("create staging dir: %w", err)
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+
+	live := outputDir
+	if linkTarget != "" {
+		live = linkTarget
+	}
+	opts.shaderCacheDir = live
+	diags, err := buildBaseline(quake3Dir, staging, opts)
+	if err == nil {
+		err = carryOver(live, staging)
+	}
+	if err == nil {
+		err = syncTree(staging)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return diags, err
+	}
+
+	if linkTarget != "" {
+		err = swapSymlink(outputDir, staging, linkTarget)
+	} else {
+		err = swapDir(outputDir, staging)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return diags, err
+	}
+	log.Printf("Swapped new build into %s", outputDir)
+	return diags, nil
+}
+
+// carryOver hard-links (or, across filesystems, copies) into staging every
+// file under live the new build didn't write, so the swap only replaces
+// what the build produced, as an in-place build would.
+func carryOver(live, staging string) error {
+	return filepath.WalkDir(live, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == live && os.IsNotExist(err) {
+				return filepath.SkipDir // first build
+			}
+			return err
+		}
+		rel, err := filepath.Rel(live, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		dest := filepath.Join(staging, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if _, err := os.Lstat(dest); err == nil {
+			return nil // rebuilt
+		}
+		if err := os.Link(p, dest); err != nil {
+			if err := copyFile(p, dest); err != nil {
+				return fmt.Errorf("carry over %s: %w", rel, err)
+			}
+		}
+		return nil
+	})
+}
+
+// syncTree flushes every file and directory under dir to disk, so a crash
+// after the swap can't leave a live build with missing contents.
+func syncTree(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			syncDir(p)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sync %s: %w", p, err)
+		}
+		return nil
+	})
+}
+
+// swapDir renames live aside and staging into its place, renaming live back
+// if that fails, then removes the old build.
+func swapDir(live, staging string) error {
+	previous := live + ".previous"
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("remove %s: %w", previous, err)
+	}
+	hadLive := true
+	if err := os.Rename(live, previous); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("move %s aside: %w", live, err)
+		}
+		hadLive = false
+	}
+	if err := os.Rename(staging, live); err != nil {
+		if hadLive {
+			if rbErr := os.Rename(previous, live); rbErr != nil {
+				return fmt.Errorf("swap in build: %w (and restoring %s failed: %v)", err, live, rbErr)
+			}
+		}
+		return fmt.Errorf("swap in build: %w", err)
+	}
+	syncDir(filepath.Dir(live))
+	if hadLive {
+		if err := os.RemoveAll(previous); err != nil {
+			log.Printf("Warning: failed to remove previous build %s: %v", previous, err)
+		}
+	}
+	return nil
+}
+
+// swapSymlink renames staging to a timestamped build directory and points
+// the symlink live at it in one rename. The build it pointed at before is
+// removed if an earlier swap made it; a directory set up by hand is left.
+func swapSymlink(live, staging, oldTarget string) error {
+	buildPrefix := filepath.Base(live) + "-build-"
+	target, err := filepath.Abs(filepath.Join(filepath.Dir(staging), buildPrefix+time.Now().UTC().Format("20060102T150405.000")))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(staging, target); err != nil {
+		return fmt.Errorf("name build: %w", err)
+	}
+	tmpLink := live + ".swap"
+	os.Remove(tmpLink)
+	if err := os.Symlink(target, tmpLink); err != nil {
+		os.Rename(target, staging)
+		return fmt.Errorf("create symlink: %w", err)
+	}
+	if err := os.Rename(tmpLink, live); err != nil {
+		os.Remove(tmpLink)
+		os.Rename(target, staging)
+		return fmt.Errorf("swap symlink: %w", err)
+	}
+	syncDir(filepath.Dir(live))
+	if strings.HasPrefix(filepath.Base(oldTarget), buildPrefix) {
+		if err := os.RemoveAll(oldTarget); err != nil {
+			log.Printf("Warning: failed to remove previous build %s: %v", oldTarget, err)
+		}
+	} else {
+		log.Printf("%s now points at %s; %s was left in place", live, target, oldTarget)
+	}
+	return nil
+}
+
+// syncDir flushes a directory's entries, making renames in it durable. Not
+// every platform can sync a directory, so failures are ignored.
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		f.Sync()
+		f.Close()
+	}
+}
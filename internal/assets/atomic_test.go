@@ -0,0 +1,90 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildBaselineAtomic(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	parent := t.TempDir()
+	out := filepath.Join(parent, "demopk3s")
+	if _, err := BuildBaseline(q, out, BuildOptions{Atomic: true}); err != nil {
+		t.Fatalf("first atomic build: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "maps", "q3dm0.pk3")); err != nil {
+		t.Fatalf("first build not in place: %v", err)
+	}
+
+	// Files the build doesn't write survive a rebuild
+	kept := filepath.Join(out, "maps", "demo-only.pk3")
+	if err := os.WriteFile(kept, []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BuildBaseline(q, out, BuildOptions{Atomic: true}); err != nil {
+		t.Fatalf("second atomic build: %v", err)
+	}
+	if data, err := os.ReadFile(kept); err != nil || string(data) != "kept" {
+		t.Errorf("carried over file = %q, %v", data, err)
+	}
+
+	// A failed build leaves the output untouched, and no staging behind
+	if _, err := BuildBaseline(t.TempDir(), out, BuildOptions{Atomic: true}); err == nil {
+		t.Fatal("build of an empty install succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(out, "manifest.json")); err != nil {
+		t.Errorf("failed build disturbed the output: %v", err)
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "demopk3s" && e.Name() != ".demopk3s.lock" {
+			t.Errorf("left beside the output: %s", e.Name())
+		}
+	}
+}
+
+func TestBuildBaselineAtomicSymlink(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	parent := t.TempDir()
+	first := filepath.Join(parent, "initial")
+	if err := os.Mkdir(first, 0755); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(parent, "demopk3s")
+	if err := os.Symlink(first, out); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	for i := range 2 {
+		if _, err := BuildBaseline(q, out, BuildOptions{Atomic: true}); err != nil {
+			t.Fatalf("atomic build %d: %v", i, err)
+		}
+	}
+	target, err := os.Readlink(out)
+	if err != nil {
+		t.Fatalf("output is no longer a symlink: %v", err)
+	}
+	if !strings.HasPrefix(filepath.Base(target), "demopk3s-build-") {
+		t.Errorf("symlink points at %s", target)
+	}
+	if _, err := os.Stat(filepath.Join(out, "manifest.json")); err != nil {
+		t.Errorf("no manifest through the symlink: %v", err)
+	}
+	// The hand-made first target is kept; the first swap's build is gone
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 4 {
+		t.Errorf("parent holds %v, want initial, the symlink, one build, and the lock file", names)
+	}
+}
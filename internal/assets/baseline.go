@@ -1,150 +1,280 @@
 package assets
 
 import (
-	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
-// Prefix whitelist for baseline pk3 files
-var baselinePrefixes = []string{
-	"gfx/",
-	"sprites/",
-	"icons/",
-	"fonts/",
-	"menu/",
-	"ui/",
-	"botfiles/",
-	"models/weapons/",
-	"models/weapons2/",
-	"models/weaphits/",
-	"models/powerups/",
-	"models/mapobjects/",
-	"models/flags/",
-	"models/ammo/",
-	"models/gibs/",
-	"models/misc/",
-	"sound/",
-	"scripts/",
-	"vm/",
-	"textures/sfx/",
-	"textures/effects/",
-	"textures/sfx2/",
-	"textures/effects2/",
-	"textures/ctf2/",
-	"team_icon/",
-	"models/players/",
+// BuildOptions tunes a baseline build. The zero value uses the built-in defaults.
+type BuildOptions struct {
+	Policy     *BaselinePolicy   // nil = DefaultBaselinePolicy()
+	Substitute *Substitution     // replace official id files in base game baselines
+	LooseFiles bool              // also index loose files in game directories, over their pk3s
+	GameBases  map[string]string // game → game it's layered over (default baseq3)
+	MapPak     MapPakOptions     // how each map pk3 is built
+
+	// Roots are further installs merged under the Quake 3 directory, highest
+	// priority first (see CollectGamePk3sFromRoots). The manifest records
+	// them, and which of their pk3s were shadowed.
+	Roots []string
+
+	Compression string // baseline pk3 compression level (see ParseCompression)
+
+	// TextureOverrides forces texture references to particular files in
+	// every game that has them, ahead of the .tga/.jpg/.png search order
+	// (see LoadTextureOverrides). The manifest keeps them, so map pk3s built
+	// from it later resolve the same way.
+	TextureOverrides map[string]string
+
+	// Textures is how texture references resolve: the image extensions
+	// tried, and whether .dds and .ktx containers are included or preferred.
+	// Like the overrides, the manifest keeps it.
+	Textures TextureSearch
+
+	// Distributable leaves out every pk3 containing official id content,
+	// baselines and map pk3s alike, so the output can be published as is.
+	// Each is reported as a DiagRestricted warning.
+	Distributable bool
+
+	// Levelshots, if set, also exports resized levelshots (see ExportLevelshots).
+	Levelshots *LevelshotExportOptions
+
+	// DryRun, if set, makes BuildBaseline resolve everything as usual but
+	// write nothing: each pk3 it would write is recorded here instead, and
+	// no manifest or pure lists are saved.
+	DryRun *BuildPlan
+
+	// Atomic builds into a staging directory and swaps it in only once the
+	// build succeeds, so a failed or interrupted run never leaves a
+	// half-updated output for servers to serve (see buildBaselineAtomic).
+	Atomic bool
+
+	shaderCacheDir string // where the shader cache is read from, if not the output directory
 }
 
-// baselineExcludePrefixes are prefixes explicitly excluded from baseline
-var baselineExcludePrefixes = []string{
-	"textures/",
-	"maps/",
-	"env/",
-	"levelshots/",
-	"demos/",
-	"video/",
-	"music/",
-	"models/players/",
+// BuildSettings are the build options an artifact's contents depend on, as
+// the manifest records them. Texture overrides and the texture search are
+// kept per game instead (see GameManifest).
+type BuildSettings struct {
+	Policy      *BaselinePolicy   `json:"policy,omitempty"`
+	Substitute  *Substitution     `json:"substitute,omitempty"`
+	LooseFiles  bool              `json:"looseFiles,omitempty"`
+	GameBases   map[string]string `json:"gameBases,omitempty"`
+	Compression string            `json:"compression,omitempty"`
+	MapPak      MapPakOptions     `json:"mapPak"`
 }
 
-// BuildBaseline builds baseline pk3s, Trinity pk3 copies, manifest, and all map pk3s.
-func BuildBaseline(quake3Dir, outputDir string) error {
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("create output dir: %w", err)
+func newBuildSettings(opts BuildOptions) *BuildSettings {
+	return &BuildSettings{
+		Policy:      opts.Policy,
+		Substitute:  opts.Substitute,
+		LooseFiles:  opts.LooseFiles,
+		GameBases:   opts.GameBases,
+		Compression: opts.Compression,
+		MapPak:      opts.MapPak,
 	}
-	if err := os.MkdirAll(filepath.Join(outputDir, "maps"), 0755); err != nil {
-		return fmt.Errorf("create maps dir: %w", err)
+}
+
+// buildOptions returns the options to rebuild a manifest's artifacts with:
+// those it was built with, or the defaults if it doesn't say. A substitution
+// table's sources are looked for where the build found them.
+func (m *Manifest) buildOptions() (BuildOptions, error) {
+	b := m.Build
+	if b == nil {
+		return BuildOptions{}, nil
+	}
+	opts := BuildOptions{
+		Policy:      b.Policy,
+		LooseFiles:  b.LooseFiles,
+		GameBases:   b.GameBases,
+		Compression: b.Compression,
+		MapPak:      b.MapPak,
+	}
+	if b.Substitute != nil {
+		sub := &Substitution{Sources: b.Substitute.Sources, Map: b.Substitute.Map}
+		if err := sub.buildIndex(); err != nil {
+			return opts, err
+		}
+		opts.Substitute = sub
+	}
+	return opts, nil
+}
+
+// BuildBaseline builds baseline pk3s, Trinity pk3 copies, manifest, and all
+// map pk3s. It returns the problems found along the way: unreadable pk3s,
+// map pk3s that failed to build, and references the maps' pk3s lack. These
+// don't fail the build, which the caller can decide to do.
+func BuildBaseline(quake3Dir, outputDir string, opts BuildOptions) (Diagnostics, error) {
+	if opts.DryRun != nil {
+		return buildBaseline(quake3Dir, outputDir, opts)
+	}
+	unlock, err := LockOutput(outputDir)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
+	return buildBaselineLocked(quake3Dir, outputDir, opts)
+}
 
-	gamePk3s := CollectGamePk3s(quake3Dir)
+// buildBaselineLocked is BuildBaseline for a caller holding the output lock.
+func buildBaselineLocked(quake3Dir, outputDir string, opts BuildOptions) (Diagnostics, error) {
+	if opts.Atomic && opts.DryRun == nil {
+		return buildBaselineAtomic(quake3Dir, outputDir, opts)
+	}
+	return buildBaseline(quake3Dir, outputDir, opts)
+}
+
+// buildBaseline builds into outputDir in place.
+func buildBaseline(quake3Dir, outputDir string, opts BuildOptions) (Diagnostics, error) {
+	if opts.Policy == nil {
+		opts.Policy = DefaultBaselinePolicy()
+	}
+	var diags Diagnostics
+	plan := opts.DryRun
+	if plan == nil {
+		defer baselineBuildSeconds.ObserveSince(time.Now())
+	}
+	if err := checkMusicEncoder(opts.MapPak); err != nil && plan == nil {
+		return diags, err
+	}
+
+	if plan == nil {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return diags, fmt.Errorf("create output dir: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Join(outputDir, "maps"), 0755); err != nil {
+			return diags, fmt.Errorf("create maps dir: %w", err)
+		}
+	}
+
+	shaderCache := loadShaderCache(shaderCachePath(outputDir, opts))
+
+	gamePk3s, shadowed := collectRootSources(quake3Dir, opts)
 	if len(gamePk3s) == 0 {
-		return fmt.Errorf("no game directories found in %s", quake3Dir)
+		return diags, fmt.Errorf("no game directories found in %s", quake3Dir)
 	}
 
 	manifest := &Manifest{
 		Games: make(map[string]*GameManifest),
+		Build: newBuildSettings(opts),
+	}
+	if len(opts.Roots) > 0 {
+		manifest.Roots = buildRoots(quake3Dir, opts)
 	}
 
-	// Process each game directory
-	for _, game := range []string{"baseq3", "missionpack"} {
-		pk3s, ok := gamePk3s[game]
-		if !ok {
-			continue
+	workshop := make(map[string]string)
+	for _, root := range buildRoots(quake3Dir, opts) {
+		if IsQuakeLiveDir(root) {
+			items := CollectWorkshopPk3s(root)
+			log.Printf("Quake Live layout detected in %s (%d workshop pk3s)", root, len(items))
+			maps.Copy(workshop, items)
 		}
+	}
+
+	gameNames := make([]string, 0, len(gamePk3s))
+	for game := range gamePk3s {
+		gameNames = append(gameNames, game)
+	}
+	gameNames = orderGames(gameNames)
+
+	// Process each game directory
+	for _, game := range gameNames {
+		pk3s := gamePk3s[game]
 
 		log.Printf("Processing %s (%d pk3s)...", game, len(pk3s))
 
-		gm, err := buildGameBaseline(game, pk3s, outputDir)
+		gm, gameDiags, err := buildGameBaseline(game, pk3s, outputDir, opts, shaderCache)
+		diags = append(diags, gameDiags...)
 		if err != nil {
-			return fmt.Errorf("build %s baseline: %w", game, err)
+			return diags, fmt.Errorf("build %s baseline: %w", game, err)
+		}
+		for _, pk3Path := range pk3s {
+			if id, ok := workshop[pk3Path]; ok {
+				if gm.Workshop == nil {
+					gm.Workshop = make(map[string]string)
+				}
+				gm.Workshop[pk3Path] = id
+			}
+		}
+		for skipped, used := range shadowed {
+			if slices.Contains(pk3s, used) {
+				if gm.Shadowed == nil {
+					gm.Shadowed = make(map[string]string)
+				}
+				gm.Shadowed[skipped] = used
+			}
 		}
 		manifest.Games[game] = gm
 	}
 
-	// For missionpack, merge baseq3 file index underneath (baseq3 as base, missionpack overrides)
-	if mp, ok := manifest.Games["missionpack"]; ok {
-		if bq3, ok := manifest.Games["baseq3"]; ok {
-			merged := make(map[string]string, len(bq3.FileIndex)+len(mp.FileIndex))
-			for k, v := range bq3.FileIndex {
-				merged[k] = v
-			}
-			for k, v := range mp.FileIndex {
-				merged[k] = v
-			}
-			mp.FileIndex = merged
+	if plan == nil {
+		if err := shaderCache.save(filepath.Join(outputDir, ShaderCacheName)); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
 
-			// Merge shaders too
-			mergedShaders := make(map[string][]string, len(bq3.Shaders)+len(mp.Shaders))
-			for k, v := range bq3.Shaders {
-				mergedShaders[k] = v
-			}
-			for k, v := range mp.Shaders {
-				mergedShaders[k] = v
-			}
-			mp.Shaders = mergedShaders
+	// Layer missionpack and mods over their bases (the overlay overrides)
+	layerGames(manifest, opts.GameBases)
+	applyTextureOverrides(manifest, substitutionOverrides(manifest, opts.TextureOverrides))
+	if !opts.Textures.isDefault() {
+		for _, gm := range manifest.Games {
+			textures := opts.Textures
+			gm.Textures = &textures
+		}
+	}
 
-			// Merge shader files
-			mergedShaderFiles := make(map[string]string, len(bq3.ShaderFiles)+len(mp.ShaderFiles))
-			for k, v := range bq3.ShaderFiles {
-				mergedShaderFiles[k] = v
-			}
-			for k, v := range mp.ShaderFiles {
-				mergedShaderFiles[k] = v
-			}
-			mp.ShaderFiles = mergedShaderFiles
+	// Configs and mods' menus may need files outside the baseline policy
+	for _, game := range gameNames {
+		added, err := completeBaseline(game, manifest.Games[game], gamePk3s[game], filepath.Join(outputDir, game+".pk3"), opts.Compression, plan)
+		if err != nil {
+			return diags, fmt.Errorf("complete %s baseline: %w", game, err)
+		}
+		if added > 0 {
+			log.Printf("  %s: %d config and menu assets added to baseline", game, added)
+		}
+	}
+
+	// Record shader references and map metadata; layered games share their
+	// bases' parsed files
+	refCache := make(map[string][]string)
+	mapCache := newMapInfoCache()
+	for _, game := range gameNames {
+		gm := manifest.Games[game]
+		gm.indexShaderRefs(mapKeys(gm.FileIndex), refCache)
+		log.Printf("  %s: %d shaders referenced by maps and models", game, len(gm.ShaderRefs))
+		gm.indexMaps(mapCache)
+	}
 
-			// Merge baseline files
-			mergedBaseline := make(map[string]bool, len(bq3.BaselineFiles)+len(mp.BaselineFiles))
-			for k := range bq3.BaselineFiles {
-				mergedBaseline[k] = true
+	if plan == nil {
+		for game, gm := range manifest.Games {
+			outputName := game + ".pk3"
+			outputPath := filepath.Join(outputDir, outputName)
+			contents, err := MapPakFileSet(outputPath)
+			if err != nil {
+				return diags, fmt.Errorf("read %s: %w", outputName, err)
 			}
-			for k := range mp.BaselineFiles {
-				mergedBaseline[k] = true
+			for path := range gm.Substituted {
+				delete(contents, path)
+			}
+			if err := manifest.addArtifact(outputName, outputPath, gm.containsOfficial(contents)); err != nil {
+				return diags, fmt.Errorf("hash %s: %w", outputName, err)
 			}
-			mp.BaselineFiles = mergedBaseline
 		}
 	}
 
-	// Save manifest
-	manifestPath := filepath.Join(outputDir, "manifest.json")
-	if err := manifest.Save(manifestPath); err != nil {
-		return fmt.Errorf("save manifest: %w", err)
-	}
-	log.Printf("Manifest saved to %s", manifestPath)
-
 	// Pre-build all map pk3s
 	builtMaps := make(map[string]bool)
-	for _, game := range []string{"baseq3", "missionpack"} {
-		gm, ok := manifest.Games[game]
-		if !ok {
-			continue
-		}
+	for _, game := range gameNames {
+		gm := manifest.Games[game]
 
 		var maps []string
 		for path := range gm.FileIndex {
@@ -160,21 +290,92 @@ func BuildBaseline(quake3Dir, outputDir string) error {
 		for _, mapName := range maps {
 			builtMaps[mapName] = true
 			mapPk3Path := filepath.Join(outputDir, "maps", mapName+".pk3")
+			if plan != nil {
+				pk3, mapDiags, err := PlanMapPak(mapName, game, manifest, mapPk3Path, opts.MapPak)
+				diags = append(diags, mapDiags...)
+				if err != nil {
+					diags = append(diags, Diagnostic{Severity: SeverityError, Kind: DiagBuildFailed, Subject: mapName, Map: mapName, Detail: err.Error()})
+				} else if pk3 != nil {
+					plan.add(pk3)
+				}
+				continue
+			}
 			log.Printf("Building map pk3: %s (%s)", mapName, game)
-			if err := BuildMapPak(mapName, game, manifest, quake3Dir, mapPk3Path); err != nil {
+			mapDiags, err := BuildMapPak(mapName, game, manifest, quake3Dir, mapPk3Path, opts.MapPak)
+			diags = append(diags, mapDiags...)
+			if err != nil {
 				log.Printf("Warning: failed to build map pk3 for %s: %v", mapName, err)
+				diags = append(diags, Diagnostic{Severity: SeverityError, Kind: DiagBuildFailed, Subject: mapName, Map: mapName, Detail: err.Error()})
+				continue
 			}
+			if contents, err := MapPakFileSet(mapPk3Path); err == nil {
+				if err := manifest.addArtifact("maps/"+mapName+".pk3", mapPk3Path, gm.containsOfficial(contents)); err != nil {
+					log.Printf("Warning: %v", err)
+				}
+			}
+		}
+	}
+
+	if plan != nil {
+		log.Printf("Dry run: %d pk3s, %s; nothing written", len(plan.Pk3s), formatSize(plan.Size()))
+		if len(diags) > 0 {
+			log.Printf("Diagnostics: %s", diags.Summary())
+		}
+		return diags, nil
+	}
+
+	if opts.Distributable {
+		diags = append(diags, removeRestricted(manifest, outputDir)...)
+	}
+	if opts.Levelshots != nil {
+		if _, err := ExportLevelshots(manifest, outputDir, *opts.Levelshots); err != nil {
+			log.Printf("Warning: failed to export levelshots: %v", err)
 		}
 	}
 
-	return nil
+	purePath := filepath.Join(outputDir, PureListName)
+	if err := savePureLists(purePath, buildPureLists(manifest, gamePk3s)); err != nil {
+		return diags, fmt.Errorf("save pure lists: %w", err)
+	}
+
+	// Save manifest last so it lists every artifact written above
+	manifestPath := filepath.Join(outputDir, "manifest.json")
+	if err := manifest.Save(manifestPath); err != nil {
+		return diags, fmt.Errorf("save manifest: %w", err)
+	}
+	log.Printf("Manifest saved to %s", manifestPath)
+	if len(diags) > 0 {
+		log.Printf("Diagnostics: %s", diags.Summary())
+	}
+
+	return diags, nil
 }
 
-func buildGameBaseline(game string, pk3s []string, outputDir string) (*GameManifest, error) {
-	// Build file index across ALL pk3s
-	fileIndex, err := BuildFileIndex(pk3s)
-	if err != nil {
-		return nil, fmt.Errorf("build file index: %w", err)
+func buildGameBaseline(game string, pk3s []string, outputDir string, opts BuildOptions, shaderCache *shaderCache) (*GameManifest, Diagnostics, error) {
+	policy := opts.Policy
+	if policy == nil {
+		policy = DefaultBaselinePolicy()
+	}
+	policy = policy.ForGame(game)
+
+	var diags Diagnostics
+
+	// Build file index across ALL pk3s, setting aside any that can't be read
+	fileIndex, originalNames, quarantined := buildFileIndexNames(pk3s)
+	if len(quarantined) > 0 {
+		bad := make(map[string]bool, len(quarantined))
+		for _, q := range quarantined {
+			log.Printf("Warning: skipping unreadable pk3 %s: %s", filepath.Base(q.Path), q.Error)
+			diags = append(diags, Diagnostic{Severity: SeverityError, Kind: DiagBadPk3, Subject: filepath.Base(q.Path), Detail: q.Error})
+			bad[q.Path] = true
+		}
+		readable := make([]string, 0, len(pk3s)-len(quarantined))
+		for _, pk3Path := range pk3s {
+			if !bad[pk3Path] {
+				readable = append(readable, pk3Path)
+			}
+		}
+		pk3s = readable
 	}
 
 	// Identify official pak files and Trinity pak files
@@ -190,12 +391,18 @@ func buildGameBaseline(game string, pk3s []string, outputDir string) (*GameManif
 		}
 	}
 
+	// Mods have no official paks; their own pk3s supply the overlay baseline
+	if !IsBaseGame(game) {
+		officialPaks = pk3s
+	}
+
 	// Build baseline from official paks only
 	baselineFiles := make(map[string][]byte)
+	baselineSources := make(map[string]string)
 	for _, pk3Path := range officialPaks {
-		r, err := zip.OpenReader(pk3Path)
+		r, err := openPk3(pk3Path)
 		if err != nil {
-			return nil, fmt.Errorf("open %s: %w", pk3Path, err)
+			return nil, diags, fmt.Errorf("open %s: %w", pk3Path, err)
 		}
 
 		for _, f := range r.File {
@@ -203,19 +410,20 @@ func buildGameBaseline(game string, pk3s []string, outputDir string) (*GameManif
 				continue
 			}
 			lower := strings.ToLower(f.Name)
-			if isBaselineFile(lower) {
+			if policy.Allows(lower, int64(f.UncompressedSize64)) {
 				rc, err := f.Open()
 				if err != nil {
 					r.Close()
-					return nil, fmt.Errorf("open %s in %s: %w", f.Name, pk3Path, err)
+					return nil, diags, fmt.Errorf("open %s in %s: %w", f.Name, pk3Path, err)
 				}
 				data, err := io.ReadAll(rc)
 				rc.Close()
 				if err != nil {
 					r.Close()
-					return nil, fmt.Errorf("read %s in %s: %w", f.Name, pk3Path, err)
+					return nil, diags, fmt.Errorf("read %s in %s: %w", f.Name, pk3Path, err)
 				}
 				baselineFiles[lower] = data
+				baselineSources[lower] = pk3Path
 			}
 		}
 		r.Close()
@@ -224,12 +432,40 @@ func buildGameBaseline(game string, pk3s []string, outputDir string) (*GameManif
 	// Write baseline pk3
 	outputName := game + ".pk3"
 	outputPath := filepath.Join(outputDir, outputName)
-	if err := WritePk3(outputPath, baselineFiles); err != nil {
-		return nil, fmt.Errorf("write baseline pk3: %w", err)
-	}
 
-	info, _ := os.Stat(outputPath)
-	log.Printf("  %s: %d files, %.1f MB", outputName, len(baselineFiles), float64(info.Size())/(1024*1024))
+	var substituted map[string]string
+	if opts.Substitute != nil && IsBaseGame(game) {
+		var err error
+		substituted, err = opts.Substitute.apply(baselineFiles)
+		if err != nil {
+			return nil, diags, fmt.Errorf("substitute: %w", err)
+		}
+		// A substitute image in another format is only in the baseline pk3
+		for p := range substituted {
+			if _, ok := fileIndex[p]; !ok {
+				fileIndex[p] = outputPath
+			}
+		}
+	}
+	if plan := opts.DryRun; plan != nil {
+		pk3 := &PlannedPk3{Path: outputPath, Files: make([]PlannedFile, 0, len(baselineFiles))}
+		for _, p := range sortedMapKeys(baselineFiles) {
+			source := baselineSources[p]
+			if sub, ok := substituted[p]; ok {
+				source = sub
+			}
+			pk3.Files = append(pk3.Files, PlannedFile{Path: p, Size: int64(len(baselineFiles[p])), Source: source})
+			pk3.Size += int64(len(baselineFiles[p]))
+		}
+		plan.add(pk3)
+		log.Printf("  %s: %d files, %.1f MB uncompressed (dry run)", outputName, len(baselineFiles), float64(pk3.Size)/(1024*1024))
+	} else {
+		if err := writePk3(outputPath, baselineFiles, opts.Compression); err != nil {
+			return nil, diags, fmt.Errorf("write baseline pk3: %w", err)
+		}
+		info, _ := os.Stat(outputPath)
+		log.Printf("  %s: %d files, %.1f MB", outputName, len(baselineFiles), float64(info.Size())/(1024*1024))
+	}
 
 	// Track baseline file set
 	baselineSet := make(map[string]bool, len(baselineFiles))
@@ -239,7 +475,7 @@ func buildGameBaseline(game string, pk3s []string, outputDir string) (*GameManif
 
 	// Add Trinity pk3 contents to baseline set (loaded separately by demo player)
 	if trinityPak != "" {
-		r, err := zip.OpenReader(trinityPak)
+		r, err := openPk3(trinityPak)
 		if err == nil {
 			for _, f := range r.File {
 				if !f.FileInfo().IsDir() {
@@ -254,69 +490,164 @@ func buildGameBaseline(game string, pk3s []string, outputDir string) (*GameManif
 	// Parse all shaders from all pk3s (in load order)
 	shaders := make(map[string][]string)
 	shaderFiles := make(map[string]string)
+	shaderLinks := make(map[string][]string)
+	lightImages := make(map[string][]string)
 	for _, pk3Path := range pk3s {
-		if err := parseShadersPk3(pk3Path, shaders, shaderFiles); err != nil {
+		if err := parseShadersPk3(pk3Path, shaders, shaderFiles, shaderLinks, lightImages, shaderCache); err != nil {
 			log.Printf("Warning: failed to parse shaders from %s: %v", filepath.Base(pk3Path), err)
+			diags = append(diags, Diagnostic{Severity: SeverityWarning, Kind: DiagBadPk3, Subject: filepath.Base(pk3Path), Detail: "shaders: " + err.Error()})
 		}
 	}
 	log.Printf("  %d shader definitions parsed", len(shaders))
 
+	videos := make(map[string]*RoQInfo)
+	for _, pk3Path := range pk3s {
+		if err := parseVideosPk3(pk3Path, videos); err != nil {
+			log.Printf("Warning: failed to read videos from %s: %v", filepath.Base(pk3Path), err)
+			diags = append(diags, Diagnostic{Severity: SeverityWarning, Kind: DiagBadPk3, Subject: filepath.Base(pk3Path), Detail: "videos: " + err.Error()})
+		}
+	}
+	if len(videos) == 0 {
+		videos = nil
+	}
+
 	return &GameManifest{
 		FileIndex:     fileIndex,
 		BaselineFiles: baselineSet,
 		Shaders:       shaders,
 		ShaderFiles:   shaderFiles,
-	}, nil
+		ShaderLinks:   shaderLinks,
+		LightImages:   lightImages,
+		Quarantined:   quarantined,
+		OfficialFiles: officialFileSet(fileIndex),
+		Substituted:   substituted,
+		OriginalNames: originalNames,
+		Videos:        videos,
+	}, diags, nil
 }
 
-func isBaselineFile(lowerPath string) bool {
-	// Check specific includes first (these override broad excludes)
-	for _, prefix := range baselinePrefixes {
-		if strings.HasPrefix(lowerPath, prefix) {
-			return true
+// completeBaseline adds what a game's baseline scripts need to its baseline
+// pk3: the root-level configs from its own sources, with the configs they exec
+// and the models and sounds they set, and for mods, their own menus under ui/
+// with every image, model, sound, and font those use. Scripts are resolved
+// against the layered file index, and files no baseline has yet are added
+// whatever the policy says. In a dry run the planned pk3 is extended instead.
+// Returns the number of files added.
+func completeBaseline(game string, gm *GameManifest, sources []string, pk3Path, compression string, plan *BuildPlan) (int, error) {
+	own := make(map[string]bool, len(sources))
+	for _, s := range sources {
+		own[s] = true
+	}
+	needed := newDepSet()
+	resolveConfigs(gm, own, needed)
+	if !IsBaseGame(game) {
+		resolveMenus(gm, own, needed)
+	}
+	var missing []string
+	for p := range needed.files {
+		if !gm.BaselineFiles[p] {
+			missing = append(missing, p)
 		}
 	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
 
-	// Check excludes
-	for _, prefix := range baselineExcludePrefixes {
-		if strings.HasPrefix(lowerPath, prefix) {
-			return false
+	if plan != nil {
+		if err := plan.extend(pk3Path, missing, gm.FileIndex); err != nil {
+			return 0, err
+		}
+		for _, p := range missing {
+			gm.BaselineFiles[p] = true
 		}
+		return len(missing), nil
 	}
 
-	// Root-level .cfg files
-	if !strings.Contains(lowerPath, "/") && strings.HasSuffix(lowerPath, ".cfg") {
-		return true
+	// Rewrite the pk3 with its current contents plus the missing files
+	contents, err := MapPakFileSet(pk3Path)
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", pk3Path, err)
 	}
-
-	return false
+	index := make(map[string]string, len(contents)+len(missing))
+	for p := range contents {
+		index[p] = pk3Path
+	}
+	for _, p := range missing {
+		index[p] = gm.FileIndex[p]
+	}
+	tmp := pk3Path + ".tmp"
+	if _, err := writePk3FromIndex(tmp, mapKeys(index), index, compression, nil); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, pk3Path); err != nil {
+		return 0, fmt.Errorf("replace %s: %w", pk3Path, err)
+	}
+	for _, p := range missing {
+		gm.BaselineFiles[p] = true
+	}
+	return len(missing), nil
 }
 
-func parseShadersPk3(pk3Path string, shaders map[string][]string, shaderFiles map[string]string) error {
-	return IteratePk3(pk3Path, func(name string, open func() (io.ReadCloser, error)) error {
-		lower := strings.ToLower(name)
-		if !strings.HasPrefix(lower, "scripts/") || !strings.HasSuffix(lower, ".shader") {
-			return nil
-		}
+// parseShadersPk3 parses a pk3's shader scripts into shaders, shaderFiles,
+// links, and lightImages (if not nil), later definitions replacing earlier
+// ones. Scripts cache still has are not parsed again; cache may be nil. A
+// script that can't be read is skipped and reported in the error, after the
+// rest are parsed.
+func parseShadersPk3(pk3Path string, shaders map[string][]string, shaderFiles map[string]string, links, lightImages map[string][]string, cache *shaderCache) error {
+	r, err := openPk3(pk3Path)
+	if err != nil {
+		return fmt.Errorf("open pk3 %s: %w", pk3Path, err)
+	}
+	defer r.Close()
 
-		rc, err := open()
-		if err != nil {
-			return nil
+	var failed []error
+	for _, f := range r.File {
+		lower := strings.ToLower(f.Name)
+		if !strings.HasPrefix(lower, "scripts/") || !strings.HasSuffix(lower, ".shader") {
+			continue
 		}
-		defer rc.Close()
 
-		defs, err := ParseShaderScript(rc)
-		if err != nil {
-			return nil
+		defs, ok := cache.lookup(pk3Path, f.Name, f.CRC32, f.UncompressedSize64)
+		if !ok {
+			rc, err := f.Open()
+			if err != nil {
+				failed = append(failed, fmt.Errorf("%s: %w", f.Name, err))
+				continue
+			}
+			defs, err = ParseShaderScript(rc)
+			rc.Close()
+			if err != nil {
+				failed = append(failed, fmt.Errorf("%s: %w", f.Name, err))
+				continue
+			}
+			cache.store(pk3Path, f.Name, f.CRC32, f.UncompressedSize64, defs)
 		}
 
 		for _, def := range defs {
 			key := strings.ToLower(def.Name)
 			shaders[key] = def.Textures
 			shaderFiles[key] = lower
+			// A later definition replaces an earlier one's links and light
+			// images too
+			setShaderList(links, key, def.Shaders)
+			setShaderList(lightImages, key, def.LightImages)
 		}
-		return nil
-	})
+	}
+	return errors.Join(failed...)
+}
+
+// setShaderList sets a shader's entry in a map of lists, or deletes it if
+// list is empty. A nil map is left alone.
+func setShaderList(m map[string][]string, name string, list []string) {
+	if m == nil {
+		return
+	}
+	if len(list) > 0 {
+		m[name] = list
+	} else {
+		delete(m, name)
+	}
 }
 
 // readFileFromIndex reads a file using the file index to locate its source pk3.
@@ -324,7 +655,7 @@ func readFileFromIndex(path string, fileIndex map[string]string) ([]byte, error)
 	lower := strings.ToLower(path)
 	pk3Path, ok := fileIndex[lower]
 	if !ok {
-		return nil, fmt.Errorf("file not in index: %s", path)
+		return nil, &ErrFileNotInIndex{Path: path}
 	}
 	return ReadFileFromPk3(pk3Path, lower)
 }
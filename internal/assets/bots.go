@@ -0,0 +1,151 @@
+package assets
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// botFilesDir is where the bot library looks for character files and includes.
+const botFilesDir = "botfiles/"
+
+// ParseInfos parses Q3 info blocks, the { key value ... } format of
+// scripts/bots.txt, .bot, and .arena files, as G_ParseInfos does: a key's
+// value is the rest of its line. Keys are lowered.
+func ParseInfos(r io.Reader) ([]map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var infos []map[string]string
+	var cur map[string]string
+	tok := NewTokenizer(string(data))
+	for t, ok := tok.Next(); ok; t, ok = tok.Next() {
+		switch {
+		case t.Kind == TokenOpen:
+			cur = make(map[string]string)
+		case t.Kind == TokenClose:
+			if cur != nil {
+				infos = append(infos, cur)
+				cur = nil
+			}
+		case cur != nil:
+			value := ""
+			if next, ok := tok.Peek(); ok && next.Kind == TokenWord && next.Line == t.Line {
+				tok.Next()
+				value = next.Text
+			}
+			cur[strings.ToLower(t.Text)] = value
+		}
+	}
+	return infos, nil
+}
+
+// BotInfo is a bot definition from scripts/bots.txt or a .bot file.
+type BotInfo struct {
+	Name   string
+	Model  string // "name/skin"
+	AIFile string // character file, relative to botfiles/
+	Script string // lowered path of the file defining the bot
+}
+
+// Bots returns the game's bot definitions by lowered name. As in the game,
+// scripts/bots.txt is read first, then scripts/*.bot, and the first
+// definition of a name wins.
+func (gm *GameManifest) Bots() map[string]*BotInfo {
+	scripts := []string{"scripts/bots.txt"}
+	var extra []string
+	for p := range gm.FileIndex {
+		if strings.HasPrefix(p, "scripts/") && strings.HasSuffix(p, ".bot") {
+			extra = append(extra, p)
+		}
+	}
+	sort.Strings(extra)
+
+	bots := make(map[string]*BotInfo)
+	for _, script := range append(scripts, extra...) {
+		data, err := readFileFromIndex(script, gm.FileIndex)
+		if err != nil {
+			continue
+		}
+		infos, _ := ParseInfos(bytes.NewReader(data))
+		for _, info := range infos {
+			key := strings.ToLower(stripColorCodes(info["name"]))
+			if key == "" || bots[key] != nil {
+				continue
+			}
+			bots[key] = &BotInfo{Name: info["name"], Model: info["model"], AIFile: info["aifile"], Script: script}
+		}
+	}
+	return bots
+}
+
+// resolveBot adds a bot's definition script, character files, and player
+// model to needed. Unknown bots are skipped.
+func resolveBot(bot *BotInfo, from string, fallback PlayerFallback, gm *GameManifest, needed *depSet) {
+	needed.add(from, "bot", bot.Script)
+	if bot.AIFile != "" {
+		resolveBotFile(botFilesDir+strings.ToLower(bot.AIFile), bot.Script, "character", gm, needed)
+	}
+	if bot.Model != "" {
+		resolvePlayerModel(bot.Model, "", fallback, bot.Script, gm, needed)
+	}
+}
+
+// resolveBotFile adds a bot library source file and, recursively, the files
+// it includes and names: character files name their chat and weight files.
+// Paths are tried relative to botfiles/ and then to the including file.
+func resolveBotFile(p, from, kind string, gm *GameManifest, needed *depSet) {
+	p = path.Clean(p)
+	if needed.files[p] {
+		needed.link(from, kind, p)
+		return
+	}
+	data, err := readFileFromIndex(p, gm.FileIndex)
+	if err != nil {
+		return
+	}
+	needed.add(from, kind, p)
+
+	tok := NewTokenizer(string(data))
+	for t, ok := tok.Next(); ok; t, ok = tok.Next() {
+		if t.Kind != TokenWord {
+			continue
+		}
+		ref := strings.ToLower(strings.ReplaceAll(t.Text, "\\", "/"))
+		if !strings.HasSuffix(ref, ".c") && !strings.HasSuffix(ref, ".h") {
+			continue
+		}
+		for _, candidate := range []string{botFilesDir + ref, path.Join(path.Dir(p), ref)} {
+			if _, ok := gm.FileIndex[path.Clean(candidate)]; ok {
+				resolveBotFile(candidate, p, "include", gm, needed)
+				break
+			}
+		}
+	}
+}
+
+// resolveArenaBots adds the bots an arena file lists for mapName.
+func resolveArenaBots(arenaPath, mapName string, fallback PlayerFallback, gm *GameManifest, needed *depSet) {
+	data, err := readFileFromIndex(arenaPath, gm.FileIndex)
+	if err != nil {
+		return
+	}
+	infos, _ := ParseInfos(bytes.NewReader(data))
+	var bots map[string]*BotInfo
+	for _, info := range infos {
+		if !strings.EqualFold(info["map"], mapName) || info["bots"] == "" {
+			continue
+		}
+		if bots == nil {
+			bots = gm.Bots()
+		}
+		for _, name := range strings.Fields(info["bots"]) {
+			if bot, ok := bots[strings.ToLower(name)]; ok {
+				resolveBot(bot, arenaPath, fallback, gm, needed)
+			}
+		}
+	}
+}
@@ -9,13 +9,30 @@ import (
 )
 
 const (
-	bspMagic         = "IBSP"
-	bspVersion       = 0x2E
-	bspLumpEntities  = 0
-	bspLumpShaders   = 1
-	bspNumLumps      = 17
-	bspShaderSize    = 72  // 64 bytes name + 2x int32
-	bspHeaderSize    = 8 + bspNumLumps*8 // magic(4) + version(4) + 17 lumps * (offset(4) + length(4))
+	bspMagic        = "IBSP"
+	bspVersion      = 0x2E
+	bspLumpEntities = 0
+	bspLumpShaders  = 1
+	bspLumpNodes    = 3
+	bspLumpLeafs    = 4
+	bspLumpBrushes  = 8
+	bspLumpVerts    = 10
+	bspLumpIndexes  = 11
+	bspLumpSurfaces = 13
+	bspLumpVisData  = 16
+	bspNumLumps     = 17
+	bspShaderSize   = 72                // 64 bytes name + 2x int32
+	bspHeaderSize   = 8 + bspNumLumps*8 // magic(4) + version(4) + 17 lumps * (offset(4) + length(4))
+
+	// Quake Live BSPs add an advertisements lump after the standard 17
+	bspVersionQL          = 0x2F
+	bspLumpAdvertisements = 17
+	bspHeaderSizeQL       = bspHeaderSize + 8
+	bspAdvertisementSize  = 128 // cellId(4) + normal(12) + rect(48) + 64 bytes shader name
+
+	// Limits well above anything q3map2 emits, so hostile files can't force huge allocations.
+	bspMaxEntitiesSize = 16 << 20
+	bspMaxShaders      = 1 << 16
 )
 
 // BSPAssets holds asset references extracted from a BSP file.
@@ -24,34 +41,35 @@ type BSPAssets struct {
 	Music   []string
 	Sounds  []string
 	Models  []string
+	Videos  []string // RoQ cinematics named by any entity key
+
+	// Advertisements are the shaders of Quake Live ad surfaces
+	Advertisements []string
+
+	// Worldspawn holds the first entity's keys, lowered, such as "message"
+	Worldspawn map[string]string
+
+	// Classnames counts the entities of each (lowered) class
+	Classnames map[string]int
 }
 
 // ParseBSP parses a Q3 BSP file and extracts asset references.
 func ParseBSP(r io.ReaderAt, size int64) (*BSPAssets, error) {
-	if size < int64(bspHeaderSize) {
-		return nil, fmt.Errorf("BSP too small: %d bytes", size)
-	}
-
-	// Read header
-	header := make([]byte, bspHeaderSize)
-	if _, err := r.ReadAt(header, 0); err != nil {
-		return nil, fmt.Errorf("read BSP header: %w", err)
-	}
-
-	// Verify magic and version
-	if string(header[0:4]) != bspMagic {
-		return nil, fmt.Errorf("invalid BSP magic: %q", header[0:4])
-	}
-	version := binary.LittleEndian.Uint32(header[4:8])
-	if version != bspVersion {
-		return nil, fmt.Errorf("unsupported BSP version: %d", version)
+	header, version, err := readBSPHeader(r, size)
+	if err != nil {
+		return nil, err
 	}
 
 	assets := &BSPAssets{}
 
 	// Parse entities lump
-	entOffset := int64(binary.LittleEndian.Uint32(header[8+bspLumpEntities*8:]))
-	entLength := int64(binary.LittleEndian.Uint32(header[8+bspLumpEntities*8+4:]))
+	entOffset, entLength, err := bspLump(header, bspLumpEntities, size)
+	if err != nil {
+		return nil, err
+	}
+	if entLength > bspMaxEntitiesSize {
+		return nil, fmt.Errorf("entities lump too large: %d bytes", entLength)
+	}
 	if entLength > 0 {
 		entData := make([]byte, entLength)
 		if _, err := r.ReadAt(entData, entOffset); err != nil {
@@ -61,11 +79,16 @@ func ParseBSP(r io.ReaderAt, size int64) (*BSPAssets, error) {
 	}
 
 	// Parse shaders lump
-	shaderOffset := int64(binary.LittleEndian.Uint32(header[8+bspLumpShaders*8:]))
-	shaderLength := int64(binary.LittleEndian.Uint32(header[8+bspLumpShaders*8+4:]))
+	shaderOffset, shaderLength, err := bspLump(header, bspLumpShaders, size)
+	if err != nil {
+		return nil, err
+	}
 	numShaders := shaderLength / bspShaderSize
+	if numShaders > bspMaxShaders {
+		return nil, fmt.Errorf("too many shaders: %d", numShaders)
+	}
 	if numShaders > 0 {
-		shaderData := make([]byte, shaderLength)
+		shaderData := make([]byte, numShaders*bspShaderSize)
 		if _, err := r.ReadAt(shaderData, shaderOffset); err != nil {
 			return nil, fmt.Errorf("read shaders lump: %w", err)
 		}
@@ -78,9 +101,80 @@ func ParseBSP(r io.ReaderAt, size int64) (*BSPAssets, error) {
 		}
 	}
 
+	if version == bspVersionQL {
+		if err := parseAdvertisements(r, size, assets); err != nil {
+			return nil, err
+		}
+	}
+
 	return assets, nil
 }
 
+// readBSPHeader reads a BSP's header, with its standard 17 lumps, and
+// checks its magic and version.
+func readBSPHeader(r io.ReaderAt, size int64) ([]byte, uint32, error) {
+	if size < int64(bspHeaderSize) {
+		return nil, 0, fmt.Errorf("BSP too small: %d bytes", size)
+	}
+	header := make([]byte, bspHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, 0, fmt.Errorf("read BSP header: %w", err)
+	}
+	if string(header[0:4]) != bspMagic {
+		return nil, 0, fmt.Errorf("invalid BSP magic: %q", header[0:4])
+	}
+	version := binary.LittleEndian.Uint32(header[4:8])
+	if version != bspVersion && version != bspVersionQL {
+		return nil, 0, fmt.Errorf("%w: %d", ErrBSPVersion, version)
+	}
+	return header, version, nil
+}
+
+// parseAdvertisements reads the shader names from a Quake Live BSP's
+// advertisements lump.
+func parseAdvertisements(r io.ReaderAt, size int64, assets *BSPAssets) error {
+	if size < bspHeaderSizeQL {
+		return fmt.Errorf("BSP too small for advertisements lump: %d bytes", size)
+	}
+	header := make([]byte, bspHeaderSizeQL)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("read BSP header: %w", err)
+	}
+	offset, length, err := bspLump(header, bspLumpAdvertisements, size)
+	if err != nil {
+		return err
+	}
+	count := length / bspAdvertisementSize
+	if count > bspMaxShaders {
+		return fmt.Errorf("too many advertisements: %d", count)
+	}
+	if count == 0 {
+		return nil
+	}
+	data := make([]byte, count*bspAdvertisementSize)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return fmt.Errorf("read advertisements lump: %w", err)
+	}
+	for i := int64(0); i < count; i++ {
+		entry := data[i*bspAdvertisementSize : (i+1)*bspAdvertisementSize]
+		name := strings.ReplaceAll(readNullTerminated(entry[64:]), "\\", "/")
+		if name != "" {
+			assets.Advertisements = append(assets.Advertisements, name)
+		}
+	}
+	return nil
+}
+
+// bspLump returns a lump's offset and length, checking it lies within the file.
+func bspLump(header []byte, lump int, size int64) (int64, int64, error) {
+	offset := int64(binary.LittleEndian.Uint32(header[8+lump*8:]))
+	length := int64(binary.LittleEndian.Uint32(header[8+lump*8+4:]))
+	if length > 0 && (offset < int64(bspHeaderSize) || offset+length > size) {
+		return 0, 0, fmt.Errorf("lump %d out of bounds: offset %d, length %d, file %d bytes", lump, offset, length, size)
+	}
+	return offset, length, nil
+}
+
 // parseEntities extracts asset refs from BSP entity text.
 func parseEntities(text string, assets *BSPAssets) {
 	scanner := strings.NewReader(text)
@@ -88,8 +182,12 @@ func parseEntities(text string, assets *BSPAssets) {
 	n, _ := scanner.Read(buf)
 	lines := strings.Split(string(buf[:n]), "\n")
 
+	entity := -1
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
+		if line == "{" {
+			entity++
+		}
 		if line == "" || line == "{" || line == "}" {
 			continue
 		}
@@ -99,11 +197,22 @@ func parseEntities(text string, assets *BSPAssets) {
 		if key == "" {
 			continue
 		}
+		if entity == 0 {
+			if assets.Worldspawn == nil {
+				assets.Worldspawn = make(map[string]string)
+			}
+			assets.Worldspawn[strings.ToLower(key)] = value
+		}
 
 		// Normalize Windows backslashes to forward slashes
 		value = strings.ReplaceAll(value, "\\", "/")
 
 		switch strings.ToLower(key) {
+		case "classname":
+			if assets.Classnames == nil {
+				assets.Classnames = make(map[string]int)
+			}
+			assets.Classnames[strings.ToLower(value)]++
 		case "music":
 			// Music value can contain a space-separated looping flag
 			parts := strings.Fields(value)
@@ -118,6 +227,8 @@ func parseEntities(text string, assets *BSPAssets) {
 			if value != "" && !strings.HasPrefix(value, "*") {
 				assets.Models = append(assets.Models, value)
 			}
+		default:
+			assets.Videos = append(assets.Videos, findVideoRefs(value)...)
 		}
 	}
 }
@@ -0,0 +1,90 @@
+package assets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	bspNodeSize  = 36 // plane, children, bounds
+	bspLeafSize  = 48 // cluster, area, bounds, leaf surfaces and brushes
+	bspBrushSize = 12 // first side, sides, shader
+
+	// Past these a map is slow to load or draw in the web player
+	heavyMapLeafs    = 20000
+	heavyMapSurfaces = 20000
+	heavyMapBrushes  = 20000
+	heavyMapVisData  = 8 << 20
+)
+
+// MapStats measures a map's complexity from its BSP tree and vis data.
+type MapStats struct {
+	Nodes    int   `json:"nodes"`
+	Leafs    int   `json:"leafs"`
+	Brushes  int   `json:"brushes"`
+	Surfaces int   `json:"surfaces"`
+	Clusters int   `json:"clusters"` // vis clusters; 0 for maps compiled without vis
+	VisData  int64 `json:"visData"`  // size of the visdata lump in bytes
+}
+
+// ParseBSPStats reads a Q3 BSP's complexity metrics. Counts come from lump
+// sizes, so only the header and the visdata lump's own header are read.
+func ParseBSPStats(r io.ReaderAt, size int64) (*MapStats, error) {
+	header, _, err := readBSPHeader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	count := func(lump int, entrySize int64) (int, error) {
+		_, length, err := bspLump(header, lump, size)
+		return int(length / entrySize), err
+	}
+	stats := &MapStats{}
+	if stats.Nodes, err = count(bspLumpNodes, bspNodeSize); err != nil {
+		return nil, err
+	}
+	if stats.Leafs, err = count(bspLumpLeafs, bspLeafSize); err != nil {
+		return nil, err
+	}
+	if stats.Brushes, err = count(bspLumpBrushes, bspBrushSize); err != nil {
+		return nil, err
+	}
+	if stats.Surfaces, err = count(bspLumpSurfaces, bspSurfaceSize); err != nil {
+		return nil, err
+	}
+	visOffset, visLength, err := bspLump(header, bspLumpVisData, size)
+	if err != nil {
+		return nil, err
+	}
+	stats.VisData = visLength
+	if visLength >= 8 {
+		vis := make([]byte, 4)
+		if _, err := r.ReadAt(vis, visOffset); err != nil {
+			return nil, fmt.Errorf("read visdata lump: %w", err)
+		}
+		stats.Clusters = int(int32(binary.LittleEndian.Uint32(vis)))
+		if stats.Clusters < 0 {
+			stats.Clusters = 0
+		}
+	}
+	return stats, nil
+}
+
+// Heavy returns why the map is likely to perform badly in the web player,
+// or nothing if it isn't.
+func (s *MapStats) Heavy() []string {
+	var reasons []string
+	if s.Leafs > heavyMapLeafs {
+		reasons = append(reasons, fmt.Sprintf("%d leafs", s.Leafs))
+	}
+	if s.Surfaces > heavyMapSurfaces {
+		reasons = append(reasons, fmt.Sprintf("%d surfaces", s.Surfaces))
+	}
+	if s.Brushes > heavyMapBrushes {
+		reasons = append(reasons, fmt.Sprintf("%d brushes", s.Brushes))
+	}
+	if s.VisData > heavyMapVisData {
+		reasons = append(reasons, fmt.Sprintf("%s visdata", formatSize(s.VisData)))
+	}
+	return reasons
+}
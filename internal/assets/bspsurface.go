@@ -0,0 +1,244 @@
+package assets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	bspSurfaceSize = 104 // shader, fog, type, verts, indexes, lightmap, lightmap vectors, patch size
+	bspVertSize    = 44  // xyz, st, lightmap st, normal, color
+	bspMaxSurfaces = 1 << 20
+
+	bspSurfacePlanar   = 1
+	bspSurfacePatch    = 2
+	bspSurfaceTriangle = 3
+)
+
+// SurfaceUsage is how much of a map's geometry uses one shader.
+type SurfaceUsage struct {
+	Shader   string  `json:"shader"`
+	Surfaces int     `json:"surfaces"`
+	Area     float64 `json:"area"` // approximate, in square units; patches count their control grid
+}
+
+// ParseBSPSurfaces counts the surfaces each shader of a Q3 BSP is drawn on
+// and the area they cover, from its surfaces, vertices, and indexes lumps.
+// Shader names are lowered, without extension, as the engine looks them up;
+// shaders no surface uses (brush-only ones, such as clips) are left out.
+// The result is sorted by shader.
+func ParseBSPSurfaces(r io.ReaderAt, size int64) ([]SurfaceUsage, error) {
+	geo, err := readBSPGeometry(r, size)
+	if err != nil {
+		return nil, err
+	}
+	shaders := make([]string, len(geo.shaders)/bspShaderSize)
+	for i := range shaders {
+		name := strings.ToLower(strings.ReplaceAll(readNullTerminated(geo.shaders[i*bspShaderSize:i*bspShaderSize+64]), "\\", "/"))
+		shaders[i] = strings.TrimSuffix(name, path.Ext(name))
+	}
+
+	usage := make(map[string]*SurfaceUsage)
+	for i := 0; i+bspSurfaceSize <= len(geo.surfaces); i += bspSurfaceSize {
+		surf := geo.surfaces[i : i+bspSurfaceSize]
+		shader := surfaceField(surf, 0)
+		if shader < 0 || shader >= len(shaders) || shaders[shader] == "" {
+			continue
+		}
+		u := usage[shaders[shader]]
+		if u == nil {
+			u = &SurfaceUsage{Shader: shaders[shader]}
+			usage[shaders[shader]] = u
+		}
+		u.Surfaces++
+		geo.triangles(surf, func(a, b, c int) {
+			u.Area += triangleArea(geo.verts[a], geo.verts[b], geo.verts[c])
+		})
+	}
+
+	result := make([]SurfaceUsage, 0, len(usage))
+	for _, name := range sortedMapKeys(usage) {
+		result = append(result, *usage[name])
+	}
+	return result, nil
+}
+
+// TextureImportance is how much of a map a texture is seen on, summed over
+// the surfaces of every shader that uses it.
+type TextureImportance struct {
+	Texture  string   `json:"texture"`
+	Shaders  []string `json:"shaders"`
+	Surfaces int      `json:"surfaces"`
+	Area     float64  `json:"area"`
+}
+
+// MapTextureImportance returns the textures a map's surfaces show, least
+// seen first: by area, then surface count. Shrinking a map pk3 to a size
+// budget can then start with the textures players hardly see. Textures only
+// models, effects, or linked shaders use aren't listed.
+func MapTextureImportance(mapName string, gm *GameManifest) ([]TextureImportance, error) {
+	bspPath := "maps/" + strings.ToLower(mapName) + ".bsp"
+	if _, ok := gm.FileIndex[bspPath]; !ok {
+		return nil, &ErrFileNotInIndex{Path: bspPath}
+	}
+	data, err := readFileFromIndex(bspPath, gm.FileIndex)
+	if err != nil {
+		return nil, fmt.Errorf("read BSP: %w", err)
+	}
+	usage, err := ParseBSPSurfaces(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("parse BSP: %w", err)
+	}
+
+	byTexture := make(map[string]*TextureImportance)
+	for _, u := range usage {
+		for _, tex := range resolvedShaderTextures(u.Shader, gm) {
+			t := byTexture[tex]
+			if t == nil {
+				t = &TextureImportance{Texture: tex}
+				byTexture[tex] = t
+			}
+			t.Shaders = append(t.Shaders, u.Shader)
+			t.Surfaces += u.Surfaces
+			t.Area += u.Area
+		}
+	}
+	result := make([]TextureImportance, 0, len(byTexture))
+	for _, t := range byTexture {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Area != b.Area {
+			return a.Area < b.Area
+		}
+		if a.Surfaces != b.Surfaces {
+			return a.Surfaces < b.Surfaces
+		}
+		return a.Texture < b.Texture
+	})
+	return result, nil
+}
+
+// bspGeometry holds the lumps a BSP's drawn surfaces are built from.
+type bspGeometry struct {
+	shaders  []byte // shaders lump, bspShaderSize entries
+	surfaces []byte // surfaces lump, bspSurfaceSize entries
+	vertData []byte // vertices lump, bspVertSize entries
+	verts    [][3]float64
+	indexes  []byte
+}
+
+// readBSPGeometry reads the geometry lumps of a Q3 BSP.
+func readBSPGeometry(r io.ReaderAt, size int64) (*bspGeometry, error) {
+	header, _, err := readBSPHeader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	geo := &bspGeometry{}
+	if geo.shaders, err = readBSPLump(r, header, bspLumpShaders, size, "shaders"); err != nil {
+		return nil, err
+	}
+	if len(geo.shaders)/bspShaderSize > bspMaxShaders {
+		return nil, fmt.Errorf("too many shaders: %d", len(geo.shaders)/bspShaderSize)
+	}
+	if geo.surfaces, err = readBSPLump(r, header, bspLumpSurfaces, size, "surfaces"); err != nil {
+		return nil, err
+	}
+	if len(geo.surfaces)/bspSurfaceSize > bspMaxSurfaces {
+		return nil, fmt.Errorf("too many surfaces: %d", len(geo.surfaces)/bspSurfaceSize)
+	}
+	if geo.vertData, err = readBSPLump(r, header, bspLumpVerts, size, "vertices"); err != nil {
+		return nil, err
+	}
+	if geo.indexes, err = readBSPLump(r, header, bspLumpIndexes, size, "indexes"); err != nil {
+		return nil, err
+	}
+	geo.verts = make([][3]float64, len(geo.vertData)/bspVertSize)
+	for i := range geo.verts {
+		for k := range 3 {
+			geo.verts[i][k] = geo.vertFloat(i, k*4)
+		}
+	}
+	return geo, nil
+}
+
+// vertFloat reads the float at offset within vertex i.
+func (geo *bspGeometry) vertFloat(i, offset int) float64 {
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(geo.vertData[i*bspVertSize+offset:])))
+}
+
+// triangles calls fn with the vertex numbers of each triangle of a surface:
+// the indexed triangles of planar and triangle soup surfaces, and a patch's
+// control grid split in two triangles per cell. Flares and surfaces
+// pointing outside the lumps have none.
+func (geo *bspGeometry) triangles(surf []byte, fn func(a, b, c int)) {
+	firstVert, numVerts := surfaceField(surf, 3), surfaceField(surf, 4)
+	if firstVert < 0 || numVerts < 0 || firstVert+numVerts > len(geo.verts) {
+		return
+	}
+	switch surfaceField(surf, 2) {
+	case bspSurfacePlanar, bspSurfaceTriangle:
+		firstIndex, count := surfaceField(surf, 5), surfaceField(surf, 6)
+		if firstIndex < 0 || count < 0 || firstIndex+count > len(geo.indexes)/4 {
+			return
+		}
+		for j := firstIndex; j+2 < firstIndex+count; j += 3 {
+			a := int(binary.LittleEndian.Uint32(geo.indexes[j*4:]))
+			b := int(binary.LittleEndian.Uint32(geo.indexes[j*4+4:]))
+			c := int(binary.LittleEndian.Uint32(geo.indexes[j*4+8:]))
+			if a < numVerts && b < numVerts && c < numVerts {
+				fn(firstVert+a, firstVert+b, firstVert+c)
+			}
+		}
+	case bspSurfacePatch:
+		width, height := surfaceField(surf, 24), surfaceField(surf, 25)
+		if width < 2 || height < 2 || width*height > numVerts {
+			return
+		}
+		for y := 0; y < height-1; y++ {
+			for x := 0; x < width-1; x++ {
+				p := firstVert + y*width + x
+				fn(p, p+1, p+width)
+				fn(p+1, p+width+1, p+width)
+			}
+		}
+	}
+}
+
+// surfaceField reads the nth int32 of a surfaces lump entry.
+func surfaceField(surf []byte, n int) int {
+	return int(int32(binary.LittleEndian.Uint32(surf[n*4:])))
+}
+
+// readBSPLump reads a whole lump; an empty lump reads as nil.
+func readBSPLump(r io.ReaderAt, header []byte, lump int, size int64, what string) ([]byte, error) {
+	offset, length, err := bspLump(header, lump, size)
+	if err != nil || length == 0 {
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("read %s lump: %w", what, err)
+	}
+	return data, nil
+}
+
+func triangleArea(a, b, c [3]float64) float64 {
+	u := [3]float64{b[0] - a[0], b[1] - a[1], b[2] - a[2]}
+	v := [3]float64{c[0] - a[0], c[1] - a[1], c[2] - a[2]}
+	x := u[1]*v[2] - u[2]*v[1]
+	y := u[2]*v[0] - u[0]*v[2]
+	z := u[0]*v[1] - u[1]*v[0]
+	area := math.Sqrt(x*x+y*y+z*z) / 2
+	if math.IsNaN(area) || math.IsInf(area, 0) {
+		return 0
+	}
+	return area
+}
@@ -0,0 +1,144 @@
+package assets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// fixtureSurface is one BSP surface: its shader index and type, its
+// vertices and their normal, and either its triangle indexes or its patch
+// width.
+type fixtureSurface struct {
+	shader, kind int
+	verts        [][3]float32
+	normal       [3]float32
+	indexes      []int32
+	patchWidth   int
+}
+
+// makeSurfaceBSP builds on makeBSP with vertices, indexes, and surfaces lumps.
+func makeSurfaceBSP(shaders []string, surfaces []fixtureSurface) []byte {
+	buf := makeBSP(shaders, []fixtureEntity{{{"classname", "worldspawn"}}})
+	var verts, indexes, surfs []byte
+	for _, s := range surfaces {
+		surf := make([]byte, bspSurfaceSize)
+		put := func(n, v int) { binary.LittleEndian.PutUint32(surf[n*4:], uint32(v)) }
+		put(0, s.shader)
+		put(2, s.kind)
+		put(3, len(verts)/bspVertSize)
+		put(4, len(s.verts))
+		put(5, len(indexes)/4)
+		put(6, len(s.indexes))
+		if s.patchWidth > 0 {
+			put(24, s.patchWidth)
+			put(25, len(s.verts)/s.patchWidth)
+		}
+		surfs = append(surfs, surf...)
+		for _, v := range s.verts {
+			vert := make([]byte, bspVertSize)
+			for k := range 3 {
+				binary.LittleEndian.PutUint32(vert[k*4:], math.Float32bits(v[k]))
+				binary.LittleEndian.PutUint32(vert[28+k*4:], math.Float32bits(s.normal[k]))
+			}
+			verts = append(verts, vert...)
+		}
+		for _, i := range s.indexes {
+			indexes = binary.LittleEndian.AppendUint32(indexes, uint32(i))
+		}
+	}
+	for _, l := range []struct {
+		lump int
+		data []byte
+	}{{bspLumpVerts, verts}, {bspLumpIndexes, indexes}, {bspLumpSurfaces, surfs}} {
+		binary.LittleEndian.PutUint32(buf[8+l.lump*8:], uint32(len(buf)))
+		binary.LittleEndian.PutUint32(buf[8+l.lump*8+4:], uint32(len(l.data)))
+		buf = append(buf, l.data...)
+	}
+	return buf
+}
+
+func TestBSPSurfaceUsage(t *testing.T) {
+	bsp := makeSurfaceBSP(
+		[]string{"textures/mod/floor", "textures/mod/trim.tga", "textures/common/clip"},
+		[]fixtureSurface{
+			// A 128x128 floor of two triangles
+			{shader: 0, kind: bspSurfacePlanar, verts: [][3]float32{{0, 0, 0}, {128, 0, 0}, {128, 128, 0}, {0, 128, 0}}, indexes: []int32{0, 1, 2, 0, 2, 3}},
+			// A 64x32 arch of 3x3 control points, and a flare on the same shader
+			{shader: 1, kind: bspSurfacePatch, patchWidth: 3, verts: [][3]float32{
+				{0, 0, 0}, {32, 0, 0}, {64, 0, 0},
+				{0, 0, 16}, {32, 0, 16}, {64, 0, 16},
+				{0, 0, 32}, {32, 0, 32}, {64, 0, 32},
+			}},
+			{shader: 1, kind: 4},
+		},
+	)
+	usage, err := ParseBSPSurfaces(bytes.NewReader(bsp), int64(len(bsp)))
+	if err != nil {
+		t.Fatalf("ParseBSPSurfaces: %v", err)
+	}
+	want := []SurfaceUsage{
+		{Shader: "textures/mod/floor", Surfaces: 1, Area: 128 * 128},
+		{Shader: "textures/mod/trim", Surfaces: 2, Area: 64 * 32},
+	}
+	if len(usage) != len(want) {
+		t.Fatalf("usage = %+v, want %+v", usage, want)
+	}
+	for i := range want {
+		if usage[i] != want[i] {
+			t.Errorf("usage[%d] = %+v, want %+v", i, usage[i], want[i])
+		}
+	}
+
+	q := t.TempDir()
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "pak0.pk3"), map[string][]byte{
+		"gfx/2d/crosshaira.tga": fixtureImage("crosshaira"),
+	})
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "map-arch.pk3"), map[string][]byte{
+		"maps/arch.bsp":          bsp,
+		"textures/mod/floor.tga": fixtureImage("floor"),
+		"textures/mod/trim.jpg":  fixtureImage("trim"),
+	})
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	importance, err := MapTextureImportance("Arch", manifest.Games["baseq3"])
+	if err != nil {
+		t.Fatalf("MapTextureImportance: %v", err)
+	}
+	if len(importance) != 2 || importance[0].Texture != "textures/mod/trim.jpg" || importance[1].Texture != "textures/mod/floor.tga" {
+		t.Fatalf("importance = %+v, want the trim before the floor", importance)
+	}
+}
+
+func TestBSPStats(t *testing.T) {
+	bsp := makeSurfaceBSP([]string{"textures/mod/floor"}, []fixtureSurface{{shader: 0, kind: 4}, {shader: 0, kind: 4}})
+	vis := binary.LittleEndian.AppendUint32(nil, 3)
+	vis = binary.LittleEndian.AppendUint32(vis, 1)
+	vis = append(vis, 0x7, 0x7, 0x7)
+	binary.LittleEndian.PutUint32(bsp[8+bspLumpVisData*8:], uint32(len(bsp)))
+	binary.LittleEndian.PutUint32(bsp[8+bspLumpVisData*8+4:], uint32(len(vis)))
+	bsp = append(bsp, vis...)
+
+	stats, err := ParseBSPStats(bytes.NewReader(bsp), int64(len(bsp)))
+	if err != nil {
+		t.Fatalf("ParseBSPStats: %v", err)
+	}
+	if want := (MapStats{Surfaces: 2, Clusters: 3, VisData: 11}); *stats != want {
+		t.Errorf("stats = %+v, want %+v", *stats, want)
+	}
+	if heavy := stats.Heavy(); len(heavy) != 0 {
+		t.Errorf("Heavy() = %v for a tiny map", heavy)
+	}
+	stats.Leafs = heavyMapLeafs + 1
+	if heavy := stats.Heavy(); len(heavy) != 1 {
+		t.Errorf("Heavy() = %v, want the leaf count", heavy)
+	}
+}
@@ -0,0 +1,238 @@
+package assets
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CaseMismatch is a reference whose spelling differs from the file it
+// resolves to only by case. Engines that look files up case-sensitively
+// (loose files on Linux, some ports) won't find it.
+type CaseMismatch struct {
+	Reference string // as written by the referrer
+	File      string // entry name as cased in its pk3
+	Pk3       string
+	Referrer  string // map, model, skin, or shader script making the reference
+}
+
+// AuditCase finds references from maps, models, skins, and the shader
+// scripts they use whose case differs from the file they resolve to. Shader
+// names themselves are matched case-insensitively by the engine and aren't
+// reported. The pk3s in the manifest's file index must still be readable.
+func AuditCase(gm *GameManifest) []CaseMismatch {
+	a := &caseAuditor{gm: gm, seen: make(map[string]bool), shaders: make(map[string]bool)}
+	for _, p := range sortedMapKeys(gm.FileIndex) {
+		switch {
+		case strings.HasPrefix(p, "maps/") && strings.HasSuffix(p, ".bsp"):
+			data, err := readFileFromIndex(p, gm.FileIndex)
+			if err != nil {
+				continue
+			}
+			bsp, err := ParseBSP(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				continue
+			}
+			referrer := gm.OriginalName(p)
+			for _, name := range append(bsp.Shaders, bsp.Advertisements...) {
+				a.shader(name, referrer)
+			}
+			for _, refs := range [][]string{bsp.Models, bsp.Sounds, bsp.Music} {
+				for _, ref := range refs {
+					a.file(ref, referrer)
+				}
+			}
+		case strings.HasSuffix(p, ".md3"):
+			data, err := readFileFromIndex(p, gm.FileIndex)
+			if err != nil {
+				continue
+			}
+			shaders, err := ParseMD3Shaders(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				continue
+			}
+			for _, name := range shaders {
+				a.shader(name, gm.OriginalName(p))
+			}
+		case strings.HasSuffix(p, ".skin"):
+			data, err := readFileFromIndex(p, gm.FileIndex)
+			if err != nil {
+				continue
+			}
+			textures, _ := ParseSkin(bytes.NewReader(data))
+			for _, name := range textures {
+				a.shader(name, gm.OriginalName(p))
+			}
+		}
+	}
+	return a.mismatches
+}
+
+type caseAuditor struct {
+	gm         *GameManifest
+	seen       map[string]bool // reported reference/file pairs
+	shaders    map[string]bool // shader definitions already checked
+	mismatches []CaseMismatch
+}
+
+// shader checks the textures a shader reference uses: those in its
+// definition, or the name itself as an implicit texture.
+func (a *caseAuditor) shader(name, referrer string) {
+	lower := strings.ToLower(name)
+	lower = shaderLookupName(lower)
+	textures, ok := a.gm.Shaders[lower]
+	if !ok || len(textures) == 0 {
+		a.texture(name, referrer)
+		return
+	}
+	if a.shaders[lower] {
+		return
+	}
+	a.shaders[lower] = true
+	script := a.gm.ShaderFiles[lower]
+	if script != "" {
+		script = a.gm.OriginalName(script)
+	}
+	for _, tex := range textures {
+		a.texture(tex, script)
+	}
+}
+
+// texture checks a texture reference, ignoring its extension since the
+// engine tries each image type.
+func (a *caseAuditor) texture(ref, referrer string) {
+	resolved, ok := a.gm.ResolveTexture(ref)
+	if !ok {
+		return
+	}
+	file := a.gm.OriginalName(resolved)
+	if trimTextureExt(ref) != trimTextureExt(file) {
+		a.add(ref, file, resolved, referrer)
+	}
+}
+
+// file checks a reference to an exact path, such as a sound or model.
+func (a *caseAuditor) file(ref, referrer string) {
+	lower := strings.ToLower(ref)
+	if _, ok := a.gm.FileIndex[lower]; !ok {
+		return
+	}
+	if file := a.gm.OriginalName(lower); file != ref {
+		a.add(ref, file, lower, referrer)
+	}
+}
+
+func (a *caseAuditor) add(ref, file, lower, referrer string) {
+	key := ref + "\x00" + file
+	if a.seen[key] {
+		return
+	}
+	a.seen[key] = true
+	a.mismatches = append(a.mismatches, CaseMismatch{
+		Reference: ref,
+		File:      file,
+		Pk3:       a.gm.FileIndex[lower],
+		Referrer:  referrer,
+	})
+}
+
+func trimTextureExt(p string) string {
+	lower := strings.ToLower(p)
+	for _, ext := range textureExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return p[:len(p)-len(ext)]
+		}
+	}
+	return p
+}
+
+// FixCaseMismatches writes a copy of each pk3 with mismatched entries
+// renamed to match how they're referenced, into outDir under the same file
+// name. The install is left untouched. Files referenced with more than one
+// spelling, and sources that aren't pk3s, are skipped with a warning.
+// Returns the pk3s written.
+func FixCaseMismatches(mismatches []CaseMismatch, outDir string) ([]string, error) {
+	renames := make(map[string]map[string]string) // pk3 → entry name → new name
+	conflicts := make(map[string]bool)
+	for _, m := range mismatches {
+		if !strings.HasSuffix(strings.ToLower(m.Pk3), ".pk3") {
+			log.Printf("Warning: can't rename %s in %s: not a pk3", m.File, m.Pk3)
+			continue
+		}
+		// Keep the file's real extension; only the case of the rest changes
+		name := m.Reference
+		if ext := path.Ext(m.File); isTextureFile(strings.ToLower(m.File)) {
+			name = trimTextureExt(m.Reference) + ext
+		}
+		if renames[m.Pk3] == nil {
+			renames[m.Pk3] = make(map[string]string)
+		}
+		if prev, ok := renames[m.Pk3][m.File]; ok && prev != name {
+			conflicts[m.Pk3+"\x00"+m.File] = true
+		}
+		renames[m.Pk3][m.File] = name
+	}
+	for key := range conflicts {
+		pk3, file, _ := strings.Cut(key, "\x00")
+		log.Printf("Warning: %s in %s is referenced with more than one spelling; not renamed", file, filepath.Base(pk3))
+		delete(renames[pk3], file)
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, pk3 := range sortedMapKeys(renames) {
+		if len(renames[pk3]) == 0 {
+			continue
+		}
+		out := filepath.Join(outDir, filepath.Base(pk3))
+		if err := RenamePk3Entries(pk3, out, renames[pk3]); err != nil {
+			return written, err
+		}
+		written = append(written, out)
+	}
+	return written, nil
+}
+
+// RenamePk3Entries copies a pk3 to outPath with entries renamed per renames
+// (old name → new name). Entry data is copied without recompressing.
+func RenamePk3Entries(inPath, outPath string, renames map[string]string) error {
+	if filepath.Clean(inPath) == filepath.Clean(outPath) {
+		return fmt.Errorf("output must differ from input")
+	}
+	r, err := openPk3(inPath)
+	if err != nil {
+		return fmt.Errorf("open pk3 %s: %w", inPath, err)
+	}
+	defer r.Close()
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", outPath, err)
+	}
+	defer out.Close()
+
+	pw := NewPk3Writer(out)
+	for _, f := range r.File {
+		header := f.FileHeader
+		if name, ok := renames[f.Name]; ok {
+			header.Name = name
+		}
+		raw, err := f.OpenRaw()
+		if err != nil {
+			return fmt.Errorf("open %s in %s: %w", f.Name, inPath, err)
+		}
+		if err := pw.addRaw(&header, raw); err != nil {
+			return err
+		}
+	}
+	if err := pw.Close(); err != nil {
+		return fmt.Errorf("finish %s: %w", outPath, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,196 @@
+package assets
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"strings"
+)
+
+// ConfigRef is an asset a .cfg script references.
+type ConfigRef struct {
+	Path string // as written
+	Kind string // exec, model, headmodel, sound, or music
+}
+
+// configModelCvars maps the cvars naming player models to the kind of
+// reference, in "name/skin" form.
+var configModelCvars = map[string]string{
+	"model":          "model",
+	"team_model":     "model",
+	"headmodel":      "headmodel",
+	"team_headmodel": "headmodel",
+}
+
+// ParseConfig parses a .cfg script for the assets it references: configs it
+// execs, player models set through cvars ("model sarge/blue" or "seta model
+// sarge/blue"), and sounds and music it plays. Cvar values are scanned as
+// commands too, since a vstr can run them. Duplicates are dropped.
+func ParseConfig(r io.Reader) ([]ConfigRef, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var refs []ConfigRef
+	seen := make(map[ConfigRef]bool)
+	add := func(p, kind string) {
+		ref := ConfigRef{Path: strings.ReplaceAll(p, "\\", "/"), Kind: kind}
+		if ref.Path == "" || seen[ref] {
+			return
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+
+	var scan func(text string, depth int)
+	scan = func(text string, depth int) {
+		if depth > 8 {
+			return
+		}
+		for _, args := range splitConfigCommands(text) {
+			cmd := strings.ToLower(args[0])
+			switch cmd {
+			case "exec":
+				if len(args) > 1 {
+					add(args[1], "exec")
+				}
+			case "play", "music":
+				kind := "sound"
+				if cmd == "music" {
+					kind = "music"
+				}
+				for _, a := range args[1:] {
+					add(a, kind)
+				}
+			case "set", "seta", "sets", "setu":
+				if len(args) < 3 {
+					continue
+				}
+				value := strings.Join(args[2:], " ")
+				if kind, ok := configModelCvars[strings.ToLower(args[1])]; ok {
+					add(value, kind)
+				} else {
+					scan(value, depth+1)
+				}
+			default:
+				// A bare cvar name with a value sets it
+				if kind, ok := configModelCvars[cmd]; ok && len(args) > 1 {
+					add(args[1], kind)
+				}
+			}
+		}
+	}
+	scan(string(data), 0)
+	return refs, nil
+}
+
+// splitConfigCommands splits script text into commands and their arguments
+// the way the command buffer does: commands end at a newline or a semicolon
+// outside quotes, arguments are separated by whitespace, and // starts a comment.
+func splitConfigCommands(text string) [][]string {
+	var commands [][]string
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	endArg := func() {
+		if inArg {
+			args = append(args, arg.String())
+			arg.Reset()
+			inArg = false
+		}
+	}
+	endCommand := func() {
+		endArg()
+		if len(args) > 0 {
+			commands = append(commands, args)
+			args = nil
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quoted:
+			if c == '"' || c == '\n' {
+				quoted = false
+				endArg()
+				if c == '\n' {
+					endCommand()
+				}
+			} else {
+				arg.WriteByte(c)
+			}
+		case c == '"':
+			endArg()
+			quoted, inArg = true, true
+		case c == '\n' || c == ';':
+			endCommand()
+		case strings.HasPrefix(text[i:], "//"):
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+			endCommand()
+		case c <= ' ':
+			endArg()
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	endCommand()
+	return commands
+}
+
+// isRootConfig reports whether a lowered path is a .cfg at the top of a game
+// directory, where exec finds it by name.
+func isRootConfig(p string) bool {
+	return strings.HasSuffix(p, ".cfg") && !strings.Contains(p, "/")
+}
+
+// resolveConfig adds a config script, the configs it execs, and the assets
+// they reference to needed.
+func resolveConfig(p, from, kind string, gm *GameManifest, needed *depSet) {
+	p = path.Clean(strings.ToLower(p))
+	if needed.files[p] {
+		needed.link(from, kind, p)
+		return
+	}
+	data, err := readFileFromIndex(p, gm.FileIndex)
+	if err != nil {
+		return
+	}
+	needed.add(from, kind, p)
+
+	refs, _ := ParseConfig(bytes.NewReader(data))
+	for _, ref := range refs {
+		lower := strings.ToLower(ref.Path)
+		switch ref.Kind {
+		case "exec":
+			// exec adds .cfg to names without an extension
+			if path.Ext(lower) == "" {
+				lower += ".cfg"
+			}
+			resolveConfig(lower, p, "exec", gm, needed)
+		case "model":
+			resolvePlayerModel(lower, "", PlayerFallback{}, p, gm, needed)
+		case "headmodel":
+			name, skin := splitModelSkin(lower)
+			base := playerModelDir(name)
+			resolveModel(base+"head.md3", p, gm, needed)
+			resolveSkin(base+"head_"+skin+".skin", p, gm, needed)
+		case "sound", "music":
+			if file, ok := resolveSound(lower, gm.FileIndex); ok {
+				needed.add(p, ref.Kind, file)
+			}
+		}
+	}
+}
+
+// resolveConfigs adds the root-level configs in the baseline that come from
+// the given sources, and everything they exec and reference, to needed.
+func resolveConfigs(gm *GameManifest, sources map[string]bool, needed *depSet) {
+	for _, p := range sortedMapKeys(gm.FileIndex) {
+		if isRootConfig(p) && gm.BaselineFiles[p] && sources[gm.FileIndex[p]] {
+			resolveConfig(p, "", "exec", gm, needed)
+		}
+	}
+}
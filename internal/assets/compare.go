@@ -0,0 +1,237 @@
+package assets
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ManifestComparison is what changes from one build of an install to
+// another: run on the manifests from before and after adding or dropping a
+// pk3 to review the effect on maps before publishing.
+type ManifestComparison struct {
+	GamesAdded   []string         `json:"gamesAdded,omitempty"`
+	GamesRemoved []string         `json:"gamesRemoved,omitempty"`
+	Games        []GameComparison `json:"games"` // games in both, in build order
+}
+
+// GameComparison is what changes in one game between two manifests.
+type GameComparison struct {
+	Game           string         `json:"game"`
+	MapsAdded      []string       `json:"mapsAdded,omitempty"`
+	MapsRemoved    []string       `json:"mapsRemoved,omitempty"`
+	ShadersAdded   []string       `json:"shadersAdded,omitempty"`
+	ShadersRemoved []string       `json:"shadersRemoved,omitempty"`
+	ShadersChanged []ShaderChange `json:"shadersChanged,omitempty"`
+	FilesAdded     []string       `json:"filesAdded,omitempty"`
+	FilesRemoved   []string       `json:"filesRemoved,omitempty"`
+	SourcesChanged []SourceChange `json:"sourcesChanged,omitempty"` // files whose winning pk3 changed
+	// BaselineAdded and BaselineRemoved are files moving into or out of
+	// the baseline, and so out of or into map pk3s
+	BaselineAdded   []string `json:"baselineAdded,omitempty"`
+	BaselineRemoved []string `json:"baselineRemoved,omitempty"`
+	// AffectedMaps are maps in both manifests whose BSP changed source or
+	// that use a changed or removed shader
+	AffectedMaps []string `json:"affectedMaps,omitempty"`
+}
+
+// ShaderChange is a shader defined in both manifests with different stages
+// or in a different script.
+type ShaderChange struct {
+	Name           string   `json:"name"`
+	ScriptBefore   string   `json:"scriptBefore"`
+	ScriptAfter    string   `json:"scriptAfter"`
+	TexturesBefore []string `json:"texturesBefore"`
+	TexturesAfter  []string `json:"texturesAfter"`
+}
+
+// SourceChange is a file whose winning copy moved to another pk3. Sources
+// are given as "<game dir>/<pk3>", so manifests of installs at different
+// paths compare.
+type SourceChange struct {
+	Path   string `json:"path"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Empty reports whether the game is unchanged.
+func (c *GameComparison) Empty() bool {
+	return len(c.MapsAdded)+len(c.MapsRemoved)+len(c.ShadersAdded)+len(c.ShadersRemoved)+len(c.ShadersChanged)+
+		len(c.FilesAdded)+len(c.FilesRemoved)+len(c.SourcesChanged)+len(c.BaselineAdded)+len(c.BaselineRemoved) == 0
+}
+
+// CompareManifests reports what changes from manifest a to manifest b.
+func CompareManifests(a, b *Manifest) *ManifestComparison {
+	c := &ManifestComparison{Games: []GameComparison{}}
+	for _, game := range b.GameNames() {
+		if _, ok := a.Games[game]; !ok {
+			c.GamesAdded = append(c.GamesAdded, game)
+		}
+	}
+	for _, game := range a.GameNames() {
+		gb, ok := b.Games[game]
+		if !ok {
+			c.GamesRemoved = append(c.GamesRemoved, game)
+			continue
+		}
+		c.Games = append(c.Games, compareGames(game, a.Games[game], gb))
+	}
+	return c
+}
+
+func compareGames(game string, a, b *GameManifest) GameComparison {
+	c := GameComparison{Game: game}
+	aMaps, bMaps := gameMaps(a), gameMaps(b)
+	c.MapsAdded, c.MapsRemoved = diffKeys(aMaps, bMaps)
+	c.ShadersAdded, c.ShadersRemoved = diffKeys(a.Shaders, b.Shaders)
+	c.FilesAdded, c.FilesRemoved = diffKeys(a.FileIndex, b.FileIndex)
+	c.BaselineAdded, c.BaselineRemoved = diffKeys(a.BaselineFiles, b.BaselineFiles)
+
+	affected := make(map[string]bool)
+	affectShader := func(name string) {
+		for _, ref := range slices.Concat(a.ShaderRefs[name], b.ShaderRefs[name]) {
+			if mapName, ok := bspMapName(ref); ok {
+				affected[mapName] = true
+			}
+		}
+	}
+
+	for _, name := range sortedMapKeys(a.Shaders) {
+		after, ok := b.Shaders[name]
+		if !ok {
+			affectShader(name)
+			continue
+		}
+		before := a.Shaders[name]
+		if a.ShaderFiles[name] != b.ShaderFiles[name] || !slices.Equal(before, after) {
+			c.ShadersChanged = append(c.ShadersChanged, ShaderChange{
+				Name:           name,
+				ScriptBefore:   a.ShaderFiles[name],
+				ScriptAfter:    b.ShaderFiles[name],
+				TexturesBefore: before,
+				TexturesAfter:  after,
+			})
+			affectShader(name)
+		}
+	}
+
+	for _, p := range sortedMapKeys(a.FileIndex) {
+		after, ok := b.FileIndex[p]
+		if !ok {
+			continue
+		}
+		before, after := comparableSource(a.FileIndex[p]), comparableSource(after)
+		if before != after {
+			c.SourcesChanged = append(c.SourcesChanged, SourceChange{Path: p, Before: before, After: after})
+			if mapName, ok := bspMapName(p); ok {
+				affected[mapName] = true
+			}
+		}
+	}
+
+	// Only maps in both builds can be affected; the rest are added or removed
+	for mapName := range affected {
+		if aMaps[mapName] && bMaps[mapName] {
+			c.AffectedMaps = append(c.AffectedMaps, mapName)
+		}
+	}
+	sort.Strings(c.AffectedMaps)
+	return c
+}
+
+// gameMaps returns the names of the maps whose BSPs are in a game's index.
+func gameMaps(gm *GameManifest) map[string]bool {
+	maps := make(map[string]bool)
+	for p := range gm.FileIndex {
+		if mapName, ok := bspMapName(p); ok {
+			maps[mapName] = true
+		}
+	}
+	return maps
+}
+
+// bspMapName returns the map a path like maps/q3dm17.bsp is the BSP of.
+func bspMapName(p string) (string, bool) {
+	if path.Dir(p) != "maps" || path.Ext(p) != ".bsp" {
+		return "", false
+	}
+	return strings.TrimSuffix(path.Base(p), ".bsp"), true
+}
+
+// comparableSource shortens a source pk3 path to "<game dir>/<pk3>".
+func comparableSource(pk3Path string) string {
+	return filepath.Base(filepath.Dir(pk3Path)) + "/" + filepath.Base(pk3Path)
+}
+
+// diffKeys returns the keys only in b and the keys only in a, sorted.
+func diffKeys[V any](a, b map[string]V) (added, removed []string) {
+	for _, k := range sortedMapKeys(b) {
+		if _, ok := a[k]; !ok {
+			added = append(added, k)
+		}
+	}
+	for _, k := range sortedMapKeys(a) {
+		if _, ok := b[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	return added, removed
+}
+
+// WriteText writes the comparison as a summary per game, listing up to
+// limit entries of each kind (0 for all).
+func (c *ManifestComparison) WriteText(w io.Writer, limit int) {
+	list := func(label string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "  %s (%d):\n", label, len(items))
+		for i, item := range items {
+			if limit > 0 && i == limit {
+				fmt.Fprintf(w, "    ... and %d more\n", len(items)-limit)
+				break
+			}
+			fmt.Fprintf(w, "    %s\n", item)
+		}
+	}
+
+	for _, game := range c.GamesAdded {
+		fmt.Fprintf(w, "Game added: %s\n", game)
+	}
+	for _, game := range c.GamesRemoved {
+		fmt.Fprintf(w, "Game removed: %s\n", game)
+	}
+	for _, g := range c.Games {
+		if g.Empty() {
+			fmt.Fprintf(w, "%s: no changes\n", g.Game)
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", g.Game)
+		list("Maps added", g.MapsAdded)
+		list("Maps removed", g.MapsRemoved)
+		list("Maps affected", g.AffectedMaps)
+		list("Shaders added", g.ShadersAdded)
+		list("Shaders removed", g.ShadersRemoved)
+		changed := make([]string, len(g.ShadersChanged))
+		for i, s := range g.ShadersChanged {
+			changed[i] = s.Name
+			if s.ScriptBefore != s.ScriptAfter {
+				changed[i] += fmt.Sprintf(" (%s -> %s)", s.ScriptBefore, s.ScriptAfter)
+			}
+		}
+		list("Shaders changed", changed)
+		sources := make([]string, len(g.SourcesChanged))
+		for i, s := range g.SourcesChanged {
+			sources[i] = fmt.Sprintf("%s: %s -> %s", s.Path, s.Before, s.After)
+		}
+		list("Files changing source", sources)
+		list("Files added", g.FilesAdded)
+		list("Files removed", g.FilesRemoved)
+		list("Baseline files added", g.BaselineAdded)
+		list("Baseline files removed", g.BaselineRemoved)
+	}
+}
@@ -0,0 +1,62 @@
+package assets
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompareManifests(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	build := func() *Manifest {
+		t.Helper()
+		out := t.TempDir()
+		if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		m, err := LoadManifest(filepath.Join(out, "manifest.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	before := build()
+
+	// A mod pk3 replacing the custom map's sky and wind, and adding a map
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "zz-mod.pk3"), map[string][]byte{
+		"scripts/custom.shader":     []byte("textures/custom/sky\n{\n\tskyparms textures/custom/night - -\n\t{\n\t\tmap textures/custom/night.jpg\n\t}\n}\n"),
+		"textures/custom/night.jpg": fixtureImage("night"),
+		"sound/custom/wind.wav":     []byte("RIFF louder wind"),
+		"maps/extra.bsp":            makeBSP([]string{"textures/base_wall/metal"}, []fixtureEntity{{{"classname", "worldspawn"}}}),
+	})
+	after := build()
+
+	c := CompareManifests(before, after)
+	if len(c.Games) == 0 || c.Games[0].Game != "baseq3" {
+		t.Fatalf("games = %+v", c.Games)
+	}
+	g := c.Games[0]
+	if !slices.Equal(g.MapsAdded, []string{"extra"}) || len(g.MapsRemoved) != 0 {
+		t.Errorf("maps added %v, removed %v", g.MapsAdded, g.MapsRemoved)
+	}
+	if len(g.ShadersChanged) != 1 || g.ShadersChanged[0].Name != "textures/custom/sky" {
+		t.Errorf("shaders changed = %+v", g.ShadersChanged)
+	}
+	if !slices.Equal(g.AffectedMaps, []string{"custom"}) {
+		t.Errorf("affected maps = %v, want [custom]", g.AffectedMaps)
+	}
+	sources := make(map[string]SourceChange)
+	for _, s := range g.SourcesChanged {
+		sources[s.Path] = s
+	}
+	if s := sources["sound/custom/wind.wav"]; s.Before != "baseq3/map-custom.pk3" || s.After != "baseq3/zz-mod.pk3" {
+		t.Errorf("wind source change = %+v", s)
+	}
+	if !slices.Contains(g.FilesAdded, "textures/custom/night.jpg") {
+		t.Errorf("files added = %v", g.FilesAdded)
+	}
+
+	if c := CompareManifests(before, before); !c.Games[0].Empty() {
+		t.Errorf("manifest differs from itself: %+v", c.Games[0])
+	}
+}
@@ -1,20 +1,25 @@
 package assets
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/klauspost/compress/zstd"
 )
 
-// Q3 configstring indices
+// Q3 configstring indices (see ConfigstringLayout for the asset runs)
 const (
 	csServerInfo = 0
 	csSystemInfo = 1
@@ -38,7 +43,29 @@ const (
 	numPlayerFields = 48
 )
 
-// entityFieldBits defines the bit width for each entityState_t netField.
+// Size limits for untrusted demos. Frames are bounded by the engine's
+// MAX_MSGLEN many times over; the decoder cap bounds the zstd window a
+// hostile file can request.
+const (
+	maxDemoDecoderMemory = 256 << 20
+	maxDemoFrameSize     = 1 << 20
+	maxConfigstringSize  = 8192
+)
+
+// Errors ParseDemoReader returns when a stream exceeds its DemoParseOptions.
+var (
+	ErrDemoTooLarge      = errors.New("demo exceeds size limit")
+	ErrDemoTooManyFrames = errors.New("demo exceeds frame limit")
+)
+
+// DemoParseOptions limits how much of an untrusted stream ParseDemoReader
+// reads. Zero means no limit.
+type DemoParseOptions struct {
+	MaxSize   int64 // bytes read from the stream, trailer included
+	MaxFrames int   // frames decoded
+}
+
+// entityFieldBits defines the bit width for each protocol 68 entityState_t netField.
 // 0 = float, positive = unsigned int bits, from msg.c entityStateFields[].
 var entityFieldBits = [numEntityFields]int{
 	32, 0, 0, 0, 0, 0, 0, 0, 0, // pos.trTime, pos.trBase[0..2], pos.trDelta[0..2], apos.trBase[1], apos.trBase[0]
@@ -52,7 +79,7 @@ var entityFieldBits = [numEntityFields]int{
 	32, 0, 0, 0, 32, 16,        // time2, angles[2], angles2[0], angles2[2], constantLight, frame
 }
 
-// playerFieldBits defines the bit width for each playerState_t netField.
+// playerFieldBits defines the bit width for each protocol 68 playerState_t netField.
 // 0 = float, negative = signed int, from msg.c playerStateFields[].
 var playerFieldBits = [numPlayerFields]int{
 	32, 0, 0, 8, 0, 0, 0, 0,    // commandTime, origin[0..1], bobCycle, velocity[0..1], viewangles[1..0]
@@ -71,13 +98,17 @@ type DemoInfo struct {
 	GameType    int
 	Models      []string
 	Sounds      []string
+	Videos      []string // RoQ cinematics named in any configstring
 	PlayerInfos []PlayerInfo
+	Trailer     *DemoTrailer // nil if the demo has no trailer
+	Warnings    []string     `json:",omitempty"` // problems that didn't stop parsing, such as a truncated frame stream
 }
 
 // PlayerInfo holds player model information from a demo.
 type PlayerInfo struct {
 	Model  string
 	HModel string
+	Bot    string // bot name, for players the server marks with a skill
 }
 
 // ParseDemo parses a .tvd demo file and extracts asset references.
@@ -90,115 +121,334 @@ type PlayerInfo struct {
 //   - null-terminated string: timestamp
 //   - configstrings: repeated [index:u16][length:u16][data:bytes], terminated by index 0xFFFF
 //   - zstd-compressed demo frames follow with additional configstring updates
+//   - optional trailer (see DemoTrailer)
 func ParseDemo(path string) (*DemoInfo, error) {
-	data, err := os.ReadFile(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read demo: %w", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
 	if err != nil {
 		return nil, fmt.Errorf("read demo: %w", err)
 	}
 
-	if len(data) < 20 || string(data[0:4]) != "TVD1" {
-		return nil, fmt.Errorf("not a TVD file")
+	info, err := parseDemoAt(f, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range info.Warnings {
+		log.Printf("Demo: %s: %s", filepath.Base(path), w)
 	}
+	return info, nil
+}
 
-	offset := 16 // skip magic(4) + protocol(4) + sv_fps(4) + maxclients(4)
+// ParseDemoBytes parses a whole demo held in memory, as ParseDemo does a
+// file. It touches neither the filesystem nor the log, so it can run where
+// neither exists (see cmd/trinity-wasm); problems that don't stop parsing
+// are returned in Warnings.
+func ParseDemoBytes(data []byte) (*DemoInfo, error) {
+	return parseDemoAt(bytes.NewReader(data), int64(len(data)))
+}
 
-	// Skip mapname (null-terminated)
-	for offset < len(data) && data[offset] != 0 {
-		offset++
+// parseDemoAt parses the demo in r, which is size bytes long, including its
+// trailer.
+func parseDemoAt(r io.ReaderAt, size int64) (*DemoInfo, error) {
+	info, err := parseDemoStream(bufio.NewReader(io.NewSectionReader(r, 0, size)), DemoParseOptions{})
+	demosParsed.With(resultLabel(err)).Inc()
+	if err != nil {
+		return nil, err
 	}
-	offset++ // skip null
+	if trailer, _, err := readDemoTrailer(r, size); err == nil {
+		info.Trailer = trailer
+	} else if !errors.Is(err, ErrNoDemoTrailer) {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("ignoring bad trailer: %v", err))
+	}
+	return info, nil
+}
 
-	// Skip timestamp (null-terminated)
-	for offset < len(data) && data[offset] != 0 {
-		offset++
+// ParseDemoReader parses a demo from a stream, such as an upload's request
+// body, without buffering it. It reads r to the end, so a caller teeing r to
+// a file or hash sees every byte, and returns ErrDemoTooLarge or
+// ErrDemoTooManyFrames once the stream passes opts' limits. The trailer sits
+// at the end of the file and can't be found without seeking, so Trailer is
+// always nil; read it from the stored file with ReadDemoTrailer. Problems
+// that don't stop parsing are returned in Warnings, as by ParseDemoBytes.
+func ParseDemoReader(r io.Reader, opts DemoParseOptions) (*DemoInfo, error) {
+	limited := &demoSizeLimiter{r: r, remaining: opts.MaxSize}
+	if opts.MaxSize <= 0 {
+		limited.remaining = math.MaxInt64 - 1 // room for the byte past the limit
+	}
+	br := bufio.NewReader(limited)
+
+	info, err := parseDemoStream(br, opts)
+	if err == nil {
+		// Consume the rest of the frames and the trailer
+		if _, cerr := io.Copy(io.Discard, br); cerr != nil && !limited.exceeded {
+			err = fmt.Errorf("read demo: %w", cerr)
+		}
+	}
+	if limited.exceeded {
+		err = ErrDemoTooLarge
 	}
-	offset++ // skip null
+	demosParsed.With(resultLabel(err)).Inc()
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
 
-	// Read header configstrings
-	configstrings := make(map[int]string)
-	for offset+4 <= len(data) {
-		index := int(binary.LittleEndian.Uint16(data[offset:]))
-		offset += 2
+// demoSizeLimiter fails reads with ErrDemoTooLarge once more than remaining
+// bytes have been read. Unlike io.LimitReader, the overrun is an error, not
+// a silent end of stream, and it's recorded in case a parser swallows it.
+type demoSizeLimiter struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
 
-		if index == 0xFFFF {
-			break // end of configstrings
+func (l *demoSizeLimiter) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrDemoTooLarge
+	}
+	// Read one byte past the limit to tell a stream that ends exactly at it
+	// from one that goes on
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		return int(l.remaining), ErrDemoTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// parseDemoStream parses a TVD from r, reading the header directly and then
+// stream-decoding the frame data. A frame limit in opts is an error; opts'
+// size limit is enforced by the caller's reader.
+func parseDemoStream(r *bufio.Reader, opts DemoParseOptions) (*DemoInfo, error) {
+	version, configstrings, err := readDemoHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse zstd-compressed frame data for configstring updates. Frames of an
+	// unknown protocol can't be decoded, but the header configstrings still
+	// name most assets, assuming Quake III's configstring layout.
+	var warnings []string
+	layout := configstringsQ3
+	if p, err := LookupProtocol(version); err != nil {
+		warnings = append(warnings, fmt.Sprintf("%v; skipping frames", err))
+	} else {
+		layout = p.Configstrings
+		if _, err := r.Peek(1); err == nil {
+			if frames, err := parseFrameConfigstrings(r, p, configstrings, opts.MaxFrames); errors.Is(err, ErrDemoTooManyFrames) {
+				return nil, err
+			} else if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%v (after %d frames)", err, frames))
+			}
 		}
+	}
+
+	info := buildDemoInfo(configstrings, layout)
+	info.Warnings = warnings
+	return info, nil
+}
 
-		length := int(binary.LittleEndian.Uint16(data[offset:]))
-		offset += 2
+// readDemoHeader reads the fixed TVD header and header configstrings,
+// returning the protocol version and configstrings and leaving r positioned
+// at the start of the zstd frame stream.
+func readDemoHeader(r *bufio.Reader) (int, map[int]string, error) {
+	var fixed [16]byte // magic(4) + protocol(4) + sv_fps(4) + maxclients(4)
+	if _, err := io.ReadFull(r, fixed[:]); err != nil || string(fixed[0:4]) != "TVD1" {
+		return 0, nil, ErrNotTVD
+	}
+	version := int(int32(binary.LittleEndian.Uint32(fixed[4:8])))
 
-		if offset+length > len(data) {
+	// Skip mapname and timestamp (null-terminated)
+	for i := 0; i < 2; i++ {
+		if err := skipCString(r, maxConfigstringSize); err != nil {
+			return 0, nil, fmt.Errorf("read demo header: %w", err)
+		}
+	}
+	return version, readHeaderConfigstrings(r), nil
+}
+
+// readHeaderConfigstrings reads the header's [index][length][data] entries up
+// to the 0xFFFF terminator.
+func readHeaderConfigstrings(r *bufio.Reader) map[int]string {
+	configstrings := make(map[int]string)
+	var entry [4]byte
+	for {
+		if _, err := io.ReadFull(r, entry[:2]); err != nil {
 			break
 		}
+		index := int(binary.LittleEndian.Uint16(entry[0:2]))
+		if index == 0xFFFF {
+			break // end of configstrings
+		}
+		if _, err := io.ReadFull(r, entry[2:4]); err != nil {
+			break
+		}
+		length := int(binary.LittleEndian.Uint16(entry[2:4]))
 
-		value := string(data[offset : offset+length])
-		offset += length
+		value := make([]byte, length)
+		if _, err := io.ReadFull(r, value); err != nil {
+			break
+		}
+		if length > 0 {
+			configstrings[index] = string(value)
+		}
+	}
+	return configstrings
+}
 
-		if value != "" {
-			configstrings[index] = value
+// skipCString consumes a null-terminated string of at most max bytes.
+func skipCString(r *bufio.Reader, max int) error {
+	for i := 0; i <= max; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			return nil
 		}
 	}
+	return fmt.Errorf("string longer than %d bytes", max)
+}
 
-	// Parse zstd-compressed frame data for configstring updates
-	if offset < len(data) {
-		parseFrameConfigstrings(data[offset:], configstrings)
+// forEachDemoFrame stream-decodes a zstd frame stream and calls fn with each
+// frame and the offset of its size prefix in the decompressed stream. Memory
+// use doesn't grow with demo length; frame is only valid during the call.
+// Returns the number of frames read. A clean end of stream and trailing
+// non-zstd data (the file trailer) end iteration without error.
+func forEachDemoFrame(compressed io.Reader, fn func(offset int64, frame []byte) error) (int, error) {
+	stream, err := newFrameStream(compressed)
+	if err != nil {
+		return 0, err
 	}
+	defer stream.close()
+	return stream.each(fn)
+}
 
-	return buildDemoInfo(configstrings), nil
+// frameStream reads size-prefixed frames from a zstd-compressed frame stream.
+type frameStream struct {
+	decoder *pooledDecoder // nil if r is decompressed by someone else
+	r       io.Reader      // the decompressed frame stream
+	offset  int64          // decompressed offset of the next frame
+	buf     []byte
 }
 
-// parseFrameConfigstrings decompresses the zstd frame stream and extracts
-// configstring updates from each frame. This catches players joining mid-match.
-func parseFrameConfigstrings(compressedData []byte, configstrings map[int]string) {
-	decoder, err := zstd.NewReader(bytes.NewReader(compressedData))
+func newFrameStream(compressed io.Reader) (*frameStream, error) {
+	decoder, err := getDemoDecoder(compressed)
 	if err != nil {
-		log.Printf("Demo: zstd decoder init error: %v", err)
-		return
+		return nil, err
 	}
-	defer decoder.Close()
+	return &frameStream{decoder: decoder, r: decoder}, nil
+}
 
-	decompressed, err := io.ReadAll(decoder)
-	if errors.Is(err, zstd.ErrMagicMismatch) {
-		err = nil // trailing non-zstd data (file trailer) is expected
+// next returns the next frame and its offset, or io.EOF at the end of the
+// stream. The frame is only valid until the following call.
+func (s *frameStream) next() (int64, []byte, error) {
+	// Read frame size (4 raw bytes)
+	var sizeBuf [4]byte
+	if _, err := io.ReadFull(s.r, sizeBuf[:]); err != nil {
+		return 0, nil, frameStreamError(err)
 	}
-	if err != nil {
-		log.Printf("Demo: zstd decompress error (read %d bytes): %v", len(decompressed), err)
-		if len(decompressed) == 0 {
-			return
-		}
+	frameSize := int(binary.LittleEndian.Uint32(sizeBuf[:]))
+	if frameSize == 0 || frameSize > maxDemoFrameSize {
+		return 0, nil, io.EOF
 	}
 
-	pos := 0
-	frameCount := 0
-	csUpdates := 0
+	if cap(s.buf) < frameSize {
+		s.buf = make([]byte, frameSize)
+	}
+	frame := s.buf[:frameSize]
+	if _, err := io.ReadFull(s.r, frame); err != nil {
+		return 0, nil, frameStreamError(err)
+	}
 
-	for pos+4 <= len(decompressed) {
-		// Read frame size (4 raw bytes)
-		frameSize := int(binary.LittleEndian.Uint32(decompressed[pos:]))
-		pos += 4
+	offset := s.offset
+	s.offset += 4 + int64(frameSize)
+	return offset, frame, nil
+}
 
-		if frameSize == 0 || pos+frameSize > len(decompressed) {
-			break
+// each calls fn with every remaining frame and its offset, and returns how
+// many it read.
+func (s *frameStream) each(fn func(offset int64, frame []byte) error) (int, error) {
+	frameCount := 0
+	for {
+		offset, frame, err := s.next()
+		if err == io.EOF {
+			return frameCount, nil
+		}
+		if err != nil {
+			return frameCount, err
+		}
+		if err := fn(offset, frame); err != nil {
+			return frameCount, err
 		}
-
-		frameData := decompressed[pos : pos+frameSize]
-		pos += frameSize
 		frameCount++
+	}
+}
 
-		// Parse this frame's Huffman-encoded data for configstrings
-		n := parseOneFrame(frameData, configstrings)
-		csUpdates += n
+// skip discards n bytes of the decompressed stream, which must end on a
+// frame boundary, without splitting them into frames.
+func (s *frameStream) skip(n int64) error {
+	if _, err := io.CopyN(io.Discard, s.r, n); err != nil {
+		return frameStreamError(err)
+	}
+	s.offset += n
+	return nil
+}
+
+// reset restarts decoding from a new compressed stream, whose first byte
+// decompresses to the frame stream's offset.
+func (s *frameStream) reset(compressed io.Reader, offset int64) error {
+	s.offset = offset
+	return s.decoder.Reset(compressed)
+}
+
+func (s *frameStream) close() {
+	if s.decoder != nil {
+		putDemoDecoder(s.decoder)
 	}
+}
 
-	if csUpdates > 0 {
-		log.Printf("Demo: parsed %d frames, found %d configstring updates", frameCount, csUpdates)
+// frameStreamError maps the expected ways a frame stream ends to io.EOF.
+func frameStreamError(err error) error {
+	if err == io.EOF || errors.Is(err, zstd.ErrMagicMismatch) {
+		return io.EOF
+	}
+	if errors.Is(err, zstd.ErrUnknownDictionary) {
+		return fmt.Errorf("zstd decompress: %w (the demo was recompressed with a dictionary; set assets.demo_dictionary)", err)
 	}
+	return fmt.Errorf("zstd decompress: %w", err)
+}
+
+// parseFrameConfigstrings extracts configstring updates from each frame of
+// the zstd frame stream. This catches players joining mid-match. Returns the
+// number of frames read, and any error that ended the stream early, which is
+// ErrDemoTooManyFrames past maxFrames if that's nonzero.
+func parseFrameConfigstrings(compressed io.Reader, p *Protocol, configstrings map[int]string, maxFrames int) (int, error) {
+	frames := 0
+	return forEachDemoFrame(compressed, func(_ int64, frame []byte) error {
+		if frames++; maxFrames > 0 && frames > maxFrames {
+			return ErrDemoTooManyFrames
+		}
+		// Parse this frame's Huffman-encoded data for configstrings
+		parseOneFrame(frame, p, configstrings)
+		return nil
+	})
 }
 
 // parseOneFrame parses a single Huffman-encoded frame and extracts configstring
 // updates. Returns the number of configstrings found.
-func parseOneFrame(frameData []byte, configstrings map[int]string) int {
+func parseOneFrame(frameData []byte, p *Protocol, configstrings map[int]string) int {
 	msg := NewMsgReader(frameData)
+	msg.Protocol = p
 
 	// Server time
 	msg.ReadLong()
@@ -212,10 +462,10 @@ func parseOneFrame(frameData []byte, configstrings map[int]string) int {
 		if entityNum == maxGentities-1 {
 			break // end marker
 		}
-		if msg.Remaining() < 2 {
+		if msg.Overflowed() {
 			return 0 // truncated frame
 		}
-		skipEntityDelta(msg)
+		readEntityDelta(msg, nil)
 	}
 
 	// Player bitmask (MAX_CLIENTS/8 = 8 bytes)
@@ -227,7 +477,7 @@ func parseOneFrame(frameData []byte, configstrings map[int]string) int {
 			continue
 		}
 		msg.ReadByte() // clientNum
-		skipPlayerDelta(msg)
+		readPlayerDelta(msg, nil)
 	}
 
 	// Read configstring updates
@@ -240,62 +490,77 @@ func parseOneFrame(frameData []byte, configstrings map[int]string) int {
 		csIndex := msg.ReadShort()
 		csLen := msg.ReadShort()
 
-		if csLen > 0 && csLen < 8192 {
+		if csLen > 0 && csLen < maxConfigstringSize {
 			csData := msg.ReadData(csLen)
-			configstrings[csIndex] = string(csData)
+			if msg.Overflowed() {
+				return i // truncated frame; keep what was complete
+			}
+			if csIndex < csMax {
+				configstrings[csIndex] = string(csData)
+			}
 		}
 	}
 
 	return csCount
 }
 
-// skipEntityDelta skips one MSG_ReadDeltaEntity worth of data.
-// Entity fields use zero-value optimization for both floats and ints.
-func skipEntityDelta(msg *MsgReader) {
+// readEntityDelta reads one MSG_ReadDeltaEntity worth of data into es, which
+// holds the entity's previous state. A nil es skips the data. Entity fields
+// use zero-value optimization for both floats and ints. Returns false if the
+// delta removes the entity.
+func readEntityDelta(msg *MsgReader, es *EntityState) bool {
 	// Check for remove
 	if msg.ReadBits(1) == 1 {
-		return
+		return false
 	}
 	// Check for no delta
 	if msg.ReadBits(1) == 0 {
-		return
+		return true
 	}
 
-	lc := int(msg.ReadByte())
-	if lc > numEntityFields {
-		return
+	fieldBits := msg.Protocol.EntityFieldBits
+	lc := msg.ReadBits(8)
+	if lc > len(fieldBits) {
+		return true
 	}
 
 	for i := 0; i < lc; i++ {
 		if msg.ReadBits(1) == 0 {
 			continue // field unchanged
 		}
-		bits := entityFieldBits[i]
+		var value uint32
+		bits := fieldBits[i]
 		if bits == 0 {
 			// Float with zero-value check
 			if msg.ReadBits(1) == 0 {
-				// value is 0.0
+				value = 0
 			} else if msg.ReadBits(1) == 0 {
-				msg.ReadBits(floatIntBits) // integral float
+				value = integralFloat(msg.ReadBits(floatIntBits))
 			} else {
-				msg.ReadBits(32) // full float
+				value = uint32(msg.ReadBits(32)) // full float
 			}
 		} else {
 			// Integer with zero-value check
 			if msg.ReadBits(1) == 0 {
-				// value is 0
+				value = 0
 			} else {
-				msg.ReadBits(bits)
+				value = uint32(msg.ReadBits(bits))
 			}
 		}
+		if es != nil {
+			es.Fields[i] = value
+		}
 	}
+	return true
 }
 
-// skipPlayerDelta skips one MSG_ReadDeltaPlayerstate worth of data.
+// readPlayerDelta reads one MSG_ReadDeltaPlayerstate worth of data into ps,
+// which holds the client's previous state. A nil ps skips the data.
 // Player fields do NOT have the zero-value optimization that entities have.
-func skipPlayerDelta(msg *MsgReader) {
-	lc := int(msg.ReadByte())
-	if lc > numPlayerFields {
+func readPlayerDelta(msg *MsgReader, ps *PlayerState) {
+	p := msg.Protocol
+	lc := msg.ReadBits(8)
+	if lc > len(p.PlayerFieldBits) {
 		return
 	}
 
@@ -303,20 +568,23 @@ func skipPlayerDelta(msg *MsgReader) {
 		if msg.ReadBits(1) == 0 {
 			continue // field unchanged
 		}
-		bits := playerFieldBits[i]
-		if bits < 0 {
-			bits = -bits
-		}
+		var value uint32
+		bits := p.PlayerFieldBits[i]
 		if bits == 0 {
 			// Float — no zero check for players
 			if msg.ReadBits(1) == 0 {
-				msg.ReadBits(floatIntBits) // integral float
+				value = integralFloat(msg.ReadBits(floatIntBits))
 			} else {
-				msg.ReadBits(32) // full float
+				value = uint32(msg.ReadBits(32)) // full float
 			}
+		} else if bits < 0 {
+			value = uint32(signExtend(msg.ReadBits(-bits), -bits))
 		} else {
 			// Integer — no zero check for players
-			msg.ReadBits(bits)
+			value = uint32(msg.ReadBits(bits))
+		}
+		if ps != nil {
+			ps.Fields[i] = value
 		}
 	}
 
@@ -325,48 +593,48 @@ func skipPlayerDelta(msg *MsgReader) {
 		return
 	}
 
-	// stats
-	if msg.ReadBits(1) != 0 {
-		bits := msg.ReadBits(maxStats)
-		for i := 0; i < maxStats; i++ {
-			if bits&(1<<uint(i)) != 0 {
-				msg.ReadShort()
-			}
-		}
+	var stats, persistant, ammo, powerups *[16]int
+	if ps != nil {
+		stats, persistant, ammo, powerups = &ps.Stats, &ps.Persistant, &ps.Ammo, &ps.Powerups
 	}
+	readDeltaArray(msg, p.MaxStats, stats, func() int { return signExtend(msg.ReadShort(), 16) })
+	readDeltaArray(msg, p.MaxPersistant, persistant, func() int { return signExtend(msg.ReadShort(), 16) })
+	readDeltaArray(msg, p.MaxWeapons, ammo, func() int { return signExtend(msg.ReadShort(), 16) })
+	readDeltaArray(msg, p.MaxPowerups, powerups, func() int { return int(int32(msg.ReadLong())) })
+}
 
-	// persistant
-	if msg.ReadBits(1) != 0 {
-		bits := msg.ReadBits(maxPersistant)
-		for i := 0; i < maxPersistant; i++ {
-			if bits&(1<<uint(i)) != 0 {
-				msg.ReadShort()
-			}
-		}
+// readDeltaArray reads one of the playerstate arrays: a changed flag, a
+// bitmask of changed slots, then a value per set bit.
+func readDeltaArray(msg *MsgReader, n int, dst *[16]int, read func() int) {
+	if msg.ReadBits(1) == 0 {
+		return
 	}
-
-	// ammo
-	if msg.ReadBits(1) != 0 {
-		bits := msg.ReadBits(maxWeapons)
-		for i := 0; i < maxWeapons; i++ {
-			if bits&(1<<uint(i)) != 0 {
-				msg.ReadShort()
+	bits := msg.ReadBits(n)
+	for i := 0; i < n; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			v := read()
+			if dst != nil {
+				dst[i] = v
 			}
 		}
 	}
+}
 
-	// powerups
-	if msg.ReadBits(1) != 0 {
-		bits := msg.ReadBits(maxPowerups)
-		for i := 0; i < maxPowerups; i++ {
-			if bits&(1<<uint(i)) != 0 {
-				msg.ReadLong()
-			}
-		}
+// integralFloat converts a FLOAT_INT_BITS truncated value to float32 bits.
+func integralFloat(trunc int) uint32 {
+	return math.Float32bits(float32(trunc - floatIntBias))
+}
+
+// signExtend interprets the low n bits of v as a signed integer.
+func signExtend(v, n int) int {
+	if v&(1<<uint(n-1)) != 0 {
+		v |= -1 << uint(n)
 	}
+	return v
 }
 
-func buildDemoInfo(configstrings map[int]string) *DemoInfo {
+// buildDemoInfo collects the assets configstrings laid out as layout name.
+func buildDemoInfo(configstrings map[int]string, layout ConfigstringLayout) *DemoInfo {
 	info := &DemoInfo{}
 
 	// Parse serverinfo (CS 0)
@@ -389,9 +657,9 @@ func buildDemoInfo(configstrings map[int]string) *DemoInfo {
 		}
 	}
 
-	// Collect models (CS 32+)
+	// Collect models
 	seen := make(map[string]bool)
-	for i := csModels; i < csModels+256; i++ {
+	for i := layout.Models; i < layout.Models+256; i++ {
 		if v, ok := configstrings[i]; ok && v != "" && !strings.HasPrefix(v, "*") {
 			if !seen[v] {
 				seen[v] = true
@@ -400,9 +668,9 @@ func buildDemoInfo(configstrings map[int]string) *DemoInfo {
 		}
 	}
 
-	// Collect sounds (CS 288+)
+	// Collect sounds
 	seen = make(map[string]bool)
-	for i := csSounds; i < csSounds+256; i++ {
+	for i := layout.Sounds; i < layout.Sounds+256; i++ {
 		if v, ok := configstrings[i]; ok && v != "" {
 			if !seen[v] {
 				seen[v] = true
@@ -411,9 +679,20 @@ func buildDemoInfo(configstrings map[int]string) *DemoInfo {
 		}
 	}
 
-	// Collect player infos (CS 544+)
+	// Collect cinematics, which mods may name in any configstring
+	seen = make(map[string]bool)
+	for _, i := range slices.Sorted(maps.Keys(configstrings)) {
+		for _, v := range findVideoRefs(configstrings[i]) {
+			if !seen[v] {
+				seen[v] = true
+				info.Videos = append(info.Videos, v)
+			}
+		}
+	}
+
+	// Collect player infos
 	seen = make(map[string]bool)
-	for i := csPlayers; i < csPlayers+64; i++ {
+	for i := layout.Players; i < layout.Players+maxClients; i++ {
 		if v, ok := configstrings[i]; ok && v != "" {
 			kvs := parseBackslashKV(v)
 			model := kvs["model"]
@@ -421,8 +700,12 @@ func buildDemoInfo(configstrings map[int]string) *DemoInfo {
 			if model == "" {
 				continue
 			}
-			// Deduplicate by model+hmodel combination
-			key := model + "|" + hmodel
+			var bot string
+			if _, ok := kvs["skill"]; ok {
+				bot = stripColorCodes(kvs["n"])
+			}
+			// Deduplicate by model+hmodel combination (and bot)
+			key := model + "|" + hmodel + "|" + bot
 			if seen[key] {
 				continue
 			}
@@ -430,6 +713,7 @@ func buildDemoInfo(configstrings map[int]string) *DemoInfo {
 			info.PlayerInfos = append(info.PlayerInfos, PlayerInfo{
 				Model:  model,
 				HModel: hmodel,
+				Bot:    bot,
 			})
 		}
 	}
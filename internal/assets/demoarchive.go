@@ -0,0 +1,257 @@
+package assets
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DemoArchiveExt is the extension of demo archives.
+const DemoArchiveExt = ".tvda"
+
+// demoArchiveIndexName is the archive entry holding the DemoArchiveIndex.
+const demoArchiveIndexName = "index.json"
+
+// DemoArchiveIndex is the central index of a demo archive: every demo's
+// parsed metadata, so listing and searching an archive reads one entry.
+type DemoArchiveIndex struct {
+	Demos []ArchivedDemo `json:"demos"`
+}
+
+// ArchivedDemo is one demo in an archive.
+type ArchivedDemo struct {
+	ID      string       `json:"id"` // path relative to the packed directory, without .tvd
+	Size    int64        `json:"size"`
+	SHA256  string       `json:"sha256"`
+	Sidecar *DemoSidecar `json:"demo"`
+}
+
+// demoArchiveEntry is the archive entry a demo is stored in.
+func demoArchiveEntry(id string) string {
+	return "demos/" + id + ".tvd"
+}
+
+// PackDemoArchive bundles every .tvd demo under dir into a demo archive at
+// outPath: a zip with the demos stored uncompressed (their frame streams are
+// already zstd) under demos/, and a DemoArchiveIndex in index.json. Demos
+// that fail to parse are skipped with a warning. Returns the index written.
+func PackDemoArchive(outPath, dir string) (*DemoArchiveIndex, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".tvd") {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	tmp, err := os.CreateTemp(filepath.Dir(outPath), ".pack-*"+DemoArchiveExt)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	index := &DemoArchiveIndex{Demos: []ArchivedDemo{}}
+	zw := zip.NewWriter(tmp)
+	for _, p := range paths {
+		rel, _ := filepath.Rel(dir, p)
+		id := strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+		demo, err := archiveDemo(zw, p, id)
+		if err != nil {
+			if demo == nil {
+				tmp.Close()
+				return nil, fmt.Errorf("pack %s: %w", rel, err)
+			}
+			log.Printf("Warning: pack %s: %v", rel, err)
+			continue
+		}
+		index.Demos = append(index.Demos, *demo)
+	}
+
+	data, err := json.Marshal(index)
+	if err == nil {
+		var w io.Writer
+		if w, err = zw.Create(demoArchiveIndexName); err == nil {
+			_, err = w.Write(data)
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write %s: %w", filepath.Base(outPath), err)
+	}
+	if err := os.Rename(tmp.Name(), outPath); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// archiveDemo stores one demo in zw. A demo that doesn't parse is skipped
+// before anything is written, returning a non-nil ArchivedDemo with the
+// error; a nil ArchivedDemo means the archive itself failed.
+func archiveDemo(zw *zip.Writer, path, id string) (*ArchivedDemo, error) {
+	sidecar, err := BuildDemoSidecar(path, nil)
+	if err != nil {
+		return &ArchivedDemo{ID: id}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return &ArchivedDemo{ID: id}, err
+	}
+	defer f.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: demoArchiveEntry(id), Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), f)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchivedDemo{
+		ID:      id,
+		Size:    n,
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+		Sidecar: sidecar,
+	}, nil
+}
+
+// DemoArchive is an open demo archive. Demos are read in place, without
+// unpacking.
+type DemoArchive struct {
+	Index *DemoArchiveIndex
+
+	f     *os.File
+	demos map[string]int       // id → position in Index.Demos
+	files map[string]*zip.File // entry name → file
+}
+
+// OpenDemoArchive opens a demo archive and reads its index.
+func OpenDemoArchive(path string) (*DemoArchive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open demo archive: %w", err)
+	}
+	a, err := newDemoArchive(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return a, nil
+}
+
+func newDemoArchive(f *os.File) (*DemoArchive, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	a := &DemoArchive{f: f, files: make(map[string]*zip.File, len(zr.File))}
+	for _, zf := range zr.File {
+		a.files[zf.Name] = zf
+	}
+
+	zf, ok := a.files[demoArchiveIndexName]
+	if !ok {
+		return nil, fmt.Errorf("not a demo archive: no %s", demoArchiveIndexName)
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(&a.Index); err != nil {
+		return nil, fmt.Errorf("read demo archive index: %w", err)
+	}
+	if a.Index == nil {
+		return nil, fmt.Errorf("read demo archive index: empty")
+	}
+	a.demos = make(map[string]int, len(a.Index.Demos))
+	for i, demo := range a.Index.Demos {
+		a.demos[demo.ID] = i
+	}
+	return a, nil
+}
+
+// Demo returns the index entry for a demo.
+func (a *DemoArchive) Demo(id string) (*ArchivedDemo, bool) {
+	i, ok := a.demos[id]
+	if !ok {
+		return nil, false
+	}
+	return &a.Index.Demos[i], true
+}
+
+// Section returns a reader over a demo's bytes in the archive, for copying
+// or serving it as a file.
+func (a *DemoArchive) Section(id string) (*io.SectionReader, error) {
+	if _, ok := a.demos[id]; !ok {
+		return nil, fmt.Errorf("demo %q: %w", id, fs.ErrNotExist)
+	}
+	zf, ok := a.files[demoArchiveEntry(id)]
+	if !ok {
+		return nil, fmt.Errorf("demo %q: missing from archive", id)
+	}
+	if zf.Method != zip.Store {
+		return nil, fmt.Errorf("demo %q: compressed in archive", id)
+	}
+	offset, err := zf.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(a.f, offset, int64(zf.UncompressedSize64)), nil
+}
+
+// OpenDemo opens a demo in the archive for frame-by-frame reading. Closing
+// the DemoReader leaves the archive open.
+func (a *DemoArchive) OpenDemo(id string) (*DemoReader, error) {
+	section, err := a.Section(id)
+	if err != nil {
+		return nil, err
+	}
+	return newDemoReader(section, section.Size())
+}
+
+// ParseDemo parses a demo in the archive as ParseDemo does a file.
+func (a *DemoArchive) ParseDemo(id string) (*DemoInfo, error) {
+	section, err := a.Section(id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseDemoAt(section, section.Size())
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range info.Warnings {
+		log.Printf("Demo: %s: %s", id, w)
+	}
+	return info, nil
+}
+
+// Close closes the archive file.
+func (a *DemoArchive) Close() error {
+	return a.f.Close()
+}
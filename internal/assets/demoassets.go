@@ -0,0 +1,129 @@
+package assets
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Categories of DemoAssetRef
+const (
+	DemoAssetModel  = "model"
+	DemoAssetSound  = "sound"
+	DemoAssetVideo  = "video"
+	DemoAssetPlayer = "player"
+)
+
+// DemoAssetRef is an asset a demo's configstrings name, with the slot that
+// named it and when it first did. DemoInfo flattens these into lists.
+type DemoAssetRef struct {
+	Category string `json:"category"` // DemoAssetModel, DemoAssetSound, DemoAssetVideo, or DemoAssetPlayer
+	Name     string `json:"name"`     // the path, or the player model
+	HModel   string `json:"hmodel,omitempty"`
+	Bot      string `json:"bot,omitempty"`
+	Index    int    `json:"index"` // configstring index
+	// ServerTime is the server time of the first frame the reference was
+	// in; for Initial ones, that of the demo's first frame
+	ServerTime int  `json:"serverTime"`
+	Initial    bool `json:"initial"` // in the header configstrings, not added mid-match
+}
+
+// DemoAssets is every asset reference a demo makes, in the order they
+// appeared: the header's by configstring index, then mid-match additions.
+type DemoAssets struct {
+	MapName  string         `json:"map"`
+	FSGame   string         `json:"fsGame,omitempty"`
+	Refs     []DemoAssetRef `json:"refs"`
+	Warnings []string       `json:"warnings,omitempty"` // problems that didn't stop parsing
+}
+
+// ParseDemoAssets reads a demo frame by frame, recording each asset
+// reference with its configstring index and the server time it first
+// appeared. A slot that changes (a player switching models, a mod
+// precaching a sound mid-match) yields a reference for each value.
+func ParseDemoAssets(path string) (*DemoAssets, error) {
+	d, err := OpenDemo(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	info := buildDemoInfo(d.Configstrings, d.Protocol.Configstrings)
+	result := &DemoAssets{MapName: info.MapName, FSGame: info.FSGame, Refs: []DemoAssetRef{}}
+	seen := make(map[DemoAssetRef]bool) // with ServerTime and Initial zeroed
+	add := func(cs map[int]string, serverTime int, initial bool) {
+		indexes := make([]int, 0, len(cs))
+		for index := range cs {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		for _, index := range indexes {
+			for _, ref := range configstringAssetRefs(d.Protocol.Configstrings, index, cs[index]) {
+				if seen[ref] {
+					continue
+				}
+				seen[ref] = true
+				ref.ServerTime, ref.Initial = serverTime, initial
+				result.Refs = append(result.Refs, ref)
+			}
+		}
+	}
+
+	dec := NewSnapshotDecoder(d.Protocol)
+	header := true
+	for {
+		frame, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Warnings = append(result.Warnings, err.Error())
+			break
+		}
+		if header {
+			add(d.Configstrings, frame.ServerTime, true)
+			header = false
+		}
+		snap, err := dec.Decode(frame.Data)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("frame at %d: %v", frame.ServerTime, err))
+			break
+		}
+		add(snap.Configstrings, snap.ServerTime, false)
+	}
+	if header {
+		add(d.Configstrings, 0, true)
+	}
+	return result, nil
+}
+
+// configstringAssetRefs returns the asset references in one configstring of
+// the given layout, by the rules buildDemoInfo collects them with.
+func configstringAssetRefs(layout ConfigstringLayout, index int, value string) []DemoAssetRef {
+	if value == "" {
+		return nil
+	}
+	var refs []DemoAssetRef
+	switch {
+	case layout.IsModel(index):
+		if !strings.HasPrefix(value, "*") { // inline BSP models
+			refs = append(refs, DemoAssetRef{Category: DemoAssetModel, Name: value, Index: index})
+		}
+	case layout.IsSound(index):
+		refs = append(refs, DemoAssetRef{Category: DemoAssetSound, Name: value, Index: index})
+	case layout.IsPlayer(index):
+		kvs := parseBackslashKV(value)
+		if kvs["model"] != "" {
+			ref := DemoAssetRef{Category: DemoAssetPlayer, Name: kvs["model"], HModel: kvs["hmodel"], Index: index}
+			if _, ok := kvs["skill"]; ok {
+				ref.Bot = stripColorCodes(kvs["n"])
+			}
+			refs = append(refs, ref)
+		}
+	}
+	for _, v := range findVideoRefs(value) {
+		refs = append(refs, DemoAssetRef{Category: DemoAssetVideo, Name: v, Index: index})
+	}
+	return refs
+}
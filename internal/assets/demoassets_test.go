@@ -0,0 +1,72 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDemoAssets(t *testing.T) {
+	enc := NewSnapshotEncoder(ProtocolQ3)
+	frame := func(serverTime int, cs map[int]string) []byte {
+		return enc.Encode(&Snapshot{
+			ServerTime:    serverTime,
+			Entities:      map[int]*EntityState{},
+			Players:       map[int]*PlayerState{},
+			Configstrings: cs,
+		})
+	}
+	data := makeTVDWithConfigstrings(map[int]string{
+		csModels + 1: "*1", // inline model
+		csModels + 2: "models/powerups/armor/armor_red.md3",
+		csPlayers:    `\n\Visor\model\visor/gorre\hmodel\visor/gorre\skill\3`,
+	}, [][]byte{
+		frame(1000, map[int]string{}),
+		frame(1050, map[int]string{
+			csSounds + 1: "sound/misc/new.wav",
+			csPlayers:    `\n\Visor\model\visor/gorre\hmodel\visor/gorre\skill\3`, // unchanged
+		}),
+		frame(1100, map[int]string{csPlayers + 1: `\n\Player\model\sarge/blue\hmodel\sarge/blue`}),
+	})
+	path := filepath.Join(t.TempDir(), "a.tvd")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseDemoAssets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MapName != "q3dm17" || len(got.Warnings) != 0 {
+		t.Errorf("map %q, warnings %v", got.MapName, got.Warnings)
+	}
+	want := []DemoAssetRef{
+		{Category: DemoAssetModel, Name: "models/powerups/armor/armor_red.md3", Index: csModels + 2, ServerTime: 1000, Initial: true},
+		{Category: DemoAssetPlayer, Name: "visor/gorre", HModel: "visor/gorre", Bot: "Visor", Index: csPlayers, ServerTime: 1000, Initial: true},
+		{Category: DemoAssetSound, Name: "sound/misc/new.wav", Index: csSounds + 1, ServerTime: 1050},
+		{Category: DemoAssetPlayer, Name: "sarge/blue", HModel: "sarge/blue", Index: csPlayers + 1, ServerTime: 1100},
+	}
+	if !reflect.DeepEqual(got.Refs, want) {
+		t.Errorf("refs:\n got %+v\nwant %+v", got.Refs, want)
+	}
+}
+
+func TestBuildDemoInfoQuakeLiveLayout(t *testing.T) {
+	configstrings := map[int]string{
+		csServerInfo: `\mapname\campgrounds`,
+		17:           "models/weapons2/rocketl/rocketl.md3",
+		300:          "sound/weapons/rocket/rocklf1a.wav",
+		530:          `\n\anarki\model\anarki\hmodel\anarki`,
+	}
+	info := buildDemoInfo(configstrings, ProtocolQL91.Configstrings)
+	if len(info.Models) != 1 || len(info.Sounds) != 1 || len(info.PlayerInfos) != 1 || info.PlayerInfos[0].Model != "anarki" {
+		t.Errorf("models %v, sounds %v, players %+v", info.Models, info.Sounds, info.PlayerInfos)
+	}
+	if client, ok := ProtocolQL91.Configstrings.Client(530); !ok || client != 1 {
+		t.Errorf("Client(530) = %d, %v; want 1, true", client, ok)
+	}
+	if ProtocolQ3.Configstrings.IsPlayer(530) {
+		t.Errorf("530 is a sound configstring in Quake III")
+	}
+}
@@ -0,0 +1,115 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// demoDecoderPoolSize is how many idle zstd decoders are kept for reuse.
+const demoDecoderPoolSize = 32
+
+// demoDecoderPool holds idle frame stream decoders. Creating a decoder
+// allocates its history and block buffers, which dominates parsing small
+// demos, so frame streams return theirs here when closed.
+var demoDecoderPool = make(chan *pooledDecoder, demoDecoderPoolSize)
+
+// pooledDecoder is a zstd decoder and the dictionary generation it was
+// created with.
+type pooledDecoder struct {
+	*zstd.Decoder
+	gen int
+}
+
+// getDemoDecoder returns a decoder reading from r, reusing an idle one when
+// it was created with the current dictionaries.
+func getDemoDecoder(r io.Reader) (*pooledDecoder, error) {
+	opts, gen := demoDecoderOptions()
+	for {
+		select {
+		case d := <-demoDecoderPool:
+			if d.gen == gen && d.Reset(r) == nil {
+				return d, nil
+			}
+			d.Close()
+			continue
+		default:
+		}
+		break
+	}
+	decoder, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("zstd decoder init: %w", err)
+	}
+	return &pooledDecoder{Decoder: decoder, gen: gen}, nil
+}
+
+// putDemoDecoder returns a decoder to the pool, or closes it if the pool is
+// full.
+func putDemoDecoder(d *pooledDecoder) {
+	if d.Reset(nil) == nil {
+		select {
+		case demoDecoderPool <- d:
+			return
+		default:
+		}
+	}
+	d.Close()
+}
+
+// DemoResult is a demo parsed by ParseDemos.
+type DemoResult struct {
+	Path string
+	Info *DemoInfo
+	Err  error
+}
+
+// ParseDemos parses demos concurrently, sending each result on the returned
+// channel as it finishes, in no particular order; the channel is closed after
+// the last. At most concurrency demos (GOMAXPROCS when it's not positive) are
+// parsed at a time, and a parser doesn't take another demo until its last
+// result is received, so memory is bounded by concurrency rather than by how
+// many demos there are or how fast results are consumed: each parser holds one
+// pooled decoder (its window capped at maxDemoDecoderMemory) and one frame
+// (at most maxDemoFrameSize). A caller that stops receiving early cancels
+// ctx: the parsers then drop their results, take no more demos, and close
+// the channel once the demos being parsed finish.
+func ParseDemos(ctx context.Context, paths []string, concurrency int) <-chan DemoResult {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	results := make(chan DemoResult)
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range work {
+				info, err := ParseDemo(path)
+				select {
+				case results <- DemoResult{Path: path, Info: info, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(results)
+		defer wg.Wait()
+		defer close(work)
+		for _, path := range paths {
+			select {
+			case work <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results
+}
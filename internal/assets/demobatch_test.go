@@ -0,0 +1,72 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeBatchDemos(t *testing.T, n int) []string {
+	t.Helper()
+	frame := NewSnapshotEncoder(ProtocolQ3).Encode(&Snapshot{
+		ServerTime: 1000,
+		Entities:   map[int]*EntityState{},
+		Players:    map[int]*PlayerState{},
+	})
+	dir := t.TempDir()
+	var paths []string
+	for i := range n {
+		path := filepath.Join(dir, fmt.Sprintf("d%d.tvd", i))
+		if err := os.WriteFile(path, makeTVDWithConfigstrings(nil, [][]byte{frame}), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestParseDemos(t *testing.T) {
+	paths := writeBatchDemos(t, 20)
+	seen := make(map[string]bool)
+	for r := range ParseDemos(context.Background(), paths, 3) {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Path, r.Err)
+		} else if r.Info.MapName != "q3dm17" {
+			t.Errorf("%s: map %q", r.Path, r.Info.MapName)
+		}
+		seen[r.Path] = true
+	}
+	if len(seen) != len(paths) {
+		t.Errorf("got %d results, want %d", len(seen), len(paths))
+	}
+}
+
+func TestParseDemosCancel(t *testing.T) {
+	paths := writeBatchDemos(t, 50)
+	ctx, cancel := context.WithCancel(context.Background())
+	results := ParseDemos(ctx, paths, 4)
+	<-results
+	cancel()
+
+	// The channel closes without the rest being received
+	time.Sleep(50 * time.Millisecond)
+	deadline := time.After(5 * time.Second)
+	received := 1
+	for {
+		select {
+		case _, ok := <-results:
+			if !ok {
+				if received == len(paths) {
+					t.Error("every demo was parsed after cancelling")
+				}
+				return
+			}
+			received++
+		case <-deadline:
+			t.Fatal("results channel not closed after cancelling")
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package assets
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DemoBundleName is the bundle's description, at the root of the bundle.
+const DemoBundleName = "bundle.json"
+
+// DemoBundle describes a self-contained demo bundle: the demo, and the pk3s
+// to load before playing it, in load order. Paths are bundle-relative.
+type DemoBundle struct {
+	Demo string        `json:"demo"`
+	Map  string        `json:"map"`
+	Game string        `json:"game"`
+	Pk3s []RequiredPk3 `json:"pk3s"`
+}
+
+// ExportDemoBundle writes a demo and every pk3 it needs to dest, a directory
+// or, if dest ends in .zip, a zip file, with a bundle.json giving the load
+// order, for offline playback or sharing as one download. Baseline and map
+// pk3s are copied from outputDir, the demobake output; the demo's own pk3 is
+// built into the bundle. Every pk3 the demo needs must already be built.
+func ExportDemoBundle(demoPath, dest string, manifest *Manifest, outputDir string) (*DemoBundle, error) {
+	info, err := ParseDemo(demoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	demoName := filepath.Base(demoPath)
+	demoPk3 := "demos/" + strings.TrimSuffix(demoName, filepath.Ext(demoName)) + ".pk3"
+	game, pk3s, err := RequiredDemoPk3s(info, manifest, DemoPk3Options{OutputDir: outputDir, DemoPk3: demoPk3})
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the demo pk3 in a scratch directory; it isn't kept in outputDir
+	tmpDir, err := os.MkdirTemp("", "trinity-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sources := make(map[string]string, len(pk3s))
+	for i := range pk3s {
+		p := &pk3s[i]
+		switch {
+		case p.Role == Pk3RoleDemo:
+			src := filepath.Join(tmpDir, "demo.pk3")
+			mapPk3Path := filepath.Join(outputDir, "maps", strings.ToLower(info.MapName)+".pk3")
+			if _, err := os.Stat(mapPk3Path); err != nil {
+				mapPk3Path = ""
+			}
+			if err := BuildDemoPak(info, manifest, mapPk3Path, src); err != nil {
+				return nil, fmt.Errorf("build demo pk3: %w", err)
+			}
+			sum, size, err := hashFile(src)
+			if err != nil {
+				return nil, err
+			}
+			p.Size, p.SHA256, p.Missing = size, sum, false
+			sources[p.Path] = src
+		case p.Missing:
+			return nil, fmt.Errorf("%s not built", p.Path)
+		default:
+			sources[p.Path] = filepath.Join(outputDir, filepath.FromSlash(p.Path))
+		}
+		p.URL = ""
+	}
+
+	bundle := &DemoBundle{Demo: demoName, Map: info.MapName, Game: game, Pk3s: pk3s}
+	desc, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []bundleFile{{name: demoName, src: demoPath}}
+	for _, p := range pk3s {
+		files = append(files, bundleFile{name: p.Path, src: sources[p.Path]})
+	}
+	if strings.EqualFold(filepath.Ext(dest), ".zip") {
+		err = writeBundleZip(dest, files, desc)
+	} else {
+		err = writeBundleDir(dest, files, desc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write bundle: %w", err)
+	}
+	return bundle, nil
+}
+
+// bundleFile is a file copied into a bundle from src.
+type bundleFile struct {
+	name string // bundle-relative, slash-separated
+	src  string
+}
+
+// writeBundleDir copies files into dir, alongside the bundle description.
+func writeBundleDir(dir string, files []bundleFile, desc []byte) error {
+	for _, f := range files {
+		dst, err := safeJoin(dir, f.name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(f.src, dst); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, DemoBundleName), desc, 0644)
+}
+
+// writeBundleZip writes files and the bundle description into a zip at
+// path. Entries are stored uncompressed: pk3s and demos are compressed
+// already.
+func writeBundleZip(zipPath string, files []bundleFile, desc []byte) error {
+	if err := os.MkdirAll(filepath.Dir(zipPath), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	add := func(name string, r io.Reader) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: path.Clean(name), Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	err = add(DemoBundleName, strings.NewReader(string(desc)))
+	for _, f := range files {
+		if err != nil {
+			break
+		}
+		var src *os.File
+		if src, err = os.Open(f.src); err != nil {
+			break
+		}
+		err = add(f.name, src)
+		src.Close()
+	}
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(zipPath)
+	}
+	return err
+}
+
+// copyFile copies src to dst, replacing dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
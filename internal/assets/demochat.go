@@ -0,0 +1,214 @@
+package assets
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	csVoteString = 9
+
+	// serverCommandBroadcast is the target of commands sent to every client.
+	serverCommandBroadcast = 255
+	maxServerCommands      = 1024
+)
+
+// ServerCommand is a reliable server command (chat, print, scores, tinfo, ...)
+// recorded in a demo frame.
+type ServerCommand struct {
+	Target int // client number, or 255 for all clients
+	Text   string
+}
+
+// Args splits the command into arguments the way Cmd_TokenizeString does.
+func (c ServerCommand) Args() []string {
+	return tokenizeServerCommand(c.Text)
+}
+
+// readServerCommands reads a frame's server-command section, which follows the
+// configstring updates:
+//   - 2 bytes: command count (short)
+//   - per command: target client (byte), text length (short), text (bytes)
+//
+// Frames written before the section existed end after the configstrings and
+// read as a count of zero.
+func readServerCommands(msg *MsgReader) []ServerCommand {
+	count := msg.ReadShort()
+	if msg.Overflowed() || count > maxServerCommands {
+		return nil // a frame from before the section existed, or a bad count
+	}
+	var cmds []ServerCommand
+	for i := 0; i < count; i++ {
+		target := msg.ReadBits(8)
+		length := msg.ReadShort()
+		if length <= 0 || length >= maxConfigstringSize {
+			continue
+		}
+		text := msg.ReadData(length)
+		if msg.Overflowed() {
+			break // truncated frame
+		}
+		cmds = append(cmds, ServerCommand{Target: target, Text: string(text)})
+	}
+	return cmds
+}
+
+// tokenizeServerCommand splits on whitespace, keeping quoted strings whole.
+func tokenizeServerCommand(text string) []string {
+	var args []string
+	for i := 0; i < len(text); {
+		for i < len(text) && text[i] <= ' ' {
+			i++
+		}
+		if i >= len(text) {
+			break
+		}
+		if text[i] == '"' {
+			end := strings.IndexByte(text[i+1:], '"')
+			if end < 0 {
+				args = append(args, text[i+1:])
+				break
+			}
+			args = append(args, text[i+1:i+1+end])
+			i += end + 2
+			continue
+		}
+		start := i
+		for i < len(text) && text[i] > ' ' {
+			i++
+		}
+		args = append(args, text[start:i])
+	}
+	return args
+}
+
+// ChatMessage is a chat or team chat line.
+type ChatMessage struct {
+	ServerTime int
+	Team       bool
+	Target     int    // client the command was sent to, or 255 for all
+	Name       string // sender, with color codes
+	Message    string
+}
+
+// CallVote is a vote called during the demo.
+type CallVote struct {
+	ServerTime int
+	Vote       string // vote string, e.g. "map q3dm17"
+}
+
+// TeamInfo is one tinfo update: teammate status sent to a client.
+type TeamInfo struct {
+	ServerTime int
+	Target     int
+	Players    []TeamInfoPlayer
+}
+
+// TeamInfoPlayer is one teammate's entry in a tinfo update.
+type TeamInfoPlayer struct {
+	Client   int
+	Location int
+	Health   int
+	Armor    int
+	Weapon   int
+	Powerups int
+}
+
+// DemoChatLog holds the chat, callvotes, and team info extracted from a demo.
+type DemoChatLog struct {
+	Chat     []ChatMessage
+	Votes    []CallVote
+	TeamInfo []TeamInfo
+}
+
+// ChatLog extracts chat lines, callvotes, and tinfo updates from a demo.
+func ChatLog(path string) (*DemoChatLog, error) {
+	d, err := OpenDemo(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	chatLog := &DemoChatLog{}
+	dec := NewSnapshotDecoder(d.Protocol)
+	for {
+		frame, err := d.Next()
+		if err == io.EOF {
+			return chatLog, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		snap, err := dec.Decode(frame.Data)
+		if err != nil {
+			return nil, err
+		}
+		chatLog.add(snap)
+	}
+}
+
+// add records the chat-relevant parts of a snapshot. A chat line sent
+// separately to each client is recorded once.
+func (l *DemoChatLog) add(snap *Snapshot) {
+	if vote, ok := snap.Configstrings[csVoteString]; ok && vote != "" {
+		l.Votes = append(l.Votes, CallVote{ServerTime: snap.ServerTime, Vote: vote})
+	}
+
+	seen := make(map[string]bool)
+	for _, cmd := range snap.Commands {
+		args := cmd.Args()
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "chat", "tchat":
+			if len(args) < 2 || seen[cmd.Text] {
+				continue
+			}
+			seen[cmd.Text] = true
+			name, message := splitChatLine(args[1])
+			l.Chat = append(l.Chat, ChatMessage{
+				ServerTime: snap.ServerTime,
+				Team:       args[0] == "tchat",
+				Target:     cmd.Target,
+				Name:       name,
+				Message:    message,
+			})
+		case "tinfo":
+			l.TeamInfo = append(l.TeamInfo, parseTeamInfo(snap.ServerTime, cmd.Target, args[1:]))
+		}
+	}
+}
+
+// splitChatLine splits "name^7\x19: message" (the game's chat format) into
+// sender and message. Team chat wraps the name in parentheses and may add a
+// location, which stays part of the name.
+func splitChatLine(line string) (string, string) {
+	i := strings.Index(line, "\x19: ")
+	if i < 0 {
+		return "", line
+	}
+	return strings.ReplaceAll(line[:i], "\x19", ""), line[i+3:]
+}
+
+// parseTeamInfo parses tinfo arguments: a count, then six numbers per teammate.
+func parseTeamInfo(serverTime, target int, args []string) TeamInfo {
+	info := TeamInfo{ServerTime: serverTime, Target: target}
+	if len(args) == 0 {
+		return info
+	}
+	n, _ := strconv.Atoi(args[0])
+	nums := make([]int, 0, len(args)-1)
+	for _, a := range args[1:] {
+		v, _ := strconv.Atoi(a)
+		nums = append(nums, v)
+	}
+	for i := 0; i < n && (i+1)*6 <= len(nums); i++ {
+		e := nums[i*6 : (i+1)*6]
+		info.Players = append(info.Players, TeamInfoPlayer{
+			Client: e[0], Location: e[1], Health: e[2], Armor: e[3], Weapon: e[4], Powerups: e[5],
+		})
+	}
+	return info
+}
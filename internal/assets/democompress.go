@@ -0,0 +1,262 @@
+package assets
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DefaultDemoDictSize is the size TrainDemoDictionary aims for.
+	DefaultDemoDictSize = 112 << 10
+
+	// demoDictSampleLimit caps the frame data a dictionary is trained on,
+	// shared evenly between the sample demos; training time grows quickly
+	// with it, and more rarely makes the dictionary better.
+	demoDictSampleLimit = 4 << 20
+)
+
+var (
+	demoDictsMu  sync.RWMutex
+	demoDicts    = make(map[uint32][]byte) // dictionary ID → dictionary
+	demoDictsGen int                       // bumped on registration, to retire pooled decoders
+)
+
+// RegisterDemoDictionary makes a zstd dictionary available to every demo
+// reader in the process, so demos recompressed with it can be read. The game
+// itself can't play such demos; they're for archive storage. Each zstd frame
+// header names the ID of the dictionary it needs, so any number can be
+// registered side by side.
+func RegisterDemoDictionary(d []byte) error {
+	info, err := zstd.InspectDictionary(d)
+	if err != nil {
+		return fmt.Errorf("invalid zstd dictionary: %w", err)
+	}
+	demoDictsMu.Lock()
+	demoDicts[info.ID()] = d
+	demoDictsGen++
+	demoDictsMu.Unlock()
+	return nil
+}
+
+// demoDecoderOptions are the zstd options frame streams are decoded with,
+// and the dictionary generation they include. Frame streams are decoded
+// synchronously: frames are small, and a parser per demo is the parallelism.
+func demoDecoderOptions() ([]zstd.DOption, int) {
+	opts := []zstd.DOption{
+		zstd.WithDecoderMaxMemory(maxDemoDecoderMemory),
+		zstd.WithDecoderConcurrency(1),
+	}
+	demoDictsMu.RLock()
+	defer demoDictsMu.RUnlock()
+	if len(demoDicts) > 0 {
+		dicts := make([][]byte, 0, len(demoDicts))
+		for _, d := range demoDicts {
+			dicts = append(dicts, d)
+		}
+		opts = append(opts, zstd.WithDecoderDicts(dicts...))
+	}
+	return opts, demoDictsGen
+}
+
+// RecompressResult summarizes what RecompressDemo did.
+type RecompressResult struct {
+	Frames int
+	Before int64 // file size
+	After  int64
+}
+
+// RecompressDemo re-encodes a demo's frame stream at a zstd level (1-22, as
+// in the zstd tool) and, if dictionary isn't nil, with a dictionary from
+// TrainDemoDictionary, writing the result to outPath, which may be path
+// itself. The header is copied unchanged. If the demo has a trailer, a new
+// zstd frame is started at each of its index entries, so seeking resumes
+// decompression there, and the trailer is rewritten to point at them. The
+// demo keeps its modification time, so watchers don't take it for a new
+// recording.
+//
+// The game can't play a demo compressed with a dictionary, so one can't
+// replace its original: outPath must then be another file. The dictionary is
+// registered for reading, since the output needs it.
+func RecompressDemo(path, outPath string, level int, dictionary []byte) (*RecompressResult, error) {
+	opts := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}
+	if dictionary != nil {
+		if sameFile(path, outPath) {
+			return nil, fmt.Errorf("%s: a demo compressed with a dictionary can't replace the original, which the game plays", filepath.Base(path))
+		}
+		if err := RegisterDemoDictionary(dictionary); err != nil {
+			return nil, err
+		}
+		opts = append(opts, zstd.WithEncoderDict(dictionary))
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open demo: %w", err)
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &RecompressResult{Before: stat.Size()}
+
+	streamEnd := stat.Size()
+	trailer, trailerStart, err := readDemoTrailer(in, stat.Size())
+	if err == nil {
+		streamEnd = trailerStart
+	} else if !errors.Is(err, ErrNoDemoTrailer) {
+		return nil, err
+	}
+
+	section := io.NewSectionReader(in, 0, streamEnd)
+	r := bufio.NewReader(section)
+	if _, _, err := readDemoHeader(r); err != nil {
+		return nil, err
+	}
+	pos, _ := section.Seek(0, io.SeekCurrent)
+	streamStart := pos - int64(r.Buffered())
+
+	tmp, err := os.CreateTemp(filepath.Dir(outPath), ".recompress-*.tvd")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if _, err := io.Copy(w, io.NewSectionReader(in, 0, streamStart)); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+
+	cw := &countingWriter{w: w}
+	enc, err := zstd.NewWriter(cw, opts...)
+	if err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("zstd encoder init: %w", err)
+	}
+	var index []DemoIndexEntry
+	if trailer != nil {
+		index = trailer.Index
+		for i := range index {
+			index[i].ZstdFrame, index[i].ZstdFrameBase = 0, 0
+		}
+	}
+	var size [4]byte
+	result.Frames, err = forEachDemoFrame(r, func(offset int64, frame []byte) error {
+		for ; len(index) > 0 && index[0].Offset <= offset; index = index[1:] {
+			if index[0].Offset != offset {
+				continue // not a frame's start; seeks there decompress from the beginning
+			}
+			if offset > 0 {
+				if err := enc.Close(); err != nil {
+					return err
+				}
+				enc.Reset(cw)
+			}
+			index[0].ZstdFrame, index[0].ZstdFrameBase = cw.n, offset
+		}
+		binary.LittleEndian.PutUint32(size[:], uint32(len(frame)))
+		if _, err := enc.Write(size[:]); err != nil {
+			return err
+		}
+		_, err := enc.Write(frame)
+		return err
+	})
+	if err == nil {
+		err = enc.Close()
+	} else {
+		enc.Close()
+	}
+	if err == nil && trailer != nil {
+		var payload []byte
+		if payload, err = encodeDemoTrailer(trailer); err == nil {
+			_, err = w.Write(payload)
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recompress %s: %w", filepath.Base(path), err)
+	}
+
+	if info, err := os.Stat(tmp.Name()); err == nil {
+		result.After = info.Size()
+	}
+	if err := os.Chmod(tmp.Name(), stat.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	if err := os.Chtimes(tmp.Name(), stat.ModTime(), stat.ModTime()); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), outPath); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// sameFile reports whether a and b name the same file, or the same path if
+// b doesn't exist yet.
+func sameFile(a, b string) bool {
+	ai, errA := os.Stat(a)
+	bi, errB := os.Stat(b)
+	if errA == nil && errB == nil {
+		return os.SameFile(ai, bi)
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// TrainDemoDictionary builds a zstd dictionary of about size bytes from the
+// frames of sample demos. Demos from the same mod and maps share most of
+// their configstrings and entity layouts, which a dictionary captures.
+func TrainDemoDictionary(paths []string, size int) ([]byte, error) {
+	if size <= 0 {
+		size = DefaultDemoDictSize
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no demos to train on")
+	}
+	perDemo := demoDictSampleLimit / len(paths)
+	var samples [][]byte
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open demo: %w", err)
+		}
+		r := bufio.NewReader(f)
+		if _, _, err := readDemoHeader(r); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		taken := 0
+		_, err = forEachDemoFrame(r, func(_ int64, frame []byte) error {
+			samples = append(samples, append([]byte(nil), frame...))
+			if taken += len(frame); taken >= perDemo {
+				return io.EOF
+			}
+			return nil
+		})
+		f.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no demo frames to train on")
+	}
+	return dict.BuildZstdDict(samples, dict.Options{MaxDictSize: size, HashBytes: 6})
+}
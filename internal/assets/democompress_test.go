@@ -0,0 +1,112 @@
+package assets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// demoStream returns a demo's frame stream, and how many frames it holds.
+func demoStream(t *testing.T, path string) ([]byte, int) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	if _, _, err := readDemoHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := forEachDemoFrame(bytes.NewReader(stream), func(int64, []byte) error { return nil })
+	if err != nil {
+		t.Fatalf("%s: %v", filepath.Base(path), err)
+	}
+	return stream, frames
+}
+
+func TestRecompressDemo(t *testing.T) {
+	enc := NewSnapshotEncoder(ProtocolQ3)
+	var frames [][]byte
+	for i := range 300 {
+		frames = append(frames, enc.Encode(&Snapshot{
+			ServerTime:    1000 + 50*i,
+			Entities:      map[int]*EntityState{},
+			Players:       map[int]*PlayerState{},
+			Configstrings: map[int]string{csSounds + 1 + i%16: fmt.Sprintf("sound/misc/s%d.wav", i%16)},
+		}))
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.tvd")
+	if err := os.WriteFile(path, makeTVDWithConfigstrings(nil, frames), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recorded := time.Unix(1700000000, 0)
+	if err := os.Chtimes(path, recorded, recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a dictionary, a demo can be recompressed in place and keeps
+	// its modification time
+	result, err := RecompressDemo(path, path, 19, nil)
+	if err != nil {
+		t.Fatalf("RecompressDemo in place: %v", err)
+	}
+	if result.Frames != len(frames) {
+		t.Errorf("recompressed %d frames, want %d", result.Frames, len(frames))
+	}
+	if _, n := demoStream(t, path); n != len(frames) {
+		t.Errorf("recompressed demo has %d frames, want %d", n, len(frames))
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if !info.ModTime().Equal(recorded) {
+		t.Errorf("modification time changed to %v", info.ModTime())
+	}
+
+	dictionary, err := TrainDemoDictionary([]string{path}, 4<<10)
+	if err != nil {
+		t.Fatalf("TrainDemoDictionary: %v", err)
+	}
+	if _, err := RecompressDemo(path, path, 3, dictionary); err == nil {
+		t.Error("RecompressDemo with a dictionary replaced the original")
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "a.tvd")
+	if _, err := RecompressDemo(path, out, 3, dictionary); err != nil {
+		t.Fatalf("RecompressDemo with a dictionary: %v", err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("the original changed")
+	}
+
+	// The output names its dictionary, which is registered for reading it
+	stream, n := demoStream(t, out)
+	if n != len(frames) {
+		t.Errorf("dictionary demo has %d frames, want %d", n, len(frames))
+	}
+	var header zstd.Header
+	if err := header.Decode(stream); err != nil {
+		t.Fatal(err)
+	}
+	info, err := zstd.InspectDictionary(dictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.DictionaryID != info.ID() {
+		t.Errorf("frame needs dictionary %d, want %d", header.DictionaryID, info.ID())
+	}
+}
@@ -0,0 +1,114 @@
+package assets
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Provenance records what asked for a pk3 built outside a full build, and
+// where its map came from.
+type Provenance struct {
+	Trigger string    `json:"trigger"`          // what asked for the build: "demo", "fetch", "intake", "mappak", or "service"
+	Demo    string    `json:"demo,omitempty"`   // the demo's file name, for a demo trigger
+	Source  string    `json:"source,omitempty"` // where the map came from, if not the install
+	Time    time.Time `json:"time"`
+}
+
+// MissingMap is a map demos need that the install doesn't have.
+type MissingMap struct {
+	Game      string    `json:"game"`
+	Map       string    `json:"map"`
+	Demos     []string  `json:"demos"` // demo file names, first seen first
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+// EnsureDemoMapPak builds the map pk3 a demo needs if it isn't already built,
+// recording the demo as its provenance. A map the install doesn't have is
+// recorded in the manifest's MissingMaps instead, for a map repository to
+// supply. It reports whether the manifest changed and needs saving.
+func EnsureDemoMapPak(manifest *Manifest, fsGame, mapName, demo, quake3Dir, outputDir string, opts MapPakOptions) (bool, Diagnostics, error) {
+	mapName = strings.ToLower(mapName)
+	rel := "maps/" + mapName + ".pk3"
+	if _, ok := manifest.Artifacts[rel]; ok {
+		return false, nil, nil
+	}
+	game, gm, ok := manifest.GameFor(fsGame)
+	if !ok {
+		return false, nil, fmt.Errorf("no game manifest for fs_game %q", fsGame)
+	}
+
+	if _, ok := gm.FileIndex["maps/"+mapName+".bsp"]; !ok {
+		key := game + "/" + mapName
+		missing, ok := manifest.MissingMaps[key]
+		if !ok {
+			if manifest.MissingMaps == nil {
+				manifest.MissingMaps = make(map[string]*MissingMap)
+			}
+			missing = &MissingMap{Game: game, Map: mapName, FirstSeen: time.Now().UTC()}
+			manifest.MissingMaps[key] = missing
+			log.Printf("Warning: %s needs map %s, which %s doesn't have", demo, mapName, game)
+		}
+		if containsString(missing.Demos, demo) {
+			return false, nil, nil
+		}
+		missing.Demos = append(missing.Demos, demo)
+		return true, nil, nil
+	}
+
+	mapPk3Path := filepath.Join(outputDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(mapPk3Path), 0755); err != nil {
+		return false, nil, err
+	}
+	log.Printf("Building map pk3: %s (%s), for %s", mapName, game, demo)
+	diags, err := BuildMapPak(mapName, game, manifest, quake3Dir, mapPk3Path, opts)
+	if err != nil {
+		return false, diags, err
+	}
+	recorded, err := manifest.RecordMapPak(game, mapName, outputDir, &Provenance{Trigger: "demo", Demo: demo})
+	return recorded, diags, err
+}
+
+// RecordMapPak records the map pk3 at maps/<map>.pk3 under outputDir, built
+// outside a full build, as an artifact of game, so that sync verifies it and
+// gc keeps it. prov, if set, is stamped with the time and recorded with it.
+// It reports whether there was a pk3 to record: BuildMapPak writes none for a
+// map with nothing beyond the baseline.
+func (m *Manifest) RecordMapPak(game, mapName, outputDir string, prov *Provenance) (bool, error) {
+	return m.recordPk3(game, "maps/"+strings.ToLower(mapName)+".pk3", outputDir, prov)
+}
+
+// RecordDemoPak is RecordMapPak for a demo pk3 at rel under outputDir, as
+// BuildDemoPak writes it for a demo resolved against game. Like a map pk3,
+// it's restricted if it holds official id content.
+func (m *Manifest) RecordDemoPak(game, rel, outputDir string, prov *Provenance) (bool, error) {
+	return m.recordPk3(game, rel, outputDir, prov)
+}
+
+func (m *Manifest) recordPk3(game, rel, outputDir string, prov *Provenance) (bool, error) {
+	gm, ok := m.Games[game]
+	if !ok {
+		return false, fmt.Errorf("no game manifest for %q", game)
+	}
+	pk3Path := filepath.Join(outputDir, filepath.FromSlash(rel))
+	if _, err := os.Stat(pk3Path); os.IsNotExist(err) {
+		return false, nil
+	}
+	contents, err := MapPakFileSet(pk3Path)
+	if err != nil {
+		return false, fmt.Errorf("read %s: %w", rel, err)
+	}
+	if err := m.addArtifact(rel, pk3Path, gm.containsOfficial(contents)); err != nil {
+		return false, err
+	}
+	if prov != nil {
+		prov.Time = time.Now().UTC()
+		a := m.Artifacts[rel]
+		a.Provenance = prov
+		m.Artifacts[rel] = a
+	}
+	return true, nil
+}
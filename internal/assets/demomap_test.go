@@ -0,0 +1,92 @@
+package assets
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureDemoMapPak(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Built maps are left alone
+	changed, _, err := EnsureDemoMapPak(manifest, "", "q3dm0", "a.tvd", q, out, MapPakOptions{})
+	if err != nil || changed {
+		t.Fatalf("built map: changed = %v, err = %v", changed, err)
+	}
+
+	// A map the install has is built, with the demo as provenance
+	delete(manifest.Artifacts, "maps/custom.pk3")
+	changed, _, err = EnsureDemoMapPak(manifest, "", "Custom", "b.tvd", q, out, MapPakOptions{})
+	if err != nil || !changed {
+		t.Fatalf("unbuilt map: changed = %v, err = %v", changed, err)
+	}
+	a, ok := manifest.Artifacts["maps/custom.pk3"]
+	if !ok || a.Provenance == nil || a.Provenance.Trigger != "demo" || a.Provenance.Demo != "b.tvd" {
+		t.Fatalf("artifact = %+v, want demo provenance", a)
+	}
+
+	// A map the install doesn't have is recorded once per demo
+	for _, demo := range []string{"c.tvd", "d.tvd", "c.tvd"} {
+		if _, _, err := EnsureDemoMapPak(manifest, "", "gone", demo, q, out, MapPakOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := manifest.MissingMaps["baseq3/gone"]
+	if missing == nil || len(missing.Demos) != 2 || missing.Demos[0] != "c.tvd" || missing.Demos[1] != "d.tvd" {
+		t.Fatalf("missing map = %+v, want demos c.tvd, d.tvd", missing)
+	}
+	if changed, _, _ := EnsureDemoMapPak(manifest, "", "gone", "d.tvd", q, out, MapPakOptions{}); changed {
+		t.Errorf("recording a known demo again changed the manifest")
+	}
+
+	// Building the map clears it
+	if err := manifest.addArtifact("maps/gone.pk3", filepath.Join(out, "maps", "custom.pk3"), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.MissingMaps) != 0 {
+		t.Errorf("missing maps = %v after building the map", manifest.MissingMaps)
+	}
+}
+
+func TestRecordDemoPak(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Games["baseq3"].OfficialFiles["textures/base_wall/metal.jpg"] {
+		t.Fatal("fixture's metal.jpg isn't official")
+	}
+	writeFixturePk3(t, filepath.Join(out, "demos", "id.pk3"), map[string][]byte{
+		"textures/base_wall/metal.jpg": fixtureImage("metal"),
+	})
+	writeFixturePk3(t, filepath.Join(out, "demos", "custom.pk3"), map[string][]byte{
+		"textures/custom/floor.tga": fixtureImage("floor"),
+	})
+
+	for rel, restricted := range map[string]bool{"demos/id.pk3": true, "demos/custom.pk3": false} {
+		recorded, err := manifest.RecordDemoPak("baseq3", rel, out, &Provenance{Trigger: "intake", Demo: "a.tvd"})
+		if err != nil || !recorded {
+			t.Fatalf("%s: recorded = %v, err = %v", rel, recorded, err)
+		}
+		a, ok := manifest.Artifacts[rel]
+		if !ok || a.Restricted != restricted || a.Provenance == nil || a.Provenance.Trigger != "intake" {
+			t.Errorf("%s: artifact = %+v, want restricted %v with intake provenance", rel, a, restricted)
+		}
+	}
+	if recorded, err := manifest.RecordDemoPak("baseq3", "demos/none.pk3", out, nil); err != nil || recorded {
+		t.Errorf("unbuilt demo pk3: recorded = %v, err = %v", recorded, err)
+	}
+}
@@ -0,0 +1,207 @@
+package assets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultIntakeTemplate names demos received by intake: by server, then
+// receipt time, map, and match id.
+const DefaultIntakeTemplate = "{server}/{date}_{time}_{map}_{match}.tvd"
+
+// DefaultRenameTemplate is the name template RenameDemo uses by default.
+const DefaultRenameTemplate = "{date}_{map}_{gametype}_{winner}-vs-{loser}.tvd"
+
+var (
+	demoNameField  = regexp.MustCompile(`\{([a-z_]+)\}`)
+	demoNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._\-]+`)
+)
+
+// ExpandDemoName fills a demo name template's {field} placeholders from
+// fields. Values are made safe for file names (runs of anything but letters,
+// digits, '.', '_', and '-' become '_', and color codes are dropped); the
+// template's own slashes make directories. An unknown field, or a result
+// that is empty or escapes the directory it's relative to, is an error.
+func ExpandDemoName(template string, fields map[string]string) (string, error) {
+	var missing []string
+	name := demoNameField.ReplaceAllStringFunc(template, func(m string) string {
+		key := m[1 : len(m)-1]
+		value, ok := fields[key]
+		if !ok {
+			missing = append(missing, key)
+			return ""
+		}
+		value = demoNameUnsafe.ReplaceAllString(stripColorCodes(value), "_")
+		return strings.Trim(value, "._")
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unknown demo name field: %s", strings.Join(missing, ", "))
+	}
+	name = path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("invalid demo name %q", name)
+	}
+	return name, nil
+}
+
+// gameTypeNames are the short gametype names used in demo names, matching the
+// stats database's where it has one.
+var gameTypeNames = []string{"ffa", "1v1", "sp", "tdm", "ctf", "1fctf", "overload", "harvester"}
+
+// DemoNameFields returns the fields a demo name template can use, from a
+// demo's sidecar and the time it was recorded:
+//
+//	{date} {time}      recording date (2006-01-02) and time (150405), UTC
+//	{map} {game}       map and game directory (baseq3 when no fs_game)
+//	{gametype}         ffa, 1v1, tdm, ctf, and so on
+//	{winner} {loser}   red/blue in team games, else the top two players
+//	{duration}         length, as 12m34s
+//	{players}          number of players seen
+//	{server} {match}   intake metadata, when the demo has it
+func DemoNameFields(sidecar *DemoSidecar, recorded time.Time) map[string]string {
+	recorded = recorded.UTC()
+	game := sidecar.Game
+	if game == "" {
+		game = "baseq3"
+	}
+	gametype := strconv.Itoa(sidecar.GameType)
+	if sidecar.GameType >= 0 && sidecar.GameType < len(gameTypeNames) {
+		gametype = gameTypeNames[sidecar.GameType]
+	}
+	fields := map[string]string{
+		"date":     recorded.Format("2006-01-02"),
+		"time":     recorded.Format("150405"),
+		"map":      sidecar.Map,
+		"game":     game,
+		"gametype": gametype,
+		"duration": formatDemoDuration(sidecar.DurationMs),
+		"players":  strconv.Itoa(len(sidecar.Players)),
+		"server":   sidecar.Server,
+		"match":    sidecar.MatchID,
+		"winner":   "",
+		"loser":    "",
+	}
+	if sidecar.GameType >= gtTeam {
+		fields["winner"], fields["loser"] = "red", "blue"
+		if sidecar.BlueScore > sidecar.RedScore {
+			fields["winner"], fields["loser"] = "blue", "red"
+		}
+	} else if len(sidecar.Players) > 0 {
+		// Players are sorted by score
+		fields["winner"] = sidecar.Players[0].Name
+		if len(sidecar.Players) > 1 {
+			fields["loser"] = sidecar.Players[1].Name
+		}
+	}
+	return fields
+}
+
+func formatDemoDuration(ms int) string {
+	s := ms / 1000
+	return fmt.Sprintf("%dm%02ds", s/60, s%60)
+}
+
+// RenameDemo renames a demo, and its sidecar if it has one, to the name the
+// template gives it (see DemoNameFields), relative to the demo's directory.
+// The recording time is the file's modification time. If the name is taken,
+// _2, _3, and so on are added before the extension. Returns the new path.
+func RenameDemo(path, template string) (string, error) {
+	target, err := DemoRenameTarget(path, template)
+	if err != nil {
+		return "", err
+	}
+	if target == path {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("rename demo: %w", err)
+	}
+	if _, err := os.Stat(SidecarPath(path)); err == nil {
+		if err := os.Rename(SidecarPath(path), SidecarPath(target)); err != nil {
+			return target, fmt.Errorf("rename sidecar: %w", err)
+		}
+	}
+	return target, nil
+}
+
+// DemoRenameTarget returns the path RenameDemo would move a demo to, without
+// moving it.
+func DemoRenameTarget(path, template string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("open demo: %w", err)
+	}
+	sidecar, err := BuildDemoSidecar(path, nil)
+	if err != nil {
+		return "", err
+	}
+	// Keep intake metadata, which only the existing sidecar has
+	if data, err := os.ReadFile(SidecarPath(path)); err == nil {
+		var existing DemoSidecar
+		if json.Unmarshal(data, &existing) == nil {
+			sidecar.Server, sidecar.MatchID = existing.Server, existing.MatchID
+		}
+	}
+	name, err := ExpandDemoName(template, DemoNameFields(sidecar, stat.ModTime()))
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(filepath.Dir(path), filepath.FromSlash(name))
+	ext := filepath.Ext(target)
+	stem := strings.TrimSuffix(target, ext)
+	for n := 2; ; n++ {
+		if target == path {
+			return target, nil
+		}
+		if _, err := os.Stat(target); errors.Is(err, fs.ErrNotExist) {
+			return target, nil
+		}
+		target = fmt.Sprintf("%s_%d%s", stem, n, ext)
+	}
+}
+
+// DemoRename is the outcome of renaming one demo in RenameDemos.
+type DemoRename struct {
+	From string
+	To   string // empty on error
+	Err  error
+}
+
+// RenameDemos renames every .tvd demo directly in dir with RenameDemo. With
+// dryRun set, it reports the new names without renaming anything; names
+// within the batch may then collide where a real run would number them.
+func RenameDemos(dir, template string, dryRun bool) ([]DemoRename, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var results []DemoRename
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".tvd") {
+			continue
+		}
+		r := DemoRename{From: filepath.Join(dir, e.Name())}
+		if dryRun {
+			r.To, r.Err = DemoRenameTarget(r.From, template)
+		} else {
+			r.To, r.Err = RenameDemo(r.From, template)
+		}
+		if r.Err != nil {
+			r.To = ""
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -0,0 +1,239 @@
+package assets
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ResolveDemoAssets resolves every file a demo needs beyond its map: models and
+// sounds from configstrings, the player models in use, and the cgame and ui
+// QVMs it plays back with. Resolution runs
+// against the game manifest selected by the demo's fs_game, so mod overrides
+// (hud graphics, sounds, menus) win over baseq3. Returns the game used and the
+// set of needed lowered paths, including baseline files.
+func ResolveDemoAssets(info *DemoInfo, manifest *Manifest) (string, map[string]bool, error) {
+	game, gm, ok := manifest.GameFor(info.FSGame)
+	if !ok {
+		return "", nil, fmt.Errorf("no game manifest for fs_game %q", info.FSGame)
+	}
+	if info.FSGame != "" && !strings.EqualFold(game, info.FSGame) {
+		log.Printf("Demo: fs_game %q not in manifest, resolving against %s", info.FSGame, game)
+	}
+
+	needed := newDepSet()
+
+	for _, p := range demoVMs {
+		if _, ok := gm.FileIndex[p]; ok {
+			needed.add("", "vm", p)
+		}
+	}
+
+	for _, modelPath := range info.Models {
+		if strings.HasSuffix(strings.ToLower(modelPath), ".md3") {
+			resolveModel(modelPath, "", gm, needed)
+		}
+	}
+
+	for _, soundPath := range info.Sounds {
+		lower := strings.ToLower(soundPath)
+		if _, ok := gm.FileIndex[lower]; ok {
+			needed.add("", "sound", lower)
+		}
+	}
+
+	for _, video := range info.Videos {
+		lower := cinematicPath(video)
+		if _, ok := gm.FileIndex[lower]; ok {
+			needed.add("", "cinematic", lower)
+		}
+	}
+
+	fallback := manifest.DefaultPlayerModel(game, info.GameType)
+	var bots map[string]*BotInfo
+	for _, pi := range info.PlayerInfos {
+		resolvePlayerModel(pi.Model, pi.HModel, fallback, "", gm, needed)
+		if pi.Bot != "" {
+			if bots == nil {
+				bots = gm.Bots()
+			}
+			if bot, ok := bots[strings.ToLower(pi.Bot)]; ok {
+				resolveBot(bot, "", fallback, gm, needed)
+			}
+		}
+	}
+
+	return game, needed.files, nil
+}
+
+// resolvePlayerModel adds a player model's md3s, skins, skin textures,
+// animation config, icon, and sounds to needed, following cgame's fallbacks:
+// a model that doesn't load is replaced by fallback, and each of the model's
+// custom sounds it lacks comes from fallback's model. model and hmodel
+// use the "name/skin" form from player configstrings. from is what references
+// the model, if anything.
+func resolvePlayerModel(model, hmodel string, fallback PlayerFallback, from string, gm *GameManifest, needed *depSet) {
+	name, skin := splitModelSkin(model)
+	headName, headSkin := name, skin
+	if hmodel != "" {
+		headName, headSkin = splitModelSkin(hmodel)
+	}
+	loaded := playerModelLoads(name, skin, headName, headSkin, gm)
+	if !loaded && fallback.Model != "" {
+		if !fallback.KeepSkin {
+			skin = "default"
+		}
+		name, headName, headSkin = fallback.Model, fallback.Head, skin
+	}
+
+	for _, part := range []struct{ model, skin, name string }{
+		{headName, headSkin, "head"},
+		{name, skin, "upper"},
+		{name, skin, "lower"},
+	} {
+		base := playerModelDir(part.model)
+		resolveModel(base+part.name+".md3", from, gm, needed)
+		resolveSkin(base+part.name+"_"+part.skin+".skin", from, gm, needed)
+	}
+
+	base := playerModelDir(name)
+	footsteps := "normal"
+	if data, err := readFileFromIndex(base+"animation.cfg", gm.FileIndex); err == nil {
+		needed.add(from, "animation", base+"animation.cfg")
+		footsteps = ParseAnimationConfig(bytes.NewReader(data)).Footsteps
+	}
+	if resolved, ok := gm.ResolveTexture(base + "icon_" + skin); ok {
+		needed.add(from, "icon", resolved)
+	}
+
+	for _, sound := range playerSounds {
+		p, ok := resolveSound("sound/player/"+name+"/"+sound+".wav", gm.FileIndex)
+		if !ok && fallback.Model != "" {
+			p, ok = resolveSound("sound/player/"+fallback.Model+"/"+sound+".wav", gm.FileIndex)
+		}
+		if ok {
+			needed.add(from, "sound", p)
+		}
+	}
+	for i := 1; i <= 4; i++ {
+		if p, ok := resolveSound(fmt.Sprintf("sound/player/footsteps/%s%d.wav", footstepSounds[footsteps], i), gm.FileIndex); ok {
+			needed.add(from, "sound", p)
+		}
+	}
+}
+
+// playerModelLoads reports whether cgame can load a player model as given:
+// all three md3s and their skins.
+func playerModelLoads(name, skin, headName, headSkin string, gm *GameManifest) bool {
+	for _, f := range []string{
+		playerModelDir(name) + "lower.md3", playerModelDir(name) + "lower_" + skin + ".skin",
+		playerModelDir(name) + "upper.md3", playerModelDir(name) + "upper_" + skin + ".skin",
+		playerModelDir(headName) + "head.md3", playerModelDir(headName) + "head_" + headSkin + ".skin",
+	} {
+		if _, ok := gm.FileIndex[f]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// playerModelDir returns a player model's directory. Team Arena head models
+// named "*name" live under models/players/heads/.
+func playerModelDir(name string) string {
+	if head, ok := strings.CutPrefix(name, "*"); ok {
+		return "models/players/heads/" + head + "/"
+	}
+	return "models/players/" + name + "/"
+}
+
+// resolveSkin adds a .skin file referenced by from and the textures it
+// references to needed.
+func resolveSkin(skinPath, from string, gm *GameManifest, needed *depSet) {
+	lower := strings.ToLower(skinPath)
+	data, err := readFileFromIndex(lower, gm.FileIndex)
+	if err != nil {
+		return
+	}
+	needed.add(from, "skin", lower)
+
+	textures, err := ParseSkin(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	for _, tex := range textures {
+		resolveShaderTextures(tex, lower, gm, needed)
+	}
+}
+
+// splitModelSkin splits "sarge/blue" into ("sarge", "blue"), defaulting the skin.
+func splitModelSkin(model string) (string, string) {
+	name, skin, ok := strings.Cut(strings.ToLower(model), "/")
+	if !ok || skin == "" {
+		skin = "default"
+	}
+	return name, skin
+}
+
+// BuildDemoPak builds a pk3 with the demo's non-baseline assets. Files already
+// provided by the map pk3 at mapPk3Path (if non-empty) are left out.
+func BuildDemoPak(info *DemoInfo, manifest *Manifest, mapPk3Path, outputPath string) error {
+	game, paths, err := demoPakFiles(info, manifest, mapPk3Path)
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+
+	count, err := WritePk3FromIndex(outputPath, paths, manifest.Games[game].FileIndex)
+	if err != nil {
+		return fmt.Errorf("write demo pk3: %w", err)
+	}
+
+	log.Printf("  demo (%s): %d files", game, count)
+	return nil
+}
+
+// PlanDemoPak is BuildDemoPak's dry run: it returns the pk3 BuildDemoPak
+// would write to outputPath without writing anything, or nil if the demo
+// needs nothing outside the baseline and map pk3.
+func PlanDemoPak(info *DemoInfo, manifest *Manifest, mapPk3Path, outputPath string) (*PlannedPk3, error) {
+	game, paths, err := demoPakFiles(info, manifest, mapPk3Path)
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	pk3, err := planPk3(outputPath, paths, manifest.Games[game].FileIndex)
+	if err != nil {
+		return nil, fmt.Errorf("plan demo pk3: %w", err)
+	}
+	return pk3, nil
+}
+
+// demoPakFiles resolves the game a demo resolves against and the files its
+// pk3 holds: the demo's assets less the baseline and the map pk3's files.
+func demoPakFiles(info *DemoInfo, manifest *Manifest, mapPk3Path string) (string, []string, error) {
+	game, needed, err := ResolveDemoAssets(info, manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	gm := manifest.Games[game]
+
+	for path := range needed {
+		if gm.BaselineFiles[path] {
+			delete(needed, path)
+		}
+	}
+
+	if mapPk3Path != "" {
+		mapFiles, err := MapPakFileSet(mapPk3Path)
+		if err != nil {
+			return "", nil, fmt.Errorf("read map pk3: %w", err)
+		}
+		for path := range mapFiles {
+			delete(needed, path)
+		}
+	}
+
+	if len(needed) == 0 {
+		log.Printf("  demo: no non-baseline files needed")
+	}
+	return game, mapKeys(needed), nil
+}
@@ -0,0 +1,202 @@
+package assets
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// DemoFrame is one frame of a demo's frame stream.
+type DemoFrame struct {
+	ServerTime int
+	Offset     int64  // offset of the frame's size prefix in the decompressed frame stream
+	Data       []byte // Huffman-encoded frame; only valid until the next call to Next
+}
+
+// DemoReader reads a TVD frame by frame and can seek by server time.
+type DemoReader struct {
+	Protocol      *Protocol      // from the header's protocol version
+	Configstrings map[int]string // header configstrings
+
+	r           io.ReaderAt
+	closer      io.Closer
+	streamStart int64 // file offset of the zstd frame stream
+	streamEnd   int64 // file offset of the trailer, or the file size
+	stream      *frameStream
+	index       []DemoIndexEntry
+	pending     []*DemoFrame // frames read ahead by Seek, returned first by Next
+}
+
+// OpenDemo opens a demo for frame-by-frame reading.
+func OpenDemo(path string) (*DemoReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open demo: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d, err := newDemoReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d.closer = f
+	return d, nil
+}
+
+// newDemoReader reads a demo of size bytes from r. Close doesn't close r
+// unless the caller sets closer.
+func newDemoReader(r io.ReaderAt, size int64) (*DemoReader, error) {
+	section := io.NewSectionReader(r, 0, size)
+	br := bufio.NewReader(section)
+	version, configstrings, err := readDemoHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	protocol, err := LookupProtocol(version)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := section.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DemoReader{
+		Protocol:      protocol,
+		Configstrings: configstrings,
+		r:             r,
+		streamStart:   pos - int64(br.Buffered()),
+		streamEnd:     size,
+	}
+	if trailer, start, err := readDemoTrailer(r, size); err == nil {
+		d.index = trailer.Index
+		d.streamEnd = start
+	}
+
+	d.stream, err = newFrameStream(d.section(0))
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// section returns a fresh reader over the compressed frame stream, from an
+// offset into it.
+func (d *DemoReader) section(from int64) io.Reader {
+	return bufio.NewReader(io.NewSectionReader(d.r, d.streamStart+from, d.streamEnd-d.streamStart-from))
+}
+
+// Next returns the next frame, or io.EOF after the last one.
+func (d *DemoReader) Next() (*DemoFrame, error) {
+	if len(d.pending) > 0 {
+		frame := d.pending[0]
+		d.pending = d.pending[1:]
+		return frame, nil
+	}
+	offset, data, err := d.stream.next()
+	if err != nil {
+		return nil, err
+	}
+	return &DemoFrame{
+		ServerTime: int(int32(NewMsgReader(data).ReadLong())),
+		Offset:     offset,
+		Data:       data,
+	}, nil
+}
+
+// Seek positions the reader so the next frame returned by Next is the last
+// frame at or before serverTime (or the first frame, if serverTime precedes
+// it). With a frame index, decompression resumes at the zstd frame holding
+// the nearest indexed frame, and the frames between are skipped without
+// being split out or Huffman-decoded.
+func (d *DemoReader) Seek(serverTime int) error {
+	var start DemoIndexEntry
+	if i := sort.Search(len(d.index), func(i int) bool { return d.index[i].ServerTime > serverTime }); i > 0 {
+		start = d.index[i-1]
+	}
+	if err := d.seekEntry(start); err != nil {
+		return err
+	}
+
+	// Scan forward from the indexed frame to the last one at or before serverTime
+	var last *DemoFrame
+	for {
+		frame, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if frame.ServerTime > serverTime {
+			d.pending = append(d.pending, copyFrame(frame))
+			break
+		}
+		last = copyFrame(frame)
+	}
+	if last != nil {
+		d.pending = append([]*DemoFrame{last}, d.pending...)
+	}
+	return nil
+}
+
+// seekEntry positions the reader at an indexed frame. The forward-only zstd
+// stream is restarted at the entry's zstd frame if the frame is behind it,
+// or if that's a later zstd frame than the one being read.
+func (d *DemoReader) seekEntry(e DemoIndexEntry) error {
+	if len(d.pending) > 0 && d.pending[0].Offset == e.Offset {
+		return nil
+	}
+	d.pending = nil
+	if e.ZstdFrameBase > e.Offset || e.ZstdFrame < 0 || e.ZstdFrame > d.streamEnd-d.streamStart {
+		return fmt.Errorf("invalid frame index entry at server time %d", e.ServerTime)
+	}
+	if e.Offset < d.stream.offset || e.ZstdFrameBase > d.stream.offset {
+		if err := d.stream.reset(d.section(e.ZstdFrame), e.ZstdFrameBase); err != nil {
+			return fmt.Errorf("rewind demo: %w", err)
+		}
+	}
+	if err := d.stream.skip(e.Offset - d.stream.offset); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("frame offset %d past end of demo", e.Offset)
+		}
+		return err
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+func (d *DemoReader) Close() error {
+	d.stream.close()
+	if d.closer == nil {
+		return nil
+	}
+	return d.closer.Close()
+}
+
+func copyFrame(f *DemoFrame) *DemoFrame {
+	c := *f
+	c.Data = append([]byte(nil), f.Data...)
+	return &c
+}
+
+// DemoIndex returns a demo's server time → frame offset index, from its
+// trailer when present and otherwise by scanning its frames.
+func DemoIndex(path string) ([]DemoIndexEntry, error) {
+	trailer, err := ReadDemoTrailer(path)
+	if errors.Is(err, ErrNoDemoTrailer) {
+		trailer, err = BuildDemoTrailer(path, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return trailer.Index, nil
+}
@@ -0,0 +1,148 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeSeekDemo writes a demo of 400 frames 50ms apart from server time
+// 1000, compressed as a single zstd frame, with a trailer indexing it.
+func writeSeekDemo(t *testing.T) string {
+	t.Helper()
+	enc := NewSnapshotEncoder(ProtocolQ3)
+	var frames [][]byte
+	for i := range 400 {
+		frames = append(frames, enc.Encode(&Snapshot{
+			ServerTime: 1000 + 50*i,
+			Entities:   map[int]*EntityState{},
+			Players:    map[int]*PlayerState{},
+		}))
+	}
+	path := filepath.Join(t.TempDir(), "seek.tvd")
+	if err := os.WriteFile(path, makeTVDWithConfigstrings(nil, frames), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RepairDemoTrailer(path, ""); err != nil {
+		t.Fatalf("RepairDemoTrailer: %v", err)
+	}
+	return path
+}
+
+// checkSeeks seeks a demo back and forth and checks the frame Next returns.
+func checkSeeks(t *testing.T, path string, seeks map[int]int) {
+	t.Helper()
+	d, err := OpenDemo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for _, serverTime := range []int{12345, 500, 19950, 6000, 5999, 18000, 1000} {
+		want, ok := seeks[serverTime]
+		if !ok {
+			continue
+		}
+		if err := d.Seek(serverTime); err != nil {
+			t.Fatalf("Seek(%d): %v", serverTime, err)
+		}
+		frame, err := d.Next()
+		if err != nil {
+			t.Fatalf("Next after Seek(%d): %v", serverTime, err)
+		}
+		if frame.ServerTime != want {
+			t.Errorf("Seek(%d) then Next = server time %d, want %d", serverTime, frame.ServerTime, want)
+		}
+	}
+}
+
+var demoSeeks = map[int]int{12345: 12300, 500: 1000, 19950: 19950, 6000: 6000, 5999: 5950, 18000: 18000, 1000: 1000}
+
+func TestDemoReaderSeek(t *testing.T) {
+	path := writeSeekDemo(t)
+	trailer, err := ReadDemoTrailer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trailer.Index) != 4 {
+		t.Fatalf("index has %d entries, want 4", len(trailer.Index))
+	}
+	for _, e := range trailer.Index {
+		if e.ZstdFrame != 0 || e.ZstdFrameBase != 0 {
+			t.Errorf("single zstd frame demo indexed %+v", e)
+		}
+	}
+	checkSeeks(t, path, demoSeeks)
+}
+
+func TestDemoReaderSeekZstdFrames(t *testing.T) {
+	path := writeSeekDemo(t)
+	if _, err := RecompressDemo(path, path, 3, nil); err != nil {
+		t.Fatalf("RecompressDemo: %v", err)
+	}
+	trailer, err := ReadDemoTrailer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recompressing starts a zstd frame at each index entry, which the
+	// trailer points at and a rebuilt trailer finds again
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamStart, err := demoStreamStart(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, streamEnd, _ := readDemoTrailer(f, info.Size())
+	bounds, err := zstdFrameBounds(f, streamStart, streamEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bounds) != len(trailer.Index)+1 || bounds[len(bounds)-1] != streamEnd {
+		t.Fatalf("zstd frame bounds %v for %d index entries, stream ending at %d", bounds, len(trailer.Index), streamEnd)
+	}
+	for i, e := range trailer.Index {
+		if e.ZstdFrame != bounds[i]-streamStart || e.ZstdFrameBase != e.Offset {
+			t.Errorf("index entry %d = %+v, want zstd frame at %d", i, e, bounds[i]-streamStart)
+		}
+	}
+	rebuilt, err := BuildDemoTrailer(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rebuilt.Index, trailer.Index) {
+		t.Errorf("rebuilt index = %+v, want %+v", rebuilt.Index, trailer.Index)
+	}
+	checkSeeks(t, path, demoSeeks)
+
+	// Seeking past the first zstd frame never decompresses it
+	garbage := make([]byte, bounds[1]-bounds[0]-8)
+	for i := range garbage {
+		garbage[i] = 0xA5
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[bounds[0]+8:], garbage)
+	bad := filepath.Join(t.TempDir(), "bad.tvd")
+	if err := os.WriteFile(bad, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkSeeks(t, bad, map[int]int{12345: 12300, 18000: 18000, 6000: 6000})
+	d, err := OpenDemo(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.Next(); err == nil {
+		t.Error("the corrupted first zstd frame decoded")
+	}
+}
@@ -0,0 +1,380 @@
+package assets
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	csMotd = 4
+
+	// redactMinNameLen is the shortest name (without color codes) replaced in
+	// free text; shorter names would match inside ordinary words.
+	redactMinNameLen = 3
+	redactedHostname = "Redacted"
+)
+
+// ipAddressPattern matches IPv4 addresses, with an optional port.
+var ipAddressPattern = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d{1,5})?\b`)
+
+// RedactResult summarizes what RedactDemo changed.
+type RedactResult struct {
+	Frames        int
+	Players       int // clients whose names were replaced
+	Configstrings int // configstrings rewritten, header and frames
+	Commands      int // server commands rewritten
+}
+
+// RedactDemo writes a copy of a demo that is safe to share publicly:
+//   - player names become "Player<client>", in player configstrings and in
+//     chat, prints, and votes that mention them
+//   - sv_hostname is replaced, and password, ip, and guid keys are dropped
+//     from serverinfo, systeminfo, and player configstrings
+//   - IPv4 addresses are masked in every rewritten string
+//
+// Entity and playerstate data is copied bit-for-bit. If the input has a
+// trailer, the output gets a freshly built one without the recorder name.
+func RedactDemo(inPath, outPath string) (*RedactResult, error) {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return nil, fmt.Errorf("open demo: %w", err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	_, _, trailerErr := readDemoTrailer(in, info.Size())
+	hasTrailer := !errors.Is(trailerErr, ErrNoDemoTrailer)
+
+	r := bufio.NewReader(in)
+	prefix, err := readDemoHeaderPrefix(r)
+	if err != nil {
+		return nil, err
+	}
+	protocol, err := LookupProtocol(int(int32(binary.LittleEndian.Uint32(prefix[4:8]))))
+	if err != nil {
+		return nil, err
+	}
+	configstrings := readHeaderConfigstrings(r)
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", outPath, err)
+	}
+	defer out.Close()
+
+	result := &RedactResult{}
+	rd := newDemoRedactor(result, protocol)
+	if err := writeRedactedDemo(out, r, prefix, configstrings, rd); err != nil {
+		out.Close()
+		os.Remove(outPath)
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	if hasTrailer {
+		if _, err := RepairDemoTrailer(outPath, ""); err != nil {
+			return nil, fmt.Errorf("write trailer: %w", err)
+		}
+	}
+	result.Players = len(rd.clients)
+	return result, nil
+}
+
+// writeRedactedDemo writes the redacted header and frame stream to out.
+func writeRedactedDemo(out io.Writer, r *bufio.Reader, prefix []byte, configstrings map[int]string, rd *demoRedactor) error {
+	w := bufio.NewWriter(out)
+	w.Write(prefix)
+
+	// Player configstrings first, so their names are known when the
+	// others are rewritten
+	indices := make([]int, 0, len(configstrings))
+	for index := range configstrings {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		pi, pj := rd.protocol.Configstrings.IsPlayer(indices[i]), rd.protocol.Configstrings.IsPlayer(indices[j])
+		if pi != pj {
+			return pi
+		}
+		return indices[i] < indices[j]
+	})
+	redacted := make(map[int]string, len(indices))
+	for _, index := range indices {
+		redacted[index] = rd.configstring(index, configstrings[index])
+	}
+	sort.Ints(indices)
+	for _, index := range indices {
+		value := redacted[index]
+		binary.Write(w, binary.LittleEndian, uint16(index))
+		binary.Write(w, binary.LittleEndian, uint16(len(value)))
+		w.WriteString(value)
+	}
+	binary.Write(w, binary.LittleEndian, uint16(0xFFFF))
+
+	enc, err := zstd.NewWriter(w)
+	if err != nil {
+		return fmt.Errorf("zstd encoder init: %w", err)
+	}
+	var size [4]byte
+	frames, err := forEachDemoFrame(r, func(_ int64, frame []byte) error {
+		data, err := rd.frame(frame)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+		if _, err := enc.Write(size[:]); err != nil {
+			return err
+		}
+		_, err = enc.Write(data)
+		return err
+	})
+	if err != nil {
+		enc.Close()
+		return fmt.Errorf("redact frame %d: %w", frames, err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("zstd compress: %w", err)
+	}
+	rd.result.Frames = frames
+	return w.Flush()
+}
+
+// readDemoHeaderPrefix reads the fixed header fields, mapname, and timestamp,
+// returning them as raw bytes.
+func readDemoHeaderPrefix(r *bufio.Reader) ([]byte, error) {
+	prefix := make([]byte, 16) // magic(4) + protocol(4) + sv_fps(4) + maxclients(4)
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix[0:4]) != "TVD1" {
+		return nil, ErrNotTVD
+	}
+	for i := 0; i < 2; i++ {
+		s, err := r.ReadBytes(0)
+		if err != nil {
+			return nil, fmt.Errorf("read demo header: %w", err)
+		}
+		if len(s) > maxConfigstringSize {
+			return nil, fmt.Errorf("read demo header: string longer than %d bytes", maxConfigstringSize)
+		}
+		prefix = append(prefix, s...)
+	}
+	return prefix, nil
+}
+
+// demoRedactor rewrites configstrings and server commands, tracking player
+// names as they appear so later mentions can be replaced.
+type demoRedactor struct {
+	protocol *Protocol
+	result   *RedactResult
+	clients  map[int]bool
+	aliases  map[string]string // player name, with and without color codes → alias
+	replacer *strings.Replacer
+}
+
+func newDemoRedactor(result *RedactResult, protocol *Protocol) *demoRedactor {
+	return &demoRedactor{
+		protocol: protocol,
+		result:   result,
+		clients:  make(map[int]bool),
+		aliases:  make(map[string]string),
+	}
+}
+
+// frame rewrites one Huffman-encoded frame. Everything up to the configstring
+// updates is copied as raw bits; configstrings and commands are re-encoded.
+func (rd *demoRedactor) frame(data []byte) ([]byte, error) {
+	msg := NewMsgReader(data)
+	msg.Protocol = rd.protocol
+	serverTime := int(int32(msg.ReadLong()))
+	msg.ReadData(maxGentities / 8)
+	for {
+		entityNum := msg.ReadBits(gentitynumBits)
+		if entityNum == maxGentities-1 {
+			break // end marker
+		}
+		if msg.Overflowed() {
+			return nil, fmt.Errorf("truncated frame at server time %d", serverTime)
+		}
+		readEntityDelta(msg, nil)
+	}
+	playerBitmask := msg.ReadData(maxClients / 8)
+	for i := 0; i < maxClients; i++ {
+		if playerBitmask[i>>3]&(1<<uint(i&7)) != 0 {
+			msg.ReadByte() // clientNum
+			readPlayerDelta(msg, nil)
+		}
+	}
+
+	if msg.Overflowed() {
+		return nil, fmt.Errorf("truncated frame at server time %d", serverTime)
+	}
+	w := NewMsgWriter()
+	w.CopyBits(data, msg.bitPos)
+
+	csCount := msg.ReadShort()
+	if csCount > csMax {
+		return nil, fmt.Errorf("bad configstring count %d at server time %d", csCount, serverTime)
+	}
+	w.WriteShort(csCount)
+	for i := 0; i < csCount; i++ {
+		csIndex := msg.ReadShort()
+		csLen := msg.ReadShort()
+		w.WriteShort(csIndex)
+		if csLen <= 0 || csLen >= maxConfigstringSize {
+			w.WriteShort(csLen) // no data follows
+			continue
+		}
+		value := string(msg.ReadData(csLen))
+		if csIndex < csMax {
+			value = rd.configstring(csIndex, value)
+		}
+		if len(value) >= maxConfigstringSize {
+			value = value[:maxConfigstringSize-1]
+		}
+		w.WriteShort(len(value))
+		w.WriteData([]byte(value))
+	}
+
+	if msg.Overflowed() {
+		return nil, fmt.Errorf("truncated frame at server time %d", serverTime)
+	}
+
+	cmds := readServerCommands(msg)
+	w.WriteShort(len(cmds))
+	for _, cmd := range cmds {
+		text := rd.text(cmd.Text)
+		if text != cmd.Text {
+			rd.result.Commands++
+		}
+		if len(text) >= maxConfigstringSize {
+			text = text[:maxConfigstringSize-1]
+		}
+		w.WriteByte(byte(cmd.Target))
+		w.WriteShort(len(text))
+		w.WriteData([]byte(text))
+	}
+	return w.Bytes(), nil
+}
+
+// configstring returns the redacted value of a configstring.
+func (rd *demoRedactor) configstring(index int, value string) string {
+	redacted := value
+	client, isPlayer := rd.protocol.Configstrings.Client(index)
+	switch {
+	case index == csServerInfo || index == csSystemInfo:
+		redacted = rewriteInfoString(value, func(key, v string) (string, bool) {
+			if strings.EqualFold(key, "sv_hostname") {
+				return redactedHostname, true
+			}
+			return scrubIPAddresses(v), !isSensitiveInfoKey(key)
+		})
+	case isPlayer:
+		redacted = rewriteInfoString(value, func(key, v string) (string, bool) {
+			if key == "n" {
+				return rd.addName(client, v), true
+			}
+			return scrubIPAddresses(v), !isSensitiveInfoKey(key)
+		})
+	case index == csMotd || index == csVoteString:
+		redacted = rd.text(value)
+	}
+	if redacted != value {
+		rd.result.Configstrings++
+	}
+	return redacted
+}
+
+// addName records a client's name and returns its alias.
+func (rd *demoRedactor) addName(client int, name string) string {
+	alias := fmt.Sprintf("Player%d", client)
+	rd.clients[client] = true
+
+	changed := false
+	for _, n := range []string{name, stripColorCodes(name)} {
+		if len(stripColorCodes(n)) >= redactMinNameLen && rd.aliases[n] != alias {
+			rd.aliases[n] = alias
+			changed = true
+		}
+	}
+	if changed {
+		names := make([]string, 0, len(rd.aliases))
+		for n := range rd.aliases {
+			names = append(names, n)
+		}
+		// Longest first, so a colored name wins over its plain form
+		sort.Slice(names, func(i, j int) bool {
+			if len(names[i]) != len(names[j]) {
+				return len(names[i]) > len(names[j])
+			}
+			return names[i] < names[j]
+		})
+		pairs := make([]string, 0, 2*len(names))
+		for _, n := range names {
+			pairs = append(pairs, n, rd.aliases[n])
+		}
+		rd.replacer = strings.NewReplacer(pairs...)
+	}
+	return alias
+}
+
+// text replaces known player names and masks IP addresses in free text such
+// as chat lines, prints, and the vote string.
+func (rd *demoRedactor) text(s string) string {
+	if rd.replacer != nil {
+		s = rd.replacer.Replace(s)
+	}
+	return scrubIPAddresses(s)
+}
+
+// isSensitiveInfoKey reports whether an info key is dropped when redacting.
+func isSensitiveInfoKey(key string) bool {
+	lower := strings.ToLower(key)
+	return strings.Contains(lower, "password") || lower == "ip" || strings.HasSuffix(lower, "guid")
+}
+
+func scrubIPAddresses(s string) string {
+	return ipAddressPattern.ReplaceAllString(s, "x.x.x.x")
+}
+
+// rewriteInfoString applies fn to each key/value pair of a backslash info
+// string, keeping pair order. fn returns the new value and whether to keep
+// the pair.
+func rewriteInfoString(s string, fn func(key, value string) (string, bool)) string {
+	parts := strings.Split(strings.TrimPrefix(s, "\\"), "\\")
+	var b strings.Builder
+	for i := 0; i+1 < len(parts); i += 2 {
+		value, keep := fn(parts[i], parts[i+1])
+		if keep {
+			b.WriteString("\\" + parts[i] + "\\" + value)
+		}
+	}
+	return b.String()
+}
+
+// stripColorCodes removes Q3 color escapes (^ followed by any character but ^).
+func stripColorCodes(s string) string {
+	if !strings.Contains(s, "^") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '^' && i+1 < len(s) && s[i+1] != '^' {
+			i++
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
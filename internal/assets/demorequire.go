@@ -0,0 +1,99 @@
+package assets
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Roles of the pk3s a demo needs
+const (
+	Pk3RoleBaseline = "baseline" // a game layer's baseline pk3
+	Pk3RoleMap      = "map"
+	Pk3RoleDemo     = "demo" // the demo's own pk3: player models and anything else outside baseline and map
+)
+
+// RequiredPk3 is a generated pk3 a client fetches before playing a demo.
+type RequiredPk3 struct {
+	Path    string `json:"path"` // output-relative
+	Role    string `json:"role"`
+	URL     string `json:"url,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Missing bool   `json:"missing,omitempty"` // needed, but not built yet
+}
+
+// DemoPk3Options locates the generated pk3s for RequiredDemoPk3s.
+type DemoPk3Options struct {
+	OutputDir string // demobake output, where map and demo pk3s are looked for
+	BaseURL   string // prefix for each pk3's URL; URLs are left empty if unset
+
+	// DemoPk3 is the output-relative path the demo's own pk3 is (or would
+	// be) built at, such as demos/{id}.pk3. Leave it empty to skip the demo pk3.
+	DemoPk3 string
+}
+
+// RequiredDemoPk3s returns the pk3s a client loads to play a demo, in load
+// order: the baseline pk3 of each game layer, bottom first, then the map pk3,
+// then the demo's own pk3 if the demo needs anything the others don't have.
+// Sizes and hashes come from the manifest's artifacts, or from disk for the
+// demo pk3. A needed pk3 that hasn't been built is returned with Missing set.
+func RequiredDemoPk3s(info *DemoInfo, manifest *Manifest, opts DemoPk3Options) (string, []RequiredPk3, error) {
+	game, _, ok := manifest.GameFor(info.FSGame)
+	if !ok {
+		return "", nil, fmt.Errorf("no game manifest for fs_game %q", info.FSGame)
+	}
+
+	var pk3s []RequiredPk3
+	add := func(rel, role string) {
+		p := RequiredPk3{Path: rel, Role: role}
+		if a, ok := manifest.Artifacts[rel]; ok {
+			p.Size, p.SHA256 = a.Size, a.SHA256
+		} else {
+			p.Missing = true
+		}
+		if opts.BaseURL != "" {
+			if u, err := url.JoinPath(opts.BaseURL, rel); err == nil {
+				p.URL = u
+			}
+		}
+		pk3s = append(pk3s, p)
+	}
+
+	layers := manifest.Layers(game)
+	for i := len(layers) - 1; i >= 0; i-- {
+		add(layers[i]+".pk3", Pk3RoleBaseline)
+	}
+
+	// The map pk3 supplies the map's files only once it's built
+	mapName := strings.ToLower(info.MapName)
+	mapRel := "maps/" + mapName + ".pk3"
+	add(mapRel, Pk3RoleMap)
+	mapPk3Path := ""
+	if _, built := manifest.Artifacts[mapRel]; built && opts.OutputDir != "" {
+		mapPk3Path = filepath.Join(opts.OutputDir, filepath.FromSlash(mapRel))
+	}
+
+	if opts.DemoPk3 != "" {
+		_, paths, err := demoPakFiles(info, manifest, mapPk3Path)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(paths) > 0 {
+			add(opts.DemoPk3, Pk3RoleDemo)
+			demo := &pk3s[len(pk3s)-1]
+			if opts.OutputDir != "" {
+				sum, size, err := hashFile(filepath.Join(opts.OutputDir, filepath.FromSlash(opts.DemoPk3)))
+				if err == nil {
+					demo.Size, demo.SHA256, demo.Missing = size, sum, false
+				} else if !errors.Is(err, fs.ErrNotExist) {
+					return "", nil, fmt.Errorf("hash demo pk3: %w", err)
+				}
+			}
+		}
+	}
+	return game, pk3s, nil
+}
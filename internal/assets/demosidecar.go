@@ -0,0 +1,188 @@
+package assets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	gtTeam = 3 // first team gametype (GT_TEAM)
+
+	scoreFieldsPerClient = 14 // client, score, ping, time, flags, powerups, accuracy, medals...
+)
+
+// DemoSidecar is the summary written next to a demo so web listings don't
+// need to parse demos at request time.
+type DemoSidecar struct {
+	Map        string          `json:"map"`
+	Game       string          `json:"game,omitempty"` // fs_game
+	GameType   int             `json:"gameType"`
+	DurationMs int             `json:"durationMs"`
+	Players    []SidecarPlayer `json:"players"`
+	RedScore   int             `json:"redScore,omitempty"` // team gametypes only
+	BlueScore  int             `json:"blueScore,omitempty"`
+	Levelshot  string          `json:"levelshot,omitempty"` // levelshot path in the manifest's file index
+	Server     string          `json:"server,omitempty"`    // recording server, for demos received by intake
+	MatchID    string          `json:"matchId,omitempty"`
+}
+
+// SidecarPlayer is a player seen in a demo, with their last reported score.
+type SidecarPlayer struct {
+	Client int    `json:"client"`
+	Name   string `json:"name"`
+	Team   int    `json:"team"`
+	Score  int    `json:"score"`
+}
+
+// SidecarPath returns the sidecar path for a demo: the demo path with its
+// extension replaced by .json.
+func SidecarPath(demoPath string) string {
+	return strings.TrimSuffix(demoPath, filepath.Ext(demoPath)) + ".json"
+}
+
+// GenerateDemoSidecar summarizes a demo and writes the sidecar next to it.
+// The levelshot is looked up in manifest, which may be nil.
+func GenerateDemoSidecar(path string, manifest *Manifest) (*DemoSidecar, error) {
+	sidecar, err := BuildDemoSidecar(path, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteDemoSidecar(path, sidecar); err != nil {
+		return nil, err
+	}
+	return sidecar, nil
+}
+
+// WriteDemoSidecar writes a sidecar next to the demo at path.
+func WriteDemoSidecar(path string, sidecar *DemoSidecar) error {
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal sidecar: %w", err)
+	}
+	if err := os.WriteFile(SidecarPath(path), data, 0644); err != nil {
+		return fmt.Errorf("write sidecar: %w", err)
+	}
+	return nil
+}
+
+// BuildDemoSidecar reads a demo and computes its sidecar without writing it.
+// Players who left before the end are included with their last known name.
+func BuildDemoSidecar(path string, manifest *Manifest) (*DemoSidecar, error) {
+	d, err := OpenDemo(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	configstrings := d.Configstrings
+	players := make(map[int]*SidecarPlayer)
+	updatePlayers := func(cs map[int]string) {
+		for index, v := range cs {
+			client, ok := d.Protocol.Configstrings.Client(index)
+			if !ok || v == "" {
+				continue
+			}
+			kvs := parseBackslashKV(v)
+			p, ok := players[client]
+			if !ok {
+				p = &SidecarPlayer{Client: client}
+				players[client] = p
+			}
+			p.Name = kvs["n"]
+			p.Team, _ = strconv.Atoi(kvs["t"])
+		}
+	}
+	updatePlayers(configstrings)
+
+	var lastScores []string
+	firstTime, lastTime := 0, 0
+	dec := NewSnapshotDecoder(d.Protocol)
+	for frames := 0; ; frames++ {
+		frame, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		snap, err := dec.Decode(frame.Data)
+		if err != nil {
+			return nil, err
+		}
+		if frames == 0 {
+			firstTime = snap.ServerTime
+		}
+		lastTime = snap.ServerTime
+
+		for k, v := range snap.Configstrings {
+			configstrings[k] = v
+		}
+		updatePlayers(snap.Configstrings)
+		for _, cmd := range snap.Commands {
+			if args := cmd.Args(); len(args) > 0 && args[0] == "scores" {
+				lastScores = args[1:]
+			}
+		}
+	}
+
+	info := buildDemoInfo(configstrings, d.Protocol.Configstrings)
+	sidecar := &DemoSidecar{
+		Map:        strings.ToLower(info.MapName),
+		Game:       info.FSGame,
+		GameType:   info.GameType,
+		DurationMs: lastTime - firstTime,
+		Players:    []SidecarPlayer{},
+	}
+
+	red, blue := applyScores(lastScores, players)
+	if sidecar.GameType >= gtTeam {
+		sidecar.RedScore, sidecar.BlueScore = red, blue
+	}
+
+	for _, p := range players {
+		sidecar.Players = append(sidecar.Players, *p)
+	}
+	sort.Slice(sidecar.Players, func(i, j int) bool {
+		a, b := sidecar.Players[i], sidecar.Players[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Client < b.Client
+	})
+
+	if manifest != nil && sidecar.Map != "" {
+		if _, gm, ok := manifest.GameFor(sidecar.Game); ok {
+			if levelshot, ok := gm.ResolveTexture("levelshots/" + sidecar.Map); ok {
+				sidecar.Levelshot = levelshot
+			}
+		}
+	}
+	return sidecar, nil
+}
+
+// applyScores sets player scores from a "scores" command's arguments:
+// count, red score, blue score, then scoreFieldsPerClient values per client.
+// Returns the team scores.
+func applyScores(args []string, players map[int]*SidecarPlayer) (int, int) {
+	nums := make([]int, len(args))
+	for i, a := range args {
+		nums[i], _ = strconv.Atoi(a)
+	}
+	if len(nums) < 3 {
+		return 0, 0
+	}
+	count, entries := nums[0], nums[3:]
+	for i := 0; i < count && (i+1)*scoreFieldsPerClient <= len(entries); i++ {
+		e := entries[i*scoreFieldsPerClient:]
+		if p, ok := players[e[0]]; ok {
+			p.Score = e[1]
+		}
+	}
+	return nums[1], nums[2]
+}
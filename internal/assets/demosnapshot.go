@@ -0,0 +1,344 @@
+package assets
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+)
+
+// EntityState is an entity's netfields as decoded from a demo. Field order
+// and widths follow the demo protocol's EntityFieldBits; float fields hold
+// float32 bits.
+type EntityState struct {
+	Number int
+	Fields [maxEntityFields]uint32
+}
+
+// PlayerState is a client's netfields as decoded from a demo. Field order and
+// widths follow the demo protocol's PlayerFieldBits; float fields hold
+// float32 bits.
+type PlayerState struct {
+	ClientNum  int
+	Fields     [maxPlayerFields]uint32
+	Stats      [maxStats]int
+	Persistant [maxPersistant]int
+	Ammo       [maxWeapons]int
+	Powerups   [maxPowerups]int
+}
+
+// Snapshot is the game state after applying one demo frame.
+type Snapshot struct {
+	ServerTime    int
+	EntityMask    [maxGentities / 8]byte // entities present in this frame
+	Entities      map[int]*EntityState   // entity number → state
+	Players       map[int]*PlayerState   // client number → playerstate recorded this frame
+	Configstrings map[int]string         // configstrings updated this frame
+	Commands      []ServerCommand        // server commands sent this frame
+}
+
+// Clients returns the client numbers with a playerstate in this frame, in order.
+func (s *Snapshot) Clients() []int {
+	clients := make([]int, 0, len(s.Players))
+	for c := range s.Players {
+		clients = append(clients, c)
+	}
+	sort.Ints(clients)
+	return clients
+}
+
+// SnapshotDecoder applies demo frames in order, tracking entity and
+// per-client playerstates across deltas. TVDs record a playerstate for every
+// connected client in each frame, tagged with its client number.
+type SnapshotDecoder struct {
+	protocol *Protocol
+	entities map[int]*EntityState
+	players  map[int]*PlayerState
+}
+
+// NewSnapshotDecoder returns a decoder for frames of protocol p, positioned
+// before the first frame.
+func NewSnapshotDecoder(p *Protocol) *SnapshotDecoder {
+	return &SnapshotDecoder{
+		protocol: p,
+		entities: make(map[int]*EntityState),
+		players:  make(map[int]*PlayerState),
+	}
+}
+
+// Decode applies one Huffman-encoded frame and returns the resulting snapshot.
+// The returned states are copies and stay valid after later calls.
+func (d *SnapshotDecoder) Decode(frameData []byte) (*Snapshot, error) {
+	msg := NewMsgReader(frameData)
+	msg.Protocol = d.protocol
+	snap := &Snapshot{
+		ServerTime:    int(int32(msg.ReadLong())),
+		Players:       make(map[int]*PlayerState),
+		Configstrings: make(map[int]string),
+	}
+	copy(snap.EntityMask[:], msg.ReadData(maxGentities/8))
+
+	for {
+		entityNum := msg.ReadBits(gentitynumBits)
+		if entityNum == maxGentities-1 {
+			break // end marker
+		}
+		if msg.Overflowed() {
+			return nil, fmt.Errorf("truncated frame at server time %d", snap.ServerTime)
+		}
+		es, ok := d.entities[entityNum]
+		if !ok {
+			es = &EntityState{Number: entityNum}
+		}
+		if readEntityDelta(msg, es) {
+			d.entities[entityNum] = es
+		} else {
+			delete(d.entities, entityNum)
+		}
+	}
+
+	playerBitmask := msg.ReadData(maxClients / 8)
+	for i := 0; i < maxClients; i++ {
+		if playerBitmask[i>>3]&(1<<uint(i&7)) == 0 {
+			continue
+		}
+		clientNum := msg.ReadBits(8)
+		ps, ok := d.players[clientNum]
+		if !ok {
+			ps = &PlayerState{ClientNum: clientNum}
+			d.players[clientNum] = ps
+		}
+		readPlayerDelta(msg, ps)
+		c := *ps
+		snap.Players[clientNum] = &c
+	}
+
+	csCount := msg.ReadShort()
+	if csCount > csMax {
+		return nil, fmt.Errorf("bad configstring count %d at server time %d", csCount, snap.ServerTime)
+	}
+	for i := 0; i < csCount; i++ {
+		csIndex := msg.ReadShort()
+		csLen := msg.ReadShort()
+		if csLen > 0 && csLen < maxConfigstringSize {
+			csData := msg.ReadData(csLen)
+			if csIndex < csMax {
+				snap.Configstrings[csIndex] = string(csData)
+			}
+		}
+	}
+	if err := msg.Err(); err != nil {
+		return nil, fmt.Errorf("truncated frame at server time %d: %w", snap.ServerTime, err)
+	}
+
+	snap.Commands = readServerCommands(msg)
+
+	snap.Entities = make(map[int]*EntityState, len(d.entities))
+	for num, es := range d.entities {
+		c := *es
+		snap.Entities[num] = &c
+	}
+	return snap, nil
+}
+
+// SnapshotEncoder is the inverse of SnapshotDecoder: it writes snapshots as
+// frames, delta-encoding each entity and playerstate against the state the
+// previous frames left, so a decoder given the frames in order reproduces the
+// snapshots. Frames can be rewritten by decoding, editing the snapshot, and
+// encoding it again.
+type SnapshotEncoder struct {
+	protocol *Protocol
+	entities map[int]*EntityState
+	players  map[int]*PlayerState
+}
+
+// NewSnapshotEncoder returns an encoder writing frames of protocol p,
+// positioned before the first frame.
+func NewSnapshotEncoder(p *Protocol) *SnapshotEncoder {
+	return &SnapshotEncoder{
+		protocol: p,
+		entities: make(map[int]*EntityState),
+		players:  make(map[int]*PlayerState),
+	}
+}
+
+// Encode writes one snapshot as a Huffman-encoded frame. Entities tracked by
+// the previous frame and missing from snap.Entities are removed.
+func (e *SnapshotEncoder) Encode(snap *Snapshot) []byte {
+	w := NewMsgWriter()
+	w.Protocol = e.protocol
+	w.WriteLong(snap.ServerTime)
+	w.WriteData(snap.EntityMask[:])
+
+	numbers := make([]int, 0, len(e.entities)+len(snap.Entities))
+	for num := range e.entities {
+		numbers = append(numbers, num)
+	}
+	for num := range snap.Entities {
+		if _, ok := e.entities[num]; !ok {
+			numbers = append(numbers, num)
+		}
+	}
+	sort.Ints(numbers)
+	for _, num := range numbers {
+		from, to := e.entities[num], snap.Entities[num]
+		switch {
+		case to == nil:
+			w.WriteDeltaEntity(from, nil, false)
+			delete(e.entities, num)
+			continue
+		case from == nil:
+			// New entities are sent even when all zero, so the decoder
+			// starts tracking them
+			w.WriteDeltaEntity(&EntityState{Number: num}, to, true)
+		default:
+			w.WriteDeltaEntity(from, to, false)
+		}
+		c := *to
+		c.Number = num
+		e.entities[num] = &c
+	}
+	w.WriteBits(maxGentities-1, gentitynumBits)
+
+	var playerMask [maxClients / 8]byte
+	for c := range snap.Players {
+		playerMask[c>>3] |= 1 << uint(c&7)
+	}
+	w.WriteData(playerMask[:])
+	for _, c := range snap.Clients() {
+		from, ok := e.players[c]
+		if !ok {
+			from = &PlayerState{ClientNum: c}
+		}
+		to := *snap.Players[c]
+		to.ClientNum = c
+		w.WriteByte(byte(c))
+		w.WriteDeltaPlayerstate(from, &to)
+		e.players[c] = &to
+	}
+
+	indices := slices.Sorted(maps.Keys(snap.Configstrings))
+	w.WriteShort(len(indices))
+	for _, index := range indices {
+		value := snap.Configstrings[index]
+		w.WriteShort(index)
+		w.WriteShort(len(value))
+		w.WriteData([]byte(value))
+	}
+
+	w.WriteShort(len(snap.Commands))
+	for _, cmd := range snap.Commands {
+		w.WriteByte(byte(cmd.Target))
+		w.WriteShort(len(cmd.Text))
+		w.WriteData([]byte(cmd.Text))
+	}
+	return w.Bytes()
+}
+
+// POVFrame is one frame of a single client's view of a demo, the shape a
+// dm_68 writer needs for each snapshot.
+type POVFrame struct {
+	ServerTime    int
+	Player        *PlayerState
+	Entities      []*EntityState  // entities present this frame, by number, excluding the client's own
+	Configstrings map[int]string  // configstrings updated this frame
+	Commands      []ServerCommand // server commands addressed to the client or broadcast
+}
+
+// DemoClients returns the client numbers that have a playerstate anywhere in
+// a demo, in order.
+func DemoClients(path string) ([]int, error) {
+	d, err := OpenDemo(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	// Only the player bitmask matters here, but it follows the entity deltas,
+	// so frames are fully decoded.
+	seen := make(map[int]bool)
+	dec := NewSnapshotDecoder(d.Protocol)
+	for {
+		frame, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		snap, err := dec.Decode(frame.Data)
+		if err != nil {
+			return nil, err
+		}
+		for c := range snap.Players {
+			seen[c] = true
+		}
+	}
+
+	clients := make([]int, 0, len(seen))
+	for c := range seen {
+		clients = append(clients, c)
+	}
+	sort.Ints(clients)
+	return clients, nil
+}
+
+// ExtractPOV walks a multi-client demo and calls fn with one client's view of
+// each frame in which that client has a playerstate. Configstring updates and
+// commands from frames where the client is absent are carried into its next
+// frame.
+func ExtractPOV(path string, clientNum int, fn func(*POVFrame) error) error {
+	d, err := OpenDemo(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	dec := NewSnapshotDecoder(d.Protocol)
+	carried := make(map[int]string)
+	var commands []ServerCommand
+	for {
+		frame, err := d.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		snap, err := dec.Decode(frame.Data)
+		if err != nil {
+			return err
+		}
+		for k, v := range snap.Configstrings {
+			carried[k] = v
+		}
+		for _, cmd := range snap.Commands {
+			if cmd.Target == clientNum || cmd.Target == serverCommandBroadcast {
+				commands = append(commands, cmd)
+			}
+		}
+
+		ps, ok := snap.Players[clientNum]
+		if !ok {
+			continue
+		}
+		pov := &POVFrame{
+			ServerTime:    snap.ServerTime,
+			Player:        ps,
+			Configstrings: carried,
+			Commands:      commands,
+		}
+		for num, es := range snap.Entities {
+			if num != clientNum && snap.EntityMask[num>>3]&(1<<uint(num&7)) != 0 {
+				pov.Entities = append(pov.Entities, es)
+			}
+		}
+		sort.Slice(pov.Entities, func(i, j int) bool { return pov.Entities[i].Number < pov.Entities[j].Number })
+		if err := fn(pov); err != nil {
+			return err
+		}
+		carried = make(map[int]string)
+		commands = nil
+	}
+}
@@ -0,0 +1,256 @@
+package assets
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	demoTrailerMagic      = "TVDT"
+	demoTrailerFooterSize = 8 // payload length(4) + magic(4)
+	demoTrailerMaxSize    = 16 << 20
+	demoIndexInterval     = 5000 // server time (ms) between frame index entries
+)
+
+// ErrNoDemoTrailer is returned when a demo ends without a trailer.
+var ErrNoDemoTrailer = errors.New("demo has no trailer")
+
+// DemoTrailer is metadata appended after a TVD's zstd frame stream.
+// Trailer format:
+//   - JSON payload (this struct)
+//   - 4 bytes: payload length (uint32 LE)
+//   - 4 bytes: "TVDT" magic
+//
+// Frame decoders stop at the end of the zstd stream, so demos with a trailer
+// stay playable by readers that don't know about it.
+type DemoTrailer struct {
+	FrameCount      int              `json:"frameCount"`
+	FirstServerTime int              `json:"firstServerTime"`
+	LastServerTime  int              `json:"lastServerTime"`
+	DurationMs      int              `json:"durationMs"`
+	RecordedBy      string           `json:"recordedBy,omitempty"`
+	Index           []DemoIndexEntry `json:"index"`
+}
+
+// DemoIndexEntry maps a server time to the frame that starts there. The zstd
+// frame holding the frame's start is where decompression resumes to seek to
+// it; a stream written as a single zstd frame is always decompressed from
+// its start (RecompressDemo starts a zstd frame at every index entry).
+type DemoIndexEntry struct {
+	ServerTime int   `json:"serverTime"`
+	Offset     int64 `json:"offset"` // offset of the frame's size prefix in the decompressed frame stream
+
+	ZstdFrame     int64 `json:"zstdFrame,omitempty"`     // offset of the zstd frame holding it in the compressed frame stream
+	ZstdFrameBase int64 `json:"zstdFrameBase,omitempty"` // decompressed offset that zstd frame starts at
+}
+
+// Duration returns the demo's length in server time.
+func (t *DemoTrailer) Duration() time.Duration {
+	return time.Duration(t.DurationMs) * time.Millisecond
+}
+
+// ReadDemoTrailer reads the trailer of a demo, returning ErrNoDemoTrailer
+// if it has none.
+func ReadDemoTrailer(path string) (*DemoTrailer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open demo: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	trailer, _, err := readDemoTrailer(f, info.Size())
+	return trailer, err
+}
+
+// readDemoTrailer reads a trailer from the end of a demo and returns it with
+// the offset where it starts.
+func readDemoTrailer(r io.ReaderAt, size int64) (*DemoTrailer, int64, error) {
+	if size < demoTrailerFooterSize {
+		return nil, size, ErrNoDemoTrailer
+	}
+	var footer [demoTrailerFooterSize]byte
+	if _, err := r.ReadAt(footer[:], size-demoTrailerFooterSize); err != nil {
+		return nil, size, fmt.Errorf("read trailer footer: %w", err)
+	}
+	if string(footer[4:8]) != demoTrailerMagic {
+		return nil, size, ErrNoDemoTrailer
+	}
+
+	length := int64(binary.LittleEndian.Uint32(footer[0:4]))
+	start := size - demoTrailerFooterSize - length
+	if length > demoTrailerMaxSize || start < 0 {
+		return nil, size, fmt.Errorf("invalid trailer length: %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, start); err != nil {
+		return nil, size, fmt.Errorf("read trailer: %w", err)
+	}
+
+	var trailer DemoTrailer
+	if err := json.Unmarshal(payload, &trailer); err != nil {
+		// Still report where it starts so a repair can replace it
+		return nil, start, fmt.Errorf("parse trailer: %w", err)
+	}
+	return &trailer, start, nil
+}
+
+// BuildDemoTrailer scans a demo's frames and computes its trailer, indexing a
+// frame every few seconds of server time along with the zstd frame it
+// starts in.
+func BuildDemoTrailer(path, recordedBy string) (*DemoTrailer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open demo: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	streamStart, err := demoStreamStart(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	_, streamEnd, _ := readDemoTrailer(f, info.Size())
+	bounds, err := zstdFrameBounds(f, streamStart, streamEnd)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := newZstdFrameReader(f, bounds)
+	if err != nil {
+		return nil, err
+	}
+	defer frames.close()
+
+	trailer := &DemoTrailer{RecordedBy: recordedBy, Index: []DemoIndexEntry{}}
+	nextIndexTime := 0
+	stream := &frameStream{r: frames}
+	count, err := stream.each(func(offset int64, frame []byte) error {
+		serverTime := int(int32(NewMsgReader(frame).ReadLong()))
+		if len(trailer.Index) == 0 {
+			trailer.FirstServerTime = serverTime
+		}
+		if len(trailer.Index) == 0 || serverTime >= nextIndexTime {
+			zstdFrame, base := frames.frameAt(offset)
+			trailer.Index = append(trailer.Index, DemoIndexEntry{
+				ServerTime:    serverTime,
+				Offset:        offset,
+				ZstdFrame:     zstdFrame - streamStart,
+				ZstdFrameBase: base,
+			})
+			nextIndexTime = serverTime + demoIndexInterval
+		}
+		trailer.LastServerTime = serverTime
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	trailer.FrameCount = count
+	trailer.DurationMs = trailer.LastServerTime - trailer.FirstServerTime
+	return trailer, nil
+}
+
+// demoStreamStart reads a demo's header and returns the offset its frame
+// stream starts at.
+func demoStreamStart(r io.ReaderAt, size int64) (int64, error) {
+	section := io.NewSectionReader(r, 0, size)
+	br := bufio.NewReader(section)
+	if _, _, err := readDemoHeader(br); err != nil {
+		return 0, err
+	}
+	pos, err := section.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	return pos - int64(br.Buffered()), nil
+}
+
+// RepairDemoTrailer rebuilds a demo's trailer from its frames and writes it,
+// replacing any existing trailer. An empty recordedBy keeps the
+// existing trailer's value.
+func RepairDemoTrailer(path, recordedBy string) (*DemoTrailer, error) {
+	if recordedBy == "" {
+		if existing, err := ReadDemoTrailer(path); err == nil {
+			recordedBy = existing.RecordedBy
+		}
+	}
+	trailer, err := BuildDemoTrailer(path, recordedBy)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeDemoTrailer(path, trailer); err != nil {
+		return nil, err
+	}
+	return trailer, nil
+}
+
+// encodeDemoTrailer returns a trailer as it's written after the frame stream.
+func encodeDemoTrailer(trailer *DemoTrailer) ([]byte, error) {
+	payload, err := json.Marshal(trailer)
+	if err != nil {
+		return nil, err
+	}
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(payload)))
+	return append(payload, demoTrailerMagic...), nil
+}
+
+// writeDemoTrailer replaces (or appends) the trailer at the end of a demo.
+// The demo is rewritten to a temp file beside it and renamed over it, so a
+// crash part way leaves the recording as it was.
+func writeDemoTrailer(path string, trailer *DemoTrailer) error {
+	payload, err := encodeDemoTrailer(trailer)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open demo: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, start, err := readDemoTrailer(f, info.Size())
+	if err != nil && !errors.Is(err, ErrNoDemoTrailer) && start == info.Size() {
+		return err // footer present but unusable; can't tell where frames end
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".trailer-*.tvd")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, io.NewSectionReader(f, 0, start))
+	if err == nil {
+		_, err = tmp.Write(payload)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write trailer: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
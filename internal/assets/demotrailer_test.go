@@ -0,0 +1,56 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepairDemoTrailerReplaces(t *testing.T) {
+	path := writeSeekDemo(t)
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewriting swaps in a whole new file holding one trailer, not another
+	// one appended
+	if _, err := RepairDemoTrailer(path, "referee"); err != nil {
+		t.Fatalf("RepairDemoTrailer: %v", err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("trailer rewritten in place")
+	}
+	if after.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", after.Mode().Perm())
+	}
+	trailer, err := ReadDemoTrailer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trailer.RecordedBy != "referee" || len(trailer.Index) != 4 {
+		t.Errorf("trailer = %+v", trailer)
+	}
+	if _, err := RepairDemoTrailer(path, ""); err != nil {
+		t.Fatalf("RepairDemoTrailer: %v", err)
+	}
+	if again, err := os.Stat(path); err != nil || again.Size() != after.Size() {
+		t.Errorf("second repair changed the size from %d: %v", after.Size(), err)
+	}
+	checkSeeks(t, path, demoSeeks)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("left behind %v", entries)
+	}
+}
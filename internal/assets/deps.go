@@ -0,0 +1,82 @@
+package assets
+
+// DepEdge is one reference in an asset dependency graph: From needs To. Nodes
+// are lowered file paths, except shaders, which are "shader:<name>" since a
+// shader needn't have a file of its own. The root of a graph has an edge from "".
+type DepEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"` // map, shader, texture, script, banner, model, skin, sound, music, levelshot, arena, animation, icon, bot, character, include, menu, font, cinematic, vm, entities
+}
+
+// Reason is why a file is part of an asset closure: the reference that first
+// pulled it in.
+type Reason struct {
+	Kind string `json:"kind"`           // see DepEdge
+	From string `json:"from,omitempty"` // the file or shader making the reference
+}
+
+// shaderNode returns the graph node for a lowered shader name.
+func shaderNode(lower string) string {
+	return "shader:" + lower
+}
+
+// depSet collects the files an asset needs along with the references that
+// pulled each one in.
+type depSet struct {
+	files map[string]bool
+	edges []DepEdge
+	seen  map[DepEdge]bool
+	first map[string]int // file → index of the first edge to it
+
+	diags    Diagnostics // references that couldn't be resolved
+	diagSeen map[Diagnostic]bool
+}
+
+func newDepSet() *depSet {
+	return &depSet{
+		files: make(map[string]bool),
+		seen:  make(map[DepEdge]bool),
+		first: make(map[string]int),
+
+		diagSeen: make(map[Diagnostic]bool),
+	}
+}
+
+// add records that from needs file.
+func (d *depSet) add(from, kind, file string) {
+	d.files[file] = true
+	d.link(from, kind, file)
+}
+
+// link records an edge without marking a file, for nodes such as shaders.
+func (d *depSet) link(from, kind, to string) {
+	e := DepEdge{From: from, To: to, Kind: kind}
+	if d.seen[e] {
+		return
+	}
+	d.seen[e] = true
+	if _, ok := d.first[to]; !ok {
+		d.first[to] = len(d.edges)
+	}
+	d.edges = append(d.edges, e)
+}
+
+// reasons returns why each file in the set was pulled in.
+func (d *depSet) reasons() map[string]Reason {
+	reasons := make(map[string]Reason, len(d.files))
+	for file := range d.files {
+		e, _ := d.reason(file)
+		reasons[file] = Reason{Kind: e.Kind, From: e.From}
+	}
+	return reasons
+}
+
+// reason returns the first edge that pulled a node in.
+func (d *depSet) reason(node string) (DepEdge, bool) {
+	i, ok := d.first[node]
+	if !ok {
+		return DepEdge{}, false
+	}
+	return d.edges[i], true
+}
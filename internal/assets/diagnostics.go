@@ -0,0 +1,126 @@
+package assets
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Severity is how serious a Diagnostic is.
+type Severity string
+
+const (
+	// SeverityWarning is a reference that couldn't be resolved; the build
+	// goes on, and the game falls back as it would for any missing file.
+	SeverityWarning Severity = "warning"
+	// SeverityError is a file that couldn't be read or parsed, or an
+	// artifact that couldn't be built.
+	SeverityError Severity = "error"
+)
+
+// Diagnostic kinds
+const (
+	DiagMissingShader  = "missing-shader"  // no definition, and no texture of the same name
+	DiagMissingTexture = "missing-texture" // a shader stage's image isn't in any pk3
+	DiagMissingLight   = "missing-light"   // a q3map_lightImage isn't in any pk3; only recompiling the map needs it
+	DiagMissingModel   = "missing-model"
+	DiagMissingSound   = "missing-sound"
+	DiagMissingMusic   = "missing-music"
+	DiagMissingVideo   = "missing-video"
+	DiagBadFile        = "bad-file"     // a file that failed to read or parse
+	DiagBadPk3         = "bad-pk3"      // a pk3 that couldn't be read, or its shaders or videos
+	DiagBuildFailed    = "build-failed" // a map pk3 that couldn't be built
+	DiagOverBudget     = "over-budget"  // a map pk3 larger than its build's size budget
+	DiagRestricted     = "restricted"   // a pk3 left out of a distributable build for its official id content
+	DiagDownload       = "download"     // a pk3 the engine's in-game download would refuse, mangle, or be slow with
+)
+
+// Diagnostic is a problem found while building: Subject is the file, shader,
+// or map concerned, and Source what referenced it (see DepEdge), if anything.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Kind     string   `json:"kind"`
+	Subject  string   `json:"subject"`
+	Source   string   `json:"source,omitempty"`
+	Map      string   `json:"map,omitempty"` // map pk3 being built, if any
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("%s: %s %s", d.Severity, d.Kind, d.Subject)
+	if d.Source != "" {
+		s += " (from " + d.Source + ")"
+	}
+	if d.Map != "" {
+		s += " in " + d.Map
+	}
+	if d.Detail != "" {
+		s += ": " + d.Detail
+	}
+	return s
+}
+
+// Diagnostics is the list of problems a build found, in the order found.
+type Diagnostics []Diagnostic
+
+// Count returns how many diagnostics have a severity.
+func (ds Diagnostics) Count(severity Severity) int {
+	n := 0
+	for _, d := range ds {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Errors returns how many diagnostics are errors.
+func (ds Diagnostics) Errors() int {
+	return ds.Count(SeverityError)
+}
+
+// ByKind counts the diagnostics of each kind.
+func (ds Diagnostics) ByKind() map[string]int {
+	counts := make(map[string]int)
+	for _, d := range ds {
+		counts[d.Kind]++
+	}
+	return counts
+}
+
+// Summary describes the diagnostics in one line, such as
+// "3 errors, 12 warnings (missing-texture 10, bad-file 3, missing-sound 2)".
+func (ds Diagnostics) Summary() string {
+	s := fmt.Sprintf("%d errors, %d warnings", ds.Errors(), ds.Count(SeverityWarning))
+	counts := ds.ByKind()
+	if len(counts) == 0 {
+		return s
+	}
+	kinds := sortedMapKeys(counts)
+	sort.SliceStable(kinds, func(i, j int) bool { return counts[kinds[i]] > counts[kinds[j]] })
+	s += " ("
+	for i, kind := range kinds {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s %d", kind, counts[kind])
+	}
+	return s + ")"
+}
+
+// warn records a warning. Each distinct diagnostic is recorded once.
+func (d *depSet) warn(kind, subject, source string) {
+	d.diagnose(Diagnostic{Severity: SeverityWarning, Kind: kind, Subject: subject, Source: source})
+}
+
+// fail records an error about a file that couldn't be read or parsed.
+func (d *depSet) fail(subject, source string, err error) {
+	d.diagnose(Diagnostic{Severity: SeverityError, Kind: DiagBadFile, Subject: subject, Source: source, Detail: err.Error()})
+}
+
+func (d *depSet) diagnose(diag Diagnostic) {
+	if d.diagSeen[diag] {
+		return
+	}
+	d.diagSeen[diag] = true
+	d.diags = append(d.diags, diag)
+}
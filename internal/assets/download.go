@@ -0,0 +1,223 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDownloadRetries = 5
+	downloadIdleTimeout    = time.Minute // a download receiving nothing this long is retried
+	downloadMaxBackoff     = 30 * time.Second
+)
+
+// downloadHTTPClient has no overall timeout, which would cut off large or
+// rate-limited downloads; stalls are caught by downloadIdleTimeout instead.
+var downloadHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: time.Minute,
+		TLSHandshakeTimeout:   30 * time.Second,
+	},
+}
+
+// errDownloadNotFound is a server answering 404.
+var errDownloadNotFound = errors.New("not found")
+
+// DownloadOptions tunes the downloads Sync and FetchMap make, for mirrors
+// synced over slow or unreliable links.
+type DownloadOptions struct {
+	RateLimit int64 // bytes per second, shared by all downloads at once; 0 = unlimited
+	Parallel  int   // downloads at once; 0 = 1
+	Retries   int   // times to resume a download after an error; 0 = defaultDownloadRetries, -1 = none
+}
+
+// downloader downloads files into partial files, resuming them with Range
+// requests after errors and across runs.
+type downloader struct {
+	opts    DownloadOptions
+	limit   *rateLimiter
+	maxSize int64 // 0 = unlimited
+}
+
+func newDownloader(opts DownloadOptions) *downloader {
+	return &downloader{opts: opts, limit: newRateLimiter(opts.RateLimit)}
+}
+
+// parallel is the number of downloads to run at once.
+func (d *downloader) parallel() int {
+	return max(d.opts.Parallel, 1)
+}
+
+// fetch downloads u into partPath, continuing from what partPath already
+// holds, and returns the URL the download came from after redirects. The
+// caller verifies the partial file and renames it into place; one that
+// fails verification should be removed so the next attempt starts over.
+func (d *downloader) fetch(u, partPath string) (string, error) {
+	retries := d.opts.Retries
+	if retries == 0 {
+		retries = defaultDownloadRetries
+	}
+	for attempt := 0; ; attempt++ {
+		finalURL, progressed, err := d.fetchOnce(u, partPath)
+		if err == nil || errors.Is(err, errDownloadNotFound) || attempt >= retries {
+			return finalURL, err
+		}
+		if progressed {
+			attempt = 0 // only count attempts that got nowhere
+		}
+		backoff := min(time.Second<<min(attempt, 5), downloadMaxBackoff)
+		log.Printf("Download %s: %v; resuming in %v", u, err, backoff)
+		time.Sleep(backoff)
+	}
+}
+
+// fetchOnce makes one request for the rest of partPath, reporting whether
+// any bytes arrived.
+func (d *downloader) fetchOnce(u, partPath string) (string, bool, error) {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := downloadHTTPClient.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	finalURL := resp.Request.URL.String()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return finalURL, false, errDownloadNotFound
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is already complete (or longer than the file
+		// now is, which verification will catch)
+		return finalURL, false, nil
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			return finalURL, false, fmt.Errorf("server resumed at the wrong offset")
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		offset = 0 // the server ignored the range; start over
+		flags |= os.O_TRUNC
+	default:
+		return finalURL, false, fmt.Errorf("%s", resp.Status)
+	}
+	if d.maxSize > 0 && offset+resp.ContentLength > d.maxSize {
+		return finalURL, false, fmt.Errorf("%s is over the %s limit", formatSize(offset+resp.ContentLength), formatSize(d.maxSize))
+	}
+
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return finalURL, false, err
+	}
+	idle := time.AfterFunc(downloadIdleTimeout, cancel)
+	defer idle.Stop()
+	body := &downloadReader{r: resp.Body, idle: idle, limit: d.limit}
+	var src io.Reader = body
+	if d.maxSize > 0 {
+		src = io.LimitReader(body, d.maxSize-offset+1)
+	}
+	n, err := io.Copy(f, src)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && d.maxSize > 0 && offset+n > d.maxSize {
+		os.Remove(partPath)
+		err = fmt.Errorf("download is over the %s limit", formatSize(d.maxSize))
+	}
+	return finalURL, n > 0, err
+}
+
+// contentRangeStart parses the first byte position of a Content-Range
+// header such as "bytes 100-199/200".
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
+
+// downloadReader reads a response body, holding reads to the rate limit and
+// putting off the idle timeout while data arrives.
+type downloadReader struct {
+	r     io.Reader
+	idle  *time.Timer
+	limit *rateLimiter
+}
+
+func (r *downloadReader) Read(p []byte) (int, error) {
+	if r.limit != nil && len(p) > r.limit.burst() {
+		p = p[:r.limit.burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.idle.Reset(downloadIdleTimeout)
+		r.limit.wait(n)
+	}
+	return n, err
+}
+
+// rateLimiter spaces reads out to an average rate, shared between the
+// downloads running at once.
+type rateLimiter struct {
+	rate int64 // bytes per second
+
+	mu   sync.Mutex
+	next time.Time // when the bytes read so far are paid for
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate}
+}
+
+// burst is the most a single read may take: a tenth of a second's worth.
+func (l *rateLimiter) burst() int {
+	return int(max(l.rate/10, 512))
+}
+
+// wait blocks until n more bytes fit within the rate.
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	until := l.next
+	l.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
@@ -0,0 +1,67 @@
+package assets
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloaderResumes(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	var requests atomic.Int32
+	var ranged atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if requests.Add(1) == 1 {
+			// Drop the connection halfway through
+			w.Header().Set("Content-Length", "100000")
+			w.Write(content[:50000])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		if strings.HasPrefix(req.Header.Get("Range"), "bytes=50000-") {
+			ranged.Store(true)
+		}
+		http.ServeContent(w, req, "f.pk3", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	part := filepath.Join(t.TempDir(), "f.pk3.part")
+	d := newDownloader(DownloadOptions{Retries: 2})
+	if _, err := d.fetch(srv.URL, part); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(part)
+	if !bytes.Equal(got, content) {
+		t.Errorf("downloaded %d bytes, want the %d served", len(got), len(content))
+	}
+	if !ranged.Load() {
+		t.Errorf("second request didn't resume from the partial file")
+	}
+
+	// A complete partial file is left as it is
+	if _, err := d.fetch(srv.URL, part); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(part); !bytes.Equal(got, content) {
+		t.Errorf("refetching a complete file changed it")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(100 << 10)
+	start := time.Now()
+	for range 5 {
+		l.wait(l.burst()) // five tenths of a second's worth
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("0.5s of data at the limit took %v", elapsed)
+	}
+}
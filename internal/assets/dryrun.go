@@ -0,0 +1,98 @@
+package assets
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// BuildPlan is what a dry run would have written.
+type BuildPlan struct {
+	Pk3s []*PlannedPk3 `json:"pk3s"`
+}
+
+// PlannedPk3 is a pk3 a build would write.
+type PlannedPk3 struct {
+	Path  string        `json:"path"`
+	Files []PlannedFile `json:"files"`
+	Size  int64         `json:"size"` // uncompressed total
+}
+
+// PlannedFile is a file a planned pk3 would hold.
+type PlannedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Source string `json:"source,omitempty"` // pk3 it would be copied from
+}
+
+// Size returns the uncompressed total of every planned pk3.
+func (p *BuildPlan) Size() int64 {
+	var total int64
+	for _, pk3 := range p.Pk3s {
+		total += pk3.Size
+	}
+	return total
+}
+
+// add records a planned pk3, replacing an earlier plan for the same path.
+func (p *BuildPlan) add(pk3 *PlannedPk3) {
+	for i, existing := range p.Pk3s {
+		if existing.Path == pk3.Path {
+			p.Pk3s[i] = pk3
+			return
+		}
+	}
+	p.Pk3s = append(p.Pk3s, pk3)
+}
+
+// extend adds files, copied from the pk3s the file index names, to the
+// planned pk3 at path.
+func (p *BuildPlan) extend(path string, files []string, fileIndex map[string]string) error {
+	for _, pk3 := range p.Pk3s {
+		if pk3.Path != path {
+			continue
+		}
+		more, err := planPk3(path, files, fileIndex)
+		if err != nil {
+			return err
+		}
+		pk3.Files = append(pk3.Files, more.Files...)
+		pk3.Size += more.Size
+		sort.Slice(pk3.Files, func(i, j int) bool { return pk3.Files[i].Path < pk3.Files[j].Path })
+		return nil
+	}
+	return fmt.Errorf("%s not planned", path)
+}
+
+// WriteSummary writes one line per planned pk3, then each file if verbose.
+func (p *BuildPlan) WriteSummary(w io.Writer, verbose bool) {
+	for _, pk3 := range p.Pk3s {
+		fmt.Fprintf(w, "%s: %d files, %s\n", pk3.Path, len(pk3.Files), formatSize(pk3.Size))
+		if verbose {
+			for _, f := range pk3.Files {
+				fmt.Fprintf(w, "  %-60s %10s  %s\n", f.Path, formatSize(f.Size), f.Source)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d pk3s, %s\n", len(p.Pk3s), formatSize(p.Size()))
+}
+
+// planPk3 describes a pk3 at outputPath holding paths, each copied from the
+// pk3 the file index names, as writePk3FromIndex would write it.
+func planPk3(outputPath string, paths []string, fileIndex map[string]string) (*PlannedPk3, error) {
+	sizes, err := fileSizes(paths, fileIndex)
+	if err != nil {
+		return nil, err
+	}
+	pk3 := &PlannedPk3{Path: outputPath, Files: make([]PlannedFile, 0, len(paths))}
+	for _, p := range paths {
+		source, ok := fileIndex[p]
+		if !ok {
+			continue
+		}
+		pk3.Files = append(pk3.Files, PlannedFile{Path: p, Size: sizes[p], Source: source})
+		pk3.Size += sizes[p]
+	}
+	sort.Slice(pk3.Files, func(i, j int) bool { return pk3.Files[i].Path < pk3.Files[j].Path })
+	return pk3, nil
+}
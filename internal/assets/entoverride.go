@@ -0,0 +1,48 @@
+package assets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseEntityOverride parses an entity override (a map's .ent file) as
+// ParseBSP does a BSP's entities lump. Only the entity fields are set.
+func ParseEntityOverride(data []byte) (*BSPAssets, error) {
+	if len(data) > bspMaxEntitiesSize {
+		return nil, fmt.Errorf("entity override too large: %d bytes", len(data))
+	}
+	assets := &BSPAssets{}
+	parseEntities(string(data), assets)
+	return assets, nil
+}
+
+// readEntityOverride reads maps/<map>.ent from a game, returning its path
+// and entities, or a nil BSPAssets if the game has none. ioquake3 servers
+// spawn a map's entities from this file when it exists, which is how
+// servers change items, music, and speakers without rebuilding the BSP.
+func readEntityOverride(mapName string, gm *GameManifest) (string, *BSPAssets, error) {
+	entPath := "maps/" + strings.ToLower(mapName) + ".ent"
+	if _, ok := gm.FileIndex[entPath]; !ok {
+		return "", nil, nil
+	}
+	data, err := readFileFromIndex(entPath, gm.FileIndex)
+	if err != nil {
+		return "", nil, fmt.Errorf("read entity override: %w", err)
+	}
+	override, err := ParseEntityOverride(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return entPath, override, nil
+}
+
+// applyEntityOverride merges an entity override's references into a, and
+// takes its worldspawn and classnames, which are what the server spawns.
+func (a *BSPAssets) applyEntityOverride(override *BSPAssets) {
+	a.Music = append(a.Music, override.Music...)
+	a.Sounds = append(a.Sounds, override.Sounds...)
+	a.Models = append(a.Models, override.Models...)
+	a.Videos = append(a.Videos, override.Videos...)
+	a.Worldspawn = override.Worldspawn
+	a.Classnames = override.Classnames
+}
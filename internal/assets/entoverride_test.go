@@ -0,0 +1,39 @@
+package assets
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEntityOverride(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "zz-server.pk3"), map[string][]byte{
+		"maps/custom.ent": []byte("{\n\"classname\" \"worldspawn\"\n\"music\" \"music/custom/theme.wav\"\n}\n" +
+			"{\n\"classname\" \"target_speaker\"\n\"noise\" \"sound/custom/bell.wav\"\n}\n"),
+		"music/custom/theme.wav": []byte("RIFF theme"),
+		"sound/custom/bell.wav":  []byte("RIFF bell"),
+	})
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reasons, err := ResolveMapAssets("custom", manifest.Games["baseq3"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]Reason{
+		"maps/custom.ent":        {Kind: "entities", From: "maps/custom.bsp"},
+		"music/custom/theme.wav": {Kind: "music", From: "maps/custom.ent"},
+		"sound/custom/bell.wav":  {Kind: "sound", From: "maps/custom.ent"},
+		"sound/custom/wind.wav":  {Kind: "sound", From: "maps/custom.bsp"}, // kept for clients without the override
+	} {
+		if got, ok := reasons[file]; !ok || got != want {
+			t.Errorf("%s: got %+v (included %v), want %+v", file, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package assets
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Errors callers can branch on with errors.Is. They're returned wrapped, with
+// the offending file or value added.
+var (
+	// ErrNotTVD is returned for a demo that doesn't start with a TVD header.
+	ErrNotTVD = errors.New("not a TVD file")
+	// ErrUnsupportedProtocol is returned for a network protocol version
+	// LookupProtocol doesn't know.
+	ErrUnsupportedProtocol = errors.New("unsupported protocol")
+	// ErrBSPVersion is returned for a BSP of a version other than Quake
+	// III's or Quake Live's.
+	ErrBSPVersion = errors.New("unsupported BSP version")
+	// ErrBadSignature is returned for a manifest or artifact list whose
+	// signature doesn't verify with the given public key.
+	ErrBadSignature = errors.New("bad signature")
+	// ErrZip64 is returned for a pk3 that would need zip64 records, which
+	// the engine can't read: 65535 or more entries, or 4 GB or more.
+	ErrZip64 = errors.New("pk3 needs zip64, which the engine can't read")
+)
+
+// ErrFileNotInIndex is returned when a file isn't in the file index it's
+// looked up in. It matches fs.ErrNotExist with errors.Is; use errors.As to
+// get the path.
+type ErrFileNotInIndex struct {
+	Path string
+}
+
+func (e *ErrFileNotInIndex) Error() string {
+	return "file not in index: " + e.Path
+}
+
+// Is makes the error match fs.ErrNotExist.
+func (e *ErrFileNotInIndex) Is(target error) bool {
+	return target == fs.ErrNotExist
+}
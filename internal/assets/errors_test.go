@@ -0,0 +1,33 @@
+package assets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestTypedErrors(t *testing.T) {
+	if _, err := ParseDemoBytes([]byte("not a demo at all")); !errors.Is(err, ErrNotTVD) {
+		t.Errorf("ParseDemoBytes: got %v, want ErrNotTVD", err)
+	}
+	if _, err := LookupProtocol(43); !errors.Is(err, ErrUnsupportedProtocol) {
+		t.Errorf("LookupProtocol: got %v, want ErrUnsupportedProtocol", err)
+	}
+
+	bsp := makeBSP(nil, []fixtureEntity{{{"classname", "worldspawn"}}})
+	binary.LittleEndian.PutUint32(bsp[4:8], 38)
+	if _, err := ParseBSP(bytes.NewReader(bsp), int64(len(bsp))); !errors.Is(err, ErrBSPVersion) {
+		t.Errorf("ParseBSP: got %v, want ErrBSPVersion", err)
+	}
+
+	_, err := readFileFromIndex("maps/q3dm0.bsp", map[string]string{})
+	var notInIndex *ErrFileNotInIndex
+	if !errors.As(err, &notInIndex) || notInIndex.Path != "maps/q3dm0.bsp" {
+		t.Errorf("readFileFromIndex: got %v, want ErrFileNotInIndex for maps/q3dm0.bsp", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ErrFileNotInIndex doesn't match fs.ErrNotExist")
+	}
+}
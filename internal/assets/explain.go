@@ -0,0 +1,90 @@
+package assets
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DepNode is a node in a dependency tree: a file or shader and the
+// dependencies it first pulled in.
+type DepNode struct {
+	Name     string     `json:"name"` // lowered file path, or "shader:<name>"
+	Kind     string     `json:"kind"` // how its parent references it (see DepEdge)
+	Source   string     `json:"source,omitempty"`
+	Baseline bool       `json:"baseline,omitempty"` // clients already have it, so it's left out of the pk3
+	Children []*DepNode `json:"children,omitempty"`
+}
+
+// ExplainMapPak runs BuildMapPak's resolver and returns why each file is
+// included, as a tree rooted at the BSP: maps/q3dm6.bsp → shader:textures/
+// base_wall/foo → textures/base_wall/foo.jpg. A file referenced from several
+// places appears once, under the reference that first pulled it in.
+func ExplainMapPak(mapName string, gm *GameManifest) (*DepNode, error) {
+	deps, _, err := resolveMapFiles(mapName, gm)
+	if err != nil {
+		return nil, err
+	}
+	roots := deps.tree(gm)
+	return roots[0], nil
+}
+
+// TraceMapTextures runs BuildMapPak's resolver and returns how each image
+// reference of the map's shaders resolved, sorted by reference: every file
+// tried, the one used, and whether a texture override chose it.
+func TraceMapTextures(mapName string, gm *GameManifest) ([]TextureResolution, error) {
+	deps, _, err := resolveMapFiles(mapName, gm)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var traces []TextureResolution
+	for _, e := range deps.edges {
+		if e.Kind != "shader" {
+			continue
+		}
+		for _, ref := range shaderTextureRefs(strings.TrimPrefix(e.To, "shader:"), gm) {
+			lower := strings.ToLower(ref)
+			if !seen[lower] {
+				seen[lower] = true
+				traces = append(traces, gm.TraceTexture(lower))
+			}
+		}
+	}
+	sort.Slice(traces, func(i, j int) bool { return traces[i].Ref < traces[j].Ref })
+	return traces, nil
+}
+
+// tree arranges the set's nodes under the edges that first reached them and
+// returns the roots, in the order they were added.
+func (d *depSet) tree(gm *GameManifest) []*DepNode {
+	nodes := make(map[string]*DepNode)
+	node := func(name string) *DepNode {
+		n, ok := nodes[name]
+		if !ok {
+			n = &DepNode{Name: name}
+			if pk3, ok := gm.FileIndex[name]; ok {
+				n.Source = filepath.Base(pk3)
+				n.Baseline = gm.BaselineFiles[name]
+			}
+			nodes[name] = n
+		}
+		return n
+	}
+
+	var roots []*DepNode
+	for i, e := range d.edges {
+		if d.first[e.To] != i {
+			continue
+		}
+		child := node(e.To)
+		child.Kind = e.Kind
+		if e.From == "" {
+			roots = append(roots, child)
+		} else {
+			parent := node(e.From)
+			parent.Children = append(parent.Children, child)
+		}
+	}
+	return roots
+}
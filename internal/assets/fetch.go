@@ -0,0 +1,163 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxFetchSize caps a map pk3 download from a repository.
+const maxFetchSize = 1 << 30
+
+// MapRepositoryURL expands a map repository URL template for a map: {map}
+// is replaced with the map name, and a template without it is treated as a
+// directory of <map>.pk3 files. For example, ws.q3df.org's is
+// "https://ws.q3df.org/maps/downloads/{map}.pk3".
+func MapRepositoryURL(template, mapName string) string {
+	if strings.Contains(template, "{map}") {
+		return strings.ReplaceAll(template, "{map}", url.PathEscape(mapName))
+	}
+	return strings.TrimSuffix(template, "/") + "/" + url.PathEscape(mapName) + ".pk3"
+}
+
+// FetchMap downloads the pk3 holding a map from the first of the given
+// repositories (URL templates, see MapRepositoryURL) that has it, into
+// gameDir. The download must pass ValidatePk3 and contain maps/<map>.bsp
+// before it's given a .pk3 name the game or Watch would pick up; until then
+// it's a hidden partial file that a later fetch resumes. It returns the
+// pk3's path and the URL it came from.
+func FetchMap(mapName string, repositories []string, gameDir string, opts DownloadOptions) (string, string, error) {
+	mapName = strings.ToLower(mapName)
+	if mapName == "" || strings.ContainsAny(mapName, `/\`) || mapName == ".." {
+		return "", "", fmt.Errorf("invalid map name %q", mapName)
+	}
+	if len(repositories) == 0 {
+		return "", "", fmt.Errorf("no map repositories configured")
+	}
+	if err := os.MkdirAll(gameDir, 0755); err != nil {
+		return "", "", err
+	}
+
+	d := newDownloader(opts)
+	d.maxSize = maxFetchSize
+	var errs []string
+	for _, template := range repositories {
+		u := MapRepositoryURL(template, mapName)
+		pk3Path, err := fetchMapFrom(d, u, mapName, gameDir)
+		if err == nil {
+			log.Printf("Fetched %s from %s", filepath.Base(pk3Path), u)
+			return pk3Path, u, nil
+		}
+		if !errors.Is(err, errDownloadNotFound) {
+			log.Printf("Warning: fetch %s: %v", u, err)
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", u, err))
+	}
+	return "", "", fmt.Errorf("map %s: %s", mapName, strings.Join(errs, "; "))
+}
+
+// fetchMapFrom downloads and verifies one repository's pk3 for a map.
+func fetchMapFrom(d *downloader, u, mapName, gameDir string) (string, error) {
+	sum := sha256.Sum256([]byte(u))
+	partPath := filepath.Join(gameDir, ".fetch-"+mapName+"-"+hex.EncodeToString(sum[:4])+".part")
+	finalURL, err := d.fetch(u, partPath)
+	if err != nil {
+		return "", err
+	}
+	// The download is complete, so whatever happens to it next there's
+	// nothing left to resume
+	defer os.Remove(partPath)
+
+	// Name the pk3 as the repository does, which is how players will have
+	// it too; redirects to the real file are followed
+	name := path.Base(finalURL)
+	if parsed, err := url.Parse(finalURL); err == nil {
+		name = path.Base(parsed.Path)
+	}
+	if !strings.EqualFold(path.Ext(name), ".pk3") || strings.HasPrefix(name, ".") {
+		name = mapName + ".pk3"
+	}
+	if IsOfficialPak(name) || IsTrinityPak(name) {
+		return "", fmt.Errorf("refusing to install %s over an official pak name", name)
+	}
+	dest := filepath.Join(gameDir, name)
+	if _, err := os.Stat(dest); err == nil {
+		return "", fmt.Errorf("%s already exists", dest)
+	}
+
+	if err := ValidatePk3(partPath); err != nil {
+		return "", fmt.Errorf("invalid pk3: %w", err)
+	}
+	bsp := "maps/" + mapName + ".bsp"
+	hasBSP := false
+	err = IteratePk3(partPath, func(entry string, _ func() (io.ReadCloser, error)) error {
+		if strings.ToLower(entry) == bsp {
+			hasBSP = true
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !hasBSP {
+		return "", fmt.Errorf("pk3 has no %s", bsp)
+	}
+	if err := os.Chmod(partPath, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(partPath, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// AddFetchedMap indexes a map pk3 FetchMap put in one of an install's game
+// directories into the existing build at opts.OutputDir, as Watch would,
+// building its map pk3 with the repository URL as provenance.
+func AddFetchedMap(opts WatchOptions, game, mapName, pk3Path, source string) error {
+	unlock, err := LockOutput(opts.OutputDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	manifest, err := LoadManifest(filepath.Join(opts.OutputDir, "manifest.json"))
+	if err != nil {
+		return err
+	}
+	w := &watcher{opts: opts, manifest: manifest, pk3s: make(map[string]fileStamp)}
+	return w.installFetched(game, mapName, pk3Path, &Provenance{Trigger: "fetch", Source: source})
+}
+
+// installFetched indexes a fetched map pk3, records prov on the map's pk3,
+// and saves the manifest and pure lists.
+func (w *watcher) installFetched(game, mapName, pk3Path string, prov *Provenance) error {
+	gamePk3s := collectGameSources(w.opts.Quake3Dir, w.opts.Build)
+	if err := w.addPk3(game, pk3Path, gamePk3s); err != nil {
+		return err
+	}
+	if stamp, ok := statStamp(pk3Path); ok {
+		w.pk3s[pk3Path] = stamp
+	}
+	rel := "maps/" + strings.ToLower(mapName) + ".pk3"
+	a, ok := w.manifest.Artifacts[rel]
+	if !ok {
+		return fmt.Errorf("%s wasn't built from %s", rel, filepath.Base(pk3Path))
+	}
+	prov.Time = time.Now().UTC()
+	a.Provenance = prov
+	w.manifest.Artifacts[rel] = a
+
+	if err := savePureLists(filepath.Join(w.opts.OutputDir, PureListName), buildPureLists(w.manifest, gamePk3s)); err != nil {
+		return fmt.Errorf("save pure lists: %w", err)
+	}
+	return w.manifest.Save(w.manifestPath())
+}
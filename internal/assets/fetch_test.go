@@ -0,0 +1,134 @@
+package assets
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchMap(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	served := t.TempDir()
+	writeFixturePk3(t, filepath.Join(served, "good", "map-ctf9.pk3"), map[string][]byte{
+		"maps/CTF9.bsp": makeBSP([]string{"textures/base_wall/metal"}, []fixtureEntity{{{"classname", "worldspawn"}}}),
+	})
+	writeFixturePk3(t, filepath.Join(served, "nobsp", "ctf9.pk3"), map[string][]byte{
+		"maps/other.bsp": makeBSP(nil, []fixtureEntity{{{"classname", "worldspawn"}}}),
+	})
+	evil, err := os.Create(filepath.Join(served, "evil.pk3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(evil)
+	zw.Create("../../maps/ctf9.bsp")
+	zw.Close()
+	evil.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/good/ctf9":
+			http.Redirect(w, req, "/files/map-ctf9.pk3", http.StatusFound)
+		case "/files/map-ctf9.pk3":
+			http.ServeFile(w, req, filepath.Join(served, "good", "map-ctf9.pk3"))
+		case "/nobsp/ctf9.pk3":
+			http.ServeFile(w, req, filepath.Join(served, "nobsp", "ctf9.pk3"))
+		case "/evil/ctf9.pk3":
+			http.ServeFile(w, req, filepath.Join(served, "evil.pk3"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	gameDir := filepath.Join(q, "baseq3")
+	for _, repo := range []string{srv.URL + "/missing", srv.URL + "/nobsp", srv.URL + "/evil/{map}.pk3"} {
+		if _, _, err := FetchMap("ctf9", []string{repo}, gameDir, DownloadOptions{Retries: -1}); err == nil {
+			t.Errorf("fetch from %s: want an error", repo)
+		}
+	}
+
+	pk3Path, source, err := FetchMap("CTF9", []string{srv.URL + "/missing", srv.URL + "/good/{map}"}, gameDir, DownloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(pk3Path) != "map-ctf9.pk3" || source != srv.URL+"/good/ctf9" {
+		t.Errorf("fetched %s from %s", pk3Path, source)
+	}
+	entries, _ := os.ReadDir(gameDir)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".part") {
+			t.Errorf("left %s behind", e.Name())
+		}
+	}
+
+	opts := WatchOptions{Quake3Dir: q, OutputDir: out}
+	if err := AddFetchedMap(opts, "baseq3", "ctf9", pk3Path, source); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, ok := manifest.Artifacts["maps/ctf9.pk3"]
+	if !ok || a.Provenance == nil || a.Provenance.Trigger != "fetch" || a.Provenance.Source != source {
+		t.Errorf("artifact = %+v, want fetch provenance", a)
+	}
+}
+
+func TestValidatePk3(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.pk3")
+	writeFixturePk3(t, good, map[string][]byte{"maps/a.bsp": []byte("bsp")})
+	if err := ValidatePk3(good); err != nil {
+		t.Errorf("good pk3: %v", err)
+	}
+
+	for name, entry := range map[string]string{
+		"absolute":  "/etc/passwd",
+		"parent":    "maps/../../x.bsp",
+		"backslash": `maps\a.bsp`,
+	} {
+		p := filepath.Join(dir, name+".pk3")
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		w, _ := zw.Create(entry)
+		w.Write([]byte("x"))
+		zw.Close()
+		f.Close()
+		if err := ValidatePk3(p); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+
+	empty := filepath.Join(dir, "empty.pk3")
+	writeFixturePk3(t, empty, map[string][]byte{})
+	if err := ValidatePk3(empty); err == nil {
+		t.Errorf("empty pk3: want an error")
+	}
+
+	// Corrupt a stored entry's data so it no longer matches its CRC
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "maps/a.bsp", Method: zip.Store})
+	w.Write([]byte("PAYLOAD"))
+	zw.Close()
+	data := bytes.Replace(buf.Bytes(), []byte("PAYLOAD"), []byte("PAYL0AD"), 1)
+	corrupt := filepath.Join(dir, "corrupt.pk3")
+	os.WriteFile(corrupt, data, 0644)
+	if err := ValidatePk3(corrupt); err == nil {
+		t.Errorf("corrupt pk3: want an error")
+	}
+}
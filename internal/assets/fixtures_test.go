@@ -0,0 +1,163 @@
+package assets
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Synthetic fixtures: tiny but structurally valid BSPs, MD3s, shader scripts,
+// and pk3s, generated in code so tests don't need a Quake 3 install.
+
+// fixtureEntity is one BSP entity as ordered key/value pairs.
+type fixtureEntity [][2]string
+
+// makeBSP builds a BSP containing only an entities lump and a shaders lump.
+func makeBSP(shaders []string, entities []fixtureEntity) []byte {
+	var ent strings.Builder
+	for _, e := range entities {
+		ent.WriteString("{\n")
+		for _, kv := range e {
+			ent.WriteString(`"` + kv[0] + `" "` + kv[1] + "\"\n")
+		}
+		ent.WriteString("}\n")
+	}
+	entData := append([]byte(ent.String()), 0)
+
+	shaderData := make([]byte, len(shaders)*bspShaderSize)
+	for i, name := range shaders {
+		copy(shaderData[i*bspShaderSize:i*bspShaderSize+64], name)
+	}
+
+	buf := make([]byte, bspHeaderSize, bspHeaderSize+len(entData)+len(shaderData))
+	copy(buf[0:4], bspMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], bspVersion)
+	putLump := func(lump, offset, length int) {
+		binary.LittleEndian.PutUint32(buf[8+lump*8:], uint32(offset))
+		binary.LittleEndian.PutUint32(buf[8+lump*8+4:], uint32(length))
+	}
+	putLump(bspLumpEntities, len(buf), len(entData))
+	buf = append(buf, entData...)
+	putLump(bspLumpShaders, len(buf), len(shaderData))
+	buf = append(buf, shaderData...)
+	return buf
+}
+
+// makeMD3 builds an MD3 with one frame-less surface per shader.
+func makeMD3(shaders ...string) []byte {
+	buf := make([]byte, md3HeaderSize)
+	copy(buf[0:4], md3Magic)
+	binary.LittleEndian.PutUint32(buf[4:8], md3Version)
+	binary.LittleEndian.PutUint32(buf[84:88], uint32(len(shaders)))
+	binary.LittleEndian.PutUint32(buf[100:104], md3HeaderSize)
+
+	for i, shader := range shaders {
+		surf := make([]byte, md3SurfaceHeaderSize+md3ShaderSize)
+		copy(surf[0:4], md3Magic)
+		copy(surf[4:68], "surf"+string(rune('a'+i)))
+		binary.LittleEndian.PutUint32(surf[76:80], 1)
+		binary.LittleEndian.PutUint32(surf[92:96], md3SurfaceHeaderSize)
+		binary.LittleEndian.PutUint32(surf[104:108], uint32(len(surf)))
+		copy(surf[md3SurfaceHeaderSize:md3SurfaceHeaderSize+64], shader)
+		buf = append(buf, surf...)
+	}
+	binary.LittleEndian.PutUint32(buf[104:108], uint32(len(buf)))
+	return buf
+}
+
+// fixtureImage is placeholder image data; nothing decodes fixture images.
+func fixtureImage(name string) []byte {
+	return []byte("image:" + name)
+}
+
+// writeFixturePk3 writes files into a pk3 at path, creating parent directories.
+func writeFixturePk3(t *testing.T, path string, files map[string][]byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePk3(path, files); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeQuake3Fixture lays out a small install:
+//
+//	baseq3/pak0.pk3        official: UI/sound/shaders, a mapobject, and q3dm0
+//	baseq3/map-custom.pk3  third-party map with its own shaders, model, and sound
+//	missionpack/pak0.pk3   official: a menu file and a map built on baseq3 textures
+func makeQuake3Fixture(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	writeFixturePk3(t, filepath.Join(dir, "baseq3", "pak0.pk3"), map[string][]byte{
+		"gfx/2d/crosshaira.tga":             fixtureImage("crosshaira"),
+		"sound/world/hum.wav":               []byte("RIFF hum"),
+		"music/fla22k_02.wav":               []byte("RIFF music"),
+		"models/mapobjects/lamp.md3":        makeMD3("models/mapobjects/lamp"),
+		"models/mapobjects/lamp.tga":        fixtureImage("lamp"),
+		"textures/base_wall/metal.jpg":      fixtureImage("metal"),
+		"textures/base_wall/glow_blend.tga": fixtureImage("glow_blend"),
+		"textures/base_wall/glow.tga":       fixtureImage("glow"),
+		"levelshots/q3dm0.jpg":              fixtureImage("q3dm0"),
+		"scripts/common.shader":             []byte("textures/common/caulk\n{\n\tsurfaceparm nodraw\n}\n"),
+		"scripts/base_wall.shader":          []byte("textures/base_wall/glow\n{\n\t{\n\t\tmap $lightmap\n\t}\n\t{\n\t\tmap textures/base_wall/glow_blend.tga\n\t}\n}\n"),
+		"scripts/q3dm0.arena":               []byte("{\nmap \"q3dm0\"\n}\n"),
+		"maps/q3dm0.bsp": makeBSP(
+			[]string{"textures/base_wall/metal", "textures/base_wall/glow", "textures/common/caulk", "noshader"},
+			[]fixtureEntity{
+				{{"classname", "worldspawn"}, {"music", "music/fla22k_02.wav"}},
+				{{"classname", "misc_model"}, {"model2", "models/mapobjects/lamp.md3"}},
+				{{"classname", "target_speaker"}, {"noise", "sound/world/hum.wav"}},
+			},
+		),
+	})
+
+	writeFixturePk3(t, filepath.Join(dir, "baseq3", "map-custom.pk3"), map[string][]byte{
+		"maps/custom.bsp": makeBSP(
+			[]string{"textures/custom/floor", "textures/custom/sky", "textures/base_wall/glow"},
+			[]fixtureEntity{
+				{{"classname", "worldspawn"}},
+				{{"classname", "misc_model"}, {"model2", "models/custom/statue.md3"}},
+				{{"classname", "target_speaker"}, {"noise", "sound/custom/wind.wav"}},
+			},
+		),
+		"textures/custom/floor.tga":   fixtureImage("floor"),
+		"textures/custom/sky_env.jpg": fixtureImage("sky_env"),
+		"textures/custom/unused.tga":  fixtureImage("unused"),
+		"scripts/custom.shader":       []byte("textures/custom/sky\n{\n\tsurfaceparm sky\n\tskyparms textures/custom/sky_env - -\n\t{\n\t\tmap textures/custom/sky_env.jpg\n\t}\n}\n"),
+		"models/custom/statue.md3":    makeMD3("models/custom/statue"),
+		"models/custom/statue.tga":    fixtureImage("statue"),
+		"sound/custom/wind.wav":       []byte("RIFF wind"),
+		"levelshots/custom.jpg":       fixtureImage("custom"),
+	})
+
+	writeFixturePk3(t, filepath.Join(dir, "missionpack", "pak0.pk3"), map[string][]byte{
+		"ui/menu.txt": []byte("{}\n"),
+		"maps/mpteam1.bsp": makeBSP(
+			[]string{"textures/base_wall/metal", "textures/mp/panel"},
+			[]fixtureEntity{{{"classname", "worldspawn"}}},
+		),
+		"textures/mp/panel.tga": fixtureImage("panel"),
+	})
+
+	return dir
+}
+
+// pk3Listing returns the sorted entry names of a pk3.
+func pk3Listing(t *testing.T, path string) []string {
+	t.Helper()
+	files, err := MapPakFileSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
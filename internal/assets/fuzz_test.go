@@ -0,0 +1,126 @@
+package assets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Fuzz targets for the parsers that see user-uploaded maps and demos. Run with
+// e.g. go test -fuzz=FuzzParseBSP ./internal/assets; the seeds also run as
+// regular tests.
+
+func FuzzParseBSP(f *testing.F) {
+	f.Add(makeBSP(
+		[]string{"textures/base_wall/metal", "*sky"},
+		[]fixtureEntity{{{"classname", "worldspawn"}, {"music", "music/a.wav loop"}}, {{"noise", "sound/b.wav"}}},
+	))
+	f.Add(makeBSP(nil, nil))
+	f.Add([]byte(bspMagic))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		assets, err := ParseBSP(bytes.NewReader(data), int64(len(data)))
+		if err == nil && len(assets.Shaders) > bspMaxShaders {
+			t.Fatalf("%d shaders exceeds limit", len(assets.Shaders))
+		}
+	})
+}
+
+func FuzzParseMD3Shaders(f *testing.F) {
+	f.Add(makeMD3("models/a", "models/b"))
+	f.Add(makeMD3())
+	f.Add([]byte(md3Magic))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		shaders, err := ParseMD3Shaders(bytes.NewReader(data), int64(len(data)))
+		if err == nil && len(shaders) > md3MaxSurfaces*md3MaxShaders {
+			t.Fatalf("%d shaders exceeds limit", len(shaders))
+		}
+	})
+}
+
+func FuzzParseDemo(f *testing.F) {
+	f.Add(makeTVD([][]byte{{0x01, 0x02, 0x03, 0x04, 0xff, 0xff}}))
+	f.Add(makeTVD(nil))
+	f.Add([]byte("TVD1"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseDemoStream(bufio.NewReader(bytes.NewReader(data)), DemoParseOptions{})
+	})
+}
+
+func FuzzParseShaderScript(f *testing.F) {
+	f.Add([]byte("textures/a\n{\n\tskyparms env/sky - -\n\t{\n\t\tmap textures/a.tga\n\t}\n}\n"))
+	f.Add([]byte("{{{{"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseShaderScript(bytes.NewReader(data))
+	})
+}
+
+func FuzzParseShaderAST(f *testing.F) {
+	f.Add([]byte("// c\ntextures/a // n\n{\n\tsurfaceparm nomarks // x\n\t{ map \"a b.tga\"\n\t}\n}\n"))
+	f.Add([]byte("a{b{c}}/* open"))
+
+	// Anything that parses must survive a write and re-parse
+	f.Fuzz(func(t *testing.T, data []byte) {
+		script, err := ParseShaderAST(bytes.NewReader(data), true)
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteShaderScript(&buf, script); err != nil {
+			t.Fatal(err)
+		}
+		again, err := ParseShaderAST(&buf, true)
+		if err != nil {
+			t.Fatalf("re-parse: %v\n%s", err, buf.String())
+		}
+		if len(again.Shaders) != len(script.Shaders) {
+			t.Fatalf("%d shaders after re-parse, want %d", len(again.Shaders), len(script.Shaders))
+		}
+	})
+}
+
+// makeTVD builds a TVD with a serverinfo configstring and the given raw frames.
+func makeTVD(frames [][]byte) []byte {
+	return makeTVDWithConfigstrings(nil, frames)
+}
+
+// makeTVDWithConfigstrings builds a TVD whose header has a serverinfo
+// configstring and cs, followed by the given raw frames.
+func makeTVDWithConfigstrings(cs map[int]string, frames [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("TVD1")
+	for _, v := range []int32{68, 20, 8} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("q3dm17\x00")
+	buf.WriteString("2026-01-01 00:00:00\x00")
+
+	header := map[int]string{csServerInfo: `\mapname\q3dm17\g_gametype\0`}
+	for index, v := range cs {
+		header[index] = v
+	}
+	for _, index := range slices.Sorted(maps.Keys(header)) {
+		binary.Write(&buf, binary.LittleEndian, uint16(index))
+		binary.Write(&buf, binary.LittleEndian, uint16(len(header[index])))
+		buf.WriteString(header[index])
+	}
+	binary.Write(&buf, binary.LittleEndian, uint16(0xFFFF))
+
+	var stream bytes.Buffer
+	for _, frame := range frames {
+		binary.Write(&stream, binary.LittleEndian, uint32(len(frame)))
+		stream.Write(frame)
+	}
+	enc, _ := zstd.NewWriter(nil)
+	buf.Write(enc.EncodeAll(stream.Bytes(), nil))
+	enc.Close()
+	return buf.Bytes()
+}
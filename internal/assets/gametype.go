@@ -0,0 +1,66 @@
+package assets
+
+// Gametypes a map can be inferred to support, named as in .arena "type"
+// fields. Quake Live's domination is the only mod gametype that needs
+// entities of its own; others (clan arena, freeze tag, ...) play on any map
+// that has spawns.
+const (
+	GametypeFFA       = "ffa"
+	GametypeTourney   = "tourney"
+	GametypeTeam      = "team"
+	GametypeCTF       = "ctf"
+	GametypeOneFlag   = "oneflag"
+	GametypeOverload  = "overload"
+	GametypeHarvester = "harvester"
+	GametypeDom       = "dom"
+)
+
+// inferGametypes returns the gametypes a map's entities support, by the
+// classes of entity each gametype's game code needs to start:
+//
+//   - ffa, tourney, and team: a spawn point. Team deathmatch uses the
+//     deathmatch spawns.
+//   - ctf: red and blue flags. Team spawns are optional; the game falls
+//     back to deathmatch spawns.
+//   - oneflag: a neutral flag as well as the team flags.
+//   - overload: red and blue obelisks.
+//   - harvester: a neutral obelisk (the skull generator) and team obelisks.
+//   - dom: domination points.
+//
+// These are more reliable than .arena "type" fields, which map packs often
+// copy from one map to the next.
+func inferGametypes(classnames map[string]int) []string {
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if classnames[name] == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	var types []string
+	if has("info_player_deathmatch") || has("info_player_start") {
+		types = append(types, GametypeFFA, GametypeTourney, GametypeTeam)
+	}
+	if has("team_ctf_redflag", "team_ctf_blueflag") {
+		types = append(types, GametypeCTF)
+		if has("team_ctf_neutralflag") {
+			types = append(types, GametypeOneFlag)
+		}
+	}
+	if has("team_redobelisk", "team_blueobelisk") {
+		types = append(types, GametypeOverload)
+		if has("team_neutralobelisk") {
+			types = append(types, GametypeHarvester)
+		}
+	}
+	if has("team_dom_point") {
+		types = append(types, GametypeDom)
+	}
+	return types
+}
+
+// Supports reports whether the map was found to support a gametype.
+func (m *MapInfo) Supports(gametype string) bool {
+	return containsString(m.Gametypes, gametype)
+}
@@ -0,0 +1,125 @@
+package assets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gcLeftoverAge is how long a temp file is left alone before it's taken as
+// abandoned.
+const gcLeftoverAge = 24 * time.Hour
+
+// GCOptions configures a garbage collection of an output directory.
+type GCOptions struct {
+	DryRun bool // list what would be collected, touching nothing
+	// Archive, if set, receives each collected artifact under its
+	// output-relative path before it's deleted (see ParseOutputBackend)
+	Archive OutputBackend
+}
+
+// GCResult lists what a garbage collection removed, or would remove.
+type GCResult struct {
+	Artifacts []string // generated pk3s the manifest no longer lists
+	Leftovers []string // temp files from interrupted builds and downloads, untouched for gcLeftoverAge
+	Bytes     int64
+}
+
+// CollectGarbage removes what a manifest no longer accounts for from the
+// output directory it was built into: pk3s of maps since dropped from the
+// install, and the .tmp and .part files of builds and downloads that never
+// finished. Everything else (the manifest, pure lists, levelshots) is left
+// alone. Every builder records the map pk3s it writes (see
+// Manifest.RecordMapPak), so an unlisted pk3 is one no build produces any
+// more. Artifacts are archived first if opts.Archive is set; leftovers are
+// just deleted.
+func CollectGarbage(outputDir string, opts GCOptions) (*GCResult, error) {
+	unlock, err := LockOutput(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	manifest, err := LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	artifacts, leftovers, err := unreferencedArtifacts(outputDir, manifest.Artifacts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GCResult{}
+	collect := func(rel string, archive bool) error {
+		fullPath := filepath.Join(outputDir, filepath.FromSlash(rel))
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return nil // gone since it was listed
+		}
+		if !opts.DryRun {
+			if archive && opts.Archive != nil {
+				if err := publishFile(opts.Archive, fullPath, rel,
+					ObjectMeta{ContentType: pk3ContentType}); err != nil {
+					return fmt.Errorf("archive %s: %w", rel, err)
+				}
+			}
+			if err := os.Remove(fullPath); err != nil {
+				return fmt.Errorf("remove %s: %w", rel, err)
+			}
+		}
+		result.Bytes += info.Size()
+		return nil
+	}
+	for _, rel := range artifacts {
+		if err := collect(rel, true); err != nil {
+			return result, err
+		}
+		result.Artifacts = append(result.Artifacts, rel)
+	}
+	for _, rel := range leftovers {
+		if err := collect(rel, false); err != nil {
+			return result, err
+		}
+		result.Leftovers = append(result.Leftovers, rel)
+	}
+	return result, nil
+}
+
+// unreferencedArtifacts lists the generated pk3s in outputDir that artifacts
+// doesn't, and the temp files left beside them, as sorted output-relative
+// paths.
+func unreferencedArtifacts(outputDir string, artifacts map[string]Artifact) (pk3s, leftovers []string, err error) {
+	for _, dir := range []string{"", "maps"} {
+		entries, err := os.ReadDir(filepath.Join(outputDir, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			rel := e.Name()
+			if dir != "" {
+				rel = dir + "/" + rel
+			}
+			lower := strings.ToLower(e.Name())
+			switch {
+			case strings.HasSuffix(lower, ".pk3"):
+				if _, ok := artifacts[rel]; !ok {
+					pk3s = append(pk3s, rel)
+				}
+			case strings.HasSuffix(lower, ".pk3.tmp"), strings.HasSuffix(lower, ".pk3.part"):
+				// A recent one may be a build or a resumable download still
+				// under way
+				if info, err := e.Info(); err == nil && time.Since(info.ModTime()) >= gcLeftoverAge {
+					leftovers = append(leftovers, rel)
+				}
+			}
+		}
+	}
+	return pk3s, leftovers, nil
+}
@@ -0,0 +1,87 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	// Rebuild a map pk3 as mappak build does, from a manifest that doesn't
+	// list it yet; recording it must keep gc from taking it
+	manifestPath := filepath.Join(out, "manifest.json")
+	manifest, err := LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(manifest.Artifacts, "maps/q3dm0.pk3")
+	if _, err := BuildMapPak("q3dm0", "baseq3", manifest, q, filepath.Join(out, "maps", "q3dm0.pk3"), MapPakOptions{}); err != nil {
+		t.Fatalf("BuildMapPak: %v", err)
+	}
+	if recorded, err := manifest.RecordMapPak("baseq3", "q3dm0", out, &Provenance{Trigger: "mappak"}); err != nil || !recorded {
+		t.Fatalf("RecordMapPak = %v, %v", recorded, err)
+	}
+	if err := manifest.Save(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(rel string, age time.Duration) {
+		p := filepath.Join(out, filepath.FromSlash(rel))
+		if err := os.WriteFile(p, []byte("stale"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		when := time.Now().Add(-age)
+		if err := os.Chtimes(p, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("maps/dropped.pk3", 0)
+	write("oldmod.pk3", 0)
+	write("maps/q3dm0.pk3.part", 0)            // a download that may still be running
+	write("maps/custom.pk3.tmp", 48*time.Hour) // long abandoned
+
+	result, err := CollectGarbage(out, GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("CollectGarbage dry run: %v", err)
+	}
+	if want := []string{"oldmod.pk3", "maps/dropped.pk3"}; !slices.Equal(result.Artifacts, want) {
+		t.Errorf("Artifacts = %v, want %v", result.Artifacts, want)
+	}
+	if want := []string{"maps/custom.pk3.tmp"}; !slices.Equal(result.Leftovers, want) {
+		t.Errorf("Leftovers = %v, want %v", result.Leftovers, want)
+	}
+	if _, err := os.Stat(filepath.Join(out, "maps", "dropped.pk3")); err != nil {
+		t.Errorf("dry run removed a pk3: %v", err)
+	}
+
+	archive := t.TempDir()
+	if _, err := CollectGarbage(out, GCOptions{Archive: &LocalBackend{Dir: archive}}); err != nil {
+		t.Fatalf("CollectGarbage: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "maps", "dropped.pk3")); !os.IsNotExist(err) {
+		t.Errorf("dropped.pk3 still in the output: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(archive, "maps", "dropped.pk3")); err != nil {
+		t.Errorf("dropped.pk3 not archived: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(archive, "maps", "custom.pk3.tmp")); !os.IsNotExist(err) {
+		t.Errorf("leftover was archived: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "maps", "q3dm0.pk3")); err != nil {
+		t.Errorf("recorded map pk3 removed: %v", err)
+	}
+	manifest, err = LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := manifest.Artifacts["maps/q3dm0.pk3"]; a.Provenance == nil || a.Provenance.Trigger != "mappak" {
+		t.Errorf("q3dm0.pk3 artifact = %+v, want mappak provenance", a)
+	}
+}
@@ -0,0 +1,164 @@
+package assets
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata/golden")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// Builders log progress for every pk3; keep test output readable.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// checkGolden compares got against testdata/golden/<name>, or rewrites it with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file (run with -update to create): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch (run with -update to accept)\n--- got ---\n%s\n--- want ---\n%s", name, got, want)
+	}
+}
+
+// summarizeManifest renders the parts of a manifest the resolvers depend on,
+// with source paths made relative to the install so output is stable.
+func summarizeManifest(t *testing.T, m *Manifest, quake3Dir string) string {
+	t.Helper()
+	rel := func(p string) string {
+		r, err := filepath.Rel(quake3Dir, p)
+		if err != nil {
+			return p
+		}
+		return filepath.ToSlash(r)
+	}
+
+	var b strings.Builder
+	for _, game := range m.GameNames() {
+		gm := m.Games[game]
+		fmt.Fprintf(&b, "game %s\n", game)
+
+		for _, path := range sortedKeys(gm.FileIndex) {
+			flags := ""
+			if gm.BaselineFiles[path] {
+				flags += " baseline"
+			}
+			if gm.OfficialFiles[path] {
+				flags += " official"
+			}
+			fmt.Fprintf(&b, "  file %s <- %s%s\n", path, rel(gm.FileIndex[path]), flags)
+		}
+		for _, name := range sortedKeys(gm.Shaders) {
+			fmt.Fprintf(&b, "  shader %s (%s) -> %s\n", name, gm.ShaderFiles[name], strings.Join(gm.Shaders[name], " "))
+		}
+		for _, name := range sortedKeys(gm.ShaderRefs) {
+			fmt.Fprintf(&b, "  refs %s <- %s\n", name, strings.Join(gm.ShaderRefs[name], " "))
+		}
+		for _, q := range gm.Quarantined {
+			fmt.Fprintf(&b, "  quarantined %s\n", rel(q.Path))
+		}
+	}
+	for _, name := range sortedKeys(m.Artifacts) {
+		restricted := ""
+		if m.Artifacts[name].Restricted {
+			restricted = " restricted"
+		}
+		fmt.Fprintf(&b, "artifact %s%s\n", name, restricted)
+	}
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestBuildBaselineGolden(t *testing.T) {
+	quake3Dir := makeQuake3Fixture(t)
+	outputDir := t.TempDir()
+
+	if _, err := BuildBaseline(quake3Dir, outputDir, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+
+	manifest, err := LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	b.WriteString(summarizeManifest(t, manifest, quake3Dir))
+	for _, name := range sortedKeys(manifest.Artifacts) {
+		fmt.Fprintf(&b, "pk3 %s\n", name)
+		for _, entry := range pk3Listing(t, filepath.Join(outputDir, filepath.FromSlash(name))) {
+			fmt.Fprintf(&b, "  %s\n", entry)
+		}
+	}
+	checkGolden(t, "baseline.golden", []byte(b.String()))
+}
+
+func TestBuildMapPakGolden(t *testing.T) {
+	quake3Dir := makeQuake3Fixture(t)
+	outputDir := t.TempDir()
+
+	if _, err := BuildBaseline(quake3Dir, outputDir, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	manifest, err := LoadManifest(filepath.Join(outputDir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ mapName, game string }{
+		{"q3dm0", "baseq3"},
+		{"custom", "baseq3"},
+		{"mpteam1", "missionpack"},
+	} {
+		t.Run(tc.mapName, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), tc.mapName+".pk3")
+			if _, err := BuildMapPak(tc.mapName, tc.game, manifest, quake3Dir, out, MapPakOptions{}); err != nil {
+				t.Fatalf("BuildMapPak: %v", err)
+			}
+			var listing string
+			if _, err := os.Stat(out); err == nil {
+				listing = strings.Join(pk3Listing(t, out), "\n") + "\n"
+				m, err := ReadMapPakManifest(out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				listing += "\n"
+				for _, f := range m.Files {
+					listing += fmt.Sprintf("%s: %s from %q (%s)\n", f.Path, f.Reason, f.From, f.Source)
+				}
+			}
+			checkGolden(t, "mappak_"+tc.mapName+".golden", []byte(listing))
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package assets
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DepGraph is an asset's full dependency graph: every reference, not just
+// the first to each file as in ExplainMapPak. Edges from the root asset have
+// From set to Root.
+type DepGraph struct {
+	Root  string      `json:"root"`
+	Nodes []DepVertex `json:"nodes"`
+	Edges []DepEdge   `json:"edges"`
+}
+
+// DepVertex is a node in a DepGraph. Sizes are uncompressed; Total is the
+// size of every file reachable from the node, itself included, counting each
+// file once.
+type DepVertex struct {
+	Name     string `json:"name"`
+	Source   string `json:"source,omitempty"`
+	Baseline bool   `json:"baseline,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Total    int64  `json:"total,omitempty"`
+}
+
+// MapDepGraph returns the dependency graph of a map, as BuildMapPak resolves it.
+func MapDepGraph(mapName string, gm *GameManifest) (*DepGraph, error) {
+	deps, _, err := resolveMapFiles(mapName, gm)
+	if err != nil {
+		return nil, err
+	}
+	return newDepGraph("maps/"+strings.ToLower(mapName)+".bsp", deps, gm)
+}
+
+// ModelDepGraph returns the dependency graph of an MD3 model, or of a player
+// model given in "name/skin" form (head, upper, and lower models, skins,
+// animation config, icon, and sounds). Sounds the player model lacks come
+// from fallback's model, as in game.
+func ModelDepGraph(model string, gm *GameManifest, fallback PlayerFallback) (*DepGraph, error) {
+	deps := newDepSet()
+	lower := strings.ToLower(model)
+	root := lower
+	if strings.HasSuffix(lower, ".md3") {
+		if _, ok := gm.FileIndex[lower]; !ok {
+			return nil, fmt.Errorf("model not found: %s", model)
+		}
+		resolveModel(lower, "", gm, deps)
+	} else {
+		name, skin := splitModelSkin(lower)
+		root = "player:" + name + "/" + skin
+		if !playerModelLoads(name, skin, name, skin, gm) {
+			return nil, fmt.Errorf("player model not found: %s", model)
+		}
+		resolvePlayerModel(lower, "", fallback, "", gm, deps)
+		if len(deps.files) == 0 {
+			return nil, fmt.Errorf("player model not found: %s", model)
+		}
+	}
+	return newDepGraph(root, deps, gm)
+}
+
+func newDepGraph(root string, deps *depSet, gm *GameManifest) (*DepGraph, error) {
+	sizes, err := fileSizes(mapKeys(deps.files), gm.FileIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &DepGraph{Root: root}
+	children := make(map[string][]string)
+	names := map[string]bool{root: true}
+	for _, e := range deps.edges {
+		if e.From == "" {
+			e.From = root
+		}
+		if e.From == e.To {
+			continue // a single model's graph has itself as root
+		}
+		g.Edges = append(g.Edges, e)
+		children[e.From] = append(children[e.From], e.To)
+		names[e.From], names[e.To] = true, true
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+
+	for _, name := range sortedMapKeys(names) {
+		v := DepVertex{Name: name, Size: sizes[name]}
+		if pk3, ok := gm.FileIndex[name]; ok {
+			v.Source = filepath.Base(pk3)
+			v.Baseline = gm.BaselineFiles[name]
+		}
+		seen := make(map[string]bool)
+		var walk func(n string)
+		walk = func(n string) {
+			if seen[n] {
+				return
+			}
+			seen[n] = true
+			v.Total += sizes[n]
+			for _, c := range children[n] {
+				walk(c)
+			}
+		}
+		walk(name)
+		g.Nodes = append(g.Nodes, v)
+	}
+	return g, nil
+}
+
+// fileSizes returns the uncompressed size of each path in the file index.
+func fileSizes(paths []string, fileIndex map[string]string) (map[string]int64, error) {
+	byPk3 := make(map[string]map[string]bool)
+	for _, p := range paths {
+		if pk3, ok := fileIndex[p]; ok {
+			if byPk3[pk3] == nil {
+				byPk3[pk3] = make(map[string]bool)
+			}
+			byPk3[pk3][p] = true
+		}
+	}
+	sizes := make(map[string]int64, len(paths))
+	for pk3, wanted := range byPk3 {
+		err := IteratePk3Files(pk3, func(f *Pk3File) error {
+			if lower := strings.ToLower(f.Name); wanted[lower] {
+				sizes[lower] = f.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sizes, nil
+}
+
+// WriteDOT writes the graph in GraphViz DOT format. Nodes are labeled with
+// their own and reachable sizes; baseline files are grey and shaders boxed.
+func (g *DepGraph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph deps {\n\trankdir=LR;\n\tnode [shape=ellipse, fontsize=10];\n")
+	for _, v := range g.Nodes {
+		var sizes []string
+		if v.Size > 0 {
+			sizes = append(sizes, formatSize(v.Size))
+		}
+		if v.Total > v.Size {
+			sizes = append(sizes, "("+formatSize(v.Total)+" total)")
+		}
+		label := v.Name
+		if len(sizes) > 0 {
+			label += `\n` + strings.Join(sizes, " ")
+		}
+		attrs := "label=" + dotQuote(label)
+		if strings.HasPrefix(v.Name, "shader:") {
+			attrs += ", shape=box"
+		}
+		if v.Baseline {
+			attrs += ", color=grey, fontcolor=grey"
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(v.Name), attrs)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n", dotQuote(e.From), dotQuote(e.To), dotQuote(e.Kind))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotQuote quotes a DOT ID, leaving escapes such as \n for line breaks intact.
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
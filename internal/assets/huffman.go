@@ -1,5 +1,11 @@
 package assets
 
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
 // Q3 static Huffman decoder using the precomputed lookup table from
 // trinity-engine/code/qcommon/huffman_static.c (backported from uberdemotools).
 //
@@ -139,26 +145,47 @@ var huffDecoderTable = [2048]uint16{
 	2322, 2504, 512, 2581, 2350, 1288, 512, 1568, 2323, 2597, 512, 1281, 1858, 1923, 512, 1543,
 }
 
+// ErrMsgOverflow is returned by MsgReader.Err after a read past the end of
+// the message.
+var ErrMsgOverflow = errors.New("read past end of message")
+
+// String read limits, from the engine's MSG_ReadString and MSG_ReadBigString.
+const (
+	maxStringChars    = 1024
+	bigInfoStringSize = 8192
+)
+
 // MsgReader reads Huffman-encoded Q3 message data.
 // Matches the MSG_ReadBits implementation from msg.c.
+//
+// Reads past the end of the message return zeros, as in the engine, and
+// set a sticky overflow flag: check Overflowed or Err after a group of reads
+// to tell a truncated message from one that really holds zeros.
 type MsgReader struct {
-	data    []byte
-	bitPos  int
-	maxBits int
+	// Protocol holds the field tables entity and playerstate deltas are
+	// read with.
+	Protocol *Protocol
+
+	data       []byte
+	bitPos     int
+	maxBits    int
+	overflowed bool
 }
 
 // NewMsgReader creates a new Huffman message reader.
 func NewMsgReader(data []byte) *MsgReader {
 	return &MsgReader{
-		data:    data,
-		bitPos:  0,
-		maxBits: len(data) * 8,
+		Protocol: ProtocolQ3,
+		data:     data,
+		bitPos:   0,
+		maxBits:  len(data) * 8,
 	}
 }
 
 // getBit reads a single raw bit from the stream (matches HuffmanGetBit).
 func (m *MsgReader) getBit() int {
 	if m.bitPos >= m.maxBits {
+		m.overflowed = true
 		return 0
 	}
 	byteIdx := m.bitPos >> 3
@@ -171,6 +198,7 @@ func (m *MsgReader) getBit() int {
 // Returns the symbol and advances bitPos by the symbol's code length.
 func (m *MsgReader) getSymbol() byte {
 	if m.bitPos >= m.maxBits {
+		m.overflowed = true
 		return 0
 	}
 	byteIdx := m.bitPos >> 3
@@ -197,15 +225,20 @@ func (m *MsgReader) getSymbol() byte {
 	code := (val >> bitOff) & 0x7FF
 	entry := huffDecoderTable[code]
 	m.bitPos += int(entry >> 8)
+	if m.bitPos > m.maxBits {
+		m.overflowed = true // the code ran into the missing bits
+	}
 	return byte(entry & 0xFF)
 }
 
 // ReadBits reads n bits from the Huffman-encoded stream.
 // Matches MSG_ReadBits: sub-byte portions read raw bits,
 // remaining full bytes use Huffman symbol decoding.
-// Negative n means signed (absolute value for bit count).
+// Negative n means signed (absolute value for bit count), but the value
+// isn't sign extended; see ReadSignedBits.
 func (m *MsgReader) ReadBits(n int) int {
 	if m.bitPos >= m.maxBits {
+		m.overflowed = true
 		return 0
 	}
 
@@ -233,31 +266,124 @@ func (m *MsgReader) ReadBits(n int) int {
 	return value
 }
 
-// ReadByte reads one byte (8 bits via Huffman).
-func (m *MsgReader) ReadByte() byte {
-	return byte(m.ReadBits(8))
+// ReadSignedBits reads n bits and sign extends them, as MSG_ReadBits does
+// for a negative bit count.
+func (m *MsgReader) ReadSignedBits(n int) int {
+	return signExtend(m.ReadBits(n), n)
 }
 
-// ReadShort reads a 16-bit value via Huffman.
+// ReadByte reads one byte (8 bits via Huffman). It returns ErrMsgOverflow
+// past the end of the message, so a MsgReader is an io.ByteReader.
+func (m *MsgReader) ReadByte() (byte, error) {
+	b := byte(m.ReadBits(8))
+	if m.overflowed {
+		return b, ErrMsgOverflow
+	}
+	return b, nil
+}
+
+// ReadChar reads a signed byte (matches MSG_ReadChar).
+func (m *MsgReader) ReadChar() int {
+	return m.ReadSignedBits(8)
+}
+
+// ReadShort reads a 16-bit value via Huffman, unsigned.
 func (m *MsgReader) ReadShort() int {
 	return m.ReadBits(16)
 }
 
-// ReadLong reads a 32-bit value via Huffman.
+// ReadSignedShort reads a signed 16-bit value (matches MSG_ReadShort).
+func (m *MsgReader) ReadSignedShort() int {
+	return m.ReadSignedBits(16)
+}
+
+// ReadLong reads a 32-bit value via Huffman, unsigned.
 func (m *MsgReader) ReadLong() int {
 	return m.ReadBits(32)
 }
 
+// ReadSignedLong reads a signed 32-bit value (matches MSG_ReadLong).
+func (m *MsgReader) ReadSignedLong() int {
+	return int(int32(m.ReadBits(32)))
+}
+
+// ReadFloat reads a 32-bit IEEE float (matches MSG_ReadFloat).
+func (m *MsgReader) ReadFloat() float32 {
+	return math.Float32frombits(uint32(m.ReadBits(32)))
+}
+
+// ReadString reads a null-terminated string of up to 1023 characters
+// (matches MSG_ReadString: '%' and bytes above 127 read as '.').
+func (m *MsgReader) ReadString() string {
+	return m.readString(maxStringChars)
+}
+
+// ReadBigString reads a null-terminated string of up to 8191 characters
+// (matches MSG_ReadBigString).
+func (m *MsgReader) ReadBigString() string {
+	return m.readString(bigInfoStringSize)
+}
+
+func (m *MsgReader) readString(size int) string {
+	buf := make([]byte, 0, 64)
+	for len(buf) < size-1 {
+		c, err := m.ReadByte()
+		if err != nil || c == 0 {
+			break
+		}
+		if c == '%' || c > 127 {
+			c = '.'
+		}
+		buf = append(buf, c)
+	}
+	return string(buf)
+}
+
 // ReadData reads n bytes, each via Huffman decoding (matches MSG_ReadData).
 func (m *MsgReader) ReadData(n int) []byte {
 	buf := make([]byte, n)
 	for i := 0; i < n; i++ {
-		buf[i] = m.ReadByte()
+		buf[i] = byte(m.ReadBits(8))
 	}
 	return buf
 }
 
-// Remaining returns the number of raw bits remaining in the stream.
+// Remaining returns the number of raw bits remaining in the stream, or 0
+// once it has overflowed.
 func (m *MsgReader) Remaining() int {
-	return m.maxBits - m.bitPos
+	return max(m.maxBits-m.bitPos, 0)
+}
+
+// Overflowed reports whether any read has gone past the end of the message.
+func (m *MsgReader) Overflowed() bool {
+	return m.overflowed
+}
+
+// Err returns ErrMsgOverflow, with the message's length, if any read has
+// gone past the end of the message.
+func (m *MsgReader) Err() error {
+	if m.overflowed {
+		return fmt.Errorf("%w (%d bits)", ErrMsgOverflow, m.maxBits)
+	}
+	return nil
+}
+
+// huffEncoderTable maps each symbol to its Huffman code (bits in stream
+// order, LSB first) and code length, derived from huffDecoderTable.
+var huffEncoderTable = buildHuffEncoderTable()
+
+type huffCode struct {
+	code uint16
+	bits int
+}
+
+func buildHuffEncoderTable() [256]huffCode {
+	var table [256]huffCode
+	for code, entry := range huffDecoderTable {
+		sym, bits := entry&0xFF, int(entry>>8)
+		if table[sym].bits == 0 {
+			table[sym] = huffCode{code: uint16(code) & (1<<uint(bits) - 1), bits: bits}
+		}
+	}
+	return table
 }
@@ -0,0 +1,195 @@
+package assets
+
+import (
+	"log"
+	"sort"
+)
+
+// MergeUnder layers base underneath gm, as the engine does when gm's game
+// directory is loaded over base's. Precedence:
+//
+//   - FileIndex, Shaders, and ShaderFiles: gm's entries override base's.
+//   - ShaderLinks and LightImages: gm's, plus base's for shaders gm doesn't redefine.
+//   - OriginalNames follow whichever copy of a file won.
+//   - BaselineFiles: the union, since clients have both games' baselines.
+//   - OfficialFiles: gm's, plus base's where base still supplies the winning copy.
+//   - ShaderRefs: gm's referrers, plus base's whose file base still supplies.
+//   - Workshop: the union, keyed by pk3 path.
+//   - Videos: gm's entries override base's, like FileIndex.
+//
+// Quarantined and Substituted describe gm's own build and are left alone.
+// Merging a chain (mod over missionpack over baseq3) works from the bottom:
+// merge missionpack under baseq3 first, then the mod under the result.
+func (gm *GameManifest) MergeUnder(base *GameManifest) {
+	gm.FileIndex = mergeOver(base.FileIndex, gm.FileIndex)
+	gm.ShaderLinks = gm.mergeShaderLists(base.ShaderLinks, gm.ShaderLinks)
+	gm.LightImages = gm.mergeShaderLists(base.LightImages, gm.LightImages)
+	gm.Shaders = mergeOver(base.Shaders, gm.Shaders)
+	gm.ShaderFiles = mergeOver(base.ShaderFiles, gm.ShaderFiles)
+	gm.BaselineFiles = mergeOver(base.BaselineFiles, gm.BaselineFiles)
+	if len(base.Workshop) > 0 {
+		gm.Workshop = mergeOver(base.Workshop, gm.Workshop)
+	}
+	if len(base.Videos) > 0 {
+		gm.Videos = mergeOver(base.Videos, gm.Videos)
+	}
+
+	// Base entries tied to a file hold only where base's copy still wins
+	baseWins := func(path string) bool { return gm.FileIndex[path] == base.FileIndex[path] }
+
+	mergedOfficial := make(map[string]bool, len(base.OfficialFiles)+len(gm.OfficialFiles))
+	for k := range base.OfficialFiles {
+		if baseWins(k) {
+			mergedOfficial[k] = true
+		}
+	}
+	for k := range gm.OfficialFiles {
+		mergedOfficial[k] = true
+	}
+	gm.OfficialFiles = mergedOfficial
+
+	mergedNames := make(map[string]string, len(base.OriginalNames)+len(gm.OriginalNames))
+	for k, v := range base.OriginalNames {
+		if baseWins(k) {
+			mergedNames[k] = v
+		}
+	}
+	for k, v := range gm.OriginalNames {
+		mergedNames[k] = v
+	}
+	gm.OriginalNames = mergedNames
+
+	if len(base.ShaderRefs) > 0 {
+		refs := make(map[string][]string, len(base.ShaderRefs)+len(gm.ShaderRefs))
+		for shader, referrers := range gm.ShaderRefs {
+			refs[shader] = append([]string(nil), referrers...)
+		}
+		for shader, referrers := range base.ShaderRefs {
+			for _, r := range referrers {
+				if baseWins(r) && !containsString(refs[shader], r) {
+					refs[shader] = append(refs[shader], r)
+				}
+			}
+		}
+		for _, referrers := range refs {
+			sort.Strings(referrers)
+		}
+		gm.ShaderRefs = refs
+	}
+}
+
+// mergeShaderLists merges a per-shader map such as ShaderLinks: gm's
+// entries, plus base's for shaders gm doesn't redefine. It's called before
+// gm.Shaders takes in base's.
+func (gm *GameManifest) mergeShaderLists(base, own map[string][]string) map[string][]string {
+	if len(base) == 0 {
+		return own
+	}
+	lists := make(map[string][]string, len(base)+len(own))
+	for name, list := range base {
+		if _, redefined := gm.Shaders[name]; !redefined {
+			lists[name] = list
+		}
+	}
+	return mergeOver(lists, own)
+}
+
+// mergeOver returns a new map with over's entries on top of under's.
+func mergeOver[V any](under, over map[string]V) map[string]V {
+	merged := make(map[string]V, len(under)+len(over))
+	for k, v := range under {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// gameBase returns the game that game is layered over: its entry in bases,
+// or baseq3. baseq3 itself has no base.
+func gameBase(game string, bases map[string]string) string {
+	if game == "baseq3" {
+		return ""
+	}
+	if base := bases[game]; base != "" {
+		return base
+	}
+	return "baseq3"
+}
+
+// layerGames merges each game in the manifest over its base, bases first, and
+// records the base in GameManifest.Base. A game whose base isn't in the
+// manifest, or whose bases loop, is layered over baseq3 instead.
+func layerGames(manifest *Manifest, bases map[string]string) {
+	merged := make(map[string]bool)
+	var layer func(game string)
+	layer = func(game string) {
+		if merged[game] {
+			return
+		}
+		merged[game] = true
+		base := gameBase(game, bases)
+		if base == "" {
+			return
+		}
+		if _, ok := manifest.Games[base]; (!ok || layersLoop(game, bases)) && base != "baseq3" {
+			log.Printf("Warning: can't layer %s over %s; using baseq3", game, base)
+			base = "baseq3"
+		}
+		baseGM, ok := manifest.Games[base]
+		if !ok || base == game {
+			return
+		}
+		layer(base)
+		manifest.Games[game].MergeUnder(baseGM)
+		manifest.Games[game].Base = base
+	}
+	for _, game := range manifest.GameNames() {
+		layer(game)
+	}
+}
+
+func layersLoop(game string, bases map[string]string) bool {
+	seen := map[string]bool{game: true}
+	for g := gameBase(game, bases); g != ""; g = gameBase(g, bases) {
+		if seen[g] {
+			return true
+		}
+		seen[g] = true
+	}
+	return false
+}
+
+// Layers returns game followed by the games merged beneath it, top first.
+// Manifests from before bases were recorded layer everything over baseq3.
+func (m *Manifest) Layers(game string) []string {
+	layers := []string{game}
+	seen := map[string]bool{game: true}
+	for g := game; ; {
+		gm, ok := m.Games[g]
+		if !ok {
+			break
+		}
+		base := gm.Base
+		if base == "" && g != "baseq3" {
+			base = "baseq3"
+		}
+		if _, ok := m.Games[base]; !ok || seen[base] {
+			break
+		}
+		seen[base] = true
+		layers = append(layers, base)
+		g = base
+	}
+	return layers
+}
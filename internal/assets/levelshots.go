@@ -0,0 +1,255 @@
+package assets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ftrvxmtrx/tga"
+	"golang.org/x/image/draw"
+)
+
+// LevelshotSizes are the widths levelshots are exported at by default; the
+// height keeps the game's 4:3 aspect.
+var LevelshotSizes = []int{256, 512}
+
+// LevelshotExportOptions controls ExportLevelshots.
+type LevelshotExportOptions struct {
+	Sizes   []int  // widths to export (default LevelshotSizes)
+	Format  string // "jpg" (default) or "png"
+	Quality int    // JPEG quality (default 85)
+
+	// Minimap, if set, also renders each map's floors top-down (see
+	// RenderMinimap) to a PNG this many pixels across
+	Minimap int
+}
+
+// LevelshotIndex maps game → map → exported levelshot. It is written
+// alongside the images as levelshots.json.
+type LevelshotIndex map[string]map[string]*ExportedLevelshot
+
+// ExportedLevelshot is one map's levelshot at each exported size, and its
+// minimap. Maps without a levelshot may still have a minimap.
+type ExportedLevelshot struct {
+	Source string            `json:"source,omitempty"` // levelshot path in the game's pk3s
+	Pk3    string            `json:"pk3,omitempty"`    // name of the pk3 it was read from
+	Images map[string]string `json:"images,omitempty"` // width → image path, relative to the output directory
+
+	Minimap *ExportedMinimap `json:"minimap,omitempty"`
+}
+
+// ExportedMinimap is a map's rendered minimap and the world area it shows.
+type ExportedMinimap struct {
+	Image string     `json:"image"` // image path, relative to the output directory
+	Mins  [2]float64 `json:"mins"`  // world X and Y at the image's bottom-left corner
+	Maxs  [2]float64 `json:"maxs"`  // world X and Y at its top-right corner
+}
+
+// ExportLevelshots converts every levelshot in the manifest's file indexes to
+// web images at standard sizes, written under outputDir/levelshots/{game}/,
+// and saves the mapping to outputDir/levelshots/levelshots.json. A mod's
+// levelshots inherited unchanged from its base game point at the base
+// game's images rather than being converted again. Levelshots that fail to
+// decode are skipped with a warning, as are maps whose minimap fails to
+// render.
+func ExportLevelshots(manifest *Manifest, outputDir string, opts LevelshotExportOptions) (LevelshotIndex, error) {
+	if len(opts.Sizes) == 0 {
+		opts.Sizes = LevelshotSizes
+	}
+	switch opts.Format {
+	case "":
+		opts.Format = "jpg"
+	case "jpg", "png":
+	default:
+		return nil, fmt.Errorf("unsupported levelshot format %q", opts.Format)
+	}
+	if opts.Quality == 0 {
+		opts.Quality = 85
+	}
+	if opts.Minimap < 0 || opts.Minimap > maxMinimapWidth {
+		return nil, fmt.Errorf("minimap width %d out of range 0-%d", opts.Minimap, maxMinimapWidth)
+	}
+
+	root := filepath.Join(outputDir, "levelshots")
+	index := make(LevelshotIndex)
+	for _, game := range manifest.GameNames() {
+		gm := manifest.Games[game]
+		var base *GameManifest
+		if gm.Base != "" {
+			base = manifest.Games[gm.Base]
+		}
+		index[game] = make(map[string]*ExportedLevelshot)
+		for mapName, source := range levelshotPaths(gm.FileIndex) {
+			pk3 := gm.FileIndex[source]
+			if inherited, ok := index[gm.Base][mapName]; ok && base != nil && base.FileIndex[source] == pk3 {
+				index[game][mapName] = inherited
+				continue
+			}
+			shot, err := exportLevelshot(game, mapName, source, pk3, root, opts)
+			if err != nil {
+				log.Printf("Warning: levelshot %s in %s: %v", source, filepath.Base(pk3), err)
+				continue
+			}
+			index[game][mapName] = shot
+		}
+		if opts.Minimap > 0 {
+			exportMinimaps(game, gm, base, index, root, opts.Minimap)
+		}
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(root, "levelshots.json"), data, 0644); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// levelshotPaths returns map name → levelshot path for every levelshot in a
+// file index, preferring the first of textureExtensions as the game does.
+func levelshotPaths(fileIndex map[string]string) map[string]string {
+	shots := make(map[string]string)
+	for _, p := range sortedMapKeys(fileIndex) {
+		if !strings.HasPrefix(p, "levelshots/") || strings.Contains(p[len("levelshots/"):], "/") {
+			continue
+		}
+		if !isTextureFile(p) {
+			continue
+		}
+		mapName := strings.TrimSuffix(path.Base(p), path.Ext(p))
+		if existing, ok := shots[mapName]; !ok || textureRank(p) < textureRank(existing) {
+			shots[mapName] = p
+		}
+	}
+	return shots
+}
+
+// textureRank is an extension's place in the texture search order.
+func textureRank(p string) int {
+	for i, ext := range textureExtensions {
+		if strings.HasSuffix(p, ext) {
+			return i
+		}
+	}
+	return len(textureExtensions)
+}
+
+// exportLevelshot decodes a levelshot and writes it at each size.
+func exportLevelshot(game, mapName, source, pk3, root string, opts LevelshotExportOptions) (*ExportedLevelshot, error) {
+	data, err := ReadFileFromPk3(pk3, source)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeTexture(source, data)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	dir := filepath.Join(root, game)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	shot := &ExportedLevelshot{Source: source, Pk3: filepath.Base(pk3), Images: make(map[string]string, len(opts.Sizes))}
+	for _, width := range opts.Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, width, width*3/4))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+
+		name := fmt.Sprintf("%s_%d.%s", mapName, width, opts.Format)
+		if err := writeImage(filepath.Join(dir, name), dst, opts); err != nil {
+			return nil, err
+		}
+		shot.Images[strconv.Itoa(width)] = path.Join("levelshots", game, name)
+	}
+	return shot, nil
+}
+
+// exportMinimaps renders the minimap of each of a game's maps into its
+// index entry. A mod's maps inherited unchanged from its base game keep the
+// base game's minimap.
+func exportMinimaps(game string, gm, base *GameManifest, index LevelshotIndex, root string, width int) {
+	for _, mapName := range gm.MapNames() {
+		bsp := "maps/" + mapName + ".bsp"
+		pk3 := gm.FileIndex[bsp]
+		var minimap *ExportedMinimap
+		if inherited, ok := index[gm.Base][mapName]; ok && base != nil && base.FileIndex[bsp] == pk3 {
+			minimap = inherited.Minimap
+		}
+		if minimap == nil {
+			var err error
+			if minimap, err = exportMinimap(game, mapName, bsp, pk3, root, width); err != nil {
+				log.Printf("Warning: minimap of %s in %s: %v", mapName, filepath.Base(pk3), err)
+				continue
+			}
+		}
+		// Entries may be shared with the base game; don't change its own
+		shot := &ExportedLevelshot{}
+		if existing := index[game][mapName]; existing != nil {
+			*shot = *existing
+		}
+		shot.Minimap = minimap
+		index[game][mapName] = shot
+	}
+}
+
+// exportMinimap renders a map's minimap and writes it as a PNG.
+func exportMinimap(game, mapName, bsp, pk3, root string, width int) (*ExportedMinimap, error) {
+	data, err := ReadFileFromPk3(pk3, bsp)
+	if err != nil {
+		return nil, err
+	}
+	m, err := RenderMinimap(bytes.NewReader(data), int64(len(data)), width)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, game)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := mapName + "_minimap.png"
+	if err := writeImage(filepath.Join(dir, name), m.Image, LevelshotExportOptions{Format: "png"}); err != nil {
+		return nil, err
+	}
+	return &ExportedMinimap{Image: path.Join("levelshots", game, name), Mins: m.Mins, Maxs: m.Maxs}, nil
+}
+
+// decodeTexture decodes a TGA, JPEG, or PNG texture by its extension.
+func decodeTexture(name string, data []byte) (image.Image, error) {
+	switch path.Ext(name) {
+	case ".tga":
+		return tga.Decode(bytes.NewReader(data))
+	case ".jpg":
+		return jpeg.Decode(bytes.NewReader(data))
+	case ".png":
+		return png.Decode(bytes.NewReader(data))
+	}
+	return nil, fmt.Errorf("unsupported image %s", name)
+}
+
+// writeImage encodes img to path in the export format.
+func writeImage(path string, img image.Image, opts LevelshotExportOptions) error {
+	var buf bytes.Buffer
+	var err error
+	if opts.Format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.Quality})
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,53 @@
+package assets
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// Output directories are locked twice over: a mutex per directory for
+// builds within one process (the asset service's worker and its handlers),
+// and an advisory lock on a file beside the directory for builds in
+// different processes (watch, the service, a demobake run by hand). The
+// lock file sits beside the directory, not in it, so an atomic build's
+// swap doesn't replace it.
+var (
+	outputMutexesMu sync.Mutex
+	outputMutexes   = make(map[string]*sync.Mutex)
+)
+
+// LockOutput takes the exclusive lock on an output directory, waiting for
+// any build holding it, and returns the function that releases it. Every
+// writer of an output directory (BuildBaseline, Sync, CollectGarbage, and
+// anything building map pk3s into it or saving its manifest) holds it, so
+// none sees another's half-written output.
+func LockOutput(outputDir string) (unlock func(), err error) {
+	abs, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %w", outputDir, err)
+	}
+	outputMutexesMu.Lock()
+	mu, ok := outputMutexes[abs]
+	if !ok {
+		mu = &sync.Mutex{}
+		outputMutexes[abs] = mu
+	}
+	outputMutexesMu.Unlock()
+
+	mu.Lock()
+	release, err := lockFile(outputLockPath(abs))
+	if err != nil {
+		mu.Unlock()
+		return nil, fmt.Errorf("lock %s: %w", outputDir, err)
+	}
+	return func() {
+		release()
+		mu.Unlock()
+	}, nil
+}
+
+// outputLockPath returns the lock file of an absolute output directory.
+func outputLockPath(abs string) string {
+	return filepath.Join(filepath.Dir(abs), "."+filepath.Base(abs)+".lock")
+}
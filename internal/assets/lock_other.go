@@ -0,0 +1,9 @@
+//go:build !unix
+
+package assets
+
+// lockFile is a no-op where flock isn't available; LockOutput's mutex
+// still keeps builds within one process apart.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,62 @@
+package assets
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockOutput(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "demopk3s")
+	unlock, err := LockOutput(out)
+	if err != nil {
+		t.Fatalf("LockOutput: %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		unlock, err := LockOutput(out)
+		if err != nil {
+			t.Errorf("second LockOutput: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- unlock
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second lock taken while the first was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case unlock2 := <-acquired:
+		if unlock2 != nil {
+			unlock2()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second lock not taken after the first was released")
+	}
+
+	// BuildBaseline takes the same lock, so it can't run under a held one
+	unlock, err = LockOutput(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	q := makeQuake3Fixture(t)
+	go func() {
+		_, err := BuildBaseline(q, out, BuildOptions{})
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("BuildBaseline ran while the output was locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package assets
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on path, creating it if need be, and
+// returns the function that releases it. The lock goes with the process,
+// so a crashed build never leaves a stale one.
+func lockFile(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(f.Fd())
+	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		log.Printf("Waiting for another build to release %s", path)
+		err = syscall.Flock(fd, syscall.LOCK_EX)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(fd, syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
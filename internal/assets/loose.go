@@ -0,0 +1,112 @@
+package assets
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LooseSource returns the source path standing for the loose files under a
+// game directory. It is the directory with a trailing separator, and can be
+// passed anywhere a pk3 path is accepted.
+func LooseSource(gameDir string) string {
+	return filepath.Clean(gameDir) + string(filepath.Separator)
+}
+
+// isLooseSource reports whether a source path is a LooseSource.
+func isLooseSource(path string) bool {
+	return strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator))
+}
+
+// AddLooseSources appends each game directory's loose files to its sources,
+// after its pk3s: the engine searches a game directory before the pk3s in it,
+// so loose files win. Game directories holding only loose files are added
+// too. Changes to loose files aren't detected by Watch until a rebuild.
+func AddLooseSources(quake3Dir string, gamePk3s map[string][]string) {
+	subdirs := append([]string(nil), baseGames...)
+	if entries, err := os.ReadDir(quake3Dir); err == nil {
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") && !IsBaseGame(e.Name()) {
+				subdirs = append(subdirs, e.Name())
+			}
+		}
+	}
+	for _, game := range subdirs {
+		dir := filepath.Join(quake3Dir, game)
+		if len(looseFiles(dir)) > 0 {
+			gamePk3s[game] = append(gamePk3s[game], LooseSource(dir))
+		}
+	}
+}
+
+// collectGameSources returns CollectGamePk3s, plus loose files if opts asks for them.
+func collectGameSources(quake3Dir string, opts BuildOptions) map[string][]string {
+	gamePk3s, _ := collectRootSources(quake3Dir, opts)
+	return gamePk3s
+}
+
+// collectRootSources is collectGameSources that also returns the pk3s
+// shadowed by a higher-priority root's copy when opts.Roots is set.
+func collectRootSources(quake3Dir string, opts BuildOptions) (map[string][]string, map[string]string) {
+	if len(opts.Roots) == 0 {
+		gamePk3s := CollectGamePk3s(quake3Dir)
+		if opts.LooseFiles {
+			AddLooseSources(quake3Dir, gamePk3s)
+		}
+		return gamePk3s, nil
+	}
+	roots := buildRoots(quake3Dir, opts)
+	gamePk3s, shadowed := CollectGamePk3sFromRoots(roots)
+	if opts.LooseFiles {
+		// Later sources win, so the highest-priority root's loose files go last
+		for i := len(roots) - 1; i >= 0; i-- {
+			AddLooseSources(roots[i], gamePk3s)
+		}
+	}
+	return gamePk3s, shadowed
+}
+
+// openLooseSource opens a LooseSource as a pk3.
+func openLooseSource(source string) (*pk3Archive, error) {
+	dir := filepath.Clean(source)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return openZipView(nil, nil, looseFiles(dir))
+}
+
+// looseFiles lists the asset files under a game directory. Files directly in
+// it (configs, logs), hidden files, and pk3/pak archives are skipped.
+func looseFiles(dir string) []zipViewEntry {
+	var entries []zipViewEntry
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || filepath.Dir(path) == dir {
+			return nil
+		}
+		lower := strings.ToLower(d.Name())
+		if strings.HasSuffix(lower, ".pk3") || isPakFile(lower) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, zipViewEntry{name: filepath.ToSlash(rel), path: path, length: info.Size()})
+		return nil
+	})
+	return entries
+}
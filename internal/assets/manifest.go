@@ -1,15 +1,39 @@
 package assets
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
 // Manifest caches file index, baseline file set, and shader definitions
 // to avoid re-scanning pk3s for map and demo pk3 builders.
 type Manifest struct {
-	Games map[string]*GameManifest `json:"games"`
+	Games     map[string]*GameManifest `json:"games"`
+	Artifacts map[string]Artifact      `json:"artifacts,omitempty"` // output-relative path → generated pk3
+	// MissingMaps are maps demos were recorded on that the install doesn't
+	// have, keyed by "<game>/<map>" (see EnsureDemoMapPak)
+	MissingMaps map[string]*MissingMap `json:"missingMaps,omitempty"`
+	// Roots are the installs merged into this one, highest priority first,
+	// when built from more than one (see BuildOptions.Roots and Root)
+	Roots []string `json:"roots,omitempty"`
+	// Build is what the artifacts were built with, so a sync can rebuild
+	// them identically from a local install
+	Build *BuildSettings `json:"build,omitempty"`
+}
+
+// Artifact describes a generated pk3 so clients can verify and sync it.
+type Artifact struct {
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	Restricted bool   `json:"restricted,omitempty"` // contains files from official id paks; not redistributable
+	Checksum   int32  `json:"checksum,omitempty"`   // engine pak checksum, for sv_pure (see PakChecksum)
+
+	Provenance *Provenance `json:"provenance,omitempty"` // why a pk3 built outside a full build exists
 }
 
 // GameManifest holds per-game manifest data.
@@ -18,6 +42,69 @@ type GameManifest struct {
 	BaselineFiles map[string]bool     `json:"baselineFiles"` // paths in baseline + trinity pk3s
 	Shaders       map[string][]string `json:"shaders"`       // shader name → texture deps
 	ShaderFiles   map[string]string   `json:"shaderFiles"`   // shader name → source .shader script path
+	Quarantined   []QuarantinedPk3    `json:"quarantined,omitempty"`
+	Workshop      map[string]string   `json:"workshop,omitempty"`      // pk3 path → Quake Live workshop item ID
+	OfficialFiles map[string]bool     `json:"officialFiles,omitempty"` // paths whose winning copy is in an official id pak
+	Substituted   map[string]string   `json:"substituted,omitempty"`   // baseline path → substitute source pk3
+	ShaderRefs    map[string][]string `json:"shaderRefs,omitempty"`    // shader name → maps and models referencing it
+	ShaderLinks   map[string][]string `json:"shaderLinks,omitempty"`   // shader name → shaders its q3map_backShader and q3map_cloneShader pull in
+	LightImages   map[string][]string `json:"lightImages,omitempty"`   // shader name → its q3map_lightImage images, for diagnostics only
+	OriginalNames map[string]string   `json:"originalNames,omitempty"` // lowered path → entry name as cased in its pk3, where not lowercase
+	Base          string              `json:"base,omitempty"`          // game merged underneath this one
+	Videos        map[string]*RoQInfo `json:"videos,omitempty"`        // RoQ video path → header info
+	Maps          map[string]*MapInfo `json:"maps,omitempty"`          // map name → title and author
+	Shadowed      map[string]string   `json:"shadowed,omitempty"`      // pk3 passed over → same-named pk3 of a higher-priority root
+
+	// TextureOverrides forces texture references to a given file ahead of
+	// the extension search (see GameManifest.ResolveTexture): override key
+	// (see TextureOverrideKey) → file
+	TextureOverrides map[string]string `json:"textureOverrides,omitempty"`
+
+	// Textures is the texture search the game was built with; nil is the
+	// stock engine's
+	Textures *TextureSearch `json:"textures,omitempty"`
+}
+
+// QuarantinedPk3 records a pk3 that was skipped because it couldn't be read.
+type QuarantinedPk3 struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// GameNames returns the manifest's games in build order (baseq3, missionpack, then mods).
+func (m *Manifest) GameNames() []string {
+	names := make([]string, 0, len(m.Games))
+	for game := range m.Games {
+		names = append(names, game)
+	}
+	return orderGames(names)
+}
+
+// GameFor returns the game manifest to resolve assets against for a demo's
+// fs_game. Mods are stored merged over their base games, so the result
+// already layers the mod's files on top of them. Unknown or empty fs_game falls
+// back to baseq3.
+func (m *Manifest) GameFor(fsGame string) (string, *GameManifest, bool) {
+	if fsGame != "" {
+		for game, gm := range m.Games {
+			if strings.EqualFold(game, fsGame) {
+				return game, gm, true
+			}
+		}
+	}
+	gm, ok := m.Games["baseq3"]
+	return "baseq3", gm, ok
+}
+
+// Quarantined returns game → pk3s skipped as unreadable during the build.
+func (m *Manifest) Quarantined() map[string][]QuarantinedPk3 {
+	result := make(map[string][]QuarantinedPk3)
+	for game, gm := range m.Games {
+		if len(gm.Quarantined) > 0 {
+			result[game] = gm.Quarantined
+		}
+	}
+	return result
 }
 
 // LoadManifest loads a manifest from a JSON file.
@@ -34,14 +121,98 @@ func LoadManifest(path string) (*Manifest, error) {
 	return &m, nil
 }
 
-// Save writes the manifest to a JSON file.
+// Save writes the manifest to a JSON file. It writes a temp file and renames
+// it into place, so a reader never sees a partly written manifest.
 func (m *Manifest) Save(path string) error {
 	data, err := json.Marshal(m)
 	if err != nil {
 		return fmt.Errorf("marshal manifest: %w", err)
 	}
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
 		return fmt.Errorf("write manifest: %w", err)
 	}
 	return nil
 }
+
+// addArtifact hashes a generated file and records it under its output-relative path.
+func (m *Manifest) addArtifact(relPath, fullPath string, restricted bool) error {
+	sum, size, err := hashFile(fullPath)
+	if err != nil {
+		return err
+	}
+	if m.Artifacts == nil {
+		m.Artifacts = make(map[string]Artifact)
+	}
+	checksum, err := PakChecksum(fullPath)
+	if err != nil {
+		return err
+	}
+	m.Artifacts[relPath] = Artifact{Size: size, SHA256: sum, Restricted: restricted, Checksum: checksum}
+	if mapFile, ok := strings.CutPrefix(relPath, "maps/"); ok {
+		mapName := strings.TrimSuffix(mapFile, ".pk3")
+		for key, missing := range m.MissingMaps {
+			if missing.Map == mapName {
+				delete(m.MissingMaps, key)
+			}
+		}
+	}
+	return nil
+}
+
+// IsRestricted reports whether an artifact contains official id content and so
+// must not be served in distribution mode. Unknown artifacts are treated as restricted.
+func (m *Manifest) IsRestricted(relPath string) bool {
+	a, ok := m.Artifacts[relPath]
+	return !ok || a.Restricted
+}
+
+// OriginalName returns a lowered path's entry name as cased in the pk3 that
+// supplies it.
+func (gm *GameManifest) OriginalName(path string) string {
+	if name, ok := gm.OriginalNames[path]; ok {
+		return name
+	}
+	return path
+}
+
+// officialFileSet returns the indexed paths whose winning source is an official pak.
+func officialFileSet(fileIndex map[string]string) map[string]bool {
+	official := make(map[string]bool)
+	for path, pk3Path := range fileIndex {
+		if IsOfficialPak(pk3Path) {
+			official[path] = true
+		}
+	}
+	return official
+}
+
+// containsOfficial reports whether any path in files is marked official.
+func (gm *GameManifest) containsOfficial(files map[string]bool) bool {
+	for path := range files {
+		if gm.OfficialFiles[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFile returns the hex SHA-256 and size of a file.
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,135 @@
+package assets
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/ftrvxmtrx/tga"
+	"golang.org/x/image/draw"
+)
+
+const (
+	budgetMinTextureSize = 16 // smallest side a texture is halved to
+	budgetJPEGQuality    = 90
+)
+
+// budgetTextures picks the textures of a map pk3 written to pk3Path to halve
+// so it sheds over bytes: the least seen first (see MapTextureImportance),
+// each estimated to save three quarters of its stored size. It returns the
+// downscaled images by path. Each texture is halved at most once, so a map
+// whose textures are all halved may still be over budget.
+func budgetTextures(mapName string, gm *GameManifest, pk3Path string, over int64) (map[string][]byte, error) {
+	importance, err := MapTextureImportance(mapName, gm)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.OpenReader(pk3Path)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]int64, len(r.File))
+	for _, f := range r.File {
+		stored[f.Name] = int64(f.CompressedSize64)
+	}
+	r.Close()
+
+	shrunk := make(map[string][]byte)
+	var saved int64
+	for _, t := range importance {
+		if saved >= over {
+			break
+		}
+		size, ok := stored[t.Texture]
+		if !ok {
+			continue
+		}
+		data, err := readFileFromIndex(t.Texture, gm.FileIndex)
+		if err != nil {
+			return nil, err
+		}
+		small, err := downscaleTexture(t.Texture, data)
+		if err != nil {
+			log.Printf("Warning: %s: can't downscale %s: %v", mapName, t.Texture, err)
+			continue
+		}
+		if small == nil {
+			continue
+		}
+		shrunk[t.Texture] = small
+		saved += size * 3 / 4
+	}
+	return shrunk, nil
+}
+
+// downscaleTexture halves a TGA, JPEG, or PNG texture in each dimension and
+// re-encodes it in its own format. It returns nil for a texture already
+// near budgetMinTextureSize.
+func downscaleTexture(name string, data []byte) ([]byte, error) {
+	img, err := decodeTexture(name, data)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Dx()/2 < budgetMinTextureSize || b.Dy()/2 < budgetMinTextureSize {
+		return nil, nil
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx()/2, b.Dy()/2))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	switch path.Ext(name) {
+	case ".tga":
+		err = tga.Encode(&buf, dst)
+	case ".jpg":
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: budgetJPEGQuality})
+	case ".png":
+		err = png.Encode(&buf, dst)
+	default:
+		err = fmt.Errorf("unsupported image %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// withoutShrunk returns paths less the ones budgetTextures downscaled.
+func withoutShrunk(paths []string, shrunk map[string][]byte) []string {
+	var kept []string
+	for _, p := range paths {
+		if _, ok := shrunk[strings.ToLower(p)]; !ok {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// writeShrunkTextures adds the textures budgetTextures downscaled to a map
+// pk3 being written, in place of the originals.
+func writeShrunkTextures(pw *Pk3Writer, shrunk map[string][]byte, gm *GameManifest) ([]writtenFile, error) {
+	var written []writtenFile
+	for _, p := range sortedMapKeys(shrunk) {
+		data := shrunk[p]
+		if err := pw.AddEntry(p, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		written = append(written, writtenFile{
+			Path:       p,
+			Source:     gm.FileIndex[p],
+			Size:       int64(len(data)),
+			SHA256:     hex.EncodeToString(sum[:]),
+			Downscaled: true,
+		})
+	}
+	return written, nil
+}
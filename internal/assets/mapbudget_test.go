@@ -0,0 +1,138 @@
+package assets
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ftrvxmtrx/tga"
+)
+
+// noiseImage encodes a size x size image of random pixels, which barely
+// compresses.
+func noiseImage(t *testing.T, size int, encode func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(int64(size)))
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			img.Set(x, y, color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildMapPakBudget(t *testing.T) {
+	bsp := makeSurfaceBSP(
+		[]string{"textures/mod/floor", "textures/mod/trim"},
+		[]fixtureSurface{
+			{shader: 0, kind: bspSurfacePlanar, verts: [][3]float32{{0, 0, 0}, {512, 0, 0}, {512, 512, 0}, {0, 512, 0}}, indexes: []int32{0, 1, 2, 0, 2, 3}},
+			{shader: 1, kind: bspSurfacePlanar, verts: [][3]float32{{0, 0, 0}, {16, 0, 0}, {16, 16, 0}, {0, 16, 0}}, indexes: []int32{0, 1, 2, 0, 2, 3}},
+		},
+	)
+	q := t.TempDir()
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "pak0.pk3"), map[string][]byte{
+		"gfx/2d/crosshaira.tga": fixtureImage("crosshaira"),
+	})
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "map-budget.pk3"), map[string][]byte{
+		"maps/budget.bsp": bsp,
+		"textures/mod/floor.tga": noiseImage(t, 128, func(w *bytes.Buffer, img image.Image) error {
+			return tga.Encode(w, img)
+		}),
+		"textures/mod/trim.png": noiseImage(t, 64, func(w *bytes.Buffer, img image.Image) error {
+			return png.Encode(w, img)
+		}),
+	})
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(maxSize int64) (string, Diagnostics) {
+		t.Helper()
+		pk3 := filepath.Join(t.TempDir(), "budget.pk3")
+		diags, err := BuildMapPak("budget", "baseq3", manifest, q, pk3, MapPakOptions{MaxSize: maxSize})
+		if err != nil {
+			t.Fatalf("BuildMapPak: %v", err)
+		}
+		return pk3, diags
+	}
+	// sizes returns each downscaled file's image width, or 0 for a file
+	// as it was
+	sizes := func(pk3 string) map[string]int {
+		t.Helper()
+		m, err := ReadMapPakManifest(pk3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		widths := make(map[string]int)
+		for _, f := range m.Files {
+			if !f.Downscaled {
+				widths[f.Path] = 0
+				continue
+			}
+			data, err := ReadFileFromPk3(pk3, f.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := decodeTexture(f.Path, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			widths[f.Path] = img.Bounds().Dx()
+		}
+		return widths
+	}
+
+	full, diags := build(0)
+	if diags.ByKind()[DiagOverBudget] != 0 {
+		t.Errorf("unbudgeted build diagnosed %v", diags)
+	}
+	r, err := zip.OpenReader(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trimSize int64
+	for _, f := range r.File {
+		if f.Name == "textures/mod/trim.png" {
+			trimSize = int64(f.CompressedSize64)
+		}
+	}
+	r.Close()
+	info, err := os.Stat(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Just over budget, only the least seen texture is halved
+	pk3, diags := build(info.Size() - trimSize/2)
+	if got := sizes(pk3); got["textures/mod/trim.png"] != 32 || got["textures/mod/floor.tga"] != 0 {
+		t.Errorf("downscaled = %v, want just the trim", got)
+	}
+	if n := diags.ByKind()[DiagOverBudget]; n != 0 {
+		t.Errorf("%d over-budget diagnostics after fitting", n)
+	}
+
+	// An unreachable budget halves every texture, and still warns
+	pk3, diags = build(1)
+	if got := sizes(pk3); got["textures/mod/trim.png"] != 32 || got["textures/mod/floor.tga"] != 64 {
+		t.Errorf("downscaled = %v, want every texture", got)
+	}
+	if n := diags.ByKind()[DiagOverBudget]; n != 1 {
+		t.Errorf("%d over-budget diagnostics, want 1", n)
+	}
+}
@@ -0,0 +1,267 @@
+package assets
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MapInfo is a map's human-readable metadata, for map listings.
+type MapInfo struct {
+	Title   string `json:"title,omitempty"`   // .arena longname, else from the worldspawn message
+	Message string `json:"message,omitempty"` // worldspawn message, shown while the map loads
+	Author  string `json:"author,omitempty"`
+	Readme  string `json:"readme,omitempty"` // text file shipped with the map that Title or Author came from
+
+	Gametypes []string `json:"gametypes,omitempty"` // inferred from the map's entities (see inferGametypes)
+
+	Stats *MapStats `json:"stats,omitempty"` // BSP complexity, if its tree could be read
+}
+
+// mapInfoCache holds what indexMaps parsed, by source pk3 and path, so games
+// sharing files read them once.
+type mapInfoCache struct {
+	bsps    map[string]*mapEntities
+	readmes map[string]*MapInfo
+}
+
+// mapEntities is what indexMaps keeps of a BSP's entities.
+type mapEntities struct {
+	worldspawn map[string]string
+	gametypes  []string
+	stats      *MapStats
+}
+
+func newMapInfoCache() *mapInfoCache {
+	return &mapInfoCache{bsps: make(map[string]*mapEntities), readmes: make(map[string]*MapInfo)}
+}
+
+// indexMaps records the metadata of every map in the game, replacing
+// gm.Maps. Titles come from scripts/arenas.txt and *.arena longnames, the
+// first definition winning as in the game; messages and authors from the
+// BSP's worldspawn; and anything still missing from a <map>.txt readme in
+// the same pk3 as the BSP. Gametypes are inferred from the BSP's entities.
+// cache may be nil.
+func (gm *GameManifest) indexMaps(cache *mapInfoCache) {
+	if cache == nil {
+		cache = newMapInfoCache()
+	}
+	names := gm.MapNames()
+	if len(names) == 0 {
+		gm.Maps = nil
+		return
+	}
+	bsps := make([]string, len(names))
+	for i, name := range names {
+		bsps[i] = "maps/" + name + ".bsp"
+	}
+
+	byPk3 := make(map[string]map[string]bool)
+	for _, p := range bsps {
+		pk3 := gm.FileIndex[p]
+		if _, ok := cache.bsps[pk3+"\x00"+p]; ok {
+			continue
+		}
+		if byPk3[pk3] == nil {
+			byPk3[pk3] = make(map[string]bool)
+		}
+		byPk3[pk3][p] = true
+	}
+	for _, pk3 := range sortedMapKeys(byPk3) {
+		wanted := byPk3[pk3]
+		err := IteratePk3(pk3, func(name string, open func() (io.ReadCloser, error)) error {
+			lower := strings.ToLower(name)
+			if !wanted[lower] {
+				return nil
+			}
+			delete(wanted, lower)
+			cache.bsps[pk3+"\x00"+lower] = readMapEntities(open)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Warning: failed to read map metadata from %s: %v", filepath.Base(pk3), err)
+		}
+	}
+
+	titles := gm.arenaTitles()
+	gm.Maps = make(map[string]*MapInfo, len(bsps))
+	for _, p := range bsps {
+		mapName := strings.TrimSuffix(path.Base(p), ".bsp")
+		pk3 := gm.FileIndex[p]
+		ents := cache.bsps[pk3+"\x00"+p]
+		if ents == nil {
+			ents = &mapEntities{}
+		}
+
+		info := &MapInfo{
+			Title:     titles[mapName],
+			Message:   cleanMapText(ents.worldspawn["message"]),
+			Author:    cleanMapText(ents.worldspawn["author"]),
+			Gametypes: ents.gametypes,
+			Stats:     ents.stats,
+		}
+		if info.Title == "" || info.Author == "" {
+			if readme := gm.mapReadme(mapName, pk3, cache); readme != nil {
+				if info.Title == "" && readme.Title != "" {
+					info.Title, info.Readme = readme.Title, readme.Readme
+				}
+				if info.Author == "" && readme.Author != "" {
+					info.Author, info.Readme = readme.Author, readme.Readme
+				}
+			}
+		}
+		title, author := splitMapMessage(info.Message)
+		if info.Title == "" {
+			info.Title = title
+		}
+		if info.Author == "" {
+			info.Author = author
+		}
+		if info.Title != "" || info.Message != "" || info.Author != "" || len(info.Gametypes) > 0 || info.Stats != nil {
+			gm.Maps[mapName] = info
+		}
+	}
+}
+
+// MapNames returns the names of the game's maps, sorted.
+func (gm *GameManifest) MapNames() []string {
+	var names []string
+	for p := range gm.FileIndex {
+		if strings.HasPrefix(p, "maps/") && strings.HasSuffix(p, ".bsp") && !strings.Contains(p[len("maps/"):], "/") {
+			names = append(names, strings.TrimSuffix(p[len("maps/"):], ".bsp"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readMapEntities returns a BSP's worldspawn keys, supported gametypes, and
+// complexity, or nil if it can't be read.
+func readMapEntities(open func() (io.ReadCloser, error)) *mapEntities {
+	rc, err := open()
+	if err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil
+	}
+	bsp, err := ParseBSP(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil
+	}
+	ents := &mapEntities{worldspawn: bsp.Worldspawn, gametypes: inferGametypes(bsp.Classnames)}
+	ents.stats, _ = ParseBSPStats(bytes.NewReader(data), int64(len(data)))
+	return ents
+}
+
+// arenaTitles returns map name → longname from the game's arena scripts.
+func (gm *GameManifest) arenaTitles() map[string]string {
+	scripts := []string{"scripts/arenas.txt"}
+	var extra []string
+	for p := range gm.FileIndex {
+		if strings.HasPrefix(p, "scripts/") && strings.HasSuffix(p, ".arena") {
+			extra = append(extra, p)
+		}
+	}
+	sort.Strings(extra)
+
+	titles := make(map[string]string)
+	for _, script := range append(scripts, extra...) {
+		data, err := readFileFromIndex(script, gm.FileIndex)
+		if err != nil {
+			continue
+		}
+		infos, _ := ParseInfos(bytes.NewReader(data))
+		for _, info := range infos {
+			mapName := strings.ToLower(info["map"])
+			longname := cleanMapText(info["longname"])
+			if mapName == "" || longname == "" || titles[mapName] != "" {
+				continue
+			}
+			titles[mapName] = longname
+		}
+	}
+	return titles
+}
+
+// mapReadme parses the first of <map>.txt and maps/<map>.txt shipped in the
+// map's own pk3, or returns nil.
+func (gm *GameManifest) mapReadme(mapName, pk3 string, cache *mapInfoCache) *MapInfo {
+	for _, p := range []string{mapName + ".txt", "maps/" + mapName + ".txt"} {
+		if gm.FileIndex[p] != pk3 {
+			continue
+		}
+		key := pk3 + "\x00" + p
+		if info, ok := cache.readmes[key]; ok {
+			return info
+		}
+		data, err := ReadFileFromPk3(pk3, p)
+		if err != nil {
+			continue
+		}
+		info := parseMapReadme(data)
+		info.Readme = p
+		cache.readmes[key] = info
+		return info
+	}
+	return nil
+}
+
+// Readme field names for a map's title and author, as map authors write them
+var (
+	readmeTitleKeys  = []string{"title", "map title", "map name", "level name", "name"}
+	readmeAuthorKeys = []string{"author", "authors", "map author", "created by", "designer"}
+)
+
+// maxReadmeLines bounds how much of a readme is searched; the header block
+// is all that names the map.
+const maxReadmeLines = 100
+
+// parseMapReadme reads "Title: ..." and "Author: ..." style lines from a
+// map's readme.
+func parseMapReadme(data []byte) *MapInfo {
+	info := &MapInfo{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 0; scanner.Scan() && n < maxReadmeLines; n++ {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.Trim(strings.TrimSpace(key), ".-=* \t"))
+		value = cleanMapText(value)
+		if value == "" {
+			continue
+		}
+		switch {
+		case info.Title == "" && containsString(readmeTitleKeys, key):
+			info.Title = value
+		case info.Author == "" && containsString(readmeAuthorKeys, key):
+			info.Author = value
+		}
+	}
+	return info
+}
+
+// splitMapMessage splits a worldspawn message in the common "Title by
+// Author" form; other messages are all title.
+func splitMapMessage(message string) (string, string) {
+	i := strings.LastIndex(strings.ToLower(message), " by ")
+	if i < 0 {
+		return message, ""
+	}
+	return strings.TrimSpace(message[:i]), strings.TrimSpace(message[i+len(" by "):])
+}
+
+// cleanMapText strips color codes, escaped newlines, and surrounding space
+// from map-supplied text.
+func cleanMapText(s string) string {
+	s = strings.ReplaceAll(s, "\\n", " ")
+	return strings.Join(strings.Fields(stripColorCodes(s)), " ")
+}
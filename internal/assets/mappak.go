@@ -5,161 +5,432 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"path"
 	"strings"
+	"time"
 )
 
-// BuildMapPak builds a per-map pk3 containing all map-specific assets not in the baseline.
-func BuildMapPak(mapName, game string, manifest *Manifest, quake3Dir, outputPath string) error {
+// MapPakOptions adjusts how BuildMapPak builds a map pk3.
+type MapPakOptions struct {
+	// Placeholders, if set, adds a generated placeholder image for each
+	// texture or shader the map references that isn't in any pk3, so the
+	// broken surfaces show what's missing instead of the engine's plain
+	// default image.
+	Placeholders bool `json:"placeholders,omitempty"`
+
+	Music        string `json:"music,omitempty"`        // music policy: MusicKeep (or empty), MusicExclude, or MusicOgg
+	MusicBitrate int    `json:"musicBitrate,omitempty"` // kbit/s for MusicOgg; 0 = 96
+
+	Compression string `json:"compression,omitempty"` // Pk3 compression level (see ParseCompression)
+	MaxSize     int64  `json:"maxSize,omitempty"`     // size budget in bytes; over it, the least seen textures are halved, and a pk3 still larger is written with a warning. 0 = none
+}
+
+// BuildMapPak builds a per-map pk3 containing all map-specific assets not in
+// the baseline, along with a trinity_manifest.json listing each file's hash,
+// source pk3, and why it was included. It returns the references that
+// couldn't be resolved; the pk3 is written without them, or with placeholders
+// for missing images if opts asks for them.
+func BuildMapPak(mapName, game string, manifest *Manifest, quake3Dir, outputPath string, opts MapPakOptions) (Diagnostics, error) {
+	start := time.Now()
+	diags, err := buildMapPak(mapName, game, manifest, quake3Dir, outputPath, opts)
+	mapPaksBuilt.With(resultLabel(err)).Inc()
+	mapPakBuildSeconds.ObserveSince(start)
+	return diags, err
+}
+
+func buildMapPak(mapName, game string, manifest *Manifest, quake3Dir, outputPath string, opts MapPakOptions) (Diagnostics, error) {
+	if err := checkMusicEncoder(opts); err != nil {
+		return nil, err
+	}
+	gm, deps, paths, err := mapPakFiles(mapName, game, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return deps.diags, nil
+	}
+	paths, music := splitMusic(paths, deps, opts)
+	var excluded []string
+	if opts.Music == MusicExclude {
+		excluded = music
+	}
+
+	// Stream from the source pk3s; maps with music can run to 100+ MB
+	write := func(paths []string, shrunk map[string][]byte) (int, error) {
+		return writePk3FromIndex(outputPath, paths, gm.FileIndex, opts.Compression, func(pw *Pk3Writer, files []writtenFile) error {
+			if len(shrunk) > 0 {
+				downscaled, err := writeShrunkTextures(pw, shrunk, gm)
+				if err != nil {
+					return err
+				}
+				files = append(files, downscaled...)
+			}
+			if opts.Placeholders {
+				placeholders, err := writePlaceholders(pw, deps, gm)
+				if err != nil {
+					return err
+				}
+				if len(placeholders) > 0 {
+					log.Printf("  %s: %d placeholder textures", mapName, len(placeholders))
+				}
+				files = append(files, placeholders...)
+			}
+			if opts.Music == MusicOgg {
+				encoded, err := writeEncodedMusic(pw, music, gm, opts)
+				if err != nil {
+					return err
+				}
+				files = append(files, encoded...)
+			}
+			return writeMapPakManifest(pw, mapName, game, quake3Dir, files, excluded, deps)
+		})
+	}
+	count, err := write(paths, nil)
+	if err != nil {
+		return deps.diags, fmt.Errorf("write map pk3: %w", err)
+	}
+
+	// Over budget, rewrite it with the least seen textures halved
+	if opts.MaxSize > 0 {
+		if info, err := os.Stat(outputPath); err == nil && info.Size() > opts.MaxSize {
+			shrunk, err := budgetTextures(mapName, gm, outputPath, info.Size()-opts.MaxSize)
+			if err != nil {
+				log.Printf("Warning: %s: can't rank textures to fit the size budget: %v", mapName, err)
+			} else if len(shrunk) > 0 {
+				if count, err = write(withoutShrunk(paths, shrunk), shrunk); err != nil {
+					return deps.diags, fmt.Errorf("write map pk3: %w", err)
+				}
+				log.Printf("  %s: halved %d textures to fit the size budget", mapName, len(shrunk))
+			}
+		}
+	}
+
+	log.Printf("  %s: %d files", mapName, count)
+	if opts.MaxSize > 0 {
+		if info, err := os.Stat(outputPath); err == nil && info.Size() > opts.MaxSize {
+			deps.diagnose(Diagnostic{
+				Severity: SeverityWarning,
+				Kind:     DiagOverBudget,
+				Subject:  mapName,
+				Map:      mapName,
+				Detail:   fmt.Sprintf("%s, budget %s", formatSize(info.Size()), formatSize(opts.MaxSize)),
+			})
+		}
+	}
+	return deps.diags, nil
+}
+
+// PlanMapPak is BuildMapPak's dry run: it returns the pk3 BuildMapPak would
+// write to outputPath, less its trinity_manifest.json, without writing
+// anything. The pk3 is nil if the map needs nothing outside the baseline.
+// Placeholders and textures halved to fit a size budget aren't planned, and
+// music to be re-encoded is planned at its original size.
+func PlanMapPak(mapName, game string, manifest *Manifest, outputPath string, opts MapPakOptions) (*PlannedPk3, Diagnostics, error) {
+	gm, deps, paths, err := mapPakFiles(mapName, game, manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(paths) == 0 {
+		return nil, deps.diags, nil
+	}
+	if opts.Music == MusicExclude {
+		paths, _ = splitMusic(paths, deps, opts)
+	}
+	pk3, err := planPk3(outputPath, paths, gm.FileIndex)
+	if err != nil {
+		return nil, deps.diags, fmt.Errorf("plan map pk3: %w", err)
+	}
+	return pk3, deps.diags, nil
+}
+
+// mapPakFiles resolves the files a map pk3 holds, everything the map needs
+// outside the baseline, with the map's diagnostics.
+func mapPakFiles(mapName, game string, manifest *Manifest) (*GameManifest, *depSet, []string, error) {
 	gm, ok := manifest.Games[game]
 	if !ok {
-		return fmt.Errorf("game %q not found in manifest", game)
+		return nil, nil, nil, fmt.Errorf("game %q not found in manifest", game)
+	}
+
+	deps, bspAssets, err := resolveMapFiles(mapName, gm)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i := range deps.diags {
+		deps.diags[i].Map = mapName
+	}
+
+	log.Printf("  %s: BSP has %d shaders, %d models, %d sounds, %d music",
+		mapName, len(bspAssets.Shaders), len(bspAssets.Models), len(bspAssets.Sounds), len(bspAssets.Music))
+	if len(deps.diags) > 0 {
+		log.Printf("  %s: %s", mapName, deps.diags.Summary())
 	}
 
-	needed := make(map[string]bool)
+	// 11. Exclude baseline files
+	var paths []string
+	for path := range deps.files {
+		if !gm.BaselineFiles[path] {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		log.Printf("  %s: no non-baseline files needed", mapName)
+	}
+	return gm, deps, paths, nil
+}
+
+// ResolveMapAssets returns every file a map needs, baseline files included,
+// and why each is needed, without extracting or writing anything.
+// It's the resolver BuildMapPak uses, for tools that only need the closure.
+func ResolveMapAssets(mapName string, gm *GameManifest) (map[string]Reason, error) {
+	deps, _, err := resolveMapFiles(mapName, gm)
+	if err != nil {
+		return nil, err
+	}
+	return deps.reasons(), nil
+}
+
+// resolveMapFiles returns every file a map needs, baseline files included:
+// the BSP and the shaders, textures, models, sounds, music, levelshot, and
+// arena file it references.
+func resolveMapFiles(mapName string, gm *GameManifest) (*depSet, *BSPAssets, error) {
+	needed := newDepSet()
 
 	// 1. BSP file
 	bspPath := "maps/" + mapName + ".bsp"
 	lowerBSP := strings.ToLower(bspPath)
 	if _, ok := gm.FileIndex[lowerBSP]; !ok {
-		return fmt.Errorf("BSP not found: %s", bspPath)
+		return nil, nil, &ErrFileNotInIndex{Path: bspPath}
 	}
-	needed[lowerBSP] = true
+	needed.add("", "map", lowerBSP)
 
 	// 2. Parse BSP
 	bspData, err := readFileFromIndex(lowerBSP, gm.FileIndex)
 	if err != nil {
-		return fmt.Errorf("read BSP: %w", err)
+		return nil, nil, fmt.Errorf("read BSP: %w", err)
 	}
 	bspAssets, err := ParseBSP(bytes.NewReader(bspData), int64(len(bspData)))
 	if err != nil {
-		return fmt.Errorf("parse BSP: %w", err)
+		return nil, nil, fmt.Errorf("parse BSP: %w", err)
 	}
 
-	log.Printf("  %s: BSP has %d shaders, %d models, %d sounds, %d music",
-		mapName, len(bspAssets.Shaders), len(bspAssets.Models), len(bspAssets.Sounds), len(bspAssets.Music))
-
-	// 3. Resolve BSP surface shaders
+	// 3. Resolve BSP surface shaders, plus ad surfaces and their banner variants
 	for _, shaderName := range bspAssets.Shaders {
-		resolveShaderTextures(shaderName, gm, needed)
+		resolveShaderTextures(shaderName, lowerBSP, gm, needed)
+		resolveBannerVariants(shaderName, gm, needed)
 	}
-
-	// 4. Resolve entity models (model2)
-	for _, modelPath := range bspAssets.Models {
-		resolveModel(modelPath, gm, needed)
+	for _, shaderName := range bspAssets.Advertisements {
+		resolveShaderTextures(shaderName, lowerBSP, gm, needed)
+		resolveBannerVariants(shaderName, gm, needed)
 	}
 
-	// 5. Resolve entity sounds
-	for _, soundPath := range bspAssets.Sounds {
-		lower := strings.ToLower(soundPath)
-		if _, ok := gm.FileIndex[lower]; ok {
-			needed[lower] = true
-		}
-	}
+	// 4. Resolve the models, sounds, music, and cinematics entities name
+	resolveEntityAssets(bspAssets, lowerBSP, gm, needed)
 
-	// 6. Resolve music
-	for _, musicPath := range bspAssets.Music {
-		lower := strings.ToLower(musicPath)
-		if _, ok := gm.FileIndex[lower]; ok {
-			needed[lower] = true
-		}
+	// 5. Apply an entity override, which servers spawn from in place of the
+	// BSP's entities; the BSP's are kept for clients without it
+	entPath, override, err := readEntityOverride(mapName, gm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if override != nil {
+		needed.add(lowerBSP, "entities", entPath)
+		resolveEntityAssets(override, entPath, gm, needed)
+		bspAssets.applyEntityOverride(override)
 	}
 
-	// 9. Include levelshot
+	// 6. Include levelshot
 	for _, ext := range []string{".jpg", ".tga"} {
 		ls := "levelshots/" + mapName + ext
 		if _, ok := gm.FileIndex[ls]; ok {
-			needed[ls] = true
+			needed.add(lowerBSP, "levelshot", ls)
 			break
 		}
 	}
 
-	// 10. Include arena file
+	// 7. Include arena file, and the bots it lists for the map. Their models
+	// resolve without a fallback, which depends on the game
 	arenaPath := "scripts/" + mapName + ".arena"
 	if _, ok := gm.FileIndex[arenaPath]; ok {
-		needed[arenaPath] = true
+		needed.add(lowerBSP, "arena", arenaPath)
+		resolveArenaBots(arenaPath, mapName, PlayerFallback{}, gm, needed)
 	}
 
-	// 11. Exclude baseline files
-	for path := range needed {
-		if gm.BaselineFiles[path] {
-			delete(needed, path)
-		}
-	}
+	return needed, bspAssets, nil
+}
 
-	if len(needed) == 0 {
-		log.Printf("  %s: no non-baseline files needed", mapName)
-		return nil
+// resolveEntityAssets adds the models, sounds, music, and cinematics named by
+// the entities in a, which come from the BSP or entity override at from.
+func resolveEntityAssets(a *BSPAssets, from string, gm *GameManifest, needed *depSet) {
+	// Entity models (model2)
+	for _, modelPath := range a.Models {
+		if _, ok := gm.FileIndex[strings.ToLower(modelPath)]; !ok {
+			needed.warn(DiagMissingModel, strings.ToLower(modelPath), from)
+		}
+		resolveModel(modelPath, from, gm, needed)
 	}
 
-	// Extract and write
-	paths := make([]string, 0, len(needed))
-	for p := range needed {
-		paths = append(paths, p)
+	// Entity sounds
+	for _, soundPath := range a.Sounds {
+		lower := strings.ToLower(soundPath)
+		if _, ok := gm.FileIndex[lower]; ok {
+			needed.add(from, "sound", lower)
+		} else {
+			needed.warn(DiagMissingSound, lower, from)
+		}
 	}
 
-	files, err := ExtractFilesFromPk3s(paths, gm.FileIndex)
-	if err != nil {
-		return fmt.Errorf("extract files: %w", err)
+	// Music
+	for _, musicPath := range a.Music {
+		lower := strings.ToLower(musicPath)
+		if _, ok := gm.FileIndex[lower]; ok {
+			needed.add(from, "music", lower)
+		} else {
+			needed.warn(DiagMissingMusic, lower, from)
+		}
 	}
 
-	if err := WritePk3(outputPath, files); err != nil {
-		return fmt.Errorf("write map pk3: %w", err)
+	// Cinematics
+	for _, video := range a.Videos {
+		lower := cinematicPath(video)
+		if _, ok := gm.FileIndex[lower]; ok {
+			needed.add(from, "cinematic", lower)
+		} else {
+			needed.warn(DiagMissingVideo, lower, from)
+		}
 	}
-
-	log.Printf("  %s: %d files", mapName, len(files))
-	return nil
 }
 
-// resolveShaderTextures resolves a shader name to its texture dependencies and adds them to needed.
-func resolveShaderTextures(shaderName string, gm *GameManifest, needed map[string]bool) {
+// resolveShaderTextures resolves a shader name referenced by from to its
+// texture dependencies and adds them to needed.
+func resolveShaderTextures(shaderName, from string, gm *GameManifest, needed *depSet) {
 	lower := strings.ToLower(shaderName)
-
-	// Look up shader definition
-	if textures, ok := gm.Shaders[lower]; ok {
+	name := shaderLookupName(lower)
+	node := shaderNode(lower)
+	needed.link(from, "shader", node)
+	resolved := resolvedShaderTextures(lower, gm)
+	for _, tex := range resolved {
+		needed.add(node, "texture", tex)
+	}
+	if textures, ok := gm.Shaders[name]; ok {
 		for _, tex := range textures {
-			if resolved, ok := ResolveTexture(tex, gm.FileIndex); ok {
-				needed[resolved] = true
+			if _, ok := gm.ResolveTexture(tex); !ok {
+				needed.warn(DiagMissingTexture, strings.ToLower(tex), node)
 			}
 		}
-		// If shader def has no texture refs (e.g. only surfaceparms),
-		// the engine uses the shader name as an implicit texture
-		if len(textures) == 0 {
-			if resolved, ok := ResolveTexture(lower, gm.FileIndex); ok {
-				needed[resolved] = true
-			}
+	} else if len(resolved) == 0 && name != "noshader" {
+		needed.warn(DiagMissingShader, lower, from)
+	}
+	// q3map_lightImage is only read by q3map2, so it's checked, not packed
+	for _, img := range gm.LightImages[name] {
+		if _, ok := gm.ResolveTexture(img); !ok {
+			needed.warn(DiagMissingLight, strings.ToLower(img), node)
+		}
+	}
+	// Include the .shader script file so the engine can find the definition
+	if scriptPath, ok := gm.ShaderFiles[name]; ok {
+		needed.add(node, "script", scriptPath)
+	}
+	// Follow q3map_backShader and q3map_cloneShader, each shader once
+	for _, linked := range gm.ShaderLinks[name] {
+		if _, done := needed.first[shaderNode(strings.ToLower(linked))]; done {
+			needed.link(node, "shader", shaderNode(strings.ToLower(linked)))
+			continue
 		}
-		// Include the .shader script file so the engine can find the definition
-		if scriptPath, ok := gm.ShaderFiles[lower]; ok {
-			needed[scriptPath] = true
+		resolveShaderTextures(linked, node, gm, needed)
+	}
+}
+
+// resolveBannerVariants adds the alternate images of a banner surface.
+// Ad and banner shaders (ad_* and md_* names) are placeholders the game swaps
+// at runtime for images named after them: textures/ad_content/ad_wide and
+// textures/ad_content/ad_wide_2.tga, say. Without these, Team Arena and Quake
+// Live map pk3s show the placeholder or a missing texture.
+func resolveBannerVariants(shaderName string, gm *GameManifest, needed *depSet) {
+	lower := strings.ToLower(shaderName)
+	base := path.Base(lower)
+	if !strings.HasPrefix(base, "ad_") && !strings.HasPrefix(base, "md_") {
+		return
+	}
+	prefix := strings.TrimSuffix(lower, path.Ext(lower)) + "_"
+	for file := range gm.FileIndex {
+		if strings.HasPrefix(file, prefix) && isTextureFile(file) {
+			needed.add(shaderNode(lower), "banner", file)
 		}
-	} else {
-		// No shader def — treat as direct texture path
-		if resolved, ok := ResolveTexture(lower, gm.FileIndex); ok {
-			needed[resolved] = true
+	}
+}
+
+// resolvedShaderTextures returns the texture files a lowered shader name
+// uses, following the engine's R_FindShader:
+//
+//  1. The definition is looked up without the name's extension, so an MD3
+//     surface naming models/foo/skin.tga uses a models/foo/skin shader.
+//  2. A definition's stage images each resolve by trying every image type
+//     (see ResolveTexture). One that isn't found leaves the engine drawing
+//     its default image; there's no further fallback to look for. A build
+//     including compressed textures adds each image's .dds and .ktx
+//     containers, and one taking companions its _n, _s, and _glow maps
+//     (see TextureSearch).
+//  3. Without a definition, the name itself is the image, extension and all,
+//     under the lightmap stage the engine adds, which needs no file.
+//
+// A definition naming no image of its own, such as a surfaceparm-only or
+// $whiteimage shader, also gets the image named after it if there is one.
+// The engine doesn't draw it, but q3map2 and the editors take it as the
+// surface's color, and maps ship it for them.
+func resolvedShaderTextures(lower string, gm *GameManifest) []string {
+	var resolved []string
+	search := gm.textureSearch()
+	for _, ref := range shaderTextureRefs(lower, gm) {
+		if path, ok := gm.ResolveTexture(ref); ok {
+			resolved = append(resolved, path)
+			resolved = append(resolved, search.variants(path, gm.FileIndex)...)
+			resolved = append(resolved, search.companions(path, gm.FileIndex)...)
 		}
 	}
+	return resolved
+}
+
+// shaderTextureRefs returns the image references resolvedShaderTextures
+// resolves for a lowered shader name: its definition's stage images, or the
+// name itself if it has none.
+func shaderTextureRefs(lower string, gm *GameManifest) []string {
+	textures := gm.Shaders[shaderLookupName(lower)]
+	if len(textures) == 0 {
+		return []string{lower}
+	}
+	return textures
+}
+
+// shaderLookupName returns the name the engine looks a lowered shader
+// reference up by: the reference without its extension.
+func shaderLookupName(lower string) string {
+	return strings.TrimSuffix(lower, path.Ext(lower))
 }
 
-// resolveModel resolves an MD3 model and all its shader/texture dependencies.
-func resolveModel(modelPath string, gm *GameManifest, needed map[string]bool) {
+// resolveModel resolves an MD3 model referenced by from and all its
+// shader/texture dependencies.
+func resolveModel(modelPath, from string, gm *GameManifest, needed *depSet) {
 	lower := strings.ToLower(modelPath)
 	if _, ok := gm.FileIndex[lower]; !ok {
 		return
 	}
-	needed[lower] = true
+	needed.add(from, "model", lower)
 
 	// Parse MD3 to get shader refs
 	data, err := readFileFromIndex(lower, gm.FileIndex)
 	if err != nil {
+		needed.fail(lower, from, err)
 		return
 	}
 	shaderRefs, err := ParseMD3Shaders(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
+		needed.fail(lower, from, err)
 		return
 	}
 
 	for _, ref := range shaderRefs {
-		resolveShaderTextures(ref, gm, needed)
+		resolveShaderTextures(ref, lower, gm, needed)
 	}
 }
 
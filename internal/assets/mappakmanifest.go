@@ -0,0 +1,103 @@
+package assets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// MapPakManifestName is the entry in each map pk3 describing its contents.
+const MapPakManifestName = "trinity_manifest.json"
+
+// MapPakManifest describes a generated map pk3: every file in it, where the
+// file came from, and why it was included.
+type MapPakManifest struct {
+	Map      string       `json:"map"`
+	Game     string       `json:"game"`
+	Files    []MapPakFile `json:"files"`
+	Excluded []string     `json:"excluded,omitempty"` // files the map uses that the build left out, such as music
+}
+
+// MapPakFile is a file in a map pk3.
+type MapPakFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Source string `json:"source"`         // source pk3, relative to the Quake 3 directory; empty if generated
+	Reason string `json:"reason"`         // kind of reference that first pulled it in (see DepEdge)
+	From   string `json:"from,omitempty"` // the file or shader making that reference
+
+	Original   string `json:"original,omitempty"`   // file this was re-encoded from, such as music/foo.wav for music/foo.ogg
+	Downscaled bool   `json:"downscaled,omitempty"` // texture halved to fit the build's size budget
+}
+
+// writeMapPakManifest adds the MapPakManifest entry to a map pk3 being written.
+func writeMapPakManifest(pw *Pk3Writer, mapName, game, quake3Dir string, files []writtenFile, excluded []string, deps *depSet) error {
+	m := MapPakManifest{Map: mapName, Game: game, Files: make([]MapPakFile, 0, len(files)), Excluded: excluded}
+	for _, f := range files {
+		entry := MapPakFile{
+			Path:       f.Path,
+			Size:       f.Size,
+			SHA256:     f.SHA256,
+			Original:   f.Original,
+			Downscaled: f.Downscaled,
+		}
+		if f.Source != "" {
+			entry.Source = relativeSource(quake3Dir, f.Source)
+		}
+		reasonFor := f.Path
+		if f.Original != "" {
+			reasonFor = f.Original
+		}
+		if e, ok := deps.reason(reasonFor); ok {
+			entry.Reason, entry.From = e.Kind, e.From
+		}
+		m.Files = append(m.Files, entry)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return pw.AddEntry(MapPakManifestName, strings.NewReader(string(data)+"\n"))
+}
+
+// relativeSource returns a source pk3's path relative to the Quake 3
+// directory, so manifests don't depend on where the install lives.
+func relativeSource(quake3Dir, source string) string {
+	if quake3Dir != "" {
+		if rel, err := filepath.Rel(quake3Dir, source); err == nil && !strings.HasPrefix(rel, "..") {
+			rel = filepath.ToSlash(rel)
+			if isLooseSource(source) {
+				rel += "/"
+			}
+			return rel
+		}
+	}
+	return filepath.Base(source)
+}
+
+// ReadMapPakManifest reads the MapPakManifest from a map pk3.
+func ReadMapPakManifest(pk3Path string) (*MapPakManifest, error) {
+	var m *MapPakManifest
+	err := IteratePk3(pk3Path, func(name string, open func() (io.ReadCloser, error)) error {
+		if name != MapPakManifestName {
+			return nil
+		}
+		rc, err := open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		m = &MapPakManifest{}
+		return json.NewDecoder(rc).Decode(m)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", pk3Path, err)
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s has no %s", pk3Path, MapPakManifestName)
+	}
+	return m, nil
+}
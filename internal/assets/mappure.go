@@ -0,0 +1,87 @@
+package assets
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// MapPureSet is the part of a game's pure list a server running one map
+// needs: the install's pk3s supplying the winning copy of a baseline file or
+// of a file the map uses. The rest can be moved out of the server's game
+// directories, shortening the sv_paks list clients must match.
+type MapPureSet struct {
+	Map  string `json:"map"`
+	Game string `json:"game"`
+	// Required and Unneeded split the game's PureList.Paks, keeping its
+	// order, highest priority first
+	Required []PurePak `json:"required"`
+	Unneeded []PurePak `json:"unneeded"`
+}
+
+// PlanMapPureSet works out which of a game's source pk3s a server must load
+// to run a map. Only the pk3s of the existing install are considered, not
+// the build's generated ones; list is the game's pure list from the build.
+func PlanMapPureSet(mapName, game string, manifest *Manifest, list *PureList) (*MapPureSet, error) {
+	gm, ok := manifest.Games[game]
+	if !ok {
+		return nil, fmt.Errorf("game %q not found in manifest", game)
+	}
+	mapName = strings.ToLower(mapName)
+	reasons, err := ResolveMapAssets(mapName, gm)
+	if err != nil {
+		return nil, err
+	}
+
+	needed := make(map[string]bool) // pure list name → needed
+	use := func(file string) {
+		if source, ok := gm.FileIndex[file]; ok && !isLooseSource(source) {
+			needed[purePakName(source)] = true
+		}
+	}
+	for file := range gm.BaselineFiles {
+		use(file)
+	}
+	for file := range reasons {
+		use(file)
+	}
+
+	set := &MapPureSet{Map: mapName, Game: game, Required: []PurePak{}, Unneeded: []PurePak{}}
+	for _, pak := range list.Paks {
+		if needed[pak.Name] {
+			set.Required = append(set.Required, pak)
+		} else {
+			set.Unneeded = append(set.Unneeded, pak)
+		}
+	}
+	return set, nil
+}
+
+// purePakName returns a source pk3's name as pure lists give it:
+// "<game dir>/<pak name>", without the extension.
+func purePakName(pk3Path string) string {
+	name := strings.TrimSuffix(filepath.Base(pk3Path), filepath.Ext(pk3Path))
+	return filepath.Base(filepath.Dir(pk3Path)) + "/" + name
+}
+
+// WriteServerConfig writes the set as a server.cfg snippet: the paks to keep
+// and to move aside as comments, then the settings to run the map pure.
+func (s *MapPureSet) WriteServerConfig(w io.Writer) error {
+	fmt.Fprintf(w, "// %s (%s): %d of %d paks needed\n", s.Map, s.Game, len(s.Required), len(s.Required)+len(s.Unneeded))
+	if s.Game != "baseq3" {
+		fmt.Fprintf(w, "// start the server with +set fs_game %s\n", s.Game)
+	}
+	fmt.Fprintf(w, "//\n// Keep:\n")
+	for _, pak := range s.Required {
+		fmt.Fprintf(w, "//   %s.pk3 (checksum %d)\n", pak.Name, pak.Checksum)
+	}
+	if len(s.Unneeded) > 0 {
+		fmt.Fprintf(w, "//\n// Not needed for this map; move out of the game directories to trim sv_paks:\n")
+		for _, pak := range s.Unneeded {
+			fmt.Fprintf(w, "//   %s.pk3\n", pak.Name)
+		}
+	}
+	_, err := fmt.Fprintf(w, "\nset sv_pure 1\nmap %s\n", s.Map)
+	return err
+}
@@ -0,0 +1,59 @@
+package assets
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanMapPureSet(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists, err := LoadPureLists(filepath.Join(out, PureListName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(paks []PurePak) string {
+		var s []string
+		for _, p := range paks {
+			s = append(s, p.Name)
+		}
+		return strings.Join(s, " ")
+	}
+	tests := []struct {
+		mapName, required, unneeded string
+	}{
+		{"q3dm0", "baseq3/pak0", "baseq3/map-custom"},
+		{"custom", "baseq3/map-custom baseq3/pak0", ""},
+	}
+	for _, tt := range tests {
+		set, err := PlanMapPureSet(tt.mapName, "baseq3", manifest, lists["baseq3"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(set.Required); got != tt.required {
+			t.Errorf("%s: required = %q, want %q", tt.mapName, got, tt.required)
+		}
+		if got := names(set.Unneeded); got != tt.unneeded {
+			t.Errorf("%s: unneeded = %q, want %q", tt.mapName, got, tt.unneeded)
+		}
+	}
+
+	set, err := PlanMapPureSet("q3dm0", "baseq3", manifest, lists["baseq3"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg strings.Builder
+	set.WriteServerConfig(&cfg)
+	if !strings.Contains(cfg.String(), "//   baseq3/map-custom.pk3\n") || !strings.HasSuffix(cfg.String(), "set sv_pure 1\nmap q3dm0\n") {
+		t.Errorf("server.cfg snippet:\n%s", cfg.String())
+	}
+}
@@ -8,10 +8,15 @@ import (
 )
 
 const (
-	md3Magic       = "IDP3"
-	md3Version     = 15
-	md3HeaderSize  = 108
-	md3ShaderSize  = 68 // 64-byte name + int32 index
+	md3Magic             = "IDP3"
+	md3Version           = 15
+	md3HeaderSize        = 108
+	md3ShaderSize        = 68 // 64-byte name + int32 index
+	md3SurfaceHeaderSize = 108
+
+	// Engine limits (MD3_MAX_SURFACES, MD3_MAX_SHADERS in qfiles.h)
+	md3MaxSurfaces = 32
+	md3MaxShaders  = 256
 )
 
 // ParseMD3Shaders parses an MD3 model file and extracts surface shader references.
@@ -35,20 +40,24 @@ func ParseMD3Shaders(r io.ReaderAt, size int64) ([]string, error) {
 		return nil, fmt.Errorf("unsupported MD3 version: %d", version)
 	}
 
-	numSurfaces := int32(binary.LittleEndian.Uint32(header[76:80]))
-	ofsSurfaces := int64(binary.LittleEndian.Uint32(header[96:100]))
+	numSurfaces := int32(binary.LittleEndian.Uint32(header[84:88]))
+	ofsSurfaces := int64(binary.LittleEndian.Uint32(header[100:104]))
 
 	var shaders []string
 	seen := make(map[string]bool)
 
+	if numSurfaces < 0 || numSurfaces > md3MaxSurfaces {
+		return nil, fmt.Errorf("invalid MD3 surface count: %d", numSurfaces)
+	}
+
 	surfaceOfs := ofsSurfaces
 	for i := int32(0); i < numSurfaces; i++ {
-		if surfaceOfs+12*4+64+4 > size {
+		if surfaceOfs+md3SurfaceHeaderSize > size {
 			break
 		}
 
 		// Read surface header (enough to get shader info)
-		surfHeader := make([]byte, 12*4+64+4) // up through ofsEnd fields
+		surfHeader := make([]byte, md3SurfaceHeaderSize) // up through ofsEnd
 		if _, err := r.ReadAt(surfHeader, surfaceOfs); err != nil {
 			return nil, fmt.Errorf("read MD3 surface %d header: %w", i, err)
 		}
@@ -58,11 +67,15 @@ func ParseMD3Shaders(r io.ReaderAt, size int64) ([]string, error) {
 			return nil, fmt.Errorf("invalid MD3 surface magic at offset %d", surfaceOfs)
 		}
 
-		numShaders := int32(binary.LittleEndian.Uint32(header[76:80]))
-		// Re-read from surface header
-		numShaders = int32(binary.LittleEndian.Uint32(surfHeader[72:76]))
-		ofsShaders := int64(binary.LittleEndian.Uint32(surfHeader[88:92]))
+		numShaders := int32(binary.LittleEndian.Uint32(surfHeader[76:80]))
+		ofsShaders := int64(binary.LittleEndian.Uint32(surfHeader[92:96]))
 		ofsEnd := int64(binary.LittleEndian.Uint32(surfHeader[104:108]))
+		if numShaders < 0 || numShaders > md3MaxShaders {
+			return nil, fmt.Errorf("invalid MD3 shader count %d in surface %d", numShaders, i)
+		}
+		if ofsEnd < md3SurfaceHeaderSize {
+			return nil, fmt.Errorf("invalid MD3 surface %d size: %d", i, ofsEnd)
+		}
 
 		// Read shader entries
 		for j := int32(0); j < numShaders; j++ {
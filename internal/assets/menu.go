@@ -0,0 +1,201 @@
+package assets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// MenuRef is an asset a Team Arena style menu script references.
+type MenuRef struct {
+	Path string // as written, except fonts (see ParseMenu)
+	Kind string // menu, include, shader, model, sound, music, cinematic, or font
+}
+
+// menuKeywords maps the .menu keywords (lowered) whose first argument names
+// an asset to the kind of asset, as ui_shared.c parses them.
+var menuKeywords = map[string]string{
+	"background":     "shader", // menuDef and itemDef
+	"asset_shader":   "shader",
+	"gradientbar":    "shader",
+	"cursor":         "shader",
+	"setbackground":  "shader", // script commands
+	"asset_model":    "model",
+	"focussound":     "sound",
+	"menuentersound": "sound",
+	"menuexitsound":  "sound",
+	"menubuzzsound":  "sound",
+	"itemfocussound": "sound",
+	"play":           "sound",
+	"playlooped":     "sound",
+	"soundloop":      "music",
+	"cinematic":      "cinematic",
+	"#include":       "include",
+}
+
+// ParseMenu parses a .menu file, or a menu list such as ui/menus.txt, for the
+// assets it references. loadMenu blocks name menus to load. A font is given
+// as the file the renderer loads for it, fonts/fontImage_<size>.dat, since
+// the font name itself is ignored. Duplicates are dropped.
+func ParseMenu(r io.Reader) ([]MenuRef, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var refs []MenuRef
+	seen := make(map[MenuRef]bool)
+	add := func(p, kind string) {
+		p = strings.TrimRight(strings.ReplaceAll(p, "\\", "/"), ";")
+		ref := MenuRef{Path: p, Kind: kind}
+		if p == "" || seen[ref] {
+			return
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+
+	tokens := Tokenize(string(data), false)
+	word := func(i int) (string, bool) {
+		if i < len(tokens) && tokens[i].Kind == TokenWord {
+			return tokens[i].Text, true
+		}
+		return "", false
+	}
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].Kind != TokenWord {
+			continue
+		}
+		keyword := strings.ToLower(tokens[i].Text)
+		switch keyword {
+		case "loadmenu":
+			if i+1 < len(tokens) && tokens[i+1].Kind == TokenOpen {
+				for i += 2; i < len(tokens) && tokens[i].Kind == TokenWord; i++ {
+					add(tokens[i].Text, "menu")
+				}
+			}
+		case "font", "smallfont", "bigfont":
+			// font "fonts/font" 16
+			if _, ok := word(i + 1); !ok {
+				continue
+			}
+			size := 12
+			if s, ok := word(i + 2); ok {
+				if n, err := strconv.Atoi(s); err == nil && n > 0 {
+					size = n
+				}
+			}
+			add(fmt.Sprintf("fonts/fontImage_%d.dat", size), "font")
+		default:
+			if kind, ok := menuKeywords[keyword]; ok {
+				if p, ok := word(i + 1); ok {
+					add(p, kind)
+				}
+			}
+		}
+	}
+	return refs, nil
+}
+
+// fontGlyphs is the number of glyphs in a fontImage_<size>.dat.
+const fontGlyphs = 256
+
+// ParseFontDat parses a fontImage_<size>.dat (the renderer's fontInfo_t) for
+// the shaders its glyphs are drawn from, usually one or two images.
+func ParseFontDat(r io.Reader) ([]string, error) {
+	// glyphInfo_t: 7 ints, 4 floats, a handle, then a 32-byte shader name
+	const glyphSize, nameOffset, nameSize = 80, 48, 32
+	data, err := io.ReadAll(io.LimitReader(r, fontGlyphs*glyphSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < fontGlyphs*glyphSize {
+		return nil, fmt.Errorf("font data too short: %d bytes", len(data))
+	}
+	var shaders []string
+	seen := make(map[string]bool)
+	for g := 0; g < fontGlyphs; g++ {
+		name := data[g*glyphSize+nameOffset : g*glyphSize+nameOffset+nameSize]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		if s := string(name); s != "" && !seen[s] {
+			seen[s] = true
+			shaders = append(shaders, s)
+		}
+	}
+	return shaders, nil
+}
+
+// isMenuFile reports whether a lowered path is a menu script or menu list.
+func isMenuFile(p string) bool {
+	return strings.HasPrefix(p, "ui/") && (strings.HasSuffix(p, ".menu") || strings.HasSuffix(p, ".txt"))
+}
+
+// resolveMenu adds a menu script and everything it references to needed.
+func resolveMenu(p, from, kind string, gm *GameManifest, needed *depSet) {
+	p = path.Clean(strings.ToLower(p))
+	if needed.files[p] {
+		needed.link(from, kind, p)
+		return
+	}
+	data, err := readFileFromIndex(p, gm.FileIndex)
+	if err != nil {
+		return
+	}
+	needed.add(from, kind, p)
+
+	refs, _ := ParseMenu(bytes.NewReader(data))
+	for _, ref := range refs {
+		lower := strings.ToLower(ref.Path)
+		switch ref.Kind {
+		case "menu", "include":
+			resolveMenu(lower, p, ref.Kind, gm, needed)
+		case "shader":
+			resolveShaderTextures(lower, p, gm, needed)
+		case "model":
+			resolveModel(lower, p, gm, needed)
+		case "sound", "music":
+			if file, ok := resolveSound(lower, gm.FileIndex); ok {
+				needed.add(p, ref.Kind, file)
+			}
+		case "cinematic":
+			lower = cinematicPath(lower)
+			if _, ok := gm.FileIndex[lower]; ok {
+				needed.add(p, ref.Kind, lower)
+			}
+		case "font":
+			resolveFont(lower, p, gm, needed)
+		}
+	}
+}
+
+// resolveFont adds a font's glyph data and the images it's drawn from.
+func resolveFont(datPath, from string, gm *GameManifest, needed *depSet) {
+	data, err := readFileFromIndex(datPath, gm.FileIndex)
+	if err != nil {
+		return
+	}
+	needed.add(from, "font", datPath)
+	shaders, err := ParseFontDat(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Warning: %s: %v", datPath, err)
+		return
+	}
+	for _, shader := range shaders {
+		resolveShaderTextures(shader, datPath, gm, needed)
+	}
+}
+
+// resolveMenus adds the menu scripts under ui/ that come from the given
+// sources, and everything they reference, to needed.
+func resolveMenus(gm *GameManifest, sources map[string]bool, needed *depSet) {
+	for _, p := range sortedMapKeys(gm.FileIndex) {
+		if isMenuFile(p) && sources[gm.FileIndex[p]] {
+			resolveMenu(p, "", "menu", gm, needed)
+		}
+	}
+}
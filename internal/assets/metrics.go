@@ -0,0 +1,25 @@
+package assets
+
+import "github.com/ernie/trinity-tools/internal/metrics"
+
+// Metrics the services export (see package metrics)
+var (
+	demosParsed = metrics.NewCounterVec("trinity_demos_parsed_total",
+		"Demos parsed, by result (ok or error).", "result")
+	mapPaksBuilt = metrics.NewCounterVec("trinity_mappaks_built_total",
+		"Map pk3 builds, by result (ok or error).", "result")
+	mapPakBuildSeconds = metrics.NewHistogram("trinity_mappak_build_seconds",
+		"Time taken to build a map pk3.")
+	baselineBuildSeconds = metrics.NewHistogram("trinity_baseline_build_seconds",
+		"Time taken to build a baseline, including its map pk3s.")
+	pk3CacheLookups = metrics.NewCounterVec("trinity_pk3_cache_lookups_total",
+		"Lookups in the remote pk3 reader caches, by cache (archive or block) and result (hit or miss).", "cache", "result")
+)
+
+// resultLabel is the result label value for an error.
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
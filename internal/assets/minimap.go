@@ -0,0 +1,147 @@
+package assets
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	maxMinimapWidth = 4096
+
+	// Shader surface flags of surfaces a minimap leaves out
+	surfSky    = 0x4
+	surfNodraw = 0x80
+
+	// minimapFloorNormal is the least upward normal a floor has; steeper
+	// surfaces are walls, and downward ones ceilings
+	minimapFloorNormal = 0.7
+)
+
+// errNoFloors is returned for a BSP with nothing a minimap would show.
+var errNoFloors = errors.New("no floors to draw")
+
+// Minimap is a top-down render of a map's floors, shaded by height, lighter
+// being higher. Where floors overlap, the highest shows.
+type Minimap struct {
+	Image *image.RGBA
+	// Mins and Maxs are the world X and Y the image spans: Mins is its
+	// bottom-left corner and Maxs its top-right, for placing players on it
+	Mins, Maxs [2]float64
+}
+
+// minimapTriangle is a floor triangle projected onto the XY plane.
+type minimapTriangle struct {
+	xy [3][2]float64
+	z  float64
+}
+
+// RenderMinimap projects a Q3 BSP's floors top-down into an image width
+// pixels across its longer side, from the same lumps ParseBSPSurfaces reads.
+// Sky and nodraw surfaces are left out.
+func RenderMinimap(r io.ReaderAt, size int64, width int) (*Minimap, error) {
+	if width <= 0 || width > maxMinimapWidth {
+		return nil, fmt.Errorf("minimap width %d out of range 1-%d", width, maxMinimapWidth)
+	}
+	geo, err := readBSPGeometry(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var tris []minimapTriangle
+	mins := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	maxs := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	numShaders := len(geo.shaders) / bspShaderSize
+	for i := 0; i+bspSurfaceSize <= len(geo.surfaces); i += bspSurfaceSize {
+		surf := geo.surfaces[i : i+bspSurfaceSize]
+		shader := surfaceField(surf, 0)
+		if shader < 0 || shader >= numShaders {
+			continue
+		}
+		if binary.LittleEndian.Uint32(geo.shaders[shader*bspShaderSize+64:])&(surfSky|surfNodraw) != 0 {
+			continue
+		}
+		geo.triangles(surf, func(a, b, c int) {
+			// Vertex normals follow the position, lightmap and texture coordinates
+			up := (geo.vertFloat(a, 36) + geo.vertFloat(b, 36) + geo.vertFloat(c, 36)) / 3
+			if !(up >= minimapFloorNormal) {
+				return
+			}
+			t := minimapTriangle{}
+			for k, v := range [3]int{a, b, c} {
+				p := geo.verts[v]
+				if math.IsNaN(p[0]+p[1]+p[2]) || math.IsInf(p[0]+p[1]+p[2], 0) {
+					return
+				}
+				t.xy[k] = [2]float64{p[0], p[1]}
+				t.z += p[2] / 3
+				for j := range 3 {
+					mins[j] = math.Min(mins[j], p[j])
+					maxs[j] = math.Max(maxs[j], p[j])
+				}
+			}
+			tris = append(tris, t)
+		})
+	}
+	if len(tris) == 0 {
+		return nil, errNoFloors
+	}
+
+	// Scale the longer side to width, keeping the map's proportions
+	scale := float64(width) / math.Max(math.Max(maxs[0]-mins[0], maxs[1]-mins[1]), 1)
+	w := max(int(math.Ceil((maxs[0]-mins[0])*scale)), 1)
+	h := max(int(math.Ceil((maxs[1]-mins[1])*scale)), 1)
+	m := &Minimap{
+		Image: image.NewRGBA(image.Rect(0, 0, w, h)),
+		Mins:  [2]float64{mins[0], mins[1]},
+		Maxs:  [2]float64{mins[0] + float64(w)/scale, mins[1] + float64(h)/scale},
+	}
+
+	// Paint from the lowest floor up, so higher floors cover lower ones
+	sort.SliceStable(tris, func(i, j int) bool { return tris[i].z < tris[j].z })
+	for _, t := range tris {
+		shade := uint8(160)
+		if maxs[2] > mins[2] {
+			shade = uint8(48 + 192*(t.z-mins[2])/(maxs[2]-mins[2]))
+		}
+		var pts [3][2]float64
+		for k, p := range t.xy {
+			pts[k] = [2]float64{(p[0] - m.Mins[0]) * scale, (m.Maxs[1] - p[1]) * scale}
+		}
+		fillTriangle(m.Image, pts, color.RGBA{shade, shade, shade, 255})
+	}
+	return m, nil
+}
+
+// fillTriangle sets the pixels whose centers lie within a triangle, of
+// either winding.
+func fillTriangle(img *image.RGBA, p [3][2]float64, c color.RGBA) {
+	edge := func(a, b [2]float64, x, y float64) float64 {
+		return (b[0]-a[0])*(y-a[1]) - (b[1]-a[1])*(x-a[0])
+	}
+	area := edge(p[0], p[1], p[2][0], p[2][1])
+	if area == 0 {
+		return
+	}
+	b := img.Bounds()
+	x0 := max(int(math.Floor(min(p[0][0], p[1][0], p[2][0]))), b.Min.X)
+	x1 := min(int(math.Ceil(max(p[0][0], p[1][0], p[2][0]))), b.Max.X-1)
+	y0 := max(int(math.Floor(min(p[0][1], p[1][1], p[2][1]))), b.Min.Y)
+	y1 := min(int(math.Ceil(max(p[0][1], p[1][1], p[2][1]))), b.Max.Y-1)
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			cx, cy := float64(x)+0.5, float64(y)+0.5
+			w0 := edge(p[1], p[2], cx, cy)
+			w1 := edge(p[2], p[0], cx, cy)
+			w2 := edge(p[0], p[1], cx, cy)
+			if area > 0 && w0 >= 0 && w1 >= 0 && w2 >= 0 || area < 0 && w0 <= 0 && w1 <= 0 && w2 <= 0 {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package assets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderMinimap(t *testing.T) {
+	up, side := [3]float32{0, 0, 1}, [3]float32{1, 0, 0}
+	quad := []int32{0, 1, 2, 0, 2, 3}
+	bsp := makeSurfaceBSP(
+		[]string{"textures/mod/floor", "textures/mod/sky"},
+		[]fixtureSurface{
+			// A 128x64 floor, with a raised ledge over its left half
+			{shader: 0, kind: bspSurfacePlanar, normal: up, indexes: quad, verts: [][3]float32{{0, 0, 0}, {128, 0, 0}, {128, 64, 0}, {0, 64, 0}}},
+			{shader: 0, kind: bspSurfacePlanar, normal: up, indexes: quad, verts: [][3]float32{{0, 0, 64}, {64, 0, 64}, {64, 64, 64}, {0, 64, 64}}},
+			// A wall, and a sky far out, neither drawn nor widening the image
+			{shader: 0, kind: bspSurfacePlanar, normal: side, indexes: quad, verts: [][3]float32{{-512, 0, 0}, {-512, 64, 0}, {-512, 64, 64}, {-512, 0, 64}}},
+			{shader: 1, kind: bspSurfacePlanar, normal: up, indexes: quad, verts: [][3]float32{{0, 0, 512}, {1024, 0, 512}, {1024, 1024, 512}, {0, 1024, 512}}},
+		},
+	)
+	shaders := int(binary.LittleEndian.Uint32(bsp[8+bspLumpShaders*8:]))
+	binary.LittleEndian.PutUint32(bsp[shaders+bspShaderSize+64:], surfSky)
+
+	m, err := RenderMinimap(bytes.NewReader(bsp), int64(len(bsp)), 64)
+	if err != nil {
+		t.Fatalf("RenderMinimap: %v", err)
+	}
+	if b := m.Image.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Fatalf("image is %v, want 64x32", b)
+	}
+	if m.Mins != [2]float64{0, 0} || m.Maxs != [2]float64{128, 64} {
+		t.Errorf("bounds = %v-%v, want 0,0-128,64", m.Mins, m.Maxs)
+	}
+	ledge, floor := m.Image.RGBAAt(10, 16), m.Image.RGBAAt(50, 16)
+	if floor.A == 0 || ledge.R <= floor.R {
+		t.Errorf("ledge %v, floor %v: want the ledge drawn lighter than the floor", ledge, floor)
+	}
+
+	q := t.TempDir()
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "pak0.pk3"), map[string][]byte{
+		"gfx/2d/crosshaira.tga": fixtureImage("crosshaira"),
+		"maps/ledge.bsp":        bsp,
+	})
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ExportLevelshots(manifest, out, LevelshotExportOptions{Minimap: 64})
+	if err != nil {
+		t.Fatalf("ExportLevelshots: %v", err)
+	}
+	shot := index["baseq3"]["ledge"]
+	if shot == nil || shot.Minimap == nil || shot.Minimap.Image != "levelshots/baseq3/ledge_minimap.png" {
+		t.Fatalf("ledge = %+v, want a minimap without a levelshot", shot)
+	}
+	if _, err := os.Stat(filepath.Join(out, filepath.FromSlash(shot.Minimap.Image))); err != nil {
+		t.Errorf("minimap not written: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package assets
+
+import (
+	"math"
+	"strings"
+)
+
+// floatIntBias offsets integral floats sent in floatIntBits (FLOAT_INT_BIAS).
+const floatIntBias = 1 << (floatIntBits - 1)
+
+// MsgWriter writes Huffman-encoded Q3 message data readable by MsgReader,
+// bit for bit as the engine's MSG_Write functions do.
+type MsgWriter struct {
+	// Protocol holds the field tables entity and playerstate deltas are
+	// written with.
+	Protocol *Protocol
+
+	data   []byte
+	bitPos int
+}
+
+// NewMsgWriter creates an empty message writer for ProtocolQ3.
+func NewMsgWriter() *MsgWriter {
+	return &MsgWriter{Protocol: ProtocolQ3}
+}
+
+// putBit appends a single raw bit (matches HuffmanPutBit).
+func (w *MsgWriter) putBit(bit int) {
+	if w.bitPos&7 == 0 {
+		w.data = append(w.data, 0)
+	}
+	w.data[w.bitPos>>3] |= byte(bit&1) << uint(w.bitPos&7)
+	w.bitPos++
+}
+
+// WriteBits writes the low n bits of value the way MsgReader.ReadBits reads
+// them: sub-byte portion as raw bits, full bytes as Huffman symbols.
+// Negative n writes a signed value in -n bits, as MSG_WriteBits does.
+func (w *MsgWriter) WriteBits(value, n int) {
+	if n < 0 {
+		n = -n
+	}
+	nbits := n & 7
+	for i := 0; i < nbits; i++ {
+		w.putBit(value >> uint(i))
+	}
+	for i := nbits; i < n; i += 8 {
+		c := huffEncoderTable[byte(value>>uint(i))]
+		for b := 0; b < c.bits; b++ {
+			w.putBit(int(c.code >> uint(b)))
+		}
+	}
+}
+
+// WriteByte writes one byte. It never fails; the error makes a MsgWriter an
+// io.ByteWriter.
+func (w *MsgWriter) WriteByte(c byte) error {
+	w.WriteBits(int(c), 8)
+	return nil
+}
+
+// WriteChar writes a signed byte (matches MSG_WriteChar).
+func (w *MsgWriter) WriteChar(v int) {
+	w.WriteBits(v, -8)
+}
+
+// WriteShort writes a 16-bit value, signed or not.
+func (w *MsgWriter) WriteShort(v int) {
+	w.WriteBits(v, 16)
+}
+
+// WriteLong writes a 32-bit value, signed or not.
+func (w *MsgWriter) WriteLong(v int) {
+	w.WriteBits(v, 32)
+}
+
+// WriteFloat writes a 32-bit IEEE float (matches MSG_WriteFloat).
+func (w *MsgWriter) WriteFloat(f float32) {
+	w.WriteBits(int(math.Float32bits(f)), 32)
+}
+
+// WriteString writes a null-terminated string (matches MSG_WriteString):
+// '%' and bytes above 127 become '.', and a string too long for ReadString
+// is written empty.
+func (w *MsgWriter) WriteString(s string) {
+	w.writeString(s, maxStringChars)
+}
+
+// WriteBigString writes a null-terminated string of up to 8191 characters
+// (matches MSG_WriteBigString).
+func (w *MsgWriter) WriteBigString(s string) {
+	w.writeString(s, bigInfoStringSize)
+}
+
+func (w *MsgWriter) writeString(s string, size int) {
+	if len(s) >= size {
+		s = ""
+	}
+	s = strings.Map(func(r rune) rune {
+		if r == '%' || r > 127 {
+			return '.'
+		}
+		return r
+	}, s)
+	for i := 0; i < len(s); i++ {
+		w.WriteByte(s[i])
+	}
+	w.WriteByte(0)
+}
+
+// WriteData writes raw bytes, each Huffman-encoded (matches MSG_WriteData).
+func (w *MsgWriter) WriteData(data []byte) {
+	for _, b := range data {
+		w.WriteByte(b)
+	}
+}
+
+// CopyBits appends the first n raw bits of an encoded message, so a frame's
+// unchanged sections can be kept without re-encoding them.
+func (w *MsgWriter) CopyBits(src []byte, n int) {
+	for i := 0; i < n; i++ {
+		w.putBit(int(src[i>>3] >> uint(i&7)))
+	}
+}
+
+// Bits returns the number of bits written.
+func (w *MsgWriter) Bits() int {
+	return w.bitPos
+}
+
+// Bytes returns the encoded message.
+func (w *MsgWriter) Bytes() []byte {
+	return w.data
+}
+
+// WriteDeltaEntity writes the changes from one entity state to the next, as
+// MSG_WriteDeltaEntity does: a nil to removes from, and an unchanged entity
+// is only written when force is set. A nil from is the zero state.
+func (w *MsgWriter) WriteDeltaEntity(from, to *EntityState, force bool) {
+	if to == nil {
+		if from != nil {
+			w.WriteBits(from.Number, gentitynumBits)
+			w.WriteBits(1, 1)
+		}
+		return
+	}
+	if from == nil {
+		from = &EntityState{}
+	}
+
+	fieldBits := w.Protocol.EntityFieldBits
+	lc := 0
+	for i := range fieldBits {
+		if from.Fields[i] != to.Fields[i] {
+			lc = i + 1
+		}
+	}
+	if lc == 0 {
+		if force {
+			w.WriteBits(to.Number, gentitynumBits)
+			w.WriteBits(0, 1) // not removed
+			w.WriteBits(0, 1) // no delta
+		}
+		return
+	}
+
+	w.WriteBits(to.Number, gentitynumBits)
+	w.WriteBits(0, 1) // not removed
+	w.WriteBits(1, 1) // delta follows
+	w.WriteByte(byte(lc))
+	for i := 0; i < lc; i++ {
+		value := to.Fields[i]
+		if from.Fields[i] == value {
+			w.WriteBits(0, 1)
+			continue
+		}
+		w.WriteBits(1, 1)
+		bits := fieldBits[i]
+		if bits == 0 {
+			if math.Float32frombits(value) == 0 {
+				w.WriteBits(0, 1)
+				continue
+			}
+			w.WriteBits(1, 1)
+			w.writeDeltaFloat(value)
+			continue
+		}
+		if value == 0 {
+			w.WriteBits(0, 1)
+			continue
+		}
+		w.WriteBits(1, 1)
+		w.WriteBits(int(value), bits)
+	}
+}
+
+// WriteDeltaPlayerstate writes the changes from one playerstate to the next,
+// as MSG_WriteDeltaPlayerstate does. A nil from is the zero state.
+func (w *MsgWriter) WriteDeltaPlayerstate(from, to *PlayerState) {
+	if from == nil {
+		from = &PlayerState{}
+	}
+
+	p := w.Protocol
+	lc := 0
+	for i := range p.PlayerFieldBits {
+		if from.Fields[i] != to.Fields[i] {
+			lc = i + 1
+		}
+	}
+	w.WriteByte(byte(lc))
+	for i := 0; i < lc; i++ {
+		value := to.Fields[i]
+		if from.Fields[i] == value {
+			w.WriteBits(0, 1)
+			continue
+		}
+		w.WriteBits(1, 1)
+		if bits := p.PlayerFieldBits[i]; bits != 0 {
+			w.WriteBits(int(value), bits)
+		} else {
+			w.writeDeltaFloat(value)
+		}
+	}
+
+	statsBits := deltaArrayBits(from.Stats[:p.MaxStats], to.Stats[:p.MaxStats])
+	persistantBits := deltaArrayBits(from.Persistant[:p.MaxPersistant], to.Persistant[:p.MaxPersistant])
+	ammoBits := deltaArrayBits(from.Ammo[:p.MaxWeapons], to.Ammo[:p.MaxWeapons])
+	powerupBits := deltaArrayBits(from.Powerups[:p.MaxPowerups], to.Powerups[:p.MaxPowerups])
+	if statsBits|persistantBits|ammoBits|powerupBits == 0 {
+		w.WriteBits(0, 1) // no arrays changed
+		return
+	}
+	w.WriteBits(1, 1)
+	w.writeDeltaArray(statsBits, p.MaxStats, to.Stats[:], w.WriteShort)
+	w.writeDeltaArray(persistantBits, p.MaxPersistant, to.Persistant[:], w.WriteShort)
+	w.writeDeltaArray(ammoBits, p.MaxWeapons, to.Ammo[:], w.WriteShort)
+	w.writeDeltaArray(powerupBits, p.MaxPowerups, to.Powerups[:], w.WriteLong)
+}
+
+// writeDeltaFloat writes a changed float field: integral values that fit in
+// floatIntBits take the short form, everything else is sent whole.
+func (w *MsgWriter) writeDeltaFloat(value uint32) {
+	f := math.Float32frombits(value)
+	if f == float32(math.Trunc(float64(f))) && f >= -floatIntBias && f < floatIntBias {
+		w.WriteBits(0, 1)
+		w.WriteBits(int(f)+floatIntBias, floatIntBits)
+		return
+	}
+	w.WriteBits(1, 1)
+	w.WriteBits(int(value), 32)
+}
+
+// deltaArrayBits returns the bitmask of slots that differ between from and to.
+func deltaArrayBits(from, to []int) int {
+	bits := 0
+	for i := range to {
+		if from[i] != to[i] {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits
+}
+
+// writeDeltaArray writes one of the playerstate arrays: a changed flag, the
+// bitmask of changed slots, then each changed value.
+func (w *MsgWriter) writeDeltaArray(bits, n int, values []int, write func(int)) {
+	if bits == 0 {
+		w.WriteBits(0, 1)
+		return
+	}
+	w.WriteBits(1, 1)
+	w.WriteBits(bits, n)
+	for i := 0; i < n; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			write(values[i])
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package assets
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestMsgWriterBitsRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	type value struct{ v, n int }
+	var values []value
+	for n := 1; n <= 32; n++ {
+		for i := 0; i < 8; i++ {
+			values = append(values, value{int(rng.Uint32()) & (1<<uint(n) - 1), n})
+		}
+		values = append(values, value{0, n}, value{1<<uint(n) - 1, n})
+	}
+
+	w := NewMsgWriter()
+	for _, v := range values {
+		w.WriteBits(v.v, v.n)
+	}
+	r := NewMsgReader(w.Bytes())
+	for i, v := range values {
+		if got := r.ReadBits(v.n); got != v.v {
+			t.Fatalf("value %d: ReadBits(%d) = %#x, want %#x", i, v.n, got, v.v)
+		}
+	}
+	if r.Overflowed() {
+		t.Fatal("reader overflowed")
+	}
+}
+
+func TestMsgWriterPrimitivesRoundTrip(t *testing.T) {
+	w := NewMsgWriter()
+	w.WriteByte(0xfe)
+	w.WriteChar(-100)
+	w.WriteShort(-12345)
+	w.WriteShort(54321)
+	w.WriteLong(-7)
+	w.WriteFloat(-3.25)
+	w.WriteString("^1Player 100% \xff")
+	w.WriteBigString("")
+	w.WriteData([]byte{0, 1, 2, 255})
+
+	r := NewMsgReader(w.Bytes())
+	if b, err := r.ReadByte(); b != 0xfe || err != nil {
+		t.Errorf("ReadByte = %#x, %v", b, err)
+	}
+	if got := r.ReadChar(); got != -100 {
+		t.Errorf("ReadChar = %d", got)
+	}
+	if got := r.ReadSignedShort(); got != -12345 {
+		t.Errorf("ReadSignedShort = %d", got)
+	}
+	if got := r.ReadShort(); got != 54321 {
+		t.Errorf("ReadShort = %d", got)
+	}
+	if got := r.ReadSignedLong(); got != -7 {
+		t.Errorf("ReadSignedLong = %d", got)
+	}
+	if got := r.ReadFloat(); got != -3.25 {
+		t.Errorf("ReadFloat = %v", got)
+	}
+	if got := r.ReadString(); got != "^1Player 100. ." {
+		t.Errorf("ReadString = %q", got)
+	}
+	if got := r.ReadBigString(); got != "" {
+		t.Errorf("ReadBigString = %q", got)
+	}
+	if got := r.ReadData(4); string(got) != "\x00\x01\x02\xff" {
+		t.Errorf("ReadData = %q", got)
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything was read; one more byte overflows
+	if _, err := r.ReadByte(); err == nil || r.Err() == nil {
+		t.Error("read past end didn't report overflow")
+	}
+}
+
+func TestMsgWriterCopyBits(t *testing.T) {
+	src := NewMsgWriter()
+	src.WriteBits(5, 3)
+	src.WriteLong(0xdeadbeef)
+	src.WriteShort(99) // not copied
+
+	w := NewMsgWriter()
+	w.CopyBits(src.Bytes(), src.Bits()-shortBits(99))
+	w.WriteShort(100)
+
+	r := NewMsgReader(w.Bytes())
+	if r.ReadBits(3) != 5 || r.ReadLong() != 0xdeadbeef || r.ReadShort() != 100 {
+		t.Fatal("copied bits don't read back")
+	}
+}
+
+// shortBits returns how many bits WriteShort uses for v.
+func shortBits(v int) int {
+	w := NewMsgWriter()
+	w.WriteShort(v)
+	return w.Bits()
+}
+
+func randomEntity(rng *rand.Rand, number int) *EntityState {
+	es := &EntityState{Number: number}
+	for i, bits := range entityFieldBits {
+		switch {
+		case rng.Intn(3) == 0:
+			// unchanged from zero
+		case bits == 0 && rng.Intn(2) == 0:
+			es.Fields[i] = math.Float32bits(float32(rng.Intn(8000) - 4000)) // integral
+		case bits == 0:
+			es.Fields[i] = math.Float32bits(rng.Float32()*1000 - 500)
+		default:
+			es.Fields[i] = uint32(rng.Int63()) & (1<<uint(bits) - 1)
+		}
+	}
+	return es
+}
+
+func TestMsgWriterDeltaEntityRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for iter := 0; iter < 200; iter++ {
+		from := randomEntity(rng, rng.Intn(maxGentities-1))
+		to := randomEntity(rng, from.Number)
+		if iter%5 == 0 {
+			to = &EntityState{Number: from.Number, Fields: from.Fields}
+			to.Fields[rng.Intn(numEntityFields)] = 0
+		}
+
+		w := NewMsgWriter()
+		w.WriteDeltaEntity(from, to, true)
+		r := NewMsgReader(w.Bytes())
+		if num := r.ReadBits(gentitynumBits); num != to.Number {
+			t.Fatalf("iter %d: entity number %d, want %d", iter, num, to.Number)
+		}
+		got := *from
+		if !readEntityDelta(r, &got) {
+			t.Fatalf("iter %d: delta read as a removal", iter)
+		}
+		if got.Fields != to.Fields {
+			t.Fatalf("iter %d: fields differ\n got %v\nwant %v", iter, got.Fields, to.Fields)
+		}
+		if r.Overflowed() {
+			t.Fatalf("iter %d: reader overflowed", iter)
+		}
+	}
+}
+
+func TestMsgWriterDeltaEntityRemoveAndUnchanged(t *testing.T) {
+	es := &EntityState{Number: 37}
+	es.Fields[0] = 1234
+
+	w := NewMsgWriter()
+	w.WriteDeltaEntity(es, nil, false)
+	w.WriteDeltaEntity(es, es, true)
+	w.WriteDeltaEntity(es, es, false) // writes nothing
+	w.WriteBits(maxGentities-1, gentitynumBits)
+
+	r := NewMsgReader(w.Bytes())
+	if r.ReadBits(gentitynumBits) != 37 || readEntityDelta(r, nil) {
+		t.Fatal("removal didn't round trip")
+	}
+	got := *es
+	if r.ReadBits(gentitynumBits) != 37 || !readEntityDelta(r, &got) || got != *es {
+		t.Fatal("forced unchanged entity didn't round trip")
+	}
+	if r.ReadBits(gentitynumBits) != maxGentities-1 {
+		t.Fatal("unforced unchanged entity was written")
+	}
+}
+
+func TestMsgWriterDeltaPlayerstateRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	random := func() *PlayerState {
+		ps := &PlayerState{}
+		for i, bits := range playerFieldBits {
+			switch {
+			case rng.Intn(3) == 0:
+			case bits == 0 && rng.Intn(2) == 0:
+				ps.Fields[i] = math.Float32bits(float32(rng.Intn(8000) - 4000))
+			case bits == 0:
+				ps.Fields[i] = math.Float32bits(rng.Float32()*1000 - 500)
+			case bits < 0:
+				ps.Fields[i] = uint32(int32(signExtend(rng.Intn(1<<uint(-bits)), -bits)))
+			default:
+				ps.Fields[i] = uint32(rng.Int63()) & (1<<uint(bits) - 1)
+			}
+		}
+		for _, arr := range []*[16]int{&ps.Stats, &ps.Persistant, &ps.Ammo} {
+			for i := range arr {
+				if rng.Intn(2) == 0 {
+					arr[i] = rng.Intn(1<<16) - 1<<15
+				}
+			}
+		}
+		for i := range ps.Powerups {
+			if rng.Intn(4) == 0 {
+				ps.Powerups[i] = int(rng.Int31()) - 1<<30
+			}
+		}
+		return ps
+	}
+
+	for iter := 0; iter < 200; iter++ {
+		from, to := random(), random()
+		if iter%4 == 0 {
+			c := *from
+			to = &c // unchanged: only the field count and arrays flag
+		}
+		w := NewMsgWriter()
+		w.WriteDeltaPlayerstate(from, to)
+		r := NewMsgReader(w.Bytes())
+		got := *from
+		readPlayerDelta(r, &got)
+		if got != *to {
+			t.Fatalf("iter %d: playerstate differs\n got %+v\nwant %+v", iter, got, *to)
+		}
+		if r.Overflowed() {
+			t.Fatalf("iter %d: reader overflowed", iter)
+		}
+	}
+}
+
+func TestSnapshotEncoderRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	enc, dec := NewSnapshotEncoder(ProtocolQ3), NewSnapshotDecoder(ProtocolQ3)
+	entities := make(map[int]*EntityState)
+	players := make(map[int]*PlayerState)
+	for frame := 0; frame < 20; frame++ {
+		// Entities come and go, and change between frames
+		for i := 0; i < 5; i++ {
+			num := rng.Intn(64)
+			if _, ok := entities[num]; ok && rng.Intn(2) == 0 {
+				delete(entities, num)
+			} else {
+				entities[num] = randomEntity(rng, num)
+			}
+		}
+		c := rng.Intn(8)
+		if _, ok := players[c]; ok && rng.Intn(3) == 0 {
+			delete(players, c)
+		} else {
+			ps := &PlayerState{ClientNum: c}
+			ps.Fields[0] = uint32(frame * 50)
+			ps.Stats[rng.Intn(maxStats)] = rng.Intn(200) - 100
+			players[c] = ps
+		}
+
+		snap := &Snapshot{
+			ServerTime:    1000 + frame*50,
+			Entities:      make(map[int]*EntityState),
+			Players:       make(map[int]*PlayerState),
+			Configstrings: make(map[int]string),
+		}
+		for num, es := range entities {
+			c := *es
+			snap.Entities[num] = &c
+			snap.EntityMask[num>>3] |= 1 << uint(num&7)
+		}
+		for num, ps := range players {
+			c := *ps
+			snap.Players[num] = &c
+		}
+		if frame%3 == 0 {
+			snap.Configstrings[csPlayers+c] = `\n\Player\t\0`
+			snap.Commands = []ServerCommand{{Target: serverCommandBroadcast, Text: `print "hi"`}}
+		}
+
+		got, err := dec.Decode(enc.Encode(snap))
+		if err != nil {
+			t.Fatalf("frame %d: %v", frame, err)
+		}
+		if !reflect.DeepEqual(got, snap) {
+			t.Fatalf("frame %d: snapshot differs\n got %+v\nwant %+v", frame, got, snap)
+		}
+	}
+}
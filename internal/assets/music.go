@@ -0,0 +1,125 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Music policies: what a map pk3 does with the map's music, which at 22kHz
+// stereo WAV is often most of the pk3
+const (
+	MusicKeep    = "keep"    // include it as is (the default)
+	MusicExclude = "exclude" // leave it out; the map plays silently
+	MusicOgg     = "ogg"     // re-encode it to Ogg Vorbis
+)
+
+// defaultMusicBitrate is the Ogg Vorbis bitrate, in kbit/s, music is
+// re-encoded at when MapPakOptions doesn't set one.
+const defaultMusicBitrate = 96
+
+// musicEncoder is the program re-encoding uses. It must be on the PATH.
+const musicEncoder = "ffmpeg"
+
+// ParseMusicPolicy validates a music policy name; empty means MusicKeep.
+func ParseMusicPolicy(s string) (string, error) {
+	switch s = strings.ToLower(s); s {
+	case "", MusicKeep:
+		return MusicKeep, nil
+	case MusicExclude, MusicOgg:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown music policy %q (want %s, %s, or %s)", s, MusicKeep, MusicExclude, MusicOgg)
+}
+
+// splitMusic separates a map pk3's music from its other files, unless the
+// policy keeps music as is.
+func splitMusic(paths []string, deps *depSet, opts MapPakOptions) ([]string, []string) {
+	if opts.Music == "" || opts.Music == MusicKeep {
+		return paths, nil
+	}
+	var kept, music []string
+	for _, p := range paths {
+		if e, ok := deps.reason(p); ok && e.Kind == "music" {
+			music = append(music, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	sort.Strings(music)
+	return kept, music
+}
+
+// checkMusicEncoder returns an error if the policy needs an encoder that
+// isn't installed, so builds fail before writing anything.
+func checkMusicEncoder(opts MapPakOptions) error {
+	if opts.Music != MusicOgg {
+		return nil
+	}
+	if _, err := exec.LookPath(musicEncoder); err != nil {
+		return fmt.Errorf("music policy %s needs %s: %w", MusicOgg, musicEncoder, err)
+	}
+	return nil
+}
+
+// writeEncodedMusic re-encodes each music file to Ogg Vorbis beside its
+// original name (music/foo.wav becomes music/foo.ogg) and adds it to a map
+// pk3 being written. ioquake3-based clients look for the .ogg when the map
+// asks for the .wav. Music that fails to encode is written as it was.
+func writeEncodedMusic(pw *Pk3Writer, music []string, gm *GameManifest, opts MapPakOptions) ([]writtenFile, error) {
+	bitrate := opts.MusicBitrate
+	if bitrate <= 0 {
+		bitrate = defaultMusicBitrate
+	}
+	var written []writtenFile
+	for _, p := range music {
+		data, err := readFileFromIndex(p, gm.FileIndex)
+		if err != nil {
+			return nil, err
+		}
+		name, original := p, ""
+		if strings.ToLower(path.Ext(p)) != ".ogg" {
+			encoded, err := encodeOgg(data, bitrate)
+			if err == nil {
+				name, original, data = strings.TrimSuffix(p, path.Ext(p))+".ogg", p, encoded
+			} else {
+				log.Printf("Warning: failed to re-encode %s, keeping it as is: %v", p, err)
+			}
+		}
+		if err := pw.AddEntry(name, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		written = append(written, writtenFile{
+			Path:     name,
+			Source:   gm.FileIndex[p],
+			Size:     int64(len(data)),
+			SHA256:   hex.EncodeToString(sum[:]),
+			Original: original,
+		})
+	}
+	return written, nil
+}
+
+// encodeOgg re-encodes audio to Ogg Vorbis at a bitrate in kbit/s.
+func encodeOgg(data []byte, bitrate int) ([]byte, error) {
+	cmd := exec.Command(musicEncoder, "-v", "error", "-i", "pipe:0", "-vn",
+		"-c:a", "libvorbis", "-b:a", strconv.Itoa(bitrate)+"k", "-f", "ogg", "pipe:1")
+	cmd.Stdin = bytes.NewReader(data)
+	var out, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
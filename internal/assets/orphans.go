@@ -0,0 +1,145 @@
+package assets
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// orphanExtensions are the file types the orphan finder reports on.
+var orphanExtensions = map[string]bool{
+	".tga": true, ".jpg": true, ".png": true,
+	".wav": true, ".ogg": true, ".mp3": true,
+}
+
+// orphanExemptPrefixes hold files the game code loads by name, so no asset
+// references them.
+var orphanExemptPrefixes = []string{
+	"gfx/", "menu/", "ui/", "icons/", "sprites/",
+	"sound/player/", "sound/feedback/", "sound/weapons/", "sound/items/",
+	"sound/misc/", "sound/world/", "sound/teamplay/",
+}
+
+// OrphanOptions controls FindOrphans.
+type OrphanOptions struct {
+	// Strict counts only shaders that a map, model, or skin references (or
+	// that live under a code-loaded prefix). By default every defined shader
+	// counts as a reference to its textures, since game code can load
+	// shaders by name.
+	Strict bool
+}
+
+// OrphanReport lists textures and sounds nothing references.
+type OrphanReport struct {
+	Files          []OrphanFile
+	Size           int64            // uncompressed total
+	CompressedSize int64            // space reclaimed by removing them from their pk3s
+	ByPk3          map[string]int64 // pk3 → compressed size of its orphans
+}
+
+// OrphanFile is an unreferenced file and the pk3 supplying it.
+type OrphanFile struct {
+	Path           string
+	Pk3            string
+	Size           int64
+	CompressedSize int64
+}
+
+// FindOrphans reports the textures and sounds in a game's install that
+// nothing references. References are collected from every map (as BuildMapPak
+// resolves it), every MD3 model, every skin, and shader definitions. Files in
+// official and Trinity paks, and files game code loads by name, are never
+// reported. The pk3s in the manifest's file index must still be readable.
+func FindOrphans(gm *GameManifest, opts OrphanOptions) (*OrphanReport, error) {
+	referenced := newDepSet()
+	usedShaders := make(map[string]bool)
+	for shader := range gm.ShaderRefs {
+		usedShaders[shader] = true
+	}
+
+	for _, p := range sortedMapKeys(gm.FileIndex) {
+		switch {
+		case strings.HasPrefix(p, "maps/") && strings.HasSuffix(p, ".bsp"):
+			mapName := strings.TrimSuffix(strings.TrimPrefix(p, "maps/"), ".bsp")
+			files, _, err := resolveMapFiles(mapName, gm)
+			if err != nil {
+				continue // unreadable maps reference nothing
+			}
+			for f := range files.files {
+				referenced.files[f] = true
+			}
+		case strings.HasSuffix(p, ".md3"):
+			resolveModel(p, "", gm, referenced)
+		case strings.HasSuffix(p, ".skin"):
+			data, err := readFileFromIndex(p, gm.FileIndex)
+			if err != nil {
+				continue
+			}
+			textures, _ := ParseSkin(strings.NewReader(string(data)))
+			for _, tex := range textures {
+				lower := strings.ToLower(tex)
+				usedShaders[strings.TrimSuffix(lower, path.Ext(lower))] = true
+				resolveShaderTextures(tex, p, gm, referenced)
+			}
+		}
+	}
+
+	for shader := range gm.Shaders {
+		if !opts.Strict || usedShaders[shader] || isOrphanExempt(shader) {
+			for _, tex := range resolvedShaderTextures(shader, gm) {
+				referenced.files[tex] = true
+			}
+		}
+	}
+
+	candidates := make(map[string]map[string]bool) // pk3 → paths
+	for p, pk3 := range gm.FileIndex {
+		base := filepath.Base(pk3)
+		if referenced.files[p] || !orphanExtensions[path.Ext(p)] || isOrphanExempt(p) ||
+			gm.OfficialFiles[p] || gm.BaselineFiles[p] || IsOfficialPak(base) || IsTrinityPak(base) {
+			continue
+		}
+		if candidates[pk3] == nil {
+			candidates[pk3] = make(map[string]bool)
+		}
+		candidates[pk3][p] = true
+	}
+
+	report := &OrphanReport{ByPk3: make(map[string]int64)}
+	for _, pk3 := range sortedMapKeys(candidates) {
+		wanted := candidates[pk3]
+		err := IteratePk3Files(pk3, func(f *Pk3File) error {
+			lower := strings.ToLower(f.Name)
+			if !wanted[lower] {
+				return nil
+			}
+			delete(wanted, lower)
+			report.Files = append(report.Files, OrphanFile{
+				Path:           lower,
+				Pk3:            pk3,
+				Size:           f.Size,
+				CompressedSize: f.CompressedSize,
+			})
+			report.Size += f.Size
+			report.CompressedSize += f.CompressedSize
+			report.ByPk3[pk3] += f.CompressedSize
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", pk3, err)
+		}
+	}
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].Path < report.Files[j].Path })
+	return report, nil
+}
+
+func isOrphanExempt(p string) bool {
+	for _, prefix := range orphanExemptPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,355 @@
+package assets
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectMeta carries the HTTP metadata stored with a published object.
+type ObjectMeta struct {
+	ContentType  string
+	CacheControl string
+}
+
+// OutputBackend is a destination for build artifacts (local directory or object storage).
+type OutputBackend interface {
+	Put(name string, body io.ReadSeeker, meta ObjectMeta) error
+}
+
+const (
+	pk3ContentType      = "application/octet-stream"
+	manifestContentType = "application/json"
+	pk3CacheControl     = "public, max-age=3600"
+	manifestCacheCtrl   = "no-cache"
+)
+
+// ParseOutputBackend returns a backend for a destination string:
+// s3://bucket/prefix, gs://bucket/prefix, or a local directory path.
+// Object storage credentials come from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY
+// (and AWS_SESSION_TOKEN, AWS_REGION, S3_ENDPOINT for S3-compatible services).
+func ParseOutputBackend(dest string) (OutputBackend, error) {
+	scheme, rest, ok := strings.Cut(dest, "://")
+	if !ok {
+		return &LocalBackend{Dir: dest}, nil
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("missing bucket in %q", dest)
+	}
+
+	creds := S3Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for %s", scheme)
+	}
+
+	switch scheme {
+	case "s3":
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		endpoint := os.Getenv("S3_ENDPOINT")
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+		return NewS3Backend(endpoint, region, bucket, prefix, creds), nil
+	case "gs":
+		return NewGCSBackend(bucket, prefix, creds), nil
+	default:
+		return nil, fmt.Errorf("unsupported output scheme %q", scheme)
+	}
+}
+
+// LocalBackend writes objects under a directory.
+type LocalBackend struct {
+	Dir string
+}
+
+// Put writes the object to Dir/name via a temp file and rename.
+func (b *LocalBackend) Put(name string, body io.ReadSeeker, meta ObjectMeta) error {
+	dest := filepath.Join(b.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("create dir for %s: %w", name, err)
+	}
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tmp, err)
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// S3Credentials are static access keys for SigV4 signing.
+type S3Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// S3Backend uploads objects to an S3-compatible bucket using path-style
+// requests signed with AWS Signature Version 4.
+type S3Backend struct {
+	Endpoint string // e.g. https://s3.us-east-1.amazonaws.com
+	Region   string
+	Bucket   string
+	Prefix   string
+	Creds    S3Credentials
+	client   *http.Client
+}
+
+// NewS3Backend creates a backend for an S3-compatible endpoint.
+func NewS3Backend(endpoint, region, bucket, prefix string, creds S3Credentials) *S3Backend {
+	return &S3Backend{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Region:   region,
+		Bucket:   bucket,
+		Prefix:   strings.Trim(prefix, "/"),
+		Creds:    creds,
+		client:   &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+// NewGCSBackend creates a backend for Google Cloud Storage through its
+// S3-interoperable XML API, authenticated with HMAC keys.
+func NewGCSBackend(bucket, prefix string, creds S3Credentials) *S3Backend {
+	return NewS3Backend("https://storage.googleapis.com", "auto", bucket, prefix, creds)
+}
+
+// Put uploads an object with a single signed PUT.
+func (b *S3Backend) Put(name string, body io.ReadSeeker, meta ObjectMeta) error {
+	h := sha256.New()
+	size, err := io.Copy(h, body)
+	if err != nil {
+		return fmt.Errorf("hash %s: %w", name, err)
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind %s: %w", name, err)
+	}
+	payloadHash := hex.EncodeToString(h.Sum(nil))
+
+	key := name
+	if b.Prefix != "" {
+		key = b.Prefix + "/" + name
+	}
+	endpoint, err := url.Parse(b.Endpoint)
+	if err != nil {
+		return fmt.Errorf("parse endpoint: %w", err)
+	}
+	canonicalURI := "/" + s3EscapePath(b.Bucket) + "/" + s3EscapePath(key)
+
+	req, err := http.NewRequest(http.MethodPut, b.Endpoint+canonicalURI, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if meta.ContentType != "" {
+		req.Header.Set("Content-Type", meta.ContentType)
+	}
+	if meta.CacheControl != "" {
+		req.Header.Set("Cache-Control", meta.CacheControl)
+	}
+	b.sign(req, endpoint.Host, canonicalURI, payloadHash, time.Now().UTC())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign adds SigV4 headers to req.
+func (b *S3Backend) sign(req *http.Request, host, canonicalURI, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := map[string]string{
+		"host":                 host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if b.Creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.Creds.SessionToken)
+		headers["x-amz-security-token"] = b.Creds.SessionToken
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.Region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.Creds.SecretKey), date)
+	key = hmacSHA256(key, b.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.Creds.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath URI-encodes each path segment per SigV4 rules, keeping slashes.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = s3Escape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3CanonicalQuery returns a query string in SigV4 canonical form: encoded
+// parameters sorted by name, then value, each with an "=" even if empty.
+func s3CanonicalQuery(query url.Values) string {
+	var params [][2]string
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, [2]string{s3Escape(name), s3Escape(v)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape URI-encodes everything but SigV4's unreserved characters.
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for j := 0; j < len(s); j++ {
+		c := s[j]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// PublishBuild uploads every artifact listed in outputDir's manifest, then the
+// manifest itself, so readers never see a manifest referencing missing pk3s.
+func PublishBuild(outputDir string, backend OutputBackend) error {
+	manifestPath := filepath.Join(outputDir, "manifest.json")
+	manifest, err := LoadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(manifest.Artifacts))
+	for name := range manifest.Artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := publishFile(backend, filepath.Join(outputDir, filepath.FromSlash(name)), name,
+			ObjectMeta{ContentType: pk3ContentType, CacheControl: pk3CacheControl}); err != nil {
+			return err
+		}
+		log.Printf("Published %s", name)
+	}
+
+	// A signed build's artifact list goes ahead of the manifest, and the
+	// manifest's signature right after it
+	sumsPath := filepath.Join(outputDir, ArtifactSumsName)
+	for _, p := range []string{sumsPath, sumsPath + SignatureExt} {
+		if err := publishSignatureFile(backend, p); err != nil {
+			return err
+		}
+	}
+	if err := publishFile(backend, manifestPath, path.Base(manifestPath),
+		ObjectMeta{ContentType: manifestContentType, CacheControl: manifestCacheCtrl}); err != nil {
+		return err
+	}
+	if err := publishSignatureFile(backend, manifestPath+SignatureExt); err != nil {
+		return err
+	}
+	log.Printf("Published manifest.json (%d artifacts)", len(names))
+	return nil
+}
+
+// publishSignatureFile publishes one of a signed build's files beside the
+// manifest, if the build has it.
+func publishSignatureFile(backend OutputBackend, localPath string) error {
+	if _, err := os.Stat(localPath); err != nil {
+		return nil
+	}
+	return publishFile(backend, localPath, filepath.Base(localPath),
+		ObjectMeta{ContentType: "text/plain; charset=utf-8", CacheControl: manifestCacheCtrl})
+}
+
+func publishFile(backend OutputBackend, localPath, name string, meta ObjectMeta) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", localPath, err)
+	}
+	defer f.Close()
+	if err := backend.Put(name, f, meta); err != nil {
+		return fmt.Errorf("publish %s: %w", name, err)
+	}
+	return nil
+}
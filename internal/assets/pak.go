@@ -0,0 +1,80 @@
+package assets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	pakMagic      = "PACK"
+	pakHeaderSize = 12 // magic(4) + dirofs(4) + dirlen(4)
+	pakEntrySize  = 64 // 56 bytes name + filepos(4) + filelen(4)
+)
+
+// isPakFile reports whether path is a Quake 1/2 style .pak archive.
+func isPakFile(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".pak")
+}
+
+// openPak opens a .pak archive as a zip.Reader, so every pk3 code path can
+// read it unchanged.
+func openPak(path string) (*pk3Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	entries, err := readPakDirectory(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	archive, err := openZipView(f, f, entries)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return archive, nil
+}
+
+func readPakDirectory(r io.ReaderAt, size int64) ([]zipViewEntry, error) {
+	header := make([]byte, pakHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("read pak header: %w", err)
+	}
+	if string(header[0:4]) != pakMagic {
+		return nil, fmt.Errorf("invalid pak magic: %q", header[0:4])
+	}
+	dirOffset := int64(binary.LittleEndian.Uint32(header[4:8]))
+	dirLength := int64(binary.LittleEndian.Uint32(header[8:12]))
+	count := dirLength / pakEntrySize
+	if dirOffset+dirLength > size {
+		return nil, fmt.Errorf("invalid pak directory: offset %d, length %d, file %d bytes", dirOffset, dirLength, size)
+	}
+
+	dir := make([]byte, count*pakEntrySize)
+	if _, err := r.ReadAt(dir, dirOffset); err != nil {
+		return nil, fmt.Errorf("read pak directory: %w", err)
+	}
+	entries := make([]zipViewEntry, 0, count)
+	for i := int64(0); i < count; i++ {
+		e := dir[i*pakEntrySize : (i+1)*pakEntrySize]
+		entry := zipViewEntry{
+			name:   strings.ReplaceAll(readNullTerminated(e[:56]), "\\", "/"),
+			offset: int64(binary.LittleEndian.Uint32(e[56:60])),
+			length: int64(binary.LittleEndian.Uint32(e[60:64])),
+		}
+		if entry.name == "" || entry.offset+entry.length > size {
+			return nil, fmt.Errorf("invalid pak entry %q", entry.name)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
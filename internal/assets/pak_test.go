@@ -0,0 +1,115 @@
+package assets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// makePak builds a .pak archive holding files in the given order.
+func makePak(names []string, files map[string][]byte) []byte {
+	var data bytes.Buffer
+	data.Write(make([]byte, pakHeaderSize))
+	dir := make([]byte, 0, len(names)*pakEntrySize)
+	for _, name := range names {
+		entry := make([]byte, pakEntrySize)
+		copy(entry[:56], name)
+		binary.LittleEndian.PutUint32(entry[56:60], uint32(data.Len()))
+		binary.LittleEndian.PutUint32(entry[60:64], uint32(len(files[name])))
+		dir = append(dir, entry...)
+		data.Write(files[name])
+	}
+	out := data.Bytes()
+	copy(out, pakMagic)
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)))
+	binary.LittleEndian.PutUint32(out[8:12], uint32(len(dir)))
+	return append(out, dir...)
+}
+
+func writeFixturePak(t *testing.T, path string, names []string, files map[string][]byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, makePak(names, files), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadPak(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pak0.pak")
+	writeFixturePak(t, path, []string{"sound\\misc\\menu1.wav", "gfx/conchars.tga", "maps/e1m1.bsp"}, map[string][]byte{
+		"sound\\misc\\menu1.wav": []byte("RIFF menu"),
+		"gfx/conchars.tga":       fixtureImage("conchars"),
+		"maps/e1m1.bsp":          {},
+	})
+
+	var names []string
+	if err := IteratePk3(path, func(name string, _ func() (io.ReadCloser, error)) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sound/misc/menu1.wav", "gfx/conchars.tga", "maps/e1m1.bsp"}; !slices.Equal(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+	for name, want := range map[string]string{"SOUND/misc/menu1.wav": "RIFF menu", "maps/e1m1.bsp": ""} {
+		data, err := ReadFileFromPk3(path, name)
+		if err != nil {
+			t.Errorf("read %s: %v", name, err)
+		} else if string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+	if _, err := ReadFileFromPk3(path, "maps/e1m2.bsp"); err == nil {
+		t.Error("read a file the pak doesn't hold")
+	}
+}
+
+func TestReadPakDirectoryInvalid(t *testing.T) {
+	good := makePak([]string{"a.txt"}, map[string][]byte{"a.txt": []byte("abc")})
+	badMagic := slices.Clone(good)
+	copy(badMagic, "PK\x03\x04")
+	badDir := slices.Clone(good)
+	binary.LittleEndian.PutUint32(badDir[8:12], pakEntrySize*4)
+	badEntry := slices.Clone(good)
+	binary.LittleEndian.PutUint32(badEntry[len(badEntry)-4:], 1000)
+
+	for name, data := range map[string][]byte{
+		"magic":     badMagic,
+		"directory": badDir,
+		"entry":     badEntry,
+		"truncated": good[:8],
+	} {
+		if _, err := readPakDirectory(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+	if _, err := readPakDirectory(bytes.NewReader(good), int64(len(good))); err != nil {
+		t.Errorf("valid pak: %v", err)
+	}
+}
+
+func TestCollectPk3FilesFromDirPak(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"pak1.pk3", "pak0.pk3", "aaa.pk3"} {
+		writeFixturePk3(t, filepath.Join(dir, name), map[string][]byte{"a.txt": []byte(name)})
+	}
+	for _, name := range []string{"pak0.pak", "pak1.pak"} {
+		writeFixturePak(t, filepath.Join(dir, name), []string{"a.txt"}, map[string][]byte{"a.txt": []byte(name)})
+	}
+
+	// Only pak0-9.pk3 load first; .pak archives sort with the other pk3s
+	var got []string
+	for _, path := range collectPk3FilesFromDir(dir) {
+		got = append(got, filepath.Base(path))
+	}
+	if want := []string{"pak0.pk3", "pak1.pk3", "aaa.pk3", "pak0.pak", "pak1.pak"}; !slices.Equal(got, want) {
+		t.Errorf("load order = %v, want %v", got, want)
+	}
+}
@@ -2,19 +2,48 @@ package assets
 
 import (
 	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 )
 
+// baseGames are the stock game directories; anything else is treated as a mod
+// layered over baseq3.
+var baseGames = []string{"baseq3", "missionpack"}
+
+// IsBaseGame reports whether game is a stock game directory rather than a mod.
+func IsBaseGame(game string) bool {
+	for _, g := range baseGames {
+		if g == game {
+			return true
+		}
+	}
+	return false
+}
+
 // CollectGamePk3s returns game dir name → ordered pk3 paths for each game directory
-// found under quake3Dir (e.g. "baseq3", "missionpack").
+// found under quake3Dir: "baseq3", "missionpack", and any mod directories
+// (e.g. "cpma", "defrag") that contain pk3s. For a Quake Live install,
+// Steam workshop pk3s are loaded after baseq3's own pk3s.
 func CollectGamePk3s(quake3Dir string) map[string][]string {
 	result := make(map[string][]string)
-	for _, subdir := range []string{"baseq3", "missionpack"} {
+	subdirs := append([]string(nil), baseGames...)
+	if entries, err := os.ReadDir(quake3Dir); err == nil {
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") && !IsBaseGame(e.Name()) {
+				subdirs = append(subdirs, e.Name())
+			}
+		}
+	}
+	for _, subdir := range subdirs {
 		dir := filepath.Join(quake3Dir, subdir)
 		if _, err := os.Stat(dir); os.IsNotExist(err) {
 			continue
@@ -24,11 +53,38 @@ func CollectGamePk3s(quake3Dir string) map[string][]string {
 			result[subdir] = files
 		}
 	}
+	if IsQuakeLiveDir(quake3Dir) {
+		if workshop := CollectWorkshopPk3s(quake3Dir); len(workshop) > 0 {
+			result["baseq3"] = append(result["baseq3"], workshopPk3sInLoadOrder(workshop)...)
+		}
+	}
 	return result
 }
 
+// orderGames sorts game names into build order: baseq3, missionpack, then mods alphabetically.
+func orderGames(games []string) []string {
+	present := make(map[string]bool, len(games))
+	var mods []string
+	for _, g := range games {
+		present[g] = true
+		if !IsBaseGame(g) {
+			mods = append(mods, g)
+		}
+	}
+	var order []string
+	for _, g := range baseGames {
+		if present[g] {
+			order = append(order, g)
+		}
+	}
+	sort.Strings(mods)
+	return append(order, mods...)
+}
+
 // collectPk3FilesFromDir collects pk3 files from a directory in Quake 3 load order:
-// pak0-9 first (numerically), then other pk3s alphabetically.
+// pak0-9 first (numerically), then other pk3s alphabetically. Quake 1/2 style
+// .pak archives, pak0.pak included, are read-only sources sorted in with the
+// other pk3s.
 func collectPk3FilesFromDir(dir string) []string {
 	var pakFiles []string
 	var otherFiles []string
@@ -37,7 +93,7 @@ func collectPk3FilesFromDir(dir string) []string {
 		if err != nil || d.IsDir() {
 			return nil
 		}
-		if !strings.HasSuffix(strings.ToLower(d.Name()), ".pk3") {
+		if !strings.HasSuffix(strings.ToLower(d.Name()), ".pk3") && !isPakFile(d.Name()) {
 			return nil
 		}
 
@@ -45,7 +101,7 @@ func collectPk3FilesFromDir(dir string) []string {
 		lowerName := strings.ToLower(name)
 
 		isRootLevel := filepath.Dir(path) == dir
-		if isRootLevel && strings.HasPrefix(lowerName, "pak") && len(lowerName) == 8 {
+		if isRootLevel && strings.HasPrefix(lowerName, "pak") && strings.HasSuffix(lowerName, ".pk3") && len(lowerName) == 8 {
 			numChar := lowerName[3]
 			if numChar >= '0' && numChar <= '9' {
 				pakFiles = append(pakFiles, path)
@@ -66,7 +122,7 @@ func collectPk3FilesFromDir(dir string) []string {
 
 // ReadFileFromPk3 reads a single file from a pk3 archive.
 func ReadFileFromPk3(pk3Path, virtualPath string) ([]byte, error) {
-	r, err := zip.OpenReader(pk3Path)
+	r, err := openPk3(pk3Path)
 	if err != nil {
 		return nil, fmt.Errorf("open pk3 %s: %w", pk3Path, err)
 	}
@@ -88,18 +144,28 @@ func ReadFileFromPk3(pk3Path, virtualPath string) ([]byte, error) {
 
 // WritePk3 creates a pk3 (zip) file with the given files using Deflate compression.
 func WritePk3(outputPath string, files map[string][]byte) error {
+	return writePk3(outputPath, files, "")
+}
+
+// writePk3 is WritePk3 at a Pk3 compression level.
+func writePk3(outputPath string, files map[string][]byte, compression string) error {
 	f, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("create %s: %w", outputPath, err)
 	}
 	defer f.Close()
 
-	return WritePk3ToWriter(f, files)
+	return writePk3ToWriter(f, files, compression)
 }
 
 // WritePk3ToWriter writes a pk3 (zip) to the given writer using Deflate compression.
 func WritePk3ToWriter(w io.Writer, files map[string][]byte) error {
-	zw := zip.NewWriter(w)
+	return writePk3ToWriter(w, files, "")
+}
+
+func writePk3ToWriter(w io.Writer, files map[string][]byte, compression string) error {
+	pw := NewPk3Writer(w)
+	pw.SetCompression(compression)
 
 	// Sort keys for deterministic output
 	keys := make([]string, 0, len(files))
@@ -109,25 +175,284 @@ func WritePk3ToWriter(w io.Writer, files map[string][]byte) error {
 	sort.Strings(keys)
 
 	for _, name := range keys {
-		header := &zip.FileHeader{
-			Name:   name,
-			Method: zip.Deflate,
+		if err := pw.AddEntry(name, bytes.NewReader(files[name])); err != nil {
+			return err
 		}
-		fw, err := zw.CreateHeader(header)
-		if err != nil {
-			return fmt.Errorf("create entry %s: %w", name, err)
+	}
+
+	return pw.Close()
+}
+
+// Pk3Writer writes a pk3 one entry at a time, streaming each entry's data, so
+// memory use doesn't grow with the size of the pk3. Entries are Deflate with
+// no timestamps, like WritePk3; add them in sorted order for the same
+// deterministic output.
+//
+// The engine can't read zip64, so a pk3 that would need it, with too many
+// entries or past 4 GB, is an ErrZip64 error unless AllowZip64 was called.
+type Pk3Writer struct {
+	zw      *zip.Writer
+	out     *countingWriter
+	names   map[string]bool
+	entries int   // entries written, counting duplicate names
+	dir     int64 // size of the central directory so far
+	method  uint16
+	zip64   bool
+}
+
+// Limits of a zip without zip64 records: entry count, and any size or
+// offset. Variables so tests can lower them.
+var (
+	pk3MaxEntries       = 0xFFFF - 1
+	pk3MaxSize    int64 = 0xFFFFFFFF - 1
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewPk3Writer returns a Pk3Writer writing to w.
+func NewPk3Writer(w io.Writer) *Pk3Writer {
+	out := &countingWriter{w: w}
+	return &Pk3Writer{zw: zip.NewWriter(out), out: out, names: make(map[string]bool), method: zip.Deflate}
+}
+
+// AllowZip64 lets the pk3 grow past the engine's limits, written with zip64
+// records, for tools that read it with a full zip reader.
+func (pw *Pk3Writer) AllowZip64() {
+	pw.zip64 = true
+}
+
+// Fits reports whether an entry of size bytes, uncompressed, can be added
+// without the pk3 needing zip64. It's conservative: it allows for Deflate
+// expanding incompressible data.
+func (pw *Pk3Writer) Fits(name string, size int64) bool {
+	if pw.entries >= pk3MaxEntries {
+		return false
+	}
+	pw.zw.Flush()
+	// Headers and the directory to come, with slack for Deflate's worst case
+	// and the data descriptor
+	end := pw.out.n + int64(zipLocalHeaderSize+len(name)) + size + size/1000 + 1024 +
+		pw.dir + int64(zipCentralHeaderSize+len(name)) + zipEndSize
+	return size <= pk3MaxSize && end <= pk3MaxSize
+}
+
+// Pk3 compression levels. The engine reads only Deflate and stored entries.
+const (
+	CompressionDefault = "default" // Deflate at its default level
+	CompressionBest    = "best"    // smallest pk3s, slowest to write
+	CompressionFast    = "fast"
+	CompressionStore   = "store" // no compression: largest, but the cheapest to write and load
+)
+
+// ParseCompression validates a compression level name; empty means
+// CompressionDefault.
+func ParseCompression(s string) (string, error) {
+	switch s = strings.ToLower(s); s {
+	case "":
+		return CompressionDefault, nil
+	case CompressionDefault, CompressionBest, CompressionFast, CompressionStore:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown compression %q (want %s, %s, %s, or %s)", s, CompressionDefault, CompressionBest, CompressionFast, CompressionStore)
+}
+
+// SetCompression sets how the pk3's entries are compressed; call it before
+// adding any. Unknown levels, and empty, mean CompressionDefault.
+func (pw *Pk3Writer) SetCompression(compression string) {
+	pw.method = zip.Deflate
+	switch compression {
+	case CompressionStore:
+		pw.method = zip.Store
+	case CompressionBest:
+		pw.setFlateLevel(flate.BestCompression)
+	case CompressionFast:
+		pw.setFlateLevel(flate.BestSpeed)
+	}
+}
+
+// setFlateLevel sets the Deflate level, as compress/flate numbers them.
+func (pw *Pk3Writer) setFlateLevel(level int) {
+	pw.zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+}
+
+// AddEntry writes an entry with the contents of r. Adding a name twice is an error.
+func (pw *Pk3Writer) AddEntry(name string, r io.Reader) error {
+	if pw.names[name] {
+		return fmt.Errorf("duplicate entry %s", name)
+	}
+	return pw.add(name, func() (io.Writer, error) {
+		return pw.zw.CreateHeader(&zip.FileHeader{Name: name, Method: pw.method})
+	}, r)
+}
+
+// addRaw writes an entry whose data r holds already compressed, as
+// header describes it, so it's copied without recompressing. Unlike
+// AddEntry, it allows a name twice, for copying pk3s that have duplicates.
+func (pw *Pk3Writer) addRaw(header *zip.FileHeader, r io.Reader) error {
+	if !pw.zip64 && header.UncompressedSize64 > uint64(pk3MaxSize) {
+		return fmt.Errorf("%w: %s is over 4 GB", ErrZip64, header.Name)
+	}
+	return pw.add(header.Name, func() (io.Writer, error) {
+		return pw.zw.CreateRaw(header)
+	}, r)
+}
+
+// add creates an entry with create and copies r into it, checking it keeps
+// the pk3 within the engine's limits.
+func (pw *Pk3Writer) add(name string, create func() (io.Writer, error), r io.Reader) error {
+	if !pw.zip64 && pw.entries >= pk3MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrZip64, pk3MaxEntries)
+	}
+	pw.names[name] = true
+	pw.entries++
+	pw.dir += int64(zipCentralHeaderSize + len(name))
+	fw, err := create()
+	if err != nil {
+		return fmt.Errorf("create entry %s: %w", name, err)
+	}
+	n, err := io.Copy(fw, r)
+	if err != nil {
+		return fmt.Errorf("write entry %s: %w", name, err)
+	}
+	if !pw.zip64 {
+		pw.zw.Flush()
+		if n > pk3MaxSize || pw.out.n > pk3MaxSize {
+			return fmt.Errorf("%w: %s ends past 4 GB", ErrZip64, name)
+		}
+	}
+	return nil
+}
+
+// Close writes the zip central directory. It doesn't close the underlying writer.
+func (pw *Pk3Writer) Close() error {
+	if err := pw.zw.Close(); err != nil {
+		return err
+	}
+	if !pw.zip64 && pw.out.n > pk3MaxSize {
+		return fmt.Errorf("%w: central directory ends past 4 GB", ErrZip64)
+	}
+	return nil
+}
+
+// WritePk3FromIndex writes the given files to a pk3, streaming each from the
+// source pk3 the file index maps it to, so large files are never held in
+// memory. Entry names are the lowered paths, sorted, so output matches
+// extracting the files and calling WritePk3. Paths missing from the index are
+// skipped. Returns the number of files written.
+func WritePk3FromIndex(outputPath string, paths []string, fileIndex map[string]string) (int, error) {
+	return writePk3FromIndex(outputPath, paths, fileIndex, "", nil)
+}
+
+// writtenFile is a file WritePk3FromIndex wrote, with its SHA-256.
+type writtenFile struct {
+	Path   string
+	Source string
+	Size   int64
+	SHA256 string
+
+	Original   string // file this was re-encoded from, if any
+	Downscaled bool   // halved to fit a size budget
+}
+
+// writePk3FromIndex is WritePk3FromIndex at a compression level, with a hook
+// to add entries after the files, given what was written. Files are only
+// hashed if trailer is set.
+func writePk3FromIndex(outputPath string, paths []string, fileIndex map[string]string, compression string, trailer func(pw *Pk3Writer, files []writtenFile) error) (int, error) {
+	sources := make(map[string]map[string]*zip.File) // pk3 → lowered name → entry
+	var archives []*pk3Archive
+	defer func() {
+		for _, r := range archives {
+			r.Close()
+		}
+	}()
+	entries := make(map[string]*zip.File)
+	for _, p := range paths {
+		lower := strings.ToLower(p)
+		pk3Path, ok := fileIndex[lower]
+		if !ok {
+			continue
+		}
+		if _, ok := sources[pk3Path]; !ok {
+			r, err := openPk3(pk3Path)
+			if err != nil {
+				return 0, fmt.Errorf("open pk3 %s: %w", pk3Path, err)
+			}
+			archives = append(archives, r)
+			// The last matching entry wins, as in BuildFileIndex
+			files := make(map[string]*zip.File, len(r.File))
+			for _, f := range r.File {
+				files[strings.ToLower(f.Name)] = f
+			}
+			sources[pk3Path] = files
 		}
-		if _, err := fw.Write(files[name]); err != nil {
-			return fmt.Errorf("write entry %s: %w", name, err)
+		f, ok := sources[pk3Path][lower]
+		if !ok {
+			return 0, fmt.Errorf("%s not found in %s", lower, pk3Path)
 		}
+		entries[lower] = f
 	}
 
-	return zw.Close()
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return 0, fmt.Errorf("create %s: %w", outputPath, err)
+	}
+	defer out.Close()
+
+	names := mapKeys(entries)
+	sort.Strings(names)
+	pw := NewPk3Writer(out)
+	pw.SetCompression(compression)
+	var written []writtenFile
+	for _, name := range names {
+		rc, err := entries[name].Open()
+		if err != nil {
+			return 0, fmt.Errorf("open %s in %s: %w", name, fileIndex[name], err)
+		}
+		var r io.Reader = rc
+		h := sha256.New()
+		if trailer != nil {
+			r = io.TeeReader(rc, h)
+		}
+		err = pw.AddEntry(name, r)
+		rc.Close()
+		if err != nil {
+			return 0, err
+		}
+		if trailer != nil {
+			written = append(written, writtenFile{
+				Path:   name,
+				Source: fileIndex[name],
+				Size:   int64(entries[name].UncompressedSize64),
+				SHA256: hex.EncodeToString(h.Sum(nil)),
+			})
+		}
+	}
+	if trailer != nil {
+		if err := trailer(pw, written); err != nil {
+			return 0, err
+		}
+	}
+	if err := pw.Close(); err != nil {
+		return 0, err
+	}
+	return len(names), out.Close()
 }
 
 // IteratePk3 iterates over entries in a pk3 file, calling fn for each entry.
 func IteratePk3(pk3Path string, fn func(name string, open func() (io.ReadCloser, error)) error) error {
-	r, err := zip.OpenReader(pk3Path)
+	r, err := openPk3(pk3Path)
 	if err != nil {
 		return fmt.Errorf("open pk3 %s: %w", pk3Path, err)
 	}
@@ -141,30 +466,146 @@ func IteratePk3(pk3Path string, fn func(name string, open func() (io.ReadCloser,
 	return nil
 }
 
+// Pk3File describes a pk3 entry without reading it.
+type Pk3File struct {
+	Name           string
+	Size           int64
+	CompressedSize int64
+	Open           func() (io.ReadCloser, error)
+}
+
+// IteratePk3Files is like IteratePk3 but also reports entry sizes. Directory
+// entries are skipped.
+func IteratePk3Files(pk3Path string, fn func(f *Pk3File) error) error {
+	r, err := openPk3(pk3Path)
+	if err != nil {
+		return fmt.Errorf("open pk3 %s: %w", pk3Path, err)
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		entry := &Pk3File{
+			Name:           f.Name,
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			Open:           f.Open,
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // BuildFileIndex builds a case-insensitive file index across all pk3s for a game.
 // Later pk3s override earlier ones. Returns lowered path → source pk3 path.
 func BuildFileIndex(pk3Paths []string) (map[string]string, error) {
+	index, _, quarantined := buildFileIndexNames(pk3Paths)
+	if len(quarantined) > 0 {
+		return nil, fmt.Errorf("open pk3 %s: %s", quarantined[0].Path, quarantined[0].Error)
+	}
+	return index, nil
+}
+
+// BuildFileIndexTolerant is like BuildFileIndex but skips pk3s that can't be
+// opened, returning them as quarantined instead of failing the whole index.
+func BuildFileIndexTolerant(pk3Paths []string) (map[string]string, []QuarantinedPk3) {
+	index, _, quarantined := buildFileIndexNames(pk3Paths)
+	return index, quarantined
+}
+
+// buildFileIndexNames is BuildFileIndexTolerant that also returns the
+// original entry name of each indexed path whose name isn't all lowercase.
+func buildFileIndexNames(pk3Paths []string) (map[string]string, map[string]string, []QuarantinedPk3) {
 	index := make(map[string]string)
+	names := make(map[string]string)
+	var quarantined []QuarantinedPk3
 	for _, pk3Path := range pk3Paths {
-		r, err := zip.OpenReader(pk3Path)
+		r, err := openPk3(pk3Path)
 		if err != nil {
-			return nil, fmt.Errorf("open pk3 %s: %w", pk3Path, err)
+			quarantined = append(quarantined, QuarantinedPk3{Path: pk3Path, Error: err.Error()})
+			continue
 		}
 		for _, f := range r.File {
 			if f.FileInfo().IsDir() {
 				continue
 			}
-			index[strings.ToLower(f.Name)] = pk3Path
+			lower := strings.ToLower(f.Name)
+			index[lower] = pk3Path
+			if f.Name != lower {
+				names[lower] = f.Name
+			} else {
+				delete(names, lower)
+			}
 		}
 		r.Close()
 	}
-	return index, nil
+	return index, names, quarantined
+}
+
+// maxValidatePk3Size caps a pk3's total uncompressed size in ValidatePk3, so
+// a compression bomb is rejected before it fills the disk.
+const maxValidatePk3Size = 4 << 30
+
+// ValidatePk3 checks that a pk3 from an untrusted source is safe to add to an
+// install: a readable zip whose entries have relative paths inside the game
+// directory, use compression the engine reads, aren't encrypted, and match
+// their CRCs.
+func ValidatePk3(pk3Path string) error {
+	r, err := zip.OpenReader(pk3Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var total uint64
+	files := 0
+	for _, f := range r.File {
+		if strings.ContainsAny(f.Name, "\\\x00:") || path.IsAbs(f.Name) || containsString(strings.Split(f.Name, "/"), "..") {
+			return fmt.Errorf("unsafe path %q", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.Flags&0x1 != 0 {
+			return fmt.Errorf("%s is encrypted", f.Name)
+		}
+		if f.Method != zip.Store && f.Method != zip.Deflate {
+			return fmt.Errorf("%s uses compression method %d, which the engine can't read", f.Name, f.Method)
+		}
+		if total += f.UncompressedSize64; total > maxValidatePk3Size {
+			return fmt.Errorf("uncompressed size over %s", formatSize(maxValidatePk3Size))
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		n, err := io.Copy(io.Discard, io.LimitReader(rc, int64(f.UncompressedSize64)+1))
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if uint64(n) != f.UncompressedSize64 {
+			return fmt.Errorf("%s: size doesn't match its header", f.Name)
+		}
+		files++
+	}
+	if files == 0 {
+		return fmt.Errorf("no files")
+	}
+	return nil
 }
 
-// IsOfficialPak returns true if the filename matches pak[0-9].pk3 (official id Software paks).
-// Excludes pak[0-9]t.pk3 (Trinity override paks).
+// IsOfficialPak returns true if the filename matches pak[0-9].pk3 (official id Software paks),
+// or is pak00.pk3, Quake Live's single pak. Excludes pak[0-9]t.pk3 (Trinity override paks).
 func IsOfficialPak(filename string) bool {
 	lower := strings.ToLower(filepath.Base(filename))
+	if lower == quakeLivePak {
+		return true
+	}
 	if len(lower) != 8 {
 		return false
 	}
@@ -202,7 +643,7 @@ func ExtractFilesFromPk3s(paths []string, fileIndex map[string]string) (map[stri
 			wanted[p] = true
 		}
 
-		r, err := zip.OpenReader(pk3Path)
+		r, err := openPk3(pk3Path)
 		if err != nil {
 			return nil, fmt.Errorf("open pk3 %s: %w", pk3Path, err)
 		}
@@ -0,0 +1,203 @@
+package assets
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Pk3DirOptions controls the path policy of ExtractPk3ToDir and BuildPk3FromDir.
+type Pk3DirOptions struct {
+	Lowercase    bool // lowercase every path
+	StripSources bool // skip editor/source files (.map, .xcf, .psd, ...)
+
+	// For BuildPk3FromDir, when the files won't fit in one pk3 the engine
+	// can read (see ErrZip64): Split continues in <out>_2.pk3, <out>_3.pk3,
+	// and so on, while Zip64 writes a single zip64 pk3 for other tools.
+	Split bool
+	Zip64 bool
+}
+
+// ExtractPk3ToDir unpacks a pk3 into dir. Entry paths keep their case unless
+// opts.Lowercase is set; when two entries collide the later one wins, as it
+// would in the engine. Junk entries (OS metadata) are skipped. The pk3 is
+// rejected without writing anything if an entry would land outside dir.
+// Returns the number of files written.
+func ExtractPk3ToDir(pk3Path, dir string, opts Pk3DirOptions) (int, error) {
+	r, err := openPk3(pk3Path)
+	if err != nil {
+		return 0, fmt.Errorf("open pk3 %s: %w", pk3Path, err)
+	}
+	defer r.Close()
+
+	keep := make(map[string]*zip.File)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := strings.ReplaceAll(f.Name, "\\", "/")
+		if isRepackJunk(name, RepackOptions{StripSources: opts.StripSources}) {
+			continue
+		}
+		if opts.Lowercase {
+			name = strings.ToLower(name)
+		}
+		if _, err := safeJoin(dir, name); err != nil {
+			return 0, err
+		}
+		keep[name] = f
+	}
+
+	names := mapKeys(keep)
+	sort.Strings(names)
+	for i, name := range names {
+		dest, _ := safeJoin(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return i, err
+		}
+		if err := extractZipFile(keep[name], dest); err != nil {
+			return i, fmt.Errorf("extract %s: %w", name, err)
+		}
+	}
+	return len(names), nil
+}
+
+func extractZipFile(f *zip.File, dest string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// BuildPk3FromDir packs every file under dir into a pk3 at outPath, with
+// slash-separated paths relative to dir. Output matches WritePk3 for the same
+// files: entries sorted, Deflate, and no timestamps, so packing the same tree
+// twice gives identical bytes. Junk files are skipped, as is outPath itself
+// if it lies under dir. Returns the number of entries written, across every
+// pk3 if opts.Split spread them over several.
+func BuildPk3FromDir(dir, outPath string, opts Pk3DirOptions) (int, error) {
+	absOut, err := filepath.Abs(outPath)
+	if err != nil {
+		return 0, err
+	}
+
+	files := make(map[string]string) // entry name → disk path
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if abs, err := filepath.Abs(path); err == nil && abs == absOut {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if isRepackJunk(name, RepackOptions{StripSources: opts.StripSources}) {
+			return nil
+		}
+		if opts.Lowercase {
+			name = strings.ToLower(name)
+		}
+		if prev, ok := files[name]; ok {
+			return fmt.Errorf("%s and %s both map to %s", prev, path, name)
+		}
+		files[name] = path
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("scan %s: %w", dir, err)
+	}
+
+	names := mapKeys(files)
+	sort.Strings(names)
+	parts := []string{outPath}
+	part, err := createPk3Part(outPath, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { part.file.Close() }()
+	for _, name := range names {
+		f, err := os.Open(files[name])
+		if err != nil {
+			return 0, err
+		}
+		if opts.Split && !opts.Zip64 && part.entries > 0 {
+			info, err := f.Stat()
+			if err == nil && !part.pw.Fits(name, info.Size()) {
+				if err := part.finish(); err != nil {
+					f.Close()
+					return 0, err
+				}
+				next := fmt.Sprintf("%s_%d.pk3", strings.TrimSuffix(outPath, filepath.Ext(outPath)), len(parts)+1)
+				parts = append(parts, next)
+				if part, err = createPk3Part(next, opts); err != nil {
+					f.Close()
+					return 0, err
+				}
+			}
+		}
+		err = part.pw.AddEntry(name, f)
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+		part.entries++
+	}
+	if err := part.finish(); err != nil {
+		return 0, err
+	}
+	if len(parts) > 1 {
+		log.Printf("Split into %d pk3s: %s", len(parts), strings.Join(parts, ", "))
+	}
+	return len(names), nil
+}
+
+// pk3Part is one pk3 BuildPk3FromDir is writing.
+type pk3Part struct {
+	path    string
+	file    *os.File
+	pw      *Pk3Writer
+	entries int
+}
+
+func createPk3Part(path string, opts Pk3DirOptions) (*pk3Part, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	pw := NewPk3Writer(f)
+	if opts.Zip64 {
+		pw.AllowZip64()
+	}
+	return &pk3Part{path: path, file: f, pw: pw}, nil
+}
+
+// finish writes the pk3's central directory and closes it.
+func (p *pk3Part) finish() error {
+	if err := p.pw.Close(); err != nil {
+		return fmt.Errorf("finish %s: %w", p.path, err)
+	}
+	return p.file.Close()
+}
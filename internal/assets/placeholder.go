@@ -0,0 +1,132 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"path"
+	"strings"
+
+	"github.com/ftrvxmtrx/tga"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Placeholder image layout: a magenta and black checkerboard, the usual
+// missing-texture pattern, with the missing path written across it
+const (
+	placeholderSize   = 256
+	placeholderSquare = 32
+	placeholderMargin = 8
+	placeholderLine   = 15 // line spacing for basicfont's 7x13 face
+)
+
+// missingTexturePlaceholders returns the placeholder files for a map's
+// unresolved textures and shaders, as image path → the shader or file that
+// references it. Placeholders are TGAs named after the missing image, which
+// the engine tries before the default image whatever extension the shader
+// asked for.
+func missingTexturePlaceholders(diags Diagnostics, gm *GameManifest) map[string]string {
+	placeholders := make(map[string]string)
+	for _, d := range diags {
+		if d.Kind != DiagMissingTexture && d.Kind != DiagMissingShader {
+			continue
+		}
+		name := d.Subject
+		if !strings.Contains(name, "/") || strings.HasPrefix(name, "$") {
+			continue
+		}
+		p := strings.TrimSuffix(name, path.Ext(name)) + ".tga"
+		if _, ok := gm.FileIndex[p]; ok {
+			continue
+		}
+		if _, ok := placeholders[p]; !ok {
+			from := d.Source
+			if d.Kind == DiagMissingShader {
+				from = shaderNode(name)
+			}
+			placeholders[p] = from
+		}
+	}
+	return placeholders
+}
+
+// writePlaceholders adds a placeholder for each of the map's missing images
+// to a map pk3 being written, recording why each was added in deps.
+func writePlaceholders(pw *Pk3Writer, deps *depSet, gm *GameManifest) ([]writtenFile, error) {
+	placeholders := missingTexturePlaceholders(deps.diags, gm)
+	var written []writtenFile
+	for _, p := range sortedMapKeys(placeholders) {
+		data, err := placeholderImage(p)
+		if err != nil {
+			return nil, fmt.Errorf("placeholder %s: %w", p, err)
+		}
+		if err := pw.AddEntry(p, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		deps.add(placeholders[p], "placeholder", p)
+		sum := sha256.Sum256(data)
+		written = append(written, writtenFile{Path: p, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+	}
+	return written, nil
+}
+
+// placeholderImage renders the placeholder for a missing image path as a TGA.
+func placeholderImage(name string) ([]byte, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, placeholderSize, placeholderSize))
+	magenta := image.NewUniform(color.NRGBA{255, 0, 255, 255})
+	black := image.NewUniform(color.NRGBA{0, 0, 0, 255})
+	for y := 0; y < placeholderSize; y += placeholderSquare {
+		for x := 0; x < placeholderSize; x += placeholderSquare {
+			square := image.Rect(x, y, x+placeholderSquare, y+placeholderSquare)
+			src := black
+			if (x/placeholderSquare+y/placeholderSquare)%2 == 0 {
+				src = magenta
+			}
+			draw.Draw(img, square, src, image.Point{}, draw.Src)
+		}
+	}
+
+	face := basicfont.Face7x13
+	perLine := (placeholderSize - 2*placeholderMargin) / face.Advance
+	lines := wrapPlaceholderText(strings.TrimSuffix(name, path.Ext(name)), perLine)
+	top := (placeholderSize-len(lines)*placeholderLine)/2 + face.Ascent
+	for i, line := range lines {
+		x := (placeholderSize - len(line)*face.Advance) / 2
+		y := top + i*placeholderLine
+		// Outline each line in black so it reads on both colors of square
+		for _, off := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}, {0, 0}} {
+			d := font.Drawer{Dst: img, Src: black, Face: face, Dot: fixed.P(x+off[0], y+off[1])}
+			if off == [2]int{0, 0} {
+				d.Src = image.White
+			}
+			d.DrawString(line)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tga.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// wrapPlaceholderText splits a path into lines of at most n characters,
+// breaking after slashes where it can.
+func wrapPlaceholderText(s string, n int) []string {
+	var lines []string
+	for len(s) > n {
+		cut := strings.LastIndex(s[:n], "/") + 1
+		if cut == 0 {
+			cut = n
+		}
+		lines = append(lines, s[:cut])
+		s = s[cut:]
+	}
+	return append(lines, s)
+}
@@ -0,0 +1,243 @@
+package assets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// playerParts are the md3s making up a player model, each with its own skins.
+var playerParts = []string{"head", "upper", "lower"}
+
+// playerSounds are the per-model sounds cgame loads for every player
+// (cg_customSoundNames), from sound/player/<model>/.
+var playerSounds = []string{
+	"death1", "death2", "death3", "jump1",
+	"pain25_1", "pain50_1", "pain75_1", "pain100_1",
+	"falling1", "gasp", "drown", "fall1", "taunt",
+}
+
+// footstepSounds maps animation.cfg footstep types to their sound names,
+// sound/player/footsteps/<name>1-4.wav.
+var footstepSounds = map[string]string{
+	"normal": "step",
+	"boot":   "boot",
+	"flesh":  "flesh",
+	"mech":   "mech",
+	"energy": "energy",
+}
+
+// AnimationConfig holds the settings at the top of a player model's
+// animation.cfg that change what cgame loads, before the animation frame
+// lines. The model's sex only picks obituary pronouns, so it isn't kept.
+type AnimationConfig struct {
+	Footsteps  string // a footstepSounds key
+	FixedLegs  bool
+	FixedTorso bool
+}
+
+// ParseAnimationConfig parses an animation.cfg's settings as cgame does:
+// unknown footstep types read as normal, and parsing stops at the first
+// frame line.
+func ParseAnimationConfig(r io.Reader) *AnimationConfig {
+	cfg := &AnimationConfig{Footsteps: "normal"}
+	var tokens []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "//")
+		tokens = append(tokens, strings.Fields(line)...)
+	}
+	for i := 0; i < len(tokens); i++ {
+		tok := strings.ToLower(tokens[i])
+		if tok != "" && (tok[0] >= '0' && tok[0] <= '9' || tok[0] == '-') {
+			break
+		}
+		next := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return strings.ToLower(tokens[i])
+			}
+			return ""
+		}
+		switch tok {
+		case "footsteps":
+			if t := next(); t == "default" {
+				cfg.Footsteps = "normal"
+			} else if _, ok := footstepSounds[t]; ok {
+				cfg.Footsteps = t
+			}
+		case "sex":
+			next()
+		case "headoffset":
+			next()
+			next()
+			next()
+		case "fixedlegs":
+			cfg.FixedLegs = true
+		case "fixedtorso":
+			cfg.FixedTorso = true
+		}
+	}
+	return cfg
+}
+
+// PlayerFallback is what cgame loads in place of a player model that
+// doesn't load, and whose sounds stand in for ones a model lacks.
+type PlayerFallback struct {
+	Model    string
+	Head     string
+	KeepSkin bool // team games keep the player's (team) skin
+}
+
+// DefaultPlayerModel returns cgame's fallback player model for a gametype
+// in a game. Team gametypes use DEFAULT_TEAM_MODEL with the player's skin:
+// james, with the *james head, in Team Arena and games layered over it, and
+// sarge otherwise. Every other gametype uses DEFAULT_MODEL, sarge, with its
+// default skin.
+func (m *Manifest) DefaultPlayerModel(game string, gametype int) PlayerFallback {
+	if gametype < gtTeam {
+		return PlayerFallback{Model: "sarge", Head: "sarge"}
+	}
+	if containsString(m.Layers(game), "missionpack") {
+		return PlayerFallback{Model: "james", Head: "*james", KeepSkin: true}
+	}
+	return PlayerFallback{Model: "sarge", Head: "sarge", KeepSkin: true}
+}
+
+// PlayerModelReport lists what a player model has and what it's missing.
+type PlayerModelReport struct {
+	Model   string
+	Skins   []string // skin names found for any part
+	Missing []MissingAsset
+}
+
+// MissingAsset is a file a player model needs but the install doesn't have.
+type MissingAsset struct {
+	Path string // path looked for, without extension for images
+	Kind string // model, skin, texture, animation, icon, or sound
+	From string // the file referencing it, if any
+}
+
+// Complete reports whether nothing is missing.
+func (r *PlayerModelReport) Complete() bool {
+	return len(r.Missing) == 0
+}
+
+// ValidatePlayerModel checks a player model for everything the game loads:
+// head, upper, and lower md3s; each skin for all three parts, with the
+// textures the skins and md3s reference; animation.cfg; an icon per skin; and
+// the model's sound set. The default skin is always required. Missing sounds
+// fall back to the default model in game, but are still reported.
+func ValidatePlayerModel(name string, gm *GameManifest) (*PlayerModelReport, error) {
+	name = strings.ToLower(name)
+	base := "models/players/" + name + "/"
+
+	skins := map[string]bool{"default": true}
+	found := false
+	for p := range gm.FileIndex {
+		if !strings.HasPrefix(p, base) {
+			continue
+		}
+		found = true
+		file := strings.TrimPrefix(p, base)
+		for _, part := range playerParts {
+			if skin, ok := strings.CutPrefix(file, part+"_"); ok && strings.HasSuffix(skin, ".skin") {
+				skins[strings.TrimSuffix(skin, ".skin")] = true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("player model not found: %s", name)
+	}
+
+	r := &PlayerModelReport{Model: name, Skins: sortedMapKeys(skins)}
+	missing := func(p, kind, from string) {
+		r.Missing = append(r.Missing, MissingAsset{Path: p, Kind: kind, From: from})
+	}
+	seen := make(map[string]bool)
+	checkShader := func(shaderName, from string) {
+		lower := strings.ToLower(shaderName)
+		lower = shaderLookupName(lower)
+		if seen[lower] {
+			return
+		}
+		seen[lower] = true
+		textures, ok := gm.Shaders[lower]
+		if !ok || len(textures) == 0 {
+			if _, ok := gm.ResolveTexture(lower); !ok {
+				missing(lower, "texture", from)
+			}
+			return
+		}
+		for _, tex := range textures {
+			if _, ok := gm.ResolveTexture(tex); !ok {
+				missing(strings.ToLower(tex), "texture", gm.ShaderFiles[lower])
+			}
+		}
+	}
+
+	for _, part := range playerParts {
+		md3 := base + part + ".md3"
+		data, err := readFileFromIndex(md3, gm.FileIndex)
+		if err != nil {
+			missing(md3, "model", "")
+			continue
+		}
+		shaders, err := ParseMD3Shaders(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", md3, err)
+		}
+		for _, shader := range shaders {
+			checkShader(shader, md3)
+		}
+	}
+
+	for _, skin := range r.Skins {
+		for _, part := range playerParts {
+			skinPath := base + part + "_" + skin + ".skin"
+			data, err := readFileFromIndex(skinPath, gm.FileIndex)
+			if err != nil {
+				missing(skinPath, "skin", "")
+				continue
+			}
+			textures, _ := ParseSkin(bytes.NewReader(data))
+			for _, tex := range textures {
+				checkShader(tex, skinPath)
+			}
+		}
+		if _, ok := gm.ResolveTexture(base + "icon_" + skin); !ok {
+			missing(base+"icon_"+skin, "icon", "")
+		}
+	}
+
+	if _, ok := gm.FileIndex[base+"animation.cfg"]; !ok {
+		missing(base+"animation.cfg", "animation", "")
+	}
+
+	for _, sound := range playerSounds {
+		if _, ok := resolveSound("sound/player/"+name+"/"+sound+".wav", gm.FileIndex); !ok {
+			missing("sound/player/"+name+"/"+sound+".wav", "sound", "")
+		}
+	}
+
+	sort.SliceStable(r.Missing, func(i, j int) bool { return r.Missing[i].Path < r.Missing[j].Path })
+	return r, nil
+}
+
+// resolveSound finds a sound file, accepting an .ogg in place of a .wav as
+// ioquake3 does.
+func resolveSound(p string, fileIndex map[string]string) (string, bool) {
+	lower := strings.ToLower(p)
+	if _, ok := fileIndex[lower]; ok {
+		return lower, true
+	}
+	if ogg, ok := strings.CutSuffix(lower, ".wav"); ok {
+		if _, ok := fileIndex[ogg+".ogg"]; ok {
+			return ogg + ".ogg", true
+		}
+	}
+	return "", false
+}
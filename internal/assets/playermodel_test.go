@@ -0,0 +1,131 @@
+package assets
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultPlayerModel(t *testing.T) {
+	manifest := &Manifest{Games: map[string]*GameManifest{
+		"baseq3":      {},
+		"missionpack": {},
+		"tamod":       {Base: "missionpack"},
+	}}
+	sarge := PlayerFallback{Model: "sarge", Head: "sarge"}
+	for _, tc := range []struct {
+		game     string
+		gametype int
+		want     PlayerFallback
+	}{
+		{"baseq3", 0, sarge},
+		{"baseq3", 4, PlayerFallback{Model: "sarge", Head: "sarge", KeepSkin: true}},
+		{"missionpack", 0, sarge},
+		{"missionpack", 1, sarge},
+		{"missionpack", 3, PlayerFallback{Model: "james", Head: "*james", KeepSkin: true}},
+		{"tamod", 1, sarge},
+		{"tamod", 5, PlayerFallback{Model: "james", Head: "*james", KeepSkin: true}},
+	} {
+		if got := manifest.DefaultPlayerModel(tc.game, tc.gametype); got != tc.want {
+			t.Errorf("DefaultPlayerModel(%s, %d) = %+v, want %+v", tc.game, tc.gametype, got, tc.want)
+		}
+	}
+}
+
+func TestParseAnimationConfig(t *testing.T) {
+	cfg := ParseAnimationConfig(strings.NewReader(`// animation config file
+sex f
+footsteps mech
+headoffset 0 0 0
+fixedtorso
+0	30	0	25		// BOTH_DEATH1
+footsteps boot
+`))
+	want := AnimationConfig{Footsteps: "mech", FixedTorso: true}
+	if *cfg != want {
+		t.Errorf("config = %+v, want %+v", *cfg, want)
+	}
+	if cfg := ParseAnimationConfig(strings.NewReader("footsteps squelch\n")); cfg.Footsteps != "normal" {
+		t.Errorf("unknown footsteps read as %q, want normal", cfg.Footsteps)
+	}
+}
+
+// playerModelFixture returns a game whose files are sarge, james and the
+// *james head with default and red skins and a full sound set, and a
+// "lamb" model with a red skin and no sounds of its own.
+func playerModelFixture(t *testing.T) *GameManifest {
+	t.Helper()
+	files := map[string][]byte{}
+	addModel := func(dir, skin string, parts ...string) {
+		for _, part := range parts {
+			files[dir+part+".md3"] = makeMD3()
+			files[dir+part+"_"+skin+".skin"] = []byte("")
+		}
+	}
+	for _, skin := range []string{"default", "red"} {
+		addModel("models/players/sarge/", skin, "head", "upper", "lower")
+		addModel("models/players/james/", skin, "upper", "lower")
+		addModel("models/players/heads/james/", skin, "head")
+	}
+	addModel("models/players/lamb/", "red", "head", "upper", "lower")
+	for _, model := range []string{"sarge", "james"} {
+		for _, sound := range playerSounds {
+			files["sound/player/"+model+"/"+sound+".wav"] = []byte("RIFF")
+		}
+	}
+	pk3 := filepath.Join(t.TempDir(), "pak0.pk3")
+	writeFixturePk3(t, pk3, files)
+	gm := &GameManifest{FileIndex: map[string]string{}}
+	for name := range files {
+		gm.FileIndex[name] = pk3
+	}
+	return gm
+}
+
+func TestResolvePlayerModelFallback(t *testing.T) {
+	gm := playerModelFixture(t)
+	team := PlayerFallback{Model: "james", Head: "*james", KeepSkin: true}
+	ffa := PlayerFallback{Model: "sarge", Head: "sarge"}
+
+	resolve := func(model string, fallback PlayerFallback) map[string]bool {
+		needed := newDepSet()
+		resolvePlayerModel(model, "", fallback, "", gm, needed)
+		return needed.files
+	}
+
+	// A model that doesn't load is replaced: in team games by the team
+	// model wearing the player's skin, otherwise by sarge's default skin
+	files := resolve("ghost/red", team)
+	for _, want := range []string{
+		"models/players/james/upper_red.skin",
+		"models/players/heads/james/head_red.skin",
+		"sound/player/james/taunt.wav",
+	} {
+		if !files[want] {
+			t.Errorf("team fallback lacks %s", want)
+		}
+	}
+	if files["models/players/sarge/upper.md3"] {
+		t.Error("team fallback loaded sarge")
+	}
+	files = resolve("ghost/red", ffa)
+	if !files["models/players/sarge/upper_default.skin"] || files["models/players/sarge/upper_red.skin"] {
+		t.Errorf("ffa fallback didn't use sarge's default skin: %v", sortedMapKeys(files))
+	}
+	if files["models/players/james/upper.md3"] {
+		t.Error("ffa fallback loaded james")
+	}
+
+	// A model that loads keeps its own parts and takes the sounds it lacks
+	// from the fallback model
+	files = resolve("lamb/red", ffa)
+	if !files["models/players/lamb/upper_red.skin"] || !files["sound/player/sarge/death1.wav"] {
+		t.Errorf("lamb resolved to %v", sortedMapKeys(files))
+	}
+	if files["sound/player/james/death1.wav"] {
+		t.Error("ffa sounds came from james")
+	}
+	if files = resolve("lamb/red", team); !files["sound/player/james/death1.wav"] {
+		t.Error("team sounds didn't come from james")
+	}
+}
@@ -0,0 +1,148 @@
+package assets
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BaselinePolicy decides which files from a game's official paks go into its
+// baseline pk3. A file is included when it matches an Include pattern, matches
+// no Exclude pattern, and is no larger than MaxFileSize (when set).
+//
+// Patterns are slash-separated globs matched against lowered paths: "*" and "?"
+// match within a path segment, "**" matches any number of segments, and a
+// trailing "/" is shorthand for everything below a directory ("gfx/" = "gfx/**").
+type BaselinePolicy struct {
+	Include     []string                   `yaml:"include" json:"include"`
+	Exclude     []string                   `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	MaxFileSize int64                      `yaml:"max_file_size,omitempty" json:"max_file_size,omitempty"` // bytes, 0 = no cap
+	Games       map[string]*BaselinePolicy `yaml:"games,omitempty" json:"games,omitempty"`                 // per-game overrides
+}
+
+// DefaultBaselinePolicy returns the built-in policy: shared UI, HUD, weapon,
+// item, and player assets plus root-level .cfg files.
+func DefaultBaselinePolicy() *BaselinePolicy {
+	return &BaselinePolicy{
+		Include: []string{
+			"gfx/",
+			"sprites/",
+			"icons/",
+			"fonts/",
+			"menu/",
+			"ui/",
+			"botfiles/",
+			"models/weapons/",
+			"models/weapons2/",
+			"models/weaphits/",
+			"models/powerups/",
+			"models/mapobjects/",
+			"models/flags/",
+			"models/ammo/",
+			"models/gibs/",
+			"models/misc/",
+			"sound/",
+			"scripts/",
+			"vm/",
+			"textures/sfx/",
+			"textures/effects/",
+			"textures/sfx2/",
+			"textures/effects2/",
+			"textures/ctf2/",
+			"team_icon/",
+			"models/players/",
+			"*.cfg",
+		},
+	}
+}
+
+// LoadBaselinePolicy reads a policy from a YAML or JSON file.
+func LoadBaselinePolicy(path string) (*BaselinePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read baseline policy: %w", err)
+	}
+	var p BaselinePolicy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse baseline policy: %w", err)
+	}
+	if len(p.Include) == 0 {
+		return nil, fmt.Errorf("baseline policy has no include patterns")
+	}
+	return &p, nil
+}
+
+// ForGame returns the effective policy for a game. A per-game override
+// replaces Include when it sets one, adds its Exclude patterns, and
+// replaces MaxFileSize when non-zero.
+func (p *BaselinePolicy) ForGame(game string) *BaselinePolicy {
+	override, ok := p.Games[game]
+	if !ok || override == nil {
+		return p
+	}
+	effective := &BaselinePolicy{
+		Include:     p.Include,
+		Exclude:     append(append([]string(nil), p.Exclude...), override.Exclude...),
+		MaxFileSize: p.MaxFileSize,
+	}
+	if len(override.Include) > 0 {
+		effective.Include = override.Include
+	}
+	if override.MaxFileSize != 0 {
+		effective.MaxFileSize = override.MaxFileSize
+	}
+	return effective
+}
+
+// Allows reports whether a lowered path of the given uncompressed size belongs in the baseline.
+func (p *BaselinePolicy) Allows(lowerPath string, size int64) bool {
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		return false
+	}
+	if !matchAnyGlob(p.Include, lowerPath) {
+		return false
+	}
+	return !matchAnyGlob(p.Exclude, lowerPath)
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(strings.ToLower(pattern), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash-separated path against a pattern supporting "**".
+func matchGlob(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,157 @@
+package assets
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BuildProfile is a named combination of build settings, so one install can
+// be built for different uses without a flag for each setting: a small
+// redistributable output for web playback, a full-quality LAN mirror, or an
+// archive.
+type BuildProfile struct {
+	Compression   string                  `yaml:"compression,omitempty" json:"compression,omitempty"`           // pk3 compression level (see ParseCompression)
+	Music         string                  `yaml:"music,omitempty" json:"music,omitempty"`                       // map music policy (see ParseMusicPolicy)
+	MusicBitrate  int                     `yaml:"music_bitrate,omitempty" json:"music_bitrate,omitempty"`       // kbit/s, for music: ogg
+	MaxMapPakSize int64                   `yaml:"max_map_pk3_size,omitempty" json:"max_map_pk3_size,omitempty"` // map pk3 size budget in bytes; 0 = none
+	Distributable bool                    `yaml:"distributable,omitempty" json:"distributable,omitempty"`       // leave out pk3s with official id content
+	Placeholders  bool                    `yaml:"placeholders,omitempty" json:"placeholders,omitempty"`         // placeholder images for missing textures
+	Levelshots    *LevelshotExportOptions `yaml:"levelshots,omitempty" json:"levelshots,omitempty"`             // also export resized levelshots
+	Textures      *TextureSearch          `yaml:"textures,omitempty" json:"textures,omitempty"`                 // texture extensions and .dds/.ktx policy; nil = stock
+}
+
+// BuildProfiles are the built-in profiles.
+var BuildProfiles = map[string]*BuildProfile{
+	// Web playback: small downloads that can be published anywhere
+	"web": {
+		Compression:   CompressionBest,
+		Music:         MusicOgg,
+		MusicBitrate:  64,
+		MaxMapPakSize: 32 << 20,
+		Distributable: true,
+		Levelshots:    &LevelshotExportOptions{Format: "jpg"},
+	},
+	// LAN mirror: every file as shipped, stored for the fastest builds and loads
+	"lan": {
+		Compression: CompressionStore,
+		Music:       MusicKeep,
+	},
+	// Archive: every file as shipped, as small as lossless compression makes it
+	"archive": {
+		Compression: CompressionBest,
+		Music:       MusicKeep,
+	},
+}
+
+// LoadBuildProfiles reads named profiles from a YAML or JSON file, to add to
+// or replace the built-in ones.
+func LoadBuildProfiles(path string) (map[string]*BuildProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read build profiles: %w", err)
+	}
+	var profiles map[string]*BuildProfile
+	if err := yaml.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("parse build profiles: %w", err)
+	}
+	for name, p := range profiles {
+		if p == nil {
+			return nil, fmt.Errorf("build profile %s is empty", name)
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("build profile %s: %w", name, err)
+		}
+	}
+	return profiles, nil
+}
+
+// LookupBuildProfile returns the profile named name from custom, or else the
+// built-in profiles.
+func LookupBuildProfile(name string, custom map[string]*BuildProfile) (*BuildProfile, error) {
+	if p, ok := custom[name]; ok {
+		return p, nil
+	}
+	if p, ok := BuildProfiles[name]; ok {
+		return p, nil
+	}
+	names := sortedMapKeys(BuildProfiles)
+	for n := range custom {
+		if !containsString(names, n) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown build profile %q (have %s)", name, strings.Join(names, ", "))
+}
+
+func (p *BuildProfile) validate() error {
+	if _, err := ParseCompression(p.Compression); err != nil {
+		return err
+	}
+	if _, err := ParseMusicPolicy(p.Music); err != nil {
+		return err
+	}
+	if p.Levelshots != nil && p.Levelshots.Format != "" && p.Levelshots.Format != "jpg" && p.Levelshots.Format != "png" {
+		return fmt.Errorf("unknown levelshot format %q", p.Levelshots.Format)
+	}
+	if p.Levelshots != nil && (p.Levelshots.Minimap < 0 || p.Levelshots.Minimap > maxMinimapWidth) {
+		return fmt.Errorf("levelshot minimap width %d out of range 0-%d", p.Levelshots.Minimap, maxMinimapWidth)
+	}
+	if p.Textures != nil {
+		return p.Textures.validate()
+	}
+	return nil
+}
+
+// Apply sets the build options the profile controls, replacing what they
+// were. Options it has no say in, such as the baseline policy, are left alone.
+func (p *BuildProfile) Apply(opts *BuildOptions) {
+	opts.Compression = p.Compression
+	opts.Distributable = p.Distributable
+	opts.Levelshots = p.Levelshots
+	opts.Textures = TextureSearch{}
+	if p.Textures != nil {
+		opts.Textures = *p.Textures
+	}
+	p.ApplyMapPak(&opts.MapPak)
+}
+
+// ApplyMapPak sets the map pk3 options the profile controls.
+func (p *BuildProfile) ApplyMapPak(opts *MapPakOptions) {
+	opts.Compression = p.Compression
+	opts.Music = p.Music
+	opts.MusicBitrate = p.MusicBitrate
+	opts.MaxSize = p.MaxMapPakSize
+	opts.Placeholders = p.Placeholders
+}
+
+// removeRestricted deletes the artifacts containing official id content from
+// a build's output and manifest, for a distributable build.
+func removeRestricted(manifest *Manifest, outputDir string) Diagnostics {
+	var diags Diagnostics
+	for _, rel := range sortedMapKeys(manifest.Artifacts) {
+		if !manifest.Artifacts[rel].Restricted {
+			continue
+		}
+		if err := os.Remove(filepath.Join(outputDir, filepath.FromSlash(rel))); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove restricted %s: %v", rel, err)
+			continue
+		}
+		delete(manifest.Artifacts, rel)
+		d := Diagnostic{Severity: SeverityWarning, Kind: DiagRestricted, Subject: rel}
+		if mapFile, ok := strings.CutPrefix(rel, "maps/"); ok {
+			d.Map = strings.TrimSuffix(mapFile, ".pk3")
+		}
+		diags = append(diags, d)
+	}
+	if len(diags) > 0 {
+		log.Printf("Distributable build: left out %d pk3s with official id content", len(diags))
+	}
+	return diags
+}
@@ -0,0 +1,154 @@
+package assets
+
+import "fmt"
+
+// Largest netfield counts of any supported protocol; EntityState and
+// PlayerState are sized for these, and fields past a protocol's own count
+// stay zero.
+const (
+	maxEntityFields = 58
+	maxPlayerFields = 57
+)
+
+// Protocol describes the network layout of a protocol version: the netfield
+// tables entity and playerstate deltas are read and written with, the sizes
+// of the playerstate arrays, and where configstrings keep models, sounds,
+// and players. Use LookupProtocol to pick one by the version in a demo
+// header.
+type Protocol struct {
+	Name            string
+	EntityFieldBits []int // entityState_t netFields: 0 = float, positive = unsigned int bits
+	PlayerFieldBits []int // playerState_t netFields: 0 = float, negative = signed int bits
+	MaxStats        int
+	MaxPersistant   int
+	MaxWeapons      int
+	MaxPowerups     int
+	Configstrings   ConfigstringLayout
+}
+
+// ConfigstringLayout gives the first configstring index of each run of
+// asset configstrings. The server and system info (CS 0 and 1) are in the
+// same place in every protocol, so fs_game can be read before the layout is
+// known.
+type ConfigstringLayout struct {
+	Models  int // CS_MODELS: 256 model paths, inline BSP models as "*n"
+	Sounds  int // CS_SOUNDS: 256 sound paths
+	Players int // CS_PLAYERS: an info string per client
+}
+
+// configstringsQ3 is the layout of Quake III and Team Arena, whose
+// missionpack game code leaves baseq3's indexes alone.
+var configstringsQ3 = ConfigstringLayout{Models: csModels, Sounds: csSounds, Players: csPlayers}
+
+// configstringsQL is Quake Live's layout, which moves the asset
+// configstrings down to make room for its own.
+var configstringsQL = ConfigstringLayout{Models: 17, Sounds: 273, Players: 529}
+
+// IsModel reports whether index is a model configstring.
+func (l ConfigstringLayout) IsModel(index int) bool {
+	return index >= l.Models && index < l.Models+256
+}
+
+// IsSound reports whether index is a sound configstring.
+func (l ConfigstringLayout) IsSound(index int) bool {
+	return index >= l.Sounds && index < l.Sounds+256
+}
+
+// IsPlayer reports whether index is a player configstring.
+func (l ConfigstringLayout) IsPlayer(index int) bool {
+	return index >= l.Players && index < l.Players+maxClients
+}
+
+// Client returns the client number of a player configstring, and false if
+// index isn't one.
+func (l ConfigstringLayout) Client(index int) (int, bool) {
+	if !l.IsPlayer(index) {
+		return 0, false
+	}
+	return index - l.Players, true
+}
+
+// ProtocolQ3 is Quake III Arena's protocol 68, also used unchanged by
+// ioquake3's protocol 71 and by mods such as OSP.
+var ProtocolQ3 = &Protocol{
+	Name:            "Quake III Arena",
+	EntityFieldBits: entityFieldBits[:],
+	PlayerFieldBits: playerFieldBits[:],
+	MaxStats:        maxStats,
+	MaxPersistant:   maxPersistant,
+	MaxWeapons:      maxWeapons,
+	MaxPowerups:     maxPowerups,
+	Configstrings:   configstringsQ3,
+}
+
+// ProtocolQL73 is Quake Live's protocol 73. Entities gain trajectory
+// gravity, jump, health, armor, and location fields; playerstates gain jump,
+// crouch, and location fields.
+var ProtocolQL73 = &Protocol{
+	Name:            "Quake Live",
+	EntityFieldBits: qlEntityFieldBits[:],
+	PlayerFieldBits: qlPlayerFieldBits[:53],
+	MaxStats:        maxStats,
+	MaxPersistant:   maxPersistant,
+	MaxWeapons:      maxWeapons,
+	MaxPowerups:     maxPowerups,
+	Configstrings:   configstringsQL,
+}
+
+// ProtocolQL91 is Quake Live's protocol 90 and 91, which add the player's
+// fov and movement input to the playerstate.
+var ProtocolQL91 = &Protocol{
+	Name:            "Quake Live",
+	EntityFieldBits: qlEntityFieldBits[:],
+	PlayerFieldBits: qlPlayerFieldBits[:],
+	MaxStats:        maxStats,
+	MaxPersistant:   maxPersistant,
+	MaxWeapons:      maxWeapons,
+	MaxPowerups:     maxPowerups,
+	Configstrings:   configstringsQL,
+}
+
+// LookupProtocol returns the protocol for a version number from a demo
+// header.
+func LookupProtocol(version int) (*Protocol, error) {
+	switch version {
+	case 68, 71:
+		return ProtocolQ3, nil
+	case 73:
+		return ProtocolQL73, nil
+	case 90, 91:
+		return ProtocolQL91, nil
+	}
+	return nil, fmt.Errorf("%w %d", ErrUnsupportedProtocol, version)
+}
+
+// qlEntityFieldBits is Quake Live's entityStateFields[]: Quake III's with
+// pos.gravity and apos.gravity inserted, and five fields appended.
+var qlEntityFieldBits = [maxEntityFields]int{
+	32, 0, 0, 0, 0, 0, 0, 0, 0, // pos.trTime, pos.trBase[0..2], pos.trDelta[0..2], apos.trBase[1], apos.trBase[0]
+	32,                // pos.gravity
+	10, 0, 8, 8, 8, 8, // event, angles2[1], eType, torsoAnim, eventParm, legsAnim
+	10, 8, 19, 10, 8, 8, 0, // groundEntityNum, pos.trType, eFlags, otherEntityNum, weapon, clientNum, angles[1]
+	32, 8, 0, 0, 0, 24, 16, // pos.trDuration, apos.trType, origin[0..2], solid, powerups
+	8, 10, 8, 8, // modelindex, otherEntityNum2, loopSound, generic1
+	0, 0, 0, 8, 0, // origin2[2], origin2[0], origin2[1], modelindex2, angles[0]
+	32, 32, 32, // time, apos.trTime, apos.trDuration
+	0, 0, 0, 0, // apos.trBase[2], apos.trDelta[0..2]
+	32,                  // apos.gravity
+	32, 0, 0, 0, 32, 16, // time2, angles[2], angles2[0], angles2[2], constantLight, frame
+	32, 1, 16, 16, 8, // jumpTime, doubleJumped, health, armor, location
+}
+
+// qlPlayerFieldBits is Quake Live's playerStateFields[] for protocol 91;
+// protocol 73 stops after location.
+var qlPlayerFieldBits = [maxPlayerFields]int{
+	32, 0, 0, 8, 0, 0, 0, 0, // commandTime, origin[0..1], bobCycle, velocity[0..1], viewangles[1..0]
+	-16, 0, 0, 8, -16, 16, // weaponTime, origin[2], velocity[2], legsTimer, pm_time, eventSequence
+	8, 4, 8, 8, 8, 24, // torsoAnim, movementDir, events[0], legsAnim, events[1], pm_flags
+	10, 4, 16, 10, 16, 16, 16, // groundEntityNum, weaponstate, eFlags, externalEvent, gravity, speed, delta_angles[1]
+	8, -8, 8, 8, 8, 8, 8, // externalEventParm, viewheight, damageEvent, damageYaw, damagePitch, damageCount, generic1
+	8, 16, 16, 12, 8, 8, // pm_type, delta_angles[0], delta_angles[2], torsoTimer, eventParms[0], eventParms[1]
+	8, 5, 0, 0, 0, 0, 10, 16, // clientNum, weapon, viewangles[2], grapplePoint[0..2], jumppad_ent, loopSound
+	32, 1, 32, 32, 8, // jumpTime, doubleJumped, crouchTime, crouchSlideTime, location
+	8, 8, 8, 8, // fov, forwardmove, rightmove, upmove
+}
@@ -0,0 +1,150 @@
+package assets
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/md4"
+)
+
+// PureListName is the file in a baseline build's output listing each game's
+// paks for sv_pure.
+const PureListName = "pure.json"
+
+// PureList is the pak list a pure server advertises for a game, with the
+// checksums clients must match.
+type PureList struct {
+	Game string `json:"game"`
+	// Paks are the installation's pk3s, from the game and the games it's
+	// layered over, highest priority first as in sv_paks. Names are
+	// "<game dir>/<pak name>", without the extension, as in sv_referencedPakNames.
+	Paks []PurePak `json:"paks"`
+	// Generated are the build's pk3s a server loading them must also list:
+	// the game's and its bases' baseline pk3s, then the map pk3s built under
+	// them. Names are output-relative, without the extension.
+	Generated []PurePak `json:"generated"`
+}
+
+// PurePak is a pak and the checksum the engine computes for it.
+type PurePak struct {
+	Name     string `json:"name"`
+	Checksum int32  `json:"checksum"`
+}
+
+// PakChecksum returns the checksum ioquake3 computes for a pk3 when loading
+// it (pack->checksum, the value sv_paks lists): the MD4 block checksum of the
+// CRC32s of its non-empty entries, in directory order.
+func PakChecksum(pk3Path string) (int32, error) {
+	r, err := openPk3(pk3Path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return pakChecksum(r.File), nil
+}
+
+func pakChecksum(files []*zip.File) int32 {
+	crcs := make([]byte, 0, 4*len(files))
+	for _, f := range files {
+		if f.UncompressedSize64 > 0 {
+			crcs = binary.LittleEndian.AppendUint32(crcs, f.CRC32)
+		}
+	}
+	return blockChecksum(crcs)
+}
+
+// blockChecksum is the engine's Com_BlockChecksum: the four words of the MD4
+// digest XORed together.
+func blockChecksum(data []byte) int32 {
+	h := md4.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+	var v uint32
+	for i := 0; i < 16; i += 4 {
+		v ^= binary.LittleEndian.Uint32(digest[i:])
+	}
+	return int32(v)
+}
+
+// buildPureLists computes each game's PureList from its sources (in load
+// order) and the artifacts in the manifest. A game's generated map pk3s are
+// those for the maps in its file index.
+func buildPureLists(manifest *Manifest, gamePk3s map[string][]string) map[string]*PureList {
+	lists := make(map[string]*PureList, len(manifest.Games))
+	checksums := make(map[string]int32) // pk3 path → checksum, shared by layered games
+	for _, game := range manifest.GameNames() {
+		gm := manifest.Games[game]
+		list := &PureList{Game: game, Paks: []PurePak{}, Generated: []PurePak{}}
+		layers := manifest.Layers(game)
+		for _, layer := range layers {
+			pk3s := gamePk3s[layer]
+			for i := len(pk3s) - 1; i >= 0; i-- {
+				pk3 := pk3s[i]
+				if isLooseSource(pk3) || !strings.EqualFold(filepath.Ext(pk3), ".pk3") {
+					continue // the engine doesn't checksum loose files or .pak archives
+				}
+				sum, ok := checksums[pk3]
+				if !ok {
+					var err error
+					if sum, err = PakChecksum(pk3); err != nil {
+						log.Printf("Warning: pure list for %s: skipping %s: %v", game, filepath.Base(pk3), err)
+						continue
+					}
+					checksums[pk3] = sum
+				}
+				name := strings.TrimSuffix(filepath.Base(pk3), filepath.Ext(pk3))
+				list.Paks = append(list.Paks, PurePak{Name: layer + "/" + name, Checksum: sum})
+			}
+		}
+
+		var maps []string
+		for rel := range manifest.Artifacts {
+			mapName, ok := strings.CutPrefix(strings.TrimSuffix(rel, ".pk3"), "maps/")
+			if _, has := gm.FileIndex["maps/"+mapName+".bsp"]; ok && has {
+				maps = append(maps, rel)
+			}
+		}
+		sort.Strings(maps)
+		rels := make([]string, 0, len(layers)+len(maps))
+		for _, layer := range layers {
+			rels = append(rels, layer+".pk3")
+		}
+		rels = append(rels, maps...)
+		for _, rel := range rels {
+			if a, ok := manifest.Artifacts[rel]; ok {
+				list.Generated = append(list.Generated, PurePak{Name: strings.TrimSuffix(rel, ".pk3"), Checksum: a.Checksum})
+			}
+		}
+		lists[game] = list
+	}
+	return lists
+}
+
+// savePureLists writes pure lists to path as JSON, keyed by game.
+func savePureLists(path string, lists map[string]*PureList) error {
+	data, err := json.MarshalIndent(lists, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadPureLists reads the pure lists a baseline build wrote, keyed by game.
+func LoadPureLists(path string) (map[string]*PureList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read pure lists: %w", err)
+	}
+	var lists map[string]*PureList
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return nil, fmt.Errorf("parse pure lists: %w", err)
+	}
+	return lists, nil
+}
@@ -0,0 +1,102 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// quakeLiveAppID is the Steam app ID Quake Live workshop items are stored under.
+	quakeLiveAppID = "282440"
+	// quakeLivePak is the pak Quake Live ships all of its baseq3 content in.
+	quakeLivePak = "pak00.pk3"
+)
+
+// IsQuakeLiveDir reports whether dir looks like a Steam Quake Live install
+// (steamapps/common/Quake Live) rather than a Quake 3 install.
+func IsQuakeLiveDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "baseq3", quakeLivePak)); err == nil {
+		return true
+	}
+	if _, err := os.Stat(workshopContentDir(dir)); err == nil {
+		return true
+	}
+	return false
+}
+
+// workshopContentDir returns steamapps/workshop/content/<appid> for a Quake Live
+// install at steamapps/common/Quake Live.
+func workshopContentDir(qlDir string) string {
+	return filepath.Join(qlDir, "..", "..", "workshop", "content", quakeLiveAppID)
+}
+
+// CollectWorkshopPk3s returns pk3 path → workshop item ID for every pk3 in the
+// install's Steam workshop content directory.
+func CollectWorkshopPk3s(qlDir string) map[string]string {
+	result := make(map[string]string)
+	entries, err := os.ReadDir(workshopContentDir(qlDir))
+	if err != nil {
+		return result
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !isWorkshopID(e.Name()) {
+			continue
+		}
+		itemDir := filepath.Join(workshopContentDir(qlDir), e.Name())
+		filepath.WalkDir(itemDir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if strings.HasSuffix(strings.ToLower(d.Name()), ".pk3") {
+				result[path] = e.Name()
+			}
+			return nil
+		})
+	}
+	return result
+}
+
+// workshopPk3sInLoadOrder orders workshop pk3s by item ID, numerically, then
+// path, so the index is deterministic across runs.
+func workshopPk3sInLoadOrder(workshop map[string]string) []string {
+	paths := make([]string, 0, len(workshop))
+	for p := range workshop {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		// IDs are all digits, so the shorter is the smaller
+		a, b := strings.TrimLeft(workshop[paths[i]], "0"), strings.TrimLeft(workshop[paths[j]], "0")
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		if a != b {
+			return a < b
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+func isWorkshopID(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// WorkshopID returns the Steam workshop item that provides a map's BSP, if any.
+func (gm *GameManifest) WorkshopID(mapName string) (string, bool) {
+	pk3Path, ok := gm.FileIndex["maps/"+strings.ToLower(mapName)+".bsp"]
+	if !ok {
+		return "", false
+	}
+	id, ok := gm.Workshop[pk3Path]
+	return id, ok
+}
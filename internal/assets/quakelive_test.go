@@ -0,0 +1,64 @@
+package assets
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestIsOfficialPak(t *testing.T) {
+	for name, want := range map[string]bool{
+		"pak0.pk3":             true,
+		"baseq3/PAK8.pk3":      true,
+		"pak00.pk3":            true, // Quake Live
+		"pak0t.pk3":            false,
+		"pak01.pk3":            false,
+		"pak0.pak":             false,
+		"map-pak0.pk3":         false,
+		"zz-pak00.pk3":         false,
+		"missionpack/pak3.pk3": true,
+	} {
+		if got := IsOfficialPak(name); got != want {
+			t.Errorf("IsOfficialPak(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestQuakeLiveInstall(t *testing.T) {
+	steam := t.TempDir()
+	ql := filepath.Join(steam, "steamapps", "common", "Quake Live")
+	writeFixturePk3(t, filepath.Join(ql, "baseq3", "pak00.pk3"), map[string][]byte{
+		"gfx/2d/crosshaira.tga":        fixtureImage("crosshaira"),
+		"textures/base_wall/metal.jpg": fixtureImage("metal"),
+	})
+	workshop := filepath.Join(steam, "steamapps", "workshop", "content", quakeLiveAppID)
+	for _, id := range []string{"900", "1000", "10"} {
+		writeFixturePk3(t, filepath.Join(workshop, id, "item.pk3"), map[string][]byte{"x" + id + ".txt": []byte(id)})
+	}
+
+	if !IsQuakeLiveDir(ql) {
+		t.Fatal("IsQuakeLiveDir = false")
+	}
+	want := []string{
+		filepath.Join(ql, "baseq3", "pak00.pk3"),
+		filepath.Join(workshop, "10", "item.pk3"),
+		filepath.Join(workshop, "900", "item.pk3"),
+		filepath.Join(workshop, "1000", "item.pk3"),
+	}
+	if got := CollectGamePk3s(ql)["baseq3"]; !slices.Equal(got, want) {
+		t.Errorf("baseq3 pk3s = %v, want %v", got, want)
+	}
+
+	out := t.TempDir()
+	if _, err := BuildBaseline(ql, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := manifest.Games["baseq3"]
+	if gm == nil || !gm.OfficialFiles["textures/base_wall/metal.jpg"] {
+		t.Errorf("pak00.pk3's files aren't official")
+	}
+}
@@ -0,0 +1,65 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+)
+
+// qvmMagic starts every QVM (VM_MAGIC), little endian.
+const qvmMagic = 0x12721444
+
+// demoVMs are the game-code modules a client loads to play back a demo.
+var demoVMs = []string{"vm/cgame.qvm", "vm/ui.qvm"}
+
+// VMFile is a QVM a demo plays back with.
+type VMFile struct {
+	Path     string `json:"path"`
+	Source   string `json:"source"` // game directory and pk3 it comes from
+	SHA256   string `json:"sha256"`
+	Baseline bool   `json:"baseline,omitempty"` // clients already have it from a baseline pk3
+}
+
+// DemoVMs returns the cgame and ui QVMs a demo plays back with: the copies
+// that win in the game its fs_game resolves to, so a mod's own QVMs are used
+// over its base game's. A missing or malformed QVM is an error, since the
+// demo can't play without it.
+func DemoVMs(info *DemoInfo, manifest *Manifest) (string, []VMFile, error) {
+	game, gm, ok := manifest.GameFor(info.FSGame)
+	if !ok {
+		return "", nil, fmt.Errorf("no game manifest for fs_game %q", info.FSGame)
+	}
+	vms := make([]VMFile, 0, len(demoVMs))
+	for _, p := range demoVMs {
+		pk3, ok := gm.FileIndex[p]
+		if !ok {
+			return "", nil, fmt.Errorf("%s: %w", game, &ErrFileNotInIndex{Path: p})
+		}
+		data, err := readFileFromIndex(p, gm.FileIndex)
+		if err != nil {
+			return "", nil, fmt.Errorf("read %s: %w", p, err)
+		}
+		if len(data) < 4 || binary.LittleEndian.Uint32(data) != qvmMagic {
+			return "", nil, fmt.Errorf("%s in %s is not a QVM", p, pk3)
+		}
+		sum := sha256.Sum256(data)
+		vms = append(vms, VMFile{
+			Path:     p,
+			Source:   vmSource(pk3),
+			SHA256:   hex.EncodeToString(sum[:]),
+			Baseline: gm.BaselineFiles[p],
+		})
+	}
+	return game, vms, nil
+}
+
+// vmSource names a source pk3 by its game directory and file name, since
+// every game has a pak0.pk3.
+func vmSource(pk3 string) string {
+	if isLooseSource(pk3) {
+		return filepath.Base(filepath.Clean(pk3)) + "/"
+	}
+	return filepath.Base(filepath.Dir(pk3)) + "/" + filepath.Base(pk3)
+}
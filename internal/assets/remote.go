@@ -0,0 +1,279 @@
+package assets
+
+import (
+	"archive/zip"
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	remoteBlockSize  = 64 * 1024
+	remoteMaxBlocks  = 256 // 16 MB of cached blocks per archive
+	remoteDirectRead = 4 * remoteBlockSize
+
+	// remoteMaxArchives bounds the remote pk3s kept open, each holding its
+	// central directory and up to remoteMaxBlocks of cached blocks.
+	remoteMaxArchives = 32
+)
+
+var remoteHTTPClient = &http.Client{Timeout: 2 * time.Minute}
+
+// RemotePk3 reads a pk3 hosted on a web server using HTTP Range requests,
+// fetching only the central directory and the entries that are opened.
+type RemotePk3 struct {
+	*zip.Reader
+	url  string
+	size int64
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+}
+
+// OpenRemotePk3 opens a pk3 at an http(s) URL. The server must support Range requests.
+func OpenRemotePk3(url string) (*RemotePk3, error) {
+	rp, err := newRemotePk3(url)
+	if err != nil {
+		return nil, fmt.Errorf("open remote pk3 %s: %w", url, err)
+	}
+	return rp, nil
+}
+
+func newRemotePk3(url string) (*RemotePk3, error) {
+	rp := &RemotePk3{
+		url:    url,
+		blocks: make(map[int64][]byte),
+	}
+
+	size, err := rp.probeSize()
+	if err != nil {
+		return nil, err
+	}
+	rp.size = size
+
+	zr, err := zip.NewReader(rp, size)
+	if err != nil {
+		return nil, err
+	}
+	rp.Reader = zr
+	return rp, nil
+}
+
+// Size returns the archive size reported by the server.
+func (rp *RemotePk3) Size() int64 {
+	return rp.size
+}
+
+// Close drops cached blocks. It exists so RemotePk3 can stand in for zip.ReadCloser.
+func (rp *RemotePk3) Close() error {
+	rp.mu.Lock()
+	rp.blocks = make(map[int64][]byte)
+	rp.mu.Unlock()
+	return nil
+}
+
+// probeSize issues a one-byte range request and reads the total from Content-Range.
+func (rp *RemotePk3) probeSize() (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, rp.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := remoteHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("server does not support range requests (%s)", resp.Status)
+	}
+	// Content-Range: bytes 0-0/12345
+	cr := resp.Header.Get("Content-Range")
+	slash := strings.LastIndexByte(cr, '/')
+	if slash < 0 {
+		return 0, fmt.Errorf("missing Content-Range total: %q", cr)
+	}
+	size, err := strconv.ParseInt(cr[slash+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range total: %q", cr)
+	}
+	return size, nil
+}
+
+// ReadAt implements io.ReaderAt. Small reads go through a block cache so the
+// zip reader's many small directory reads don't each cost a round trip.
+func (rp *RemotePk3) ReadAt(p []byte, off int64) (int, error) {
+	if off >= rp.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if off+int64(want) > rp.size {
+		want = int(rp.size - off)
+	}
+
+	if want >= remoteDirectRead {
+		n, err := rp.fetchRange(p[:want], off)
+		if err == nil && n < len(p) {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	n := 0
+	for n < want {
+		blockIdx := (off + int64(n)) / remoteBlockSize
+		block, err := rp.block(blockIdx)
+		if err != nil {
+			return n, err
+		}
+		start := int(off + int64(n) - blockIdx*remoteBlockSize)
+		n += copy(p[n:want], block[start:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns a cached block, fetching it on a miss.
+func (rp *RemotePk3) block(idx int64) ([]byte, error) {
+	rp.mu.Lock()
+	if b, ok := rp.blocks[idx]; ok {
+		rp.mu.Unlock()
+		pk3CacheLookups.With("block", "hit").Inc()
+		return b, nil
+	}
+	rp.mu.Unlock()
+	pk3CacheLookups.With("block", "miss").Inc()
+
+	start := idx * remoteBlockSize
+	length := int64(remoteBlockSize)
+	if start+length > rp.size {
+		length = rp.size - start
+	}
+	buf := make([]byte, length)
+	if _, err := rp.fetchRange(buf, start); err != nil {
+		return nil, err
+	}
+
+	rp.mu.Lock()
+	if len(rp.blocks) >= remoteMaxBlocks {
+		rp.blocks = make(map[int64][]byte)
+	}
+	rp.blocks[idx] = buf
+	rp.mu.Unlock()
+	return buf, nil
+}
+
+// fetchRange fills p with bytes starting at off using a single Range request.
+func (rp *RemotePk3) fetchRange(p []byte, off int64) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, rp.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	resp, err := remoteHTTPClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("range request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("range request: %s", resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err != nil {
+		return n, fmt.Errorf("range read: %w", err)
+	}
+	return n, nil
+}
+
+// pk3Archive is an open pk3, local or remote.
+type pk3Archive struct {
+	*zip.Reader
+	io.Closer
+}
+
+// remoteArchives keeps remote pk3s open across calls so repeated reads from the
+// same URL reuse its central directory and block cache.
+var remoteArchives = newRemoteArchiveCache(remoteMaxArchives)
+
+// remoteArchiveCache holds the most recently used remote pk3s by URL,
+// dropping the least recently used once it holds max.
+type remoteArchiveCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List               // *RemotePk3, most recently used first
+	byURL map[string]*list.Element // url → element in order
+}
+
+func newRemoteArchiveCache(max int) *remoteArchiveCache {
+	return &remoteArchiveCache{max: max, order: list.New(), byURL: make(map[string]*list.Element)}
+}
+
+func (c *remoteArchiveCache) get(url string) (*RemotePk3, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byURL[url]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*RemotePk3), true
+}
+
+// put caches rp and returns the archive cached for its URL, which is an
+// earlier one if another caller opened the same URL first. Archives already
+// handed out stay readable after eviction; they only lose their blocks.
+func (c *remoteArchiveCache) put(rp *RemotePk3) *RemotePk3 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.byURL[rp.url]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*RemotePk3)
+	}
+	c.byURL[rp.url] = c.order.PushFront(rp)
+	for c.order.Len() > c.max {
+		oldest := c.order.Remove(c.order.Back()).(*RemotePk3)
+		delete(c.byURL, oldest.url)
+		oldest.Close()
+	}
+	return rp
+}
+
+// openPk3 opens a pk3 from a local path or an http(s) URL. Local .pak
+// archives and LooseSource directories are opened as read-only pk3s. Errors
+// are returned unwrapped so callers can add their own context.
+func openPk3(pk3Path string) (*pk3Archive, error) {
+	if isRemoteSource(pk3Path) {
+		if cached, ok := remoteArchives.get(pk3Path); ok {
+			pk3CacheLookups.With("archive", "hit").Inc()
+			return &pk3Archive{Reader: cached.Reader, Closer: io.NopCloser(nil)}, nil
+		}
+		pk3CacheLookups.With("archive", "miss").Inc()
+		rp, err := newRemotePk3(pk3Path)
+		if err != nil {
+			return nil, err
+		}
+		rp = remoteArchives.put(rp)
+		return &pk3Archive{Reader: rp.Reader, Closer: io.NopCloser(nil)}, nil
+	}
+
+	if isPakFile(pk3Path) {
+		return openPak(pk3Path)
+	}
+	if isLooseSource(pk3Path) {
+		return openLooseSource(pk3Path)
+	}
+	r, err := zip.OpenReader(pk3Path)
+	if err != nil {
+		return nil, err
+	}
+	return &pk3Archive{Reader: &r.Reader, Closer: r}, nil
+}
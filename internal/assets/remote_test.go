@@ -0,0 +1,69 @@
+package assets
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRemoteArchiveCache(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		writeFixturePk3(t, filepath.Join(dir, name+".pk3"), map[string][]byte{"scripts/" + name + ".shader": []byte(name)})
+	}
+	var mu sync.Mutex
+	probes := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Range") == "bytes=0-0" {
+			mu.Lock()
+			probes[req.URL.Path]++
+			mu.Unlock()
+		}
+		http.ServeFile(w, req, filepath.Join(dir, req.URL.Path))
+	}))
+	defer srv.Close()
+
+	saved := remoteArchives
+	remoteArchives = newRemoteArchiveCache(2)
+	defer func() { remoteArchives = saved }()
+
+	read := func(name string) {
+		t.Helper()
+		pk3, err := openPk3(srv.URL + "/" + name + ".pk3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pk3.Close()
+		f, err := pk3.Open("scripts/" + name + ".shader")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if data, _ := io.ReadAll(f); string(data) != name {
+			t.Errorf("%s.pk3 read %q", name, data)
+		}
+	}
+
+	// b is the least recently used when c is opened, so only b is reopened
+	read("a")
+	read("b")
+	read("a")
+	read("c")
+	read("a")
+	read("b")
+	want := map[string]int{"/a.pk3": 1, "/b.pk3": 2, "/c.pk3": 1}
+	for path, n := range want {
+		if probes[path] != n {
+			t.Errorf("%s opened %d times, want %d", path, probes[path], n)
+		}
+	}
+	if n := remoteArchives.order.Len(); n != 2 || len(remoteArchives.byURL) != 2 {
+		t.Errorf("cache holds %d archives (%d by url), want 2", n, len(remoteArchives.byURL))
+	}
+	if _, ok := remoteArchives.get(srv.URL + "/c.pk3"); ok {
+		t.Error("c.pk3 still cached")
+	}
+}
@@ -0,0 +1,142 @@
+package assets
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RepackOptions controls how RepackPk3 normalizes an archive.
+type RepackOptions struct {
+	KeepCase     bool   // keep entry paths' case instead of lowercasing them
+	StripSources bool   // drop editor/source files (.map, .xcf, .psd, ...)
+	Compression  string // pk3 compression level (see ParseCompression)
+}
+
+// RepackResult summarizes a repack.
+type RepackResult struct {
+	Entries int
+	Removed []string
+	InSize  int64
+	OutSize int64
+}
+
+// junkNames are OS metadata files that never belong in a pk3.
+var junkNames = map[string]bool{
+	"thumbs.db":   true,
+	".ds_store":   true,
+	"desktop.ini": true,
+}
+
+// sourceExtensions are editor/source formats the engine never loads.
+var sourceExtensions = map[string]bool{
+	".map": true,
+	".xcf": true,
+	".psd": true,
+	".bak": true,
+	".srf": true,
+	".prt": true,
+}
+
+// RepackPk3 rewrites inPath to outPath with normalized paths, junk entries
+// removed, and every entry recompressed. Entries are written in sorted order
+// so the output is deterministic. When two entries collide (e.g. after
+// lowercasing) the later one wins, as it would in the engine.
+func RepackPk3(inPath, outPath string, opts RepackOptions) (*RepackResult, error) {
+	if filepath.Clean(inPath) == filepath.Clean(outPath) {
+		return nil, fmt.Errorf("repack output must differ from input")
+	}
+	compression, err := ParseCompression(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := openPk3(inPath)
+	if err != nil {
+		return nil, fmt.Errorf("open pk3 %s: %w", inPath, err)
+	}
+	defer r.Close()
+
+	result := &RepackResult{}
+	if info, err := os.Stat(inPath); err == nil {
+		result.InSize = info.Size()
+	}
+
+	keep := make(map[string]*zip.File)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := strings.ReplaceAll(f.Name, "\\", "/")
+		if isRepackJunk(name, opts) {
+			result.Removed = append(result.Removed, f.Name)
+			continue
+		}
+		if !opts.KeepCase {
+			name = strings.ToLower(name)
+		}
+		if prev, ok := keep[name]; ok {
+			result.Removed = append(result.Removed, prev.Name)
+		}
+		keep[name] = f
+	}
+
+	names := make([]string, 0, len(keep))
+	for name := range keep {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", outPath, err)
+	}
+	defer out.Close()
+
+	pw := NewPk3Writer(out)
+	pw.SetCompression(compression)
+	for _, name := range names {
+		f := keep[name]
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("open %s in %s: %w", f.Name, inPath, err)
+		}
+		err = pw.AddEntry(name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := pw.Close(); err != nil {
+		return nil, fmt.Errorf("finish %s: %w", outPath, err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	result.Entries = len(names)
+	if info, err := os.Stat(outPath); err == nil {
+		result.OutSize = info.Size()
+	}
+	return result, nil
+}
+
+// isRepackJunk reports whether an entry should be dropped during repacking.
+func isRepackJunk(name string, opts RepackOptions) bool {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "__macosx/") || strings.Contains(lower, "/__macosx/") {
+		return true
+	}
+	base := path.Base(lower)
+	if junkNames[base] || strings.HasPrefix(base, "._") {
+		return true
+	}
+	if opts.StripSources && sourceExtensions[path.Ext(lower)] {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package assets
+
+import (
+	"archive/zip"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepackPk3(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.pk3")
+	writeFixturePk3(t, in, map[string][]byte{
+		"Textures/Custom/Floor.tga": fixtureImage("floor"),
+		"maps/custom.map":           []byte("// source"),
+		"__MACOSX/._floor.tga":      []byte("junk"),
+	})
+
+	out := filepath.Join(dir, "out.pk3")
+	result, err := RepackPk3(in, out, RepackOptions{StripSources: true, Compression: CompressionStore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Entries != 1 || len(result.Removed) != 2 {
+		t.Errorf("result = %+v, want 1 entry and 2 removed", result)
+	}
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.File) != 1 || r.File[0].Name != "textures/custom/floor.tga" || r.File[0].Method != zip.Store {
+		t.Errorf("entry = %s (method %d), want a stored, lowercased floor.tga", r.File[0].Name, r.File[0].Method)
+	}
+
+	if _, err := RepackPk3(in, out, RepackOptions{KeepCase: true}); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kept.Close()
+	var names []string
+	for _, f := range kept.File {
+		names = append(names, f.Name)
+	}
+	if !containsString(names, "Textures/Custom/Floor.tga") {
+		t.Errorf("KeepCase repack = %v", names)
+	}
+	if _, err := RepackPk3(in, out, RepackOptions{Compression: "max"}); err == nil {
+		t.Error("unknown compression accepted")
+	}
+}
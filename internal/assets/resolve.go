@@ -0,0 +1,91 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// FileResolution is the copy of a file a game loads: where it comes from and
+// what it holds, for tracking down assets clients report missing.
+type FileResolution struct {
+	Ref      string `json:"ref"`  // the path asked for, lowered
+	Path     string `json:"path"` // the file found: Ref itself, or the image a texture reference resolved to
+	Pk3      string `json:"pk3"`  // source pk3
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Baseline bool   `json:"baseline"`           // in the baseline or trinity pk3s, which clients already have
+	Official bool   `json:"official,omitempty"` // the copy is from an official id pak
+
+	Shader  string             `json:"shader,omitempty"`  // script defining a shader of Ref's name, if any
+	Texture *TextureResolution `json:"texture,omitempty"` // how a texture reference resolved
+}
+
+// ResolveFile finds the copy of a file the game loads, and hashes it. Image
+// paths and paths without an extension are texture references, resolved as
+// the renderer does (see GameManifest.ResolveTexture); anything else must be
+// in the file index as named. A texture reference that names a shader, as
+// most do, also reports its script; with no image, that's all it reports.
+// It returns ErrFileNotInIndex if there is neither.
+func (gm *GameManifest) ResolveFile(ref string) (*FileResolution, error) {
+	lower := strings.TrimPrefix(strings.ToLower(strings.ReplaceAll(ref, "\\", "/")), "/")
+	r := &FileResolution{Ref: lower}
+	if path.Ext(lower) == "" || isTextureFile(lower) {
+		r.Shader = gm.ShaderFiles[shaderLookupName(lower)]
+		trace := gm.TraceTexture(lower)
+		r.Texture = &trace
+		r.Path = trace.Winner
+	} else if _, ok := gm.FileIndex[lower]; ok {
+		r.Path = lower
+	}
+	if r.Path == "" {
+		if r.Shader != "" {
+			return r, nil
+		}
+		return nil, &ErrFileNotInIndex{Path: ref}
+	}
+
+	r.Pk3 = gm.FileIndex[r.Path]
+	r.Baseline = gm.BaselineFiles[r.Path]
+	r.Official = gm.OfficialFiles[r.Path]
+	var err error
+	if r.SHA256, r.Size, err = hashPk3Entry(r.Pk3, r.Path); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// hashPk3Entry returns the SHA-256 and size of a file in a pk3. The last
+// matching entry wins, as in BuildFileIndex.
+func hashPk3Entry(pk3Path, name string) (string, int64, error) {
+	r, err := openPk3(pk3Path)
+	if err != nil {
+		return "", 0, fmt.Errorf("open pk3 %s: %w", pk3Path, err)
+	}
+	defer r.Close()
+
+	lower := strings.ToLower(name)
+	found := -1
+	for i, f := range r.File {
+		if strings.ToLower(f.Name) == lower {
+			found = i
+		}
+	}
+	if found < 0 {
+		return "", 0, fmt.Errorf("%s not found in %s", name, pk3Path)
+	}
+	rc, err := r.File[found].Open()
+	if err != nil {
+		return "", 0, fmt.Errorf("open %s in %s: %w", name, pk3Path, err)
+	}
+	defer rc.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return "", 0, fmt.Errorf("read %s in %s: %w", name, pk3Path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
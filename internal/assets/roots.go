@@ -0,0 +1,97 @@
+package assets
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CollectGamePk3sFromRoots merges the game directories of several installs
+// into one, as if their pk3s had been copied into a single install. Roots are
+// given highest priority first. Each game's pk3s are the union of the roots'
+// in load order; where roots have a pk3 of the same name (relative to the
+// game directory, ignoring case), the highest-priority root's copy is used
+// and the others are returned as shadowed: passed-over path → path used.
+func CollectGamePk3sFromRoots(roots []string) (map[string][]string, map[string]string) {
+	type pk3Entry struct {
+		rel  string // lowered path relative to the game directory, or "" if outside it
+		path string
+	}
+	merged := make(map[string][]pk3Entry)
+	used := make(map[string]map[string]string) // game → rel → path used
+	shadowed := make(map[string]string)
+	for _, root := range roots {
+		for game, pk3s := range CollectGamePk3s(root) {
+			if used[game] == nil {
+				used[game] = make(map[string]string)
+			}
+			gameDir := filepath.Join(root, game)
+			for _, p := range pk3s {
+				rel, err := filepath.Rel(gameDir, p)
+				if err != nil || strings.HasPrefix(rel, "..") {
+					// Outside the game directory, as Quake Live workshop
+					// items are; nothing to merge with
+					merged[game] = append(merged[game], pk3Entry{path: p})
+					continue
+				}
+				rel = strings.ToLower(filepath.ToSlash(rel))
+				if winner, ok := used[game][rel]; ok {
+					shadowed[p] = winner
+					continue
+				}
+				used[game][rel] = p
+				merged[game] = append(merged[game], pk3Entry{rel: rel, path: p})
+			}
+		}
+	}
+
+	result := make(map[string][]string, len(merged))
+	for game, entries := range merged {
+		// The load order of a single directory (see collectPk3FilesFromDir):
+		// numbered paks, then the rest by name, then anything from outside
+		sort.SliceStable(entries, func(i, j int) bool {
+			oi, oj := pk3LoadGroup(entries[i].rel), pk3LoadGroup(entries[j].rel)
+			if oi != oj {
+				return oi < oj
+			}
+			return oi < 2 && entries[i].rel < entries[j].rel
+		})
+		paths := make([]string, len(entries))
+		for i, e := range entries {
+			paths[i] = e.path
+		}
+		result[game] = paths
+	}
+	return result, shadowed
+}
+
+// pk3LoadGroup orders a pk3 by its path relative to its game directory: 0
+// for the numbered paks at the top level, 1 for other pk3s, and 2 for pk3s
+// outside the game directory (rel "").
+func pk3LoadGroup(rel string) int {
+	switch {
+	case rel == "":
+		return 2
+	case !strings.Contains(rel, "/") && len(rel) == 8 && strings.HasPrefix(rel, "pak") && rel[3] >= '0' && rel[3] <= '9':
+		return 0
+	}
+	return 1
+}
+
+// Root returns the install root, of those the manifest was built from, that
+// a source pk3 is in: the provenance of each file in a game's FileIndex. It
+// returns "" for manifests built from a single install.
+func (m *Manifest) Root(pk3Path string) string {
+	for _, root := range m.Roots {
+		if rel, err := filepath.Rel(root, pk3Path); err == nil && !strings.HasPrefix(rel, "..") {
+			return root
+		}
+	}
+	return ""
+}
+
+// buildRoots returns the installs a build merges, highest priority first:
+// the Quake 3 directory, then opts.Roots.
+func buildRoots(quake3Dir string, opts BuildOptions) []string {
+	return append([]string{quake3Dir}, opts.Roots...)
+}
@@ -0,0 +1,70 @@
+package assets
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCollectGamePk3sFromRoots(t *testing.T) {
+	primary, secondary := t.TempDir(), t.TempDir()
+	writeFixturePk3(t, filepath.Join(primary, "baseq3", "pak0.pk3"), map[string][]byte{"a.txt": []byte("a")})
+	writeFixturePk3(t, filepath.Join(primary, "baseq3", "zz.pk3"), map[string][]byte{"z.txt": []byte("z")})
+	writeFixturePk3(t, filepath.Join(secondary, "baseq3", "PAK0.pk3"), map[string][]byte{"a.txt": []byte("old")})
+	writeFixturePk3(t, filepath.Join(secondary, "baseq3", "pak1.pk3"), map[string][]byte{"b.txt": []byte("b")})
+	writeFixturePk3(t, filepath.Join(secondary, "baseq3", "map-x.pk3"), map[string][]byte{"x.txt": []byte("x")})
+	writeFixturePk3(t, filepath.Join(secondary, "cpma", "z-cpma.pk3"), map[string][]byte{"c.txt": []byte("c")})
+
+	gamePk3s, shadowed := CollectGamePk3sFromRoots([]string{primary, secondary})
+
+	want := []string{
+		filepath.Join(primary, "baseq3", "pak0.pk3"),
+		filepath.Join(secondary, "baseq3", "pak1.pk3"),
+		filepath.Join(secondary, "baseq3", "map-x.pk3"),
+		filepath.Join(primary, "baseq3", "zz.pk3"),
+	}
+	if !slices.Equal(gamePk3s["baseq3"], want) {
+		t.Errorf("baseq3 pk3s = %v, want %v", gamePk3s["baseq3"], want)
+	}
+	if len(gamePk3s["cpma"]) != 1 {
+		t.Errorf("cpma pk3s = %v, want the secondary root's", gamePk3s["cpma"])
+	}
+	if got := shadowed[filepath.Join(secondary, "baseq3", "PAK0.pk3")]; got != want[0] {
+		t.Errorf("PAK0.pk3 shadowed by %q, want %q", got, want[0])
+	}
+	if len(shadowed) != 1 {
+		t.Errorf("shadowed = %v, want just PAK0.pk3", shadowed)
+	}
+}
+
+func TestBuildBaselineRoots(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	extra := t.TempDir()
+	writeFixturePk3(t, filepath.Join(extra, "baseq3", "pak0.pk3"), map[string][]byte{
+		"gfx/2d/crosshaira.tga": fixtureImage("old"),
+	})
+	writeFixturePk3(t, filepath.Join(extra, "baseq3", "zz-extra.pk3"), map[string][]byte{
+		"sound/extra/beep.wav": []byte("RIFF beep"),
+	})
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{Roots: []string{extra}}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	m, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	if !slices.Equal(m.Roots, []string{q, extra}) {
+		t.Errorf("Roots = %v", m.Roots)
+	}
+	gm := m.Games["baseq3"]
+	if src := gm.FileIndex["gfx/2d/crosshaira.tga"]; m.Root(src) != q {
+		t.Errorf("crosshaira.tga from %s, want the primary root", src)
+	}
+	if src := gm.FileIndex["sound/extra/beep.wav"]; m.Root(src) != extra {
+		t.Errorf("beep.wav from %s, want the extra root", src)
+	}
+	if got := gm.Shadowed[filepath.Join(extra, "baseq3", "pak0.pk3")]; got != filepath.Join(q, "baseq3", "pak0.pk3") {
+		t.Errorf("Shadowed = %v", gm.Shadowed)
+	}
+}
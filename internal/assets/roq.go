@@ -0,0 +1,125 @@
+package assets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RoQ chunk IDs, from the engine's cinematic decoder.
+const (
+	roqSignature = 0x1084
+	roqInfo      = 0x1001
+	roqQuadVQ    = 0x1011
+	roqJPEG      = 0x1012
+)
+
+// RoQInfo is what a RoQ video's chunk headers say about it.
+type RoQInfo struct {
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	FPS      int     `json:"fps"`
+	Frames   int     `json:"frames"`
+	Duration float64 `json:"duration"` // seconds
+}
+
+// ParseRoQ reads a RoQ video's chunk headers, skipping the chunk data, for
+// its dimensions, frame rate, and frame count.
+func ParseRoQ(r io.Reader) (*RoQInfo, error) {
+	var header [8]byte // id u16, size u32, argument u16
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("read RoQ header: %w", err)
+	}
+	if binary.LittleEndian.Uint16(header[0:]) != roqSignature {
+		return nil, fmt.Errorf("not a RoQ file")
+	}
+	info := &RoQInfo{FPS: int(binary.LittleEndian.Uint16(header[6:]))}
+	if info.FPS == 0 {
+		info.FPS = 30 // the engine's default
+	}
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break // trailing partial chunks are ignored, as in game
+			}
+			return nil, err
+		}
+		id := binary.LittleEndian.Uint16(header[0:])
+		size := int64(binary.LittleEndian.Uint32(header[2:]))
+		switch id {
+		case roqInfo:
+			var dims [4]byte
+			if _, err := io.ReadFull(r, dims[:]); err != nil {
+				return info, nil
+			}
+			info.Width = int(binary.LittleEndian.Uint16(dims[0:]))
+			info.Height = int(binary.LittleEndian.Uint16(dims[2:]))
+			size -= int64(len(dims))
+		case roqQuadVQ, roqJPEG:
+			info.Frames++
+		}
+		if size > 0 {
+			if n, err := io.CopyN(io.Discard, r, size); err != nil || n < size {
+				break
+			}
+		}
+	}
+	info.Duration = float64(info.Frames) / float64(info.FPS)
+	return info, nil
+}
+
+// isVideoFile reports whether a lowered path is a RoQ video.
+func isVideoFile(p string) bool {
+	return strings.HasSuffix(p, ".roq")
+}
+
+// cinematicPath returns the file the engine plays for a cinematic name:
+// names without a directory are looked up under video/.
+func cinematicPath(name string) string {
+	lower := strings.ToLower(strings.ReplaceAll(name, "\\", "/"))
+	if !strings.Contains(lower, "/") {
+		lower = "video/" + lower
+	}
+	return path.Clean(lower)
+}
+
+// findVideoRefs returns the RoQ videos named in a configstring or other
+// free-form value: backslash-separated info strings and plain text alike.
+func findVideoRefs(s string) []string {
+	var refs []string
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == '\\' || r == ' ' || r == '\t' || r == '"' || r == ';'
+	}) {
+		if isVideoFile(strings.ToLower(field)) {
+			refs = append(refs, field)
+		}
+	}
+	return refs
+}
+
+// parseVideosPk3 reads the header info of each RoQ video in a pk3 into videos.
+func parseVideosPk3(pk3Path string, videos map[string]*RoQInfo) error {
+	return IteratePk3(pk3Path, func(name string, open func() (io.ReadCloser, error)) error {
+		lower := strings.ToLower(name)
+		if !isVideoFile(lower) {
+			return nil
+		}
+		rc, err := open()
+		if err != nil {
+			return nil
+		}
+		defer rc.Close()
+		info, err := ParseRoQ(rc)
+		if err != nil {
+			log.Printf("Warning: %s in %s: %v", lower, filepath.Base(pk3Path), err)
+			return nil
+		}
+		videos[lower] = info
+		return nil
+	})
+}
@@ -0,0 +1,67 @@
+package assets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Server map states: whether players joining a server can get its map
+const (
+	ServerMapReady      = "ready"       // the map pk3 is built
+	ServerMapMissingPk3 = "missing-pk3" // the install has the map but its pk3 isn't built
+	ServerMapUnknown    = "unknown-map" // the install doesn't have the map
+)
+
+// ServerMap is what a game server is running, checked against a build.
+type ServerMap struct {
+	Address string `json:"address"`
+	Name    string `json:"name,omitempty"`
+	Map     string `json:"map"`
+	FSGame  string `json:"fs_game,omitempty"`
+	Game    string `json:"game"`          // manifest game the map resolves against
+	Pk3     string `json:"pk3,omitempty"` // output-relative map pk3 path
+	Status  string `json:"status"`
+}
+
+func (s ServerMap) String() string {
+	server := s.Address
+	if s.Name != "" {
+		server = s.Name + " (" + s.Address + ")"
+	}
+	switch s.Status {
+	case ServerMapMissingPk3:
+		return fmt.Sprintf("%s: %s/%s: map pk3 %s not built", server, s.Game, s.Map, s.Pk3)
+	case ServerMapUnknown:
+		return fmt.Sprintf("%s: %s/%s: map not in the install", server, s.Game, s.Map)
+	}
+	return fmt.Sprintf("%s: %s/%s: ready", server, s.Game, s.Map)
+}
+
+// CheckServerMap checks whether the map a server is running, as reported by
+// its getinfo or getstatus mapname and fs_game, has a map pk3 in the build
+// at outputDir. A pk3 built since the manifest was written counts as built.
+func CheckServerMap(manifest *Manifest, outputDir, fsGame, mapName string) (ServerMap, error) {
+	sm := ServerMap{Map: strings.ToLower(mapName), FSGame: fsGame}
+	if sm.Map == "" {
+		return sm, fmt.Errorf("server reported no map")
+	}
+	game, gm, ok := manifest.GameFor(fsGame)
+	if !ok {
+		return sm, fmt.Errorf("no game manifest for fs_game %q", fsGame)
+	}
+	sm.Game = game
+	if _, ok := gm.FileIndex["maps/"+sm.Map+".bsp"]; !ok {
+		sm.Status = ServerMapUnknown
+		return sm, nil
+	}
+	sm.Pk3 = "maps/" + sm.Map + ".pk3"
+	sm.Status = ServerMapReady
+	if _, ok := manifest.Artifacts[sm.Pk3]; !ok {
+		if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(sm.Pk3))); err != nil {
+			sm.Status = ServerMapMissingPk3
+		}
+	}
+	return sm, nil
+}
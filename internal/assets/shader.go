@@ -1,7 +1,7 @@
 package assets
 
 import (
-	"bufio"
+	"fmt"
 	"io"
 	"strings"
 )
@@ -10,144 +10,208 @@ import (
 type ShaderDef struct {
 	Name     string
 	Textures []string
+	Shaders  []string // other shaders it pulls in (see appendShaderLinks)
+
+	// LightImages are the images q3map_lightImage has q3map2 take the
+	// surface's light color from. They're only read when compiling a map,
+	// so they're kept for diagnostics, never packed.
+	LightImages []string
 }
 
 // ParseShaderScript parses a .shader text file and extracts shader definitions
-// with their texture dependencies.
+// with their texture dependencies. A directive is the words of a line up to
+// the next brace, so compact shaders ("{ map foo.tga }") parse as the engine
+// reads them. It's lenient: unbalanced braces end or skip shaders rather
+// than failing (see ParseShaderAST for a strict parse).
 func ParseShaderScript(r io.Reader) ([]ShaderDef, error) {
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // 1MB buffer for large shader files
+	// Read straight into a string, rather than copying a byte slice into one
+	var text strings.Builder
+	if _, err := io.Copy(&text, r); err != nil {
+		return nil, err
+	}
 
 	var shaders []ShaderDef
 	var current *ShaderDef
 	depth := 0
-	inBlockComment := false
-
-	for scanner.Scan() {
-		line := scanner.Text()
+	var directive []string
+	directiveLine := 0
 
-		// Handle block comments
-		if inBlockComment {
-			if idx := strings.Index(line, "*/"); idx >= 0 {
-				line = line[idx+2:]
-				inBlockComment = false
-			} else {
-				continue
-			}
+	flush := func() {
+		if len(directive) == 0 {
+			return
 		}
-
-		// Process comments: find whichever comment marker comes first
-		for {
-			slashSlash := strings.Index(line, "//")
-			slashStar := strings.Index(line, "/*")
-
-			if slashStar >= 0 && (slashSlash < 0 || slashStar < slashSlash) {
-				// /* comes first — handle block comment
-				endIdx := strings.Index(line[slashStar+2:], "*/")
-				if endIdx >= 0 {
-					line = line[:slashStar] + line[slashStar+2+endIdx+2:]
-					continue // re-check for more comments
-				} else {
-					line = line[:slashStar]
-					inBlockComment = true
-					break
-				}
-			} else if slashSlash >= 0 {
-				// // comes first — strip rest of line
-				line = line[:slashSlash]
-				break
-			} else {
-				break
+		switch {
+		case depth == 0:
+			// Shader name
+			current = &ShaderDef{Name: strings.Clone(directive[0])}
+		case current != nil:
+			// Parse directives inside shader (depth >= 1). Kept paths are
+			// cloned, so they don't pin the whole script in memory.
+			n := len(current.Textures)
+			current.Textures = appendShaderTextures(current.Textures, directive, depth >= 2)
+			cloneStrings(current.Textures[n:])
+			if depth == 1 {
+				n := len(current.Shaders)
+				current.Shaders = appendShaderLinks(current.Shaders, directive)
+				cloneStrings(current.Shaders[n:])
+				n = len(current.LightImages)
+				current.LightImages = appendShaderLightImages(current.LightImages, directive)
+				cloneStrings(current.LightImages[n:])
 			}
 		}
+		directive = directive[:0]
+	}
 
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Process braces and content, handling compact formatting where
-		// braces share a line with directives (e.g. "{ map foo.tga")
-		for line != "" {
-			if line[0] == '{' {
-				depth++
-				line = strings.TrimSpace(line[1:])
-				continue
+	tok := NewTokenizer(text.String())
+	for t, ok := tok.Next(); ok; t, ok = tok.Next() {
+		switch t.Kind {
+		case TokenWord:
+			if t.Line != directiveLine {
+				flush()
+				directiveLine = t.Line
 			}
-			if line[0] == '}' {
+			directive = append(directive, t.Text)
+		case TokenOpen:
+			flush()
+			depth++
+		case TokenClose:
+			flush()
+			if depth > 0 {
 				depth--
 				if depth == 0 && current != nil {
 					shaders = append(shaders, *current)
 					current = nil
 				}
-				line = strings.TrimSpace(line[1:])
-				continue
 			}
+		}
+	}
+	return shaders, nil
+}
 
-			// Extract content up to the next brace (or end of line)
-			var content string
-			if idx := strings.IndexAny(line, "{}"); idx >= 0 {
-				content = strings.TrimSpace(line[:idx])
-				line = line[idx:] // leave brace for next iteration
-			} else {
-				content = line
-				line = ""
-			}
+// ExtractShaderBlock returns the source of one shader definition in a pk3's
+// shader script, exactly as written: from its name through the closing
+// brace of its body, comments and layout included. The name matches
+// case-insensitively; if the script defines it more than once, the first
+// definition is returned, as the engine uses.
+func ExtractShaderBlock(pk3Path, scriptPath, shaderName string) (string, error) {
+	data, err := ReadFileFromPk3(pk3Path, scriptPath)
+	if err != nil {
+		return "", err
+	}
+	return shaderBlock(string(data), shaderName, scriptPath)
+}
 
-			if content == "" {
-				continue
+// shaderBlock finds shaderName's definition in the text of a script.
+func shaderBlock(text, shaderName, scriptPath string) (string, error) {
+	depth := 0
+	start, found := 0, false
+	tok := NewTokenizer(text)
+	for t, ok := tok.Next(); ok; t, ok = tok.Next() {
+		switch t.Kind {
+		case TokenWord:
+			if depth == 0 && !found && strings.EqualFold(t.Text, shaderName) {
+				start, found = t.Offset, true
 			}
-
+		case TokenOpen:
+			depth++
+		case TokenClose:
 			if depth == 0 {
-				// Shader name
-				current = &ShaderDef{Name: content}
 				continue
 			}
-
-			if current == nil {
-				continue
+			depth--
+			if depth == 0 && found {
+				return text[start : t.Offset+1], nil
 			}
+		}
+	}
+	if found {
+		return "", fmt.Errorf("shader %s in %s: missing }", shaderName, scriptPath)
+	}
+	return "", fmt.Errorf("shader %s not found in %s", shaderName, scriptPath)
+}
 
-			// Parse directives inside shader (depth >= 1)
-			tokens := tokenizeLine(content)
-			if len(tokens) == 0 {
-				continue
-			}
+// cloneStrings replaces each string in list with a copy of its own.
+func cloneStrings(list []string) {
+	for i, s := range list {
+		list[i] = strings.Clone(s)
+	}
+}
 
-			directive := strings.ToLower(tokens[0])
-			switch directive {
-			case "map", "clampmap", "diffusemap", "normalmap", "specularmap":
-				if len(tokens) >= 2 {
-					path := tokens[1]
-					if !strings.HasPrefix(path, "$") {
-						current.Textures = append(current.Textures, path)
-					}
-				}
-			case "animmap":
-				// animMap <freq> <path1> <path2> ...
-				if len(tokens) >= 3 {
-					for _, path := range tokens[2:] {
-						if !strings.HasPrefix(path, "$") {
-							current.Textures = append(current.Textures, path)
-						}
-					}
+// appendShaderTextures appends the textures referenced by a tokenized line of
+// a shader: a general directive, or one inside a stage if stage is set. As in
+// the engine, stage images are only loaded inside stages and skies only
+// outside them, and a line can hold several directives, as compact shaders
+// write them ("rgbGen identity map foo.tga blendFunc add"). Engine images
+// ($lightmap, $whiteimage, *white, ...) need no file and are skipped.
+func appendShaderTextures(textures []string, tokens []string, stage bool) []string {
+	image := func(path string) {
+		if !strings.HasPrefix(path, "$") && !strings.HasPrefix(path, "*") {
+			textures = append(textures, path)
+		}
+	}
+	for i := 0; i < len(tokens); i++ {
+		keyword, args := strings.ToLower(tokens[i]), tokens[i+1:]
+		if !stage {
+			// skyparms <farbox> <cloudheight> <nearbox>
+			if keyword == "skyparms" && len(args) >= 1 && args[0] != "-" {
+				for _, suffix := range []string{"_rt", "_lf", "_bk", "_ft", "_up", "_dn"} {
+					textures = append(textures, args[0]+suffix)
 				}
-			case "skyparms":
-				// skyparms <farbox> - -
-				if len(tokens) >= 2 && tokens[1] != "-" {
-					base := tokens[1]
-					for _, suffix := range []string{"_rt", "_lf", "_bk", "_ft", "_up", "_dn"} {
-						current.Textures = append(current.Textures, base+suffix)
-					}
+				i++
+			}
+			continue
+		}
+		switch keyword {
+		case "map", "clampmap", "diffusemap", "normalmap", "normalparallaxmap", "specularmap":
+			if len(args) >= 1 {
+				image(args[0])
+				i++
+			}
+		case "animmap", "clampanimmap":
+			// animMap <freq> <path1> <path2> ..., to the end of the line
+			if len(args) >= 2 {
+				for _, path := range args[1:] {
+					image(path)
 				}
 			}
+			return textures
+		case "videomap":
+			// videoMap <cinematic>, played from video/ unless a path is given
+			if len(args) >= 1 {
+				textures = append(textures, cinematicPath(args[0]))
+				i++
+			}
+		case "tcmod":
+			// tcMod takes the rest of the line as its arguments
+			return textures
 		}
 	}
+	return textures
+}
 
-	return shaders, scanner.Err()
+// appendShaderLinks appends the shaders a tokenized general directive line
+// pulls in: q3map_backShader and q3map_cloneShader have q3map2 give the
+// surface's back side, or a copy of it, another shader, which the map then
+// needs as much as its own.
+func appendShaderLinks(links []string, tokens []string) []string {
+	for i := 0; i < len(tokens)-1; i++ {
+		switch strings.ToLower(tokens[i]) {
+		case "q3map_backshader", "q3map_cloneshader":
+			links = append(links, tokens[i+1])
+			i++
+		}
+	}
+	return links
 }
 
-// tokenizeLine splits a shader line into whitespace-separated tokens.
-func tokenizeLine(line string) []string {
-	return strings.Fields(line)
+// appendShaderLightImages appends the images a tokenized general directive
+// line names with q3map_lightImage.
+func appendShaderLightImages(images []string, tokens []string) []string {
+	for i := 0; i < len(tokens)-1; i++ {
+		if strings.EqualFold(tokens[i], "q3map_lightimage") {
+			images = append(images, tokens[i+1])
+			i++
+		}
+	}
+	return images
 }
@@ -0,0 +1,355 @@
+package assets
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Shaders in the styles OSP, CPMA, and other mods' HUD and map scripts use
+var trickyShaderTests = []struct {
+	name   string
+	script string
+	want   map[string][]string // shader name → textures
+}{
+	{
+		name: "compact one-line stages",
+		script: `gfx/2d/osp_hud
+{
+	nopicmip
+	{ map gfx/2d/osp_hud.tga blendFunc GL_SRC_ALPHA GL_ONE_MINUS_SRC_ALPHA rgbGen vertex }
+}
+gfx/2d/osp_bar { { clampmap gfx/2d/osp_bar.tga blendfunc blend } }
+`,
+		want: map[string][]string{
+			"gfx/2d/osp_hud": {"gfx/2d/osp_hud.tga"},
+			"gfx/2d/osp_bar": {"gfx/2d/osp_bar.tga"},
+		},
+	},
+	{
+		name: "image after other directives on the line",
+		script: `textures/osp/flag
+{
+	{
+		rgbGen identity Map textures/osp/flag.tga
+		tcMod scroll 0 1 map textures/osp/not_loaded.tga
+	}
+}
+`,
+		want: map[string][]string{"textures/osp/flag": {"textures/osp/flag.tga"}},
+	},
+	{
+		name: "engine images",
+		script: `textures/osp/glass
+{
+	{
+		map $lightmap
+	}
+	{
+		clampMap $whiteimage
+		rgbGen const ( 1 1 1 )
+	}
+	{
+		map *white
+	}
+	{
+		map textures/osp/glass_env.jpg
+	}
+}
+`,
+		want: map[string][]string{"textures/osp/glass": {"textures/osp/glass_env.jpg"}},
+	},
+	{
+		name: "stage keywords outside stages",
+		script: `textures/osp/sky
+{
+	map textures/osp/not_a_stage.tga
+	skyparms env/osp - -
+	{
+		map textures/osp/clouds.tga
+	}
+}
+`,
+		want: map[string][]string{"textures/osp/sky": {
+			"env/osp_rt", "env/osp_lf", "env/osp_bk", "env/osp_ft", "env/osp_up", "env/osp_dn",
+			"textures/osp/clouds.tga",
+		}},
+	},
+	{
+		name: "animMap and comments",
+		script: `/* OSP
+   scoreboard */ gfx/2d/osp_anim
+{
+	{ // frames
+		animMap 4 gfx/2d/a1.tga gfx/2d/a2.tga gfx/2d/a3.tga
+		clampAnimMap 2 gfx/2d/b1.tga gfx/2d/b2.tga
+	}
+}
+`,
+		want: map[string][]string{"gfx/2d/osp_anim": {
+			"gfx/2d/a1.tga", "gfx/2d/a2.tga", "gfx/2d/a3.tga", "gfx/2d/b1.tga", "gfx/2d/b2.tga",
+		}},
+	},
+	{
+		name: "quoted paths",
+		script: `textures/osp/quoted
+{
+	{
+		map "textures/osp/my texture.tga"
+	}
+}
+`,
+		want: map[string][]string{"textures/osp/quoted": {"textures/osp/my texture.tga"}},
+	},
+	{
+		name: "q3map_lightImage",
+		script: `textures/osp/light
+{
+	q3map_lightImage textures/osp/light_color.tga
+	q3map_surfacelight 500
+	{
+		map textures/osp/light.tga
+	}
+}
+`,
+		want: map[string][]string{"textures/osp/light": {"textures/osp/light.tga"}},
+	},
+	{
+		name: "no stages",
+		script: `textures/osp/clip
+{
+	surfaceparm nodraw
+	surfaceparm playerclip
+}
+`,
+		want: map[string][]string{"textures/osp/clip": nil},
+	},
+}
+
+func TestParseShaderScriptTrickyShaders(t *testing.T) {
+	for _, tt := range trickyShaderTests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs, err := ParseShaderScript(strings.NewReader(tt.script))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string][]string)
+			for _, def := range defs {
+				got[def.Name] = def.Textures
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseShaderScript textures = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// ParseShaderAST, which tokenizes differently, must find the same textures
+func TestShaderASTTexturesMatchParseShaderScript(t *testing.T) {
+	for _, tt := range trickyShaderTests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := ParseShaderAST(strings.NewReader(tt.script), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string][]string)
+			for _, sh := range script.Shaders {
+				got[sh.Name] = sh.Textures()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Shader.Textures = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvedShaderTexturesFallback(t *testing.T) {
+	gm := &GameManifest{
+		FileIndex: map[string]string{
+			"models/osp/skin.tga":      "a.pk3",
+			"models/osp/skin_glow.jpg": "a.pk3",
+			"models/osp/plain.tga":     "a.pk3",
+			"models/osp/plain.jpg":     "a.pk3",
+			"textures/osp/clip.tga":    "a.pk3",
+			"textures/osp/shine.jpg":   "a.pk3",
+			"textures/osp/white.tga":   "a.pk3",
+		},
+		Shaders: map[string][]string{
+			"models/osp/skin":     {"models/osp/skin.tga", "models/osp/skin_glow.tga"},
+			"models/osp/broken":   {"models/osp/gone.tga"},
+			"textures/osp/clip":   nil,
+			"textures/osp/white":  {},
+			"textures/osp/shine2": {"textures/osp/shine.tga"},
+		},
+	}
+	tests := []struct {
+		ref  string
+		want []string
+	}{
+		// Definitions are found without the reference's extension, and each
+		// stage image tries every image type
+		{"models/osp/skin.tga", []string{"models/osp/skin.tga", "models/osp/skin_glow.jpg"}},
+		{"models/osp/skin", []string{"models/osp/skin.tga", "models/osp/skin_glow.jpg"}},
+		{"textures/osp/shine2", []string{"textures/osp/shine.jpg"}},
+		// A missing stage image has no fallback
+		{"models/osp/broken", nil},
+		// Without a definition the reference is the image, its own type first
+		{"models/osp/plain.jpg", []string{"models/osp/plain.jpg"}},
+		{"models/osp/plain.tga", []string{"models/osp/plain.tga"}},
+		{"models/osp/plain", []string{"models/osp/plain.tga"}},
+		{"models/osp/none", nil},
+		// Definitions without images of their own keep their namesake image
+		{"textures/osp/clip", []string{"textures/osp/clip.tga"}},
+		{"textures/osp/white", []string{"textures/osp/white.tga"}},
+	}
+	for _, tt := range tests {
+		if got := resolvedShaderTextures(tt.ref, gm); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resolvedShaderTextures(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestShaderLinks(t *testing.T) {
+	defs, err := ParseShaderScript(strings.NewReader(`textures/osp/fence
+{
+	q3map_backShader textures/osp/fence_back
+	q3map_cloneShader textures/osp/fence_clone
+	{
+		map textures/osp/fence.tga
+	}
+}
+textures/osp/fence_back
+{
+	q3map_cloneShader textures/osp/fence
+	{
+		map textures/osp/fence_back.tga
+	}
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"textures/osp/fence_back", "textures/osp/fence_clone"}; !reflect.DeepEqual(defs[0].Shaders, want) {
+		t.Errorf("Shaders = %q, want %q", defs[0].Shaders, want)
+	}
+
+	// Linked shaders' textures are needed too, cycles and all
+	gm := &GameManifest{
+		FileIndex: map[string]string{
+			"textures/osp/fence.tga":       "a.pk3",
+			"textures/osp/fence_back.tga":  "a.pk3",
+			"textures/osp/fence_clone.jpg": "a.pk3",
+		},
+		Shaders:     map[string][]string{},
+		ShaderFiles: map[string]string{},
+		ShaderLinks: map[string][]string{},
+	}
+	for _, def := range defs {
+		gm.Shaders[def.Name] = def.Textures
+		gm.ShaderLinks[def.Name] = def.Shaders
+	}
+	needed := newDepSet()
+	resolveShaderTextures("textures/osp/fence", "maps/osp.bsp", gm, needed)
+	for file := range gm.FileIndex {
+		if !needed.files[file] {
+			t.Errorf("%s not needed", file)
+		}
+	}
+	if len(needed.diags) > 0 {
+		t.Errorf("diagnostics: %v", needed.diags)
+	}
+}
+
+func TestShaderLightImages(t *testing.T) {
+	defs, err := ParseShaderScript(strings.NewReader(`textures/osp/light
+{
+	q3map_lightImage textures/osp/light_color.tga
+	{
+		map textures/osp/light.tga
+	}
+}
+textures/osp/lamp
+{
+	q3map_lightImage textures/osp/lamp_color.tga
+	{
+		map textures/osp/lamp.tga
+	}
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"textures/osp/light_color.tga"}; !reflect.DeepEqual(defs[0].LightImages, want) {
+		t.Errorf("LightImages = %q, want %q", defs[0].LightImages, want)
+	}
+
+	// Light images are only checked: one that's there isn't packed, and
+	// one that's missing is diagnosed
+	gm := &GameManifest{
+		FileIndex: map[string]string{
+			"textures/osp/light.tga":       "a.pk3",
+			"textures/osp/light_color.tga": "a.pk3",
+			"textures/osp/lamp.tga":        "a.pk3",
+		},
+		Shaders:     map[string][]string{},
+		ShaderFiles: map[string]string{},
+		LightImages: map[string][]string{},
+	}
+	for _, def := range defs {
+		gm.Shaders[def.Name] = def.Textures
+		gm.LightImages[def.Name] = def.LightImages
+	}
+	needed := newDepSet()
+	resolveShaderTextures("textures/osp/light", "maps/osp.bsp", gm, needed)
+	resolveShaderTextures("textures/osp/lamp", "maps/osp.bsp", gm, needed)
+	if !needed.files["textures/osp/light.tga"] || !needed.files["textures/osp/lamp.tga"] {
+		t.Errorf("needed = %v, want the stage images", sortedMapKeys(needed.files))
+	}
+	if needed.files["textures/osp/light_color.tga"] {
+		t.Error("light image packed")
+	}
+	if len(needed.diags) != 1 || needed.diags[0].Kind != DiagMissingLight || needed.diags[0].Subject != "textures/osp/lamp_color.tga" {
+		t.Errorf("diagnostics = %v, want the missing lamp light image", needed.diags)
+	}
+}
+
+// benchmarkShaderScript is a large script in the styles the tricky shader
+// tests cover, about the size of a mod's biggest shader files.
+func benchmarkShaderScript() []byte {
+	var b strings.Builder
+	for i := 0; i < 200; i++ {
+		for _, tt := range trickyShaderTests {
+			b.WriteString(tt.script)
+		}
+	}
+	return []byte(b.String())
+}
+
+func BenchmarkParseShaderScript(b *testing.B) {
+	data := benchmarkShaderScript()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		if _, err := ParseShaderScript(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExtractShaderBlock(t *testing.T) {
+	script := "// header\ntextures/osp/a\n{\n\tsurfaceparm nodraw\n}\n\nTextures/OSP/b // the one\n{\n\t{ map \"x}.tga\" } // brace in a string\n}\ntextures/osp/b\n{\n}\n"
+	pk3 := filepath.Join(t.TempDir(), "osp.pk3")
+	writeFixturePk3(t, pk3, map[string][]byte{"scripts/osp.shader": []byte(script)})
+
+	got, err := ExtractShaderBlock(pk3, "scripts/OSP.shader", "textures/osp/b")
+	if err != nil {
+		t.Fatalf("ExtractShaderBlock: %v", err)
+	}
+	if want := "Textures/OSP/b // the one\n{\n\t{ map \"x}.tga\" } // brace in a string\n}"; got != want {
+		t.Errorf("block = %q, want %q", got, want)
+	}
+	if _, err := ExtractShaderBlock(pk3, "scripts/osp.shader", "textures/osp/missing"); err == nil {
+		t.Error("missing shader found")
+	}
+}
@@ -0,0 +1,305 @@
+package assets
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ShaderScript is a fully parsed .shader file. Unlike ParseShaderScript,
+// which keeps only texture references, it holds every directive and stage
+// so shaders can be inspected, edited, and written back out with
+// WriteShaderScript.
+type ShaderScript struct {
+	Shaders  []*Shader
+	Comments []string // comments after the last shader
+}
+
+// Shader is one shader definition.
+type Shader struct {
+	Name             string
+	Comments         []string           // comments preceding the shader
+	Directives       []*ShaderDirective // general directives: surfaceparm, cull, skyparms, ...
+	Stages           []*ShaderStage
+	TrailingComments []string // comments before the closing brace
+}
+
+// ShaderStage is a { ... } block inside a shader.
+type ShaderStage struct {
+	Comments         []string
+	Directives       []*ShaderDirective
+	TrailingComments []string
+}
+
+// ShaderDirective is a keyword and its arguments, as written on one line.
+type ShaderDirective struct {
+	Name        string
+	Args        []string
+	Comments    []string // comments on the lines before
+	LineComment string   // comment following the directive on the same line
+}
+
+// ParseShaderAST parses a .shader file into a ShaderScript. Comments are
+// kept, attached to the element that follows them, only if keepComments is
+// set. Tokenizing follows the engine: quoted strings are single tokens and
+// comments only start at a token boundary. Unlike ParseShaderScript, which
+// is lenient, unbalanced braces are an error.
+func ParseShaderAST(r io.Reader, keepComments bool) (*ShaderScript, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &shaderParser{tokens: Tokenize(string(data), true), keepComments: keepComments}
+	return p.script()
+}
+
+// Shader returns the first shader named name, case-insensitively, or nil.
+func (s *ShaderScript) Shader(name string) *Shader {
+	for _, sh := range s.Shaders {
+		if strings.EqualFold(sh.Name, name) {
+			return sh
+		}
+	}
+	return nil
+}
+
+// Directive returns the shader's first general directive named name,
+// case-insensitively, or nil.
+func (sh *Shader) Directive(name string) *ShaderDirective {
+	return findShaderDirective(sh.Directives, name)
+}
+
+// Directive returns the stage's first directive named name, case-insensitively, or nil.
+func (st *ShaderStage) Directive(name string) *ShaderDirective {
+	return findShaderDirective(st.Directives, name)
+}
+
+// Textures returns the textures the shader references, as ParseShaderScript
+// would report them.
+func (sh *Shader) Textures() []string {
+	var textures []string
+	for _, d := range sh.Directives {
+		textures = appendShaderTextures(textures, d.tokens(), false)
+	}
+	for _, st := range sh.Stages {
+		for _, d := range st.Directives {
+			textures = appendShaderTextures(textures, d.tokens(), true)
+		}
+	}
+	return textures
+}
+
+func (d *ShaderDirective) tokens() []string {
+	return append([]string{d.Name}, d.Args...)
+}
+
+func findShaderDirective(directives []*ShaderDirective, name string) *ShaderDirective {
+	for _, d := range directives {
+		if strings.EqualFold(d.Name, name) {
+			return d
+		}
+	}
+	return nil
+}
+
+// WriteShaderScript writes a ShaderScript in the conventional layout: one
+// directive per line, tab-indented, shaders separated by a blank line.
+// Tokens that need it are quoted, so the output parses back to the same script.
+func WriteShaderScript(w io.Writer, script *ShaderScript) error {
+	bw := bufio.NewWriter(w)
+	for i, sh := range script.Shaders {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		writeShaderComments(bw, "", sh.Comments)
+		bw.WriteString(quoteShaderToken(sh.Name) + "\n{\n")
+		for _, d := range sh.Directives {
+			writeShaderDirective(bw, "\t", d)
+		}
+		for _, st := range sh.Stages {
+			writeShaderComments(bw, "\t", st.Comments)
+			bw.WriteString("\t{\n")
+			for _, d := range st.Directives {
+				writeShaderDirective(bw, "\t\t", d)
+			}
+			writeShaderComments(bw, "\t\t", st.TrailingComments)
+			bw.WriteString("\t}\n")
+		}
+		writeShaderComments(bw, "\t", sh.TrailingComments)
+		bw.WriteString("}\n")
+	}
+	if len(script.Comments) > 0 && len(script.Shaders) > 0 {
+		bw.WriteString("\n")
+	}
+	writeShaderComments(bw, "", script.Comments)
+	return bw.Flush()
+}
+
+func writeShaderComments(w *bufio.Writer, indent string, comments []string) {
+	for _, c := range comments {
+		w.WriteString(indent + c + "\n")
+	}
+}
+
+func writeShaderDirective(w *bufio.Writer, indent string, d *ShaderDirective) {
+	writeShaderComments(w, indent, d.Comments)
+	w.WriteString(indent + quoteShaderToken(d.Name))
+	for _, arg := range d.Args {
+		w.WriteString(" " + quoteShaderToken(arg))
+	}
+	if d.LineComment != "" {
+		w.WriteString(" " + d.LineComment)
+	}
+	w.WriteString("\n")
+}
+
+// quoteShaderToken quotes a token that would otherwise not survive
+// tokenizing: empty, or containing whitespace, braces, or a comment marker.
+func quoteShaderToken(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n{}\"") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/*") {
+		return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+	}
+	return s
+}
+
+type shaderParser struct {
+	tokens       []Token
+	pos          int
+	keepComments bool
+	comments     []string // pending comments for the next element
+}
+
+func (p *shaderParser) next() (Token, bool) {
+	if p.pos >= len(p.tokens) {
+		return Token{}, false
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, true
+}
+
+func (p *shaderParser) takeComments() []string {
+	c := p.comments
+	p.comments = nil
+	return c
+}
+
+func (p *shaderParser) addComment(text string) {
+	if p.keepComments {
+		p.comments = append(p.comments, text)
+	}
+}
+
+func (p *shaderParser) script() (*ShaderScript, error) {
+	script := &ShaderScript{}
+	for {
+		t, ok := p.next()
+		if !ok {
+			script.Comments = p.takeComments()
+			return script, nil
+		}
+		switch t.Kind {
+		case TokenComment:
+			p.addComment(t.Text)
+		case TokenWord:
+			sh := &Shader{Name: t.Text, Comments: p.takeComments()}
+			if err := p.shaderBody(sh); err != nil {
+				return nil, err
+			}
+			script.Shaders = append(script.Shaders, sh)
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %s outside a shader", t.Line, t.Text)
+		}
+	}
+}
+
+func (p *shaderParser) shaderBody(sh *Shader) error {
+	for {
+		t, ok := p.next()
+		if !ok {
+			return fmt.Errorf("shader %s: missing {", sh.Name)
+		}
+		if t.Kind == TokenComment {
+			p.addComment(t.Text)
+			continue
+		}
+		if t.Kind != TokenOpen {
+			return fmt.Errorf("line %d: shader %s: expected {, got %s", t.Line, sh.Name, t.Text)
+		}
+		break
+	}
+
+	for {
+		t, ok := p.next()
+		if !ok {
+			return fmt.Errorf("shader %s: missing }", sh.Name)
+		}
+		switch t.Kind {
+		case TokenComment:
+			p.comment(t, sh.Directives)
+		case TokenWord:
+			sh.Directives = append(sh.Directives, p.directive(t))
+		case TokenOpen:
+			st := &ShaderStage{Comments: p.takeComments()}
+			if err := p.stageBody(sh, st); err != nil {
+				return err
+			}
+			sh.Stages = append(sh.Stages, st)
+		case TokenClose:
+			sh.TrailingComments = p.takeComments()
+			return nil
+		}
+	}
+}
+
+func (p *shaderParser) stageBody(sh *Shader, st *ShaderStage) error {
+	for {
+		t, ok := p.next()
+		if !ok {
+			return fmt.Errorf("shader %s: missing } after stage", sh.Name)
+		}
+		switch t.Kind {
+		case TokenComment:
+			p.comment(t, st.Directives)
+		case TokenWord:
+			st.Directives = append(st.Directives, p.directive(t))
+		case TokenOpen:
+			return fmt.Errorf("line %d: shader %s: nested { in stage", t.Line, sh.Name)
+		case TokenClose:
+			st.TrailingComments = p.takeComments()
+			return nil
+		}
+	}
+}
+
+// directive reads a directive starting at first: every following word on
+// the same line is an argument.
+func (p *shaderParser) directive(first Token) *ShaderDirective {
+	d := &ShaderDirective{Name: first.Text, Comments: p.takeComments()}
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		if t.Kind != TokenWord || t.Line != first.Line {
+			break
+		}
+		d.Args = append(d.Args, t.Text)
+		p.pos++
+	}
+	return d
+}
+
+// comment attaches a comment that shares a line with the preceding
+// directive to it, and otherwise holds it for the next element.
+func (p *shaderParser) comment(t Token, directives []*ShaderDirective) {
+	if !p.keepComments {
+		return
+	}
+	if n := len(directives); n > 0 && p.pos >= 2 {
+		prev := p.tokens[p.pos-2]
+		if prev.Kind == TokenWord && prev.Line == t.Line && directives[n-1].LineComment == "" && !strings.Contains(t.Text, "\n") {
+			directives[n-1].LineComment = t.Text
+			return
+		}
+	}
+	p.addComment(t.Text)
+}
@@ -0,0 +1,114 @@
+package assets
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ShaderCacheName is the file in an output directory that keeps parsed
+// shader scripts between builds, so a warm build only parses the scripts
+// that changed.
+const ShaderCacheName = ".shadercache.json"
+
+// shaderCacheVersion changes whenever ParseShaderScript's output would, so
+// parses by an older build are never reused.
+const shaderCacheVersion = 2
+
+// shaderCache holds ParseShaderScript results by pk3 and script entry,
+// valid while the entry's CRC and size match.
+type shaderCache struct {
+	Version int                                       `json:"version"`
+	Pk3s    map[string]map[string]*cachedShaderScript `json:"pk3s"` // pk3 path → script entry name → parse
+
+	used         map[string]map[string]*cachedShaderScript // entries looked up or stored this build
+	hits, misses int
+}
+
+type cachedShaderScript struct {
+	CRC     uint32      `json:"crc"`
+	Size    uint64      `json:"size"`
+	Shaders []ShaderDef `json:"shaders"`
+}
+
+// loadShaderCache reads a shader cache. One that's missing, unreadable, or
+// from another version starts empty: the cache only ever saves work.
+func loadShaderCache(path string) *shaderCache {
+	c := &shaderCache{used: make(map[string]map[string]*cachedShaderScript)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	if err := json.Unmarshal(data, c); err != nil || c.Version != shaderCacheVersion {
+		c.Pk3s = nil
+	}
+	return c
+}
+
+// lookup returns the cached parse of a pk3's script entry, if its CRC and
+// size still match. Entries without a CRC, as in .pak archives and loose
+// directories, are never cached. A nil cache has nothing.
+func (c *shaderCache) lookup(pk3Path, name string, crc uint32, size uint64) ([]ShaderDef, bool) {
+	if c == nil || crc == 0 {
+		return nil, false
+	}
+	entry, ok := c.Pk3s[pk3Path][name]
+	if !ok || entry.CRC != crc || entry.Size != size {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.keep(pk3Path, name, entry)
+	return entry.Shaders, true
+}
+
+// store caches a parse for the next build.
+func (c *shaderCache) store(pk3Path, name string, crc uint32, size uint64, defs []ShaderDef) {
+	if c == nil || crc == 0 {
+		return
+	}
+	c.keep(pk3Path, name, &cachedShaderScript{CRC: crc, Size: size, Shaders: defs})
+}
+
+func (c *shaderCache) keep(pk3Path, name string, entry *cachedShaderScript) {
+	if c.used[pk3Path] == nil {
+		c.used[pk3Path] = make(map[string]*cachedShaderScript)
+	}
+	c.used[pk3Path][name] = entry
+}
+
+// save writes the entries this build used, dropping those of pk3s and
+// scripts that are gone.
+func (c *shaderCache) save(path string) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(&shaderCache{Version: shaderCacheVersion, Pk3s: c.used})
+	if err != nil {
+		return fmt.Errorf("marshal shader cache: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("write shader cache: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("write shader cache: %w", err)
+	}
+	if c.hits > 0 {
+		log.Printf("  shader cache: %d of %d scripts unchanged", c.hits, c.hits+c.misses)
+	}
+	return nil
+}
+
+// shaderCachePath returns where a build into outputDir reads its shader
+// cache from: opts.shaderCacheDir if set, as for an atomic build reading the
+// live output's, else outputDir.
+func shaderCachePath(outputDir string, opts BuildOptions) string {
+	if opts.shaderCacheDir != "" {
+		return filepath.Join(opts.shaderCacheDir, ShaderCacheName)
+	}
+	return filepath.Join(outputDir, ShaderCacheName)
+}
@@ -0,0 +1,65 @@
+package assets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestShaderCache(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	cachePath := filepath.Join(out, ShaderCacheName)
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		t.Fatalf("no shader cache: %v", err)
+	}
+	var cache shaderCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatal(err)
+	}
+	pak0 := filepath.Join(q, "baseq3", "pak0.pk3")
+	entry := cache.Pk3s[pak0]["scripts/base_wall.shader"]
+	if entry == nil {
+		t.Fatalf("base_wall.shader not cached: %v", cache.Pk3s)
+	}
+
+	// A warm build takes the cached parse, not the script's text
+	entry.Shaders[0].Textures = []string{"textures/base_wall/from_cache.tga"}
+	if data, err = json.Marshal(&cache); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cachePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("warm BuildBaseline: %v", err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := manifest.Games["baseq3"].Shaders["textures/base_wall/glow"]; !slices.Equal(got, []string{"textures/base_wall/from_cache.tga"}) {
+		t.Errorf("warm build shader textures = %v, want the cached parse", got)
+	}
+
+	// A script whose CRC changed is parsed again
+	writeFixturePk3(t, pak0, map[string][]byte{
+		"scripts/base_wall.shader": []byte("textures/base_wall/glow\n{\n\t{\n\t\tmap textures/base_wall/changed.tga\n\t}\n}\n"),
+		"maps/q3dm0.bsp":           makeBSP([]string{"textures/base_wall/glow"}, []fixtureEntity{{{"classname", "worldspawn"}}}),
+	})
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline after a change: %v", err)
+	}
+	if manifest, err = LoadManifest(filepath.Join(out, "manifest.json")); err != nil {
+		t.Fatal(err)
+	}
+	if got := manifest.Games["baseq3"].Shaders["textures/base_wall/glow"]; !slices.Equal(got, []string{"textures/base_wall/changed.tga"}) {
+		t.Errorf("shader textures after a change = %v", got)
+	}
+}
@@ -0,0 +1,192 @@
+package assets
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ShaderUsage relates shaders to the maps and models that reference them and
+// to the textures they use.
+type ShaderUsage struct {
+	// ReferencedBy maps each shader, defined or referenced, to the maps
+	// (maps/*.bsp) and models (*.md3) that reference it. Defined shaders
+	// nothing references have an empty list.
+	ReferencedBy map[string][]string
+	// UsedBy maps each resolved texture path to the shaders that use it.
+	UsedBy map[string][]string
+}
+
+// ShaderUsage computes usage statistics from the manifest's shader
+// definitions and the references recorded at build time.
+func (gm *GameManifest) ShaderUsage() *ShaderUsage {
+	u := &ShaderUsage{
+		ReferencedBy: make(map[string][]string, len(gm.Shaders)),
+		UsedBy:       make(map[string][]string),
+	}
+	for name := range gm.Shaders {
+		u.ReferencedBy[name] = nil
+	}
+	for name, refs := range gm.ShaderRefs {
+		u.ReferencedBy[name] = refs
+	}
+	for name := range u.ReferencedBy {
+		for _, tex := range resolvedShaderTextures(name, gm) {
+			u.UsedBy[tex] = append(u.UsedBy[tex], name)
+		}
+	}
+	for _, shaders := range u.UsedBy {
+		sort.Strings(shaders)
+	}
+	return u
+}
+
+// Unreferenced returns the shaders no map or model references, sorted. Some
+// may still be used by game code (menus, effects) rather than by assets.
+func (u *ShaderUsage) Unreferenced() []string {
+	var names []string
+	for name, refs := range u.ReferencedBy {
+		if len(refs) == 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dependents returns the maps and models that would be affected by replacing
+// a texture: everything referencing a shader that uses it.
+func (u *ShaderUsage) Dependents(texture string) []string {
+	seen := make(map[string]bool)
+	for _, shader := range u.UsedBy[strings.ToLower(texture)] {
+		for _, ref := range u.ReferencedBy[shader] {
+			seen[ref] = true
+		}
+	}
+	deps := mapKeys(seen)
+	sort.Strings(deps)
+	return deps
+}
+
+// isShaderRefSource reports whether a file is parsed for shader references.
+func isShaderRefSource(name string) bool {
+	return (strings.HasPrefix(name, "maps/") && strings.HasSuffix(name, ".bsp")) || strings.HasSuffix(name, ".md3")
+}
+
+// indexShaderRefs records the shaders referenced by the maps and models
+// among paths, replacing anything previously recorded for them. cache holds
+// parsed references by source pk3 and path, so games sharing files parse
+// them once; it may be nil.
+func (gm *GameManifest) indexShaderRefs(paths []string, cache map[string][]string) {
+	if cache == nil {
+		cache = make(map[string][]string)
+	}
+	changed := make(map[string]bool)
+	byPk3 := make(map[string]map[string]bool)
+	for _, p := range paths {
+		pk3, ok := gm.FileIndex[p]
+		if !ok || !isShaderRefSource(p) {
+			continue
+		}
+		changed[p] = true
+		if _, ok := cache[pk3+"\x00"+p]; ok {
+			continue
+		}
+		if byPk3[pk3] == nil {
+			byPk3[pk3] = make(map[string]bool)
+		}
+		byPk3[pk3][p] = true
+	}
+
+	for _, pk3 := range sortedMapKeys(byPk3) {
+		wanted := byPk3[pk3]
+		err := IteratePk3(pk3, func(name string, open func() (io.ReadCloser, error)) error {
+			lower := strings.ToLower(name)
+			if !wanted[lower] {
+				return nil
+			}
+			delete(wanted, lower)
+			rc, err := open()
+			if err != nil {
+				return nil
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil
+			}
+			cache[pk3+"\x00"+lower] = parseShaderRefs(lower, data)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Warning: failed to read shader references from %s: %v", filepath.Base(pk3), err)
+		}
+	}
+
+	if gm.ShaderRefs == nil {
+		gm.ShaderRefs = make(map[string][]string)
+	}
+	for name, refs := range gm.ShaderRefs {
+		kept := refs[:0]
+		for _, ref := range refs {
+			if !changed[ref] {
+				kept = append(kept, ref)
+			}
+		}
+		gm.ShaderRefs[name] = kept
+	}
+	for p := range changed {
+		for _, shader := range cache[gm.FileIndex[p]+"\x00"+p] {
+			gm.ShaderRefs[shader] = append(gm.ShaderRefs[shader], p)
+		}
+	}
+	for name, refs := range gm.ShaderRefs {
+		if len(refs) == 0 {
+			delete(gm.ShaderRefs, name)
+			continue
+		}
+		sort.Strings(refs)
+	}
+}
+
+// parseShaderRefs returns the lowered shader names a BSP or MD3 references.
+// Files that fail to parse reference nothing.
+func parseShaderRefs(filePath string, data []byte) []string {
+	var names []string
+	if strings.HasSuffix(filePath, ".bsp") {
+		bsp, err := ParseBSP(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil
+		}
+		names = append(bsp.Shaders, bsp.Advertisements...)
+	} else {
+		shaders, err := ParseMD3Shaders(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil
+		}
+		names = shaders
+	}
+
+	seen := make(map[string]bool, len(names))
+	refs := make([]string, 0, len(names))
+	for _, name := range names {
+		// The engine ignores the extension when looking up a shader
+		lower := strings.ToLower(name)
+		lower = strings.TrimSuffix(lower, path.Ext(lower))
+		if lower != "" && !seen[lower] {
+			seen[lower] = true
+			refs = append(refs, lower)
+		}
+	}
+	return refs
+}
+
+func sortedMapKeys[V any](m map[string]V) []string {
+	keys := mapKeys(m)
+	sort.Strings(keys)
+	return keys
+}
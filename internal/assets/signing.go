@@ -0,0 +1,188 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A signed build has, beside manifest.json, a detached Ed25519 signature of
+// it in manifest.json.sig, and artifacts.sha256, a sha256sum-style list of
+// every generated pk3, with its own signature. The manifest's signature
+// covers the pk3s through their hashes; the list lets a mirror check its
+// pk3s without the whole manifest (sha256sum -c works on it as is).
+const (
+	SignatureExt     = ".sig"
+	ArtifactSumsName = "artifacts.sha256"
+)
+
+// GenerateSigningKey writes a new private key to path, which mustn't exist,
+// and returns its public key.
+func GenerateSigningKey(path string) (ed25519.PublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("create key file: %w", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, base64.StdEncoding.EncodeToString(priv.Seed())); err != nil {
+		return nil, fmt.Errorf("write key file: %w", err)
+	}
+	return pub, f.Close()
+}
+
+// LoadSigningKey reads a private key written by GenerateSigningKey.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key %s: not a base64 Ed25519 seed", path)
+	}
+	return ed25519.NewKeyFromSeed(raw), nil
+}
+
+// EncodePublicKey returns a public key in the form ParsePublicKey reads.
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey reads a base64 public key, given either directly or as the
+// path of a file holding it.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	if data, err := os.ReadFile(s); err == nil {
+		s = string(data)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("not a base64 Ed25519 public key or a file holding one")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// ArtifactSums lists a manifest's artifacts as "<sha256>  <path>" lines,
+// sorted by path.
+func ArtifactSums(m *Manifest) []byte {
+	var b bytes.Buffer
+	for _, name := range sortedMapKeys(m.Artifacts) {
+		fmt.Fprintf(&b, "%s  %s\n", m.Artifacts[name].SHA256, name)
+	}
+	return b.Bytes()
+}
+
+// SignBuild signs outputDir's manifest.json and writes artifacts.sha256 and
+// its signature. Anything that rewrites the manifest afterwards (a map pk3
+// built on demand, a missing map recorded) leaves the signature stale, so
+// sign once a build is final, before publishing.
+func SignBuild(outputDir string, key ed25519.PrivateKey) error {
+	unlock, err := LockOutput(outputDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	manifestPath := filepath.Join(outputDir, "manifest.json")
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("parse manifest: %w", err)
+	}
+	sums := ArtifactSums(&m)
+	sumsPath := filepath.Join(outputDir, ArtifactSumsName)
+	if err := os.WriteFile(sumsPath, sums, 0644); err != nil {
+		return fmt.Errorf("write %s: %w", ArtifactSumsName, err)
+	}
+	if err := writeSignature(sumsPath, sums, key); err != nil {
+		return err
+	}
+	return writeSignature(manifestPath, data, key)
+}
+
+func writeSignature(path string, data []byte, key ed25519.PrivateKey) error {
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)) + "\n"
+	if err := os.WriteFile(path+SignatureExt, []byte(sig), 0644); err != nil {
+		return fmt.Errorf("write signature: %w", err)
+	}
+	return nil
+}
+
+// VerifySignature checks data against the contents of its .sig file.
+func VerifySignature(data, sig []byte, pub ed25519.PublicKey) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(pub, data, raw) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// VerifyManifestFile loads a manifest after checking it against the
+// signature beside it.
+func VerifyManifestFile(path string, pub ed25519.PublicKey) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	sig, err := os.ReadFile(path + SignatureExt)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest signature: %w", err)
+	}
+	if err := VerifySignature(data, sig, pub); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// VerifyBuild checks a signed build in outputDir: both signatures, that
+// artifacts.sha256 agrees with the manifest, and that every pk3 on disk
+// matches its hash. It returns the artifacts that are missing or altered,
+// and an error if the signatures themselves don't check out.
+func VerifyBuild(outputDir string, pub ed25519.PublicKey) ([]string, error) {
+	m, err := VerifyManifestFile(filepath.Join(outputDir, "manifest.json"), pub)
+	if err != nil {
+		return nil, err
+	}
+	sumsPath := filepath.Join(outputDir, ArtifactSumsName)
+	sums, err := os.ReadFile(sumsPath)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", ArtifactSumsName, err)
+	}
+	sig, err := os.ReadFile(sumsPath + SignatureExt)
+	if err != nil {
+		return nil, fmt.Errorf("read %s signature: %w", ArtifactSumsName, err)
+	}
+	if err := VerifySignature(sums, sig, pub); err != nil {
+		return nil, fmt.Errorf("%s: %w", ArtifactSumsName, err)
+	}
+	if !bytes.Equal(sums, ArtifactSums(m)) {
+		return nil, fmt.Errorf("%s doesn't match the manifest's artifacts", ArtifactSumsName)
+	}
+
+	var bad []string
+	for _, name := range sortedMapKeys(m.Artifacts) {
+		localPath, err := artifactLocalPath(outputDir, name)
+		if err == nil {
+			err = VerifyArtifact(localPath, m.Artifacts[name])
+		}
+		if err != nil {
+			bad = append(bad, name)
+		}
+	}
+	return bad, nil
+}
@@ -0,0 +1,67 @@
+package assets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSignBuild(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "signing.key")
+	pub, err := GenerateSigningKey(keyPath)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	if _, err := GenerateSigningKey(keyPath); err == nil {
+		t.Error("GenerateSigningKey overwrote an existing key")
+	}
+	key, err := LoadSigningKey(keyPath)
+	if err != nil {
+		t.Fatalf("LoadSigningKey: %v", err)
+	}
+	if err := SignBuild(out, key); err != nil {
+		t.Fatalf("SignBuild: %v", err)
+	}
+
+	parsed, err := ParsePublicKey(EncodePublicKey(pub))
+	if err != nil || !parsed.Equal(pub) {
+		t.Fatalf("ParsePublicKey round trip: %v", err)
+	}
+	if bad, err := VerifyBuild(out, pub); err != nil || len(bad) > 0 {
+		t.Fatalf("VerifyBuild = %v, %v; want clean", bad, err)
+	}
+	if _, err := Sync(SyncOptions{Manifest: filepath.Join(out, "manifest.json"), OutputDir: out, PublicKey: pub}); err != nil {
+		t.Errorf("Sync with a valid signature: %v", err)
+	}
+
+	// An altered pk3 is reported, not an error
+	if err := os.WriteFile(filepath.Join(out, "maps", "custom.pk3"), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if bad, err := VerifyBuild(out, pub); err != nil || !slices.Equal(bad, []string{"maps/custom.pk3"}) {
+		t.Errorf("VerifyBuild after altering a pk3 = %v, %v", bad, err)
+	}
+
+	// An altered manifest fails, whoever loads it
+	manifestPath := filepath.Join(out, "manifest.json")
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manifestPath, append(data, ' '), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyBuild(out, pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifyBuild after altering the manifest = %v, want ErrBadSignature", err)
+	}
+	if _, err := Sync(SyncOptions{Manifest: manifestPath, OutputDir: t.TempDir(), PublicKey: pub}); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Sync after altering the manifest = %v, want ErrBadSignature", err)
+	}
+}
@@ -0,0 +1,165 @@
+package assets
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Size report categories, by what a file is
+var sizeCategories = []string{"maps", "textures", "models", "sounds", "music", "video", "other"}
+
+// SizeReport breaks down where the space in a build's map pk3s goes. Sizes
+// are uncompressed, except each map's Pk3Size, which is the file on disk.
+type SizeReport struct {
+	Total      int64            `json:"total"`
+	Pk3Total   int64            `json:"pk3_total"`
+	Categories map[string]int64 `json:"categories"`
+	Maps       []MapPakSize     `json:"maps"`    // largest first
+	Largest    []SizedFile      `json:"largest"` // files contributing the most, across every map pk3
+}
+
+// MapPakSize is one map pk3's share of a SizeReport.
+type MapPakSize struct {
+	Map        string           `json:"map"`
+	Size       int64            `json:"size"`
+	Pk3Size    int64            `json:"pk3_size"`
+	Files      int              `json:"files"`
+	Categories map[string]int64 `json:"categories"`
+}
+
+// SizedFile is a file's contribution to a SizeReport: its size times the
+// number of map pk3s carrying a copy.
+type SizedFile struct {
+	Path     string `json:"path"`
+	Category string `json:"category"`
+	Size     int64  `json:"size"`
+	Copies   int    `json:"copies"`
+	Total    int64  `json:"total"`
+}
+
+// MapPakSizes reports the sizes of the map pk3s under a demobake output
+// directory, by map and category, with the topN files contributing the most
+// (every file if topN isn't positive). Each pk3's trinity_manifest.json
+// supplies its files' sizes; pk3s without one are skipped with a warning.
+func MapPakSizes(outputDir string, topN int) (*SizeReport, error) {
+	pk3s, err := filepath.Glob(filepath.Join(outputDir, "maps", "*.pk3"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pk3s)
+
+	report := &SizeReport{Categories: make(map[string]int64), Maps: []MapPakSize{}}
+	files := make(map[string]*SizedFile)
+	for _, pk3Path := range pk3s {
+		m, err := ReadMapPakManifest(pk3Path)
+		if err != nil {
+			log.Printf("Warning: skipping %s: %v", filepath.Base(pk3Path), err)
+			continue
+		}
+		info, err := os.Stat(pk3Path)
+		if err != nil {
+			return nil, err
+		}
+		size := MapPakSize{Map: m.Map, Pk3Size: info.Size(), Files: len(m.Files), Categories: make(map[string]int64)}
+		for _, f := range m.Files {
+			category := sizeCategory(f.Path, f.Reason)
+			size.Size += f.Size
+			size.Categories[category] += f.Size
+			sf, ok := files[f.Path]
+			if !ok {
+				sf = &SizedFile{Path: f.Path, Category: category, Size: f.Size}
+				files[f.Path] = sf
+			}
+			sf.Copies++
+			sf.Total += f.Size
+		}
+		report.Maps = append(report.Maps, size)
+		report.Total += size.Size
+		report.Pk3Total += size.Pk3Size
+		for category, n := range size.Categories {
+			report.Categories[category] += n
+		}
+	}
+	sort.SliceStable(report.Maps, func(i, j int) bool { return report.Maps[i].Size > report.Maps[j].Size })
+
+	report.Largest = make([]SizedFile, 0, len(files))
+	for _, sf := range files {
+		report.Largest = append(report.Largest, *sf)
+	}
+	sort.Slice(report.Largest, func(i, j int) bool {
+		a, b := report.Largest[i], report.Largest[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Path < b.Path
+	})
+	if topN > 0 && len(report.Largest) > topN {
+		report.Largest = report.Largest[:topN]
+	}
+	return report, nil
+}
+
+// sizeCategory classifies a map pk3 file for a SizeReport. Music is told
+// from other sounds by why it was included.
+func sizeCategory(p, reason string) string {
+	switch ext := path.Ext(p); {
+	case ext == ".bsp" || ext == ".aas":
+		return "maps"
+	case isTextureFile(p) || reason == "texture" || reason == "banner" || reason == "levelshot":
+		return "textures"
+	case ext == ".md3" || ext == ".mdr" || ext == ".iqm" || ext == ".skin":
+		return "models"
+	case reason == "music" || strings.HasPrefix(p, "music/"):
+		return "music"
+	case ext == ".wav" || ext == ".ogg" || ext == ".opus":
+		return "sounds"
+	case isVideoFile(p):
+		return "video"
+	}
+	return "other"
+}
+
+// WriteText writes the report as a table: totals by category, each map's
+// size, and the largest contributors.
+func (r *SizeReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%d map pk3s: %s on disk, %s uncompressed\n", len(r.Maps), formatSize(r.Pk3Total), formatSize(r.Total))
+	for _, category := range sizeCategories {
+		if n := r.Categories[category]; n > 0 {
+			fmt.Fprintf(w, "  %-10s %10s  %5.1f%%\n", category, formatSize(n), percent(n, r.Total))
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-24s %10s %10s %6s  %s\n", "MAP", "PK3", "SIZE", "FILES", "LARGEST CATEGORY")
+	for _, m := range r.Maps {
+		largest := ""
+		for _, category := range sizeCategories {
+			if m.Categories[category] > m.Categories[largest] {
+				largest = category
+			}
+		}
+		fmt.Fprintf(w, "%-24s %10s %10s %6d  %s %s\n", m.Map, formatSize(m.Pk3Size), formatSize(m.Size), m.Files,
+			largest, formatSize(m.Categories[largest]))
+	}
+
+	if len(r.Largest) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%-56s %-9s %10s %6s %10s\n", "FILE", "CATEGORY", "SIZE", "COPIES", "TOTAL")
+		for _, f := range r.Largest {
+			fmt.Fprintf(w, "%-56s %-9s %10s %6d %10s\n", f.Path, f.Category, formatSize(f.Size), f.Copies, formatSize(f.Total))
+		}
+	}
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
@@ -0,0 +1,146 @@
+package assets
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Substitution replaces official id assets with freely distributable
+// equivalents (e.g. OpenArena data) when building baseline pk3s. Each official
+// baseline file is looked up in the substitute sources, first through the
+// explicit Map, then under its own path; files with no substitute are left out.
+type Substitution struct {
+	Sources []string          `yaml:"sources" json:"sources"`             // substitute pk3s or directories of pk3s, in load order
+	Map     map[string]string `yaml:"map,omitempty" json:"map,omitempty"` // official path → substitute path ("" drops the file)
+
+	index map[string]string
+}
+
+// LoadSubstitution reads a substitution table from a YAML or JSON file and
+// indexes its sources.
+func LoadSubstitution(path string) (*Substitution, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read substitution table: %w", err)
+	}
+	var s Substitution
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse substitution table: %w", err)
+	}
+	if err := s.buildIndex(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// buildIndex indexes the substitute sources and normalizes the map keys.
+func (s *Substitution) buildIndex() error {
+	var pk3s []string
+	for _, src := range s.Sources {
+		info, err := os.Stat(src)
+		if err != nil {
+			return fmt.Errorf("substitute source %s: %w", src, err)
+		}
+		if info.IsDir() {
+			pk3s = append(pk3s, collectPk3FilesFromDir(src)...)
+		} else {
+			pk3s = append(pk3s, src)
+		}
+	}
+	if len(pk3s) == 0 {
+		return fmt.Errorf("substitution table has no source pk3s")
+	}
+
+	index, err := BuildFileIndex(pk3s)
+	if err != nil {
+		return fmt.Errorf("index substitute sources: %w", err)
+	}
+	s.index = index
+
+	normalized := make(map[string]string, len(s.Map))
+	for k, v := range s.Map {
+		normalized[strings.ToLower(k)] = strings.ToLower(v)
+	}
+	s.Map = normalized
+	return nil
+}
+
+// resolve finds the substitute for an official path. Images may resolve to a
+// different extension, which the engine's image loader accepts.
+func (s *Substitution) resolve(officialPath string) (string, bool) {
+	target := officialPath
+	if mapped, ok := s.Map[officialPath]; ok {
+		if mapped == "" {
+			return "", false
+		}
+		target = mapped
+	}
+	if _, ok := s.index[target]; ok {
+		return target, true
+	}
+	switch path.Ext(target) {
+	case ".tga", ".jpg", ".png":
+		return ResolveTexture(target, s.index)
+	}
+	return "", false
+}
+
+// apply swaps every file in a base game's baseline (all of which come from
+// official paks) for its substitute and returns substituted path → substitute
+// source pk3.
+func (s *Substitution) apply(baselineFiles map[string][]byte) (map[string]string, error) {
+	substituted := make(map[string]string)
+	missing := 0
+	official := make([]string, 0, len(baselineFiles))
+	for p := range baselineFiles {
+		official = append(official, p)
+	}
+	for _, officialPath := range official {
+		delete(baselineFiles, officialPath)
+
+		subPath, ok := s.resolve(officialPath)
+		if !ok {
+			missing++
+			continue
+		}
+		data, err := readFileFromIndex(subPath, s.index)
+		if err != nil {
+			return nil, fmt.Errorf("read substitute %s: %w", subPath, err)
+		}
+		// Keep the official directory and name so references still resolve;
+		// only the image extension may change.
+		outPath := strings.TrimSuffix(officialPath, path.Ext(officialPath)) + path.Ext(subPath)
+		baselineFiles[outPath] = data
+		substituted[outPath] = s.index[subPath]
+	}
+	log.Printf("  substitution: %d files replaced, %d official files without a substitute left out", len(substituted), missing)
+	return substituted, nil
+}
+
+// substitutionOverrides returns overrides with one added for each substitute
+// image in another format than the official file it replaces, so references
+// resolve to the substitute rather than the official file the extension
+// search would find first. overrides' own entries win.
+func substitutionOverrides(manifest *Manifest, overrides map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, gm := range manifest.Games {
+		for p := range gm.Substituted {
+			if !isTextureFile(p) {
+				continue
+			}
+			if _, ok := gm.FileIndex[p]; ok && !gm.OfficialFiles[p] {
+				if key := TextureOverrideKey(p); key != p {
+					merged[key] = p
+				}
+			}
+		}
+	}
+	maps.Copy(merged, overrides)
+	return merged
+}
@@ -0,0 +1,384 @@
+package assets
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncOptions configures a reconciliation of a local output directory against a manifest.
+type SyncOptions struct {
+	Manifest  string // manifest path or http(s) URL
+	OutputDir string // local directory mirroring the generated pk3s
+	Quake3Dir string // optional local install used to build pk3s that can't be downloaded
+	Prune     bool   // delete generated pk3s not listed in the manifest
+
+	// Distributable skips artifacts containing official id content, so a
+	// public mirror only ever holds freely redistributable pk3s.
+	Distributable bool
+
+	// PublicKey, if set, requires the manifest to carry a valid signature
+	// by its private key (see SignBuild). Artifacts are then trusted only
+	// as far as their signed hashes, which every sync checks.
+	PublicKey ed25519.PublicKey
+
+	Download DownloadOptions
+}
+
+// SyncResult summarizes what a sync changed.
+type SyncResult struct {
+	UpToDate   []string
+	Downloaded []string
+	Built      []string
+	Pruned     []string
+	Skipped    []string // restricted artifacts left out in distributable mode
+	Failed     map[string]error
+}
+
+var syncHTTPClient = &http.Client{Timeout: 10 * time.Minute}
+
+// Sync makes OutputDir match the manifest's artifacts: missing or mismatched pk3s are
+// downloaded (when the manifest came from a URL) or built from Quake3Dir, every
+// result is checksum-verified, and stale generated pk3s are optionally pruned.
+// Downloads resume where an interrupted sync left them (see DownloadOptions).
+func Sync(opts SyncOptions) (*SyncResult, error) {
+	manifest, err := loadManifestSource(opts.Manifest, opts.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Artifacts) == 0 {
+		return nil, fmt.Errorf("manifest lists no artifacts")
+	}
+	if err := os.MkdirAll(filepath.Join(opts.OutputDir, "maps"), 0755); err != nil {
+		return nil, fmt.Errorf("create output dir: %w", err)
+	}
+	unlock, err := LockOutput(opts.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result := &SyncResult{Failed: make(map[string]error)}
+
+	names := make([]string, 0, len(manifest.Artifacts))
+	for name := range manifest.Artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Check what's already in place, then download the rest in parallel and
+	// build what couldn't be downloaded one at a time
+	var stale []string
+	for _, name := range names {
+		want := manifest.Artifacts[name]
+		if opts.Distributable && want.Restricted {
+			result.Skipped = append(result.Skipped, name)
+			continue
+		}
+		localPath, err := artifactLocalPath(opts.OutputDir, name)
+		if err != nil {
+			result.Failed[name] = err
+			continue
+		}
+		if VerifyArtifact(localPath, want) == nil {
+			result.UpToDate = append(result.UpToDate, name)
+			continue
+		}
+		stale = append(stale, name)
+	}
+
+	downloaded := make(map[string]bool)
+	if isRemoteSource(opts.Manifest) {
+		d := newDownloader(opts.Download)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, d.parallel())
+		for _, name := range stale {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				localPath, _ := artifactLocalPath(opts.OutputDir, name)
+				if err := downloadArtifact(d, opts.Manifest, name, localPath, manifest.Artifacts[name]); err != nil {
+					log.Printf("Sync: download %s: %v", name, err)
+					return
+				}
+				mu.Lock()
+				downloaded[name] = true
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+	}
+
+	for _, name := range stale {
+		if downloaded[name] {
+			result.Downloaded = append(result.Downloaded, name)
+			continue
+		}
+		want := manifest.Artifacts[name]
+		localPath, _ := artifactLocalPath(opts.OutputDir, name)
+		if opts.Quake3Dir != "" {
+			if err := buildArtifact(name, manifest, opts.Quake3Dir, localPath); err != nil {
+				result.Failed[name] = fmt.Errorf("build: %w", err)
+				continue
+			}
+			if err := VerifyArtifact(localPath, want); err != nil {
+				result.Failed[name] = err
+				continue
+			}
+			result.Built = append(result.Built, name)
+			continue
+		}
+
+		result.Failed[name] = fmt.Errorf("no source available")
+	}
+
+	if opts.Prune {
+		keep := manifest.Artifacts
+		if opts.Distributable {
+			keep = make(map[string]Artifact, len(manifest.Artifacts))
+			for name, a := range manifest.Artifacts {
+				if !a.Restricted {
+					keep[name] = a
+				}
+			}
+		}
+		pruned, err := pruneArtifacts(opts.OutputDir, keep)
+		if err != nil {
+			return result, err
+		}
+		result.Pruned = pruned
+	}
+
+	return result, nil
+}
+
+// SyncFile is a pk3 in a sync diff: one a client holds, as it reports it,
+// or one it needs, as the manifest lists it.
+type SyncFile struct {
+	Name       string `json:"name"` // output-relative path, as in the manifest's artifacts
+	Size       int64  `json:"size,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	Checksum   int32  `json:"checksum,omitempty"`   // engine pak checksum (see PakChecksum)
+	Restricted bool   `json:"restricted,omitempty"` // contains official id content
+}
+
+// SyncDiff is what a client must change to match a manifest.
+type SyncDiff struct {
+	Download []SyncFile `json:"download"` // missing or different
+	Delete   []string   `json:"delete"`   // generated pk3s held but no longer listed
+	UpToDate int        `json:"upToDate"`
+	Skipped  []string   `json:"skipped,omitempty"` // restricted artifacts left out in distributable mode
+}
+
+// DiffSync compares the pk3s a client holds with a manifest's artifacts:
+// the remote half of Sync, for clients that can't read the manifest
+// themselves. A pk3 matches its artifact if their SHA-256s are equal or,
+// when the client sent none, their engine checksums; one with neither is
+// downloaded again.
+//
+// A client may list its whole install, so only pk3s that look like
+// generated output (see isGeneratedPk3) are ever listed for deletion;
+// official paks and third-party pk3s are left alone. With distributable
+// set, restricted artifacts are skipped rather than downloaded, and held
+// ones are deleted, as Sync does with SyncOptions.Distributable.
+func DiffSync(manifest *Manifest, held []SyncFile, distributable bool) *SyncDiff {
+	diff := &SyncDiff{Download: []SyncFile{}, Delete: []string{}}
+	have := make(map[string]SyncFile, len(held))
+	for _, f := range held {
+		have[path.Clean(strings.ReplaceAll(f.Name, "\\", "/"))] = f
+	}
+	for _, name := range sortedMapKeys(manifest.Artifacts) {
+		want := manifest.Artifacts[name]
+		f, ok := have[name]
+		switch {
+		case distributable && want.Restricted:
+			diff.Skipped = append(diff.Skipped, name)
+			if ok {
+				diff.Delete = append(diff.Delete, name)
+			}
+		case ok && f.SHA256 != "" && strings.EqualFold(f.SHA256, want.SHA256),
+			ok && f.SHA256 == "" && f.Checksum != 0 && f.Checksum == want.Checksum:
+			diff.UpToDate++
+		default:
+			diff.Download = append(diff.Download, SyncFile{
+				Name: name, Size: want.Size, SHA256: want.SHA256, Checksum: want.Checksum, Restricted: want.Restricted,
+			})
+		}
+	}
+	for _, name := range sortedMapKeys(have) {
+		if _, ok := manifest.Artifacts[name]; !ok && isGeneratedPk3(manifest, name) {
+			diff.Delete = append(diff.Delete, name)
+		}
+	}
+	sort.Strings(diff.Delete)
+	return diff
+}
+
+// isGeneratedPk3 reports whether an output-relative name is one a build
+// writes: a map pk3 directly under maps/, or a game's baseline pk3 named
+// after a game the manifest lists.
+func isGeneratedPk3(manifest *Manifest, name string) bool {
+	lower := strings.ToLower(name)
+	if !strings.HasSuffix(lower, ".pk3") {
+		return false
+	}
+	if rest, ok := strings.CutPrefix(name, "maps/"); ok {
+		return rest != "" && !strings.Contains(rest, "/")
+	}
+	if strings.Contains(name, "/") {
+		return false
+	}
+	_, ok := manifest.Games[strings.TrimSuffix(name, path.Ext(name))]
+	return ok
+}
+
+// loadManifestSource loads a manifest from a local path or an http(s) URL,
+// checking its signature first if pub is set.
+func loadManifestSource(src string, pub ed25519.PublicKey) (*Manifest, error) {
+	data, err := readSource(src)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if pub != nil {
+		sig, err := readSource(src + SignatureExt)
+		if err != nil {
+			return nil, fmt.Errorf("read manifest signature: %w", err)
+		}
+		if err := VerifySignature(data, sig, pub); err != nil {
+			return nil, fmt.Errorf("manifest: %w", err)
+		}
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// readSource reads a local file or fetches an http(s) URL.
+func readSource(src string) ([]byte, error) {
+	if !isRemoteSource(src) {
+		return os.ReadFile(src)
+	}
+
+	resp, err := syncHTTPClient.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", src, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func isRemoteSource(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// artifactLocalPath maps a manifest artifact name to a path under outputDir,
+// rejecting names that would escape it.
+func artifactLocalPath(outputDir, name string) (string, error) {
+	cleaned := path.Clean("/" + name)
+	if cleaned == "/" || cleaned[1:] != name {
+		return "", fmt.Errorf("invalid artifact name %q", name)
+	}
+	return filepath.Join(outputDir, filepath.FromSlash(name)), nil
+}
+
+// VerifyArtifact checks a local file against the manifest's size and checksum.
+func VerifyArtifact(localPath string, want Artifact) error {
+	sum, size, err := hashFile(localPath)
+	if err != nil {
+		return err
+	}
+	if size != want.Size || sum != want.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s", filepath.Base(localPath))
+	}
+	return nil
+}
+
+// downloadArtifact fetches an artifact relative to the manifest URL into
+// localPath, resuming an earlier partial download, and verifies it against
+// the manifest before moving it into place. A partial download that fails
+// verification is removed so the next sync starts it over.
+func downloadArtifact(d *downloader, manifestURL, name, localPath string, want Artifact) error {
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return fmt.Errorf("parse manifest URL: %w", err)
+	}
+	ref, err := url.Parse(name)
+	if err != nil {
+		return fmt.Errorf("parse artifact name: %w", err)
+	}
+
+	tmpPath := localPath + ".part"
+	if _, err := d.fetch(base.ResolveReference(ref).String(), tmpPath); err != nil {
+		return err
+	}
+	if err := VerifyArtifact(tmpPath, want); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, localPath)
+}
+
+// buildArtifact regenerates a single artifact from a local Quake 3 install,
+// with the options the manifest's build used.
+func buildArtifact(name string, manifest *Manifest, quake3Dir, localPath string) error {
+	opts, err := manifest.buildOptions()
+	if err != nil {
+		return err
+	}
+	if mapFile, ok := strings.CutPrefix(name, "maps/"); ok {
+		mapName := strings.TrimSuffix(mapFile, ".pk3")
+		bspPath := "maps/" + mapName + ".bsp"
+		for _, game := range manifest.GameNames() {
+			gm := manifest.Games[game]
+			if _, ok := gm.FileIndex[bspPath]; ok {
+				_, err := BuildMapPak(mapName, game, manifest, quake3Dir, localPath, opts.MapPak)
+				return err
+			}
+		}
+		return fmt.Errorf("map %s not in manifest", mapName)
+	}
+
+	game := strings.TrimSuffix(name, ".pk3")
+	gamePk3s, _ := collectRootSources(quake3Dir, opts)
+	pk3s, ok := gamePk3s[game]
+	if !ok {
+		return fmt.Errorf("game %s not found in %s", game, quake3Dir)
+	}
+	_, _, err = buildGameBaseline(game, pk3s, filepath.Dir(localPath), opts, nil)
+	return err
+}
+
+// pruneArtifacts removes generated pk3s in outputDir that the manifest no longer lists.
+func pruneArtifacts(outputDir string, artifacts map[string]Artifact) ([]string, error) {
+	stale, _, err := unreferencedArtifacts(outputDir, artifacts)
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, rel := range stale {
+		if err := os.Remove(filepath.Join(outputDir, filepath.FromSlash(rel))); err != nil {
+			return pruned, fmt.Errorf("remove %s: %w", rel, err)
+		}
+		pruned = append(pruned, rel)
+	}
+	return pruned, nil
+}
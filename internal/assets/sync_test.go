@@ -0,0 +1,105 @@
+package assets
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestDiffSync(t *testing.T) {
+	manifest := &Manifest{
+		Games: map[string]*GameManifest{"baseq3": {}},
+		Artifacts: map[string]Artifact{
+			"maps/q3dm0.pk3":  {Size: 10, SHA256: "aa", Checksum: 7},
+			"maps/custom.pk3": {Size: 20, SHA256: "bb", Checksum: 8},
+			"zz-trinity.pk3":  {Size: 30, SHA256: "cc", Checksum: 9, Restricted: true},
+		},
+	}
+	held := []SyncFile{
+		{Name: "maps/q3dm0.pk3", SHA256: "AA"},
+		{Name: "maps\\custom.pk3", Checksum: 5},
+		{Name: "zz-trinity.pk3", Checksum: 9},
+		{Name: "maps/old.pk3", SHA256: "dd"},
+		{Name: "baseq3.pk3", SHA256: "ee"},
+		// A client listing its install reports pk3s no build wrote
+		{Name: "pak0.pk3", Checksum: 1},
+		{Name: "map-ctf4ish.pk3", Checksum: 2},
+		{Name: "maps/extra/x.pk3", Checksum: 3},
+	}
+	diff := DiffSync(manifest, held, false)
+	want := &SyncDiff{
+		Download: []SyncFile{{Name: "maps/custom.pk3", Size: 20, SHA256: "bb", Checksum: 8}},
+		Delete:   []string{"baseq3.pk3", "maps/old.pk3"},
+		UpToDate: 2,
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff = %+v, want %+v", diff, want)
+	}
+
+	// Distributable mode neither downloads nor keeps restricted artifacts
+	diff = DiffSync(manifest, held, true)
+	want = &SyncDiff{
+		Download: []SyncFile{{Name: "maps/custom.pk3", Size: 20, SHA256: "bb", Checksum: 8}},
+		Delete:   []string{"baseq3.pk3", "maps/old.pk3", "zz-trinity.pk3"},
+		UpToDate: 1,
+		Skipped:  []string{"zz-trinity.pk3"},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("distributable diff = %+v, want %+v", diff, want)
+	}
+}
+
+func TestSyncRebuildsWithBuildOptions(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	subPk3 := filepath.Join(t.TempDir(), "sub.pk3")
+	writeFixturePk3(t, subPk3, map[string][]byte{
+		"gfx/2d/crosshaira.tga":             fixtureImage("free crosshair"),
+		"textures/base_wall/glow_blend.png": fixtureImage("free glow_blend"),
+	})
+	sub := &Substitution{Sources: []string{subPk3}}
+	if err := sub.buildIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	opts := BuildOptions{
+		Policy:      &BaselinePolicy{Include: []string{"gfx/", "textures/base_wall/glow_blend.*"}},
+		Substitute:  sub,
+		Compression: CompressionBest,
+		MapPak:      MapPakOptions{Compression: CompressionStore},
+	}
+	if _, err := BuildBaseline(q, out, opts); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	manifestPath := filepath.Join(out, "manifest.json")
+	manifest, err := LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := manifest.Build; b == nil || b.Compression != CompressionBest || b.MapPak.Compression != CompressionStore || b.Substitute == nil {
+		t.Fatalf("manifest build settings = %+v", manifest.Build)
+	}
+
+	// The substitute wins over the official file in another format
+	gm := manifest.Games["baseq3"]
+	if got, _ := gm.ResolveTexture("textures/base_wall/glow_blend.tga"); got != "textures/base_wall/glow_blend.png" {
+		t.Errorf("glow_blend.tga resolves to %q, want the substitute", got)
+	}
+	if slices.Contains(pk3Listing(t, filepath.Join(out, "maps", "q3dm0.pk3")), "textures/base_wall/glow_blend.tga") {
+		t.Error("q3dm0.pk3 packs the official glow_blend.tga")
+	}
+
+	// A sync that has to build reproduces every artifact
+	mirror := t.TempDir()
+	result, err := Sync(SyncOptions{Manifest: manifestPath, OutputDir: mirror, Quake3Dir: q})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(result.Failed) > 0 {
+		t.Errorf("Sync failed: %v", result.Failed)
+	}
+	if len(result.Built) != len(manifest.Artifacts) {
+		t.Errorf("Sync built %v, want all of %v", result.Built, sortedMapKeys(manifest.Artifacts))
+	}
+}
@@ -1,39 +1,329 @@
 package assets
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 // textureExtensions is the Q3 texture search order.
 var textureExtensions = []string{".tga", ".jpg", ".png"}
 
+// compressedTextureExtensions are the GPU texture containers HD texture
+// packs ship beside the original images, in the order they're tried. The
+// stock engine can't load them; modern engines prefer them.
+var compressedTextureExtensions = []string{".dds", ".ktx"}
+
+// companionSuffixes name the maps engines such as Quake3e and vkQuake3 look
+// for beside a diffuse image: textures/foo/bar_n.tga is bar.tga's normal
+// map, bar_s its specular map, and bar_glow its glow map.
+var companionSuffixes = []string{"_n", "_s", "_glow"}
+
+// Compressed texture policies (see TextureSearch)
+const (
+	CompressedTexturesIgnore  = "ignore"  // never used, as in the stock engine (default)
+	CompressedTexturesInclude = "include" // shipped beside the image they compress, and used where it's missing
+	CompressedTexturesPrefer  = "prefer"  // used in place of the image they compress
+)
+
+// ParseCompressedTextures validates a compressed texture policy. Empty
+// means CompressedTexturesIgnore.
+func ParseCompressedTextures(s string) (string, error) {
+	switch s {
+	case "", CompressedTexturesIgnore:
+		return CompressedTexturesIgnore, nil
+	case CompressedTexturesInclude, CompressedTexturesPrefer:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown compressed texture policy %q (want ignore, include, or prefer)", s)
+}
+
+// TextureSearch is how texture references resolve to files: the image
+// extensions tried, in order, and what's made of compressed containers
+// (.dds, .ktx) and companion maps. The zero value is the stock engine's
+// search.
+type TextureSearch struct {
+	Extensions []string `yaml:"extensions,omitempty" json:"extensions,omitempty"` // nil = .tga, .jpg, .png
+	Compressed string   `yaml:"compressed,omitempty" json:"compressed,omitempty"` // compressed texture policy; empty = ignore
+	Companions bool     `yaml:"companions,omitempty" json:"companions,omitempty"` // also take each image's _n, _s, and _glow maps
+}
+
+func (s TextureSearch) validate() error {
+	for _, ext := range s.Extensions {
+		if !strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+			return fmt.Errorf("texture extension %q must be lowercase and start with a dot", ext)
+		}
+	}
+	_, err := ParseCompressedTextures(s.Compressed)
+	return err
+}
+
+// isDefault reports whether s searches as the stock engine does.
+func (s TextureSearch) isDefault() bool {
+	return len(s.Extensions) == 0 && (s.Compressed == "" || s.Compressed == CompressedTexturesIgnore) && !s.Companions
+}
+
+func (s TextureSearch) extensions() []string {
+	if len(s.Extensions) > 0 {
+		return s.Extensions
+	}
+	return textureExtensions
+}
+
+func (s TextureSearch) usesCompressed() bool {
+	return s.Compressed == CompressedTexturesInclude || s.Compressed == CompressedTexturesPrefer
+}
+
+// candidates lists the files tried for a lowered image path, in order. The
+// path itself comes first if it has an image extension, then its base name
+// with each extension in turn; compressed containers go ahead of both when
+// preferred, and after them when included.
+func (s TextureSearch) candidates(lower string) []string {
+	base, hasExt := lower, false
+	known := s.extensions()
+	if s.usesCompressed() {
+		known = append(slices.Clip(known), compressedTextureExtensions...)
+	}
+	for _, ext := range known {
+		if strings.HasSuffix(lower, ext) {
+			base, hasExt = lower[:len(lower)-len(ext)], true
+			break
+		}
+	}
+
+	var candidates []string
+	add := func(p string) {
+		if !slices.Contains(candidates, p) {
+			candidates = append(candidates, p)
+		}
+	}
+	if s.Compressed == CompressedTexturesPrefer {
+		for _, ext := range compressedTextureExtensions {
+			add(base + ext)
+		}
+	}
+	if hasExt {
+		add(lower)
+	}
+	for _, ext := range s.extensions() {
+		add(base + ext)
+	}
+	if s.Compressed == CompressedTexturesInclude {
+		for _, ext := range compressedTextureExtensions {
+			add(base + ext)
+		}
+	}
+	return candidates
+}
+
+// resolve is ResolveTexture under s.
+func (s TextureSearch) resolve(path string, fileIndex map[string]string) (string, bool) {
+	lower := strings.ToLower(path)
+
+	// videoMap cinematics are used as they are
+	if isVideoFile(lower) {
+		_, ok := fileIndex[lower]
+		return lower, ok
+	}
+
+	for _, candidate := range s.candidates(lower) {
+		if _, ok := fileIndex[candidate]; ok {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// variants returns the compressed containers of a resolved image that ship
+// beside it when they're included.
+func (s TextureSearch) variants(resolved string, fileIndex map[string]string) []string {
+	if s.Compressed != CompressedTexturesInclude {
+		return nil
+	}
+	base := strings.TrimSuffix(resolved, path.Ext(resolved))
+	var variants []string
+	for _, ext := range compressedTextureExtensions {
+		if v := base + ext; v != resolved {
+			if _, ok := fileIndex[v]; ok {
+				variants = append(variants, v)
+			}
+		}
+	}
+	return variants
+}
+
+// companions returns the companion maps of a resolved image in the index,
+// each resolved as a texture of its own, when s takes them.
+func (s TextureSearch) companions(resolved string, fileIndex map[string]string) []string {
+	if !s.Companions || isVideoFile(resolved) {
+		return nil
+	}
+	base := strings.TrimSuffix(resolved, path.Ext(resolved))
+	var companions []string
+	for _, suffix := range companionSuffixes {
+		if p, ok := s.resolve(base+suffix, fileIndex); ok {
+			companions = append(companions, p)
+			companions = append(companions, s.variants(p, fileIndex)...)
+		}
+	}
+	return companions
+}
+
 // ResolveTexture finds the actual file path for an abstract texture path
 // by trying known image extensions. Returns the resolved path and true if found.
 func ResolveTexture(path string, fileIndex map[string]string) (string, bool) {
-	lower := strings.ToLower(path)
+	return TextureSearch{}.resolve(path, fileIndex)
+}
 
-	// If the path already has a recognized extension, check directly
+// isTextureFile reports whether path has a texture extension.
+func isTextureFile(path string) bool {
 	for _, ext := range textureExtensions {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// TextureResolution records how a texture reference was resolved: every
+// file tried, in the engine's order, and the one used.
+type TextureResolution struct {
+	Ref        string   `json:"ref"`                  // lowered reference, as a shader or model names it
+	Candidates []string `json:"candidates"`           // files tried, in order
+	Found      []string `json:"found,omitempty"`      // candidates present in the index
+	Winner     string   `json:"winner,omitempty"`     // file used; empty if none was found
+	Source     string   `json:"source,omitempty"`     // pk3 the winner comes from
+	Override   bool     `json:"override,omitempty"`   // the winner was forced by a texture override
+	Overridden string   `json:"overridden,omitempty"` // file the search order would have picked instead
+}
+
+// Ambiguous reports whether more than one candidate exists, so the choice
+// between them matters: mods sometimes ship a .tga and a .jpg of the same
+// texture with different content.
+func (r *TextureResolution) Ambiguous() bool {
+	return len(r.Found) > 1
+}
+
+// TraceTexture resolves path as ResolveTexture does, after any override in
+// overrides (see TextureOverrideKey), and records the decision.
+func TraceTexture(path string, fileIndex, overrides map[string]string) TextureResolution {
+	return TextureSearch{}.trace(path, fileIndex, overrides)
+}
+
+func (s TextureSearch) trace(path string, fileIndex, overrides map[string]string) TextureResolution {
+	lower := strings.ToLower(path)
+	r := TextureResolution{Ref: lower}
+	if isVideoFile(lower) {
+		r.Candidates = []string{lower}
+	} else {
+		r.Candidates = s.candidates(lower)
+	}
+	for _, candidate := range r.Candidates {
+		if _, ok := fileIndex[candidate]; ok {
+			r.Found = append(r.Found, candidate)
+		}
+	}
+	if len(r.Found) > 0 {
+		r.Winner = r.Found[0]
+	}
+	if forced, ok := overrides[TextureOverrideKey(lower)]; ok && forced != r.Winner {
+		if _, ok := fileIndex[forced]; ok {
+			r.Overridden = r.Winner
+			r.Winner = forced
+			r.Override = true
+		}
+	}
+	r.Source = fileIndex[r.Winner]
+	return r
+}
+
+// TextureOverrideKey returns the key a texture override is looked up by:
+// the lowered reference without its image extension, so one entry covers
+// textures/foo/bar, textures/foo/bar.tga, and textures/foo/bar.jpg alike.
+// The extensions stripped are the stock ones and the compressed containers.
+func TextureOverrideKey(path string) string {
+	lower := strings.ToLower(path)
+	for _, ext := range slices.Concat(textureExtensions, compressedTextureExtensions) {
 		if strings.HasSuffix(lower, ext) {
-			if _, ok := fileIndex[lower]; ok {
-				return lower, true
+			return lower[:len(lower)-len(ext)]
+		}
+	}
+	return lower
+}
+
+// LoadTextureOverrides reads a YAML or JSON map of texture references to
+// the files they must resolve to, for textures a mod ships in more than one
+// format with different content:
+//
+//	textures/mymod/floor: textures/mymod/floor.jpg
+//
+// Keys and files are lowered and keys normalized with TextureOverrideKey.
+func LoadTextureOverrides(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read texture overrides: %w", err)
+	}
+	var raw map[string]string
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse texture overrides: %w", err)
+	}
+	overrides := make(map[string]string, len(raw))
+	for ref, file := range raw {
+		overrides[TextureOverrideKey(ref)] = strings.ToLower(file)
+	}
+	return overrides, nil
+}
+
+// ResolveTexture is the package-level ResolveTexture, honoring the game's
+// texture overrides and texture search.
+func (gm *GameManifest) ResolveTexture(path string) (string, bool) {
+	if len(gm.TextureOverrides) > 0 && !isVideoFile(strings.ToLower(path)) {
+		if forced, ok := gm.TextureOverrides[TextureOverrideKey(path)]; ok {
+			if _, ok := gm.FileIndex[forced]; ok {
+				return forced, true
 			}
-			// Also try stripping and re-adding extensions
-			base := lower[:len(lower)-len(ext)]
-			return resolveWithExtensions(base, fileIndex)
 		}
 	}
+	return gm.textureSearch().resolve(path, gm.FileIndex)
+}
 
-	// No extension or unrecognized extension — try all
-	return resolveWithExtensions(lower, fileIndex)
+// TraceTexture is TraceTexture against the game's file index, overrides,
+// and texture search.
+func (gm *GameManifest) TraceTexture(path string) TextureResolution {
+	return gm.textureSearch().trace(path, gm.FileIndex, gm.TextureOverrides)
 }
 
-func resolveWithExtensions(base string, fileIndex map[string]string) (string, bool) {
-	for _, ext := range textureExtensions {
-		candidate := base + ext
-		if _, ok := fileIndex[candidate]; ok {
-			return candidate, true
+// textureSearch returns the search the game was built with.
+func (gm *GameManifest) textureSearch() TextureSearch {
+	if gm.Textures != nil {
+		return *gm.Textures
+	}
+	return TextureSearch{}
+}
+
+// applyTextureOverrides gives each game the overrides whose forced file it
+// has, and warns of any no game has.
+func applyTextureOverrides(manifest *Manifest, overrides map[string]string) {
+	for _, key := range sortedMapKeys(overrides) {
+		forced := overrides[key]
+		used := false
+		for _, gm := range manifest.Games {
+			if _, ok := gm.FileIndex[forced]; !ok {
+				continue
+			}
+			if gm.TextureOverrides == nil {
+				gm.TextureOverrides = make(map[string]string)
+			}
+			gm.TextureOverrides[key] = forced
+			used = true
+		}
+		if !used {
+			log.Printf("Warning: texture override %s → %s: no game has %s", key, forced, forced)
 		}
 	}
-	return "", false
 }
@@ -0,0 +1,166 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTextureOverrides(t *testing.T) {
+	q := t.TempDir()
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "pak0.pk3"), map[string][]byte{
+		"gfx/2d/crosshaira.tga": fixtureImage("crosshaira"),
+	})
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "map-floor.pk3"), map[string][]byte{
+		"textures/mod/floor.tga": fixtureImage("floor tga"),
+		"textures/mod/floor.jpg": fixtureImage("floor jpg"),
+		"maps/floor.bsp":         makeBSP([]string{"textures/mod/floor"}, []fixtureEntity{{{"classname", "worldspawn"}}}),
+	})
+
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	traces, err := TraceMapTextures("floor", manifest.Games["baseq3"])
+	if err != nil {
+		t.Fatalf("TraceMapTextures: %v", err)
+	}
+	if len(traces) != 1 || !traces[0].Ambiguous() || traces[0].Winner != "textures/mod/floor.tga" ||
+		filepath.Base(traces[0].Source) != "map-floor.pk3" {
+		t.Fatalf("traces = %+v, want floor.tga winning over floor.jpg", traces)
+	}
+
+	overridesPath := filepath.Join(t.TempDir(), "overrides.yaml")
+	if err := os.WriteFile(overridesPath, []byte("Textures/Mod/Floor.tga: textures/mod/floor.jpg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := LoadTextureOverrides(overridesPath)
+	if err != nil {
+		t.Fatalf("LoadTextureOverrides: %v", err)
+	}
+	if _, err := BuildBaseline(q, out, BuildOptions{TextureOverrides: overrides}); err != nil {
+		t.Fatalf("BuildBaseline with overrides: %v", err)
+	}
+	contents, err := MapPakFileSet(filepath.Join(out, "maps", "floor.pk3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contents["textures/mod/floor.jpg"] || contents["textures/mod/floor.tga"] {
+		t.Errorf("map pk3 holds %v, want the overriding floor.jpg only", sortedMapKeys(contents))
+	}
+
+	// The manifest keeps the overrides for later builds and traces
+	manifest, err = LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := manifest.Games["baseq3"].TraceTexture("textures/mod/floor")
+	if !r.Override || r.Winner != "textures/mod/floor.jpg" || r.Overridden != "textures/mod/floor.tga" {
+		t.Errorf("trace after override = %+v", r)
+	}
+}
+
+func TestTextureSearchCompressed(t *testing.T) {
+	index := map[string]string{
+		"textures/hd/wall.tga": "pak0.pk3",
+		"textures/hd/wall.dds": "zz-hd.pk3",
+		"textures/hd/trim.ktx": "zz-hd.pk3",
+	}
+	tests := []struct {
+		search TextureSearch
+		ref    string
+		want   string
+	}{
+		{TextureSearch{}, "textures/hd/wall.tga", "textures/hd/wall.tga"},
+		{TextureSearch{}, "textures/hd/trim", ""},
+		{TextureSearch{Compressed: CompressedTexturesInclude}, "textures/hd/wall.tga", "textures/hd/wall.tga"},
+		{TextureSearch{Compressed: CompressedTexturesInclude}, "textures/hd/trim.tga", "textures/hd/trim.ktx"},
+		{TextureSearch{Compressed: CompressedTexturesPrefer}, "textures/hd/wall.tga", "textures/hd/wall.dds"},
+		{TextureSearch{Extensions: []string{".dds", ".tga"}}, "textures/hd/wall", "textures/hd/wall.dds"},
+	}
+	for _, tt := range tests {
+		got, _ := tt.search.resolve(tt.ref, index)
+		if got != tt.want {
+			t.Errorf("%+v: resolve(%s) = %q, want %q", tt.search, tt.ref, got, tt.want)
+		}
+	}
+
+	gm := &GameManifest{
+		FileIndex: index,
+		Shaders:   map[string][]string{"textures/hd/wall": {"textures/hd/wall.tga"}},
+		Textures:  &TextureSearch{Compressed: CompressedTexturesInclude},
+	}
+	got := resolvedShaderTextures("textures/hd/wall", gm)
+	if want := []string{"textures/hd/wall.tga", "textures/hd/wall.dds"}; !slices.Equal(got, want) {
+		t.Errorf("included textures = %v, want %v", got, want)
+	}
+	if err := (TextureSearch{Compressed: "always"}).validate(); err == nil {
+		t.Error("unknown compressed texture policy accepted")
+	}
+}
+
+func TestTextureCompanions(t *testing.T) {
+	gm := &GameManifest{
+		FileIndex: map[string]string{
+			"textures/hd/wall.tga":      "pak0.pk3",
+			"textures/hd/wall_n.png":    "zz-hd.pk3",
+			"textures/hd/wall_glow.jpg": "zz-hd.pk3",
+			"textures/hd/wall_x.tga":    "zz-hd.pk3",
+		},
+	}
+	if got := resolvedShaderTextures("textures/hd/wall", gm); !slices.Equal(got, []string{"textures/hd/wall.tga"}) {
+		t.Errorf("without companions = %v", got)
+	}
+	gm.Textures = &TextureSearch{Companions: true}
+	got := resolvedShaderTextures("textures/hd/wall", gm)
+	if want := []string{"textures/hd/wall.tga", "textures/hd/wall_n.png", "textures/hd/wall_glow.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("with companions = %v, want %v", got, want)
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := manifest.Games["baseq3"]
+
+	floor, err := gm.ResolveFile("Textures/Custom/Floor")
+	if err != nil {
+		t.Fatalf("ResolveFile(floor): %v", err)
+	}
+	sum := sha256.Sum256(fixtureImage("floor"))
+	if floor.Path != "textures/custom/floor.tga" || filepath.Base(floor.Pk3) != "map-custom.pk3" || floor.Baseline ||
+		floor.SHA256 != hex.EncodeToString(sum[:]) || floor.Size != int64(len(fixtureImage("floor"))) || floor.Texture == nil {
+		t.Errorf("floor = %+v, want map-custom.pk3's floor.tga", floor)
+	}
+
+	sky, err := gm.ResolveFile("textures/custom/sky")
+	if err != nil {
+		t.Fatalf("ResolveFile(sky): %v", err)
+	}
+	if sky.Path != "" || sky.Shader != "scripts/custom.shader" {
+		t.Errorf("sky = %+v, want only its shader script", sky)
+	}
+
+	if wind, err := gm.ResolveFile("sound/custom/wind.wav"); err != nil || wind.Path != "sound/custom/wind.wav" || wind.Texture != nil {
+		t.Errorf("ResolveFile(wind) = %+v, %v", wind, err)
+	}
+	if _, err := gm.ResolveFile("sound/custom/missing.wav"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ResolveFile(missing) error = %v, want not found", err)
+	}
+}
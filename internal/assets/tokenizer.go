@@ -0,0 +1,126 @@
+package assets
+
+import "strings"
+
+// TokenKind is the kind of a Token.
+type TokenKind int
+
+const (
+	TokenWord    TokenKind = iota // a word or quoted string, without its quotes
+	TokenOpen                     // {
+	TokenClose                    // }
+	TokenComment                  // a // or /* */ comment, markers included
+)
+
+// Token is one token of a script, with the line it starts on and its byte
+// offset in the text (of the opening quote, for a quoted string).
+type Token struct {
+	Kind   TokenKind
+	Text   string
+	Line   int
+	Offset int
+}
+
+// Tokenizer splits the text of Q3 scripts, .shader, .menu, .bot, .arena,
+// and bot library files alike, into tokens in a single pass over the bytes,
+// as the engine's COM_ParseExt does: a quoted string is one word, comments
+// only start at a token boundary, and braces are tokens of their own even
+// when written against a word ("{map foo.tga"). Token texts are slices of
+// the input, not copies.
+type Tokenizer struct {
+	// Comments makes Next return comments as tokens instead of skipping them.
+	Comments bool
+
+	s    string
+	pos  int
+	line int
+}
+
+// NewTokenizer returns a Tokenizer reading s.
+func NewTokenizer(s string) *Tokenizer {
+	return &Tokenizer{s: s, line: 1}
+}
+
+// Next returns the next token, or false at the end of the text.
+func (t *Tokenizer) Next() (Token, bool) {
+	s := t.s
+	for t.pos < len(s) {
+		c := s[t.pos]
+		switch {
+		case c == '\n':
+			t.line++
+			t.pos++
+		case c <= ' ':
+			t.pos++
+		case c == '/' && t.pos+1 < len(s) && s[t.pos+1] == '/':
+			start := t.pos
+			for t.pos < len(s) && s[t.pos] != '\n' {
+				t.pos++
+			}
+			if t.Comments {
+				return Token{TokenComment, strings.TrimRight(s[start:t.pos], " \t\r"), t.line, start}, true
+			}
+		case c == '/' && t.pos+1 < len(s) && s[t.pos+1] == '*':
+			start, line := t.pos, t.line
+			t.pos += 2
+			for t.pos < len(s) && !(s[t.pos] == '*' && t.pos+1 < len(s) && s[t.pos+1] == '/') {
+				if s[t.pos] == '\n' {
+					t.line++
+				}
+				t.pos++
+			}
+			t.pos = min(t.pos+2, len(s))
+			if t.Comments {
+				return Token{TokenComment, s[start:t.pos], line, start}, true
+			}
+		case c == '{':
+			t.pos++
+			return Token{TokenOpen, "{", t.line, t.pos - 1}, true
+		case c == '}':
+			t.pos++
+			return Token{TokenClose, "}", t.line, t.pos - 1}, true
+		case c == '"':
+			// A quoted string ends at its closing quote, or unterminated, at
+			// the end of the line
+			start := t.pos + 1
+			t.pos = start
+			for t.pos < len(s) && s[t.pos] != '"' && s[t.pos] != '\n' {
+				t.pos++
+			}
+			text := s[start:t.pos]
+			if t.pos < len(s) && s[t.pos] == '"' {
+				t.pos++
+			}
+			return Token{TokenWord, text, t.line, start - 1}, true
+		default:
+			start := t.pos
+			for t.pos < len(s) && s[t.pos] > ' ' && s[t.pos] != '{' && s[t.pos] != '}' {
+				t.pos++
+			}
+			return Token{TokenWord, s[start:t.pos], t.line, start}, true
+		}
+	}
+	return Token{}, false
+}
+
+// Peek returns the token Next would, without consuming it.
+func (t *Tokenizer) Peek() (Token, bool) {
+	pos, line := t.pos, t.line
+	tok, ok := t.Next()
+	t.pos, t.line = pos, line
+	return tok, ok
+}
+
+// Tokenize returns every token of s, comments included if comments is set.
+func Tokenize(s string, comments bool) []Token {
+	t := NewTokenizer(s)
+	t.Comments = comments
+	var tokens []Token
+	for {
+		tok, ok := t.Next()
+		if !ok {
+			return tokens
+		}
+		tokens = append(tokens, tok)
+	}
+}
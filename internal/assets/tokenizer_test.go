@@ -0,0 +1,36 @@
+package assets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizer(t *testing.T) {
+	src := "name{map \"a b.tga\" // note\n/* two\nlines */ x//y }\n\"open"
+	var got []Token
+	tok := NewTokenizer(src)
+	tok.Comments = true
+	if peek, _ := tok.Peek(); peek.Text != "name" {
+		t.Errorf("Peek = %+v", peek)
+	}
+	for tk, ok := tok.Next(); ok; tk, ok = tok.Next() {
+		got = append(got, tk)
+	}
+	want := []Token{
+		{TokenWord, "name", 1, 0},
+		{TokenOpen, "{", 1, 4},
+		{TokenWord, "map", 1, 5},
+		{TokenWord, "a b.tga", 1, 9},
+		{TokenComment, "// note", 1, 19},
+		{TokenComment, "/* two\nlines */", 2, 27},
+		{TokenWord, "x//y", 3, 43},
+		{TokenClose, "}", 3, 48},
+		{TokenWord, "open", 4, 50},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens = %+v\nwant %+v", got, want)
+	}
+	if n := len(Tokenize(src, false)); n != len(want)-2 {
+		t.Errorf("Tokenize without comments = %d tokens, want %d", n, len(want)-2)
+	}
+}
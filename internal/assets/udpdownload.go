@@ -0,0 +1,155 @@
+package assets
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Limits of the engine's in-game download, which sends pk3s over the game
+// connection to clients with cl_allowDownload when the server has no sv_dlURL
+const (
+	// udpDownloadMaxPath is MAX_QPATH: the engine truncates the
+	// "<game>/<name>.pk3" it looks up to one less than this
+	udpDownloadMaxPath = 64
+	// udpDownloadMaxSize is the largest size the download protocol's
+	// signed 32-bit length field can describe
+	udpDownloadMaxSize = math.MaxInt32
+	// DefaultUDPDownloadWarnSize is the size above which a UDP download is
+	// slow enough to warn about: about a minute at ioquake3's default
+	// sv_dlRate of 100 KB/s.
+	DefaultUDPDownloadWarnSize = 6 << 20
+)
+
+// DownloadPak is a generated pk3 laid out as a game directory entry, the way
+// servers serve it over UDP and clients fetch it from an sv_dlURL.
+type DownloadPak struct {
+	Artifact string `json:"artifact"` // output-relative path of the built pk3
+	Path     string `json:"path"`     // "<game>/<name>.pk3"
+	Size     int64  `json:"size"`
+	Checksum int32  `json:"checksum"`
+}
+
+// DownloadLayout is the game directory layout of a build's pk3s, with the
+// problems the engine's download would have with them.
+type DownloadLayout struct {
+	Paks        []DownloadPak `json:"paks"`
+	Diagnostics Diagnostics   `json:"diagnostics,omitempty"`
+}
+
+// PlanDownloadLayout lays a manifest's artifacts out as game directories:
+// each game's baseline pk3 in its own directory, and each map pk3 in the
+// lowest game that has the map, so every game layered over it can offer it.
+// Paks the engine couldn't download are reported as errors; ones larger than
+// warnSize (0 for DefaultUDPDownloadWarnSize) as warnings.
+func PlanDownloadLayout(manifest *Manifest, warnSize int64) *DownloadLayout {
+	if warnSize <= 0 {
+		warnSize = DefaultUDPDownloadWarnSize
+	}
+	layout := &DownloadLayout{Paks: []DownloadPak{}}
+	for _, rel := range sortedMapKeys(manifest.Artifacts) {
+		a := manifest.Artifacts[rel]
+		game := strings.TrimSuffix(rel, ".pk3")
+		name := rel
+		if mapFile, ok := strings.CutPrefix(rel, "maps/"); ok {
+			game, name = mapGame(manifest, strings.TrimSuffix(mapFile, ".pk3")), mapFile
+			if game == "" {
+				layout.Diagnostics = append(layout.Diagnostics, Diagnostic{Severity: SeverityWarning, Kind: DiagDownload, Subject: rel, Detail: "no game in the manifest has the map"})
+				continue
+			}
+		}
+		layout.Paks = append(layout.Paks, DownloadPak{Artifact: rel, Path: game + "/" + name, Size: a.Size, Checksum: a.Checksum})
+	}
+	sort.Slice(layout.Paks, func(i, j int) bool { return layout.Paks[i].Path < layout.Paks[j].Path })
+
+	seenPath := make(map[string]string)
+	seenChecksum := make(map[int32]string)
+	for _, p := range layout.Paks {
+		for _, problem := range udpDownloadProblems(p) {
+			layout.Diagnostics = append(layout.Diagnostics, Diagnostic{Severity: SeverityError, Kind: DiagDownload, Subject: p.Path, Detail: problem})
+		}
+		// Clients on case-insensitive filesystems would save both paks
+		// to the same file
+		if other, ok := seenPath[strings.ToLower(p.Path)]; ok {
+			layout.Diagnostics = append(layout.Diagnostics, Diagnostic{Severity: SeverityError, Kind: DiagDownload, Subject: p.Path, Detail: "same name as " + other + " apart from case"})
+		}
+		seenPath[strings.ToLower(p.Path)] = p.Path
+		// The engine tells paks apart by checksum when deciding what a
+		// client is missing
+		if p.Checksum == 0 {
+			layout.Diagnostics = append(layout.Diagnostics, Diagnostic{Severity: SeverityWarning, Kind: DiagDownload, Subject: p.Path, Detail: "no checksum in the manifest; rebuild to record one"})
+		} else if other, ok := seenChecksum[p.Checksum]; ok {
+			layout.Diagnostics = append(layout.Diagnostics, Diagnostic{Severity: SeverityError, Kind: DiagDownload, Subject: p.Path, Detail: "same checksum as " + other})
+		} else {
+			seenChecksum[p.Checksum] = p.Path
+		}
+		if p.Size > warnSize && p.Size <= udpDownloadMaxSize {
+			layout.Diagnostics = append(layout.Diagnostics, Diagnostic{Severity: SeverityWarning, Kind: DiagDownload, Subject: p.Path,
+				Detail: fmt.Sprintf("%s is slow to download in game; consider sv_dlURL", formatSize(p.Size))})
+		}
+	}
+	return layout
+}
+
+// mapGame returns the lowest game in the build order whose file index has a
+// map, or "" if none has it.
+func mapGame(manifest *Manifest, mapName string) string {
+	for _, game := range manifest.GameNames() {
+		if _, ok := manifest.Games[game].FileIndex["maps/"+mapName+".bsp"]; ok {
+			return game
+		}
+	}
+	return ""
+}
+
+// udpDownloadProblems lists why the engine would refuse or mangle a pak's
+// download.
+func udpDownloadProblems(p DownloadPak) []string {
+	var problems []string
+	if len(p.Path) >= udpDownloadMaxPath {
+		problems = append(problems, fmt.Sprintf("path is %d characters; the engine allows %d", len(p.Path), udpDownloadMaxPath-1))
+	}
+	// sv_referencedPakNames is a space-separated list in an info string, and
+	// clients tokenize it as commands, so names keep to a safe set
+	for _, r := range p.Path {
+		if !isDownloadNameRune(r) {
+			problems = append(problems, fmt.Sprintf("character %q can't be sent in a pak name", r))
+			break
+		}
+	}
+	if game, name, _ := strings.Cut(p.Path, "/"); (strings.EqualFold(game, "baseq3") || strings.EqualFold(game, "missionpack")) && IsOfficialPak(name) {
+		problems = append(problems, "clients refuse to download id pak names")
+	}
+	if p.Size > udpDownloadMaxSize {
+		problems = append(problems, fmt.Sprintf("%s is too large for the download protocol", formatSize(p.Size)))
+	}
+	return problems
+}
+
+func isDownloadNameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		r == '_' || r == '-' || r == '.' || r == '/'
+}
+
+// WriteDownloadLayout links (or, across filesystems, copies) a layout's paks
+// from a build's output directory into dir as <game>/<name>.pk3: the game
+// directories to serve in-game downloads from, or to publish at an sv_dlURL.
+func WriteDownloadLayout(layout *DownloadLayout, outputDir, dir string) error {
+	for _, p := range layout.Paks {
+		src := filepath.Join(outputDir, filepath.FromSlash(p.Artifact))
+		dst := filepath.Join(dir, filepath.FromSlash(p.Path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		os.Remove(dst)
+		if err := os.Link(src, dst); err != nil {
+			if err := copyFile(src, dst); err != nil {
+				return fmt.Errorf("write %s: %w", p.Path, err)
+			}
+		}
+	}
+	return nil
+}
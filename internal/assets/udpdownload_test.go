@@ -0,0 +1,77 @@
+package assets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlanDownloadLayout(t *testing.T) {
+	manifest := &Manifest{
+		Games: map[string]*GameManifest{
+			"baseq3": {FileIndex: map[string]string{
+				"maps/dm1.bsp":  "a.pk3",
+				"maps/pak0.bsp": "a.pk3",
+				"maps/a b.bsp":  "a.pk3",
+				"maps/big.bsp":  "a.pk3",
+			}},
+			"cpma": {Base: "baseq3", FileIndex: map[string]string{
+				"maps/dm1.bsp":  "a.pk3",
+				"maps/cpm1.bsp": "cpma.pk3",
+			}},
+		},
+		Artifacts: map[string]Artifact{
+			"baseq3.pk3":    {Size: 1 << 20, Checksum: 1},
+			"cpma.pk3":      {Size: 1 << 20, Checksum: 2},
+			"maps/dm1.pk3":  {Size: 1 << 10, Checksum: 3},
+			"maps/cpm1.pk3": {Size: 1 << 10, Checksum: 3},
+			"maps/pak0.pk3": {Size: 1 << 10, Checksum: 4},
+			"maps/a b.pk3":  {Size: 1 << 10, Checksum: 5},
+			"maps/big.pk3":  {Size: 8 << 20, Checksum: 6},
+			"maps/gone.pk3": {Size: 1 << 10, Checksum: 7},
+		},
+	}
+	layout := PlanDownloadLayout(manifest, 0)
+
+	paths := make(map[string]string)
+	for _, p := range layout.Paks {
+		paths[p.Artifact] = p.Path
+	}
+	for artifact, want := range map[string]string{
+		"baseq3.pk3":    "baseq3/baseq3.pk3",
+		"cpma.pk3":      "cpma/cpma.pk3",
+		"maps/dm1.pk3":  "baseq3/dm1.pk3", // the lowest game with the map
+		"maps/cpm1.pk3": "cpma/cpm1.pk3",
+	} {
+		if paths[artifact] != want {
+			t.Errorf("%s laid out at %q, want %q", artifact, paths[artifact], want)
+		}
+	}
+	if _, ok := paths["maps/gone.pk3"]; ok {
+		t.Errorf("map no game has was laid out")
+	}
+
+	want := map[string]Severity{
+		"maps/gone.pk3|no game":       SeverityWarning,
+		"baseq3/pak0.pk3|id pak":      SeverityError,
+		"baseq3/a b.pk3|character":    SeverityError,
+		"baseq3/big.pk3|slow":         SeverityWarning,
+		"cpma/cpm1.pk3|same checksum": SeverityError,
+	}
+	for _, d := range layout.Diagnostics {
+		matched := false
+		for key, severity := range want {
+			subject, word, _ := strings.Cut(key, "|")
+			if d.Subject == subject && strings.Contains(d.Detail, word) && d.Severity == severity {
+				delete(want, key)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			t.Errorf("unexpected diagnostic: %s", d)
+		}
+	}
+	for key := range want {
+		t.Errorf("missing diagnostic: %s", key)
+	}
+}
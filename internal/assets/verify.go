@@ -0,0 +1,161 @@
+package assets
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UnresolvedRef is an asset reference that would fail to load at runtime.
+type UnresolvedRef struct {
+	Kind string // "shader", "texture", "model", "sound", "music"
+	Ref  string // the reference as written
+	From string // what referenced it (BSP, model, or shader name)
+}
+
+func (u UnresolvedRef) String() string {
+	return fmt.Sprintf("%s %s (from %s)", u.Kind, u.Ref, u.From)
+}
+
+// mapVerifier walks references the way the engine loads them, against the
+// file index and shader definitions of the pk3s a client would have mounted.
+type mapVerifier struct {
+	fileIndex  map[string]string
+	shaders    map[string][]string
+	visited    map[string]bool
+	unresolved []UnresolvedRef
+}
+
+// VerifyMapPak simulates engine resolution for a built map pk3 mounted on top
+// of the given baseline pk3s (in load order). It loads the BSP from the map
+// pk3, walks every shader, texture, model, sound, and music reference, and
+// returns those that wouldn't resolve, with diagnostics for pk3s whose
+// shaders couldn't be read, since their references then look unresolved.
+func VerifyMapPak(mapPk3 string, baselinePk3s []string) ([]UnresolvedRef, Diagnostics, error) {
+	pk3s := append(append([]string(nil), baselinePk3s...), mapPk3)
+	fileIndex, err := BuildFileIndex(pk3s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mapFiles, err := MapPakFileSet(mapPk3)
+	if err != nil {
+		return nil, nil, err
+	}
+	var bspPath string
+	for name := range mapFiles {
+		if strings.HasPrefix(name, "maps/") && strings.HasSuffix(name, ".bsp") && (bspPath == "" || name < bspPath) {
+			bspPath = name
+		}
+	}
+	if bspPath == "" {
+		return nil, nil, fmt.Errorf("no BSP in %s", mapPk3)
+	}
+
+	v := &mapVerifier{
+		fileIndex: fileIndex,
+		shaders:   make(map[string][]string),
+		visited:   make(map[string]bool),
+	}
+	shaderFiles := make(map[string]string)
+	var diags Diagnostics
+	for _, pk3Path := range pk3s {
+		if err := parseShadersPk3(pk3Path, v.shaders, shaderFiles, nil, nil, nil); err != nil {
+			diags = append(diags, Diagnostic{Severity: SeverityWarning, Kind: DiagBadPk3, Subject: filepath.Base(pk3Path), Detail: "shaders: " + err.Error()})
+		}
+	}
+
+	data, err := readFileFromIndex(bspPath, fileIndex)
+	if err != nil {
+		return nil, diags, fmt.Errorf("read BSP: %w", err)
+	}
+	bsp, err := ParseBSP(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, diags, fmt.Errorf("parse BSP: %w", err)
+	}
+
+	for _, shader := range bsp.Shaders {
+		v.shader(shader, bspPath)
+	}
+	for _, model := range bsp.Models {
+		v.model(model, bspPath)
+	}
+	for _, sound := range bsp.Sounds {
+		v.file("sound", sound, bspPath)
+	}
+	for _, music := range bsp.Music {
+		v.file("music", music, bspPath)
+	}
+
+	sort.Slice(v.unresolved, func(i, j int) bool {
+		if v.unresolved[i].Kind != v.unresolved[j].Kind {
+			return v.unresolved[i].Kind < v.unresolved[j].Kind
+		}
+		return v.unresolved[i].Ref < v.unresolved[j].Ref
+	})
+	return v.unresolved, diags, nil
+}
+
+func (v *mapVerifier) fail(kind, ref, from string) {
+	v.unresolved = append(v.unresolved, UnresolvedRef{Kind: kind, Ref: ref, From: from})
+}
+
+// shader mirrors R_FindShader: a defined shader needs each of its stage images;
+// an undefined one falls back to an image with the shader's name.
+func (v *mapVerifier) shader(name, from string) {
+	lower := strings.ToLower(name)
+	if v.visited["shader:"+lower] || lower == "noshader" {
+		return
+	}
+	v.visited["shader:"+lower] = true
+
+	textures, ok := v.shaders[lower]
+	if !ok {
+		if _, found := ResolveTexture(lower, v.fileIndex); !found {
+			v.fail("shader", name, from)
+		}
+		return
+	}
+	for _, tex := range textures {
+		if _, found := ResolveTexture(tex, v.fileIndex); !found {
+			v.fail("texture", tex, name)
+		}
+	}
+}
+
+// model checks an MD3 exists and verifies its surface shaders.
+func (v *mapVerifier) model(path, from string) {
+	lower := strings.ToLower(path)
+	if v.visited["model:"+lower] {
+		return
+	}
+	v.visited["model:"+lower] = true
+
+	data, err := readFileFromIndex(lower, v.fileIndex)
+	if err != nil {
+		v.fail("model", path, from)
+		return
+	}
+	shaders, err := ParseMD3Shaders(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		v.fail("model", path, from)
+		return
+	}
+	for _, shader := range shaders {
+		v.shader(shader, lower)
+	}
+}
+
+// file checks a plain file reference exists.
+func (v *mapVerifier) file(kind, path, from string) {
+	lower := strings.ToLower(path)
+	if v.visited[kind+":"+lower] {
+		return
+	}
+	v.visited[kind+":"+lower] = true
+	if _, ok := v.fileIndex[lower]; !ok {
+		v.fail(kind, path, from)
+	}
+}
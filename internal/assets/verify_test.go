@@ -0,0 +1,57 @@
+package assets
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyMapPakBadShaders(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "pak0.pk3")
+	writeFixturePk3(t, base, map[string][]byte{
+		"scripts/good.shader":      []byte("textures/v/floor\n{\n\t{\n\t\tmap textures/v/floor_img.tga\n\t}\n}\n"),
+		"scripts/bad.shader":       []byte("textures/v/wall\n{\n\t{\n\t\tmap textures/v/wall_img.tga\n\t}\n}\n"),
+		"textures/v/floor_img.tga": fixtureImage("floor"),
+		"textures/v/wall_img.tga":  fixtureImage("wall"),
+	})
+	mapPk3 := filepath.Join(dir, "v.pk3")
+	writeFixturePk3(t, mapPk3, map[string][]byte{
+		"maps/v.bsp": makeBSP([]string{"textures/v/floor", "textures/v/wall"}, []fixtureEntity{{{"classname", "worldspawn"}}}),
+	})
+
+	// Corrupt bad.shader's compressed data, so it can't be read
+	r, err := zip.OpenReader(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset int64
+	for _, f := range r.File {
+		if f.Name == "scripts/bad.shader" {
+			offset, _ = f.DataOffset()
+		}
+	}
+	r.Close()
+	data, err := os.ReadFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 8 {
+		data[offset+int64(i)] ^= 0xFF
+	}
+	if err := os.WriteFile(base, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	unresolved, diags, err := VerifyMapPak(mapPk3, []string{base})
+	if err != nil {
+		t.Fatalf("VerifyMapPak: %v", err)
+	}
+	if len(diags) != 1 || diags[0].Kind != DiagBadPk3 || diags[0].Subject != "pak0.pk3" {
+		t.Errorf("diagnostics = %v, want pak0.pk3's shaders", diags)
+	}
+	if len(unresolved) != 1 || unresolved[0].Ref != "textures/v/wall" {
+		t.Errorf("unresolved = %v, want the unreadable shader", unresolved)
+	}
+}
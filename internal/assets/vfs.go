@@ -0,0 +1,125 @@
+package assets
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// VFS is a game's merged virtual filesystem: every path the engine would see,
+// mapped to the pk3 that supplies the winning copy.
+type VFS struct {
+	index map[string]string // lowered path → source pk3
+}
+
+// VFSEntry is a file in a VFS.
+type VFSEntry struct {
+	Path string // lowered virtual path
+	Pk3  string // pk3 that supplies it
+}
+
+// OpenVFS indexes a game's pk3s in load order. Games other than baseq3 are
+// layered over baseq3, as the engine does.
+func OpenVFS(quake3Dir, game string) (*VFS, error) {
+	gamePk3s := CollectGamePk3s(quake3Dir)
+	var pk3s []string
+	if game != "baseq3" {
+		pk3s = append(pk3s, gamePk3s["baseq3"]...)
+	}
+	pk3s = append(pk3s, gamePk3s[game]...)
+	if len(pk3s) == 0 {
+		return nil, fmt.Errorf("no pk3s for %s in %s", game, quake3Dir)
+	}
+
+	index, err := BuildFileIndex(pk3s)
+	if err != nil {
+		return nil, err
+	}
+	return &VFS{index: index}, nil
+}
+
+// NewVFS wraps an existing file index, such as a manifest game's FileIndex.
+func NewVFS(fileIndex map[string]string) *VFS {
+	return &VFS{index: fileIndex}
+}
+
+// Source returns the pk3 that supplies path.
+func (v *VFS) Source(path string) (string, bool) {
+	pk3, ok := v.index[strings.ToLower(path)]
+	return pk3, ok
+}
+
+// List returns the files at or under path, sorted. An empty path lists everything.
+func (v *VFS) List(path string) []VFSEntry {
+	prefix := strings.Trim(strings.ToLower(path), "/")
+	var entries []VFSEntry
+	for p, pk3 := range v.index {
+		if prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			entries = append(entries, VFSEntry{Path: p, Pk3: pk3})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// ReadFile reads the winning copy of path.
+func (v *VFS) ReadFile(path string) ([]byte, error) {
+	pk3, ok := v.Source(path)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+	return ReadFileFromPk3(pk3, path)
+}
+
+// Extract writes the files at or under path into destDir, keeping their
+// lowered virtual paths. Entries that would escape destDir are skipped.
+// Returns the number of files written.
+func (v *VFS) Extract(path, destDir string) (int, error) {
+	entries := v.List(path)
+	if len(entries) == 0 {
+		return 0, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+
+	// Read each source pk3 once
+	byPk3 := make(map[string][]string)
+	for _, e := range entries {
+		byPk3[e.Pk3] = append(byPk3[e.Pk3], e.Path)
+	}
+	pk3s := mapKeys(byPk3)
+	sort.Strings(pk3s)
+	written := 0
+	for _, pk3 := range pk3s {
+		files, err := ExtractFilesFromPk3s(byPk3[pk3], v.index)
+		if err != nil {
+			return written, err
+		}
+		for _, p := range byPk3[pk3] {
+			dest, err := safeJoin(destDir, p)
+			if err != nil {
+				log.Printf("Warning: skipping %s: %v", p, err)
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return written, err
+			}
+			if err := os.WriteFile(dest, files[p], 0644); err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+	return written, nil
+}
+
+// safeJoin joins a pk3 entry name onto dir, refusing names that would land
+// outside it.
+func safeJoin(dir, name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("unsafe path in pk3: %s", name)
+	}
+	return filepath.Join(dir, cleaned), nil
+}
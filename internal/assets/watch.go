@@ -0,0 +1,483 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultWatchInterval = 10 * time.Second
+
+// WatchOptions configures Watch.
+type WatchOptions struct {
+	Quake3Dir string
+	OutputDir string        // demobake output: baseline pk3s, maps/, manifest.json
+	DemoDir   string        // demos to index; empty disables demo indexing
+	Interval  time.Duration // poll interval; 0 = defaultWatchInterval
+	Build     BuildOptions
+
+	// MapRepositories are URL templates to fetch maps demos need but the
+	// install lacks from (see FetchMap); empty disables fetching
+	MapRepositories []string
+	Download        DownloadOptions // for map repository fetches
+}
+
+// Watch keeps a demobake output in step with a live server install until ctx
+// is cancelled. It polls rather than using filesystem notifications, so it
+// works the same on network mounts and needs no extra dependencies.
+//
+// A pk3 added to a game directory is indexed into the existing manifest and
+// any maps it contains get map pk3s. Changed or removed pk3s, new official
+// paks, and new game directories trigger a full rebuild. New demos get a
+// trailer if they lack one and a sidecar, and their map a map pk3 if it has
+// none (see EnsureDemoMapPak). Files are only picked up once
+// their size and modification time are unchanged across two polls, so
+// copies and recordings in progress are left alone.
+func Watch(ctx context.Context, opts WatchOptions) error {
+	if opts.Interval == 0 {
+		opts.Interval = defaultWatchInterval
+	}
+	w := &watcher{
+		opts:      opts,
+		pk3s:      make(map[string]fileStamp),
+		lastPk3s:  make(map[string]fileStamp),
+		demos:     make(map[string]fileStamp),
+		lastDemos: make(map[string]fileStamp),
+	}
+	unlock, err := LockOutput(opts.OutputDir)
+	if err != nil {
+		return err
+	}
+	err = w.init()
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+// poll runs one round of checks, holding the output lock so builds by other
+// processes don't interleave with its own.
+func (w *watcher) poll() {
+	unlock, err := LockOutput(w.opts.OutputDir)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	defer unlock()
+	w.pollPk3s()
+	if w.opts.DemoDir != "" {
+		w.pollDemos()
+	}
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func statStamp(path string) (fileStamp, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, false
+	}
+	return fileStamp{size: info.Size(), modTime: info.ModTime()}, true
+}
+
+type watcher struct {
+	opts     WatchOptions
+	manifest *Manifest
+
+	pk3s      map[string]fileStamp // pk3s reflected in the manifest
+	lastPk3s  map[string]fileStamp // pk3s seen on the previous poll
+	demos     map[string]fileStamp // demos already indexed (or failed)
+	lastDemos map[string]fileStamp
+
+	mapCache *mapInfoCache   // worldspawns and readmes read so far, kept between polls
+	fetched  map[string]bool // missing maps already tried in the map repositories
+}
+
+func (w *watcher) manifestPath() string {
+	return filepath.Join(w.opts.OutputDir, "manifest.json")
+}
+
+// init loads the existing manifest, or builds one if there is none. Pk3s
+// the manifest doesn't reference are treated as new on the first poll.
+func (w *watcher) init() error {
+	manifest, err := LoadManifest(w.manifestPath())
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Watch: no manifest in %s, running full build", w.opts.OutputDir)
+		return w.rebuild()
+	}
+	if err != nil {
+		return err
+	}
+	w.manifest = manifest
+
+	known := make(map[string]bool)
+	for _, gm := range manifest.Games {
+		for _, pk3Path := range gm.FileIndex {
+			known[pk3Path] = true
+		}
+		for _, q := range gm.Quarantined {
+			known[q.Path] = true
+		}
+	}
+	for _, pk3s := range collectGameSources(w.opts.Quake3Dir, w.opts.Build) {
+		for _, pk3Path := range pk3s {
+			if stamp, ok := statStamp(pk3Path); ok && known[pk3Path] {
+				w.pk3s[pk3Path] = stamp
+			}
+		}
+	}
+	return nil
+}
+
+// rebuild runs a full BuildBaseline and records the current pk3s. They're
+// recorded even if the build fails, so a failing build isn't rerun on every
+// poll, only once the pk3s change again.
+func (w *watcher) rebuild() error {
+	defer w.recordPk3s()
+	if _, err := buildBaselineLocked(w.opts.Quake3Dir, w.opts.OutputDir, w.opts.Build); err != nil {
+		return err
+	}
+	manifest, err := LoadManifest(w.manifestPath())
+	if err != nil {
+		return err
+	}
+	// A full build starts a new manifest; keep the maps demos still need
+	if w.manifest != nil && len(w.manifest.MissingMaps) > 0 {
+		for key, missing := range w.manifest.MissingMaps {
+			if _, built := manifest.Artifacts["maps/"+missing.Map+".pk3"]; !built {
+				if manifest.MissingMaps == nil {
+					manifest.MissingMaps = make(map[string]*MissingMap)
+				}
+				manifest.MissingMaps[key] = missing
+			}
+		}
+		if err := manifest.Save(w.manifestPath()); err != nil {
+			return err
+		}
+	}
+	w.manifest = manifest
+	return nil
+}
+
+// recordPk3s records the install's current pk3s as the ones the manifest
+// reflects.
+func (w *watcher) recordPk3s() {
+	w.pk3s = make(map[string]fileStamp)
+	for _, pk3s := range collectGameSources(w.opts.Quake3Dir, w.opts.Build) {
+		for _, pk3Path := range pk3s {
+			if stamp, ok := statStamp(pk3Path); ok {
+				w.pk3s[pk3Path] = stamp
+			}
+		}
+	}
+}
+
+// pollPk3s compares the install against the manifest and applies settled changes.
+func (w *watcher) pollPk3s() {
+	gamePk3s := collectGameSources(w.opts.Quake3Dir, w.opts.Build)
+	current := make(map[string]fileStamp)
+	for _, pk3s := range gamePk3s {
+		for _, pk3Path := range pk3s {
+			if stamp, ok := statStamp(pk3Path); ok {
+				current[pk3Path] = stamp
+			}
+		}
+	}
+	defer func() { w.lastPk3s = current }()
+
+	needRebuild := false
+	for pk3Path := range w.pk3s {
+		if _, ok := current[pk3Path]; !ok {
+			log.Printf("Watch: %s removed", pk3Path)
+			needRebuild = true
+		}
+	}
+
+	type addedPk3 struct{ game, path string }
+	var added []addedPk3
+	for _, game := range orderGames(mapKeys(gamePk3s)) {
+		for _, pk3Path := range gamePk3s[game] {
+			stamp := current[pk3Path]
+			if known, ok := w.pk3s[pk3Path]; ok && known == stamp {
+				continue
+			}
+			if w.lastPk3s[pk3Path] != stamp {
+				continue // still being written
+			}
+			_, existed := w.pk3s[pk3Path]
+			base := filepath.Base(pk3Path)
+			switch {
+			case existed:
+				log.Printf("Watch: %s changed", pk3Path)
+				needRebuild = true
+			case w.manifest.Games[game] == nil || IsOfficialPak(base) || IsTrinityPak(base):
+				log.Printf("Watch: %s added", pk3Path)
+				needRebuild = true
+			default:
+				added = append(added, addedPk3{game, pk3Path})
+			}
+		}
+	}
+
+	if needRebuild {
+		log.Printf("Watch: rebuilding %s", w.opts.OutputDir)
+		if err := w.rebuild(); err != nil {
+			log.Printf("Warning: rebuild failed, retrying once the install changes again: %v", err)
+		}
+		return
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	for _, a := range added {
+		log.Printf("Watch: indexing %s (%s)", a.path, a.game)
+		if err := w.addPk3(a.game, a.path, gamePk3s); err != nil {
+			log.Printf("Warning: %s: %v", a.path, err)
+		}
+		w.pk3s[a.path] = current[a.path]
+	}
+	purePath := filepath.Join(w.opts.OutputDir, PureListName)
+	if err := savePureLists(purePath, buildPureLists(w.manifest, gamePk3s)); err != nil {
+		log.Printf("Warning: save pure lists: %v", err)
+	}
+	if err := w.manifest.Save(w.manifestPath()); err != nil {
+		log.Printf("Warning: save manifest: %v", err)
+	}
+}
+
+// addPk3 layers a new non-official pk3 into the manifest at its load-order
+// position and builds map pk3s for the maps it adds. A pk3 added to a base
+// game also reaches the games layered over it, where they don't override it.
+func (w *watcher) addPk3(game, pk3Path string, gamePk3s map[string][]string) error {
+	var files []string
+	names := make(map[string]string)
+	err := IteratePk3(pk3Path, func(name string, _ func() (io.ReadCloser, error)) error {
+		if !strings.HasSuffix(name, "/") {
+			lower := strings.ToLower(name)
+			files = append(files, lower)
+			names[lower] = name
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	shaders := make(map[string][]string)
+	shaderFiles := make(map[string]string)
+	shaderLinks := make(map[string][]string)
+	lightImages := make(map[string][]string)
+	if err := parseShadersPk3(pk3Path, shaders, shaderFiles, shaderLinks, lightImages, nil); err != nil {
+		log.Printf("Warning: failed to parse shaders from %s: %v", filepath.Base(pk3Path), err)
+	}
+	videos := make(map[string]*RoQInfo)
+	if err := parseVideosPk3(pk3Path, videos); err != nil {
+		log.Printf("Warning: failed to read videos from %s: %v", filepath.Base(pk3Path), err)
+	}
+
+	var games []string
+	for _, g := range w.manifest.GameNames() {
+		if containsString(w.manifest.Layers(g), game) {
+			games = append(games, g)
+		}
+	}
+
+	var newMaps []string
+	refCache := make(map[string][]string)
+	if w.mapCache == nil {
+		w.mapCache = newMapInfoCache()
+	}
+	for _, g := range games {
+		gm := w.manifest.Games[g]
+		layers := w.manifest.Layers(g)
+		// In games layered over game, the pk3 stays below everything the
+		// layers above load and competes only with game's own pk3s
+		winsOver := func(owner string) bool {
+			if owner == "" {
+				return true
+			}
+			for _, layer := range layers {
+				p := loadPosition(gamePk3s[layer], owner)
+				if layer == game {
+					return p < loadPosition(gamePk3s[game], pk3Path)
+				}
+				if p >= 0 {
+					return false
+				}
+			}
+			return true
+		}
+		var won []string
+		for _, path := range files {
+			if !winsOver(gm.FileIndex[path]) {
+				continue
+			}
+			won = append(won, path)
+			gm.FileIndex[path] = pk3Path
+			delete(gm.OfficialFiles, path)
+			delete(gm.OriginalNames, path)
+			if names[path] != path {
+				if gm.OriginalNames == nil {
+					gm.OriginalNames = make(map[string]string)
+				}
+				gm.OriginalNames[path] = names[path]
+			}
+			if info, ok := videos[path]; ok {
+				if gm.Videos == nil {
+					gm.Videos = make(map[string]*RoQInfo)
+				}
+				gm.Videos[path] = info
+			}
+			if g == game && strings.HasPrefix(path, "maps/") && strings.HasSuffix(path, ".bsp") {
+				newMaps = append(newMaps, strings.TrimSuffix(strings.TrimPrefix(path, "maps/"), ".bsp"))
+			}
+		}
+		for name, textures := range shaders {
+			if winsOver(gm.FileIndex[gm.ShaderFiles[name]]) || gm.FileIndex[gm.ShaderFiles[name]] == pk3Path {
+				gm.Shaders[name] = textures
+				gm.ShaderFiles[name] = shaderFiles[name]
+				if gm.ShaderLinks == nil {
+					gm.ShaderLinks = make(map[string][]string)
+				}
+				setShaderList(gm.ShaderLinks, name, shaderLinks[name])
+				if gm.LightImages == nil {
+					gm.LightImages = make(map[string][]string)
+				}
+				setShaderList(gm.LightImages, name, lightImages[name])
+			}
+		}
+		gm.indexShaderRefs(won, refCache)
+		gm.indexMaps(w.mapCache)
+	}
+
+	for _, mapName := range newMaps {
+		mapPk3Path := filepath.Join(w.opts.OutputDir, "maps", mapName+".pk3")
+		log.Printf("Building map pk3: %s (%s)", mapName, game)
+		if _, err := BuildMapPak(mapName, game, w.manifest, w.opts.Quake3Dir, mapPk3Path, w.opts.Build.MapPak); err != nil {
+			log.Printf("Warning: failed to build map pk3 for %s: %v", mapName, err)
+			continue
+		}
+		if _, err := w.manifest.RecordMapPak(game, mapName, w.opts.OutputDir, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPosition returns pk3Path's index in a game's load order, or -1 if the
+// game doesn't load it directly (it comes from a game layered underneath).
+func loadPosition(order []string, pk3Path string) int {
+	for i, p := range order {
+		if p == pk3Path {
+			return i
+		}
+	}
+	return -1
+}
+
+// pollDemos indexes settled demos whose sidecar is missing or stale.
+func (w *watcher) pollDemos() {
+	matches, err := filepath.Glob(filepath.Join(w.opts.DemoDir, "*.tvd"))
+	if err != nil {
+		return
+	}
+	current := make(map[string]fileStamp, len(matches))
+	for _, path := range matches {
+		stamp, ok := statStamp(path)
+		if !ok {
+			continue
+		}
+		current[path] = stamp
+		if w.demos[path] == stamp || w.lastDemos[path] != stamp {
+			continue // already handled, or still being recorded
+		}
+		if sidecar, ok := statStamp(SidecarPath(path)); ok && !sidecar.modTime.Before(stamp.modTime) {
+			w.demos[path] = stamp
+			continue
+		}
+		w.indexDemo(path)
+		w.demos[path], _ = statStamp(path)
+	}
+	w.lastDemos = current
+}
+
+// indexDemo adds a trailer to a demo that lacks one and writes its sidecar.
+func (w *watcher) indexDemo(path string) {
+	if _, err := ReadDemoTrailer(path); errors.Is(err, ErrNoDemoTrailer) {
+		if _, err := RepairDemoTrailer(path, ""); err != nil {
+			log.Printf("Warning: %s: trailer: %v", path, err)
+		}
+	}
+	sidecar, err := GenerateDemoSidecar(path, w.manifest)
+	if err != nil {
+		log.Printf("Warning: %s: sidecar: %v", path, err)
+		return
+	}
+	log.Printf("Watch: indexed %s", filepath.Base(path))
+
+	// Demos can be recorded on maps added since the last build, or not in
+	// the install at all
+	changed, _, err := EnsureDemoMapPak(w.manifest, sidecar.Game, sidecar.Map, filepath.Base(path), w.opts.Quake3Dir, w.opts.OutputDir, w.opts.Build.MapPak)
+	if err != nil {
+		log.Printf("Warning: %s: map pk3 for %s: %v", path, sidecar.Map, err)
+	}
+	if changed {
+		if err := w.manifest.Save(w.manifestPath()); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	if err == nil && len(w.opts.MapRepositories) > 0 {
+		w.fetchMissingMap(sidecar.Game, sidecar.Map, filepath.Base(path))
+	}
+}
+
+// fetchMissingMap fetches a map a demo needs that the install lacks from
+// the map repositories into the game's directory and indexes it. Each map
+// is tried once per run.
+func (w *watcher) fetchMissingMap(fsGame, mapName, demo string) {
+	game, _, _ := w.manifest.GameFor(fsGame)
+	key := game + "/" + strings.ToLower(mapName)
+	if _, missing := w.manifest.MissingMaps[key]; !missing || w.fetched[key] {
+		return
+	}
+	if w.fetched == nil {
+		w.fetched = make(map[string]bool)
+	}
+	w.fetched[key] = true
+
+	pk3Path, source, err := FetchMap(mapName, w.opts.MapRepositories, filepath.Join(w.opts.Quake3Dir, game), w.opts.Download)
+	if err != nil {
+		log.Printf("Warning: %s: %v", demo, err)
+		return
+	}
+	if err := w.installFetched(game, mapName, pk3Path, &Provenance{Trigger: "demo", Demo: demo, Source: source}); err != nil {
+		log.Printf("Warning: %s: %v", pk3Path, err)
+	}
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,106 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestWatcher builds q into out and returns a watcher over them, as
+// Watch starts one.
+func newTestWatcher(t *testing.T, q, out string) *watcher {
+	t.Helper()
+	if _, err := BuildBaseline(q, out, BuildOptions{}); err != nil {
+		t.Fatalf("BuildBaseline: %v", err)
+	}
+	w := &watcher{
+		opts:      WatchOptions{Quake3Dir: q, OutputDir: out},
+		pk3s:      make(map[string]fileStamp),
+		lastPk3s:  make(map[string]fileStamp),
+		demos:     make(map[string]fileStamp),
+		lastDemos: make(map[string]fileStamp),
+	}
+	if err := w.init(); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	return w
+}
+
+// touch gives a file a modification time of its own.
+func touch(t *testing.T, path string, when time.Time) {
+	t.Helper()
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchIndexesAddedPk3(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	w := newTestWatcher(t, q, out)
+
+	writeFixturePk3(t, filepath.Join(q, "baseq3", "map-new.pk3"), map[string][]byte{
+		"maps/newmap.bsp":       makeBSP([]string{"textures/new/wall"}, []fixtureEntity{{{"classname", "worldspawn"}}}),
+		"textures/new/wall.tga": fixtureImage("wall"),
+	})
+
+	// A pk3 is only picked up once it's settled across two polls
+	w.poll()
+	if _, ok := w.manifest.Games["baseq3"].FileIndex["maps/newmap.bsp"]; ok {
+		t.Fatal("pk3 indexed on the poll that first saw it")
+	}
+	w.poll()
+
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manifest.Games["baseq3"].FileIndex["maps/newmap.bsp"]; !ok {
+		t.Error("saved manifest lacks the new map")
+	}
+	if _, ok := manifest.Artifacts["maps/newmap.pk3"]; !ok {
+		t.Error("saved manifest lacks the new map pk3")
+	}
+	if got := pk3Listing(t, filepath.Join(out, "maps", "newmap.pk3")); !containsString(got, "textures/new/wall.tga") {
+		t.Errorf("newmap.pk3 = %v", got)
+	}
+}
+
+func TestWatchFailedRebuildNotRetried(t *testing.T) {
+	q := makeQuake3Fixture(t)
+	out := t.TempDir()
+	w := newTestWatcher(t, q, out)
+
+	// A changed pk3 triggers a full rebuild, which can't write the baseline
+	custom := filepath.Join(q, "baseq3", "map-custom.pk3")
+	writeFixturePk3(t, custom, map[string][]byte{"textures/custom/floor.tga": fixtureImage("floor2")})
+	touch(t, custom, time.Now().Add(time.Hour))
+	baseline := filepath.Join(out, "baseq3.pk3")
+	if err := os.Remove(baseline); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(baseline, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w.poll()
+	w.poll()
+	if stamp, _ := statStamp(custom); w.pk3s[custom] != stamp {
+		t.Fatal("failed rebuild didn't record the pk3s")
+	}
+
+	// The next poll leaves it alone until the install changes again
+	if err := os.Remove(baseline); err != nil {
+		t.Fatal(err)
+	}
+	w.poll()
+	if _, err := os.Stat(baseline); err == nil {
+		t.Fatal("failed rebuild was rerun without a change")
+	}
+	touch(t, custom, time.Now().Add(2*time.Hour))
+	w.poll()
+	w.poll()
+	if info, err := os.Stat(baseline); err != nil || info.IsDir() {
+		t.Errorf("rebuild after a change didn't write %s: %v", baseline, err)
+	}
+}
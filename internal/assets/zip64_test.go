@@ -0,0 +1,117 @@
+package assets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPk3WriterZip64Guard(t *testing.T) {
+	dir := t.TempDir()
+	large := filepath.Join(dir, "large.pk3")
+	writeFixturePk3(t, large, map[string][]byte{"a.txt": {1}, "b.txt": {2}, "c.txt": {3}, "d.txt": {4}})
+	defer func(n int) { pk3MaxEntries = n }(pk3MaxEntries)
+	pk3MaxEntries = 3
+
+	var buf bytes.Buffer
+	pw := NewPk3Writer(&buf)
+	for i := range 3 {
+		if err := pw.AddEntry(fmt.Sprintf("f%d.txt", i), bytes.NewReader([]byte("x"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pw.Fits("f3.txt", 1) {
+		t.Error("Fits = true past the entry limit")
+	}
+	if err := pw.AddEntry("f3.txt", bytes.NewReader([]byte("x"))); !errors.Is(err, ErrZip64) {
+		t.Errorf("AddEntry past the entry limit = %v, want ErrZip64", err)
+	}
+
+	// Repacking and renaming entries go through the same guard
+	if _, err := RepackPk3(large, filepath.Join(dir, "repacked.pk3"), RepackOptions{}); !errors.Is(err, ErrZip64) {
+		t.Errorf("RepackPk3 past the entry limit = %v, want ErrZip64", err)
+	}
+	if err := RenamePk3Entries(large, filepath.Join(dir, "renamed.pk3"), map[string]string{"a.txt": "A.txt"}); !errors.Is(err, ErrZip64) {
+		t.Errorf("RenamePk3Entries past the entry limit = %v, want ErrZip64", err)
+	}
+
+	src := filepath.Join(dir, "src")
+	for i := range 7 {
+		path := filepath.Join(src, "textures", fmt.Sprintf("t%d.tga", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "hd.pk3")
+	if _, err := BuildPk3FromDir(src, out, Pk3DirOptions{}); !errors.Is(err, ErrZip64) {
+		t.Errorf("BuildPk3FromDir without Split = %v, want ErrZip64", err)
+	}
+	n, err := BuildPk3FromDir(src, out, Pk3DirOptions{Split: true})
+	if err != nil {
+		t.Fatalf("BuildPk3FromDir with Split: %v", err)
+	}
+	if n != 7 {
+		t.Errorf("BuildPk3FromDir wrote %d entries, want 7", n)
+	}
+	total := 0
+	for _, part := range []string{"hd.pk3", "hd_2.pk3", "hd_3.pk3"} {
+		r, err := openPk3(filepath.Join(dir, part))
+		if err != nil {
+			t.Fatalf("open %s: %v", part, err)
+		}
+		total += len(r.File)
+		r.Close()
+	}
+	if total != 7 {
+		t.Errorf("split pk3s hold %d entries, want 7", total)
+	}
+
+	if _, err := BuildPk3FromDir(src, out, Pk3DirOptions{Zip64: true}); err != nil {
+		t.Fatalf("BuildPk3FromDir with Zip64: %v", err)
+	}
+	r, err := openPk3(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.File) != 7 {
+		t.Errorf("zip64 pk3 holds %d entries, want 7", len(r.File))
+	}
+}
+
+func TestZipViewZip64(t *testing.T) {
+	// More entries than a plain zip's end record can count
+	const count = 0xFFFF + 10
+	entries := make([]zipViewEntry, count)
+	for i := range entries {
+		entries[i] = zipViewEntry{name: fmt.Sprintf("f%05d", i), offset: int64(i % 4), length: 1}
+	}
+	archive, err := openZipView(bytes.NewReader([]byte("abcd")), nil, entries)
+	if err != nil {
+		t.Fatalf("openZipView: %v", err)
+	}
+	defer archive.Close()
+	if len(archive.File) != count {
+		t.Fatalf("view has %d entries, want %d", len(archive.File), count)
+	}
+	last := archive.File[count-1]
+	rc, err := last.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := string("abcd"[(count-1)%4]); last.Name != fmt.Sprintf("f%05d", count-1) || string(data) != want {
+		t.Errorf("last entry = %s %q, want %q", last.Name, data, want)
+	}
+}
@@ -0,0 +1,248 @@
+package assets
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	zipLocalHeaderSize   = 30
+	zipCentralHeaderSize = 46
+	zipEndSize           = 22
+	zip64EndSize         = 56
+	zip64LocatorSize     = 20
+	zip64ExtraID         = 0x0001
+)
+
+// zipView is a virtual zip file: generated headers interleaved with byte
+// ranges of other files, presented as stored (uncompressed) entries. It lets
+// sources that aren't pk3s (.pak archives, loose directories) be read through
+// zip.Reader like any pk3, without copying their data. Sources too large for
+// a plain zip get zip64 records, which zip.Reader reads even though the
+// engine wouldn't.
+type zipView struct {
+	src      io.ReaderAt // backs entries without a path
+	segments []zipViewSegment
+	size     int64
+
+	mu      sync.Mutex
+	curPath string // most recently opened entry file, kept open for sequential reads
+	cur     *os.File
+}
+
+// zipViewEntry is a file in a zipView. Its data is length bytes at offset in
+// the file at path, or in the view's src if path is empty.
+type zipViewEntry struct {
+	name   string
+	path   string
+	offset int64
+	length int64
+}
+
+type zipViewSegment struct {
+	start  int64  // offset in the view
+	data   []byte // generated bytes, or nil for an entry's data
+	path   string
+	offset int64
+	length int64
+}
+
+// openZipView builds a zip.Reader over entries. closer is closed with the archive.
+func openZipView(src io.ReaderAt, closer io.Closer, entries []zipViewEntry) (*pk3Archive, error) {
+	v, err := newZipView(src, entries)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(v, v.size)
+	if err != nil {
+		return nil, fmt.Errorf("zip view: %w", err)
+	}
+	return &pk3Archive{Reader: zr, Closer: &zipViewCloser{v, closer}}, nil
+}
+
+func newZipView(src io.ReaderAt, entries []zipViewEntry) (*zipView, error) {
+	v := &zipView{src: src}
+	add := func(seg zipViewSegment) {
+		seg.start = v.size
+		if seg.data != nil {
+			seg.length = int64(len(seg.data))
+		}
+		v.segments = append(v.segments, seg)
+		v.size += seg.length
+	}
+
+	// A CRC of zero tells the zip reader not to verify it
+	headerOffsets := make([]int64, len(entries))
+	for i, e := range entries {
+		headerOffsets[i] = v.size
+		// A local header's zip64 field carries both sizes, if either is too large
+		var extra []byte
+		if e.length >= 0xFFFFFFFF {
+			extra = zip64Extra(e.length, e.length)
+		}
+		h := make([]byte, zipLocalHeaderSize, zipLocalHeaderSize+len(e.name)+len(extra))
+		binary.LittleEndian.PutUint32(h[0:], 0x04034b50)
+		binary.LittleEndian.PutUint16(h[4:], zipVersion(extra)) // version needed
+		binary.LittleEndian.PutUint32(h[18:], zip32(e.length))
+		binary.LittleEndian.PutUint32(h[22:], zip32(e.length))
+		binary.LittleEndian.PutUint16(h[26:], uint16(len(e.name)))
+		binary.LittleEndian.PutUint16(h[28:], uint16(len(extra)))
+		add(zipViewSegment{data: append(append(h, e.name...), extra...)})
+		add(zipViewSegment{path: e.path, offset: e.offset, length: e.length})
+	}
+
+	dirStart := v.size
+	var dir []byte
+	for i, e := range entries {
+		// A central header's zip64 field carries only the values too large
+		var values []int64
+		if e.length >= 0xFFFFFFFF {
+			values = append(values, e.length, e.length)
+		}
+		if headerOffsets[i] >= 0xFFFFFFFF {
+			values = append(values, headerOffsets[i])
+		}
+		extra := zip64Extra(values...)
+		h := make([]byte, zipCentralHeaderSize)
+		binary.LittleEndian.PutUint32(h[0:], 0x02014b50)
+		binary.LittleEndian.PutUint16(h[4:], zipVersion(extra)) // version made by
+		binary.LittleEndian.PutUint16(h[6:], zipVersion(extra)) // version needed
+		binary.LittleEndian.PutUint32(h[20:], zip32(e.length))
+		binary.LittleEndian.PutUint32(h[24:], zip32(e.length))
+		binary.LittleEndian.PutUint16(h[28:], uint16(len(e.name)))
+		binary.LittleEndian.PutUint16(h[30:], uint16(len(extra)))
+		binary.LittleEndian.PutUint32(h[42:], zip32(headerOffsets[i]))
+		dir = append(append(append(dir, h...), e.name...), extra...)
+	}
+
+	count := int64(len(entries))
+	if count >= 0xFFFF || int64(len(dir)) >= 0xFFFFFFFF || dirStart >= 0xFFFFFFFF {
+		end64Start := dirStart + int64(len(dir))
+		end64 := make([]byte, zip64EndSize+zip64LocatorSize)
+		binary.LittleEndian.PutUint32(end64[0:], 0x06064b50)
+		binary.LittleEndian.PutUint64(end64[4:], zip64EndSize-12)
+		binary.LittleEndian.PutUint16(end64[12:], 45) // version made by
+		binary.LittleEndian.PutUint16(end64[14:], 45) // version needed
+		binary.LittleEndian.PutUint64(end64[24:], uint64(count))
+		binary.LittleEndian.PutUint64(end64[32:], uint64(count))
+		binary.LittleEndian.PutUint64(end64[40:], uint64(len(dir)))
+		binary.LittleEndian.PutUint64(end64[48:], uint64(dirStart))
+		locator := end64[zip64EndSize:]
+		binary.LittleEndian.PutUint32(locator[0:], 0x07064b50)
+		binary.LittleEndian.PutUint64(locator[8:], uint64(end64Start))
+		binary.LittleEndian.PutUint32(locator[16:], 1) // total disks
+		dir = append(dir, end64...)
+	}
+	end := make([]byte, zipEndSize)
+	binary.LittleEndian.PutUint32(end[0:], 0x06054b50)
+	binary.LittleEndian.PutUint16(end[8:], uint16(min(count, 0xFFFF)))
+	binary.LittleEndian.PutUint16(end[10:], uint16(min(count, 0xFFFF)))
+	binary.LittleEndian.PutUint32(end[12:], zip32(int64(len(dir))))
+	binary.LittleEndian.PutUint32(end[16:], zip32(dirStart))
+	add(zipViewSegment{data: append(dir, end...)})
+	return v, nil
+}
+
+// zip32 returns n for a 32-bit zip field, or 0xFFFFFFFF if it's too large
+// and goes in a zip64 record instead.
+func zip32(n int64) uint32 {
+	return uint32(min(n, 0xFFFFFFFF))
+}
+
+// zip64Extra builds a zip64 extended information extra field holding
+// values, or nil if there are none.
+func zip64Extra(values ...int64) []byte {
+	if len(values) == 0 {
+		return nil
+	}
+	extra := make([]byte, 4, 4+8*len(values))
+	binary.LittleEndian.PutUint16(extra[0:], zip64ExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], uint16(8*len(values)))
+	for _, v := range values {
+		extra = binary.LittleEndian.AppendUint64(extra, uint64(v))
+	}
+	return extra
+}
+
+// zipVersion is the version needed to read a header: 4.5 with zip64
+// fields, else 1.0.
+func zipVersion(extra []byte) uint16 {
+	if extra != nil {
+		return 45
+	}
+	return 10
+}
+
+// ReadAt implements io.ReaderAt
+func (v *zipView) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	n := 0
+	for n < len(p) && off < v.size {
+		i := sort.Search(len(v.segments), func(i int) bool {
+			return v.segments[i].start+v.segments[i].length > off
+		})
+		seg := v.segments[i]
+		rel := off - seg.start
+		want := min(int64(len(p)-n), seg.length-rel)
+		if seg.data != nil {
+			copy(p[n:], seg.data[rel:rel+want])
+		} else if err := v.readEntry(p[n:n+int(want)], seg.path, seg.offset+rel); err != nil {
+			return n, err
+		}
+		n += int(want)
+		off += want
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (v *zipView) readEntry(p []byte, path string, off int64) error {
+	if path == "" {
+		_, err := v.src.ReadAt(p, off)
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.curPath != path {
+		if v.cur != nil {
+			v.cur.Close()
+			v.cur = nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		v.cur, v.curPath = f, path
+	}
+	_, err := v.cur.ReadAt(p, off)
+	return err
+}
+
+type zipViewCloser struct {
+	view   *zipView
+	closer io.Closer
+}
+
+func (c *zipViewCloser) Close() error {
+	c.view.mu.Lock()
+	if c.view.cur != nil {
+		c.view.cur.Close()
+		c.view.cur = nil
+		c.view.curPath = ""
+	}
+	c.view.mu.Unlock()
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
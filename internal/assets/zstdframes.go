@@ -0,0 +1,124 @@
+package assets
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstd block header fields (RFC 8878 3.1.1.2)
+const (
+	zstdBlockHeaderSize = 3
+	zstdBlockRLE        = 1 // block type whose content is one repeated byte
+	zstdChecksumSize    = 4
+)
+
+// zstdFrameBounds walks the zstd frames in r between start and end without
+// decompressing them, and returns the offset each starts at followed by the
+// offset the last one ends at, or nil if there are none. The walk stops at
+// anything that isn't a zstd frame, such as a demo trailer; a frame cut
+// short, as in a demo still being recorded, ends at end.
+func zstdFrameBounds(r io.ReaderAt, start, end int64) ([]int64, error) {
+	var bounds []int64
+	buf := make([]byte, zstd.HeaderMaxSize)
+	pos := start
+	for pos < end {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), end-pos)], pos)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		var h zstd.Header
+		if h.Decode(buf[:n]) != nil {
+			break
+		}
+		bounds = append(bounds, pos)
+		if h.Skippable {
+			pos += int64(h.HeaderSize) + int64(h.SkippableSize)
+			continue
+		}
+
+		pos += int64(h.HeaderSize)
+		for last := false; !last; {
+			var header [zstdBlockHeaderSize]byte
+			if pos+zstdBlockHeaderSize > end {
+				pos = end
+				break
+			}
+			if _, err := r.ReadAt(header[:], pos); err != nil {
+				return nil, err
+			}
+			v := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+			last = v&1 != 0
+			size := int64(v >> 3)
+			if (v>>1)&3 == zstdBlockRLE {
+				size = 1
+			}
+			pos += zstdBlockHeaderSize + size
+		}
+		if h.HasCheckSum {
+			pos += zstdChecksumSize
+		}
+	}
+	if len(bounds) == 0 {
+		return nil, nil
+	}
+	return append(bounds, min(pos, end)), nil
+}
+
+// zstdFrameReader decompresses the zstd frames between bounds (as returned
+// by zstdFrameBounds) one at a time, so the decompressed offset each one
+// starts at is known.
+type zstdFrameReader struct {
+	r       io.ReaderAt
+	bounds  []int64
+	decoder *pooledDecoder
+	opened  int     // frames opened so far
+	offset  int64   // decompressed bytes read
+	bases   []int64 // decompressed offset of each opened frame
+}
+
+func newZstdFrameReader(r io.ReaderAt, bounds []int64) (*zstdFrameReader, error) {
+	decoder, err := getDemoDecoder(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdFrameReader{r: r, bounds: bounds, decoder: decoder}, nil
+}
+
+func (z *zstdFrameReader) Read(p []byte) (int, error) {
+	for {
+		if z.opened > 0 {
+			n, err := z.decoder.Read(p)
+			z.offset += int64(n)
+			if err != io.EOF || n > 0 {
+				return n, err
+			}
+		}
+		if z.opened >= len(z.bounds)-1 {
+			return 0, io.EOF
+		}
+		start, end := z.bounds[z.opened], z.bounds[z.opened+1]
+		if err := z.decoder.Reset(io.NewSectionReader(z.r, start, end-start)); err != nil {
+			return 0, err
+		}
+		z.bases = append(z.bases, z.offset)
+		z.opened++
+	}
+}
+
+// frameAt returns the compressed offset and decompressed base of the last
+// opened frame starting at or before a decompressed offset.
+func (z *zstdFrameReader) frameAt(offset int64) (int64, int64) {
+	i := len(z.bases) - 1
+	for i > 0 && z.bases[i] > offset {
+		i--
+	}
+	if i < 0 {
+		return 0, 0
+	}
+	return z.bounds[i], z.bases[i]
+}
+
+func (z *zstdFrameReader) close() {
+	putDemoDecoder(z.decoder)
+}
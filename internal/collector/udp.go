@@ -13,11 +13,18 @@ import (
 const (
 	q3Header    = "\xff\xff\xff\xff"
 	getStatus   = q3Header + "getstatus\n"
+	getInfo     = q3Header + "getinfo "
+	getServers  = q3Header + "getservers "
 	rconPrefix  = q3Header + "rcon "
 	printPrefix = q3Header + "print\n"
 	timeout     = 2 * time.Second
 	rconTimeout = 3 * time.Second
 	maxResponse = 65535
+
+	// Master servers answer getservers with as many packets as the list
+	// needs, then stop; the list is complete once none arrives for this long
+	masterTimeout     = 3 * time.Second
+	masterIdleTimeout = 500 * time.Millisecond
 )
 
 // Q3Client queries Quake 3 servers via UDP
@@ -53,6 +60,75 @@ func (c *Q3Client) QueryStatus(address string) (*domain.ServerStatus, error) {
 	return parseStatusResponse(address, buf[:n])
 }
 
+// QueryInfo queries a Q3 server with getinfo, the lighter query clients use
+// for server browsers, and returns its info vars (mapname, game for the
+// server's fs_game, clients, and so on) with lowered keys.
+func (c *Q3Client) QueryInfo(address string) (map[string]string, error) {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	challenge := strconv.FormatInt(time.Now().UnixNano()&0x7fffffff, 10)
+	if _, err := conn.Write([]byte(getInfo + challenge + "\n")); err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	buf := make([]byte, maxResponse)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	return parseInfoResponse(buf[:n], challenge)
+}
+
+// QueryMaster asks a master server for the servers it lists for a protocol
+// (68 for Quake 3 1.32), returning their addresses as host:port.
+func (c *Q3Client) QueryMaster(master string, protocol int) ([]string, error) {
+	conn, err := net.DialTimeout("udp", master, masterTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", master, err)
+	}
+	defer conn.Close()
+
+	request := fmt.Sprintf("%s%d full empty", getServers, protocol)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	var servers []string
+	seen := make(map[string]bool)
+	buf := make([]byte, maxResponse)
+	conn.SetReadDeadline(time.Now().Add(masterTimeout))
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && len(servers) > 0 {
+				break // No more packets
+			}
+			return nil, fmt.Errorf("reading response: %w", err)
+		}
+		addrs, done, err := parseServersResponse(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if !seen[addr] {
+				seen[addr] = true
+				servers = append(servers, addr)
+			}
+		}
+		if done {
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(masterIdleTimeout))
+	}
+	return servers, nil
+}
+
 // RconCommand sends an RCON command to a Q3 server and returns the response
 func (c *Q3Client) RconCommand(address, password, command string) (string, error) {
 	conn, err := net.DialTimeout("udp", address, rconTimeout)
@@ -195,6 +271,53 @@ func parseStatusResponse(address string, data []byte) (*domain.ServerStatus, err
 	return status, nil
 }
 
+// parseInfoResponse parses a getinfo reply, checking it answers our challenge
+func parseInfoResponse(data []byte, challenge string) (map[string]string, error) {
+	response := string(data)
+
+	// Response format: \xff\xff\xff\xffinfoResponse\n\key\value...
+	if !strings.HasPrefix(response, q3Header+"infoResponse\n") {
+		return nil, fmt.Errorf("invalid response prefix")
+	}
+	response = strings.TrimPrefix(response, q3Header+"infoResponse\n")
+
+	vars := parseVars(strings.TrimRight(response, "\n"))
+	if vars["challenge"] != challenge {
+		return nil, fmt.Errorf("response challenge mismatch")
+	}
+	delete(vars, "challenge")
+	return vars, nil
+}
+
+// parseServersResponse parses one getserversResponse packet into server
+// addresses, reporting whether it ends the list
+// Format: \xff\xff\xff\xffgetserversResponse\<4 ip bytes><2 port bytes>\...\EOT\0\0\0
+func parseServersResponse(data []byte) ([]string, bool, error) {
+	prefix := q3Header + "getserversResponse"
+	if !strings.HasPrefix(string(data), prefix) {
+		return nil, false, fmt.Errorf("invalid response prefix")
+	}
+	data = data[len(prefix):]
+
+	var servers []string
+	for len(data) > 0 && data[0] == '\\' {
+		data = data[1:]
+		if len(data) >= 3 && string(data[:3]) == "EOT" {
+			return servers, true, nil
+		}
+		if len(data) < 6 {
+			break
+		}
+		ip := net.IPv4(data[0], data[1], data[2], data[3])
+		port := int(data[4])<<8 | int(data[5])
+		if port != 0 && !ip.IsUnspecified() {
+			servers = append(servers, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		}
+		data = data[6:]
+	}
+	return servers, false, nil
+}
+
 // parseVars parses backslash-separated key/value pairs
 // Format: \key1\value1\key2\value2...
 func parseVars(line string) map[string]string {
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -13,9 +14,31 @@ type Config struct {
 	Server    ServerConfig   `yaml:"server"`
 	Database  DatabaseConfig `yaml:"database"`
 	Auth      AuthConfig     `yaml:"auth"`
+	Assets    AssetsConfig   `yaml:"assets,omitempty"`
 	Q3Servers []Q3Server     `yaml:"q3_servers"`
 }
 
+// AssetsConfig holds shared defaults for the asset and demo commands
+type AssetsConfig struct {
+	OutputDir        string            `yaml:"output_dir,omitempty"`        // demobake output (default: {static_dir}/demopk3s)
+	DemoDir          string            `yaml:"demo_dir,omitempty"`          // recorded demos (default: {static_dir}/demos)
+	Policy           string            `yaml:"policy,omitempty"`            // baseline policy file
+	Substitute       string            `yaml:"substitute,omitempty"`        // substitution table for official id files
+	TextureOverrides string            `yaml:"texture_overrides,omitempty"` // texture reference → file to use, for mods shipping both a .tga and a .jpg
+	LooseFiles       bool              `yaml:"loose_files,omitempty"`       // index loose files in game directories (dev installs)
+	AtomicBuilds     bool              `yaml:"atomic_builds,omitempty"`     // build into a staging dir and swap it in on success
+	Roots            []string          `yaml:"roots,omitempty"`             // further installs merged under quake3_dir, highest priority first
+	Placeholders     bool              `yaml:"placeholders,omitempty"`      // put placeholder images for missing textures in map pk3s
+	Profile          string            `yaml:"profile,omitempty"`           // build profile: web, lan, archive, or one from Profiles
+	Profiles         string            `yaml:"profiles,omitempty"`          // file of custom build profiles
+	GameBases        map[string]string `yaml:"game_bases,omitempty"`        // mod → game it's layered over (default baseq3)
+	IntakeTemplate   string            `yaml:"intake_template,omitempty"`   // names for demos servers send the asset service
+	DemoDictionary   string            `yaml:"demo_dictionary,omitempty"`   // zstd dictionary archived demos were recompressed with
+	MapRepositories  []string          `yaml:"map_repositories,omitempty"`  // URL templates to fetch missing maps from, e.g. https://ws.q3df.org/maps/downloads/{map}.pk3
+	SigningKey       string            `yaml:"signing_key,omitempty"`       // private key file demobake signs builds with (see manifest keygen)
+	VerifyKey        string            `yaml:"verify_key,omitempty"`        // public key, or a file holding it, manifests must be signed by to be synced or served
+}
+
 // AuthConfig holds authentication settings
 type AuthConfig struct {
 	JWTSecret     string        `yaml:"jwt_secret"`
@@ -24,13 +47,14 @@ type AuthConfig struct {
 
 // ServerConfig holds HTTP server settings
 type ServerConfig struct {
-	ListenAddr   string        `yaml:"listen_addr"`
-	HTTPPort     int           `yaml:"http_port"`
-	PollInterval time.Duration `yaml:"poll_interval"`
-	StaticDir    string        `yaml:"static_dir"`
-	Quake3Dir    string        `yaml:"quake3_dir"`
-	ServiceUser  string        `yaml:"service_user,omitempty"`
-	UseSystemd   *bool         `yaml:"use_systemd,omitempty"`
+	ListenAddr          string        `yaml:"listen_addr"`
+	HTTPPort            int           `yaml:"http_port"`
+	PollInterval        time.Duration `yaml:"poll_interval"`
+	StaticDir           string        `yaml:"static_dir"`
+	Quake3Dir           string        `yaml:"quake3_dir"`
+	ServiceUser         string        `yaml:"service_user,omitempty"`
+	UseSystemd          *bool         `yaml:"use_systemd,omitempty"`
+	RedistributableOnly bool          `yaml:"redistributable_only,omitempty"`
 }
 
 // DatabaseConfig holds SQLite settings
@@ -84,6 +108,30 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// AssetOutputDir returns the demobake output directory, or "" if neither
+// assets.output_dir nor static_dir is set
+func (c *Config) AssetOutputDir() string {
+	if c.Assets.OutputDir != "" {
+		return c.Assets.OutputDir
+	}
+	if c.Server.StaticDir != "" {
+		return filepath.Join(c.Server.StaticDir, "demopk3s")
+	}
+	return ""
+}
+
+// AssetDemoDir returns the demo directory, or "" if neither assets.demo_dir
+// nor static_dir is set
+func (c *Config) AssetDemoDir() string {
+	if c.Assets.DemoDir != "" {
+		return c.Assets.DemoDir
+	}
+	if c.Server.StaticDir != "" {
+		return filepath.Join(c.Server.StaticDir, "demos")
+	}
+	return ""
+}
+
 // Save writes the configuration to a YAML file, backing up the original first
 func Save(path string, cfg *Config) error {
 	// Back up existing file
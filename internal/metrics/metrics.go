@@ -0,0 +1,282 @@
+// Package metrics keeps process-wide counters and histograms and serves them
+// in the Prometheus text exposition format, for scraping the long-running
+// services (serve, serve-assets, watch).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are histogram bucket bounds in seconds, from a quick map
+// pk3 rebuild to a full baseline build of a large install.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// metric is anything the registry can write.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]metric)
+)
+
+// register adds m to the registry; names must be unique.
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[m.name()]; ok {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	registry[m.name()] = m
+}
+
+// WriteText writes every registered metric in the Prometheus text format,
+// sorted by name.
+func WriteText(w io.Writer) error {
+	registryMu.Lock()
+	metrics := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	registryMu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Handler serves the registered metrics for a Prometheus scrape.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	n atomic.Uint64
+}
+
+// Inc adds one.
+func (c *Counter) Inc() { c.n.Add(1) }
+
+// Add adds n.
+func (c *Counter) Add(n uint64) { c.n.Add(n) }
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 { return c.n.Load() }
+
+// CounterVec is a family of counters told apart by label values.
+type CounterVec struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu       sync.Mutex
+	counters map[string]*Counter // joined label values → counter
+}
+
+// NewCounter registers a counter without labels.
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+// NewCounterVec registers a counter family with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{metricName: name, help: help, labels: labels, counters: make(map[string]*Counter)}
+	register(v)
+	return v
+}
+
+// With returns the counter for a set of label values, one per label name,
+// creating it at zero the first time.
+func (v *CounterVec) With(values ...string) *Counter {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.metricName, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[key]
+	if !ok {
+		c = &Counter{}
+		v.counters[key] = c
+	}
+	return c
+}
+
+func (v *CounterVec) name() string { return v.metricName }
+
+func (v *CounterVec) write(w io.Writer) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.counters))
+	for key := range v.counters {
+		keys = append(keys, key)
+	}
+	v.mu.Unlock()
+	sort.Strings(keys)
+
+	writeHeader(w, v.metricName, v.help, "counter")
+	for _, key := range keys {
+		v.mu.Lock()
+		c := v.counters[key]
+		v.mu.Unlock()
+		var values []string
+		if len(v.labels) > 0 {
+			values = strings.Split(key, "\xff")
+		}
+		fmt.Fprintf(w, "%s%s %d\n", v.metricName, formatLabels(v.labels, values), c.Value())
+	}
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64 // upper bounds, ascending
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, or
+// DefaultBuckets if there are none.
+func NewHistogram(name, help string, buckets ...float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{metricName: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+	register(h)
+	return h
+}
+
+// Observe records a value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v) // first bound >= v
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) name() string { return h.metricName }
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	writeHeader(w, h.metricName, h.help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.metricName, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.metricName, count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.metricName, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.metricName, count)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders label pairs as {a="x",b="y"}, or "" without labels.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escape.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CountBytes wraps a handler, adding the bytes of every response body it
+// writes to c.
+func CountBytes(c *Counter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.ServeHTTP(&countingWriter{ResponseWriter: w, c: c}, req)
+	})
+}
+
+// countingWriter counts the bytes written through a ResponseWriter.
+type countingWriter struct {
+	http.ResponseWriter
+	c *Counter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.c.Add(uint64(n))
+	return n, err
+}
+
+// ReadFrom lets http.ServeContent and io.Copy reach the underlying writer's
+// ReadFrom, so files are still sent with sendfile.
+func (w *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.c.Add(uint64(n))
+	return n, err
+}
+
+// Flush passes flushes through for streamed responses.
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes hijacking through for websocket upgrades; bytes sent over a
+// hijacked connection aren't counted.
+func (w *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("metrics: response writer can't be hijacked")
+	}
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
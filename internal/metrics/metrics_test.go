@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	lookups := NewCounterVec("test_lookups_total", "Lookups.", "cache", "result")
+	lookups.With("block", "hit").Add(3)
+	lookups.With("block", "miss").Inc()
+	seconds := NewHistogram("test_seconds", "Durations.", 1, 0.1)
+	seconds.Observe(0.05)
+	seconds.Observe(0.5)
+	seconds.Observe(5)
+
+	var b strings.Builder
+	if err := WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_lookups_total Lookups.
+# TYPE test_lookups_total counter
+test_lookups_total{cache="block",result="hit"} 3
+test_lookups_total{cache="block",result="miss"} 1
+# HELP test_seconds Durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 5.55
+test_seconds_count 3
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestCountBytesReadFrom(t *testing.T) {
+	var c Counter
+	body := strings.Repeat("x", 4096)
+	h := CountBytes(&c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Error("counting writer hides io.ReaderFrom")
+		}
+		io.Copy(w, strings.NewReader(body))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != body {
+		t.Errorf("body is %d bytes, want %d", rec.Body.Len(), len(body))
+	}
+	if c.Value() != uint64(len(body)) {
+		t.Errorf("counted %d bytes, want %d", c.Value(), len(body))
+	}
+}